@@ -151,17 +151,17 @@ func (lh ListHandler) LinkHandler(apiContext *types.APIContext, next types.Reque
 
 	_, targetSysCatalogImages := image.ParseCatalogImageListConfigMap(catalogImageList)
 
-	var targetRkeSysImages []string
+	var targetRkeSysImages image.ImageList
 	exportConfig := image.ExportConfig{OsType: image.Linux}
 	switch apiContext.ID {
 	case linuxImages:
-		targetRkeSysImages, _, err = image.GetImages(exportConfig, nil, []string{}, rkeSysImages)
+		targetRkeSysImages, err = image.GetImages(exportConfig, nil, []string{}, rkeSysImages)
 		if err != nil {
 			return httperror.WrapAPIError(err, httperror.ServerError, "error getting image list for linux platform")
 		}
 	case windowsImages:
 		exportConfig.OsType = image.Windows
-		targetRkeSysImages, _, err = image.GetImages(exportConfig, nil, []string{}, rkeSysImages)
+		targetRkeSysImages, err = image.GetImages(exportConfig, nil, []string{}, rkeSysImages)
 		if err != nil {
 			return httperror.WrapAPIError(err, httperror.ServerError, "error getting image list for windows platform")
 		}
@@ -170,7 +170,7 @@ func (lh ListHandler) LinkHandler(apiContext *types.APIContext, next types.Reque
 	var targetImages []string
 	agentImage := settings.AgentImage.Get()
 	targetImages = append(targetImages, img.Mirror(agentImage))
-	targetImages = append(targetImages, targetRkeSysImages...)
+	targetImages = append(targetImages, targetRkeSysImages.Images()...)
 	targetImages = append(targetImages, targetSysCatalogImages...)
 
 	b := []byte(strings.Join(targetImages, "\n"))
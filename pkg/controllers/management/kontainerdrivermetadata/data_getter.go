@@ -105,10 +105,36 @@ func GetK8sVersionInfo(
 	rancherVersions map[string]rketypes.K8sVersionInfo,
 ) (linuxInfo, windowsInfo *VersionInfo) {
 
-	linuxInfo = newVersionInfo()
-	windowsInfo = newVersionInfo()
-
 	maxVersionForMajorK8sVersion := map[string]string{}
+	for _, k8sVersion := range supportedK8sVersions(rancherVersion, rkeSysImages, rancherVersions) {
+		majorVersion := util.GetTagMajorVersion(k8sVersion)
+		if curr, ok := maxVersionForMajorK8sVersion[majorVersion]; !ok || mVersion.Compare(k8sVersion, curr, ">") {
+			maxVersionForMajorK8sVersion[majorVersion] = k8sVersion
+		}
+	}
+	var k8sVersions []string
+	for _, k8sVersion := range maxVersionForMajorK8sVersion {
+		k8sVersions = append(k8sVersions, k8sVersion)
+	}
+	return k8sVersionInfo(k8sVersions, rkeSysImages, linuxSvcOptions, windowsSvcOptions)
+}
+
+// GetAllK8sVersionInfo works like GetK8sVersionInfo, but keeps every patch version of each Kubernetes minor version
+// instead of the latest one only.
+func GetAllK8sVersionInfo(
+	rancherVersion string,
+	rkeSysImages map[string]rketypes.RKESystemImages,
+	linuxSvcOptions map[string]rketypes.KubernetesServicesOptions,
+	windowsSvcOptions map[string]rketypes.KubernetesServicesOptions,
+	rancherVersions map[string]rketypes.K8sVersionInfo,
+) (linuxInfo, windowsInfo *VersionInfo) {
+	k8sVersions := supportedK8sVersions(rancherVersion, rkeSysImages, rancherVersions)
+	return k8sVersionInfo(k8sVersions, rkeSysImages, linuxSvcOptions, windowsSvcOptions)
+}
+
+// supportedK8sVersions returns the Kubernetes versions of rkeSysImages that are not ignored for rancherVersion.
+func supportedK8sVersions(rancherVersion string, rkeSysImages map[string]rketypes.RKESystemImages, rancherVersions map[string]rketypes.K8sVersionInfo) []string {
+	var k8sVersions []string
 	for k8sVersion := range rkeSysImages {
 		if rancherVersionInfo, ok := rancherVersions[k8sVersion]; ok && toIgnoreForAllK8s(rancherVersionInfo, rancherVersion) {
 			continue
@@ -117,11 +143,24 @@ func GetK8sVersionInfo(
 		if majorVersionInfo, ok := rancherVersions[majorVersion]; ok && toIgnoreForK8sCurrent(majorVersionInfo, rancherVersion) {
 			continue
 		}
-		if curr, ok := maxVersionForMajorK8sVersion[majorVersion]; !ok || mVersion.Compare(k8sVersion, curr, ">") {
-			maxVersionForMajorK8sVersion[majorVersion] = k8sVersion
-		}
+		k8sVersions = append(k8sVersions, k8sVersion)
 	}
-	for majorVersion, k8sVersion := range maxVersionForMajorK8sVersion {
+	return k8sVersions
+}
+
+// k8sVersionInfo returns the system images and service options of k8sVersions for linux and windows.
+func k8sVersionInfo(
+	k8sVersions []string,
+	rkeSysImages map[string]rketypes.RKESystemImages,
+	linuxSvcOptions map[string]rketypes.KubernetesServicesOptions,
+	windowsSvcOptions map[string]rketypes.KubernetesServicesOptions,
+) (linuxInfo, windowsInfo *VersionInfo) {
+
+	linuxInfo = newVersionInfo()
+	windowsInfo = newVersionInfo()
+
+	for _, k8sVersion := range k8sVersions {
+		majorVersion := util.GetTagMajorVersion(k8sVersion)
 		sysImgs, exist := rkeSysImages[k8sVersion]
 		if !exist {
 			continue
@@ -7,6 +7,7 @@
 	"encoding/binary"
 	"encoding/hex"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -57,6 +58,10 @@ type Helm struct {
 	username    string
 	password    string
 	lastCommit  string
+	// LazyIndex makes LoadIndex leave the LocalFiles and Digest of the chart versions of repositories without
+	// index.yaml unset until ResolveLocalFiles is called for them, so that the files of the versions that are not
+	// used are never listed.
+	LazyIndex bool
 }
 
 func (h *Helm) lock() {
@@ -246,6 +251,9 @@ func (h *Helm) FetchLocalFiles(version *ChartVersion) ([]v32.File, error) {
 		return nil, err
 	}
 
+	if err := h.resolveLocalFiles(version); err != nil {
+		return nil, err
+	}
 	if len(version.LocalFiles) == 0 && len(version.URLs) == 0 {
 		return nil, errors.New("No files or urls provided for helm fetch")
 	}
@@ -395,6 +403,68 @@ func (h *Helm) loadFile(version *ChartVersion, filename string) (*v32.File, erro
 	}, nil
 }
 
+// indexSkippedDirs are the directories of chart repositories that hold no chart sources, e.g. the packaged charts of
+// assets and the icons of icons, which buildIndex does not descend into.
+var indexSkippedDirs = map[string]bool{
+	"assets": true,
+	"icons":  true,
+}
+
+// skipIndexDir returns whether buildIndex skips the directories called name while looking for charts: hidden
+// directories, e.g. .git, and indexSkippedDirs.
+func skipIndexDir(name string) bool {
+	return strings.HasPrefix(name, ".") || indexSkippedDirs[name]
+}
+
+// ResolveLocalFiles sets the LocalFiles and Digest of version, a chart version of an index loaded with LazyIndex.
+// Versions whose files are already resolved, or that are not local, are left untouched.
+func (h *Helm) ResolveLocalFiles(version *ChartVersion) error {
+	err := h.lockAndVerifyCachePath()
+	defer h.unlock()
+	if err != nil {
+		return err
+	}
+	return h.resolveLocalFiles(version)
+}
+
+// resolveLocalFiles sets the LocalFiles of version to the files of its directory, and its Digest to a digest of their
+// paths, sizes and modification times. LocalFiles is left non-nil once resolved, even if the directory holds no files.
+func (h *Helm) resolveLocalFiles(version *ChartVersion) error {
+	if version.Dir == "" || version.LocalFiles != nil {
+		return nil
+	}
+	localFiles := []string{}
+	digest := md5.New()
+	err := filepath.WalkDir(filepath.Join(h.LocalPath, version.Dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		localFiles = append(localFiles, path)
+		digest.Write([]byte(path))
+
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(info.Size()))
+		digest.Write(b)
+
+		binary.LittleEndian.PutUint64(b, uint64(info.ModTime().Second()))
+		digest.Write(b)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	version.LocalFiles = localFiles
+	version.Digest = hex.EncodeToString(digest.Sum(nil))
+	return nil
+}
+
+// buildIndex builds the index of a repository without index.yaml from the Chart.yaml files of its chart versions.
 func (h *Helm) buildIndex() (*RepoIndex, error) {
 	index := &RepoIndex{
 		IndexFile: &IndexFile{
@@ -402,11 +472,17 @@ func (h *Helm) buildIndex() (*RepoIndex, error) {
 		},
 	}
 
-	filepath.Walk(h.LocalPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
+	filepath.WalkDir(h.LocalPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
 			return err
 		}
-		if !strings.EqualFold(info.Name(), "Chart.yaml") {
+		if d.IsDir() {
+			if path != h.LocalPath && skipIndexDir(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(d.Name(), "Chart.yaml") {
 			return nil
 		}
 
@@ -425,29 +501,14 @@ func (h *Helm) buildIndex() (*RepoIndex, error) {
 			return err
 		}
 		version.Dir = relDir
-		digest := md5.New()
-
-		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-			if err != nil || info.IsDir() {
+		if !h.LazyIndex {
+			if err := h.resolveLocalFiles(version); err != nil {
 				return err
 			}
-
-			version.LocalFiles = append(version.LocalFiles, path)
-			digest.Write([]byte(path))
-
-			b := make([]byte, 8)
-			binary.LittleEndian.PutUint64(b, uint64(info.Size()))
-			digest.Write(b)
-
-			binary.LittleEndian.PutUint64(b, uint64(info.ModTime().Second()))
-			digest.Write(b)
-
-			return nil
-		})
-
-		version.Digest = hex.EncodeToString(digest.Sum(nil))
+		}
 		index.IndexFile.Entries[version.Name] = append(index.IndexFile.Entries[version.Name], version)
 
+		// The subdirectories of the chart version hold its files, and no other chart versions
 		return filepath.SkipDir
 	})
 
@@ -1,6 +1,8 @@
 package helm
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -46,3 +48,78 @@ func Test_craftExtension(t *testing.T) {
 		})
 	}
 }
+
+func Test_buildIndex(t *testing.T) {
+	localPath := t.TempDir()
+	files := map[string]string{
+		"charts/rancher-monitoring/v0.3.2/Chart.yaml":      "name: rancher-monitoring\nversion: 0.3.2\n",
+		"charts/rancher-monitoring/v0.3.2/values.yaml":     "image: rancher/prometheus\n",
+		"charts/rancher-monitoring/v0.3.2/assets/a.yaml":   "a: b\n",
+		".git/charts/rancher-monitoring/v0.1.0/Chart.yaml": "name: rancher-monitoring\nversion: 0.1.0\n",
+		"assets/rancher-monitoring/Chart.yaml":             "name: rancher-monitoring\nversion: 0.2.0\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(localPath, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	index, err := (&Helm{LocalPath: localPath}).buildIndex()
+	assert.NoError(t, err)
+	versions := index.IndexFile.Entries["rancher-monitoring"]
+	if assert.Len(t, versions, 1) {
+		assert.Equal(t, "0.3.2", versions[0].Version)
+		assert.Equal(t, filepath.Join("charts", "rancher-monitoring", "v0.3.2"), versions[0].Dir)
+		assert.Len(t, versions[0].LocalFiles, 3)
+	}
+}
+
+func Test_buildIndexLazy(t *testing.T) {
+	localPath := t.TempDir()
+	files := map[string]string{
+		"charts/rancher-monitoring/v0.3.2/Chart.yaml":  "name: rancher-monitoring\nversion: 0.3.2\n",
+		"charts/rancher-monitoring/v0.3.2/values.yaml": "image: rancher/prometheus\n",
+		"charts/rancher-monitoring/v0.3.1/Chart.yaml":  "name: rancher-monitoring\nversion: 0.3.1\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(localPath, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	eager := &Helm{LocalPath: localPath, IconPath: localPath}
+	eagerIndex, err := eager.LoadIndex()
+	assert.NoError(t, err)
+	lazy := &Helm{LocalPath: localPath, IconPath: localPath, LazyIndex: true}
+	index, err := lazy.LoadIndex()
+	assert.NoError(t, err)
+	versions := index.IndexFile.Entries["rancher-monitoring"]
+	if !assert.Len(t, versions, 2) {
+		return
+	}
+	for _, version := range versions {
+		assert.Nil(t, version.LocalFiles)
+		assert.Empty(t, version.Digest)
+	}
+
+	// Resolving the files of a version sets them as they are without LazyIndex, leaving the other versions untouched
+	assert.NoError(t, lazy.ResolveLocalFiles(versions[0]))
+	assert.Equal(t, eagerIndex.IndexFile.Entries["rancher-monitoring"][0], versions[0])
+	assert.Nil(t, versions[1].LocalFiles)
+	chartFiles, err := lazy.FetchLocalFiles(versions[1])
+	assert.NoError(t, err)
+	assert.Len(t, chartFiles, 1)
+	assert.Equal(t, eagerIndex.IndexFile.Entries["rancher-monitoring"][1], versions[1])
+}
+
+func Test_resolveLocalFilesEmptyDir(t *testing.T) {
+	localPath := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(localPath, "charts", "empty"), 0755))
+
+	// The files of a version are resolved once, even if there are none
+	version := &ChartVersion{Dir: filepath.Join("charts", "empty")}
+	assert.NoError(t, (&Helm{LocalPath: localPath}).resolveLocalFiles(version))
+	assert.NotNil(t, version.LocalFiles)
+	assert.Empty(t, version.LocalFiles)
+	assert.NotEmpty(t, version.Digest)
+}
@@ -21,6 +21,7 @@ type ChartVersion struct {
 
 type ChartMetadata struct {
 	Name        string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Home        string   `json:"home,omitempty" yaml:"home,omitempty"`
 	Sources     []string `json:"sources,omitempty" yaml:"sources,omitempty"`
 	Version     string   `json:"version,omitempty" yaml:"version,omitempty"`
 	KubeVersion string   `json:"kubeVersion,omitempty" yaml:"kubeVersion,omitempty"`
@@ -0,0 +1,63 @@
+package image
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/rancher/rke/types/kdm"
+	"github.com/sirupsen/logrus"
+)
+
+// addonTemplateImageRegexp matches the image of a container in an RKE addon template, e.g. image: rancher/pause:3.6.
+var addonTemplateImageRegexp = regexp.MustCompile(`(?m)^\s*-?\s*image:\s*["']?([^"'\s]+)["']?\s*$`)
+
+// RKEAddonImages returns the images embedded in the RKE addon templates of k8sVersion, e.g. the nginx ingress, metrics
+// server or DNS addons, given the K8sVersionedTemplates of the KDM data. Most templates reference the RKE system images
+// through template actions, e.g. image: {{.IngressImage}}, which are left out since they are provided by System: only
+// the images written in the templates themselves are returned, sorted.
+func RKEAddonImages(templates map[string]map[string]string, k8sVersion string) []string {
+	version, err := semver.Make(strings.TrimPrefix(k8sVersion, "v"))
+	if err != nil {
+		logrus.Debugf("[addons] skipping addon templates of invalid Kubernetes version %s: %v", k8sVersion, err)
+		return nil
+	}
+	found := make(map[string]struct{})
+	for addon, templateNames := range templates {
+		if addon == kdm.TemplateKeys {
+			continue
+		}
+		for versionRange, templateName := range templateNames {
+			inRange, err := semver.ParseRange(versionRange)
+			if err != nil {
+				logrus.Debugf("[addons] skipping %s template %s with invalid range %s: %v", addon, templateName, versionRange, err)
+				continue
+			}
+			if !inRange(version) {
+				continue
+			}
+			for _, image := range addonTemplateImages(templates[kdm.TemplateKeys][templateName]) {
+				found[image] = struct{}{}
+			}
+		}
+	}
+	images := make([]string, 0, len(found))
+	for image := range found {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// addonTemplateImages returns the images of the containers of an RKE addon template that are not template actions.
+func addonTemplateImages(template string) []string {
+	var images []string
+	for _, match := range addonTemplateImageRegexp.FindAllStringSubmatch(template, -1) {
+		if strings.Contains(match[1], "{{") {
+			continue
+		}
+		images = append(images, match[1])
+	}
+	return images
+}
@@ -0,0 +1,45 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/rancher/rke/types/kdm"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestRKEAddonImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	templates := map[string]map[string]string{
+		kdm.NginxIngress: {
+			">=1.25.0-rancher0 <1.26.0-rancher0": "nginxingress-v1.25",
+			">=1.26.0-rancher0":                  "nginxingress-v1.26",
+		},
+		kdm.MetricsServer: {
+			">=1.20.4-rancher1-1": "metricsserver-v1.20",
+		},
+		kdm.TemplateKeys: {
+			"nginxingress-v1.25": `
+      containers:
+      - name: controller
+        image: {{.IngressImage}}
+      - name: backend
+        image: "rancher/mirrored-nginx-ingress-controller-defaultbackend:1.5-rancher1"
+`,
+			"nginxingress-v1.26": `
+      containers:
+      - image: rancher/nginx-ingress-controller:nginx-1.9.4-rancher1
+        name: controller
+`,
+			"metricsserver-v1.20": `
+      containers:
+      - name: metrics-server
+        image: {{ .MetricsServerImage }}
+`,
+		},
+	}
+	assert.Equal([]string{"rancher/mirrored-nginx-ingress-controller-defaultbackend:1.5-rancher1"}, RKEAddonImages(templates, "v1.25.16-rancher2-3"))
+	assert.Equal([]string{"rancher/nginx-ingress-controller:nginx-1.9.4-rancher1"}, RKEAddonImages(templates, "v1.26.11-rancher2-1"))
+	assert.Empty(RKEAddonImages(templates, "v1.19.16-rancher2-1"))
+	assert.Empty(RKEAddonImages(templates, "invalid"))
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"strings"
+
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/pkg/errors"
+)
+
+// AllowedRegistriesPolicy is the policy name of the violations of AllowedRegistries.
+const AllowedRegistriesPolicy = "allowed-registries"
+
+// AllowedRegistries is a policy of the registries and namespaces the exported images may come from, e.g.
+// docker.io/rancher and registry.rancher.com, so that charts pulling in images from unexpected sources are caught before
+// they ship.
+type AllowedRegistries struct {
+	// Prefixes are the allowed registries, e.g. quay.io, and namespaces, e.g. docker.io/rancher, normalized with their
+	// registry.
+	Prefixes []string
+	// Action is whether the images from other sources fail the export or are only reported.
+	Action PolicyAction
+}
+
+// NewAllowedRegistries returns the policy allowing the images of registries, e.g. quay.io, or of namespaces of
+// registries, e.g. quay.io/jetstack. Namespaces without registry, e.g. rancher, are Docker Hub namespaces.
+func NewAllowedRegistries(registries []string, action PolicyAction) (*AllowedRegistries, error) {
+	if len(registries) == 0 {
+		return nil, errors.New("no allowed registry")
+	}
+	policy := &AllowedRegistries{Action: action}
+	for _, registry := range registries {
+		prefix := strings.Trim(strings.TrimSpace(registry), "/")
+		if prefix == "" {
+			return nil, errors.Errorf("invalid allowed registry %q", registry)
+		}
+		domain, _, _ := strings.Cut(prefix, "/")
+		if !isRegistryDomain(domain) {
+			prefix = dockerHubDomain + "/" + prefix
+		}
+		policy.Prefixes = append(policy.Prefixes, prefix)
+	}
+	return policy, nil
+}
+
+// isRegistryDomain returns true if the first component of an image reference is a registry rather than a namespace,
+// like reference.ParseNormalizedNamed does.
+func isRegistryDomain(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// Check returns the entries of list whose repository is in none of the allowed registries and namespaces.
+func (a *AllowedRegistries) Check(list ImageList) PolicyViolations {
+	if a == nil {
+		return nil
+	}
+	var violations PolicyViolations
+	for _, entry := range list {
+		violation := PolicyViolation{
+			Policy:  AllowedRegistriesPolicy,
+			Image:   entry.Image,
+			OS:      entry.OS,
+			Sources: entry.Sources,
+			Action:  a.Action,
+		}
+		named, err := reference.ParseNormalizedNamed(entry.Image)
+		if err != nil {
+			violation.Rule = entry.Image
+			violation.Reason = err.Error()
+			violations = append(violations, violation)
+			continue
+		}
+		repository := reference.Domain(named) + "/" + reference.Path(named)
+		if !a.allows(repository) {
+			violation.Rule = repository
+			violation.Reason = "not in the allowed registries and namespaces"
+			violations = append(violations, violation)
+		}
+	}
+	sortPolicyViolations(violations)
+	return violations
+}
+
+func (a *AllowedRegistries) allows(repository string) bool {
+	for _, prefix := range a.Prefixes {
+		if repository == prefix || strings.HasPrefix(repository, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
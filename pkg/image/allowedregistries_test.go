@@ -0,0 +1,75 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestNewAllowedRegistries(t *testing.T) {
+	assert := assertlib.New(t)
+
+	policy, err := NewAllowedRegistries([]string{"rancher", "docker.io/library/", "registry.rancher.com", "localhost:5000/mirror"}, PolicyActionWarn)
+	assert.NoError(err)
+	assert.Equal(&AllowedRegistries{
+		Prefixes: []string{"docker.io/rancher", "docker.io/library", "registry.rancher.com", "localhost:5000/mirror"},
+		Action:   PolicyActionWarn,
+	}, policy)
+
+	_, err = NewAllowedRegistries(nil, PolicyActionFail)
+	assert.EqualError(err, "no allowed registry")
+	_, err = NewAllowedRegistries([]string{" / "}, PolicyActionFail)
+	assert.EqualError(err, `invalid allowed registry " / "`)
+}
+
+func TestAllowedRegistriesCheck(t *testing.T) {
+	assert := assertlib.New(t)
+
+	policy, err := NewAllowedRegistries([]string{"rancher", "quay.io/jetstack", "registry.rancher.com"}, PolicyActionFail)
+	assert.NoError(err)
+	violations := policy.Check(ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Sources: []string{"system"}},
+		{Image: "docker.io/rancher/fleet:v0.9.0@sha256:1111111111111111111111111111111111111111111111111111111111111111", OS: Linux},
+		{Image: "registry.rancher.com/rancher/rancher:v2.8.0", OS: Linux},
+		{Image: "quay.io/jetstack/cert-manager-controller:v1.11.0", OS: Linux},
+		{Image: "quay.io/jetstack-contrib/cert-manager-csi:v0.5.0", OS: Linux, Sources: []string{"cert-manager-csi:0.5.0"}},
+		{Image: "rancher-extra/shell:v0.1.22", OS: Windows},
+		{Image: "busybox:1.36", OS: Linux, Sources: []string{"rancher-monitoring:102.0.0", "system"}},
+	})
+	assert.Equal(PolicyViolations{
+		{
+			Policy:  AllowedRegistriesPolicy,
+			Image:   "busybox:1.36",
+			OS:      Linux,
+			Sources: []string{"rancher-monitoring:102.0.0", "system"},
+			Rule:    "docker.io/library/busybox",
+			Reason:  "not in the allowed registries and namespaces",
+			Action:  PolicyActionFail,
+		},
+		{
+			Policy:  AllowedRegistriesPolicy,
+			Image:   "quay.io/jetstack-contrib/cert-manager-csi:v0.5.0",
+			OS:      Linux,
+			Sources: []string{"cert-manager-csi:0.5.0"},
+			Rule:    "quay.io/jetstack-contrib/cert-manager-csi",
+			Reason:  "not in the allowed registries and namespaces",
+			Action:  PolicyActionFail,
+		},
+		{
+			Policy: AllowedRegistriesPolicy,
+			Image:  "rancher-extra/shell:v0.1.22",
+			OS:     Windows,
+			Rule:   "docker.io/rancher-extra/shell",
+			Reason: "not in the allowed registries and namespaces",
+			Action: PolicyActionFail,
+		},
+	}, violations)
+
+	bySource := violations.BySource()
+	assert.Len(bySource, 3)
+	assert.Len(bySource["system"], 1)
+	assert.Len(bySource["cert-manager-csi:0.5.0"], 1)
+
+	var nilPolicy *AllowedRegistries
+	assert.Empty(nilPolicy.Check(ImageList{{Image: "busybox:1.36", OS: Linux}}))
+}
@@ -0,0 +1,174 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Arch is a CPU architecture images are exported for. Images are exported for every architecture unless the chart
+// values referencing them restrict them with an "arch:" key, see pickImagesFromValuesMap.
+type Arch int
+
+const (
+	AMD64 Arch = iota
+	ARM64
+	S390X
+	PPC64LE
+)
+
+// Arches are the architectures images can be exported for, including the IBM Z and Power architectures Rancher runs
+// on.
+var Arches = []Arch{AMD64, ARM64, S390X, PPC64LE}
+
+// PermitsArchAnnotationKey is the chart annotation listing the architectures the chart can be installed on, e.g.
+// "amd64" or "amd64,arm64", like catalog.cattle.io/permits-os does for OS types. Charts without it are considered to
+// support every architecture.
+const PermitsArchAnnotationKey = "catalog.cattle.io/permits-arch"
+
+func (a Arch) String() string {
+	switch a {
+	case AMD64:
+		return "amd64"
+	case ARM64:
+		return "arm64"
+	case S390X:
+		return "s390x"
+	case PPC64LE:
+		return "ppc64le"
+	default:
+		return fmt.Sprintf("Arch(%d)", int(a))
+	}
+}
+
+// ParseArch returns the Arch called name, case insensitively, e.g. amd64 or ARM64.
+func ParseArch(name string) (Arch, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "amd64":
+		return AMD64, nil
+	case "arm64":
+		return ARM64, nil
+	case "s390x":
+		return S390X, nil
+	case "ppc64le":
+		return PPC64LE, nil
+	default:
+		return 0, errors.Errorf("unknown architecture %q", name)
+	}
+}
+
+// ParseArches parses a comma separated list of architectures, e.g. amd64,arm64.
+func ParseArches(s string) ([]Arch, error) {
+	var arches []Arch
+	for _, field := range strings.Split(s, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		arch, err := ParseArch(field)
+		if err != nil {
+			return nil, err
+		}
+		arches = append(arches, arch)
+	}
+	return arches, nil
+}
+
+// ParseChartArches parses the architectures supported by charts given as NAME=ARCH[,ARCH], e.g.
+// rancher-vsphere-csi=amd64.
+func ParseChartArches(values []string) (map[string][]Arch, error) {
+	chartArches := make(map[string][]Arch, len(values))
+	for _, value := range values {
+		name, archList, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return nil, errors.Errorf("invalid chart architectures %q, expected NAME=ARCH[,ARCH]", value)
+		}
+		arches, err := ParseArches(archList)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid chart architectures %q", value)
+		}
+		chartArches[name] = arches
+	}
+	return chartArches, nil
+}
+
+// MarshalText encodes the architecture as its name, so it is readable in JSON and YAML outputs.
+func (a Arch) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Arch) UnmarshalText(text []byte) error {
+	arch, err := ParseArch(string(text))
+	if err != nil {
+		return err
+	}
+	*a = arch
+	return nil
+}
+
+// chartArches returns the architectures supported by the chart called chartName: the ones of ChartArches if the chart
+// is listed there, or else the ones of its PermitsArchAnnotationKey annotation. Unknown architectures are ignored.
+// Charts supporting every architecture have none.
+func (c ExportConfig) chartArches(chartName string, annotations map[string]string) []Arch {
+	if arches, ok := c.ChartArches[chartName]; ok {
+		return arches
+	}
+	var arches []Arch
+	for _, name := range strings.Split(annotations[PermitsArchAnnotationKey], ",") {
+		if arch, err := ParseArch(name); err == nil {
+			arches = append(arches, arch)
+		}
+	}
+	return arches
+}
+
+// archUnsupported returns true if the export is limited to some architectures and an image or chart restricted to
+// arches supports none of them.
+func (c ExportConfig) archUnsupported(arches []Arch) bool {
+	for _, arch := range c.Arches {
+		if hasArch(arches, arch) {
+			return false
+		}
+	}
+	return len(c.Arches) > 0
+}
+
+// intersectArches returns the architectures of both a and b, where no architectures means every architecture. ok is
+// false if they have no architecture in common.
+func intersectArches(a, b []Arch) (arches []Arch, ok bool) {
+	switch {
+	case len(a) == 0:
+		return b, true
+	case len(b) == 0:
+		return a, true
+	}
+	for _, arch := range a {
+		if hasArch(b, arch) {
+			arches = append(arches, arch)
+		}
+	}
+	return arches, len(arches) > 0
+}
+
+// hasArch returns whether an image restricted to arches is exported for arch. Images restricted to no architecture
+// are exported for every architecture.
+func hasArch(arches []Arch, arch Arch) bool {
+	if len(arches) == 0 {
+		return true
+	}
+	for _, a := range arches {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// archNames returns the names of arches.
+func archNames(arches []Arch) []string {
+	names := make([]string, 0, len(arches))
+	for _, arch := range arches {
+		names = append(names, arch.String())
+	}
+	return names
+}
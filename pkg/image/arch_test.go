@@ -0,0 +1,61 @@
+package image
+
+import (
+	"encoding/json"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestParseArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	arches, err := ParseArches("amd64, ARM64,")
+	assert.NoError(err)
+	assert.Equal([]Arch{AMD64, ARM64}, arches)
+
+	arches, err = ParseArches("s390x,PPC64LE")
+	assert.NoError(err)
+	assert.Equal([]Arch{S390X, PPC64LE}, arches)
+
+	_, err = ParseArches("amd64,mips64le")
+	assert.EqualError(err, `unknown architecture "mips64le"`)
+}
+
+func TestArchJSON(t *testing.T) {
+	assert := assertlib.New(t)
+
+	out, err := json.Marshal([]Arch{AMD64, ARM64})
+	assert.NoError(err)
+	assert.Equal(`["amd64","arm64"]`, string(out))
+
+	var arches []Arch
+	assert.NoError(json.Unmarshal(out, &arches))
+	assert.Equal([]Arch{AMD64, ARM64}, arches)
+}
+
+func TestParseChartArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	chartArches, err := ParseChartArches([]string{"rancher-vsphere-csi=amd64", "fleet=amd64,arm64"})
+	assert.NoError(err)
+	assert.Equal(map[string][]Arch{"rancher-vsphere-csi": {AMD64}, "fleet": {AMD64, ARM64}}, chartArches)
+
+	_, err = ParseChartArches([]string{"fleet"})
+	assert.Error(err)
+	_, err = ParseChartArches([]string{"fleet=mips64le"})
+	assert.Error(err)
+}
+
+func TestImageListForArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/amd64:v1", OS: Linux, Arches: []Arch{AMD64}},
+		{Image: "rancher/any:v1", OS: Linux},
+		{Image: "rancher/wins:v1", OS: Windows},
+	}
+	assert.Equal([]string{"rancher/any:v1"}, list.ForArches([]Arch{ARM64}).Images())
+	assert.Equal([]string{"rancher/amd64:v1", "rancher/any:v1", "rancher/wins:v1"}, list.ForArches([]Arch{AMD64, ARM64}).Images())
+	assert.Equal(list, list.ForArches(nil))
+}
@@ -0,0 +1,71 @@
+package image
+
+import (
+	"strings"
+
+	"github.com/containers/image/v5/types"
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/pkg/errors"
+)
+
+// RegistryCredentials configures how RegistryClient authenticates to registries. Registries without credentials use
+// the docker config.json and containers auth.json files of the user, as docker and podman do.
+type RegistryCredentials struct {
+	// AuthFile is the path of a docker config.json file to read the credentials from, including the credential helpers
+	// it configures, instead of the files of the user.
+	AuthFile string
+	// Credentials are explicit credentials keyed by registry, e.g. docker.io.
+	Credentials map[string]types.DockerAuthConfig
+	// Helpers are docker credential helpers keyed by registry, e.g. ecr-login for
+	// 123456789012.dkr.ecr.us-east-1.amazonaws.com, gcr for gcr.io or acr-env for azurecr.io registries. The
+	// docker-credential-HELPER program is run to get the credentials of the registry.
+	Helpers map[string]string
+}
+
+// authConfig returns the explicit or helper credentials of registry, or nil if it has none.
+func (c RegistryCredentials) authConfig(registry string) (*types.DockerAuthConfig, error) {
+	if auth, ok := c.Credentials[registry]; ok {
+		return &auth, nil
+	}
+	helper, ok := c.Helpers[registry]
+	if !ok {
+		return nil, nil
+	}
+	creds, err := client.Get(client.NewShellProgramFunc("docker-credential-"+helper), registry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get credentials of registry %s from docker-credential-%s", registry, helper)
+	}
+	// Helpers return identity tokens with the <token> username
+	if creds.Username == "<token>" {
+		return &types.DockerAuthConfig{IdentityToken: creds.Secret}, nil
+	}
+	return &types.DockerAuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+}
+
+// ParseRegistryCredentials parses explicit registry credentials given as REGISTRY=USERNAME:PASSWORD.
+func ParseRegistryCredentials(values []string) (map[string]types.DockerAuthConfig, error) {
+	credentials := make(map[string]types.DockerAuthConfig, len(values))
+	for _, value := range values {
+		registry, userAndPassword, ok := strings.Cut(value, "=")
+		username, password, hasPassword := strings.Cut(userAndPassword, ":")
+		if !ok || !hasPassword || registry == "" || username == "" {
+			return nil, errors.Errorf("invalid registry credentials for %q, must be REGISTRY=USERNAME:PASSWORD", registry)
+		}
+		credentials[registry] = types.DockerAuthConfig{Username: username, Password: password}
+	}
+	return credentials, nil
+}
+
+// ParseCredentialHelpers parses docker credential helpers given as REGISTRY=HELPER, e.g.
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com=ecr-login.
+func ParseCredentialHelpers(values []string) (map[string]string, error) {
+	helpers := make(map[string]string, len(values))
+	for _, value := range values {
+		registry, helper, ok := strings.Cut(value, "=")
+		if !ok || registry == "" || helper == "" {
+			return nil, errors.Errorf("invalid credential helper %q, must be REGISTRY=HELPER", value)
+		}
+		helpers[registry] = helper
+	}
+	return helpers, nil
+}
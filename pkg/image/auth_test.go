@@ -0,0 +1,49 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestParseRegistryCredentials(t *testing.T) {
+	assert := assertlib.New(t)
+
+	credentials, err := ParseRegistryCredentials([]string{"docker.io=user:pass:word", "registry.example.com:5000=admin:secret"})
+	assert.NoError(err)
+	assert.Equal(map[string]types.DockerAuthConfig{
+		"docker.io":                 {Username: "user", Password: "pass:word"},
+		"registry.example.com:5000": {Username: "admin", Password: "secret"},
+	}, credentials)
+
+	for _, value := range []string{"docker.io", "docker.io=user", "=user:password", "docker.io=:password"} {
+		_, err := ParseRegistryCredentials([]string{value})
+		assert.Error(err, value)
+		assert.NotContains(err.Error(), "password", value)
+	}
+}
+
+func TestParseCredentialHelpers(t *testing.T) {
+	assert := assertlib.New(t)
+
+	helpers, err := ParseCredentialHelpers([]string{"123456789012.dkr.ecr.us-east-1.amazonaws.com=ecr-login"})
+	assert.NoError(err)
+	assert.Equal(map[string]string{"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"}, helpers)
+
+	_, err = ParseCredentialHelpers([]string{"gcr.io"})
+	assert.Error(err)
+}
+
+func TestRegistryCredentialsAuthConfig(t *testing.T) {
+	assert := assertlib.New(t)
+
+	credentials := RegistryCredentials{Credentials: map[string]types.DockerAuthConfig{"docker.io": {Username: "user", Password: "password"}}}
+	auth, err := credentials.authConfig("docker.io")
+	assert.NoError(err)
+	assert.Equal(&types.DockerAuthConfig{Username: "user", Password: "password"}, auth)
+
+	auth, err = credentials.authConfig("quay.io")
+	assert.NoError(err)
+	assert.Nil(auth)
+}
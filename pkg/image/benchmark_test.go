@@ -0,0 +1,244 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The benchmarks of the export run against a synthetic charts repository sized after the Rancher charts repository:
+// benchmarkCharts charts of benchmarkChartVersions versions each, whose values files reference benchmarkChartImages
+// images amid benchmarkValuesPadding unrelated settings. Their baselines are recorded in testdata/benchmarks.txt, see
+// its header for how to compare a change against them.
+const (
+	benchmarkCharts        = 120
+	benchmarkChartVersions = 3
+	benchmarkChartImages   = 12
+	benchmarkValuesPadding = 40
+)
+
+// benchmarkValues returns a values file of the chart called chartName, with images images, every fourth of them for
+// Linux and Windows, and padding settings nested in maps and lists like those of real charts.
+func benchmarkValues(chartName string, images, padding int) []byte {
+	var values strings.Builder
+	for i := 0; i < images; i++ {
+		fmt.Fprintf(&values, "component%d:\n  image:\n    repository: rancher/%s-component%d\n    tag: v1.%d.0\n", i, chartName, i, i)
+		if i%4 == 0 {
+			values.WriteString("    os: linux,windows\n")
+		}
+		values.WriteString("  resources:\n    limits:\n      cpu: 100m\n      memory: 128Mi\n")
+	}
+	for i := 0; i < padding; i++ {
+		fmt.Fprintf(&values, "setting%d:\n  enabled: true\n  annotations:\n    example.com/key: value%d\n  ports:\n  - name: http\n    port: %d\n", i, i, 8000+i)
+	}
+	return []byte(values.String())
+}
+
+// writeChartTgz writes the tgz file of a chart with the given values files to path.
+func writeChartTgz(path, chartName, version string, valuesFiles map[string][]byte) error {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	files := map[string][]byte{
+		"Chart.yaml":                []byte(fmt.Sprintf("apiVersion: v2\nname: %s\nversion: %s\n", chartName, version)),
+		"templates/deployment.yaml": []byte("apiVersion: apps/v1\nkind: Deployment\n"),
+	}
+	for name, content := range valuesFiles {
+		files[name] = content
+	}
+	for name, content := range files {
+		header := &tar.Header{Name: chartName + "/" + name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gzw.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// newBenchmarkChartsRepo writes a synthetic charts repository of charts charts of versions versions each to a temporary
+// directory, and returns its path. Every chart version has a values.yaml file and, for every other chart, a values file
+// of its Windows variant. Only the latest versions are scanned, the charts having no Rancher version constraint to
+// check, see chartsToCheckConstraints.
+func newBenchmarkChartsRepo(b *testing.B, charts, versions int) string {
+	b.Helper()
+	chartsPath := b.TempDir()
+	var index strings.Builder
+	index.WriteString("apiVersion: v1\nentries:\n")
+	for c := 0; c < charts; c++ {
+		chartName := fmt.Sprintf("chart%d", c)
+		fmt.Fprintf(&index, "  %s:\n", chartName)
+		assetsPath := filepath.Join(chartsPath, "assets", chartName)
+		if err := os.MkdirAll(assetsPath, 0755); err != nil {
+			b.Fatal(err)
+		}
+		// Versions are listed in descending order, as charts-build-scripts does
+		for v := versions - 1; v >= 0; v-- {
+			version := fmt.Sprintf("1.%d.0", v)
+			url := fmt.Sprintf("assets/%s/%s-%s.tgz", chartName, chartName, version)
+			fmt.Fprintf(&index, "  - name: %s\n    version: %s\n    annotations:\n      %s: '>= 2.7.0-0'\n    urls:\n    - %s\n", chartName, version, RancherVersionAnnotationKey, url)
+			valuesFiles := map[string][]byte{"values.yaml": benchmarkValues(chartName, benchmarkChartImages, benchmarkValuesPadding)}
+			if c%2 == 0 {
+				valuesFiles["values-windows.yaml"] = benchmarkValues(chartName+"-windows", benchmarkChartImages/2, benchmarkValuesPadding/2)
+			}
+			if err := writeChartTgz(filepath.Join(chartsPath, url), chartName, version, valuesFiles); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := os.WriteFile(filepath.Join(chartsPath, "index.yaml"), []byte(index.String()), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return chartsPath
+}
+
+// withoutExtensions disables the extension endpoints, which are fetched over the network, until the benchmark ends.
+func withoutExtensions(b *testing.B) {
+	originalEndpoints := ExtensionEndpoints
+	b.Cleanup(func() {
+		ExtensionEndpoints = originalEndpoints
+	})
+	ExtensionEndpoints = nil
+}
+
+func BenchmarkGetImagesForOSTypes(b *testing.B) {
+	withoutExtensions(b)
+	config := ExportConfig{ChartsPath: newBenchmarkChartsRepo(b, benchmarkCharts, benchmarkChartVersions), RancherVersion: "2.8.0"}
+	inputs := map[OSType]OSImageInputs{
+		Linux:   {ImagesFromArgs: []string{"rancher/rancher:v2.8.0"}},
+		Windows: {ImagesFromArgs: []string{}},
+	}
+	result, err := GetImagesForOSTypes(context.Background(), config, inputs)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(result.ChartErrors) > 0 || len(result.Images.ForOS(Linux)) < benchmarkCharts*benchmarkChartImages {
+		b.Fatalf("unexpected export of the synthetic charts repository: %d Linux images, chart errors: %v", len(result.Images.ForOS(Linux)), result.ChartErrors)
+	}
+
+	// Every export decodes the charts, as a single run of the export tool does
+	b.Run("cold", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := GetImagesForOSTypes(context.Background(), config, inputs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// The exports share the decoded charts, as the exports of several Rancher versions do
+	b.Run("warm", func(b *testing.B) {
+		ctx := WithDecodeCache(context.Background())
+		if _, err := GetImagesForOSTypes(ctx, config, inputs); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := GetImagesForOSTypes(ctx, config, inputs); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkReadValuesFilesInTgz(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "chart-1.0.0.tgz")
+	valuesFiles := map[string][]byte{"values.yaml": benchmarkValues("chart", benchmarkChartImages, benchmarkValuesPadding)}
+	if err := writeChartTgz(path, "chart", "1.0.0", valuesFiles); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := readValuesFilesInTgz(path, ValuesLimits{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeValues(b *testing.B) {
+	values := benchmarkValues("chart", benchmarkChartImages, benchmarkValuesPadding)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(values)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeValues(bytes.NewReader(values), ValuesLimits{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPickImagesFromValuesMap(b *testing.B) {
+	values, err := decodeValues(bytes.NewReader(benchmarkValues("chart", benchmarkChartImages, benchmarkValuesPadding)), ValuesLimits{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		imagesSet := NewImageSet(Linux, Windows)
+		if err := pickImagesFromValuesMap(imagesSet, values, "chart:1.0.0", "", nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkImageSetAdd adds 10k images referenced by several charts each, listing the set half way through like the
+// mirror conversion of the export does.
+func BenchmarkImageSetAdd(b *testing.B) {
+	const images = 10000
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		imagesSet := NewImageSet(Linux, Windows)
+		for j := 0; j < images; j++ {
+			image := fmt.Sprintf("rancher/image%d:v1.0.0", (j*7919)%images)
+			for c := 0; c < 3; c++ {
+				imagesSet.AddChartImage(Linux, image, fmt.Sprintf("chart%d:1.0.0", (j+c)%benchmarkCharts), "image")
+			}
+			if j == images/2 {
+				imagesSet.Images(Linux)
+			}
+		}
+		imagesSet.Images(Linux)
+	}
+}
+
+func BenchmarkImageSetListAll(b *testing.B) {
+	imagesSet := NewImageSet(Linux, Windows)
+	for i := 0; i < benchmarkCharts*benchmarkChartImages; i++ {
+		chartNameAndVersion := fmt.Sprintf("chart%d:1.0.0", i%benchmarkCharts)
+		imagesSet.AddChartImage(Linux, fmt.Sprintf("rancher/image%d:v1.0.0", i), chartNameAndVersion, "image")
+		if i%4 == 0 {
+			imagesSet.AddChartImageForArches(Windows, fmt.Sprintf("rancher/image%d:v1.0.0", i), chartNameAndVersion, "image", AMD64)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		imagesSet.ListAll()
+	}
+}
+
+func BenchmarkCompareRancherVersionToConstraint(b *testing.B) {
+	ctx := withConstraintCache(context.Background())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := compareRancherVersionToConstraint(ctx, "2.8.1-rc1", ">= 2.7.0-0 < 2.8.99-0"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
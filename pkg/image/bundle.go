@@ -0,0 +1,407 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/reference"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultBundleName is the name of the files of bundles without name.
+	defaultBundleName = "rancher-images-bundle"
+	// containerdImageNameAnnotation is the annotation containerd and docker read the name of the images of OCI
+	// archives from, since org.opencontainers.image.ref.name usually only holds their tag.
+	containerdImageNameAnnotation = "io.containerd.image.name"
+)
+
+// BundleBuilder writes the images of an image list to an air-gap bundle, without docker or skopeo: a tarball of an OCI
+// image layout holding every platform of the images, which docker load, ctr images import and skopeo can read,
+// optionally split into size-capped parts, along with an index manifest describing its images and parts.
+type BundleBuilder struct {
+	// Client configures the connections to the registries and how many images are pulled concurrently.
+	Client RegistryClient
+	// Dir is the directory the bundle is written to. The OCI layout is staged in a subdirectory, so the directory
+	// needs room for twice the size of the bundle.
+	Dir string
+	// Name is the name of the bundle files, defaultBundleName if not set: NAME.tar, or NAME.tar.000, NAME.tar.001...
+	// when split, and the NAME-index.json index manifest.
+	Name string
+	// SourceRegistry, if set, is the registry the images are pulled from instead of their own registries, e.g. a
+	// staging mirror.
+	SourceRegistry string
+	// PartSize, if positive, is the maximum size of the parts the tarball is split into.
+	PartSize int64
+	// Retries is the number of times the pull of an image is retried after failing.
+	Retries int
+	// Progress, if set, is called with the result of every image once it has been pulled or has failed. It is not
+	// called concurrently.
+	Progress func(result CopyResult)
+}
+
+// BundleIndex is the index manifest of a bundle, describing its images and the parts of its tarball.
+type BundleIndex struct {
+	// CreatedAt is when the bundle was written.
+	CreatedAt time.Time `json:"createdAt"`
+	// Images are the images of the bundle, sorted.
+	Images []BundleImage `json:"images"`
+	// Parts are the parts of the tarball in order, a single part if it is not split.
+	Parts []BundlePart `json:"parts"`
+	// Size is the total size of the parts.
+	Size int64 `json:"size"`
+}
+
+// BundleImage is an image of a bundle.
+type BundleImage struct {
+	// Image is the image reference.
+	Image string `json:"image"`
+	// OS is the OS the image is exported for.
+	OS OSType `json:"os"`
+	// Digest is the digest of the manifest of the image, i.e. of the manifest list for multi-arch images.
+	Digest string `json:"digest"`
+}
+
+// BundlePart is a part of the tarball of a bundle.
+type BundlePart struct {
+	// File is the name of the part in the directory of the bundle.
+	File string `json:"file"`
+	// Size is the size of the part.
+	Size int64 `json:"size"`
+	// SHA256 is the sha256 checksum of the part.
+	SHA256 string `json:"sha256"`
+}
+
+// IndexFile returns the name of the index manifest of the bundle.
+func (b BundleBuilder) IndexFile() string {
+	return bundleIndexFile(b.Name)
+}
+
+func (b BundleBuilder) name() string {
+	return bundleName(b.Name)
+}
+
+// bundleName returns the name of the files of the bundle called name, defaultBundleName if it is empty.
+func bundleName(name string) string {
+	if name == "" {
+		return defaultBundleName
+	}
+	return name
+}
+
+// bundleIndexFile returns the name of the index manifest of the bundle called name.
+func bundleIndexFile(name string) string {
+	return bundleName(name) + "-index.json"
+}
+
+// Build pulls the images of list and writes the bundle. Images exported for several OS types are pulled once. The
+// bundle holds the images that could be pulled, ImageErrors are returned along with its index if some could not.
+func (b BundleBuilder) Build(ctx context.Context, list ImageList) (BundleIndex, error) {
+	layoutDir := filepath.Join(b.Dir, "."+b.name()+"-layout")
+	if err := os.RemoveAll(layoutDir); err != nil {
+		return BundleIndex{}, err
+	}
+	defer os.RemoveAll(layoutDir)
+
+	images, errs := b.pull(ctx, list, layoutDir)
+	if err := writeLayoutIndex(layoutDir, images); err != nil {
+		return BundleIndex{}, err
+	}
+	index := BundleIndex{CreatedAt: time.Now().UTC(), Images: make([]BundleImage, 0, len(images))}
+	for _, image := range images {
+		index.Images = append(index.Images, image.BundleImage)
+	}
+	parts, err := b.writeTarball(layoutDir)
+	if err != nil {
+		return BundleIndex{}, err
+	}
+	index.Parts = parts
+	for _, part := range parts {
+		index.Size += part.Size
+	}
+	out, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return BundleIndex{}, err
+	}
+	if err := os.WriteFile(filepath.Join(b.Dir, b.IndexFile()), out, 0644); err != nil {
+		return BundleIndex{}, err
+	}
+	if len(errs) > 0 {
+		return index, errs
+	}
+	return index, nil
+}
+
+// layoutImage is an image pulled to the OCI layout of a bundle.
+type layoutImage struct {
+	BundleImage
+	descriptor imgspecv1.Descriptor
+}
+
+// pull pulls the images of list to the OCI layout at layoutDir, and returns the images pulled, sorted, along with the
+// errors of the others.
+func (b BundleBuilder) pull(ctx context.Context, list ImageList, layoutDir string) ([]layoutImage, ImageErrors) {
+	var unique ImageList
+	seen := make(map[string]bool, len(list))
+	for _, entry := range list {
+		if !seen[entry.Image] {
+			seen[entry.Image] = true
+			unique = append(unique, entry)
+		}
+	}
+
+	var mu sync.Mutex
+	// Every destination rewrites the index of the layout when committed
+	commitMu := &sync.Mutex{}
+	var images []layoutImage
+	var errs ImageErrors
+	b.Client.forEach(unique, func(entry *ImageEntry) {
+		var raw []byte
+		err := withRetries(ctx, b.Retries, func() error {
+			var err error
+			raw, err = b.pullImage(ctx, *entry, layoutDir, commitMu)
+			return err
+		})
+		result := CopyResult{Image: entry.Image, OS: entry.OS, Target: entry.Image, Err: err}
+		var image layoutImage
+		if err == nil {
+			image, err = newLayoutImage(*entry, raw)
+			result.Digest, result.Err = image.Digest, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, &ImageError{Image: entry.Image, OS: entry.OS, Err: err})
+		} else {
+			images = append(images, image)
+		}
+		if b.Progress != nil {
+			b.Progress(result)
+		}
+	})
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Image < images[j].Image
+	})
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Image < errs[j].Image
+	})
+	return images, errs
+}
+
+// pullImage pulls the image of entry to the OCI layout at layoutDir and returns its manifest.
+func (b BundleBuilder) pullImage(ctx context.Context, entry ImageEntry, layoutDir string, commitMu *sync.Mutex) ([]byte, error) {
+	ref, err := layout.NewReference(layoutDir, entry.Image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create layout reference of image %s", entry.Image)
+	}
+	dest, err := ref.NewImageDestination(ctx, &types.SystemContext{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create layout of image %s", entry.Image)
+	}
+	defer dest.Close()
+	source := entry.Image
+	if b.SourceRegistry != "" {
+		source = strings.TrimSuffix(b.SourceRegistry, "/") + "/" + entry.Image
+	}
+	return b.Client.copyImageTo(ctx, source, entry.OS, lockedCommitDestination{ImageDestination: dest, mu: commitMu})
+}
+
+// lockedCommitDestination serializes the commits of the destinations sharing an OCI layout.
+type lockedCommitDestination struct {
+	types.ImageDestination
+	mu *sync.Mutex
+}
+
+func (d lockedCommitDestination) Commit(ctx context.Context, unparsedToplevel types.UnparsedImage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ImageDestination.Commit(ctx, unparsedToplevel)
+}
+
+// newLayoutImage returns the layout image of entry whose manifest is raw.
+func newLayoutImage(entry ImageEntry, raw []byte) (layoutImage, error) {
+	manifestDigest, err := manifest.Digest(raw)
+	if err != nil {
+		return layoutImage{}, errors.Wrapf(err, "failed to compute digest of image %s", entry.Image)
+	}
+	named, err := reference.ParseNormalizedNamed(entry.Image)
+	if err != nil {
+		return layoutImage{}, errors.Wrapf(err, "failed to parse image %s", entry.Image)
+	}
+	return layoutImage{
+		BundleImage: BundleImage{Image: entry.Image, OS: entry.OS, Digest: manifestDigest.String()},
+		descriptor: imgspecv1.Descriptor{
+			MediaType: manifest.GuessMIMEType(raw),
+			Digest:    manifestDigest,
+			Size:      int64(len(raw)),
+			Annotations: map[string]string{
+				imgspecv1.AnnotationRefName:   entry.Image,
+				containerdImageNameAnnotation: named.String(),
+			},
+		},
+	}, nil
+}
+
+// writeLayoutIndex writes the index of the OCI layout at layoutDir, listing images in order. It replaces the index
+// written by the destinations, whose concurrent commits each only know the images committed before their creation.
+func writeLayoutIndex(layoutDir string, images []layoutImage) error {
+	index := imgspecv1.Index{MediaType: imgspecv1.MediaTypeImageIndex, Manifests: make([]imgspecv1.Descriptor, 0, len(images))}
+	index.SchemaVersion = 2
+	for _, image := range images {
+		index.Manifests = append(index.Manifests, image.descriptor)
+	}
+	out, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(layoutDir, 0755); err != nil {
+		return err
+	}
+	layoutFile, err := json.Marshal(imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(layoutDir, imgspecv1.ImageLayoutFile), layoutFile, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(layoutDir, "index.json"), out, 0644)
+}
+
+// writeTarball writes the OCI layout at layoutDir to the tarball of the bundle, split in parts of at most PartSize
+// bytes, and returns its parts. The files of the layout are written in order with fixed timestamps, so the same
+// images always produce the same tarball.
+func (b BundleBuilder) writeTarball(layoutDir string) ([]BundlePart, error) {
+	parts := &partWriter{dir: b.Dir, name: b.name() + ".tar", partSize: b.PartSize}
+	tw := tar.NewWriter(parts)
+	err := filepath.WalkDir(layoutDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == layoutDir {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if header.Name, err = filepath.Rel(layoutDir, path); err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(header.Name)
+		header.ModTime = time.Unix(0, 0)
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		header.Mode = 0644
+		if d.IsDir() {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := parts.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write bundle tarball")
+	}
+	return parts.parts, nil
+}
+
+// partWriter writes a file split in parts of at most partSize bytes, named NAME.000, NAME.001..., or a single NAME
+// file if partSize is not positive.
+type partWriter struct {
+	dir      string
+	name     string
+	partSize int64
+
+	file    *os.File
+	hash    hash.Hash
+	written int64
+	parts   []BundlePart
+}
+
+func (w *partWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.file == nil || (w.partSize > 0 && w.written >= w.partSize) {
+			if err := w.next(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if w.partSize > 0 && int64(len(chunk)) > w.partSize-w.written {
+			chunk = chunk[:w.partSize-w.written]
+		}
+		n, err := io.MultiWriter(w.file, w.hash).Write(chunk)
+		w.written += int64(n)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// next closes the current part and creates the next one.
+func (w *partWriter) next() error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	name := w.name
+	if w.partSize > 0 {
+		name = fmt.Sprintf("%s.%03d", w.name, len(w.parts))
+	}
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+	w.file, w.hash, w.written = file, sha256.New(), 0
+	w.parts = append(w.parts, BundlePart{File: name})
+	return nil
+}
+
+// Close closes the current part and records its size and checksum.
+func (w *partWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	part := &w.parts[len(w.parts)-1]
+	part.Size = w.written
+	part.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+	return err
+}
@@ -0,0 +1,82 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestBundleBuilderBuild(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	shellDigest := registry.AddImage("rancher/shell", "v0.1.22", bytes.Repeat([]byte("shell"), 1000))
+	agentDigest := registry.AddImage("rancher/rancher-agent", "v2.8.0", bytes.Repeat([]byte("agent"), 1000))
+	shell := registry.Host() + "/rancher/shell:v0.1.22"
+	agent := registry.Host() + "/rancher/rancher-agent:v2.8.0"
+
+	builder := BundleBuilder{Client: registry.client(), Dir: t.TempDir(), PartSize: 4096}
+	index, err := builder.Build(context.Background(), ImageList{
+		{Image: shell, OS: Linux},
+		{Image: agent, OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.21", OS: Linux},
+	})
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal(registry.Host()+"/rancher/shell:v0.1.21", imageErrs[0].Image)
+	}
+	assert.Equal([]BundleImage{{Image: agent, OS: Linux, Digest: agentDigest}, {Image: shell, OS: Linux, Digest: shellDigest}}, index.Images)
+
+	// The parts are capped, and concatenated they are the tarball of the OCI layout
+	var tarball bytes.Buffer
+	assert.Greater(len(index.Parts), 1)
+	for i, part := range index.Parts {
+		content, err := os.ReadFile(filepath.Join(builder.Dir, part.File))
+		assert.NoError(err)
+		assert.LessOrEqual(part.Size, int64(4096))
+		assert.Equal(part.Size, int64(len(content)))
+		checksum := sha256.Sum256(content)
+		assert.Equal(hex.EncodeToString(checksum[:]), part.SHA256)
+		assert.Equal(fmt.Sprintf("rancher-images-bundle.tar.%03d", i), part.File)
+		tarball.Write(content)
+	}
+	assert.Equal(int64(tarball.Len()), index.Size)
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(&tarball)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(err) {
+			return
+		}
+		files[header.Name], _ = io.ReadAll(tr)
+	}
+	assert.Contains(files, "oci-layout")
+	var layoutIndex imgspecv1.Index
+	assert.NoError(json.Unmarshal(files["index.json"], &layoutIndex))
+	if assert.Len(layoutIndex.Manifests, 2) {
+		assert.Equal(agentDigest, layoutIndex.Manifests[0].Digest.String())
+		assert.Equal(agent, layoutIndex.Manifests[0].Annotations[imgspecv1.AnnotationRefName])
+		assert.Contains(files, "blobs/sha256/"+layoutIndex.Manifests[1].Digest.Encoded())
+	}
+
+	written, err := os.ReadFile(filepath.Join(builder.Dir, "rancher-images-bundle-index.json"))
+	assert.NoError(err)
+	var writtenIndex BundleIndex
+	assert.NoError(json.Unmarshal(written, &writtenIndex))
+	assert.Equal(index.Parts, writtenIndex.Parts)
+}
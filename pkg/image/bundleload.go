@@ -0,0 +1,343 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// extractedMarker is the file written in the extracted layout of a bundle once it is complete, holding the checksum
+// of the index manifest of the bundle it was extracted from.
+const extractedMarker = ".extracted"
+
+// BundleLoader pushes the images of an air-gap bundle written by BundleBuilder to a private registry, replacing the
+// load scripts inside air-gapped networks. The parts of the bundle are verified against its index manifest before
+// being extracted, the images the registry already holds with the digest of the bundle are skipped, and the digest of
+// every pushed image is verified. The extracted layout is kept until every image has been pushed, so a failed load
+// resumes where it stopped.
+type BundleLoader struct {
+	// Client configures the connections to the private registry and how many images are pushed concurrently.
+	Client RegistryClient
+	// Dir is the directory of the bundle. The bundle is extracted in a subdirectory, so the directory needs room for
+	// twice the size of the bundle.
+	Dir string
+	// Name is the name of the bundle files, defaultBundleName if not set.
+	Name string
+	// Registry is the private registry the images are pushed to, e.g. registry.example.com:5000. Images are pushed
+	// like the load scripts do, see TargetImage.
+	Registry string
+	// Retries is the number of times the push of an image is retried after failing.
+	Retries int
+	// Progress, if set, is called with the result of every image once it has been pushed, skipped or has failed. It
+	// is not called concurrently.
+	Progress func(result CopyResult)
+}
+
+// ReadBundleIndex reads the index manifest of a bundle at path.
+func ReadBundleIndex(path string) (BundleIndex, error) {
+	var index BundleIndex
+	in, err := os.ReadFile(path)
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(in, &index); err != nil {
+		return index, errors.Wrapf(err, "failed to parse bundle index %s", path)
+	}
+	return index, nil
+}
+
+// Load pushes the images of the bundle to the private registry. It returns the results of every image, sorted by
+// image, along with ImageErrors if some images could not be pushed.
+func (l BundleLoader) Load(ctx context.Context) ([]CopyResult, error) {
+	if l.Registry == "" {
+		return nil, errors.New("target registry is required")
+	}
+	indexPath := filepath.Join(l.Dir, bundleIndexFile(l.Name))
+	index, err := ReadBundleIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	layoutDir := filepath.Join(l.Dir, "."+bundleName(l.Name)+"-layout")
+	if err := l.extract(index, indexPath, layoutDir); err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	results := make([]CopyResult, 0, len(index.Images))
+	list := make(ImageList, 0, len(index.Images))
+	digests := make(map[string]string, len(index.Images))
+	for _, image := range index.Images {
+		list = append(list, ImageEntry{Image: image.Image, OS: image.OS})
+		digests[image.Image] = image.Digest
+	}
+	l.Client.forEach(list, func(entry *ImageEntry) {
+		result := l.pushWithRetries(ctx, *entry, layoutDir, digests[entry.Image])
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, result)
+		if l.Progress != nil {
+			l.Progress(result)
+		}
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Image < results[j].Image
+	})
+	var errs ImageErrors
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, &ImageError{Image: result.Image, OS: result.OS, Err: result.Err})
+		}
+	}
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, os.RemoveAll(layoutDir)
+}
+
+// pushWithRetries pushes the image of entry from the layout at layoutDir unless the registry already holds it with
+// manifestDigest, retrying with an exponential backoff, and verifies the digest of the pushed image.
+func (l BundleLoader) pushWithRetries(ctx context.Context, entry ImageEntry, layoutDir, manifestDigest string) CopyResult {
+	result := CopyResult{Image: entry.Image, OS: entry.OS, Target: TargetImage(l.Registry, entry.Image), Digest: manifestDigest}
+	if held, err := l.Client.Digest(ctx, result.Target, entry.OS); err == nil && held == manifestDigest {
+		result.Skipped = true
+		return result
+	}
+	result.Err = withRetries(ctx, l.Retries, func() error {
+		if err := l.push(ctx, entry, layoutDir, result.Target); err != nil {
+			return err
+		}
+		pushed, err := l.Client.Digest(ctx, result.Target, entry.OS)
+		if err != nil {
+			return errors.Wrapf(err, "failed to verify image %s", result.Target)
+		}
+		if pushed != manifestDigest {
+			return errors.Errorf("image %s has digest %s instead of %s", result.Target, pushed, manifestDigest)
+		}
+		return nil
+	})
+	return result
+}
+
+// push pushes the image of entry from the layout at layoutDir to target.
+func (l BundleLoader) push(ctx context.Context, entry ImageEntry, layoutDir, target string) error {
+	src, err := newLayoutSource(layoutDir, entry.Image)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read image %s from the bundle", entry.Image)
+	}
+	destRef, err := dockerReference(target)
+	if err != nil {
+		return err
+	}
+	destSys, err := l.Client.systemContext(target, entry.OS)
+	if err != nil {
+		return err
+	}
+	dest, err := destRef.NewImageDestination(ctx, destSys)
+	if err != nil {
+		return errors.Wrapf(err, "failed to access image %s", target)
+	}
+	defer dest.Close()
+	_, err = l.Client.copySource(ctx, src, dest, entry.Image)
+	return err
+}
+
+// extract verifies the parts of the bundle against index and extracts its tarball to layoutDir, unless a previous load
+// already extracted the bundle of the index manifest at indexPath.
+func (l BundleLoader) extract(index BundleIndex, indexPath, layoutDir string) error {
+	indexChecksum, err := fileSHA256(indexPath)
+	if err != nil {
+		return err
+	}
+	if marker, err := os.ReadFile(filepath.Join(layoutDir, extractedMarker)); err == nil && string(marker) == indexChecksum {
+		return nil
+	}
+
+	readers := make([]io.Reader, 0, len(index.Parts))
+	for _, part := range index.Parts {
+		path := filepath.Join(l.Dir, part.File)
+		checksum, err := fileSHA256(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read bundle part %s", part.File)
+		}
+		if checksum != part.SHA256 {
+			return errors.Errorf("bundle part %s is corrupted, its sha256 checksum is %s instead of %s", part.File, checksum, part.SHA256)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	if err := os.RemoveAll(layoutDir); err != nil {
+		return err
+	}
+	if err := extractTar(io.MultiReader(readers...), layoutDir); err != nil {
+		return errors.Wrap(err, "failed to extract bundle")
+	}
+	return os.WriteFile(filepath.Join(layoutDir, extractedMarker), []byte(indexChecksum), 0644)
+}
+
+// extractTar extracts the directories and regular files of the tarball read from r to dir.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return errors.Errorf("invalid file %s outside of the bundle", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			file, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tr)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// layoutSource is a types.ImageSource reading an image of the OCI layout of a bundle. The layout source of
+// containers/image only reads OCI manifests, while bundles keep the manifests as the registries serve them.
+type layoutSource struct {
+	ref        types.ImageReference
+	dir        string
+	descriptor imgspecv1.Descriptor
+}
+
+// newLayoutSource returns the source of image in the layout at dir, whose manifest is found in the index of the layout
+// by its reference name annotation, or by the image name annotation of the layouts exported by containerd.
+func newLayoutSource(dir, image string) (*layoutSource, error) {
+	ref, err := layout.NewReference(dir, image)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index imgspecv1.Index
+	if err := json.Unmarshal(in, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to parse layout index")
+	}
+	names, err := containerdImageNames(image)
+	if err != nil {
+		return nil, err
+	}
+	for _, descriptor := range index.Manifests {
+		if descriptor.Annotations[imgspecv1.AnnotationRefName] == image {
+			return &layoutSource{ref: ref, dir: dir, descriptor: descriptor}, nil
+		}
+	}
+	for _, name := range names {
+		for _, descriptor := range index.Manifests {
+			if descriptor.Annotations[containerdImageNameAnnotation] == name {
+				return &layoutSource{ref: ref, dir: dir, descriptor: descriptor}, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("image %s is not in the layout", image)
+}
+
+func (s *layoutSource) Reference() types.ImageReference {
+	return s.ref
+}
+
+func (s *layoutSource) Close() error {
+	return nil
+}
+
+func (s *layoutSource) GetManifest(_ context.Context, instanceDigest *digest.Digest) ([]byte, string, error) {
+	manifestDigest, mimeType := s.descriptor.Digest, s.descriptor.MediaType
+	if instanceDigest != nil {
+		manifestDigest, mimeType = *instanceDigest, ""
+	}
+	raw, err := os.ReadFile(s.blobPath(manifestDigest))
+	if err != nil {
+		return nil, "", err
+	}
+	if mimeType == "" {
+		mimeType = manifest.GuessMIMEType(raw)
+	}
+	return raw, mimeType, nil
+}
+
+func (s *layoutSource) HasThreadSafeGetBlob() bool {
+	return true
+}
+
+func (s *layoutSource) GetBlob(_ context.Context, info types.BlobInfo, _ types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	file, err := os.Open(s.blobPath(info.Digest))
+	if err != nil {
+		return nil, -1, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, -1, err
+	}
+	return file, stat.Size(), nil
+}
+
+func (s *layoutSource) GetSignatures(context.Context, *digest.Digest) ([][]byte, error) {
+	return nil, nil
+}
+
+func (s *layoutSource) LayerInfosForCopy(context.Context, *digest.Digest) ([]types.BlobInfo, error) {
+	return nil, nil
+}
+
+func (s *layoutSource) blobPath(blobDigest digest.Digest) string {
+	return filepath.Join(s.dir, "blobs", blobDigest.Algorithm().String(), blobDigest.Encoded())
+}
+
+// fileSHA256 returns the hex encoded sha256 checksum of the file at path.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
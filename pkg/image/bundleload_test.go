@@ -0,0 +1,44 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestBundleLoaderLoad(t *testing.T) {
+	assert := assertlib.New(t)
+
+	source := newFakeRegistry(t)
+	shellDigest := source.AddImage("rancher/shell", "v0.1.22", []byte("shell"))
+	agentDigest := source.AddImage("rancher/rancher-agent", "v2.8.0", []byte("agent"))
+	dir := t.TempDir()
+	builder := BundleBuilder{Client: source.client(), Dir: dir, SourceRegistry: source.Host(), PartSize: 1024}
+	_, err := builder.Build(context.Background(), ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/rancher-agent:v2.8.0", OS: Linux},
+	})
+	assert.NoError(err)
+
+	dest := newFakeRegistry(t)
+	dest.AddImage("rancher/shell", "v0.1.22", []byte("shell"))
+	loader := BundleLoader{Client: dest.client(), Dir: dir, Registry: dest.Host()}
+	results, err := loader.Load(context.Background())
+	assert.NoError(err)
+	assert.Equal([]CopyResult{
+		{Image: "rancher/rancher-agent:v2.8.0", OS: Linux, Target: dest.Host() + "/rancher/rancher-agent:v2.8.0", Digest: agentDigest},
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Target: dest.Host() + "/rancher/shell:v0.1.22", Digest: shellDigest, Skipped: true},
+	}, results)
+	held, err := dest.client().Digest(context.Background(), dest.Host()+"/rancher/rancher-agent:v2.8.0", Linux)
+	assert.NoError(err)
+	assert.Equal(agentDigest, held)
+	assert.NoDirExists(filepath.Join(dir, ".rancher-images-bundle-layout"))
+
+	// Corrupted parts are not extracted again
+	assert.NoError(os.WriteFile(filepath.Join(dir, "rancher-images-bundle.tar.000"), []byte("corrupted"), 0644))
+	_, err = loader.Load(context.Background())
+	assert.ErrorContains(err, "rancher-images-bundle.tar.000 is corrupted")
+}
@@ -0,0 +1,354 @@
+package image
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/pkg/errors"
+	"github.com/tomnomnom/linkheader"
+)
+
+// catalogPageSize is the number of repositories or tags requested per page of registry catalogs and tag lists.
+const catalogPageSize = 100
+
+// Catalog returns the repositories of registry, e.g. registry.example.com:5000, walking every page of its catalog. The
+// registry must allow listing its catalog, which Docker Hub and most cloud registries do not.
+func (c RegistryClient) Catalog(ctx context.Context, registry string) ([]string, error) {
+	var repositories []string
+	err := c.WalkCatalog(ctx, registry, func(page []string) error {
+		repositories = append(repositories, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(repositories)
+	return repositories, nil
+}
+
+// WalkCatalog calls f with each page of the catalog of registry as soon as it is read, following the Link headers of
+// the pages, so the catalogs of registries with many repositories are not held in memory. Walking stops at the first
+// error of f, which is returned.
+func (c RegistryClient) WalkCatalog(ctx context.Context, registry string, f func(repositories []string) error) error {
+	// The catalog is not an image, its path only selects the credentials of the registry
+	sys, err := c.systemContext(registry+"/_catalog", Linux)
+	if err != nil {
+		return err
+	}
+	catalog, err := c.catalogClient(sys, registry, "registry:catalog:*")
+	if err != nil {
+		return err
+	}
+
+	next := "https://" + registry + "/v2/_catalog?n=" + strconv.Itoa(catalogPageSize)
+	for next != "" {
+		resp, err := catalog.get(ctx, next)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list catalog of registry %s", registry)
+		}
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse catalog of registry %s", registry)
+		}
+		if next, err = nextPage(next, resp); err != nil {
+			return errors.Wrapf(err, "failed to list catalog of registry %s", registry)
+		}
+		if err := f(page.Repositories); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inventory returns the images held by registry, every tag of every repository of its catalog, sorted. The registry is
+// stripped from the images, e.g. rancher/shell:v0.1.22 for registry.example.com/rancher/shell:v0.1.22, so they compare
+// to the images of the image lists. The repositories whose tags cannot be listed are returned as ImageErrors along with
+// the images of the other repositories.
+func (c RegistryClient) Inventory(ctx context.Context, registry string) ([]string, error) {
+	var images []string
+	err := c.WalkInventory(ctx, registry, 0, func(repositoryImages []string) error {
+		images = append(images, repositoryImages...)
+		return nil
+	})
+	sort.Strings(images)
+	var imageErrs ImageErrors
+	if err != nil && !errors.As(err, &imageErrs) {
+		return nil, err
+	}
+	return images, err
+}
+
+// WalkInventory calls f with the images of each repository of registry, see Inventory, as soon as its tags are
+// listed, so the images of registries with many repositories are not held in memory. The repositories of each page of
+// the catalog are listed concurrently, and f is not called concurrently. At most maxTags tags are listed per
+// repository if maxTags is positive, in the order of the registry, usually lexical. The repositories whose tags cannot
+// be listed are returned as ImageErrors once every repository has been walked. Walking stops at the first error of f,
+// which is returned.
+func (c RegistryClient) WalkInventory(ctx context.Context, registry string, maxTags int, f func(images []string) error) error {
+	var mu sync.Mutex
+	var errs ImageErrors
+	var walkErr error
+	err := c.WalkCatalog(ctx, registry, func(repositories []string) error {
+		list := make(ImageList, 0, len(repositories))
+		for _, repository := range repositories {
+			list = append(list, ImageEntry{Image: repository, OS: Linux})
+		}
+		c.forEach(list, func(entry *ImageEntry) {
+			var tags []string
+			err := c.withImage(ctx, registry+"/"+entry.Image, entry.OS, func(ref types.ImageReference, sys *types.SystemContext) error {
+				var err error
+				tags, err = c.listTags(ctx, sys, registry, entry.Image, maxTags)
+				return errors.Wrapf(err, "failed to list tags of repository %s", entry.Image)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if walkErr != nil {
+				return
+			}
+			if err != nil {
+				errs = append(errs, &ImageError{Image: entry.Image, OS: entry.OS, Err: err})
+				return
+			}
+			images := make([]string, 0, len(tags))
+			for _, tag := range tags {
+				images = append(images, entry.Image+":"+tag)
+			}
+			walkErr = f(images)
+		})
+		return walkErr
+	})
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Image < errs[j].Image
+		})
+		return errs
+	}
+	return nil
+}
+
+// listTags returns the tags of repository in registry, walking the pages of its tag list, at most maxTags tags if
+// maxTags is positive.
+func (c RegistryClient) listTags(ctx context.Context, sys *types.SystemContext, registry, repository string, maxTags int) ([]string, error) {
+	tagsClient, err := c.catalogClient(sys, registry, "repository:"+repository+":pull")
+	if err != nil {
+		return nil, err
+	}
+	pageSize := catalogPageSize
+	if maxTags > 0 && maxTags < pageSize {
+		pageSize = maxTags
+	}
+
+	var tags []string
+	next := "https://" + registry + "/v2/" + repository + "/tags/list?n=" + strconv.Itoa(pageSize)
+	for next != "" && (maxTags <= 0 || len(tags) < maxTags) {
+		resp, err := tagsClient.get(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse tag list")
+		}
+		tags = append(tags, page.Tags...)
+		if next, err = nextPage(next, resp); err != nil {
+			return nil, err
+		}
+	}
+	if maxTags > 0 && len(tags) > maxTags {
+		tags = tags[:maxTags]
+	}
+	return tags, nil
+}
+
+// catalogClient returns the client of the registry API requests of scope to registry, with the credentials of sys.
+func (c RegistryClient) catalogClient(sys *types.SystemContext, registry, scope string) (*catalogClient, error) {
+	auth, err := config.GetCredentials(sys, registry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get credentials of registry %s", registry)
+	}
+	return &catalogClient{client: c.httpClient(sys), auth: auth, scope: scope}, nil
+}
+
+// httpClient returns an HTTP client for the registry API requests containers/image does not support, configured by
+// TLS, or trusting the certificates of any registry if sys skips TLS verification.
+func (c RegistryClient) httpClient(sys *types.SystemContext) *http.Client {
+	if c.TLS != nil {
+		return c.TLS.Client()
+	}
+	if sys.DockerInsecureSkipTLSVerify != types.OptionalBoolTrue {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec // requested by the system context
+	return &http.Client{Transport: transport}
+}
+
+// catalogClient sends the requests listing a registry catalog or the tags of a repository, authenticating them as the
+// registry challenges them.
+type catalogClient struct {
+	client        *http.Client
+	auth          types.DockerAuthConfig
+	scope         string
+	authorization string
+}
+
+// get requests pageURL, authenticating the request if the registry requires it, and returns the response if it is
+// successful.
+func (c *catalogClient) get(ctx context.Context, pageURL string) (*http.Response, error) {
+	resp, err := c.do(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenges := challenge.ResponseChallenges(resp)
+		resp.Body.Close()
+		if err := c.authenticate(ctx, challenges); err != nil {
+			return nil, err
+		}
+		if resp, err = c.do(ctx, pageURL); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return nil, docker.ErrTooManyRequests
+		}
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func (c *catalogClient) do(ctx context.Context, pageURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.client.Do(req)
+}
+
+// authenticate sets the authorization answering challenges: the credentials for basic challenges, or a token of the
+// scope of the client for bearer challenges.
+func (c *catalogClient) authenticate(ctx context.Context, challenges []challenge.Challenge) error {
+	for _, ch := range challenges {
+		switch ch.Scheme {
+		case "basic":
+			if c.auth.Username == "" {
+				return errors.New("registry requires credentials")
+			}
+			c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(c.auth.Username+":"+c.auth.Password))
+			return nil
+		case "bearer":
+			token, _, err := requestBearerToken(ctx, c.client, ch.Parameters["realm"], ch.Parameters["service"], c.scope, c.auth)
+			if err != nil {
+				return err
+			}
+			c.authorization = "Bearer " + token
+			return nil
+		}
+	}
+	return errors.New("registry requires an unsupported authentication")
+}
+
+// nextPage returns the URL of the page following the page of resp at pageURL, from its Link header, or an empty string
+// for the last page.
+func nextPage(pageURL string, resp *http.Response) (string, error) {
+	for _, link := range linkheader.Parse(resp.Header.Get("Link")) {
+		if link.Rel != "next" {
+			continue
+		}
+		current, err := url.Parse(pageURL)
+		if err != nil {
+			return "", err
+		}
+		next, err := current.Parse(link.URL)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid next page %s", link.URL)
+		}
+		return next.String(), nil
+	}
+	return "", nil
+}
+
+// requestBearerToken requests a bearer token of scope, e.g. repository:rancher/shell:pull, from the token server at
+// realm, with the credentials of auth if any. It returns the token along with its lifetime.
+func requestBearerToken(ctx context.Context, client *http.Client, realm, service, scope string, auth types.DockerAuthConfig) (string, time.Duration, error) {
+	if realm == "" {
+		return "", 0, errors.New("missing realm in bearer challenge")
+	}
+	params := url.Values{}
+	if service != "" {
+		params.Set("service", service)
+	}
+	params.Set("scope", scope)
+	if auth.Username != "" {
+		params.Set("account", auth.Username)
+	}
+	separator := "?"
+	if strings.Contains(realm, "?") {
+		separator = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+separator+params.Encode(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if auth.Username != "" && auth.Password != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "failed to get token for %s", scope)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", 0, docker.ErrTooManyRequests
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, errors.Errorf("failed to get token for %s: %s", scope, resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", 0, errors.Wrapf(err, "failed to parse token for %s", scope)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	// Tokens without lifetime are valid for 60 seconds, see https://docs.docker.com/registry/spec/auth/token/
+	lifetime := 60 * time.Second
+	if body.ExpiresIn > 0 {
+		lifetime = time.Duration(body.ExpiresIn) * time.Second
+	}
+	return token, lifetime, nil
+}
@@ -0,0 +1,82 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestRegistryClientInventory(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	registry.Credentials = "admin:secret"
+	registry.CatalogPageSize = 2
+	registry.AddManifest("rancher/shell", "v0.1.22", schema2MediaType, schema2Manifest(100))
+	registry.AddManifest("rancher/shell", "v0.1.21", schema2MediaType, schema2Manifest(100))
+	registry.AddManifest("rancher/shell", "v0.1.20", schema2MediaType, schema2Manifest(100))
+	for _, repo := range []string{"rancher/a", "rancher/b", "rancher/c"} {
+		registry.AddManifest(repo, "v1", schema2MediaType, schema2Manifest(100))
+	}
+
+	client := registry.client()
+	_, err := client.Inventory(context.Background(), registry.Host())
+	assert.Error(err)
+
+	client.Credentials.Credentials = map[string]types.DockerAuthConfig{registry.Host(): {Username: "admin", Password: "secret"}}
+	repositories, err := client.Catalog(context.Background(), registry.Host())
+	assert.NoError(err)
+	assert.Equal([]string{"rancher/a", "rancher/b", "rancher/c", "rancher/shell"}, repositories)
+
+	images, err := client.Inventory(context.Background(), registry.Host())
+	assert.NoError(err)
+	assert.Equal([]string{"rancher/a:v1", "rancher/b:v1", "rancher/c:v1", "rancher/shell:v0.1.20", "rancher/shell:v0.1.21", "rancher/shell:v0.1.22"}, images)
+}
+
+func TestRegistryClientWalkInventory(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	registry.CatalogPageSize = 2
+	for _, tag := range []string{"v1", "v2", "v3", "v4", "v5"} {
+		registry.AddManifest("rancher/shell", tag, schema2MediaType, schema2Manifest(100))
+	}
+	for _, repo := range []string{"rancher/a", "rancher/b", "rancher/c"} {
+		registry.AddManifest(repo, "v1", schema2MediaType, schema2Manifest(100))
+	}
+	client := registry.client()
+
+	var pages [][]string
+	assert.NoError(client.WalkCatalog(context.Background(), registry.Host(), func(repositories []string) error {
+		pages = append(pages, repositories)
+		return nil
+	}))
+	assert.Equal([][]string{{"rancher/a", "rancher/b"}, {"rancher/c", "rancher/shell"}}, pages)
+
+	var images []string
+	assert.NoError(client.WalkInventory(context.Background(), registry.Host(), 3, func(repositoryImages []string) error {
+		images = append(images, repositoryImages...)
+		return nil
+	}))
+	sort.Strings(images)
+	assert.Equal([]string{"rancher/a:v1", "rancher/b:v1", "rancher/c:v1", "rancher/shell:v1", "rancher/shell:v2", "rancher/shell:v3"}, images)
+
+	images = nil
+	assert.NoError(client.WalkInventory(context.Background(), registry.Host(), 0, func(repositoryImages []string) error {
+		images = append(images, repositoryImages...)
+		return nil
+	}))
+	assert.Len(images, 8)
+
+	walks := 0
+	err := client.WalkInventory(context.Background(), registry.Host(), 0, func([]string) error {
+		walks++
+		return errors.New("disk full")
+	})
+	assert.EqualError(err, "disk full")
+	assert.Equal(1, walks)
+}
@@ -3,6 +3,7 @@
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -44,10 +45,19 @@ type Charts struct {
 	Config ExportConfig
 }
 
+func (c Charts) Name() string {
+	return "charts"
+}
+
 // FetchImages finds all the images used by all the charts in a Rancher charts repository and adds them to imageSet.
 // The images from the latest version of each chart are always added to the images set, whereas the remaining versions
 // are added only if the given Rancher version/tag satisfies the chart's Rancher version constraint annotation.
-func (c Charts) FetchImages(imagesSet map[string]map[string]struct{}) error {
+// Charts that cannot be scanned are skipped and returned as ChartErrors, unless the export is strict. Only the charts
+// Rancher installs by itself are scanned in core only exports, and charts of disabled features are skipped, as are the
+// charts supporting none of the exported architectures, see ExportConfig.Arches. The charts of the hosted provider
+// operators, of provisioning v2 and of the components pinned by the Rancher settings are left to HostedProviderCharts,
+// Provisioning and Components, see chartVersionsPinned.
+func (c Charts) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
 	if c.Config.ChartsPath == "" || c.Config.RancherVersion == "" {
 		return nil
 	}
@@ -55,12 +65,23 @@ func (c Charts) FetchImages(imagesSet map[string]map[string]struct{}) error {
 	if err != nil {
 		return err
 	}
+	chartErrs := chartErrorCollector{strict: c.Config.Strict}
 	// Filter index entries based on their Rancher version constraint
 	var filteredVersions repo.ChartVersions
 	for _, versions := range index.Entries {
 		if len(versions) == 0 {
 			continue
 		}
+		chartName := versions[0].Metadata.Name
+		if _, ok := requiredCharts[chartName]; c.Config.CoreOnly && !ok {
+			continue
+		}
+		if c.Config.chartDisabled(chartName, false) {
+			continue
+		}
+		if chartVersionsPinned(chartName) {
+			continue
+		}
 		// Always append the latest version of the chart
 		// Note: Selecting the correct latest version relies on the charts-build-scripts `make standardize` command
 		// sorting the versions in the index file in descending order correctly.
@@ -68,42 +89,132 @@ func (c Charts) FetchImages(imagesSet map[string]map[string]struct{}) error {
 		filteredVersions = append(filteredVersions, latestVersion)
 		// Append the remaining versions of the chart if the chart exists in the chartsToCheckConstraints map
 		// and the given Rancher version satisfies the chart's Rancher version constraint annotation.
-		chartName := versions[0].Metadata.Name
 		if _, ok := chartsToCheckConstraints[chartName]; ok {
 			for _, version := range versions[1:] {
-				if isConstraintSatisfied, err := c.checkChartVersionConstraint(*version); err != nil {
-					return errors.Wrapf(err, "failed to check constraint of chart")
+				if isConstraintSatisfied, err := c.checkChartVersionConstraint(ctx, *version); err != nil {
+					chartNameAndVersion := fmt.Sprintf("%s:%s", version.Name, version.Version)
+					if err := chartErrs.add(chartNameAndVersion, "index.yaml", errors.Wrapf(err, "failed to check constraint of chart")); err != nil {
+						return err
+					}
 				} else if isConstraintSatisfied {
 					filteredVersions = append(filteredVersions, version)
 				}
 			}
 		}
 	}
-	// Find values.yaml files in the tgz files of each chart, and check for images to add to imageSet
-	for _, version := range filteredVersions {
-		tgzPath := filepath.Join(c.Config.ChartsPath, version.URLs[0])
-		versionValues, err := decodeValuesFilesInTgz(tgzPath)
-		if err != nil {
-			logrus.Info(err)
+	if err := scanChartVersions(ctx, c.Config, filteredVersions, imagesSet, &chartErrs); err != nil {
+		return err
+	}
+	return chartErrs.err()
+}
+
+// chartVersionsPinned returns whether the versions of the chart called chartName that Rancher installs are chosen by
+// the Rancher version, in which case the chart is scanned by HostedProviderCharts, Provisioning or Components instead
+// of Charts.
+func chartVersionsPinned(chartName string) bool {
+	_, hostedProvider := hostedProviderCharts[chartName]
+	_, provisioning := provisioningCharts[chartName]
+	_, component := componentChartSettings[chartName]
+	return hostedProvider || provisioning || component
+}
+
+// scanChartVersions finds the values.yaml files in the tgz files of the given versions of the charts of the charts
+// repository of config, and adds the images they define to imagesSet. The charts that cannot be scanned are added to
+// chartErrs. The charts scanned before are read from the scan cache of ctx, if any, see WithScanCache.
+func scanChartVersions(ctx context.Context, config ExportConfig, versions repo.ChartVersions, imagesSet *ImageSet, chartErrs *chartErrorCollector) error {
+	charts := make([]chartToScan, 0, len(versions))
+	for _, version := range versions {
+		tgzPath := filepath.Join(config.ChartsPath, version.URLs[0])
+		tag, _ := chartsToIgnoreTags[version.Name]
+		charts = append(charts, chartToScan{
+			Name:        version.Name,
+			Version:     version.Version,
+			Annotations: version.Annotations,
+			URLs:        append([]string{version.Home}, version.Sources...),
+			Path:        version.URLs[0],
+			TagToIgnore: tag,
+			Scan: func(imagesSet *ImageSet, scan chartScan) error {
+				return scanCacheFromContext(ctx).scan(imagesSet, tgzPath, scan, func(imagesSet *ImageSet) error {
+					versionValues, err := decodeValuesFilesInTgz(ctx, tgzPath)
+					if err != nil {
+						return err
+					}
+					for _, values := range versionValues {
+						if err := pickImagesFromChartScan(imagesSet, values, scan); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+		})
+	}
+	return scanCharts(ctx, config, "chart", charts, imagesSet, chartErrs)
+}
+
+// chartToScan is a version of a chart or system chart to scan for images, see scanCharts.
+type chartToScan struct {
+	Name        string
+	Version     string
+	Annotations map[string]string
+	// URLs are the home and source URLs of the chart, see ImageSet.SetChartURLs.
+	URLs []string
+	// Path is the file the errors scanning the chart are reported at.
+	Path        string
+	TagToIgnore string
+	// Scan reads the values of the chart and adds the images they define to imagesSet, see pickImagesFromChartScan.
+	Scan func(imagesSet *ImageSet, scan chartScan) error
+}
+
+// scanCharts scans the given charts or system charts for images to add to imagesSet, kind naming them in the logs.
+// The charts supporting none of the exported architectures are skipped, and the charts that cannot be scanned are
+// added to chartErrs. It is shared by the charts and the system charts, so that both resolve the architectures and OS
+// types of their charts, report their progress and collect their errors the same way.
+func scanCharts(ctx context.Context, config ExportConfig, kind string, charts []chartToScan, imagesSet *ImageSet, chartErrs *chartErrorCollector) error {
+	progress := progressFromContext(ctx)
+	progress.setChartsTotal(len(charts))
+	for _, chart := range charts {
+		chartNameAndVersion := fmt.Sprintf("%s:%s", chart.Name, chart.Version)
+		chartArches := config.chartArches(chart.Name, chart.Annotations)
+		chartOSTypes := config.chartOSTypes(chart.Name, chart.Annotations)
+		if config.archUnsupported(chartArches) {
+			logrus.Infof("skipping %s %s, it does not support the exported architectures", kind, chartNameAndVersion)
+			progress.chartScanned(chartNameAndVersion)
 			continue
 		}
-		tag, _ := chartsToIgnoreTags[version.Name]
-		chartNameAndVersion := fmt.Sprintf("%s:%s", version.Name, version.Version)
-		for _, values := range versionValues {
-			if err = pickImagesFromValuesMap(imagesSet, values, chartNameAndVersion, c.Config.OsType, tag); err != nil {
+		imagesSet.SetChartURLs(chartNameAndVersion, chart.URLs...)
+		scan := chartScan{
+			Chart:        chartNameAndVersion,
+			TagToIgnore:  chart.TagToIgnore,
+			Arches:       chartArches,
+			OSTypes:      chartOSTypes,
+			ValuesLimits: valuesLimitsFromContext(ctx),
+		}
+		if err := chart.Scan(imagesSet, scan); err != nil {
+			// The errors of the files of a chart are already collected by Scan, and only returned in strict exports
+			if _, ok := err.(*ChartError); ok {
+				return err
+			}
+			if err := chartErrs.add(chartNameAndVersion, chart.Path, err); err != nil {
 				return err
 			}
 		}
+		progress.chartScanned(chartNameAndVersion)
 	}
 	return nil
 }
 
+// pickImagesFromChartScan adds the images defined by values, the values of the chart of scan, to imagesSet.
+func pickImagesFromChartScan(imagesSet *ImageSet, values map[interface{}]interface{}, scan chartScan) error {
+	return pickImagesFromValuesMap(imagesSet, values, scan.Chart, scan.TagToIgnore, scan.Arches, scan.OSTypes)
+}
+
 // checkChartVersionConstraint retrieves the value of a chart's Rancher version constraint annotation, and
 // returns true if the Rancher version in the export configuration satisfies the chart's constraint, false otherwise.
 // If a chart does not have a Rancher version annotation defined, this function returns false.
-func (c Charts) checkChartVersionConstraint(version repo.ChartVersion) (bool, error) {
+func (c Charts) checkChartVersionConstraint(ctx context.Context, version repo.ChartVersion) (bool, error) {
 	if constraintStr, ok := version.Annotations[RancherVersionAnnotationKey]; ok {
-		return compareRancherVersionToConstraint(c.Config.RancherVersion, constraintStr)
+		return compareRancherVersionToConstraint(ctx, c.Config.RancherVersion, constraintStr)
 	}
 	return false, nil
 }
@@ -112,6 +223,10 @@ type SystemCharts struct {
 	Config ExportConfig
 }
 
+func (sc SystemCharts) Name() string {
+	return "system charts"
+}
+
 type Questions struct {
 	RancherMinVersion string `yaml:"rancher_min_version"`
 	RancherMaxVersion string `yaml:"rancher_max_version"`
@@ -120,30 +235,41 @@ type Questions struct {
 // FetchImages finds all the images used by all the charts in a Rancher system charts repository and adds them to imageSet.
 // The images from the latest version of each chart are always added to the images set, whereas the remaining versions
 // are added only if the given Rancher version/tag satisfies the chart's Rancher version constraint defined in its questions file.
-func (sc SystemCharts) FetchImages(imagesSet map[string]map[string]struct{}) error {
+// Charts that cannot be scanned are skipped and returned as ChartErrors, unless the export is strict. Charts of
+// disabled features are skipped, as are the charts listed in ExportConfig.ChartArches without the exported
+// architectures.
+func (sc SystemCharts) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
 	if sc.Config.SystemChartsPath == "" || sc.Config.RancherVersion == "" {
 		return nil
 	}
-	// Load system charts virtual index
+	// Load system charts virtual index, only listing the files of the chart versions that are scanned
 	helm := libhelm.Helm{
 		LocalPath: sc.Config.SystemChartsPath,
 		IconPath:  sc.Config.SystemChartsPath,
 		Hash:      "",
+		LazyIndex: true,
 	}
 	virtualIndex, err := helm.LoadIndex()
 	if err != nil {
 		return errors.Wrapf(err, "failed to load system charts index")
 	}
+	chartErrs := chartErrorCollector{strict: sc.Config.Strict}
 	// Filter index entries based on their Rancher version constraint
 	var filteredVersions libhelm.ChartVersions
 	for _, versions := range virtualIndex.IndexFile.Entries {
 		if len(versions) == 0 {
 			continue
 		}
+		if sc.Config.chartDisabled(versions[0].ChartMetadata.Name, true) {
+			continue
+		}
 		// Always append the latest version of the chart unless it has been intentionally hidden with constraints
 		latestVersion := versions[0]
-		if isConstraintSatisfied, err := sc.checkChartVersionConstraint(*latestVersion); err != nil {
-			return errors.Wrapf(err, "failed to filter chart versions")
+		if isConstraintSatisfied, err := sc.checkChartVersionConstraint(ctx, *latestVersion); err != nil {
+			chartNameAndVersion := fmt.Sprintf("%s:%s", latestVersion.Name, latestVersion.Version)
+			if err := chartErrs.add(chartNameAndVersion, latestVersion.Dir, errors.Wrapf(err, "failed to filter chart versions")); err != nil {
+				return err
+			}
 		} else if isConstraintSatisfied {
 			filteredVersions = append(filteredVersions, latestVersion)
 		}
@@ -152,8 +278,11 @@ func (sc SystemCharts) FetchImages(imagesSet map[string]map[string]struct{}) err
 		chartName := versions[0].ChartMetadata.Name
 		if _, ok := systemChartsToCheckConstraints[chartName]; ok {
 			for _, version := range versions[1:] {
-				if isConstraintSatisfied, err := sc.checkChartVersionConstraint(*version); err != nil {
-					return errors.Wrapf(err, "failed to filter chart versions")
+				if isConstraintSatisfied, err := sc.checkChartVersionConstraint(ctx, *version); err != nil {
+					chartNameAndVersion := fmt.Sprintf("%s:%s", version.Name, version.Version)
+					if err := chartErrs.add(chartNameAndVersion, version.Dir, errors.Wrapf(err, "failed to filter chart versions")); err != nil {
+						return err
+					}
 				} else if isConstraintSatisfied {
 					filteredVersions = append(filteredVersions, version)
 				}
@@ -161,59 +290,94 @@ func (sc SystemCharts) FetchImages(imagesSet map[string]map[string]struct{}) err
 		}
 	}
 	// Find values.yaml files in each chart's local files, and check for images to add to imageSet
+	charts := make([]chartToScan, 0, len(filteredVersions))
 	for _, version := range filteredVersions {
-		for _, file := range version.LocalFiles {
-			if !isValuesFile(file) {
-				continue
-			}
-			values, err := decodeValuesFile(file)
-			if err != nil {
-				return err
-			}
-			tag, _ := systemChartsToIgnoreTags[version.Name]
-			chartNameAndVersion := fmt.Sprintf("%s:%s", version.Name, version.Version)
-			if err = pickImagesFromValuesMap(imagesSet, values, chartNameAndVersion, sc.Config.OsType, tag); err != nil {
-				return err
-			}
-		}
+		version := version
+		tag, _ := systemChartsToIgnoreTags[version.Name]
+		charts = append(charts, chartToScan{
+			Name:        version.Name,
+			Version:     version.Version,
+			URLs:        append([]string{version.Home}, version.Sources...),
+			Path:        version.Dir,
+			TagToIgnore: tag,
+			Scan: func(imagesSet *ImageSet, scan chartScan) error {
+				if err := helm.ResolveLocalFiles(version); err != nil {
+					return errors.Wrapf(err, "failed to list chart files")
+				}
+				for _, file := range version.LocalFiles {
+					if !isValuesFile(file) {
+						continue
+					}
+					values, err := decodeValuesFile(ctx, file)
+					if err != nil {
+						if err := chartErrs.add(scan.Chart, file, err); err != nil {
+							return err
+						}
+						continue
+					}
+					if err := pickImagesFromChartScan(imagesSet, values, scan); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
 	}
-	return nil
+	if err := scanCharts(ctx, sc.Config, "system chart", charts, imagesSet, &chartErrs); err != nil {
+		return err
+	}
+	return chartErrs.err()
 }
 
 // checkChartVersionConstraint retrieves the value of a chart's Rancher version defined in its questions file, and
 // returns true if the Rancher version in the export configuration satisfies the chart's constraint, false otherwise.
 // If a chart does not have a Rancher version constraint defined, this function returns false.
-func (sc SystemCharts) checkChartVersionConstraint(version libhelm.ChartVersion) (bool, error) {
+func (sc SystemCharts) checkChartVersionConstraint(ctx context.Context, version libhelm.ChartVersion) (bool, error) {
 	questionsPath := filepath.Join(sc.Config.SystemChartsPath, version.Dir, "questions.yaml")
-	questions, err := decodeQuestionsFile(questionsPath)
+	questions, err := decodeQuestionsFile(ctx, questionsPath)
 	if os.IsNotExist(err) {
 		questionsPath = filepath.Join(sc.Config.SystemChartsPath, version.Dir, "questions.yml")
-		questions, err = decodeQuestionsFile(questionsPath)
+		questions, err = decodeQuestionsFile(ctx, questionsPath)
 	}
-	if err != nil {
+	if os.IsNotExist(err) {
 		logrus.Warnf("skipping system chart, %s:%s does not have a questions file", version.ChartMetadata.Name, version.ChartMetadata.Version)
 		return false, nil
 	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read %s", questionsPath)
+	}
 	constraintStr := minMaxToConstraintStr(questions.RancherMinVersion, questions.RancherMaxVersion)
 	if constraintStr == "" {
 		return false, nil
 	}
-	return compareRancherVersionToConstraint(sc.Config.RancherVersion, constraintStr)
+	return compareRancherVersionToConstraint(ctx, sc.Config.RancherVersion, constraintStr)
 }
 
-// compareRancherVersionToConstraint returns true if the Rancher version satisfies constraintStr, false otherwise.
-func compareRancherVersionToConstraint(rancherVersion, constraintStr string) (bool, error) {
+// compareRancherVersionToConstraint returns true if the Rancher version satisfies constraintStr, false otherwise. The
+// Rancher version and the constraint are parsed once per export if ctx carries a constraint cache, see
+// withConstraintCache.
+func compareRancherVersionToConstraint(ctx context.Context, rancherVersion, constraintStr string) (bool, error) {
 	if constraintStr == "" {
 		return false, errors.Errorf("Invalid constraint string: \"%s\"", constraintStr)
 	}
-	constraint, err := semver.NewConstraint(constraintStr)
+	cache := constraintCacheFromContext(ctx)
+	constraint, err := cache.parseConstraint(constraintStr)
 	if err != nil {
 		return false, err
 	}
-	rancherSemVer, err := semver.NewVersion(rancherVersion)
+	rSemVer, err := cache.parseRancherVersion(rancherVersion)
 	if err != nil {
 		return false, err
 	}
+	return constraint.Check(rSemVer), nil
+}
+
+// constraintRancherVersion parses rancherVersion into the version it is compared to chart constraints as.
+func constraintRancherVersion(rancherVersion string) (*semver.Version, error) {
+	rancherSemVer, err := semver.NewVersion(rancherVersion)
+	if err != nil {
+		return nil, err
+	}
 	// When the exporter is ran in a dev environment, we replace the rancher version with a dev version (e.g 2.X.99).
 	// This breaks the semver compare logic for exporting because we use the Rancher version constraint < 2.X.99-0 in
 	// many of our charts and since 2.X.99 > 2.X.99-0 the comparison returns false which is not the desired behavior.
@@ -225,11 +389,7 @@ func compareRancherVersionToConstraint(rancherVersion, constraintStr string) (bo
 	// the constraint has a pre-release too. Since the exporter for charts can treat pre-releases and releases equally,
 	// is cleaner to remove it. E.g. comparing rancherVersion 2.6.4-rc1 and constraint 2.6.3 - 2.6.5 yields false because
 	// the versions in the contraint do not have a pre-release. This behavior comes from the semver module and is intentional.
-	rSemVer, err := semver.NewVersion(fmt.Sprintf("%d.%d.%d", rancherSemVer.Major(), rancherSemVer.Minor(), patch))
-	if err != nil {
-		return false, err
-	}
-	return constraint.Check(rSemVer), nil
+	return semver.NewVersion(fmt.Sprintf("%d.%d.%d", rancherSemVer.Major(), rancherSemVer.Minor(), patch))
 }
 
 // minMaxToConstraintStr converts min and max Rancher version strings into a constraint string
@@ -247,9 +407,13 @@ func minMaxToConstraintStr(min, max string) string {
 	return ""
 }
 
-// pickImagesFromValuesMap walks a values map to find images, and add them to imagesSet.
-func pickImagesFromValuesMap(imagesSet map[string]map[string]struct{}, values map[interface{}]interface{}, chartNameAndVersion string, osType OSType, tagToIgnore string) error {
-	walkMap(values, func(inputMap map[interface{}]interface{}) {
+// pickImagesFromValuesMap walks a values map to find images, and add them to imagesSet for each OS and architecture
+// they declare along with the key path of the values defining them. The architectures of the images are limited to
+// chartArches, the architectures supported by the chart, if any, and the images are exported for chartOSTypes instead
+// of the OS types they declare if any is given. It is the only way images are extracted from chart values, for the
+// charts and the system charts alike, so that both handle the os and arch fields the same way.
+func pickImagesFromValuesMap(imagesSet *ImageSet, values map[interface{}]interface{}, chartNameAndVersion string, tagToIgnore string, chartArches []Arch, chartOSTypes []OSType) error {
+	walkMap(values, func(inputMap map[interface{}]interface{}, valuesPath string) {
 		repository, ok := inputMap["repository"].(string)
 		if !ok {
 			return
@@ -263,36 +427,84 @@ func pickImagesFromValuesMap(imagesSet map[string]map[string]struct{}, values ma
 			return
 		}
 		imageName := fmt.Sprintf("%s:%v", repository, tag)
+		warn := func(format string, args ...interface{}) {
+			imagesSet.AddChartWarning(ChartWarning{
+				Chart:      chartNameAndVersion,
+				Image:      imageName,
+				ValuesPath: valuesPath,
+				Message:    fmt.Sprintf(format, args...),
+			})
+		}
+		// Images are exported for every architecture unless they are restricted to some of them with a
+		// comma-delineated list (e.g. "arch: amd64" or "arch: amd64,arm64"). Unknown architectures are ignored, and
+		// images listing none that is known are exported for every architecture rather than being dropped.
+		var valuesArches []Arch
+		switch archList := inputMap["arch"].(type) {
+		case nil:
+		case string:
+			for _, name := range strings.Split(archList, ",") {
+				if strings.TrimSpace(name) == "" {
+					continue
+				}
+				if arch, err := ParseArch(name); err == nil {
+					valuesArches = append(valuesArches, arch)
+				} else {
+					warn("ignoring unknown architecture %q of field 'arch:'", strings.TrimSpace(name))
+				}
+			}
+		default:
+			warn("field 'arch:' contains neither a string nor nil, exporting the image for every architecture")
+		}
+		arches, ok := intersectArches(valuesArches, chartArches)
+		if !ok {
+			return
+		}
+		// The OS types forced for the whole chart override the OS hints of its values
+		if len(chartOSTypes) > 0 {
+			for _, osType := range chartOSTypes {
+				imagesSet.AddChartImageForArches(osType, imageName, chartNameAndVersion, valuesPath, arches...)
+			}
+			return
+		}
 		// By default, images are added to the generic images list ("linux"). For Windows and multi-OS
 		// images to be considered, they must use a comma-delineated list (e.g. "os: windows",
-		// "os: windows,linux", and "os: linux,windows").
+		// "os: windows,linux", and "os: linux,windows"). Unknown OS types are ignored.
 		osList, ok := inputMap["os"].(string)
 		if !ok {
 			if inputMap["os"] != nil {
-				errors.Errorf("field 'os:' for image %s contains neither a string nor nil", imageName)
-			}
-			if osType == Linux {
-				addSourceToImage(imagesSet, imageName, chartNameAndVersion)
-				return
+				warn("field 'os:' contains neither a string nor nil, exporting the image for linux")
 			}
+			imagesSet.AddChartImageForArches(Linux, imageName, chartNameAndVersion, valuesPath, arches...)
+			return
 		}
-		for _, os := range strings.Split(osList, ",") {
-			os = strings.TrimSpace(os)
-			if strings.EqualFold("windows", os) && osType == Windows {
-				addSourceToImage(imagesSet, imageName, chartNameAndVersion)
-				return
+		for _, name := range strings.Split(osList, ",") {
+			if strings.TrimSpace(name) == "" {
+				continue
 			}
-			if strings.EqualFold("linux", os) && osType == Linux {
-				addSourceToImage(imagesSet, imageName, chartNameAndVersion)
-				return
+			osType, err := ParseOSType(name)
+			if err != nil {
+				warn("ignoring unknown os %q of field 'os:'", strings.TrimSpace(name))
+				continue
 			}
+			imagesSet.AddChartImageForArches(osType, imageName, chartNameAndVersion, valuesPath, arches...)
 		}
 	})
 	return nil
 }
 
 // decodeValueFilesInTgz reads tarball in tgzPath and returns a slice of values corresponding to values.yaml files found inside of it.
-func decodeValuesFilesInTgz(tgzPath string) ([]map[interface{}]interface{}, error) {
+// The tarball is only read once per export, see decodeCache.
+func decodeValuesFilesInTgz(ctx context.Context, tgzPath string) ([]map[interface{}]interface{}, error) {
+	valuesSlice, err := decodeCacheFromContext(ctx).decode(tgzPath, func() (interface{}, error) {
+		return readValuesFilesInTgz(tgzPath, valuesLimitsFromContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return valuesSlice.([]map[interface{}]interface{}), nil
+}
+
+func readValuesFilesInTgz(tgzPath string, limits ValuesLimits) ([]map[interface{}]interface{}, error) {
 	tgz, err := os.Open(tgzPath)
 	if err != nil {
 		return nil, err
@@ -313,9 +525,9 @@ func decodeValuesFilesInTgz(tgzPath string) ([]map[interface{}]interface{}, erro
 		case err != nil:
 			return nil, err
 		case header.Typeflag == tar.TypeReg && isValuesFile(header.Name):
-			var values map[interface{}]interface{}
-			if err := decodeYAMLFile(tr, &values); err != nil {
-				return nil, err
+			values, err := decodeValues(tr, limits)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to decode %s", header.Name)
 			}
 			valuesSlice = append(valuesSlice, values)
 		default:
@@ -324,22 +536,42 @@ func decodeValuesFilesInTgz(tgzPath string) ([]map[interface{}]interface{}, erro
 	}
 }
 
-// walkMap walks inputMap and calls the callback function on all map type nodes including the root node.
-func walkMap(inputMap interface{}, callback func(map[interface{}]interface{})) {
+// walkMap walks inputMap and calls the callback function on all map type nodes including the root node, along with
+// the key path of the node, e.g. fluentd.image or images[0]. The key path of the root node is empty.
+func walkMap(inputMap interface{}, callback func(map[interface{}]interface{}, string)) {
+	walkMapPath(inputMap, "", callback)
+}
+
+func walkMapPath(inputMap interface{}, path string, callback func(map[interface{}]interface{}, string)) {
 	switch data := inputMap.(type) {
 	case map[interface{}]interface{}:
-		callback(data)
-		for _, value := range data {
-			walkMap(value, callback)
+		callback(data, path)
+		for key, value := range data {
+			keyPath := fmt.Sprintf("%v", key)
+			if path != "" {
+				keyPath = path + "." + keyPath
+			}
+			walkMapPath(value, keyPath, callback)
 		}
 	case []interface{}:
-		for _, elem := range data {
-			walkMap(elem, callback)
+		for i, elem := range data {
+			walkMapPath(elem, fmt.Sprintf("%s[%d]", path, i), callback)
 		}
 	}
 }
 
-func decodeQuestionsFile(path string) (Questions, error) {
+// decodeQuestionsFile decodes the questions file at path, only once per export, see decodeCache.
+func decodeQuestionsFile(ctx context.Context, path string) (Questions, error) {
+	questions, err := decodeCacheFromContext(ctx).decode(path, func() (interface{}, error) {
+		return readQuestionsFile(path)
+	})
+	if err != nil {
+		return Questions{}, err
+	}
+	return questions.(Questions), nil
+}
+
+func readQuestionsFile(path string) (Questions, error) {
 	var questions Questions
 	file, err := os.Open(path)
 	if err != nil {
@@ -352,17 +584,24 @@ func decodeQuestionsFile(path string) (Questions, error) {
 	return questions, nil
 }
 
-func decodeValuesFile(path string) (map[interface{}]interface{}, error) {
-	var values map[interface{}]interface{}
-	file, err := os.Open(path)
+// decodeValuesFile decodes the values file at path, only once per export, see decodeCache.
+func decodeValuesFile(ctx context.Context, path string) (map[interface{}]interface{}, error) {
+	values, err := decodeCacheFromContext(ctx).decode(path, func() (interface{}, error) {
+		return readValuesFile(path, valuesLimitsFromContext(ctx))
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	if err := decodeYAMLFile(file, &values); err != nil {
+	return values.(map[interface{}]interface{}), nil
+}
+
+func readValuesFile(path string, limits ValuesLimits) (map[interface{}]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
 		return nil, err
 	}
-	return values, nil
+	defer file.Close()
+	return decodeValues(file, limits)
 }
 
 func decodeYAMLFile(r io.Reader, target interface{}) error {
@@ -1,6 +1,10 @@
 package image
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	assertlib "github.com/stretchr/testify/assert"
@@ -125,15 +129,276 @@ func TestPickImagesFromValuesMap(t *testing.T) {
 	}
 	assert := assertlib.New(t)
 	for _, tc := range testCases {
-		actualImagesSet := make(map[string]map[string]struct{})
-		err := pickImagesFromValuesMap(actualImagesSet, tc.values, tc.chartNameAndVersion, tc.osType, tc.tagToIgnore)
+		actualImagesSet := NewImageSet(tc.osType)
+		err := pickImagesFromValuesMap(actualImagesSet, tc.values, tc.chartNameAndVersion, tc.tagToIgnore, nil, nil)
 		if err != nil {
 			t.Errorf("unexpected error: %s", err)
 		}
-		assert.Equalf(tc.expectedImagesSet, actualImagesSet, "testcase: %s", tc.description)
+		assert.Equalf(tc.expectedImagesSet, imageSetToMap(actualImagesSet, tc.osType), "testcase: %s", tc.description)
 	}
 }
 
+func TestPickImagesFromValuesMapValuesPaths(t *testing.T) {
+	values := map[interface{}]interface{}{
+		"repository": "rancher/root",
+		"tag":        "v1.0.0",
+		"fluentd": map[interface{}]interface{}{
+			"image": map[interface{}]interface{}{
+				"repository": "rancher/fluentd",
+				"tag":        "v0.1.0",
+			},
+		},
+		"sidecars": []interface{}{
+			map[interface{}]interface{}{
+				"repository": "rancher/sidecar",
+				"tag":        "v0.2.0",
+			},
+		},
+	}
+	imagesSet := NewImageSet(Linux)
+	assertlib.NoError(t, pickImagesFromValuesMap(imagesSet, values, "chart:0.1.2", "", nil, nil))
+
+	valuesPaths := make(map[string]map[string][]string)
+	for _, entry := range imagesSet.List(Linux) {
+		valuesPaths[entry.Image] = entry.ValuesPaths
+	}
+	assertlib.Equal(t, map[string]map[string][]string{
+		"rancher/root:v1.0.0":    nil,
+		"rancher/fluentd:v0.1.0": {"chart:0.1.2": {"fluentd.image"}},
+		"rancher/sidecar:v0.2.0": {"chart:0.1.2": {"sidecars[0]"}},
+	}, valuesPaths)
+}
+
+func TestPickImagesFromValuesMapArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	values := map[interface{}]interface{}{
+		"amd64": map[interface{}]interface{}{
+			"repository": "rancher/amd64",
+			"tag":        "v1.0.0",
+			"arch":       "amd64",
+		},
+		"multi": map[interface{}]interface{}{
+			"repository": "rancher/multi",
+			"tag":        "v1.0.0",
+			"os":         "linux,windows",
+			"arch":       "arm64, amd64",
+		},
+		"unknown": map[interface{}]interface{}{
+			"repository": "rancher/unknown",
+			"tag":        "v1.0.0",
+			"arch":       "mips64le",
+		},
+		"power": map[interface{}]interface{}{
+			"repository": "rancher/power",
+			"tag":        "v1.0.0",
+			"arch":       "s390x,ppc64le",
+		},
+		"any": map[interface{}]interface{}{
+			"repository": "rancher/any",
+			"tag":        "v1.0.0",
+		},
+	}
+	imagesSet := NewImageSet(Linux, Windows)
+	assert.NoError(pickImagesFromValuesMap(imagesSet, values, "chart:0.1.2", "", nil, nil))
+
+	assert.Equal([]Arch{AMD64}, imagesSet.Arches(Linux, "rancher/amd64:v1.0.0"))
+	assert.Equal([]Arch{AMD64, ARM64}, imagesSet.Arches(Linux, "rancher/multi:v1.0.0"))
+	assert.Equal([]Arch{AMD64, ARM64}, imagesSet.Arches(Windows, "rancher/multi:v1.0.0"))
+	assert.Nil(imagesSet.Arches(Linux, "rancher/unknown:v1.0.0"))
+	assert.Equal([]Arch{S390X, PPC64LE}, imagesSet.Arches(Linux, "rancher/power:v1.0.0"))
+	assert.Equal([]string{"rancher/any:v1.0.0", "rancher/power:v1.0.0", "rancher/unknown:v1.0.0"}, imagesSet.ImagesForArch(Linux, S390X))
+	assert.Equal([]string{"rancher/any:v1.0.0", "rancher/multi:v1.0.0", "rancher/unknown:v1.0.0"}, imagesSet.ImagesForArch(Linux, ARM64))
+}
+
+func TestPickImagesFromValuesMapChartArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	values := map[interface{}]interface{}{
+		"amd64": map[interface{}]interface{}{
+			"repository": "rancher/amd64",
+			"tag":        "v1.0.0",
+			"arch":       "amd64",
+		},
+		"arm64": map[interface{}]interface{}{
+			"repository": "rancher/arm64",
+			"tag":        "v1.0.0",
+			"arch":       "arm64",
+		},
+		"any": map[interface{}]interface{}{
+			"repository": "rancher/any",
+			"tag":        "v1.0.0",
+		},
+	}
+	imagesSet := NewImageSet(Linux)
+	assert.NoError(pickImagesFromValuesMap(imagesSet, values, "chart:0.1.2", "", []Arch{AMD64}, nil))
+
+	assert.Equal([]string{"rancher/amd64:v1.0.0", "rancher/any:v1.0.0"}, imagesSet.Images(Linux))
+	assert.Equal([]Arch{AMD64}, imagesSet.Arches(Linux, "rancher/any:v1.0.0"))
+}
+
+func TestPickImagesFromValuesMapChartOSTypes(t *testing.T) {
+	assert := assertlib.New(t)
+
+	values := map[interface{}]interface{}{
+		"agent": map[interface{}]interface{}{
+			"repository": "rancher/agent",
+			"tag":        "v1.0.0",
+			"os":         "windows",
+		},
+		"malformed": map[interface{}]interface{}{
+			"repository": "rancher/malformed",
+			"tag":        "v1.0.0",
+			"os":         []interface{}{"windows"},
+		},
+		"linux": map[interface{}]interface{}{
+			"repository": "rancher/linux",
+			"tag":        "v1.0.0",
+		},
+	}
+	imagesSet := NewImageSet(Linux, Windows)
+	assert.NoError(pickImagesFromValuesMap(imagesSet, values, "chart:0.1.2", "", nil, []OSType{Linux}))
+
+	assert.Equal([]string{"rancher/agent:v1.0.0", "rancher/linux:v1.0.0", "rancher/malformed:v1.0.0"}, imagesSet.Images(Linux))
+	assert.Empty(imagesSet.Images(Windows))
+	// The OS hints are not checked since they are overridden
+	assert.Empty(imagesSet.ChartWarnings())
+
+	imagesSet = NewImageSet(Linux, Windows)
+	assert.NoError(pickImagesFromValuesMap(imagesSet, values, "chart:0.1.2", "", nil, []OSType{Windows}))
+	assert.Empty(imagesSet.Images(Linux))
+	assert.Equal([]string{"rancher/agent:v1.0.0", "rancher/linux:v1.0.0", "rancher/malformed:v1.0.0"}, imagesSet.Images(Windows))
+}
+
+func TestExportConfigChartOSTypes(t *testing.T) {
+	assert := assertlib.New(t)
+
+	annotations := map[string]string{ImagesOSAnnotationKey: "linux, unknown"}
+	config := ExportConfig{}
+	assert.Equal([]OSType{Linux}, config.chartOSTypes("rancher-monitoring", annotations))
+	assert.Nil(config.chartOSTypes("rancher-monitoring", nil))
+	config.ChartOSTypes = map[string][]OSType{"rancher-monitoring": {Linux, Windows}}
+	assert.Equal([]OSType{Linux, Windows}, config.chartOSTypes("rancher-monitoring", annotations))
+}
+
+const archChartsIndex = `apiVersion: v1
+entries:
+  amd64-only:
+  - name: amd64-only
+    version: 1.0.0
+    annotations:
+      catalog.cattle.io/permits-arch: amd64
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+  unannotated:
+  - name: unannotated
+    version: 1.0.0
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+`
+
+func TestChartsFetchImagesSkipsUnsupportedArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	// The charts point to a broken tarball, so the charts that are scanned are reported as chart errors
+	chartsPath := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "index.yaml"), []byte(archChartsIndex), 0644))
+	assert.NoError(os.MkdirAll(filepath.Join(chartsPath, "assets", "broken"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "assets", "broken", "broken-1.0.0.tgz"), []byte("not a tarball"), 0644))
+
+	scannedCharts := func(config ExportConfig) []string {
+		var chartErrs ChartErrors
+		assert.ErrorAs(Charts{config}.FetchImages(context.Background(), NewImageSet(Linux)), &chartErrs)
+		var charts []string
+		for _, chartErr := range chartErrs {
+			charts = append(charts, chartErr.Chart)
+		}
+		sort.Strings(charts)
+		return charts
+	}
+	config := ExportConfig{ChartsPath: chartsPath, RancherVersion: "2.8.0"}
+	assert.Equal([]string{"amd64-only:1.0.0", "unannotated:1.0.0"}, scannedCharts(config))
+	config.Arches = []Arch{ARM64}
+	assert.Equal([]string{"unannotated:1.0.0"}, scannedCharts(config))
+	config.ChartArches = map[string][]Arch{"amd64-only": {AMD64, ARM64}, "unannotated": {AMD64}}
+	assert.Equal([]string{"amd64-only:1.0.0"}, scannedCharts(config))
+}
+
+func TestSystemChartsFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	systemChartsPath := t.TempDir()
+	files := map[string]string{
+		"charts/good/v0.1.0/Chart.yaml":       "name: good\nversion: 0.1.0\n",
+		"charts/good/v0.1.0/questions.yaml":   "rancher_min_version: 2.7.0\n",
+		"charts/good/v0.1.0/values.yaml":      "image:\n  repository: rancher/good\n  tag: v1.0.0\n",
+		"charts/broken/v0.1.0/Chart.yaml":     "name: broken\nversion: 0.1.0\n",
+		"charts/broken/v0.1.0/questions.yaml": "rancher_min_version: 2.7.0\n",
+		"charts/broken/v0.1.0/values.yaml":    "image: [\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(systemChartsPath, filepath.FromSlash(name))
+		assert.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(os.WriteFile(path, []byte(content), 0644))
+	}
+
+	// The values files that cannot be decoded are reported once, without skipping the other charts
+	config := ExportConfig{SystemChartsPath: systemChartsPath, RancherVersion: "2.8.0"}
+	imagesSet := NewImageSet(Linux)
+	var chartErrs ChartErrors
+	assert.ErrorAs(SystemCharts{config}.FetchImages(context.Background(), imagesSet), &chartErrs)
+	if assert.Len(chartErrs, 1) {
+		assert.Equal("broken:0.1.0", chartErrs[0].Chart)
+	}
+	assert.Equal([]string{"rancher/good:v1.0.0"}, imagesSet.ImagesForArch(Linux, AMD64))
+
+	// Strict exports return the error of the values file as it is
+	config.Strict = true
+	var chartErr *ChartError
+	if assert.ErrorAs(SystemCharts{config}.FetchImages(context.Background(), NewImageSet(Linux)), &chartErr) {
+		assert.Equal("broken:0.1.0", chartErr.Chart)
+		_, nested := chartErr.Err.(*ChartError)
+		assert.False(nested)
+	}
+}
+
+func TestPickImagesFromValuesMapWarnings(t *testing.T) {
+	assert := assertlib.New(t)
+
+	values := map[interface{}]interface{}{
+		"unknownOS": map[interface{}]interface{}{
+			"repository": "rancher/unknown-os",
+			"tag":        "v1.0.0",
+			"os":         "linux, linx,",
+		},
+		"malformedOS": map[interface{}]interface{}{
+			"repository": "rancher/malformed-os",
+			"tag":        "v1.0.0",
+			"os":         []interface{}{"windows"},
+		},
+		"unknownArch": map[interface{}]interface{}{
+			"repository": "rancher/unknown-arch",
+			"tag":        "v1.0.0",
+			"arch":       "amd64,mips64le",
+		},
+	}
+	imagesSet := NewImageSet(Linux, Windows)
+	assert.NoError(pickImagesFromValuesMap(imagesSet, values, "chart:0.1.2", "", nil, nil))
+
+	assert.Equal([]string{"rancher/malformed-os:v1.0.0", "rancher/unknown-arch:v1.0.0", "rancher/unknown-os:v1.0.0"}, imagesSet.Images(Linux))
+	assert.Empty(imagesSet.Images(Windows))
+	warnings := imagesSet.ChartWarnings()
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].Image < warnings[j].Image
+	})
+	assert.Equal([]ChartWarning{
+		{Chart: "chart:0.1.2", Image: "rancher/malformed-os:v1.0.0", ValuesPath: "malformedOS", Message: "field 'os:' contains neither a string nor nil, exporting the image for linux"},
+		{Chart: "chart:0.1.2", Image: "rancher/unknown-arch:v1.0.0", ValuesPath: "unknownArch", Message: `ignoring unknown architecture "mips64le" of field 'arch:'`},
+		{Chart: "chart:0.1.2", Image: "rancher/unknown-os:v1.0.0", ValuesPath: "unknownOS", Message: `ignoring unknown os "linx" of field 'os:'`},
+	}, warnings)
+	assert.Equal(`chart chart:0.1.2, image rancher/unknown-os:v1.0.0 (unknownOS): ignoring unknown os "linx" of field 'os:'`, warnings[2].String())
+}
+
 func TestMinMaxToConstraintStr(t *testing.T) {
 	testCases := []struct {
 		min      string
@@ -182,7 +447,7 @@ func TestCompareRancherVersionToConstraint(t *testing.T) {
 	}
 	assert := assertlib.New(t)
 	for _, tc := range testCases {
-		actual, err := compareRancherVersionToConstraint(tc.rancherVersion, tc.constraintStr)
+		actual, err := compareRancherVersionToConstraint(context.Background(), tc.rancherVersion, tc.constraintStr)
 		if err != nil {
 			if tc.isErr {
 				assert.Error(err)
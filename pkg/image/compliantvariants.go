@@ -0,0 +1,204 @@
+package image
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// VariantMode is how an image list is converted to the compliant variants of its images, see CompliantVariants.
+type VariantMode string
+
+const (
+	// VariantModeSwap replaces the images by their compliant variants, and keeps the images without variant.
+	VariantModeSwap VariantMode = "swap"
+	// VariantModeFilter replaces the images by their compliant variants, and drops the images without variant.
+	VariantModeFilter VariantMode = "filter"
+)
+
+// ParseVariantMode parses a variant mode, VariantModeSwap if empty.
+func ParseVariantMode(value string) (VariantMode, error) {
+	switch VariantMode(value) {
+	case "", VariantModeSwap:
+		return VariantModeSwap, nil
+	case VariantModeFilter:
+		return VariantModeFilter, nil
+	}
+	return "", errors.Errorf("invalid variant mode %q, must be %s or %s", value, VariantModeSwap, VariantModeFilter)
+}
+
+// CompliantVariants maps images to their FIPS-compliant or hardened variants, for the image lists of hardened
+// environments. It is loaded from a YAML file like:
+//
+//	variants:
+//	  rancher/mirrored-coredns-coredns: rancher/hardened-coredns:v{tag}-build20230406
+//	  rancher/shell:v0.1.22: rancher/shell:v0.1.22-fips
+//	compliant:
+//	- rancher/hardened-*
+//	- regex:.*-fips$
+type CompliantVariants struct {
+	// Variants are the compliant variants of images, keyed by image or by repository for all the tags of a repository.
+	// The {tag} placeholder of the variants of repositories is replaced by the tag of the image. The variants of images
+	// take precedence over the variants of their repositories.
+	Variants map[string]string `yaml:"variants"`
+	// Compliant are the patterns of the images that are compliant as is, e.g. the hardened images, see ImageFilter.
+	Compliant []string `yaml:"compliant"`
+
+	compliant *ImageFilter
+}
+
+// LoadCompliantVariants reads the compliant variants file at path.
+func LoadCompliantVariants(path string) (*CompliantVariants, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var variants CompliantVariants
+	if err := decodeYAMLFile(file, &variants); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode compliant variants file %s", path)
+	}
+	for image, variant := range variants.Variants {
+		if image == "" || variant == "" {
+			return nil, errors.Errorf("invalid compliant variants file %s: empty image or variant", path)
+		}
+	}
+	if variants.compliant, err = NewImageFilter(variants.Compliant); err != nil {
+		return nil, errors.Wrapf(err, "invalid compliant variants file %s", path)
+	}
+	return &variants, nil
+}
+
+// variant returns the compliant variant of image, or false if it has none. Images pinned to a digest are looked up by
+// their tag, and their variant is not pinned.
+func (v *CompliantVariants) variant(image string) (string, bool) {
+	tagged, _, _ := strings.Cut(image, "@")
+	if variant, ok := v.Variants[tagged]; ok {
+		return variant, true
+	}
+	repository, tag := splitImageTag(tagged)
+	variant, ok := v.Variants[repository]
+	if !ok || (tag == "" && strings.Contains(variant, "{tag}")) {
+		return "", false
+	}
+	return strings.ReplaceAll(variant, "{tag}", tag), true
+}
+
+// isCompliant returns true if image is compliant as is: it matches a compliant pattern, or it is the variant of some
+// image.
+func (v *CompliantVariants) isCompliant(image string) bool {
+	for _, name := range imagePolicyNames(image) {
+		if v.compliant.Match(name) {
+			return true
+		}
+	}
+	tagged, _, _ := strings.Cut(image, "@")
+	repository, _ := splitImageTag(tagged)
+	for _, variant := range v.Variants {
+		if variant == tagged || strings.HasPrefix(variant, repository+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// SwappedImage is an image replaced by its compliant variant.
+type SwappedImage struct {
+	Image   string `json:"image"`
+	Variant string `json:"variant"`
+	OS      OSType `json:"os"`
+}
+
+// CompliantList is an image list converted to the compliant variants of its images.
+type CompliantList struct {
+	// Images is the converted list, sorted by OS and then by image.
+	Images ImageList
+	// Swapped are the images replaced by their compliant variant.
+	Swapped []SwappedImage
+	// NonCompliant are the images that are not compliant and have no compliant variant. They are kept in Images in
+	// VariantModeSwap, and dropped from it in VariantModeFilter.
+	NonCompliant ImageList
+}
+
+// WithCompliantVariants converts the list to the compliant variants of its images. The entries of the images sharing a
+// variant are merged, and the digests and sizes of the swapped images, which are those of the original images, are
+// dropped.
+func (l ImageList) WithCompliantVariants(variants *CompliantVariants, mode VariantMode) CompliantList {
+	type imageKey struct {
+		image string
+		os    OSType
+	}
+	var result CompliantList
+	indexes := make(map[imageKey]int)
+	for _, entry := range l {
+		if variant, ok := variants.variant(entry.Image); ok && variant != entry.Image {
+			result.Swapped = append(result.Swapped, SwappedImage{Image: entry.Image, Variant: variant, OS: entry.OS})
+			entry.Image = variant
+			entry.Digest = ""
+			entry.CompressedSize = 0
+			entry.PlatformDigests = nil
+		} else if !ok && !variants.isCompliant(entry.Image) {
+			result.NonCompliant = append(result.NonCompliant, entry)
+			if mode == VariantModeFilter {
+				continue
+			}
+		}
+		key := imageKey{image: entry.Image, os: entry.OS}
+		if i, ok := indexes[key]; ok {
+			result.Images[i] = mergeVariantEntries(result.Images[i], entry)
+			continue
+		}
+		indexes[key] = len(result.Images)
+		result.Images = append(result.Images, entry)
+	}
+	sortImageList(result.Images)
+	return result
+}
+
+// mergeVariantEntries merges the entry of an image into the entry of another image of the same compliant variant. The
+// slices of entry are copied, so that the entries of the original list are not modified.
+func mergeVariantEntries(entry, other ImageEntry) ImageEntry {
+	entry.Sources = append([]string(nil), entry.Sources...)
+	entry.Charts = append([]string(nil), entry.Charts...)
+	entry.RancherVersions = append([]string(nil), entry.RancherVersions...)
+	for _, source := range other.Sources {
+		entry.Sources = insertSorted(entry.Sources, source)
+	}
+	for _, chart := range other.Charts {
+		entry.Charts = insertSorted(entry.Charts, chart)
+	}
+	if len(other.ValuesPaths) > 0 {
+		valuesPaths := make(map[string][]string, len(entry.ValuesPaths)+len(other.ValuesPaths))
+		for chart, paths := range entry.ValuesPaths {
+			valuesPaths[chart] = append([]string(nil), paths...)
+		}
+		for chart, paths := range other.ValuesPaths {
+			for _, path := range paths {
+				valuesPaths[chart] = insertSorted(valuesPaths[chart], path)
+			}
+		}
+		entry.ValuesPaths = valuesPaths
+	}
+	for _, version := range other.RancherVersions {
+		entry.RancherVersions = insertSorted(entry.RancherVersions, version)
+	}
+	// The variant is required for the architectures of both images, and for every architecture if either is not
+	// restricted
+	if len(entry.Arches) == 0 || len(other.Arches) == 0 {
+		entry.Arches = nil
+	} else {
+		arches := append([]Arch(nil), entry.Arches...)
+		for _, arch := range other.Arches {
+			if !hasArch(arches, arch) {
+				arches = append(arches, arch)
+			}
+		}
+		sort.Slice(arches, func(i, j int) bool { return arches[i] < arches[j] })
+		entry.Arches = arches
+	}
+	entry.Optional = entry.Optional && other.Optional
+	return entry
+}
@@ -0,0 +1,83 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestLoadCompliantVariants(t *testing.T) {
+	assert := assertlib.New(t)
+
+	path := filepath.Join(t.TempDir(), "variants.yaml")
+	assert.NoError(os.WriteFile(path, []byte(`variants:
+  rancher/mirrored-coredns-coredns: rancher/hardened-coredns:v{tag}-build20230406
+compliant:
+- rancher/hardened-*
+`), 0644))
+	variants, err := LoadCompliantVariants(path)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"rancher/mirrored-coredns-coredns": "rancher/hardened-coredns:v{tag}-build20230406"}, variants.Variants)
+
+	assert.NoError(os.WriteFile(path, []byte("variants:\n  rancher/shell: \"\"\n"), 0644))
+	_, err = LoadCompliantVariants(path)
+	assert.Error(err)
+	assert.NoError(os.WriteFile(path, []byte("compliant:\n- regex:(\n"), 0644))
+	_, err = LoadCompliantVariants(path)
+	assert.Error(err)
+
+	mode, err := ParseVariantMode("")
+	assert.NoError(err)
+	assert.Equal(VariantModeSwap, mode)
+	_, err = ParseVariantMode("replace")
+	assert.EqualError(err, `invalid variant mode "replace", must be swap or filter`)
+}
+
+func TestImageListWithCompliantVariants(t *testing.T) {
+	assert := assertlib.New(t)
+
+	path := filepath.Join(t.TempDir(), "variants.yaml")
+	assert.NoError(os.WriteFile(path, []byte(`variants:
+  rancher/mirrored-coredns-coredns: rancher/hardened-coredns:v{tag}-build20230406
+  rancher/shell:v0.1.22: rancher/shell:v0.1.22-fips
+  rancher/shell:v0.1.21: rancher/shell:v0.1.22-fips
+compliant:
+- rancher/hardened-*
+`), 0644))
+	variants, err := LoadCompliantVariants(path)
+	assert.NoError(err)
+
+	list := ImageList{
+		{Image: "busybox:1.36", OS: Linux, Sources: []string{"system"}},
+		{Image: "rancher/hardened-etcd:v3.5.9-k3s1-build20230802", OS: Linux, Sources: []string{"rke2"}},
+		{Image: "rancher/mirrored-coredns-coredns:1.10.1@sha256:1111111111111111111111111111111111111111111111111111111111111111", OS: Linux,
+			Sources: []string{"system"}, Digest: "sha256:1111111111111111111111111111111111111111111111111111111111111111"},
+		{Image: "rancher/shell:v0.1.21", OS: Linux, Sources: []string{"system"}, Arches: []Arch{ARM64}, Optional: true},
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Sources: []string{"rancher-monitoring:102.0.0"}, Arches: []Arch{AMD64}},
+		{Image: "rancher/shell:v0.1.22-fips", OS: Windows, Sources: []string{"system"}},
+	}
+	swapped := list.WithCompliantVariants(variants, VariantModeSwap)
+	assert.Equal(ImageList{
+		{Image: "busybox:1.36", OS: Linux, Sources: []string{"system"}},
+		{Image: "rancher/hardened-coredns:v1.10.1-build20230406", OS: Linux, Sources: []string{"system"}},
+		{Image: "rancher/hardened-etcd:v3.5.9-k3s1-build20230802", OS: Linux, Sources: []string{"rke2"}},
+		{Image: "rancher/shell:v0.1.22-fips", OS: Linux, Sources: []string{"rancher-monitoring:102.0.0", "system"}, Arches: []Arch{AMD64, ARM64}},
+		{Image: "rancher/shell:v0.1.22-fips", OS: Windows, Sources: []string{"system"}},
+	}, swapped.Images)
+	assert.Equal([]SwappedImage{
+		{Image: "rancher/mirrored-coredns-coredns:1.10.1@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			Variant: "rancher/hardened-coredns:v1.10.1-build20230406", OS: Linux},
+		{Image: "rancher/shell:v0.1.21", Variant: "rancher/shell:v0.1.22-fips", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", Variant: "rancher/shell:v0.1.22-fips", OS: Linux},
+	}, swapped.Swapped)
+	assert.Equal(ImageList{{Image: "busybox:1.36", OS: Linux, Sources: []string{"system"}}}, swapped.NonCompliant)
+	// The entries of the original list are not modified by the merges
+	assert.Equal([]string{"system"}, list[3].Sources)
+
+	filtered := list.WithCompliantVariants(variants, VariantModeFilter)
+	assert.Len(filtered.Images, 4)
+	assert.Equal("rancher/hardened-coredns:v1.10.1-build20230406", filtered.Images[0].Image)
+	assert.Equal(swapped.NonCompliant, filtered.NonCompliant)
+}
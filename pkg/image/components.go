@@ -0,0 +1,71 @@
+package image
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/rancher/rancher/pkg/settings"
+	"github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// componentChartSettings are the settings pinning the exact versions of the charts of the components Rancher installs
+// by itself, keyed by chart name.
+var componentChartSettings = map[string]settings.Setting{
+	"fleet":                     settings.FleetVersion,
+	"fleet-crd":                 settings.FleetVersion,
+	"fleet-agent":               settings.FleetVersion,
+	"rancher-webhook":           settings.RancherWebhookVersion,
+	"system-upgrade-controller": settings.SystemUpgradeControllerChartVersion,
+}
+
+// Components provides the images of the Rancher components whose versions are defined by the settings of the Rancher
+// version: the images of the fleet, rancher-webhook and system-upgrade-controller chart versions Rancher installs, see
+// componentChartSettings. Like the Rancher images, the settings default to their CATTLE_ environment variables. The
+// charts whose setting is not set, or whose version is not in the charts repository, are scanned in their latest
+// version. The rancher-agent image is provided by Requirements, see SettingImages.
+type Components struct {
+	Config ExportConfig
+}
+
+func (c Components) Name() string {
+	return "components"
+}
+
+func (c Components) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
+	if c.Config.ChartsPath == "" || c.Config.RancherVersion == "" {
+		return nil
+	}
+	index, err := repo.LoadIndexFile(filepath.Join(c.Config.ChartsPath, "index.yaml"))
+	if err != nil {
+		return err
+	}
+	var filteredVersions repo.ChartVersions
+	for chartName, setting := range componentChartSettings {
+		versions := index.Entries[chartName]
+		if len(versions) == 0 || c.Config.chartDisabled(chartName, false) {
+			continue
+		}
+		filteredVersions = append(filteredVersions, pinnedChartVersion(versions, settingValue(setting)))
+	}
+	chartErrs := chartErrorCollector{strict: c.Config.Strict}
+	if err := scanChartVersions(ctx, c.Config, filteredVersions, imagesSet, &chartErrs); err != nil {
+		return err
+	}
+	return chartErrs.err()
+}
+
+// pinnedChartVersion returns the version of versions, the versions of a chart sorted in descending order, that is
+// pinned to version, or the latest version if version is empty or is not one of them.
+func pinnedChartVersion(versions repo.ChartVersions, version string) *repo.ChartVersion {
+	if version == "" {
+		return versions[0]
+	}
+	for _, chartVersion := range versions {
+		if chartVersion.Version == version {
+			return chartVersion
+		}
+	}
+	logrus.Warnf("version %s of chart %s not found, using its latest version %s", version, versions[0].Name, versions[0].Version)
+	return versions[0]
+}
@@ -0,0 +1,59 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rancher/pkg/settings"
+	assertlib "github.com/stretchr/testify/assert"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+const componentChartsIndex = `apiVersion: v1
+entries:
+  rancher-webhook:
+  - name: rancher-webhook
+    version: 103.0.2+up0.4.3
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+  - name: rancher-webhook
+    version: 103.0.1+up0.4.2
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+`
+
+func TestComponentsFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	t.Setenv(settings.GetEnvKey(settings.RancherWebhookVersion.Name), "103.0.1+up0.4.2")
+
+	// The chart points to a broken tarball, so the chart that is scanned is reported as a chart error
+	chartsPath := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "index.yaml"), []byte(componentChartsIndex), 0644))
+	assert.NoError(os.MkdirAll(filepath.Join(chartsPath, "assets", "broken"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "assets", "broken", "broken-1.0.0.tgz"), []byte("not a tarball"), 0644))
+
+	imagesSet := NewImageSet(Linux, Windows)
+	err := Components{ExportConfig{ChartsPath: chartsPath, RancherVersion: "2.8.2"}}.FetchImages(context.Background(), imagesSet)
+	var chartErrs ChartErrors
+	if assert.ErrorAs(err, &chartErrs) && assert.Len(chartErrs, 1) {
+		assert.Equal("rancher-webhook:103.0.1+up0.4.2", chartErrs[0].Chart)
+	}
+	assert.Empty(imagesSet.Images(Linux))
+	assert.Empty(imagesSet.Images(Windows))
+}
+
+func TestPinnedChartVersion(t *testing.T) {
+	assert := assertlib.New(t)
+
+	versions := repo.ChartVersions{
+		{Metadata: &chart.Metadata{Name: "fleet", Version: "103.1.0+up0.9.0"}},
+		{Metadata: &chart.Metadata{Name: "fleet", Version: "103.0.0+up0.8.0"}},
+	}
+	assert.Equal("103.0.0+up0.8.0", pinnedChartVersion(versions, "103.0.0+up0.8.0").Version)
+	assert.Equal("103.1.0+up0.9.0", pinnedChartVersion(versions, "").Version)
+	assert.Equal("103.1.0+up0.9.0", pinnedChartVersion(versions, "102.0.0+up0.7.0").Version)
+}
@@ -0,0 +1,262 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// EmbeddedKDMSource is the KDM data source of the KDM data embedded in RKE, the snapshot of the RKE version Rancher is
+// built with, for exports that do not depend on the build environment.
+const EmbeddedKDMSource = "embedded"
+
+// ExportConfigFile is the YAML format of a file configuring an image export, so that complex air-gap pipelines can be
+// reproduced and reviewed. Relative paths in the file are relative to the directory of the file.
+type ExportConfigFile struct {
+	// RancherVersions are the Rancher versions to export images for.
+	RancherVersions []string `yaml:"rancherVersions"`
+	// Charts and SystemCharts are the charts and system charts repositories to scan.
+	Charts       ChartRepo `yaml:"charts"`
+	SystemCharts ChartRepo `yaml:"systemCharts"`
+	// KDM is the path or URL of the KDM data.json file to read the RKE, K3s and RKE2 images from, or
+	// EmbeddedKDMSource for the KDM data embedded in RKE.
+	KDM string `yaml:"kdm"`
+	// DownloadCache caches the KDM data and the image lists downloaded by the export on disk, see DownloadCache.
+	DownloadCache *DownloadCache `yaml:"downloadCache"`
+	// ScanCache persists the images found in the scanned charts on disk, so that later exports only scan the charts
+	// that changed, see ScanCache.
+	ScanCache *ScanCache `yaml:"scanCache"`
+	// Images are the Rancher images to include, e.g. rancher/rancher:v2.8.0.
+	Images []string `yaml:"images"`
+	// OS are the names of the OS types to export images for, e.g. linux or windows.
+	OS []string `yaml:"os"`
+	// Arch are the architectures to limit the export to, e.g. arm64, see ExportConfig.Arches.
+	Arch []Arch `yaml:"arch"`
+	// ChartArches are the architectures supported by charts, keyed by chart name, see ExportConfig.ChartArches.
+	ChartArches map[string][]Arch `yaml:"chartArches"`
+	// ChartOS are the OS types all the images of charts are exported for, keyed by chart name, see
+	// ExportConfig.ChartOSTypes.
+	ChartOS map[string][]OSType `yaml:"chartOS"`
+	// InstallableK8sVersionsOnly limits the RKE system images to the Kubernetes versions the Rancher version can
+	// install, see ExportConfig.InstallableK8sVersionsOnly.
+	InstallableK8sVersionsOnly bool `yaml:"installableK8sVersionsOnly"`
+	// K8sPatches is which patch versions of each Kubernetes minor version the RKE system images are exported for: latest
+	// or all, see ExportConfig.K8sPatches.
+	K8sPatches K8sPatchMode `yaml:"k8sPatches"`
+	// RequirementImages are core images required on each OS type in addition to the default ones, keyed by OS type,
+	// see ExportConfig.RequirementImages.
+	RequirementImages map[OSType][]RequirementImage `yaml:"requirementImages"`
+	// RKE2Components are the optional RKE2 components whose images are exported along with the core RKE2 images, see
+	// ExportConfig.RKE2Components.
+	RKE2Components []string `yaml:"rke2Components"`
+	// Distributions are the Kubernetes distributions whose images are exported, e.g. rke2, see
+	// ExportConfig.Distributions.
+	Distributions []Distribution `yaml:"distributions"`
+	// ValuesLimits limits the size and depth of the values files of the scanned charts, see ExportConfig.ValuesLimits.
+	ValuesLimits ValuesLimits `yaml:"valuesLimits"`
+	// WindowsBuilds are the Windows Server builds to write per-build image lists for, e.g. ltsc2022, the builds
+	// supported by the Rancher versions if empty, see WindowsBuildsForRancherVersion.
+	WindowsBuilds []string `yaml:"windowsBuilds"`
+	// WindowsTagVariants are the tag templates of the per-build variants of Windows images, see WindowsTagVariants.
+	WindowsTagVariants WindowsTagVariants `yaml:"windowsTagVariants"`
+	// ExpandWindowsTagVariants lists the variants of every build in the Windows image lists.
+	ExpandWindowsTagVariants bool `yaml:"expandWindowsTagVariants"`
+	// Exclude are patterns of images to exclude, see ImageFilter.
+	Exclude []string `yaml:"exclude"`
+	// ExtraImages are files listing additional images to include, see ExtraImages.
+	ExtraImages []string `yaml:"extraImages"`
+	// Formats are the names of the outputs to write, origins, txt, sources and scripts if empty.
+	Formats []string `yaml:"formats"`
+	// OutputDir is the directory to write the outputs to.
+	OutputDir string `yaml:"outputDir"`
+	// Checksums writes a sha256 checksum file next to each output.
+	Checksums bool `yaml:"checksums"`
+	// ChecksumManifest also writes the checksums of all the outputs to sha256sum.txt.
+	ChecksumManifest bool `yaml:"checksumManifest"`
+	// Provenance writes the SLSA provenance of the outputs.
+	Provenance bool `yaml:"provenance"`
+	// ProvenanceBuilderID is the SLSA builder ID of the platform running the export, recorded in the provenance.
+	ProvenanceBuilderID string `yaml:"provenanceBuilderID"`
+	// Strict makes the export fail on the first chart that cannot be scanned.
+	Strict bool `yaml:"strict"`
+	// CoreOnly limits the export to the images strictly required to run Rancher and provision clusters.
+	CoreOnly bool `yaml:"coreOnly"`
+	// Prime exports the images of Rancher Prime, see PrimeRegistryMapping.
+	Prime bool `yaml:"prime"`
+	// DenyList is the path of a deny list file, see LoadDenyList.
+	DenyList string `yaml:"denyList"`
+	// AllowedRegistries are the registries and namespaces the exported images may come from, see
+	// NewAllowedRegistries. Images from any registry are allowed if empty.
+	AllowedRegistries []string `yaml:"allowedRegistries"`
+	// AllowedRegistriesAction is what the images from other registries do to the export: fail or warn.
+	AllowedRegistriesAction PolicyAction `yaml:"allowedRegistriesAction"`
+	// FloatingTags are the floating tags the exported images are flagged with, DefaultFloatingTags if empty, see
+	// FloatingTags.
+	FloatingTags []string `yaml:"floatingTags"`
+	// FloatingTagsAction is what the images with floating tags do to the export: warn, the default, or fail.
+	FloatingTagsAction PolicyAction `yaml:"floatingTagsAction"`
+	// CompliantVariants is the path of a compliant variants file, see LoadCompliantVariants.
+	CompliantVariants string `yaml:"compliantVariants"`
+	// VariantMode is what happens to the images without compliant variant: swap or filter, see VariantMode.
+	VariantMode VariantMode `yaml:"variantMode"`
+	// MirrorMapping is the path of a mirror mapping file, see LoadMirrorMapping.
+	MirrorMapping string `yaml:"mirrorMapping"`
+	// MirrorMode is how upstream images are converted to their mirrored names: mirrored, upstream or both.
+	MirrorMode MirrorMode `yaml:"mirrorMode"`
+	// RegistryMapping rewrites the exported images, and takes precedence over Prime for the prefixes it defines.
+	RegistryMapping RegistryMapping `yaml:"registryMapping"`
+	// Features are Rancher feature flags, charts of disabled features are skipped.
+	Features map[string]bool `yaml:"features"`
+	// Previous is the image list of the previous release, in the rancher-images.json or rancher-images.txt format, to
+	// report the changes since that release.
+	Previous string `yaml:"previous"`
+	// ConfigMapNamespace is the namespace of the ConfigMap manifest holding the image lists.
+	ConfigMapNamespace string `yaml:"configMapNamespace"`
+	// RegistryLookups looks up the digest and size of the images in their registries.
+	RegistryLookups bool `yaml:"registryLookups"`
+	// PinDigests pins the images to their digests, looking them up in their registries.
+	PinDigests bool `yaml:"pinDigests"`
+	// PlatformDigests are the OS/ARCHITECTURE platforms to look up the platform-specific manifest digests of the images
+	// for, e.g. linux/arm64, for mirrors replicating some platforms only.
+	PlatformDigests []string `yaml:"platformDigests"`
+	// MirrorEndpoint is the private registry the images are mirrored to, to write the containerd mirror configuration.
+	MirrorEndpoint string `yaml:"mirrorEndpoint"`
+	// ECRRegistry is the ECR registry to serve the images from through pull-through cache rules, to write the rules.
+	ECRRegistry string `yaml:"ecrRegistry"`
+	// HarborRegistries are the IDs of the Harbor registry endpoints to replicate the images from, keyed by registry,
+	// to write the Harbor replication policies.
+	HarborRegistries map[string]int64 `yaml:"harborRegistries"`
+	// HarborNamespace is the Harbor project to replicate the images to.
+	HarborNamespace string `yaml:"harborNamespace"`
+	// SigningRegistries are the registries the images are published to, whose references the signing manifest lists.
+	SigningRegistries []string `yaml:"signingRegistries"`
+}
+
+// ChartRepo locates a charts repository, either on disk or in a git repository to clone.
+type ChartRepo struct {
+	// Path is the path of a local copy of the repository.
+	Path string `yaml:"path"`
+	// URL is the git URL to clone the repository from when Path is not set.
+	URL string `yaml:"url"`
+	// Branch is the branch to clone, the default branch of the repository if empty.
+	Branch string `yaml:"branch"`
+	// Username is the user to authenticate with when cloning.
+	Username string `yaml:"username"`
+	// PasswordEnv is the name of the environment variable holding the password or token to authenticate with when
+	// cloning, so that it does not have to be stored in the file.
+	PasswordEnv string `yaml:"passwordEnv"`
+}
+
+// LoadExportConfigFile reads the export config file at path.
+func LoadExportConfigFile(path string) (ExportConfigFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ExportConfigFile{}, err
+	}
+	defer file.Close()
+
+	var config ExportConfigFile
+	if err := decodeYAMLFile(file, &config); err != nil {
+		return ExportConfigFile{}, errors.Wrapf(err, "failed to decode export config file %s", path)
+	}
+	// OS types are decoded as plain strings, so they are parsed to reject the unknown ones
+	for chart, osTypes := range config.ChartOS {
+		for i, osType := range osTypes {
+			if osTypes[i], err = ParseOSType(osType.String()); err != nil {
+				return ExportConfigFile{}, errors.Wrapf(err, "invalid os of chart %s in export config file %s", chart, path)
+			}
+		}
+	}
+	if len(config.RequirementImages) > 0 {
+		requirementImages := make(map[OSType][]RequirementImage, len(config.RequirementImages))
+		for osType, images := range config.RequirementImages {
+			parsed, err := ParseOSType(osType.String())
+			if err != nil {
+				return ExportConfigFile{}, errors.Wrapf(err, "invalid os of requirement images in export config file %s", path)
+			}
+			requirementImages[parsed] = append(requirementImages[parsed], images...)
+		}
+		config.RequirementImages = requirementImages
+	}
+	dir := filepath.Dir(path)
+	config.Charts.Path = resolvePath(dir, config.Charts.Path)
+	config.SystemCharts.Path = resolvePath(dir, config.SystemCharts.Path)
+	if !strings.Contains(config.KDM, "://") && config.KDM != EmbeddedKDMSource {
+		config.KDM = resolvePath(dir, config.KDM)
+	}
+	config.OutputDir = resolvePath(dir, config.OutputDir)
+	config.Previous = resolvePath(dir, config.Previous)
+	config.MirrorMapping = resolvePath(dir, config.MirrorMapping)
+	config.DenyList = resolvePath(dir, config.DenyList)
+	config.CompliantVariants = resolvePath(dir, config.CompliantVariants)
+	for i, extraImages := range config.ExtraImages {
+		config.ExtraImages[i] = resolvePath(dir, extraImages)
+	}
+	if config.DownloadCache != nil {
+		config.DownloadCache.Dir = resolvePath(dir, config.DownloadCache.Dir)
+	}
+	if config.ScanCache != nil {
+		config.ScanCache.Dir = resolvePath(dir, config.ScanCache.Dir)
+	}
+	return config, nil
+}
+
+// ExportConfig returns the configuration to export the images of rancherVersion with GetImages. The charts
+// repositories must be available locally, i.e. set with a Path.
+func (f ExportConfigFile) ExportConfig(rancherVersion string) ExportConfig {
+	return ExportConfig{
+		RancherVersion:             rancherVersion,
+		ChartsPath:                 f.Charts.Path,
+		SystemChartsPath:           f.SystemCharts.Path,
+		ExcludePatterns:            f.Exclude,
+		ExtraImagesFiles:           f.ExtraImages,
+		Strict:                     f.Strict,
+		CoreOnly:                   f.CoreOnly,
+		RegistryMapping:            f.Mapping(),
+		Features:                   f.Features,
+		Arches:                     f.Arch,
+		ChartArches:                f.ChartArches,
+		ChartOSTypes:               f.ChartOS,
+		RequirementImages:          f.RequirementImages,
+		InstallableK8sVersionsOnly: f.InstallableK8sVersionsOnly,
+		K8sPatches:                 f.K8sPatches,
+		RKE2Components:             f.RKE2Components,
+		Distributions:              f.Distributions,
+		ValuesLimits:               f.ValuesLimits,
+	}
+}
+
+// Mapping returns the registry mapping of the file, combining Prime and RegistryMapping.
+func (f ExportConfigFile) Mapping() RegistryMapping {
+	if !f.Prime && len(f.RegistryMapping) == 0 {
+		return nil
+	}
+	mapping := make(RegistryMapping)
+	if f.Prime {
+		for prefix, replacement := range PrimeRegistryMapping {
+			mapping[prefix] = replacement
+		}
+	}
+	for prefix, replacement := range f.RegistryMapping {
+		mapping[prefix] = replacement
+	}
+	return mapping
+}
+
+// Password returns the password to clone the repository with, read from the PasswordEnv environment variable.
+func (r ChartRepo) Password() string {
+	if r.PasswordEnv == "" {
+		return ""
+	}
+	return os.Getenv(r.PasswordEnv)
+}
+
+// resolvePath returns path relative to dir, unless it is empty or absolute.
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
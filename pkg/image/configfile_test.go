@@ -0,0 +1,94 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestLoadExportConfigFile(t *testing.T) {
+	assert := assertlib.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export.yaml")
+	err := os.WriteFile(path, []byte(`rancherVersions: [v2.8.0]
+charts:
+  path: charts
+systemCharts:
+  url: https://github.com/rancher/system-charts
+  branch: dev-v2.8
+  username: robot
+  passwordEnv: TEST_SYSTEM_CHARTS_TOKEN
+kdm: /opt/kdm/data.json
+os: [linux]
+exclude: ["*-windows-*"]
+extraImages: [extra-images.txt]
+formats: [txt, sources]
+outputDir: out
+strict: true
+`), 0644)
+	assert.NoError(err)
+	t.Setenv("TEST_SYSTEM_CHARTS_TOKEN", "secret")
+
+	config, err := LoadExportConfigFile(path)
+	assert.NoError(err)
+	assert.Equal(ExportConfigFile{
+		RancherVersions: []string{"v2.8.0"},
+		Charts:          ChartRepo{Path: filepath.Join(dir, "charts")},
+		SystemCharts: ChartRepo{
+			URL:         "https://github.com/rancher/system-charts",
+			Branch:      "dev-v2.8",
+			Username:    "robot",
+			PasswordEnv: "TEST_SYSTEM_CHARTS_TOKEN",
+		},
+		KDM:         "/opt/kdm/data.json",
+		OS:          []string{"linux"},
+		Exclude:     []string{"*-windows-*"},
+		ExtraImages: []string{filepath.Join(dir, "extra-images.txt")},
+		Formats:     []string{"txt", "sources"},
+		OutputDir:   filepath.Join(dir, "out"),
+		Strict:      true,
+	}, config)
+	assert.Equal("secret", config.SystemCharts.Password())
+
+	assert.Equal(ExportConfig{
+		RancherVersion:   "v2.8.0",
+		ChartsPath:       filepath.Join(dir, "charts"),
+		ExcludePatterns:  []string{"*-windows-*"},
+		ExtraImagesFiles: []string{filepath.Join(dir, "extra-images.txt")},
+		Strict:           true,
+	}, config.ExportConfig("v2.8.0"))
+}
+
+func TestLoadExportConfigFileChartOS(t *testing.T) {
+	assert := assertlib.New(t)
+
+	path := filepath.Join(t.TempDir(), "export.yaml")
+	assert.NoError(os.WriteFile(path, []byte("chartOS:\n  rancher-monitoring: [Linux]\n"), 0644))
+	config, err := LoadExportConfigFile(path)
+	assert.NoError(err)
+	assert.Equal(map[string][]OSType{"rancher-monitoring": {Linux}}, config.ChartOS)
+
+	assert.NoError(os.WriteFile(path, []byte("chartOS:\n  rancher-monitoring: [freebsd]\n"), 0644))
+	_, err = LoadExportConfigFile(path)
+	assert.Error(err)
+}
+
+func TestLoadExportConfigFileKDMSource(t *testing.T) {
+	assert := assertlib.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export.yaml")
+	for kdm, expected := range map[string]string{
+		"data.json": filepath.Join(dir, "data.json"),
+		"https://releases.rancher.com/kontainer-driver-metadata/release-v2.8/data.json": "https://releases.rancher.com/kontainer-driver-metadata/release-v2.8/data.json",
+		EmbeddedKDMSource: EmbeddedKDMSource,
+	} {
+		assert.NoError(os.WriteFile(path, []byte("kdm: "+kdm+"\n"), 0644))
+		config, err := LoadExportConfigFile(path)
+		assert.NoError(err)
+		assert.Equal(expected, config.KDM)
+	}
+}
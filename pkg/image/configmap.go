@@ -0,0 +1,83 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ImageListConfigMapName is the name of the ConfigMap of ImageListConfigMap.
+	ImageListConfigMapName = "rancher-images"
+	// ImageListVersionLabel is the label of the ConfigMap of ImageListConfigMap holding the Rancher version, so that
+	// consumers can check which release the list belongs to.
+	ImageListVersionLabel = "images.cattle.io/rancher-version"
+	// ImageListGeneratedAtAnnotation is the annotation of the ConfigMap of ImageListConfigMap holding when the list
+	// was generated.
+	ImageListGeneratedAtAnnotation = "images.cattle.io/generated-at"
+)
+
+// ImageListConfigMap returns the ConfigMap holding list in namespace, for in-cluster consumers such as pre-pull
+// DaemonSets or policy controllers. The images of each OS are in the rancher-images.txt and
+// rancher-windows-images.txt keys, and the metadata in the metadata.json key. The ConfigMap is labeled with the Rancher
+// version when the list is for a single version.
+func ImageListConfigMap(list ImageList, metadata ExportMetadata, namespace string) (*corev1.ConfigMap, error) {
+	metadataJSON, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal image list metadata")
+	}
+
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ImageListConfigMapName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app.kubernetes.io/name":       ImageListConfigMapName,
+				"app.kubernetes.io/managed-by": "rancher-image-export",
+			},
+			Annotations: map[string]string{
+				ImageListGeneratedAtAnnotation: metadata.GeneratedAt.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: map[string]string{
+			"rancher-images.txt":         imagesData(list.ForOS(Linux)),
+			"rancher-windows-images.txt": imagesData(list.ForOS(Windows)),
+			"metadata.json":              string(metadataJSON),
+		},
+	}
+	if len(metadata.RancherVersions) == 1 {
+		configMap.Labels[ImageListVersionLabel] = metadata.RancherVersions[0]
+	}
+	return configMap, nil
+}
+
+func imagesData(list ImageList) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return strings.Join(list.Images(), "\n") + "\n"
+}
+
+// WriteImageListConfigMap writes the ConfigMap of ImageListConfigMap to w as a YAML manifest.
+func WriteImageListConfigMap(w io.Writer, list ImageList, metadata ExportMetadata, namespace string) error {
+	configMap, err := ImageListConfigMap(list, metadata, namespace)
+	if err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(configMap)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal image list ConfigMap")
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,37 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestImageListConfigMap(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet:v0.7.0", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/wins:v0.4.12", OS: Windows},
+	}
+	metadata := ExportMetadata{
+		RancherVersions: []string{"v2.8.0"},
+		GeneratedAt:     time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	configMap, err := ImageListConfigMap(list, metadata, "cattle-system")
+	assert.NoError(err)
+	assert.Equal("cattle-system", configMap.Namespace)
+	assert.Equal("v2.8.0", configMap.Labels[ImageListVersionLabel])
+	assert.Equal("2023-09-01T12:00:00Z", configMap.Annotations[ImageListGeneratedAtAnnotation])
+	assert.Equal("rancher/fleet:v0.7.0\nrancher/shell:v0.1.22\n", configMap.Data["rancher-images.txt"])
+	assert.Equal("rancher/wins:v0.4.12\n", configMap.Data["rancher-windows-images.txt"])
+
+	metadata.RancherVersions = []string{"v2.7.5", "v2.8.0"}
+	var buf bytes.Buffer
+	assert.NoError(WriteImageListConfigMap(&buf, list, metadata, "cattle-system"))
+	assert.Contains(buf.String(), "kind: ConfigMap")
+	assert.NotContains(buf.String(), ImageListVersionLabel)
+}
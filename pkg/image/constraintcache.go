@@ -0,0 +1,75 @@
+package image
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+type constraintCacheContextKey struct{}
+
+// constraintCache memoizes the chart constraints and Rancher versions parsed during an export: the chart versions of a
+// repository share a few constraints, and all of them are compared with the same Rancher version. All methods are safe
+// to call on a nil cache, in which case constraints and versions are parsed every time.
+type constraintCache struct {
+	lock            sync.Mutex
+	constraints     map[string]parsedConstraint
+	rancherVersions map[string]parsedVersion
+}
+
+type parsedConstraint struct {
+	constraint *semver.Constraints
+	err        error
+}
+
+type parsedVersion struct {
+	version *semver.Version
+	err     error
+}
+
+// withConstraintCache returns a context carrying a new cache of the constraints and Rancher versions parsed by the
+// export run with it.
+func withConstraintCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, constraintCacheContextKey{}, &constraintCache{
+		constraints:     make(map[string]parsedConstraint),
+		rancherVersions: make(map[string]parsedVersion),
+	})
+}
+
+// constraintCacheFromContext returns the constraint cache of ctx, or nil if it has none.
+func constraintCacheFromContext(ctx context.Context) *constraintCache {
+	cache, _ := ctx.Value(constraintCacheContextKey{}).(*constraintCache)
+	return cache
+}
+
+// parseConstraint returns the constraint parsed from constraintStr, parsing it on the first call only.
+func (c *constraintCache) parseConstraint(constraintStr string) (*semver.Constraints, error) {
+	if c == nil {
+		return semver.NewConstraint(constraintStr)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	parsed, ok := c.constraints[constraintStr]
+	if !ok {
+		parsed.constraint, parsed.err = semver.NewConstraint(constraintStr)
+		c.constraints[constraintStr] = parsed
+	}
+	return parsed.constraint, parsed.err
+}
+
+// parseRancherVersion returns the version rancherVersion is compared to chart constraints as, parsing it on the first
+// call only.
+func (c *constraintCache) parseRancherVersion(rancherVersion string) (*semver.Version, error) {
+	if c == nil {
+		return constraintRancherVersion(rancherVersion)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	parsed, ok := c.rancherVersions[rancherVersion]
+	if !ok {
+		parsed.version, parsed.err = constraintRancherVersion(rancherVersion)
+		c.rancherVersions[rancherVersion] = parsed
+	}
+	return parsed.version, parsed.err
+}
@@ -0,0 +1,42 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestConstraintCache(t *testing.T) {
+	assert := assertlib.New(t)
+
+	cache := constraintCacheFromContext(withConstraintCache(context.Background()))
+	constraint, err := cache.parseConstraint(">= 2.7.0-0 < 2.8.0-0")
+	assert.NoError(err)
+	cached, err := cache.parseConstraint(">= 2.7.0-0 < 2.8.0-0")
+	assert.NoError(err)
+	assert.Same(constraint, cached)
+	_, err = cache.parseConstraint("not a constraint")
+	assert.Error(err)
+	_, err = cache.parseConstraint("not a constraint")
+	assert.Error(err)
+
+	version, err := cache.parseRancherVersion("2.7.99")
+	assert.NoError(err)
+	assert.Equal("2.7.98", version.String())
+	cachedVersion, err := cache.parseRancherVersion("2.7.99")
+	assert.NoError(err)
+	assert.Same(version, cachedVersion)
+
+	// Another export parses them again, and a context without a cache parses them every time
+	other := constraintCacheFromContext(withConstraintCache(context.Background()))
+	otherVersion, err := other.parseRancherVersion("2.7.99")
+	assert.NoError(err)
+	assert.NotSame(version, otherVersion)
+	uncached := constraintCacheFromContext(context.Background())
+	assert.Nil(uncached)
+	uncachedVersion, err := uncached.parseRancherVersion("2.7.99")
+	assert.NoError(err)
+	assert.NotSame(version, uncachedVersion)
+	assert.Equal(version, uncachedVersion)
+}
@@ -0,0 +1,133 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// dockerHubDomain is the domain of the images without a registry, see reference.ParseNormalizedNamed.
+const dockerHubDomain = "docker.io"
+
+// RegistriesConfig is the registries.yaml file configuring the registry mirrors of RKE2 and K3s nodes.
+type RegistriesConfig struct {
+	Mirrors map[string]RegistryMirror `yaml:"mirrors"`
+}
+
+// RegistryMirror is the mirror of a source registry in a registries.yaml file.
+type RegistryMirror struct {
+	// Endpoint are the URLs of the mirrors of the registry.
+	Endpoint []string `yaml:"endpoint"`
+	// Rewrite maps regular expressions matching the repositories of the registry to their repository in the mirrors.
+	Rewrite map[string]string `yaml:"rewrite,omitempty"`
+}
+
+// ContainerdMirrors returns the registries.yaml configuration pulling the images of list from endpoint, the private
+// registry the images were pushed to by the load script. The load script keeps the registry of the images not from
+// Docker Hub in their repository, e.g. quay.io/skopeo/stable is pushed as endpoint/quay.io/skopeo/stable, and the
+// Docker Hub images without a namespace are pushed to the rancher namespace, so the mirrors rewrite the repositories
+// accordingly, the same way Rancher prefixes images with its system default registry.
+func ContainerdMirrors(list ImageList, endpoint string) (RegistriesConfig, error) {
+	endpoint, err := mirrorEndpointURL(endpoint)
+	if err != nil {
+		return RegistriesConfig{}, err
+	}
+	domains, hasOfficialImages, err := imageDomains(list)
+	if err != nil {
+		return RegistriesConfig{}, err
+	}
+
+	config := RegistriesConfig{Mirrors: make(map[string]RegistryMirror, len(domains))}
+	for _, domain := range domains {
+		mirror := RegistryMirror{Endpoint: []string{endpoint}}
+		if domain != dockerHubDomain {
+			mirror.Rewrite = map[string]string{"^(.*)$": domain + "/$1"}
+		} else if hasOfficialImages {
+			mirror.Rewrite = map[string]string{"^library/(.*)$": "rancher/$1"}
+		}
+		config.Mirrors[domain] = mirror
+	}
+	return config, nil
+}
+
+// MarshalRegistriesYAML returns the registries.yaml file of config.
+func MarshalRegistriesYAML(config RegistriesConfig) ([]byte, error) {
+	out, err := yaml.Marshal(config)
+	return out, errors.Wrap(err, "failed to marshal registries configuration")
+}
+
+// ContainerdHostsTOML returns the hosts.toml files configuring containerd to pull the images of list from endpoint,
+// keyed by the source registry they configure, i.e. the directory of the containerd config_path to write them to.
+// As hosts.toml files cannot rewrite repositories, the registry of the images not from Docker Hub is part of the
+// mirror URL instead. The Docker Hub images without a namespace cannot be mirrored this way and should be pulled from
+// their rancher/ repository in the private registry.
+func ContainerdHostsTOML(list ImageList, endpoint string) (map[string]string, error) {
+	endpoint, err := mirrorEndpointURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	domains, _, err := imageDomains(list)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string, len(domains))
+	for _, domain := range domains {
+		var b strings.Builder
+		if domain == dockerHubDomain {
+			fmt.Fprintf(&b, "server = %q\n\n", "https://registry-1.docker.io")
+			fmt.Fprintf(&b, "[host.%q]\n", endpoint)
+			fmt.Fprintf(&b, "  capabilities = [\"pull\", \"resolve\"]\n")
+		} else {
+			fmt.Fprintf(&b, "server = %q\n\n", "https://"+domain)
+			fmt.Fprintf(&b, "[host.%q]\n", endpoint+"/v2/"+domain)
+			fmt.Fprintf(&b, "  capabilities = [\"pull\", \"resolve\"]\n")
+			fmt.Fprintf(&b, "  override_path = true\n")
+		}
+		files[domain] = b.String()
+	}
+	return files, nil
+}
+
+var endpointSchemeRegexp = regexp.MustCompile(`^[a-z]+://`)
+
+// mirrorEndpointURL returns the URL of the private registry endpoint, which defaults to HTTPS.
+func mirrorEndpointURL(endpoint string) (string, error) {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if endpoint == "" {
+		return "", errors.New("mirror endpoint is required")
+	}
+	if !endpointSchemeRegexp.MatchString(endpoint) {
+		endpoint = "https://" + endpoint
+	}
+	return endpoint, nil
+}
+
+// imageDomains returns the sorted registries of the images of list, and whether list has Docker Hub images without
+// a namespace.
+func imageDomains(list ImageList) ([]string, bool, error) {
+	seen := make(map[string]bool)
+	var domains []string
+	var hasOfficialImages bool
+	for _, entry := range list {
+		named, err := reference.ParseNormalizedNamed(entry.Image)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "failed to parse image %s", entry.Image)
+		}
+		domain := reference.Domain(named)
+		if !strings.Contains(entry.Image, "/") {
+			hasOfficialImages = true
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains, hasOfficialImages, nil
+}
@@ -0,0 +1,53 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestContainerdMirrors(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "busybox:1.36", OS: Linux},
+		{Image: "quay.io/skopeo/stable:v1", OS: Linux},
+	}
+
+	config, err := ContainerdMirrors(list, "registry.example.com:5000/")
+	assert.NoError(err)
+	out, err := MarshalRegistriesYAML(config)
+	assert.NoError(err)
+	assert.Equal(`mirrors:
+  docker.io:
+    endpoint:
+    - https://registry.example.com:5000
+    rewrite:
+      ^library/(.*)$: rancher/$1
+  quay.io:
+    endpoint:
+    - https://registry.example.com:5000
+    rewrite:
+      ^(.*)$: quay.io/$1
+`, string(out))
+
+	hosts, err := ContainerdHostsTOML(list, "http://registry.example.com:5000")
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"docker.io": `server = "https://registry-1.docker.io"
+
+[host."http://registry.example.com:5000"]
+  capabilities = ["pull", "resolve"]
+`,
+		"quay.io": `server = "https://quay.io"
+
+[host."http://registry.example.com:5000/v2/quay.io"]
+  capabilities = ["pull", "resolve"]
+  override_path = true
+`,
+	}, hosts)
+
+	_, err = ContainerdMirrors(list, "")
+	assert.EqualError(err, "mirror endpoint is required")
+}
@@ -0,0 +1,395 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ctrimage "github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultCopyRetryDelay is the delay before the first retry of an image copy, doubled for each following retry.
+const defaultCopyRetryDelay = 2 * time.Second
+
+// ImageCopier copies the images of an image list from their registries to a private registry, without docker or
+// skopeo. Every platform of multi-arch images is copied.
+type ImageCopier struct {
+	// Client configures the connections to the source registries and to the private registry, and how many images are
+	// copied concurrently.
+	Client RegistryClient
+	// Registry is the private registry the images are copied to, e.g. registry.example.com:5000.
+	Registry string
+	// SourceRegistry, if set, is the registry the images are pulled from instead of their own registries, e.g. a
+	// staging mirror, like the source registry of the load and mirror scripts.
+	SourceRegistry string
+	// Retries is the number of times the copy of an image is retried after failing.
+	Retries int
+	// StateFile, if set, is the file recording the images copied to the private registry. The images it records are
+	// skipped, so a copy interrupted halfway resumes where it stopped, and the blobs of an image copied halfway are
+	// not pushed again since the registry already has them. Removing the file copies every image again.
+	StateFile string
+	// Progress, if set, is called with the result of every image once it has been copied, skipped or has failed. It
+	// is not called concurrently.
+	Progress func(result CopyResult)
+	// TransferProgress, if set, is called with the progress of the transfer of the images while their blobs are
+	// copied, at most once per ProgressInterval, and whenever an image is done. It is not called concurrently.
+	TransferProgress func(progress CopyProgress)
+	// ProgressInterval is the minimum interval between two calls of TransferProgress, defaultProgressInterval if not
+	// set.
+	ProgressInterval time.Duration
+}
+
+// copyState is the content of the state file of an ImageCopier.
+type copyState struct {
+	// Images are the images copied to the private registry, by target image.
+	Images map[string]copiedImage `json:"images"`
+}
+
+// copiedImage is an image recorded in a state file once its digest has been verified in the private registry.
+type copiedImage struct {
+	Digest   string    `json:"digest"`
+	CopiedAt time.Time `json:"copiedAt"`
+}
+
+// readCopyState reads the state file at path, or returns an empty state if it does not exist.
+func readCopyState(path string) (copyState, error) {
+	state := copyState{Images: make(map[string]copiedImage)}
+	in, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(in, &state); err != nil {
+		return state, errors.Wrapf(err, "failed to parse copy state file %s", path)
+	}
+	if state.Images == nil {
+		state.Images = make(map[string]copiedImage)
+	}
+	return state, nil
+}
+
+// write writes the state to path, replacing the file at once so an interrupted write does not lose the state.
+func (s copyState) write(path string) error {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// CopyResult is the outcome of the copy of an image.
+type CopyResult struct {
+	// Image is the copied image.
+	Image string
+	// OS is the OS the image is exported for.
+	OS OSType
+	// Target is the image in the private registry.
+	Target string
+	// Digest is the digest of the copied manifest, empty if the copy failed.
+	Digest string
+	// Err is the error of the last attempt, if the copy failed.
+	Err error
+	// Skipped is set when the target already held the image with the same digest, so it was not copied again.
+	Skipped bool
+}
+
+// TargetImage returns the name of image in registry, like the load and mirror scripts: images without a repository are
+// pushed to the rancher repository, and the digest of pinned images is dropped since a tag cannot be created with a
+// digest.
+func TargetImage(registry, image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if !strings.Contains(image, "/") {
+		image = "rancher/" + image
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + image
+}
+
+// Copy copies the images of list to the private registry. Images exported for several OS types are copied once. It
+// returns the results of every image, sorted by image, along with ImageErrors if some images could not be copied.
+func (c ImageCopier) Copy(ctx context.Context, list ImageList) ([]CopyResult, error) {
+	if c.Registry == "" {
+		return nil, errors.New("target registry is required")
+	}
+	var unique ImageList
+	seen := make(map[string]bool, len(list))
+	for _, entry := range list {
+		if !seen[entry.Image] {
+			seen[entry.Image] = true
+			unique = append(unique, entry)
+		}
+	}
+
+	state := copyState{Images: make(map[string]copiedImage)}
+	if c.StateFile != "" {
+		var err error
+		if state, err = readCopyState(c.StateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var tracker *transferTracker
+	if c.TransferProgress != nil {
+		tracker = newTransferTracker(len(unique), c.ProgressInterval, c.TransferProgress)
+	}
+	var mu sync.Mutex
+	results := make([]CopyResult, 0, len(unique))
+	c.Client.forEach(unique, func(entry *ImageEntry) {
+		target := TargetImage(c.Registry, entry.Image)
+		mu.Lock()
+		copied, done := state.Images[target]
+		mu.Unlock()
+		result := CopyResult{Image: entry.Image, OS: entry.OS, Target: target, Digest: copied.Digest, Skipped: done}
+		if !done {
+			result = c.copyWithRetries(ctx, *entry, tracker)
+		}
+		tracker.done(entry.Image, !done && result.Err == nil)
+		mu.Lock()
+		defer mu.Unlock()
+		if !done && result.Err == nil && c.StateFile != "" {
+			state.Images[target] = copiedImage{Digest: result.Digest, CopiedAt: time.Now().UTC()}
+			if err := state.write(c.StateFile); err != nil {
+				logrus.Warnf("failed to record the copy of %s in state file %s: %v", entry.Image, c.StateFile, err)
+			}
+		}
+		results = append(results, result)
+		if c.Progress != nil {
+			c.Progress(result)
+		}
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Image < results[j].Image
+	})
+	var errs ImageErrors
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, &ImageError{Image: result.Image, OS: result.OS, Err: result.Err})
+		}
+	}
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
+
+// copyWithRetries copies the image of entry, retrying with an exponential backoff, and verifies the digest of the
+// copied image in the private registry. The transfer of the image is tracked by tracker, if not nil.
+func (c ImageCopier) copyWithRetries(ctx context.Context, entry ImageEntry, tracker *transferTracker) CopyResult {
+	result := CopyResult{Image: entry.Image, OS: entry.OS, Target: TargetImage(c.Registry, entry.Image)}
+	result.Err = withRetries(ctx, c.Retries, func() error {
+		var err error
+		if result.Digest, err = c.copyImage(ctx, entry, result.Target, tracker.image(entry.Image)); err != nil {
+			return err
+		}
+		copied, err := c.Client.Digest(ctx, result.Target, entry.OS)
+		if err != nil {
+			return errors.Wrapf(err, "failed to verify image %s", result.Target)
+		}
+		if copied != result.Digest {
+			return errors.Errorf("image %s has digest %s instead of %s", result.Target, copied, result.Digest)
+		}
+		return nil
+	})
+	if result.Err != nil {
+		result.Digest = ""
+	}
+	return result
+}
+
+// withRetries calls f until it succeeds, retrying it up to retries times with an exponential backoff. The errors
+// retrying cannot fix, from rejected credentials or missing images, are not retried.
+func withRetries(ctx context.Context, retries int, f func() error) error {
+	delay := defaultCopyRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		if class := ClassifyError(err); class == ErrorAuth || class == ErrorMissing {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// copyImage copies the image of entry to target, recording its transfer in transfer, and returns the digest of its
+// manifest.
+func (c ImageCopier) copyImage(ctx context.Context, entry ImageEntry, target string, transfer *imageTransfer) (string, error) {
+	source := entry.Image
+	if c.SourceRegistry != "" {
+		source = strings.TrimSuffix(c.SourceRegistry, "/") + "/" + entry.Image
+	}
+	destRef, err := dockerReference(target)
+	if err != nil {
+		return "", err
+	}
+	destSys, err := c.Client.systemContext(target, entry.OS)
+	if err != nil {
+		return "", err
+	}
+	dest, err := destRef.NewImageDestination(ctx, destSys)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to access image %s", target)
+	}
+	defer dest.Close()
+
+	client := c.Client
+	client.transfer = transfer
+	raw, err := client.copyImageTo(ctx, source, entry.OS, dest)
+	if err != nil {
+		return "", err
+	}
+	manifestDigest, err := manifest.Digest(raw)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to compute digest of image %s", entry.Image)
+	}
+	return manifestDigest.String(), nil
+}
+
+// copyImageTo copies every platform of image from its registry to dest and commits it, or its platform for osType from
+// the local store of the client if set. It returns the manifest of the image, i.e. the manifest list of multi-arch
+// images.
+func (c RegistryClient) copyImageTo(ctx context.Context, image string, osType OSType, dest types.ImageDestination) ([]byte, error) {
+	srcSys, err := c.systemContext(image, osType)
+	if err != nil {
+		return nil, err
+	}
+	var src types.ImageSource
+	if c.LocalStore != nil {
+		if src, err = c.LocalStore.newImageSource(ctx, image, srcSys); err != nil {
+			return nil, err
+		}
+	} else {
+		srcRef, err := dockerReference(image)
+		if err != nil {
+			return nil, err
+		}
+		if src, err = srcRef.NewImageSource(ctx, srcSys); err != nil {
+			return nil, errors.Wrapf(err, "failed to access image %s", image)
+		}
+	}
+	defer src.Close()
+	return c.copySource(ctx, src, dest, image)
+}
+
+// copySource copies every platform of image from src to dest and commits it. It returns the manifest of the image.
+func (c RegistryClient) copySource(ctx context.Context, src types.ImageSource, dest types.ImageDestination, image string) ([]byte, error) {
+	raw, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get manifest of image %s", image)
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(raw, mimeType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse manifest list of image %s", image)
+		}
+		for _, instance := range list.Instances() {
+			instance := instance
+			if err := c.copyInstance(ctx, src, dest, &instance); err != nil {
+				return nil, errors.Wrapf(err, "failed to copy %s of image %s", instance, image)
+			}
+		}
+	} else if err := c.copyInstance(ctx, src, dest, nil); err != nil {
+		return nil, errors.Wrapf(err, "failed to copy image %s", image)
+	}
+
+	if err := dest.PutManifest(ctx, raw, nil); err != nil {
+		return nil, errors.Wrapf(err, "failed to write manifest of image %s", image)
+	}
+	if err := dest.Commit(ctx, ctrimage.UnparsedInstance(src, nil)); err != nil {
+		return nil, errors.Wrapf(err, "failed to commit image %s", image)
+	}
+	return raw, nil
+}
+
+// copyInstance copies the config and layers of the manifest of instance, or of the single image of src if instance is
+// nil, then the manifest itself when it is an instance of a manifest list. Blobs the destination already has are not
+// copied again, and up to BlobWorkers blobs are copied concurrently when src and dest support it.
+func (c RegistryClient) copyInstance(ctx context.Context, src types.ImageSource, dest types.ImageDestination, instance *digest.Digest) error {
+	raw, mimeType, err := src.GetManifest(ctx, instance)
+	if err != nil {
+		return err
+	}
+	m, err := manifest.FromBlob(raw, mimeType)
+	if err != nil {
+		return err
+	}
+	blobs := []types.BlobInfo{m.ConfigInfo()}
+	for _, layer := range m.LayerInfos() {
+		blobs = append(blobs, layer.BlobInfo)
+	}
+	workers := c.BlobWorkers
+	if workers <= 0 || !src.HasThreadSafeGetBlob() || !dest.HasThreadSafePutBlob() {
+		workers = defaultBlobWorkers
+	}
+	for _, blob := range blobs {
+		c.transfer.expect(blob.Size)
+	}
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(workers)
+	for i, blob := range blobs {
+		if blob.Digest == "" {
+			// Schema1 manifests have no config
+			continue
+		}
+		i, blob := i, blob
+		group.Go(func() error {
+			return c.copyBlob(groupCtx, src, dest, blob, i == 0)
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+	if instance != nil {
+		return dest.PutManifest(ctx, raw, instance)
+	}
+	return nil
+}
+
+// copyBlob copies blob from src to dest unless dest already has it, within the bandwidth of the Bandwidth limiter.
+func (c RegistryClient) copyBlob(ctx context.Context, src types.ImageSource, dest types.ImageDestination, blob types.BlobInfo, isConfig bool) error {
+	if reused, _, err := dest.TryReusingBlob(ctx, blob, none.NoCache, false); err == nil && reused {
+		c.transfer.reused(blob.Size)
+		return nil
+	}
+	stream, size, err := src.GetBlob(ctx, blob, none.NoCache)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get blob %s", blob.Digest)
+	}
+	defer stream.Close()
+	if c.Bandwidth != nil {
+		stream = c.Bandwidth.reader(ctx, stream)
+	}
+	stream = c.transfer.reader(stream)
+	if blob.Size <= 0 {
+		blob.Size = size
+	}
+	if _, err := dest.PutBlob(ctx, stream, blob, none.NoCache, isConfig); err != nil {
+		return errors.Wrapf(err, "failed to push blob %s", blob.Digest)
+	}
+	return nil
+}
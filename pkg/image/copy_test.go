@@ -0,0 +1,93 @@
+package image
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestTargetImage(t *testing.T) {
+	assert := assertlib.New(t)
+
+	assert.Equal("registry.example.com/rancher/shell:v0.1.22", TargetImage("registry.example.com/", "rancher/shell:v0.1.22"))
+	assert.Equal("registry.example.com/rancher/busybox:1.36", TargetImage("registry.example.com", "busybox:1.36"))
+	assert.Equal("registry.example.com/quay.io/skopeo/stable:v1", TargetImage("registry.example.com", "quay.io/skopeo/stable:v1"))
+	assert.Equal("registry.example.com/rancher/shell:v0.1.22", TargetImage("registry.example.com", "rancher/shell:v0.1.22@sha256:0000000000000000000000000000000000000000000000000000000000000000"))
+}
+
+func TestImageCopierCopy(t *testing.T) {
+	assert := assertlib.New(t)
+
+	source := newFakeRegistry(t)
+	manifestDigest := source.AddImage("rancher/shell", "v0.1.22", []byte("layer"))
+	dest := newFakeRegistry(t)
+
+	var progress []string
+	copier := ImageCopier{
+		Client:         source.client(),
+		Registry:       dest.Host(),
+		SourceRegistry: source.Host(),
+		Progress: func(result CopyResult) {
+			progress = append(progress, result.Image)
+		},
+	}
+	results, err := copier.Copy(context.Background(), ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Windows},
+		{Image: "rancher/shell:v0.1.21", OS: Linux},
+	})
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal("rancher/shell:v0.1.21", imageErrs[0].Image)
+	}
+	if assert.Len(results, 2) {
+		assert.Equal(dest.Host()+"/rancher/shell:v0.1.22", results[1].Target)
+		assert.Equal(manifestDigest, results[1].Digest)
+		assert.NoError(results[1].Err)
+	}
+	assert.ElementsMatch([]string{"rancher/shell:v0.1.21", "rancher/shell:v0.1.22"}, progress)
+
+	digest, err := dest.client().Digest(context.Background(), dest.Host()+"/rancher/shell:v0.1.22", Linux)
+	assert.NoError(err)
+	assert.Equal(manifestDigest, digest)
+}
+
+func TestImageCopierCopyStateFile(t *testing.T) {
+	assert := assertlib.New(t)
+
+	source := newFakeRegistry(t)
+	shellDigest := source.AddImage("rancher/shell", "v0.1.22", []byte("shell"))
+	dest := newFakeRegistry(t)
+	copier := ImageCopier{
+		Client:         source.client(),
+		Registry:       dest.Host(),
+		SourceRegistry: source.Host(),
+		StateFile:      filepath.Join(t.TempDir(), "copy-state.json"),
+	}
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/rancher-agent:v2.8.0", OS: Linux},
+	}
+	results, err := copier.Copy(context.Background(), list)
+	assert.Error(err)
+	if assert.Len(results, 2) {
+		assert.False(results[1].Skipped)
+		assert.Equal(shellDigest, results[1].Digest)
+	}
+
+	// The copied image is skipped, the failed image is copied again
+	agentDigest := source.AddImage("rancher/rancher-agent", "v2.8.0", []byte("agent"))
+	results, err = copier.Copy(context.Background(), list)
+	assert.NoError(err)
+	assert.Equal([]CopyResult{
+		{Image: "rancher/rancher-agent:v2.8.0", OS: Linux, Target: dest.Host() + "/rancher/rancher-agent:v2.8.0", Digest: agentDigest},
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Target: dest.Host() + "/rancher/shell:v0.1.22", Digest: shellDigest, Skipped: true},
+	}, results)
+
+	state, err := readCopyState(copier.StateFile)
+	assert.NoError(err)
+	assert.Len(state.Images, 2)
+	assert.Equal(agentDigest, state.Images[dest.Host()+"/rancher/rancher-agent:v2.8.0"].Digest)
+}
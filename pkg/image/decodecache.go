@@ -0,0 +1,70 @@
+package image
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+type decodeCacheContextKey struct{}
+
+// decodeCache memoizes the values and questions files decoded during an export, so that the charts scanned for each
+// OS type, architecture and Rancher version of an export are only decoded once. Files are keyed by path, modification
+// time and size, so that a file changing during the export is decoded again. The decoded documents are shared by every
+// caller and must not be modified. All methods are safe to call on a nil cache, in which case files are decoded every
+// time.
+type decodeCache struct {
+	lock    sync.Mutex
+	entries map[decodeCacheKey]*decodeCacheEntry
+}
+
+type decodeCacheKey struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+type decodeCacheEntry struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+// WithDecodeCache returns a context carrying a cache of the files decoded by the exports run with it, see
+// GetImagesForOSTypes, e.g. to share the decoded chart files between the exports of several Rancher versions. Exports
+// run with a context without a cache use their own.
+func WithDecodeCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, decodeCacheContextKey{}, &decodeCache{entries: make(map[decodeCacheKey]*decodeCacheEntry)})
+}
+
+// decodeCacheFromContext returns the decode cache of ctx, or nil if it has none.
+func decodeCacheFromContext(ctx context.Context) *decodeCache {
+	cache, _ := ctx.Value(decodeCacheContextKey{}).(*decodeCache)
+	return cache
+}
+
+// decode returns the document decoded from the file at path by decodeFile, decoding it on the first call for the
+// current version of the file only. The file is decoded without caching if it cannot be stat'ed, so that decodeFile
+// reports the error.
+func (c *decodeCache) decode(path string, decodeFile func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return decodeFile()
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return decodeFile()
+	}
+	key := decodeCacheKey{path: path, modTime: info.ModTime(), size: info.Size()}
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &decodeCacheEntry{}
+		c.entries[key] = entry
+	}
+	c.lock.Unlock()
+	entry.once.Do(func() {
+		entry.value, entry.err = decodeFile()
+	})
+	return entry.value, entry.err
+}
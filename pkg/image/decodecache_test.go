@@ -0,0 +1,46 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCache(t *testing.T) {
+	assert := assertlib.New(t)
+
+	path := filepath.Join(t.TempDir(), "values.yaml")
+	assert.NoError(os.WriteFile(path, []byte("image:\n  repository: rancher/shell\n  tag: v0.1.22\n"), 0644))
+
+	decodes := 0
+	cache := decodeCacheFromContext(WithDecodeCache(context.Background()))
+	decode := func() (interface{}, error) {
+		decodes++
+		return readValuesFile(path, ValuesLimits{})
+	}
+	for i := 0; i < 2; i++ {
+		values, err := cache.decode(path, decode)
+		assert.NoError(err)
+		assert.Contains(values, "image")
+	}
+	assert.Equal(1, decodes)
+
+	// A modified file is decoded again
+	assert.NoError(os.WriteFile(path, []byte("image:\n  repository: rancher/shell\n  tag: v0.1.23\n"), 0644))
+	assert.NoError(os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)))
+	_, err := cache.decode(path, decode)
+	assert.NoError(err)
+	assert.Equal(2, decodes)
+
+	// Files are decoded every time without a cache
+	_, err = decodeCacheFromContext(context.Background()).decode(path, decode)
+	assert.NoError(err)
+	assert.Equal(3, decodes)
+
+	_, err = decodeValuesFile(context.Background(), filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.True(os.IsNotExist(err))
+}
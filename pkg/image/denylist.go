@@ -0,0 +1,119 @@
+package image
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	img "github.com/rancher/rke/types/image"
+)
+
+// DenyListPolicy is the policy name of the violations of a DenyList.
+const DenyListPolicy = "deny-list"
+
+// DenyList is a policy of images that must not be exported, e.g. after a CVE embargo decision. It is loaded from a
+// YAML file like:
+//
+//	denied:
+//	- image: rancher/mirrored-library-nginx:1.21.1
+//	  reason: CVE-2023-44487, use 1.25.3 or later
+//	- image: quay.io/jetstack/*:v1.10.*
+//	  reason: end of life
+//	  action: warn
+type DenyList struct {
+	Denied []DenyRule `yaml:"denied"`
+}
+
+// DenyRule denies the images matching a pattern.
+type DenyRule struct {
+	// Image is the denied image, or a glob or regex: pattern of the denied images, see ImageFilter. Exported images
+	// match it by their name, their name without digest, or their upstream name if they are mirrored.
+	Image string `yaml:"image"`
+	// Reason is why the images are denied.
+	Reason string `yaml:"reason"`
+	// Action is whether the denied images fail the export or are only reported, PolicyActionFail if not set.
+	Action PolicyAction `yaml:"action"`
+
+	filter *ImageFilter
+}
+
+// LoadDenyList reads the deny list file at path.
+func LoadDenyList(path string) (*DenyList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var denyList DenyList
+	if err := decodeYAMLFile(file, &denyList); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode deny list file %s", path)
+	}
+	for i := range denyList.Denied {
+		rule := &denyList.Denied[i]
+		if rule.Image == "" {
+			return nil, errors.Errorf("invalid deny list file %s: rule %d has no image", path, i+1)
+		}
+		if rule.Reason == "" {
+			return nil, errors.Errorf("invalid deny list file %s: image %s has no reason", path, rule.Image)
+		}
+		if rule.Action, err = ParsePolicyAction(string(rule.Action)); err != nil {
+			return nil, errors.Wrapf(err, "invalid deny list file %s: image %s", path, rule.Image)
+		}
+		if rule.filter, err = NewImageFilter([]string{rule.Image}); err != nil {
+			return nil, errors.Wrapf(err, "invalid deny list file %s", path)
+		}
+	}
+	return &denyList, nil
+}
+
+// Check returns the entries of list denied by the deny list, each denied by the first of the rules matching it.
+func (d *DenyList) Check(list ImageList) PolicyViolations {
+	if d == nil {
+		return nil
+	}
+	var violations PolicyViolations
+	for _, entry := range list {
+		names := imagePolicyNames(entry.Image)
+		for _, rule := range d.Denied {
+			if !rule.match(names) {
+				continue
+			}
+			violations = append(violations, PolicyViolation{
+				Policy:  DenyListPolicy,
+				Image:   entry.Image,
+				OS:      entry.OS,
+				Sources: entry.Sources,
+				Rule:    rule.Image,
+				Reason:  rule.Reason,
+				Action:  rule.Action,
+			})
+			break
+		}
+	}
+	sortPolicyViolations(violations)
+	return violations
+}
+
+func (r DenyRule) match(names []string) bool {
+	for _, name := range names {
+		if r.filter.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// imagePolicyNames returns the names an image is checked against policies by: its name, its name without digest if it
+// is pinned, and its upstream name if it is mirrored.
+func imagePolicyNames(image string) []string {
+	names := []string{image}
+	tagged, _, pinned := strings.Cut(image, "@")
+	if pinned {
+		names = append(names, tagged)
+	}
+	if upstream, ok := img.Mirrors[tagged]; ok && upstream != tagged {
+		names = append(names, upstream)
+	}
+	return names
+}
@@ -0,0 +1,96 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	img "github.com/rancher/rke/types/image"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestLoadDenyList(t *testing.T) {
+	assert := assertlib.New(t)
+
+	path := filepath.Join(t.TempDir(), "deny-list.yaml")
+	assert.NoError(os.WriteFile(path, []byte(`denied:
+- image: rancher/mirrored-library-nginx:1.21.1
+  reason: CVE-2023-44487
+- image: quay.io/jetstack/*:v1.10.*
+  reason: end of life
+  action: warn
+- image: regex:^rancher/hardened-.*:v1\.2[0-3]\..*
+  reason: unsupported Kubernetes version
+`), 0644))
+	denyList, err := LoadDenyList(path)
+	assert.NoError(err)
+	if assert.Len(denyList.Denied, 3) {
+		assert.Equal(PolicyActionFail, denyList.Denied[0].Action)
+		assert.Equal(PolicyActionWarn, denyList.Denied[1].Action)
+	}
+
+	for content, message := range map[string]string{
+		"denied:\n- reason: CVE-2023-44487\n":                           "rule 1 has no image",
+		"denied:\n- image: busybox:1.36\n":                              "image busybox:1.36 has no reason",
+		"denied:\n- image: busybox:1.36\n  reason: r\n  action: drop\n": `invalid policy action "drop"`,
+		"denied:\n- image: regex:(\n  reason: r\n":                      "invalid image pattern",
+	} {
+		assert.NoError(os.WriteFile(path, []byte(content), 0644))
+		_, err := LoadDenyList(path)
+		if assert.Error(err) {
+			assert.Contains(err.Error(), message)
+		}
+	}
+}
+
+func TestDenyListCheck(t *testing.T) {
+	assert := assertlib.New(t)
+
+	path := filepath.Join(t.TempDir(), "deny-list.yaml")
+	assert.NoError(os.WriteFile(path, []byte(`denied:
+- image: quay.io/jetstack/cert-manager-controller:v1.10.*
+  reason: end of life
+  action: warn
+- image: rancher/mirrored-library-nginx:1.21.1
+  reason: CVE-2023-44487
+- image: rancher/*
+  reason: never reported, the first matching rule wins
+`), 0644))
+	denyList, err := LoadDenyList(path)
+	assert.NoError(err)
+
+	// Mirrored images are denied by their upstream name too
+	defer delete(img.Mirrors, "rancher/mirrored-jetstack-cert-manager-controller:v1.10.2")
+	img.Mirrors["rancher/mirrored-jetstack-cert-manager-controller:v1.10.2"] = "quay.io/jetstack/cert-manager-controller:v1.10.2"
+	violations := denyList.Check(ImageList{
+		{Image: "rancher/mirrored-library-nginx:1.21.1@sha256:1111111111111111111111111111111111111111111111111111111111111111", OS: Linux,
+			Sources: []string{"rancher-monitoring:102.0.0"}},
+		{Image: "rancher/mirrored-jetstack-cert-manager-controller:v1.10.2", OS: Linux},
+		{Image: "busybox:1.36", OS: Linux},
+	})
+	assert.Equal(PolicyViolations{
+		{
+			Policy: DenyListPolicy,
+			Image:  "rancher/mirrored-jetstack-cert-manager-controller:v1.10.2",
+			OS:     Linux,
+			Rule:   "quay.io/jetstack/cert-manager-controller:v1.10.*",
+			Reason: "end of life",
+			Action: PolicyActionWarn,
+		},
+		{
+			Policy:  DenyListPolicy,
+			Image:   "rancher/mirrored-library-nginx:1.21.1@sha256:1111111111111111111111111111111111111111111111111111111111111111",
+			OS:      Linux,
+			Sources: []string{"rancher-monitoring:102.0.0"},
+			Rule:    "rancher/mirrored-library-nginx:1.21.1",
+			Reason:  "CVE-2023-44487",
+			Action:  PolicyActionFail,
+		},
+	}, violations)
+	assert.Len(violations.Failures(), 1)
+	assert.Equal("linux image rancher/mirrored-library-nginx:1.21.1@sha256:1111111111111111111111111111111111111111111111111111111111111111 "+
+		"from rancher-monitoring:102.0.0 violates deny-list rule rancher/mirrored-library-nginx:1.21.1: CVE-2023-44487", violations[1].String())
+
+	var nilDenyList *DenyList
+	assert.Empty(nilDenyList.Check(ImageList{{Image: "busybox:1.36", OS: Linux}}))
+}
@@ -0,0 +1,151 @@
+package image
+
+import (
+	"sort"
+	"strings"
+)
+
+// ImageDiff is the difference between two image lists, e.g. the lists of two Rancher releases.
+type ImageDiff struct {
+	// Added are the images only found in the new list.
+	Added ImageList
+	// Removed are the images only found in the old list.
+	Removed ImageList
+	// Retagged are the images found in both lists with a different tag.
+	Retagged []RetaggedImage
+}
+
+// RetaggedImage is an image whose repository is in both lists of an ImageDiff, but with a different tag.
+type RetaggedImage struct {
+	Old ImageEntry
+	New ImageEntry
+}
+
+// Empty returns true if the lists of the diff are identical.
+func (d ImageDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Retagged) == 0
+}
+
+// DiffImageLists compares oldList to newList. Images are compared per OS. An image removed from a repository for which
+// a new tag was added is reported as retagged rather than removed and added; when a repository has several tags
+// added or removed, they are paired in sorted order and the remaining ones are reported as added or removed.
+func DiffImageLists(oldList, newList ImageList) ImageDiff {
+	type repositoryKey struct {
+		os         OSType
+		repository string
+	}
+	oldEntries := entriesByImage(oldList)
+	newEntries := entriesByImage(newList)
+
+	removedByRepository := make(map[repositoryKey]ImageList)
+	for key, entry := range oldEntries {
+		if _, ok := newEntries[key]; !ok {
+			repository, _ := splitImageTag(entry.Image)
+			repoKey := repositoryKey{os: entry.OS, repository: repository}
+			removedByRepository[repoKey] = append(removedByRepository[repoKey], entry)
+		}
+	}
+	addedByRepository := make(map[repositoryKey]ImageList)
+	for key, entry := range newEntries {
+		if _, ok := oldEntries[key]; !ok {
+			repository, _ := splitImageTag(entry.Image)
+			repoKey := repositoryKey{os: entry.OS, repository: repository}
+			addedByRepository[repoKey] = append(addedByRepository[repoKey], entry)
+		}
+	}
+
+	var diff ImageDiff
+	for repoKey, removed := range removedByRepository {
+		added := addedByRepository[repoKey]
+		sortImageList(removed)
+		sortImageList(added)
+		for len(removed) > 0 && len(added) > 0 {
+			diff.Retagged = append(diff.Retagged, RetaggedImage{Old: removed[0], New: added[0]})
+			removed, added = removed[1:], added[1:]
+		}
+		diff.Removed = append(diff.Removed, removed...)
+		addedByRepository[repoKey] = added
+	}
+	for _, added := range addedByRepository {
+		diff.Added = append(diff.Added, added...)
+	}
+
+	sortImageList(diff.Added)
+	sortImageList(diff.Removed)
+	sort.Slice(diff.Retagged, func(i, j int) bool {
+		return lessImageEntry(diff.Retagged[i].New, diff.Retagged[j].New)
+	})
+	return diff
+}
+
+// GroupBySource splits the diff per source chart, so changes can be reviewed chart by chart. Images that do not come
+// from a chart are grouped by their sources instead, e.g. "system". An image with several charts or sources is part
+// of the diff of each one of them. Retagged images are grouped by the sources of their new entry.
+func (d ImageDiff) GroupBySource() map[string]ImageDiff {
+	groups := make(map[string]ImageDiff)
+	for _, entry := range d.Added {
+		for _, source := range diffSources(entry) {
+			group := groups[source]
+			group.Added = append(group.Added, entry)
+			groups[source] = group
+		}
+	}
+	for _, entry := range d.Removed {
+		for _, source := range diffSources(entry) {
+			group := groups[source]
+			group.Removed = append(group.Removed, entry)
+			groups[source] = group
+		}
+	}
+	for _, retagged := range d.Retagged {
+		for _, source := range diffSources(retagged.New) {
+			group := groups[source]
+			group.Retagged = append(group.Retagged, retagged)
+			groups[source] = group
+		}
+	}
+	return groups
+}
+
+// diffSources returns what an entry is grouped by in ImageDiff.GroupBySource.
+func diffSources(entry ImageEntry) []string {
+	if len(entry.Charts) > 0 {
+		return entry.Charts
+	}
+	if len(entry.Sources) > 0 {
+		return entry.Sources
+	}
+	return []string{"unknown"}
+}
+
+func entriesByImage(list ImageList) map[string]ImageEntry {
+	entries := make(map[string]ImageEntry, len(list))
+	for _, entry := range list {
+		entries[entry.OS.String()+" "+entry.Image] = entry
+	}
+	return entries
+}
+
+// splitImageTag splits image into its repository and tag or digest. The tag is empty if image has neither.
+func splitImageTag(image string) (string, string) {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+func sortImageList(list ImageList) {
+	sort.Slice(list, func(i, j int) bool {
+		return lessImageEntry(list[i], list[j])
+	})
+}
+
+func lessImageEntry(a, b ImageEntry) bool {
+	if a.OS != b.OS {
+		return a.OS.less(b.OS)
+	}
+	return a.Image < b.Image
+}
@@ -0,0 +1,66 @@
+package image
+
+import (
+	"strings"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestDiffImageLists(t *testing.T) {
+	assert := assertlib.New(t)
+
+	oldList := ImageList{
+		{Image: "rancher/fleet:v0.7.0", OS: Linux, Sources: []string{"fleet:102.1.0"}, Charts: []string{"fleet:102.1.0"}},
+		{Image: "rancher/shell:v0.1.20", OS: Linux, Sources: []string{"core"}},
+		{Image: "rancher/kube-api-auth:v0.1.9", OS: Linux, Sources: []string{"system"}},
+		{Image: "rancher/fleet:v0.7.0", OS: Windows, Sources: []string{"fleet:102.1.0"}, Charts: []string{"fleet:102.1.0"}},
+	}
+	newList := ImageList{
+		{Image: "rancher/fleet:v0.8.0", OS: Linux, Sources: []string{"fleet:102.2.0"}, Charts: []string{"fleet:102.2.0"}},
+		{Image: "rancher/shell:v0.1.20", OS: Linux, Sources: []string{"core"}},
+		{Image: "rancher/mirrored-pause:3.6", OS: Linux, Sources: []string{"system"}},
+		{Image: "rancher/fleet:v0.7.0", OS: Windows, Sources: []string{"fleet:102.1.0"}, Charts: []string{"fleet:102.1.0"}},
+	}
+
+	diff := DiffImageLists(oldList, newList)
+	assert.Equal(ImageList{newList[2]}, diff.Added)
+	assert.Equal(ImageList{oldList[2]}, diff.Removed)
+	assert.Equal([]RetaggedImage{{Old: oldList[0], New: newList[0]}}, diff.Retagged)
+	assert.False(diff.Empty())
+
+	groups := diff.GroupBySource()
+	assert.Len(groups, 2)
+	assert.Equal([]RetaggedImage{{Old: oldList[0], New: newList[0]}}, groups["fleet:102.2.0"].Retagged)
+	assert.Equal(ImageList{newList[2]}, groups["system"].Added)
+	assert.Equal(ImageList{oldList[2]}, groups["system"].Removed)
+
+	assert.True(DiffImageLists(oldList, oldList).Empty())
+}
+
+func TestSplitImageTag(t *testing.T) {
+	testCases := []struct {
+		image      string
+		repository string
+		tag        string
+	}{
+		{"rancher/shell:v0.1.20", "rancher/shell", "v0.1.20"},
+		{"registry.example.com:5000/rancher/shell", "registry.example.com:5000/rancher/shell", ""},
+		{"registry.example.com:5000/rancher/shell:v0.1.20", "registry.example.com:5000/rancher/shell", "v0.1.20"},
+		{"rancher/shell@sha256:abc", "rancher/shell", "sha256:abc"},
+	}
+	for _, tc := range testCases {
+		repository, tag := splitImageTag(tc.image)
+		assertlib.Equal(t, tc.repository, repository, tc.image)
+		assertlib.Equal(t, tc.tag, tag, tc.image)
+	}
+}
+
+func TestReadImageList(t *testing.T) {
+	list, err := ReadImageList(strings.NewReader("# comment\nrancher/shell:v0.1.20 core\n\nrancher/fleet:v0.7.0 fleet:102.1.0,system\n"), Windows)
+	assertlib.NoError(t, err)
+	assertlib.Equal(t, ImageList{
+		{Image: "rancher/fleet:v0.7.0", OS: Windows, Sources: []string{"fleet:102.1.0", "system"}},
+		{Image: "rancher/shell:v0.1.20", OS: Windows, Sources: []string{"core"}},
+	}, list)
+}
@@ -0,0 +1,103 @@
+package image
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Distribution is a Kubernetes distribution of the downstream clusters Rancher provisions.
+type Distribution string
+
+const (
+	// DistributionRKE is RKE, whose images are the RKE system images, see System.
+	DistributionRKE Distribution = "rke"
+	// DistributionRKE2 is RKE2, whose images are those of its releases, see RKE2Images.
+	DistributionRKE2 Distribution = "rke2"
+	// DistributionK3s is K3s, whose images are those of its releases, see K3sImages.
+	DistributionK3s Distribution = "k3s"
+)
+
+// Distributions are the Kubernetes distributions whose images can be exported.
+var Distributions = []Distribution{DistributionRKE, DistributionRKE2, DistributionK3s}
+
+// ParseDistribution returns the Distribution called name, case insensitively, e.g. rke2 or K3s.
+func ParseDistribution(name string) (Distribution, error) {
+	for _, distribution := range Distributions {
+		if strings.EqualFold(strings.TrimSpace(name), string(distribution)) {
+			return distribution, nil
+		}
+	}
+	return "", errors.Errorf("unknown Kubernetes distribution %q, must be one of %v", name, Distributions)
+}
+
+// ParseDistributions parses a comma separated list of distributions, e.g. rke2,k3s.
+func ParseDistributions(s string) ([]Distribution, error) {
+	var distributions []Distribution
+	for _, field := range strings.Split(s, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		distribution, err := ParseDistribution(field)
+		if err != nil {
+			return nil, err
+		}
+		distributions = append(distributions, distribution)
+	}
+	return distributions, nil
+}
+
+// sourceDistributions are the distributions of the sources of the images of downstream clusters.
+var sourceDistributions = map[string]Distribution{
+	"system":     DistributionRKE,
+	"rke2All":    DistributionRKE2,
+	"rke2":       DistributionRKE2,
+	"k3sUpgrade": DistributionK3s,
+	"k3s":        DistributionK3s,
+}
+
+// sourceDistribution returns the distribution of source, if it provides images of downstream clusters. Like
+// sourceCategory, the sources of the images of a release, e.g. rke2:v1.27.10+rke2r1, are looked up by the name before
+// their version.
+func sourceDistribution(source string) (Distribution, bool) {
+	if distribution, ok := sourceDistributions[source]; ok {
+		return distribution, true
+	}
+	if name, _, ok := strings.Cut(source, ":"); ok {
+		distribution, ok := sourceDistributions[name]
+		return distribution, ok
+	}
+	return "", false
+}
+
+// distributionExported returns whether the images of distribution are exported, i.e. whether it is one of
+// Distributions, or Distributions is empty.
+func (c ExportConfig) distributionExported(distribution Distribution) bool {
+	if len(c.Distributions) == 0 {
+		return true
+	}
+	for _, exported := range c.Distributions {
+		if exported == distribution {
+			return true
+		}
+	}
+	return false
+}
+
+// ByDistribution splits the list by the Kubernetes distributions of the sources of its images. An image used by
+// several distributions is part of each of them, and the images of no distribution, e.g. chart images, are left out.
+func (l ImageList) ByDistribution() map[Distribution]ImageList {
+	lists := make(map[Distribution]ImageList)
+	for _, entry := range l {
+		distributions := make(map[Distribution]bool)
+		for _, source := range entry.Sources {
+			if distribution, ok := sourceDistribution(source); ok {
+				distributions[distribution] = true
+			}
+		}
+		for distribution := range distributions {
+			lists[distribution] = append(lists[distribution], entry)
+		}
+	}
+	return lists
+}
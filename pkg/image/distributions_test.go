@@ -0,0 +1,56 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestParseDistributions(t *testing.T) {
+	assert := assertlib.New(t)
+
+	distributions, err := ParseDistributions("RKE2, k3s,")
+	assert.NoError(err)
+	assert.Equal([]Distribution{DistributionRKE2, DistributionK3s}, distributions)
+	_, err = ParseDistributions("rke2,eks")
+	assert.Error(err)
+}
+
+func TestImageListByDistribution(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet:v0.7.0", OS: Linux, Sources: []string{"fleet:102.1.0"}, Charts: []string{"fleet:102.1.0"}},
+		{Image: "rancher/hyperkube:v1.27.10-rancher1", OS: Linux, Sources: []string{"system:v1.27.10-rancher1-1"}},
+		{Image: "rancher/k3s-upgrade:v1.27.10-k3s1", OS: Linux, Sources: []string{"k3s:v1.27.10+k3s1"}},
+		{Image: "rancher/mirrored-pause:3.6", OS: Linux, Sources: []string{"k3s:v1.27.10+k3s1", "rke2:v1.27.10+rke2r1"}},
+		{Image: "rancher/rke2-upgrade:v1.27.10-rke2r1", OS: Linux, Sources: []string{"rke2:v1.27.10+rke2r1"}},
+	}
+
+	assert.Equal(map[Distribution]ImageList{
+		DistributionRKE:  {list[1]},
+		DistributionRKE2: {list[3], list[4]},
+		DistributionK3s:  {list[2], list[3]},
+	}, list.ByDistribution())
+}
+
+func TestExportConfigDistributionExported(t *testing.T) {
+	assert := assertlib.New(t)
+
+	assert.True(ExportConfig{}.distributionExported(DistributionRKE))
+	config := ExportConfig{Distributions: []Distribution{DistributionRKE2}}
+	assert.True(config.distributionExported(DistributionRKE2))
+	assert.False(config.distributionExported(DistributionRKE))
+	assert.Nil(imageSourceNamed(config, "system"))
+	assert.Nil(imageSourceNamed(ExportConfig{K3sReleases: map[string]interface{}{}, Distributions: []Distribution{DistributionRKE}}, "k3s"))
+}
+
+// imageSourceNamed returns the source called name registered for an export with config, if any.
+func imageSourceNamed(config ExportConfig, name string) ImageSource {
+	for _, source := range imageSources(config, map[OSType]OSImageInputs{Linux: {}}) {
+		if source.Name() == name {
+			return source
+		}
+	}
+	return nil
+}
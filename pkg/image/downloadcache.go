@@ -0,0 +1,222 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDownloadCacheTTL is how long the downloads of a DownloadCache without TTL are used without checking whether
+// they changed.
+const DefaultDownloadCacheTTL = 24 * time.Hour
+
+// DownloadCache caches the files downloaded by exports on disk, e.g. the KDM data and the image lists of the RKE2 and
+// K3s releases, so that repeated exports, e.g. in CI, do not download them again. Downloads are keyed by URL, and the
+// digest of their content is checked when they are read from the cache. Downloads older than TTL are revalidated with
+// their ETag, if any, and downloaded again if they changed. All methods are safe to call on a nil cache, in which case
+// files are downloaded every time.
+type DownloadCache struct {
+	// Dir is the directory the downloads are stored in.
+	Dir string `yaml:"dir"`
+	// TTL is how long downloads are used without checking whether they changed, DefaultDownloadCacheTTL if zero.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// DownloadStatusError is returned by DownloadCache.Download for the responses without content.
+type DownloadStatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (e *DownloadStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %s downloading %s", e.Status, e.URL)
+}
+
+// downloadCacheEntry is the metadata of a download stored in a DownloadCache, next to its content.
+type downloadCacheEntry struct {
+	URL        string    `json:"url"`
+	ETag       string    `json:"etag,omitempty"`
+	Digest     string    `json:"digest"`
+	Downloaded time.Time `json:"downloaded"`
+}
+
+type downloadCacheContextKey struct{}
+
+// WithDownloadCache returns a context carrying cache, which the exports run with it download their files through.
+func WithDownloadCache(ctx context.Context, cache *DownloadCache) context.Context {
+	if cache == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, downloadCacheContextKey{}, cache)
+}
+
+// downloadCacheFromContext returns the download cache of ctx, or nil if it has none.
+func downloadCacheFromContext(ctx context.Context) *DownloadCache {
+	cache, _ := ctx.Value(downloadCacheContextKey{}).(*DownloadCache)
+	return cache
+}
+
+// Download returns the content of the file at url, from the cache if it was downloaded less than TTL ago or has not
+// changed since, downloading it otherwise. Responses other than 200 OK are returned as a DownloadStatusError and are
+// not cached. A cache that cannot be read or written is logged and bypassed.
+func (c *DownloadCache) Download(ctx context.Context, url string) ([]byte, error) {
+	if c == nil || c.Dir == "" {
+		content, _, err := download(ctx, url, "")
+		return content, err
+	}
+	entry, cached, err := c.read(url)
+	if err != nil {
+		logrus.Debugf("[download-cache] ignoring cached download of %s: %v", url, err)
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultDownloadCacheTTL
+	}
+	if cached != nil && time.Since(entry.Downloaded) < ttl {
+		return cached, nil
+	}
+
+	var etag string
+	if cached != nil {
+		etag = entry.ETag
+	}
+	content, newETag, err := download(ctx, url, etag)
+	var statusErr *DownloadStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotModified {
+		content, newETag, err = cached, etag, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.write(url, newETag, content); err != nil {
+		logrus.Warnf("[download-cache] failed to cache the download of %s: %v", url, err)
+	}
+	return content, nil
+}
+
+// path returns the path of the content of the download of url in the cache, its metadata being stored next to it
+// with a .json extension.
+func (c *DownloadCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:]))
+}
+
+// read returns the cached download of url and its metadata, or nil content if url was not downloaded or its content
+// does not match its digest.
+func (c *DownloadCache) read(url string) (downloadCacheEntry, []byte, error) {
+	var entry downloadCacheEntry
+	path := c.path(url)
+	metadata, err := os.ReadFile(path + ".json")
+	if os.IsNotExist(err) {
+		return entry, nil, nil
+	}
+	if err != nil {
+		return entry, nil, err
+	}
+	if err := json.Unmarshal(metadata, &entry); err != nil {
+		return entry, nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return entry, nil, err
+	}
+	if entry.URL != url || contentDigest(content) != entry.Digest {
+		return entry, nil, errors.New("content does not match its digest")
+	}
+	return entry, content, nil
+}
+
+// write stores the download of url in the cache, writing its content before its metadata so that an interrupted
+// write is not read back.
+func (c *DownloadCache) write(url, etag string, content []byte) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	path := c.path(url)
+	metadata, err := json.Marshal(downloadCacheEntry{URL: url, ETag: etag, Digest: contentDigest(content), Downloaded: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomically(path, content); err != nil {
+		return err
+	}
+	return writeFileAtomically(path+".json", metadata)
+}
+
+// writeFileAtomically writes data to a temporary file renamed to path, so that readers never see a partial file.
+func writeFileAtomically(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func contentDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+type httpClientContextKey struct{}
+
+// WithHTTPClient returns a context carrying client, which the exports run with it download their files and query
+// GitHub with instead of the default HTTP client, e.g. to trust the CA of a TLS intercepting proxy.
+func WithHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	if client == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, httpClientContextKey{}, client)
+}
+
+// httpClientFromContext returns the HTTP client of ctx, or the default HTTP client if it has none.
+func httpClientFromContext(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(httpClientContextKey{}).(*http.Client); ok {
+		return client
+	}
+	return http.DefaultClient
+}
+
+// download downloads the file at url with the HTTP client of ctx, conditionally on it not matching etag if set. It
+// returns its content along with its ETag.
+func download(ctx context.Context, url, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := httpClientFromContext(ctx).Do(req)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to download %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", &DownloadStatusError{URL: url, Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+	content, err := io.ReadAll(progressFromContext(ctx).reader(resp.Body))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to read %s", url)
+	}
+	return content, resp.Header.Get("ETag"), nil
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestDownloadCache(t *testing.T) {
+	assert := assertlib.New(t)
+
+	content := "rancher/rke2-runtime:v1.27.10-rke2r1\n"
+	requests, downloads := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/missing.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	cache := &DownloadCache{Dir: t.TempDir(), TTL: time.Hour}
+	for i := 0; i < 2; i++ {
+		body, err := cache.Download(context.Background(), server.URL+"/images.txt")
+		assert.NoError(err)
+		assert.Equal(content, string(body))
+	}
+	assert.Equal(1, requests)
+
+	// Expired downloads are revalidated with their ETag
+	cache.TTL = time.Nanosecond
+	body, err := cache.Download(context.Background(), server.URL+"/images.txt")
+	assert.NoError(err)
+	assert.Equal(content, string(body))
+	assert.Equal(2, requests)
+	assert.Equal(1, downloads)
+
+	// Downloads not matching their digest are downloaded again
+	assert.NoError(os.WriteFile(cache.path(server.URL+"/images.txt"), []byte("corrupted"), 0644))
+	body, err = cache.Download(context.Background(), server.URL+"/images.txt")
+	assert.NoError(err)
+	assert.Equal(content, string(body))
+	assert.Equal(2, downloads)
+
+	var statusErr *DownloadStatusError
+	_, err = cache.Download(context.Background(), server.URL+"/missing.txt")
+	if assert.True(errors.As(err, &statusErr)) {
+		assert.Equal(http.StatusNotFound, statusErr.StatusCode)
+	}
+
+	// Files are downloaded every time without a cache
+	var noCache *DownloadCache
+	for i := 0; i < 2; i++ {
+		body, err := noCache.Download(context.Background(), server.URL+"/images.txt")
+		assert.NoError(err)
+		assert.Equal(content, string(body))
+	}
+	assert.Equal(4, downloads)
+}
+
+func TestDownloadCacheHTTPClient(t *testing.T) {
+	assert := assertlib.New(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("rancher/rke2-runtime:v1.27.10-rke2r1\n"))
+	}))
+	defer server.Close()
+
+	// The default HTTP client does not trust the certificate of the server, the client of the context does
+	var cache *DownloadCache
+	_, err := cache.Download(context.Background(), server.URL+"/images.txt")
+	assert.Error(err)
+	body, err := cache.Download(WithHTTPClient(context.Background(), server.Client()), server.URL+"/images.txt")
+	assert.NoError(err)
+	assert.Equal("rancher/rke2-runtime:v1.27.10-rke2r1\n", string(body))
+}
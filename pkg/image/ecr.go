@@ -0,0 +1,117 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+// ECRPullThroughCacheRule is an ECR pull-through cache rule, with the fields of the ECR CreatePullThroughCacheRule API.
+type ECRPullThroughCacheRule struct {
+	ECRRepositoryPrefix string `json:"ecrRepositoryPrefix"`
+	UpstreamRegistryURL string `json:"upstreamRegistryUrl"`
+	// CredentialRequired is true if ECR requires a Secrets Manager secret with the credentials of the upstream
+	// registry to create the rule.
+	CredentialRequired bool `json:"credentialRequired"`
+}
+
+// ECRImage is an image of an image list along with its reference in ECR.
+type ECRImage struct {
+	Image    string `json:"image"`
+	ECRImage string `json:"ecrImage"`
+}
+
+// ECRPullThroughCache is what is needed to serve an image list from ECR through pull-through cache rules.
+type ECRPullThroughCache struct {
+	// Rules are the pull-through cache rules to create.
+	Rules []ECRPullThroughCacheRule `json:"rules"`
+	// Images are the images served by the rules.
+	Images []ECRImage `json:"images"`
+	// Unsupported are the images of registries ECR cannot cache, which must be pushed to ECR instead.
+	Unsupported []string `json:"unsupported,omitempty"`
+}
+
+// ecrUpstreamRegistry is a registry supported by ECR pull-through cache rules.
+type ecrUpstreamRegistry struct {
+	prefix             string
+	url                string
+	credentialRequired bool
+}
+
+// ecrUpstreamRegistries are the upstream registries supported by ECR pull-through cache rules, keyed by the registry
+// of the images.
+var ecrUpstreamRegistries = map[string]ecrUpstreamRegistry{
+	"docker.io":           {prefix: "docker-hub", url: "registry-1.docker.io", credentialRequired: true},
+	"public.ecr.aws":      {prefix: "ecr-public", url: "public.ecr.aws"},
+	"quay.io":             {prefix: "quay", url: "quay.io"},
+	"registry.k8s.io":     {prefix: "k8s", url: "registry.k8s.io"},
+	"ghcr.io":             {prefix: "github", url: "ghcr.io", credentialRequired: true},
+	"registry.gitlab.com": {prefix: "gitlab", url: "registry.gitlab.com", credentialRequired: true},
+}
+
+// ECRPullThroughCacheRules returns the pull-through cache rules of ecrRegistry, e.g.
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com, needed to serve the images of list, along with the references of the
+// images in ECR.
+func ECRPullThroughCacheRules(list ImageList, ecrRegistry string) (ECRPullThroughCache, error) {
+	ecrRegistry = strings.TrimSuffix(ecrRegistry, "/")
+	if ecrRegistry == "" {
+		return ECRPullThroughCache{}, errors.New("ECR registry is required")
+	}
+
+	var cache ECRPullThroughCache
+	rules := make(map[string]ECRPullThroughCacheRule)
+	seen := make(map[string]bool)
+	for _, entry := range list {
+		if seen[entry.Image] {
+			continue
+		}
+		seen[entry.Image] = true
+
+		named, err := reference.ParseNormalizedNamed(entry.Image)
+		if err != nil {
+			return ECRPullThroughCache{}, errors.Wrapf(err, "failed to parse image %s", entry.Image)
+		}
+		domain := reference.Domain(named)
+		upstream, ok := ecrUpstreamRegistries[domain]
+		if !ok && strings.HasSuffix(domain, ".azurecr.io") {
+			upstream, ok = ecrUpstreamRegistry{prefix: strings.TrimSuffix(domain, ".azurecr.io"), url: domain, credentialRequired: true}, true
+		}
+		if !ok {
+			cache.Unsupported = append(cache.Unsupported, entry.Image)
+			continue
+		}
+		rules[upstream.prefix] = ECRPullThroughCacheRule{
+			ECRRepositoryPrefix: upstream.prefix,
+			UpstreamRegistryURL: upstream.url,
+			CredentialRequired:  upstream.credentialRequired,
+		}
+		// The path of Docker Hub images always has a namespace in ECR, e.g. library/busybox
+		ecrImage := ecrRegistry + "/" + upstream.prefix + "/" + strings.TrimPrefix(named.String(), domain+"/")
+		cache.Images = append(cache.Images, ECRImage{Image: entry.Image, ECRImage: ecrImage})
+	}
+
+	for _, prefix := range sortedKeys(rules) {
+		cache.Rules = append(cache.Rules, rules[prefix])
+	}
+	sort.Slice(cache.Images, func(i, j int) bool {
+		return cache.Images[i].Image < cache.Images[j].Image
+	})
+	sort.Strings(cache.Unsupported)
+	return cache, nil
+}
+
+// WriteECRPullThroughCacheRules writes the pull-through cache rules of the images of list to w as indented JSON, see
+// ECRPullThroughCacheRules.
+func WriteECRPullThroughCacheRules(w io.Writer, list ImageList, ecrRegistry string) error {
+	cache, err := ECRPullThroughCacheRules(list, ecrRegistry)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(cache)
+}
@@ -0,0 +1,37 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestECRPullThroughCacheRules(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Windows},
+		{Image: "busybox:1.36", OS: Linux},
+		{Image: "quay.io/skopeo/stable:v1", OS: Linux},
+		{Image: "registry.example.com/team/tool:v1", OS: Linux},
+	}
+
+	cache, err := ECRPullThroughCacheRules(list, "123456789012.dkr.ecr.us-east-1.amazonaws.com/")
+	assert.NoError(err)
+	assert.Equal(ECRPullThroughCache{
+		Rules: []ECRPullThroughCacheRule{
+			{ECRRepositoryPrefix: "docker-hub", UpstreamRegistryURL: "registry-1.docker.io", CredentialRequired: true},
+			{ECRRepositoryPrefix: "quay", UpstreamRegistryURL: "quay.io"},
+		},
+		Images: []ECRImage{
+			{Image: "busybox:1.36", ECRImage: "123456789012.dkr.ecr.us-east-1.amazonaws.com/docker-hub/library/busybox:1.36"},
+			{Image: "quay.io/skopeo/stable:v1", ECRImage: "123456789012.dkr.ecr.us-east-1.amazonaws.com/quay/skopeo/stable:v1"},
+			{Image: "rancher/shell:v0.1.22", ECRImage: "123456789012.dkr.ecr.us-east-1.amazonaws.com/docker-hub/rancher/shell:v0.1.22"},
+		},
+		Unsupported: []string{"registry.example.com/team/tool:v1"},
+	}, cache)
+
+	_, err = ECRPullThroughCacheRules(list, "")
+	assert.EqualError(err, "ECR registry is required")
+}
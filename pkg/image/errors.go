@@ -0,0 +1,157 @@
+package image
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ChartError describes a chart that could not be scanned for images.
+type ChartError struct {
+	// Chart is the chart in name:version format.
+	Chart string
+	// File is the file or directory of the chart that could not be read.
+	File string
+	// Err is the cause of the error.
+	Err error
+}
+
+func (e *ChartError) Error() string {
+	return fmt.Sprintf("chart %s (%s): %v", e.Chart, e.File, e.Err)
+}
+
+func (e *ChartError) Unwrap() error {
+	return e.Err
+}
+
+// ChartErrors are the errors of all the charts an image source could not scan. Sources return ChartErrors once they
+// have scanned every other chart, so the images found so far are still exported.
+type ChartErrors []*ChartError
+
+func (e ChartErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, chartErr := range e {
+		messages = append(messages, chartErr.Error())
+	}
+	return fmt.Sprintf("failed to scan %d chart(s): %s", len(e), strings.Join(messages, "; "))
+}
+
+// chartErrorCollector collects the errors of the charts scanned by an image source. In strict mode, the first error
+// is returned right away instead, so the source stops scanning.
+type chartErrorCollector struct {
+	strict bool
+	errs   ChartErrors
+}
+
+// add records that the file of chartNameAndVersion could not be read because of err. It only returns an error in
+// strict mode.
+func (c *chartErrorCollector) add(chartNameAndVersion, file string, err error) error {
+	chartErr := &ChartError{Chart: chartNameAndVersion, File: file, Err: err}
+	if c.strict {
+		return chartErr
+	}
+	logrus.Warnf("skipping %v", chartErr)
+	c.errs = append(c.errs, chartErr)
+	return nil
+}
+
+// err returns the collected errors, or nil if there are none.
+func (c *chartErrorCollector) err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+	return c.errs
+}
+
+// ChartWarning describes a problem of the values of a chart that did not prevent scanning it, e.g. an unknown os
+// value, but that may have exported one of its images for the wrong OS types or architectures.
+type ChartWarning struct {
+	// Chart is the chart in name:version format.
+	Chart string `json:"chart"`
+	// Image is the image defined by the values with the problem.
+	Image string `json:"image"`
+	// ValuesPath is the key path of the values defining the image, e.g. fluentd.image.
+	ValuesPath string `json:"valuesPath,omitempty"`
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+func (w ChartWarning) String() string {
+	if w.ValuesPath == "" {
+		return fmt.Sprintf("chart %s, image %s: %s", w.Chart, w.Image, w.Message)
+	}
+	return fmt.Sprintf("chart %s, image %s (%s): %s", w.Chart, w.Image, w.ValuesPath, w.Message)
+}
+
+// ImageError describes an image that could not be found or handled in its registry.
+type ImageError struct {
+	// Image is the image reference.
+	Image string
+	// OS is the OS the image is exported for.
+	OS OSType
+	// Err is the cause of the error.
+	Err error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("%s image %s: %v", e.OS, e.Image, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+// Class returns the class of the error, see ClassifyError.
+func (e *ImageError) Class() ErrorClass {
+	return ClassifyError(e.Err)
+}
+
+// ImageErrors are the errors of all the images a registry operation failed for. Operations return ImageErrors once
+// they have handled every other image.
+type ImageErrors []*ImageError
+
+func (e ImageErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, imageErr := range e {
+		messages = append(messages, imageErr.Error())
+	}
+	return fmt.Sprintf("failed for %d image(s): %s", len(e), strings.Join(messages, "; "))
+}
+
+// ClassSummary summarizes the classes of the errors, e.g. "2 missing, 1 transient", in the order of their first error.
+func (e ImageErrors) ClassSummary() string {
+	counts := make(map[ErrorClass]int)
+	var classes []ErrorClass
+	for _, imageErr := range e {
+		class := imageErr.Class()
+		if counts[class] == 0 {
+			classes = append(classes, class)
+		}
+		counts[class]++
+	}
+	summary := make([]string, 0, len(classes))
+	for _, class := range classes {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[class], class))
+	}
+	return strings.Join(summary, ", ")
+}
+
+// RateLimitError is returned when a registry still rate limits the requests for an image after they have been retried.
+type RateLimitError struct {
+	// Registry is the registry rate limiting the requests.
+	Registry string
+	// Err is the error of the last attempt.
+	Err error
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Registry == dockerHubDomain {
+		return fmt.Sprintf("docker.io pull rate limit exceeded, authenticate to Docker Hub or look the images up in a mirror: %v", e.Err)
+	}
+	return fmt.Sprintf("registry %s rate limit exceeded: %v", e.Registry, e.Err)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,58 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+const brokenChartsIndex = `apiVersion: v1
+entries:
+  broken:
+  - name: broken
+    version: 1.0.0
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+`
+
+func TestChartsFetchImagesCollectsChartErrors(t *testing.T) {
+	assert := assertlib.New(t)
+
+	chartsPath := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "index.yaml"), []byte(brokenChartsIndex), 0644))
+	assert.NoError(os.MkdirAll(filepath.Join(chartsPath, "assets", "broken"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "assets", "broken", "broken-1.0.0.tgz"), []byte("not a tarball"), 0644))
+
+	config := ExportConfig{ChartsPath: chartsPath, RancherVersion: "2.8.0"}
+	err := Charts{config}.FetchImages(context.Background(), NewImageSet(Linux))
+	var chartErrs ChartErrors
+	assert.True(errors.As(err, &chartErrs))
+	if assert.Len(chartErrs, 1) {
+		assert.Equal("broken:1.0.0", chartErrs[0].Chart)
+		assert.Equal("assets/broken/broken-1.0.0.tgz", chartErrs[0].File)
+		assert.Error(chartErrs[0].Err)
+	}
+
+	config.Strict = true
+	err = Charts{config}.FetchImages(context.Background(), NewImageSet(Linux))
+	var chartErr *ChartError
+	assert.True(errors.As(err, &chartErr))
+	assert.False(errors.As(err, &chartErrs))
+}
+
+func TestChartErrorCollector(t *testing.T) {
+	assert := assertlib.New(t)
+
+	collector := chartErrorCollector{}
+	assert.NoError(collector.err())
+	assert.NoError(collector.add("fleet:102.1.0", "values.yaml", errors.New("invalid yaml")))
+	assert.EqualError(collector.err(), "failed to scan 1 chart(s): chart fleet:102.1.0 (values.yaml): invalid yaml")
+
+	strictCollector := chartErrorCollector{strict: true}
+	assert.EqualError(strictCollector.add("fleet:102.1.0", "values.yaml", errors.New("invalid yaml")), "chart fleet:102.1.0 (values.yaml): invalid yaml")
+	assert.NoError(strictCollector.err())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	checksumSuffix           = ".sha256"
+	checksumManifestFilename = "sha256sum.txt"
+)
+
+// writeChecksums computes the sha256 checksums of the files of the current directory, and its subdirectories, modified
+// since writtenSince, i.e. the outputs of the export. If perFile is true, the checksum of each file is written next to
+// it in the sha256sum format. If manifest is true, the checksums of all the files are written to sha256sum.txt, like
+// the other Rancher release assets.
+func writeChecksums(writtenSince time.Time, perFile, manifest bool) error {
+	files, err := outputFiles(writtenSince)
+	if err != nil {
+		return err
+	}
+
+	var manifestLines []string
+	for _, file := range files {
+		sum, err := sha256File(file)
+		if err != nil {
+			return err
+		}
+		if perFile {
+			if err := os.WriteFile(file+checksumSuffix, []byte(fmt.Sprintf("%s  %s\n", sum, filepath.Base(file))), 0644); err != nil {
+				return err
+			}
+		}
+		manifestLines = append(manifestLines, fmt.Sprintf("%s  %s\n", sum, filepath.ToSlash(file)))
+	}
+	if perFile {
+		log.Printf("Created the checksum files of %d files\n", len(files))
+	}
+
+	if manifest {
+		log.Printf("Creating %s\n", checksumManifestFilename)
+		return os.WriteFile(checksumManifestFilename, []byte(strings.Join(manifestLines, "")), 0644)
+	}
+	return nil
+}
+
+// outputFiles returns the files of the current directory, and its subdirectories, modified since writtenSince, i.e.
+// the outputs of the export, except the checksum files, sorted by path.
+func outputFiles(writtenSince time.Time) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasSuffix(path, checksumSuffix) || path == checksumManifestFilename {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(writtenSince) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list the output files: %w", err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("could not compute checksum of %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	img "github.com/rancher/rancher/pkg/image"
+	"github.com/urfave/cli"
+)
+
+func diffCommand() cli.Command {
+	return cli.Command{
+		Name:      "diff",
+		Usage:     "print the added, removed and retagged images between two image lists, grouped by source chart",
+		ArgsUsage: "OLD_IMAGE_LIST NEW_IMAGE_LIST",
+		Description: "Image lists are files in the rancher-images.txt, rancher-images-sources.txt, rancher-images.json or " +
+			"rancher-images.yaml format, e.g. the rancher-images-sources.txt files of two releases.",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "os",
+				Usage: "OS of the images in the lists, linux or windows; the OS of the images of JSON lists is read from the lists",
+				Value: "linux",
+			},
+		},
+		Action: diff,
+	}
+}
+
+func diff(c *cli.Context) error {
+	if c.NArg() != 2 {
+		cli.ShowCommandHelp(c, "diff")
+		return fmt.Errorf("diff requires 2 arguments")
+	}
+	osTypes, err := parseOSTypes([]string{c.String("os")})
+	if err != nil {
+		return err
+	}
+	oldList, err := readImageListFile(c.Args().Get(0), osTypes[0])
+	if err != nil {
+		return err
+	}
+	newList, err := readImageListFile(c.Args().Get(1), osTypes[0])
+	if err != nil {
+		return err
+	}
+	writeDiff(os.Stdout, img.DiffImageLists(oldList, newList))
+	return nil
+}
+
+// readImageListFile reads the image list at path. Lists in the rancher-images.json and rancher-images.yaml formats
+// hold the OS of their images, the images of text lists are for osType.
+func readImageListFile(path string, osType img.OSType) (img.ImageList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	switch filepath.Ext(path) {
+	case ".json", ".yaml", ".yml":
+		readDocument := img.ReadImageListJSON
+		if filepath.Ext(path) != ".json" {
+			readDocument = img.ReadImageListYAML
+		}
+		document, err := readDocument(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read image list %s: %w", path, err)
+		}
+		return document.Images, nil
+	}
+	list, err := img.ReadImageList(file, osType)
+	if err != nil {
+		return nil, fmt.Errorf("could not read image list %s: %w", path, err)
+	}
+	return list, nil
+}
+
+// writeDiff writes diff to w grouped by source, with "+" for added images, "-" for removed images and "~" for
+// retagged images.
+func writeDiff(w io.Writer, diff img.ImageDiff) {
+	if diff.Empty() {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+	groups := diff.GroupBySource()
+	sources := make([]string, 0, len(groups))
+	for source := range groups {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		group := groups[source]
+		fmt.Fprintln(w, source)
+		for _, entry := range group.Added {
+			fmt.Fprintf(w, "  + %s\n", entry.Image)
+		}
+		for _, entry := range group.Removed {
+			fmt.Fprintf(w, "  - %s\n", entry.Image)
+		}
+		for _, retagged := range group.Retagged {
+			fmt.Fprintf(w, "  ~ %s -> %s\n", retagged.Old.Image, retagged.New.Image)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	img "github.com/rancher/rancher/pkg/image"
+	"github.com/rancher/rancher/pkg/image/utilities"
+)
+
+// exportOutput is what export-images writes outputs from.
+type exportOutput struct {
+	utilities.ImageTargetsAndSources
+	// OSTypes are the OS types to write image lists for.
+	OSTypes []img.OSType
+	// Arches are the architectures the export is limited to, if any.
+	Arches []img.Arch
+	// WindowsBuilds are the Windows Server builds to write per-build image lists for.
+	WindowsBuilds []img.WindowsBuild
+	// WindowsTagVariants are the tag templates of the per-build variants of Windows images.
+	WindowsTagVariants img.WindowsTagVariants
+	// DigestPlatforms are the platforms the platform-specific manifest digests of the images were looked up for.
+	DigestPlatforms []img.Platform
+	// Metadata describes what the images were exported from.
+	Metadata img.ExportMetadata
+	// Previous is the image list of the previous release, if any.
+	Previous img.ImageList
+	// ConfigMapNamespace is the namespace of the ConfigMap manifest holding the image lists.
+	ConfigMapNamespace string
+	// MirrorEndpoint is the private registry the images are mirrored to, if known.
+	MirrorEndpoint string
+	// ECRRegistry is the ECR registry to serve the images from, if any.
+	ECRRegistry string
+	// HarborRegistries and HarborNamespace configure the Harbor replication policies, see
+	// img.HarborReplicationPolicies.
+	HarborRegistries map[string]int64
+	HarborNamespace  string
+	// DigestsLookedUp is true if the digests of the images were looked up in their registries.
+	DigestsLookedUp bool
+	// SigningRegistries are the registries the references of the signing manifest are in, if any.
+	SigningRegistries []string
+}
+
+// imageList returns the images of every OS type of the output, sorted by OS and then by image.
+func (o exportOutput) imageList() img.ImageList {
+	var list img.ImageList
+	for _, osType := range o.OSTypes {
+		list = append(list, o.ImageList(osType)...)
+	}
+	return list
+}
+
+// outputFormat is an output of export-images.
+type outputFormat struct {
+	name  string
+	write func(output exportOutput) error
+}
+
+// outputFormats are the outputs export-images can write, in the order they are written.
+var outputFormats = []outputFormat{
+	{name: "origins", write: writeOrigins},
+	{name: "txt", write: writeImagesText},
+	{name: "sources", write: writeImagesAndSourcesText},
+	{name: "registries", write: writeRegistryImagesText},
+	{name: "unmirrored", write: writeUnmirroredImagesText},
+	{name: "per-source", write: writeSourceCategoryImagesText},
+	{name: "per-distribution", write: writeDistributionImagesText},
+	{name: "per-arch", write: writeArchImagesText},
+	{name: "per-windows-build", write: writeWindowsBuildImagesText},
+	{name: "platform-digests", write: writePlatformDigestsText},
+	{name: "scripts", write: writeScripts},
+	{name: "containerd-scripts", write: writeContainerdScripts},
+	{name: "json", write: writeJSON},
+	{name: "yaml", write: writeYAML},
+	{name: "tfvars", write: writeImageVariables},
+	{name: "required", write: writeRequiredImagesText},
+	{name: "skopeo", write: writeSkopeoSyncYAML},
+	{name: "containerd-mirrors", write: writeContainerdMirrors},
+	{name: "harbor", write: writeHarborReplicationPolicies},
+	{name: "ecr", write: writeECRPullThroughCacheRules},
+	{name: "signing-manifest", write: writeSigningManifest},
+	{name: "csv", write: writeCSV},
+	{name: "configmap", write: writeConfigMap},
+	{name: "report", write: writeMarkdownReport},
+}
+
+// defaultOutputFormats are the outputs written when none is requested, those export-images has always written. The
+// other outputs are only written when requested with --format or the formats of the export config file.
+var defaultOutputFormats = []string{"origins", "txt", "sources", "scripts"}
+
+func outputFormatNames() []string {
+	names := make([]string, 0, len(outputFormats))
+	for _, format := range outputFormats {
+		names = append(names, format.name)
+	}
+	return names
+}
+
+func findOutputFormat(name string) (outputFormat, bool) {
+	for _, format := range outputFormats {
+		if format.name == name {
+			return format, true
+		}
+	}
+	return outputFormat{}, false
+}
+
+// writeOrigins creates rancher-image-origins.txt. Will fail if /pkg/image/origins.go does not provide a mapping for
+// each image.
+func writeOrigins(output exportOutput) error {
+	return img.GenerateImageOrigins(output.LinuxImagesFromArgs, output.TargetLinuxImages, output.TargetWindowsImages)
+}
+
+func writeImagesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		if err := utilities.ImagesText(osType.String(), output.ImageList(osType).Images(), output.MirrorMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeImagesAndSourcesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		if err := utilities.ImagesAndSourcesText(osType.String(), output.ImageList(osType).ImagesAndSources(), output.MirrorMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registryFilenamePrefixes are the prefixes of the files written for the images of each OS type, see filenamePrefix.
+var registryFilenamePrefixes = map[img.OSType]string{
+	img.Linux:   "rancher-images-",
+	img.Windows: "rancher-windows-images-",
+}
+
+// filenamePrefix returns the prefix of the files written for the images of osType, e.g. rancher-windows-images-.
+func filenamePrefix(osType img.OSType) string {
+	if prefix, ok := registryFilenamePrefixes[osType]; ok {
+		return prefix
+	}
+	return "rancher-" + osType.String() + "-images-"
+}
+
+// writeRegistryImagesText writes the images of each registry to their own file, e.g. rancher-images-quay.io.txt, for
+// mirroring jobs that handle a single upstream registry.
+func writeRegistryImagesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		for registry, list := range output.ImageList(osType).ByRegistry() {
+			filename := filenamePrefix(osType) + strings.ReplaceAll(registry, ":", "_") + ".txt"
+			if err := writeImageListFile(filename, list, img.FormatText); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeSourceCategoryImagesText writes the images of each source category to their own file, e.g.
+// rancher-images-system.txt, for sync pipelines handling each category with its own cadence.
+func writeSourceCategoryImagesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		for category, list := range output.ImageList(osType).BySourceCategory() {
+			filename := filenamePrefix(osType) + string(category) + ".txt"
+			if err := writeImageListFile(filename, list, img.FormatText); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeDistributionImagesText writes the images of each Kubernetes distribution to their own file, e.g.
+// rancher-images-rke2.txt, for air-gapped installations mirroring the images of the distributions of their downstream
+// clusters only.
+func writeDistributionImagesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		for distribution, list := range output.ImageList(osType).ByDistribution() {
+			filename := filenamePrefix(osType) + string(distribution) + ".txt"
+			if err := writeImageListFile(filename, list, img.FormatText); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeArchImagesText writes the images of each architecture to their own file, e.g. rancher-images-arm64.txt, for
+// air-gapped installs mirroring the images of a single architecture. Images restricted to other architectures by
+// their chart values are left out. Only the lists of the exported architectures are written if the export is limited
+// to some architectures.
+func writeArchImagesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		list := output.ImageList(osType)
+		for _, arch := range osArches(osType) {
+			if len(output.Arches) > 0 && !containsArch(output.Arches, arch) {
+				continue
+			}
+			filename := filenamePrefix(osType) + arch.String() + ".txt"
+			if err := writeImageListFile(filename, list.ForArch(arch), img.FormatText); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeWindowsBuildImagesText writes the Windows images of each Windows Server build to their own file, e.g.
+// rancher-windows-images-ltsc2022.txt, with the images that have per-build variants replaced by the variant of the
+// build, for air-gapped Windows nodes of a single build.
+func writeWindowsBuildImagesText(output exportOutput) error {
+	if !containsOSType(output.OSTypes, img.Windows) {
+		return nil
+	}
+	for _, build := range output.WindowsBuilds {
+		filename := filenamePrefix(img.Windows) + build.Name + ".txt"
+		list := output.WindowsImageList.ForWindowsBuild(build, output.WindowsTagVariants)
+		if err := writeImageListFile(filename, list, img.FormatText); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePlatformDigestsText writes the platform-specific manifest digests of the images to
+// rancher-images-platform-digests.txt, for mirrors replicating the requested platforms only instead of whole manifest
+// lists. Nothing is written unless platform digests were looked up with --platform-digest.
+func writePlatformDigestsText(output exportOutput) error {
+	if len(output.DigestPlatforms) == 0 {
+		return nil
+	}
+	for _, osType := range output.OSTypes {
+		filename := filenamePrefix(osType) + "platform-digests.txt"
+		if err := writePlatformDigestsFile(filename, output.ImageList(osType)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePlatformDigestsFile writes the platform-specific manifest digests of the images of list to filename.
+func writePlatformDigestsFile(filename string, list img.ImageList) error {
+	log.Printf("Creating %s\n", filename)
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return list.WritePlatformDigests(file)
+}
+
+// containsOSType returns whether osTypes contains osType.
+func containsOSType(osTypes []img.OSType, osType img.OSType) bool {
+	for _, o := range osTypes {
+		if o == osType {
+			return true
+		}
+	}
+	return false
+}
+
+// osArches returns the architectures the images of osType are published for.
+func osArches(osType img.OSType) []img.Arch {
+	if arches := osType.Arches(); len(arches) > 0 {
+		return arches
+	}
+	return img.Arches
+}
+
+// containsArch returns whether arches contains arch.
+func containsArch(arches []img.Arch, arch img.Arch) bool {
+	for _, a := range arches {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// writeUnmirroredImagesText writes the images that have no Rancher mirror, along with their sources, to
+// rancher-images-unmirrored.txt, so that the images still requiring access to third-party registries are known.
+func writeUnmirroredImagesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		unmirrored := output.ImageList(osType).Unmirrored()
+		if len(unmirrored) > 0 {
+			log.Printf("%d %s images have no Rancher mirror\n", len(unmirrored), osType)
+		}
+		filename := filenamePrefix(osType) + "unmirrored.txt"
+		if err := writeImageListFile(filename, unmirrored, img.FormatSources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeImageListFile writes list to filename in format.
+func writeImageListFile(filename string, list img.ImageList, format img.Format) error {
+	log.Printf("Creating %s\n", filename)
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return list.WriteImages(file, format)
+}
+
+// writeScripts writes the scripts to mirror, save and load the images. There are only scripts for Linux and Windows.
+func writeScripts(output exportOutput) error {
+	imageList := output.imageList()
+	for _, osType := range output.OSTypes {
+		if osType != img.Linux && osType != img.Windows {
+			log.Printf("Skipping the scripts of the %s images, scripts are only written for Linux and Windows\n", osType)
+			continue
+		}
+		arch := osType.String()
+		if err := utilities.MirrorScript(arch, imageList.ForOS(osType).Images()); err != nil {
+			return err
+		}
+		if err := utilities.SaveScript(arch, imageList); err != nil {
+			return err
+		}
+		if err := utilities.LoadScript(arch, imageList); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeContainerdScripts writes the scripts to save and load the Linux images with containerd.
+func writeContainerdScripts(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		if osType == img.Linux {
+			return utilities.ContainerdScripts(output.imageList())
+		}
+	}
+	return nil
+}
+
+const jsonFilename = "rancher-images.json"
+
+// writeJSON writes the images of every OS along with the export metadata to rancher-images.json.
+func writeJSON(output exportOutput) error {
+	log.Printf("Creating %s\n", jsonFilename)
+	file, err := os.Create(jsonFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteImageListJSON(file, output.imageList(), output.Metadata)
+}
+
+const yamlFilename = "rancher-images.yaml"
+
+// writeYAML writes the images of every OS along with the export metadata to rancher-images.yaml.
+func writeYAML(output exportOutput) error {
+	log.Printf("Creating %s\n", yamlFilename)
+	file, err := os.Create(yamlFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteImageListYAML(file, output.imageList(), output.Metadata)
+}
+
+const variablesFilename = "rancher-images.tfvars.json"
+
+// writeImageVariables writes the images of every OS keyed by logical component to rancher-images.tfvars.json, for
+// Terraform and Ansible.
+func writeImageVariables(output exportOutput) error {
+	log.Printf("Creating %s\n", variablesFilename)
+	file, err := os.Create(variablesFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteImageVariables(file, output.imageList())
+}
+
+// writeRequiredImagesText writes the images required to run Rancher and provision clusters, i.e. without the images
+// only needed by optional charts and UI extensions, for operators who want a minimal mirror.
+func writeRequiredImagesText(output exportOutput) error {
+	for _, osType := range output.OSTypes {
+		required := output.ImageList(osType).Required()
+		if err := utilities.RequiredImagesText(osType.String(), required.Images()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const skopeoFilename = "rancher-images-skopeo.yaml"
+
+// writeSkopeoSyncYAML writes the skopeo sync configuration of the images to rancher-images-skopeo.yaml.
+func writeSkopeoSyncYAML(output exportOutput) error {
+	log.Printf("Creating %s\n", skopeoFilename)
+	file, err := os.Create(skopeoFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteSkopeoSyncYAML(file, output.imageList())
+}
+
+const (
+	registriesFilename = "registries.yaml"
+	hostsDir           = "hosts.d"
+)
+
+// writeContainerdMirrors writes the registries.yaml file of RKE2 and K3s nodes, and the hosts.toml files of the
+// containerd config_path, pulling the images from the mirror endpoint. They are skipped without a mirror endpoint.
+func writeContainerdMirrors(output exportOutput) error {
+	if output.MirrorEndpoint == "" {
+		log.Printf("Skipping %s and %s, no mirror endpoint given\n", registriesFilename, hostsDir)
+		return nil
+	}
+	list := output.imageList()
+
+	registries, err := img.ContainerdMirrors(list, output.MirrorEndpoint)
+	if err != nil {
+		return err
+	}
+	registriesYAML, err := img.MarshalRegistriesYAML(registries)
+	if err != nil {
+		return err
+	}
+	log.Printf("Creating %s\n", registriesFilename)
+	if err := os.WriteFile(registriesFilename, registriesYAML, 0644); err != nil {
+		return err
+	}
+
+	hosts, err := img.ContainerdHostsTOML(list, output.MirrorEndpoint)
+	if err != nil {
+		return err
+	}
+	log.Printf("Creating %s\n", hostsDir)
+	for registry, hostsTOML := range hosts {
+		dir := filepath.Join(hostsDir, registry)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "hosts.toml"), []byte(hostsTOML), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const harborFilename = "rancher-images-harbor.json"
+
+// writeHarborReplicationPolicies writes the Harbor replication policies of the images to rancher-images-harbor.json.
+// They are skipped without Harbor registries.
+func writeHarborReplicationPolicies(output exportOutput) error {
+	if len(output.HarborRegistries) == 0 {
+		log.Printf("Skipping %s, no Harbor registries given\n", harborFilename)
+		return nil
+	}
+	log.Printf("Creating %s\n", harborFilename)
+	file, err := os.Create(harborFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteHarborReplicationPolicies(file, output.imageList(), output.HarborRegistries, output.HarborNamespace)
+}
+
+const csvFilename = "rancher-images.csv"
+
+// writeCSV writes the images of every OS to rancher-images.csv.
+func writeCSV(output exportOutput) error {
+	return writeImageListFile(csvFilename, output.imageList(), img.FormatCSV)
+}
+
+const configMapFilename = "rancher-images-configmap.yaml"
+
+// writeConfigMap writes the manifest of the ConfigMap holding the image lists to rancher-images-configmap.yaml.
+func writeConfigMap(output exportOutput) error {
+	log.Printf("Creating %s\n", configMapFilename)
+	file, err := os.Create(configMapFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteImageListConfigMap(file, output.imageList(), output.Metadata, output.ConfigMapNamespace)
+}
+
+const reportFilename = "rancher-images-report.md"
+
+// writeMarkdownReport writes the markdown report of the images for release notes to rancher-images-report.md.
+func writeMarkdownReport(output exportOutput) error {
+	log.Printf("Creating %s\n", reportFilename)
+	file, err := os.Create(reportFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Only compare the images of the exported OS types
+	var previous img.ImageList
+	if output.Previous != nil {
+		previous = img.ImageList{}
+		for _, osType := range output.OSTypes {
+			previous = append(previous, output.Previous.ForOS(osType)...)
+		}
+	}
+	return img.WriteMarkdownReport(file, output.imageList(), previous, output.Metadata)
+}
+
+const ecrFilename = "rancher-images-ecr.json"
+
+// writeECRPullThroughCacheRules writes the ECR pull-through cache rules serving the images to
+// rancher-images-ecr.json. They are skipped without an ECR registry.
+func writeECRPullThroughCacheRules(output exportOutput) error {
+	if output.ECRRegistry == "" {
+		log.Printf("Skipping %s, no ECR registry given\n", ecrFilename)
+		return nil
+	}
+	log.Printf("Creating %s\n", ecrFilename)
+	file, err := os.Create(ecrFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteECRPullThroughCacheRules(file, output.imageList(), output.ECRRegistry)
+}
+
+const signingManifestFilename = "rancher-images-signing-manifest.json"
+
+// writeSigningManifest writes the references the release pipeline signs for the images to
+// rancher-images-signing-manifest.json. It is skipped unless the digests of the images were looked up with
+// --registry-lookups or --pin-digests, and lists the platform-specific digests looked up with --platform-digest.
+func writeSigningManifest(output exportOutput) error {
+	if !output.DigestsLookedUp {
+		log.Printf("Skipping %s, the digests of the images were not looked up\n", signingManifestFilename)
+		return nil
+	}
+	log.Printf("Creating %s\n", signingManifestFilename)
+	file, err := os.Create(signingManifestFilename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return img.WriteSigningManifest(file, output.imageList(), output.Metadata.RancherVersions, output.SigningRegistries)
+}
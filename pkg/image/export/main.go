@@ -1,67 +1,1050 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	img "github.com/rancher/rancher/pkg/image"
 	"github.com/rancher/rancher/pkg/image/utilities"
+	"github.com/rancher/rancher/pkg/version"
+	"github.com/urfave/cli"
 )
 
+// This tool generates the lists of images required to install Rancher in air-gapped environments, along with the
+// scripts to mirror, save and load them. Run `go run ./pkg/image/export help export-images` for its usage.
+
 func main() {
-	if len(os.Args) < 3 {
-		log.Fatal("\"main.go\" requires 2 arguments. Usage: go run main.go [SYSTEM_CHART_PATH] [CHART_PATH] [OPTIONAL]...")
+	app := cli.NewApp()
+	app.Name = "export"
+	app.Usage = "generate the lists of images required to install Rancher in air-gapped environments"
+	app.ArgsUsage = "[SYSTEM_CHART_PATH CHART_PATH [IMAGE]...]"
+	app.Commands = []cli.Command{
+		exportImagesCommand(),
+		diffCommand(),
+		validateCommand(),
+		copyCommand(),
+		sizeCommand(),
+		platformsCommand(),
+		windowsBuildsCommand(),
+		inventoryCommand(),
+		staleCommand(),
+		bundleCommand(),
+		loadCommand(),
+		verifyCommand(),
+		verifySignaturesCommand(),
+		sbomCommand(),
+		scanCommand(),
 	}
-
-	if err := run(os.Args[1], os.Args[2], os.Args[3:]); err != nil {
+	app.Action = legacyExport
+	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(systemChartsPath, chartsPath string, imagesFromArgs []string) error {
-	targetsAndSources, err := utilities.GatherTargetImagesAndSources(systemChartsPath, chartsPath, imagesFromArgs)
+func exportImagesCommand() cli.Command {
+	return cli.Command{
+		Name:      "export-images",
+		Usage:     "write the image lists and scripts for the given charts and Rancher images",
+		ArgsUsage: "[IMAGE]...",
+		Description: "The Rancher images given as arguments are added to the lists, and must include the rancher/wins upgrade image. " +
+			"Charts and KDM data are read as-is; KDM data is read from --kdm, or else from ./data.json or $HOME/bin/data.json. " +
+			"The charts are scanned once for every OS type of --os and architecture of --arch, e.g. --os linux --arch amd64,arm64.",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "config",
+				Usage: "YAML export config file, see image.ExportConfigFile; flags take precedence over the file",
+			},
+			cli.StringFlag{
+				Name:  "system-charts",
+				Usage: "path or git URL of the system charts repository",
+			},
+			cli.StringFlag{
+				Name:  "system-charts-branch",
+				Usage: "branch to clone when --system-charts is a git URL",
+			},
+			cli.StringFlag{
+				Name:  "charts",
+				Usage: "path or git URL of the charts repository",
+			},
+			cli.StringFlag{
+				Name:  "charts-branch",
+				Usage: "branch to clone when --charts is a git URL",
+			},
+			cli.StringFlag{
+				Name:  "kdm",
+				Usage: "path or URL of the KDM data.json file, or \"embedded\" for the KDM data embedded in RKE, to export the images of a specific KDM snapshot",
+			},
+			cli.StringSliceFlag{
+				Name:  "rancher-version",
+				Usage: "Rancher version to export images for, can be repeated to export the union of several versions, defaults to $TAG",
+			},
+			cli.StringSliceFlag{
+				Name:  "os",
+				Usage: "OS to gather images and write image lists for (linux, windows), can be repeated or comma separated, defaults to all",
+			},
+			cli.StringSliceFlag{
+				Name:  "arch",
+				Usage: "architecture to limit the image lists to (amd64, arm64, s390x, ppc64le), skipping the charts and OS types that do not support it, can be repeated or comma separated, defaults to all",
+			},
+			cli.StringSliceFlag{
+				Name:  "chart-arch",
+				Usage: "NAME=ARCH[,ARCH] architectures supported by the chart called NAME, taking precedence over its catalog.cattle.io/permits-arch annotation, can be repeated",
+			},
+			cli.StringSliceFlag{
+				Name:  "chart-os",
+				Usage: "NAME=OS[,OS] OS types all the images of the chart called NAME are exported for, overriding the os hints of its values and its catalog.cattle.io/images-os annotation, can be repeated",
+			},
+			cli.BoolFlag{
+				Name:  "installable-k8s-versions-only",
+				Usage: "only export the RKE system images of the Kubernetes versions the Rancher versions can install, leaving out the versions only kept for upgraded clusters",
+			},
+			cli.StringFlag{
+				Name:  "k8s-patches",
+				Usage: "patch versions of each Kubernetes minor version to export the RKE system images of: latest (default), or all to also export the older patch versions",
+			},
+			cli.StringSliceFlag{
+				Name:  "requirement-image",
+				Usage: "OS[/ARCH]=IMAGE core image required on the OS, e.g. linux/arm64=rancher/shell:v0.1.22-arm64, in addition to the default shell, busybox, agent and wins images, can be repeated",
+			},
+			cli.StringSliceFlag{
+				Name:  "rke2-component",
+				Usage: "optional RKE2 component whose images are exported along with the core RKE2 images, e.g. canal, can be repeated, defaults to all the CNIs and cloud providers",
+			},
+			cli.StringSliceFlag{
+				Name:  "distribution",
+				Usage: "Kubernetes distribution of the downstream clusters to export the images of (rke, rke2, k3s), can be repeated or comma separated, defaults to all",
+			},
+			cli.Int64Flag{
+				Name:  "max-values-size",
+				Usage: "maximum size of the values files of the scanned charts, in bytes, larger values files fail their chart (default: 16MiB)",
+			},
+			cli.IntFlag{
+				Name:  "max-values-depth",
+				Usage: "maximum nesting depth of the values files of the scanned charts, deeper values files fail their chart (default: 100)",
+			},
+			cli.StringFlag{
+				Name:  "download-cache-dir",
+				Usage: "directory to cache the downloaded KDM data and RKE2 and K3s image lists in, for repeated exports, e.g. in CI",
+			},
+			cli.DurationFlag{
+				Name:  "download-cache-ttl",
+				Usage: "how long the cached downloads are used without checking whether they changed (default: 24h)",
+			},
+			cli.StringFlag{
+				Name:  "scan-cache-dir",
+				Usage: "directory to persist the images found in the scanned charts in, so that repeated exports only scan the charts that changed",
+			},
+			cli.StringSliceFlag{
+				Name:  "windows-build",
+				Usage: "Windows Server build to write a per-build image list for, e.g. ltsc2022, can be repeated, defaults to the builds supported by the Rancher versions",
+			},
+			cli.StringSliceFlag{
+				Name:  "windows-tag-variant",
+				Usage: "REPOSITORY=TEMPLATE tag template of the per-build variants of the Windows images of REPOSITORY, with {tag}, {build} and {version} placeholders, e.g. rancher/mirrored-pause={tag}-windows-{build}-amd64, can be repeated",
+			},
+			cli.BoolFlag{
+				Name:  "expand-windows-tag-variants",
+				Usage: "list the per-build variants of every Windows build in the Windows image lists",
+			},
+			cli.StringFlag{
+				Name:  "output-dir",
+				Usage: "directory to write the image lists and scripts to",
+				Value: ".",
+			},
+			cli.StringSliceFlag{
+				Name:  "format",
+				Usage: fmt.Sprintf("output to write (%s), can be repeated, defaults to %s", strings.Join(outputFormatNames(), ", "), strings.Join(defaultOutputFormats, ", ")),
+			},
+			cli.BoolFlag{
+				Name:  "checksums",
+				Usage: "write a sha256 checksum file next to each output",
+			},
+			cli.BoolFlag{
+				Name:  "provenance",
+				Usage: "write the SLSA provenance of the outputs, describing the charts revisions, KDM data and Rancher versions they were built from, to " + provenanceFilename,
+			},
+			cli.StringFlag{
+				Name:  "provenance-builder-id",
+				Usage: "SLSA builder ID of the platform running the export recorded in the provenance, e.g. the URL of a CI workflow",
+			},
+			cli.StringFlag{
+				Name:  "provenance-invocation-id",
+				Usage: "ID of the run of the export recorded in the provenance, e.g. the URL of a CI job",
+			},
+			cli.BoolFlag{
+				Name:  "checksum-manifest",
+				Usage: "write the sha256 checksums of all the outputs to sha256sum.txt",
+			},
+			cli.StringSliceFlag{
+				Name:  "exclude",
+				Usage: "glob or regex: pattern of images to exclude from the image lists, can be repeated",
+			},
+			cli.StringSliceFlag{
+				Name:  "extra-images",
+				Usage: "text or YAML file of additional images to include in the image lists, can be repeated",
+			},
+			cli.BoolFlag{
+				Name:  "strict",
+				Usage: "fail on the first chart that cannot be scanned instead of reporting all of them at the end",
+			},
+			cli.BoolFlag{
+				Name:  "core-only",
+				Usage: "only export the images required to run Rancher and provision clusters, skipping optional app charts, system charts and UI extensions",
+			},
+			cli.BoolFlag{
+				Name:  "prime",
+				Usage: "export the images of Rancher Prime, whose Rancher owned images are in registry.rancher.com",
+			},
+			cli.StringFlag{
+				Name:  "deny-list",
+				Usage: "YAML file of denied images, the export fails if it includes images denied with the fail action and reports the ones denied with the warn action",
+			},
+			cli.StringSliceFlag{
+				Name:  "allowed-registry",
+				Usage: "registry, e.g. quay.io, or namespace, e.g. docker.io/rancher, the exported images may come from, can be repeated; images from others violate the policy",
+			},
+			cli.StringFlag{
+				Name:  "allowed-registries-action",
+				Usage: "what the images from registries that are not allowed do: fail (default) the export or warn",
+			},
+			cli.StringSliceFlag{
+				Name:  "floating-tag",
+				Usage: "floating tag, or glob or regex: pattern of floating tags, the images are flagged with, can be repeated, latest and stable if not set",
+			},
+			cli.StringFlag{
+				Name:  "floating-tags-action",
+				Usage: "what the images with floating tags do: warn (default) or fail the export",
+			},
+			cli.StringFlag{
+				Name:  "compliant-variants",
+				Usage: "YAML file mapping images to their FIPS-compliant or hardened variants, the images are swapped for their variants and the ones without variant are listed in rancher-images-noncompliant.txt",
+			},
+			cli.StringFlag{
+				Name:  "variant-mode",
+				Usage: "what happens to the images without compliant variant: swap (default) keeps them, filter drops them from the image lists",
+			},
+			cli.StringFlag{
+				Name:  "mirror-mapping",
+				Usage: "YAML file mapping upstream image prefixes to the prefixes of their mirrored images, in addition to the mirrors of the rke types",
+			},
+			cli.StringFlag{
+				Name:  "mirror-mode",
+				Usage: "how upstream images are converted to their mirrored names: mirrored (default), upstream to skip the conversion, or both to export both names",
+			},
+			cli.StringSliceFlag{
+				Name:  "registry-mapping",
+				Usage: "PREFIX=REPLACEMENT mapping rewriting the exported images starting with PREFIX, can be repeated",
+			},
+			cli.StringSliceFlag{
+				Name:  "feature",
+				Usage: "NAME=BOOL Rancher feature flag, the charts of disabled features are skipped, e.g. legacy=false, can be repeated",
+			},
+			cli.StringFlag{
+				Name:  "previous",
+				Usage: "image list of the previous release, in the rancher-images.json or rancher-images.txt format, to report the changes since that release",
+			},
+			cli.StringFlag{
+				Name:  "configmap-namespace",
+				Usage: "namespace of the ConfigMap manifest holding the image lists",
+				Value: "cattle-system",
+			},
+			cli.BoolFlag{
+				Name:  "registry-lookups",
+				Usage: "look up the digest and compressed size of the images in their registries, for the csv and json outputs",
+			},
+			cli.BoolFlag{
+				Name:  "pin-digests",
+				Usage: "pin the images to their digests, e.g. rancher/shell:v0.1.22@sha256:..., looking them up in their registries",
+			},
+			cli.StringSliceFlag{
+				Name:  "platform-digest",
+				Usage: "OS/ARCHITECTURE platform to look up the platform-specific manifest digests of the images for, e.g. linux/arm64, for the platform-digests and json outputs, can be repeated",
+			},
+			cli.StringFlag{
+				Name:  "mirror-endpoint",
+				Usage: "private registry the images are mirrored to, e.g. registry.example.com:5000, to write the containerd mirror configuration of RKE2 and K3s nodes",
+			},
+			cli.StringFlag{
+				Name:  "ecr-registry",
+				Usage: "ECR registry to serve the images from, e.g. 123456789012.dkr.ecr.us-east-1.amazonaws.com, to write its pull-through cache rules",
+			},
+			cli.StringSliceFlag{
+				Name:  "harbor-registry",
+				Usage: "REGISTRY=ID ID of the Harbor registry endpoint to replicate the images of REGISTRY from, to write the Harbor replication policies, can be repeated",
+			},
+			cli.StringFlag{
+				Name:  "harbor-namespace",
+				Usage: "Harbor project to replicate the images to, defaults to the namespace of each image",
+			},
+			cli.StringSliceFlag{
+				Name:  "signing-registry",
+				Usage: "registry the images are published to, e.g. registry.rancher.com, whose references the signing manifest lists, can be repeated, defaults to the registry of each image",
+			},
+			cli.StringFlag{
+				Name:  "inventory",
+				Usage: "file listing the images a mirror already holds, to also list the missing and obsolete images of the mirror",
+			},
+			cli.StringFlag{
+				Name:  "inventory-registry",
+				Usage: "registry to strip from the images of the inventory file",
+			},
+		}, append(append(append(append([]cli.Flag{}, registryAuthFlags...), dockerHubFlags...), retryFlags...), tlsFlags...)...),
+		Action: exportImages,
+	}
+}
+
+func exportImages(c *cli.Context) error {
+	var config img.ExportConfigFile
+	if path := c.String("config"); path != "" {
+		var err error
+		if config, err = img.LoadExportConfigFile(path); err != nil {
+			return err
+		}
+	}
+
+	osTypes, err := parseOSTypes(stringSliceFlag(c, "os", config.OS))
 	if err != nil {
 		return err
 	}
-
-	// create rancher-image-origins.txt. Will fail if /pkg/image/origins.go
-	// does not provide a mapping for each image.
-	err = img.GenerateImageOrigins(targetsAndSources.LinuxImagesFromArgs, targetsAndSources.TargetLinuxImages, targetsAndSources.TargetWindowsImages)
+	formats := stringSliceFlag(c, "format", config.Formats)
+	if len(formats) == 0 {
+		formats = defaultOutputFormats
+	}
+	for _, format := range formats {
+		if _, ok := findOutputFormat(format); !ok {
+			return fmt.Errorf("unknown format %q, must be one of %s", format, strings.Join(outputFormatNames(), ", "))
+		}
+	}
+	credentials, err := registryCredentials(c)
+	if err != nil {
+		return err
+	}
+	tls, err := tlsConfig(c)
+	if err != nil {
+		return err
+	}
+	defer tls.Close()
+	dockerHub := dockerHubLimiter(c)
+	dockerHub.Client = tls.Client()
+	retry, err := retryPolicy(c)
 	if err != nil {
 		return err
 	}
 
-	type imageTextLists struct {
-		images           []string
-		imagesAndSources []string
+	if c.Bool("prime") {
+		config.Prime = true
+	}
+	if c.IsSet("registry-mapping") {
+		if config.RegistryMapping, err = img.ParseRegistryMapping(c.StringSlice("registry-mapping")); err != nil {
+			return err
+		}
 	}
-	for arch, imageLists := range map[string]imageTextLists{
-		"linux":   {images: targetsAndSources.TargetLinuxImages, imagesAndSources: targetsAndSources.TargetLinuxImagesAndSources},
-		"windows": {images: targetsAndSources.TargetWindowsImages, imagesAndSources: targetsAndSources.TargetWindowsImagesAndSources},
-	} {
-		err = utilities.ImagesText(arch, imageLists.images)
+	if c.IsSet("feature") {
+		features, err := img.ParseFeatures(c.StringSlice("feature"))
 		if err != nil {
 			return err
 		}
-
-		if err = utilities.ImagesAndSourcesText(arch, imageLists.imagesAndSources); err != nil {
+		if config.Features == nil {
+			config.Features = make(map[string]bool, len(features))
+		}
+		for name, enabled := range features {
+			config.Features[name] = enabled
+		}
+	}
+	if c.IsSet("arch") {
+		if config.Arch, err = img.ParseArches(strings.Join(c.StringSlice("arch"), ",")); err != nil {
 			return err
 		}
-		err = utilities.MirrorScript(arch, imageLists.images)
+	}
+	if c.IsSet("distribution") {
+		if config.Distributions, err = img.ParseDistributions(strings.Join(c.StringSlice("distribution"), ",")); err != nil {
+			return err
+		}
+	}
+	if c.IsSet("max-values-size") {
+		config.ValuesLimits.MaxSize = c.Int64("max-values-size")
+	}
+	if c.IsSet("max-values-depth") {
+		config.ValuesLimits.MaxDepth = c.Int("max-values-depth")
+	}
+	if c.IsSet("download-cache-dir") || c.IsSet("download-cache-ttl") {
+		if config.DownloadCache == nil {
+			config.DownloadCache = &img.DownloadCache{}
+		}
+		if c.IsSet("download-cache-dir") {
+			config.DownloadCache.Dir = c.String("download-cache-dir")
+		}
+		if c.IsSet("download-cache-ttl") {
+			config.DownloadCache.TTL = c.Duration("download-cache-ttl")
+		}
+	}
+	if c.IsSet("scan-cache-dir") {
+		config.ScanCache = &img.ScanCache{Dir: c.String("scan-cache-dir")}
+	}
+	// A single export covers every requested platform, the OS types without images for the requested architectures
+	// are left out, e.g. Windows for arm64
+	if supported := img.OSTypesForArches(osTypes, config.Arch); len(supported) < len(osTypes) {
+		if len(supported) == 0 {
+			return fmt.Errorf("none of the OS types %v has images for the architectures %v", osTypes, config.Arch)
+		}
+		log.Printf("Skipping the OS types without images for the architectures %v\n", config.Arch)
+		osTypes = supported
+	}
+	if c.IsSet("chart-arch") {
+		chartArches, err := img.ParseChartArches(c.StringSlice("chart-arch"))
 		if err != nil {
 			return err
 		}
-
-		err = utilities.SaveScript(arch, imageLists.images)
+		if config.ChartArches == nil {
+			config.ChartArches = make(map[string][]img.Arch, len(chartArches))
+		}
+		for name, arches := range chartArches {
+			config.ChartArches[name] = arches
+		}
+	}
+	if c.IsSet("chart-os") {
+		chartOSTypes, err := img.ParseChartOSTypes(c.StringSlice("chart-os"))
 		if err != nil {
 			return err
 		}
-
-		err = utilities.LoadScript(arch, imageLists.images)
+		if config.ChartOS == nil {
+			config.ChartOS = make(map[string][]img.OSType, len(chartOSTypes))
+		}
+		for name, osTypes := range chartOSTypes {
+			config.ChartOS[name] = osTypes
+		}
+	}
+	if c.IsSet("requirement-image") {
+		requirementImages, err := img.ParseRequirementImages(c.StringSlice("requirement-image"))
 		if err != nil {
 			return err
 		}
+		if config.RequirementImages == nil {
+			config.RequirementImages = make(map[img.OSType][]img.RequirementImage, len(requirementImages))
+		}
+		for osType, images := range requirementImages {
+			config.RequirementImages[osType] = append(config.RequirementImages[osType], images...)
+		}
+	}
+	var digestPlatforms []img.Platform
+	for _, value := range stringSliceFlag(c, "platform-digest", config.PlatformDigests) {
+		platform, err := img.ParsePlatform(value)
+		if err != nil {
+			return err
+		}
+		digestPlatforms = append(digestPlatforms, platform)
+	}
+	var windowsBuilds []img.WindowsBuild
+	for _, value := range stringSliceFlag(c, "windows-build", config.WindowsBuilds) {
+		build, err := img.ParseWindowsBuild(value)
+		if err != nil {
+			return err
+		}
+		windowsBuilds = append(windowsBuilds, build)
+	}
+	if c.IsSet("windows-tag-variant") {
+		variants, err := img.ParseWindowsTagVariants(c.StringSlice("windows-tag-variant"))
+		if err != nil {
+			return err
+		}
+		if config.WindowsTagVariants == nil {
+			config.WindowsTagVariants = make(img.WindowsTagVariants, len(variants))
+		}
+		for repository, template := range variants {
+			config.WindowsTagVariants[repository] = template
+		}
+	}
+	var mirrorMapping img.RegistryMapping
+	if path := c.String("mirror-mapping"); path != "" || config.MirrorMapping != "" {
+		if path == "" {
+			path = config.MirrorMapping
+		}
+		if mirrorMapping, err = img.LoadMirrorMapping(path); err != nil {
+			return err
+		}
+	}
+	var denyList *img.DenyList
+	if path := c.String("deny-list"); path != "" || config.DenyList != "" {
+		if path == "" {
+			path = config.DenyList
+		}
+		if denyList, err = img.LoadDenyList(path); err != nil {
+			return err
+		}
+	}
+	var allowedRegistries *img.AllowedRegistries
+	if registries := stringSliceFlag(c, "allowed-registry", config.AllowedRegistries); len(registries) > 0 {
+		action := img.PolicyAction(c.String("allowed-registries-action"))
+		if action == "" {
+			action = config.AllowedRegistriesAction
+		}
+		if action, err = img.ParsePolicyAction(string(action)); err != nil {
+			return err
+		}
+		if allowedRegistries, err = img.NewAllowedRegistries(registries, action); err != nil {
+			return err
+		}
+	}
+	// The images with floating tags are reported by default, and only fail the export if configured to
+	floatingTagsAction := img.PolicyAction(c.String("floating-tags-action"))
+	if floatingTagsAction == "" {
+		floatingTagsAction = config.FloatingTagsAction
+	}
+	if floatingTagsAction == "" {
+		floatingTagsAction = img.PolicyActionWarn
+	}
+	if floatingTagsAction, err = img.ParsePolicyAction(string(floatingTagsAction)); err != nil {
+		return err
+	}
+	floatingTags, err := img.NewFloatingTags(stringSliceFlag(c, "floating-tag", config.FloatingTags), floatingTagsAction)
+	if err != nil {
+		return err
+	}
+	var compliantVariants *img.CompliantVariants
+	if path := c.String("compliant-variants"); path != "" || config.CompliantVariants != "" {
+		if path == "" {
+			path = config.CompliantVariants
+		}
+		if compliantVariants, err = img.LoadCompliantVariants(path); err != nil {
+			return err
+		}
+	}
+	variantMode := img.VariantMode(c.String("variant-mode"))
+	if variantMode == "" {
+		variantMode = config.VariantMode
+	}
+	if variantMode, err = img.ParseVariantMode(string(variantMode)); err != nil {
+		return err
+	}
+	mirrorMode := img.MirrorMode(c.String("mirror-mode"))
+	if mirrorMode == "" {
+		mirrorMode = config.MirrorMode
+	}
+	if mirrorMode, err = img.ParseMirrorMode(string(mirrorMode)); err != nil {
+		return err
+	}
+	k8sPatches := img.K8sPatchMode(c.String("k8s-patches"))
+	if k8sPatches == "" {
+		k8sPatches = config.K8sPatches
+	}
+	if k8sPatches, err = img.ParseK8sPatchMode(string(k8sPatches)); err != nil {
+		return err
+	}
+	registryMapping := config.Mapping()
+	if len(registryMapping) > 0 && !c.IsSet("format") && len(config.Formats) == 0 {
+		// The image origins only know about the images of Docker Hub
+		formats = removeString(formats, "origins")
+	}
+
+	systemChartsRepo := chartRepoFlags(c, "system-charts", config.SystemCharts)
+	chartsRepo := chartRepoFlags(c, "charts", config.Charts)
+	systemChartsPath, cleanup, err := fetchRepo(systemChartsRepo, tls)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	chartsPath, cleanup, err := fetchRepo(chartsRepo, tls)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	images := []string(c.Args())
+	if len(images) == 0 {
+		images = config.Images
+	}
+	kdmSource := config.KDM
+	if c.IsSet("kdm") {
+		kdmSource = c.String("kdm")
+	}
+	outputDir := c.String("output-dir")
+	if !c.IsSet("output-dir") && config.OutputDir != "" {
+		outputDir = config.OutputDir
+	}
+	previous := c.String("previous")
+	if previous == "" {
+		previous = config.Previous
+	}
+	configMapNamespace := c.String("configmap-namespace")
+	if !c.IsSet("configmap-namespace") && config.ConfigMapNamespace != "" {
+		configMapNamespace = config.ConfigMapNamespace
+	}
+	mirrorEndpoint := c.String("mirror-endpoint")
+	if mirrorEndpoint == "" {
+		mirrorEndpoint = config.MirrorEndpoint
+	}
+	if c.IsSet("ecr-registry") {
+		config.ECRRegistry = c.String("ecr-registry")
+	}
+	if c.IsSet("harbor-registry") {
+		if config.HarborRegistries, err = img.ParseHarborRegistryIDs(c.StringSlice("harbor-registry")); err != nil {
+			return err
+		}
+	}
+	if c.IsSet("harbor-namespace") {
+		config.HarborNamespace = c.String("harbor-namespace")
+	}
+	return run(exportOptions{
+		GatherOptions: utilities.GatherOptions{
+			SystemChartsPath:           systemChartsPath,
+			ChartsPath:                 chartsPath,
+			RancherVersions:            stringSliceFlag(c, "rancher-version", config.RancherVersions),
+			OSTypes:                    osTypes,
+			ImagesFromArgs:             images,
+			ExcludePatterns:            stringSliceFlag(c, "exclude", config.Exclude),
+			ExtraImagesFiles:           stringSliceFlag(c, "extra-images", config.ExtraImages),
+			Progress:                   logProgress,
+			Strict:                     c.Bool("strict") || config.Strict,
+			CoreOnly:                   c.Bool("core-only") || config.CoreOnly,
+			MirrorMapping:              mirrorMapping,
+			MirrorMode:                 mirrorMode,
+			RegistryMapping:            registryMapping,
+			Features:                   config.Features,
+			Arches:                     config.Arch,
+			ChartArches:                config.ChartArches,
+			ChartOSTypes:               config.ChartOS,
+			RequirementImages:          config.RequirementImages,
+			InstallableK8sVersionsOnly: c.Bool("installable-k8s-versions-only") || config.InstallableK8sVersionsOnly,
+			RKE2Components:             stringSliceFlag(c, "rke2-component", config.RKE2Components),
+			Distributions:              config.Distributions,
+			ValuesLimits:               config.ValuesLimits,
+			K8sPatches:                 k8sPatches,
+			KDMDataSource:              kdmSource,
+			DownloadCache:              config.DownloadCache,
+			ScanCache:                  config.ScanCache,
+			HTTPClient:                 tls.Client(),
+		},
+		OSTypes:                  osTypes,
+		Formats:                  formats,
+		OutputDir:                outputDir,
+		Checksums:                c.Bool("checksums") || config.Checksums,
+		ChecksumManifest:         c.Bool("checksum-manifest") || config.ChecksumManifest,
+		Provenance:               c.Bool("provenance") || config.Provenance,
+		ProvenanceBuilderID:      stringFlag(c, "provenance-builder-id", config.ProvenanceBuilderID),
+		ProvenanceInvocationID:   c.String("provenance-invocation-id"),
+		ChartRepos:               map[string]img.ChartRepo{"charts": chartsRepo, "systemCharts": systemChartsRepo},
+		Previous:                 previous,
+		ConfigMapNamespace:       configMapNamespace,
+		RegistryLookups:          c.Bool("registry-lookups") || config.RegistryLookups,
+		PinDigests:               c.Bool("pin-digests") || config.PinDigests,
+		DigestPlatforms:          digestPlatforms,
+		Credentials:              credentials,
+		TLS:                      tls,
+		DockerHub:                dockerHub,
+		RateLimitRetries:         c.Int("rate-limit-retries"),
+		Retry:                    retry,
+		MirrorEndpoint:           mirrorEndpoint,
+		ECRRegistry:              config.ECRRegistry,
+		HarborRegistries:         config.HarborRegistries,
+		HarborNamespace:          config.HarborNamespace,
+		SigningRegistries:        stringSliceFlag(c, "signing-registry", config.SigningRegistries),
+		DenyList:                 denyList,
+		AllowedRegistries:        allowedRegistries,
+		FloatingTags:             floatingTags,
+		CompliantVariants:        compliantVariants,
+		VariantMode:              variantMode,
+		InventoryFile:            c.String("inventory"),
+		InventoryRegistry:        c.String("inventory-registry"),
+		WindowsBuilds:            windowsBuilds,
+		WindowsTagVariants:       config.WindowsTagVariants,
+		ExpandWindowsTagVariants: c.Bool("expand-windows-tag-variants") || config.ExpandWindowsTagVariants,
+	})
+}
+
+// removeString returns values without value.
+func removeString(values []string, value string) []string {
+	var result []string
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// stringFlag returns the value of the flag called name if it is set, and fromConfig otherwise.
+func stringFlag(c *cli.Context, name string, fromConfig string) string {
+	if c.IsSet(name) {
+		return c.String(name)
+	}
+	return fromConfig
+}
+
+// stringSliceFlag returns the values of the flag called name if it is set, and fromConfig otherwise.
+func stringSliceFlag(c *cli.Context, name string, fromConfig []string) []string {
+	if c.IsSet(name) {
+		return c.StringSlice(name)
+	}
+	return fromConfig
+}
+
+// chartRepoFlags overrides the charts repository fromConfig with the flag called name, a path or git URL, and its
+// branch flag.
+func chartRepoFlags(c *cli.Context, name string, fromConfig img.ChartRepo) img.ChartRepo {
+	repo := fromConfig
+	if c.IsSet(name) {
+		if pathOrURL := c.String(name); isGitURL(pathOrURL) {
+			repo = img.ChartRepo{URL: pathOrURL}
+		} else {
+			repo = img.ChartRepo{Path: pathOrURL}
+		}
+	}
+	if c.IsSet(name + "-branch") {
+		repo.Branch = c.String(name + "-branch")
 	}
+	return repo
+}
 
+// legacyExport supports the original invocation of this tool, with the system charts and charts paths followed by
+// the Rancher images as arguments, which writes the default outputs for every OS to the current directory.
+func legacyExport(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		cli.ShowAppHelp(c)
+		return fmt.Errorf("\"main.go\" requires 2 arguments. Usage: go run ./pkg/image/export [SYSTEM_CHART_PATH] [CHART_PATH] [OPTIONAL]...")
+	}
+	return run(exportOptions{
+		GatherOptions: utilities.GatherOptions{
+			SystemChartsPath: args[0],
+			ChartsPath:       args[1],
+			ImagesFromArgs:   args[2:],
+			Progress:         logProgress,
+		},
+		OSTypes:            []img.OSType{img.Linux, img.Windows},
+		Formats:            defaultOutputFormats,
+		OutputDir:          ".",
+		ConfigMapNamespace: "cattle-system",
+	})
+}
+
+// parseOSTypes parses the names of OS types, each possibly a comma separated list. No names means every OS type.
+func parseOSTypes(names []string) ([]img.OSType, error) {
+	osTypes, err := img.ParseOSTypes(strings.Join(names, ","))
+	if err != nil {
+		return nil, err
+	}
+	if len(osTypes) == 0 {
+		return img.RegisteredOSTypes(), nil
+	}
+	return osTypes, nil
+}
+
+// chartProgressInterval is the number of charts scanned between progress logs.
+const chartProgressInterval = 25
+
+// logProgress logs when an image source has been fetched, and periodically while charts are being scanned.
+func logProgress(progress img.Progress) {
+	switch {
+	case progress.Done:
+		log.Printf("Fetched images from %s: %d images found, %d bytes downloaded\n", progress.Source, progress.ImagesFound, progress.BytesDownloaded)
+	case progress.Chart != "" && progress.ChartsScanned%chartProgressInterval == 0:
+		log.Printf("Scanned %d/%d %s: %d images found\n", progress.ChartsScanned, progress.ChartsTotal, progress.Source, progress.ImagesFound)
+	}
+}
+
+// exportOptions configures the files written by run.
+type exportOptions struct {
+	utilities.GatherOptions
+	// OSTypes are the OS types to write image lists for.
+	OSTypes []img.OSType
+	// Formats are the names of the outputs to write, see outputFormats.
+	Formats []string
+	// OutputDir is the directory the files are written to.
+	OutputDir string
+	// Checksums writes a sha256 checksum file next to each file written.
+	Checksums bool
+	// ChecksumManifest writes the sha256 checksums of all the files written to sha256sum.txt.
+	ChecksumManifest bool
+	// Provenance writes the SLSA provenance of the files written, see writeProvenance. ProvenanceBuilderID and
+	// ProvenanceInvocationID identify the platform running the export and the run, see img.ProvenanceInputs.
+	Provenance             bool
+	ProvenanceBuilderID    string
+	ProvenanceInvocationID string
+	// ChartRepos are the charts repositories the images are read from, keyed by name, as configured.
+	ChartRepos map[string]img.ChartRepo
+	// Previous is the image list of the previous release, if any, to report the changes since that release.
+	Previous string
+	// ConfigMapNamespace is the namespace of the ConfigMap manifest holding the image lists.
+	ConfigMapNamespace string
+	// RegistryLookups looks up the digest and compressed size of the images in their registries.
+	RegistryLookups bool
+	// PinDigests pins the images to their digests, looking them up in their registries.
+	PinDigests bool
+	// DigestPlatforms are the platforms to look up the platform-specific manifest digests of the images for, if any.
+	DigestPlatforms []img.Platform
+	// Credentials are the credentials of the registries the images are looked up in.
+	Credentials img.RegistryCredentials
+	// TLS, if set, configures the TLS connections to the registries.
+	TLS *img.TLSConfig
+	// DockerHub and RateLimitRetries keep the lookups under the rate limits of the registries, see img.RegistryClient.
+	DockerHub        *img.DockerHubLimiter
+	RateLimitRetries int
+	// Retry configures the retries of the lookups failing with a transient error.
+	Retry img.RetryPolicy
+	// MirrorEndpoint is the private registry the images are mirrored to, if known.
+	MirrorEndpoint string
+	// ECRRegistry is the ECR registry to serve the images from, if any.
+	ECRRegistry string
+	// HarborRegistries and HarborNamespace configure the Harbor replication policies, see
+	// img.HarborReplicationPolicies.
+	HarborRegistries map[string]int64
+	HarborNamespace  string
+	// SigningRegistries are the registries the references of the signing manifest are in, the registry of each image
+	// if empty.
+	SigningRegistries []string
+	// DenyList, if set, is checked against the exported images, see img.DenyList.
+	DenyList *img.DenyList
+	// AllowedRegistries, if set, are the registries and namespaces the exported images may come from, see
+	// img.AllowedRegistries.
+	AllowedRegistries *img.AllowedRegistries
+	// FloatingTags, if set, flags the exported images with floating tags, see img.FloatingTags.
+	FloatingTags *img.FloatingTags
+	// CompliantVariants, if set, maps the images to their compliant variants, and VariantMode is what happens to the
+	// images without variant, see img.ImageList.WithCompliantVariants.
+	CompliantVariants *img.CompliantVariants
+	VariantMode       img.VariantMode
+	// InventoryFile, if set, lists the images a mirror already holds. The images missing from the mirror and the
+	// images of the mirror no longer required are then written as well.
+	InventoryFile string
+	// InventoryRegistry is the registry prefixing the images of InventoryFile, if any.
+	InventoryRegistry string
+	// WindowsBuilds are the Windows Server builds to write per-build image lists for, the builds supported by the
+	// Rancher versions if empty.
+	WindowsBuilds []img.WindowsBuild
+	// WindowsTagVariants are the tag templates of the per-build variants of Windows images.
+	WindowsTagVariants img.WindowsTagVariants
+	// ExpandWindowsTagVariants lists the variants of every Windows build in the Windows image lists.
+	ExpandWindowsTagVariants bool
+}
+
+func run(options exportOptions) error {
+	startedOn := time.Now()
+	targetsAndSources, err := utilities.GatherTargetImages(options.GatherOptions)
+	if err != nil {
+		return err
+	}
+	windowsBuilds := options.WindowsBuilds
+	if len(windowsBuilds) == 0 {
+		windowsBuilds = supportedWindowsBuilds(targetsAndSources.RancherVersions)
+	}
+	if options.ExpandWindowsTagVariants {
+		windowsList := targetsAndSources.WindowsImageList.ExpandWindowsTagVariants(windowsBuilds, options.WindowsTagVariants)
+		targetsAndSources.SetImageList(img.Windows, windowsList)
+	}
+	// The variants are swapped in before the lookups, so that the digests are those of the variants
+	var nonCompliant img.ImageList
+	if options.CompliantVariants != nil {
+		for _, osType := range img.RegisteredOSTypes() {
+			compliant := targetsAndSources.ImageList(osType).WithCompliantVariants(options.CompliantVariants, options.VariantMode)
+			log.Printf("Swapped %d %s images for their compliant variants, %d have none\n", len(compliant.Swapped), osType, len(compliant.NonCompliant))
+			targetsAndSources.SetImageList(osType, compliant.Images)
+			nonCompliant = append(nonCompliant, compliant.NonCompliant...)
+		}
+	}
+	if options.RegistryLookups || options.PinDigests || len(options.DigestPlatforms) > 0 {
+		client := img.RegistryClient{
+			Credentials:      options.Credentials,
+			TLS:              options.TLS,
+			DockerHub:        options.DockerHub,
+			RateLimitRetries: options.RateLimitRetries,
+			Retry:            options.Retry,
+		}
+		// The images of lists limited to a single architecture are sized for it rather than for amd64
+		if len(options.Arches) == 1 {
+			client.Arch = options.Arches[0]
+		}
+		for _, osType := range options.OSTypes {
+			list := targetsAndSources.ImageList(osType)
+			if options.RegistryLookups || options.PinDigests {
+				log.Printf("Looking up %d %s images in their registries\n", len(list), osType)
+				client.LookupImages(context.Background(), list)
+			}
+			if len(options.DigestPlatforms) > 0 {
+				log.Printf("Looking up the platform digests of %d %s images in their registries\n", len(list), osType)
+				client.LookupPlatformDigests(context.Background(), list, options.DigestPlatforms)
+			}
+		}
+	}
+	if options.PinDigests {
+		for _, osType := range options.OSTypes {
+			pinned, err := targetsAndSources.ImageList(osType).PinDigests()
+			if err != nil {
+				return fmt.Errorf("could not pin the %s images to their digests: %w", osType, err)
+			}
+			targetsAndSources.SetImageList(osType, pinned)
+		}
+	}
+
+	var violations img.PolicyViolations
+	for _, osType := range options.OSTypes {
+		list := targetsAndSources.ImageList(osType)
+		violations = append(violations, options.DenyList.Check(list)...)
+		violations = append(violations, options.AllowedRegistries.Check(list)...)
+		violations = append(violations, options.FloatingTags.Check(list)...)
+	}
+
+	output := exportOutput{
+		ImageTargetsAndSources: targetsAndSources,
+		OSTypes:                options.OSTypes,
+		Arches:                 options.Arches,
+		WindowsBuilds:          windowsBuilds,
+		WindowsTagVariants:     options.WindowsTagVariants,
+		DigestPlatforms:        options.DigestPlatforms,
+		ConfigMapNamespace:     options.ConfigMapNamespace,
+		MirrorEndpoint:         options.MirrorEndpoint,
+		ECRRegistry:            options.ECRRegistry,
+		HarborRegistries:       options.HarborRegistries,
+		HarborNamespace:        options.HarborNamespace,
+		DigestsLookedUp:        options.RegistryLookups || options.PinDigests,
+		SigningRegistries:      options.SigningRegistries,
+		Metadata: img.ExportMetadata{
+			RancherVersions:  targetsAndSources.RancherVersions,
+			GeneratedAt:      time.Now().UTC(),
+			Revisions:        repoRevisions(map[string]string{"charts": options.ChartsPath, "systemCharts": options.SystemChartsPath}),
+			ToolVersion:      version.FriendlyVersion(),
+			ChartWarnings:    targetsAndSources.ChartWarnings,
+			PolicyViolations: violations,
+		},
+	}
+
+	if options.Previous != "" {
+		if output.Previous, err = readImageListFile(options.Previous, img.Linux); err != nil {
+			return err
+		}
+	}
+
+	// The files are written to the current directory, so switch to the output directory once all the inputs given as
+	// relative paths have been read.
+	if options.InventoryFile != "" {
+		if options.InventoryFile, err = filepath.Abs(options.InventoryFile); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(options.OutputDir, 0755); err != nil {
+		return fmt.Errorf("could not create output directory: %w", err)
+	}
+	if err := os.Chdir(options.OutputDir); err != nil {
+		return fmt.Errorf("could not switch to output directory: %w", err)
+	}
+	// Modification times may be truncated to the second by the filesystem
+	writtenSince := time.Now().Truncate(time.Second)
+
+	for _, name := range options.Formats {
+		format, _ := findOutputFormat(name)
+		if err := format.write(output); err != nil {
+			return err
+		}
+	}
+
+	if len(options.ExcludePatterns) > 0 {
+		for _, osType := range options.OSTypes {
+			excluded := targetsAndSources.ExcludedImageList.ForOS(osType).ImagesAndSources()
+			log.Printf("Excluded %d %s images matching %v\n", len(excluded), osType, options.ExcludePatterns)
+			if err = utilities.ExcludedImagesText(osType.String(), excluded); err != nil {
+				return err
+			}
+		}
+	}
+
+	if options.CompliantVariants != nil {
+		for _, osType := range options.OSTypes {
+			if err := utilities.NonCompliantImagesText(osType.String(), nonCompliant.ForOS(osType).ImagesAndSources()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if options.InventoryFile != "" {
+		if err := writeMirrorDelta(options.InventoryFile, options.InventoryRegistry, targetsAndSources, options.OSTypes); err != nil {
+			return err
+		}
+	}
+
+	if options.Provenance {
+		if err := writeProvenance(writtenSince, provenanceInputs(options, output, startedOn)); err != nil {
+			return err
+		}
+	}
+
+	if options.Checksums || options.ChecksumManifest {
+		if err := writeChecksums(writtenSince, options.Checksums, options.ChecksumManifest); err != nil {
+			return err
+		}
+	}
+
+	// The image lists have been written with the images violating the policies, so they are reported, but must not be
+	// used if some violations fail the export.
+	for _, violation := range violations {
+		log.Printf("Policy violation (%s): %v\n", violation.Action, violation)
+	}
+	bySource := violations.BySource()
+	sources := make([]string, 0, len(bySource))
+	for source := range bySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		log.Printf("%s introduces %d images violating the image policies\n", source, len(bySource[source]))
+	}
+	if failures := violations.Failures(); len(failures) > 0 {
+		return failures
+	}
+
+	// The image lists have been written without the images of the charts that could not be scanned, report all of
+	// those charts and fail so the incomplete lists are not mistaken for complete ones.
+	if len(targetsAndSources.ChartErrors) > 0 {
+		for _, chartErr := range targetsAndSources.ChartErrors {
+			log.Printf("Could not scan %v\n", chartErr)
+		}
+		return targetsAndSources.ChartErrors
+	}
 	return nil
 }
+
+// supportedWindowsBuilds returns the Windows Server builds supported by any of rancherVersions.
+func supportedWindowsBuilds(rancherVersions []string) []img.WindowsBuild {
+	var builds []img.WindowsBuild
+	seen := make(map[string]bool)
+	for _, rancherVersion := range rancherVersions {
+		for _, build := range img.WindowsBuildsForRancherVersion(rancherVersion) {
+			if !seen[build.Name] {
+				seen[build.Name] = true
+				builds = append(builds, build)
+			}
+		}
+	}
+	if len(builds) == 0 {
+		return img.DefaultWindowsBuilds
+	}
+	return builds
+}
+
+// writeMirrorDelta writes the images missing from the mirror whose inventory is in inventoryFile, and the images of the
+// mirror that are no longer required.
+func writeMirrorDelta(inventoryFile, inventoryRegistry string, targetsAndSources utilities.ImageTargetsAndSources, osTypes []img.OSType) error {
+	inventory, err := readImageListFile(inventoryFile, img.Linux)
+	if err != nil {
+		return err
+	}
+	// Compare against the images of every OS, so the images of the OS types not written are not considered obsolete
+	var imageList img.ImageList
+	for _, osType := range img.RegisteredOSTypes() {
+		imageList = append(imageList, targetsAndSources.ImageList(osType)...)
+	}
+	delta := img.ComputeMirrorDelta(imageList, inventory.Images(), inventoryRegistry)
+	for _, osType := range osTypes {
+		missing := delta.Missing.ForOS(osType).Images()
+		log.Printf("%d %s images are missing from the mirror\n", len(missing), osType)
+		if err := utilities.MissingImagesText(osType.String(), missing); err != nil {
+			return err
+		}
+	}
+	log.Printf("%d images of the mirror are obsolete\n", len(delta.Obsolete))
+	return utilities.ObsoleteImagesText(delta.Obsolete)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	img "github.com/rancher/rancher/pkg/image"
+	"github.com/rancher/rancher/pkg/image/internal/imagetest"
+	assertlib "github.com/stretchr/testify/assert"
+	"github.com/urfave/cli"
+)
+
+// exportTestImages runs export-images with args on a fixture tree: an empty KDM data.json file, empty charts and system
+// charts repositories, and the Rancher images of a v2.7.99 release. It returns the directory the image lists are
+// written to.
+func exportTestImages(t *testing.T, args ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	// The export switches to the output directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	files := map[string]string{
+		"data.json":           "{}",
+		"charts/index.yaml":   "apiVersion: v1\nentries: {}\n",
+		"system-charts/.keep": "",
+		"bin/.keep":           "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := cli.NewApp()
+	app.Commands = []cli.Command{exportImagesCommand()}
+	appArgs := []string{"export", "export-images",
+		"--kdm", filepath.Join(dir, "data.json"),
+		"--charts", filepath.Join(dir, "charts"),
+		"--system-charts", filepath.Join(dir, "system-charts"),
+		"--rancher-version", "v2.7.99",
+		"--output-dir", filepath.Join(dir, "output"),
+		// The UI extensions are fetched from GitHub
+		"--core-only",
+	}
+	if err := app.Run(append(append(appArgs, args...), "rancher/rancher:v2.7.99", "rancher/wins:v0.4.11")); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "output")
+}
+
+func TestExportImagesText(t *testing.T) {
+	// OS types cannot be unregistered, so every case lists the OS types it exports
+	img.RegisterOSType("freebsd", img.OSTypeConfig{})
+	extraImages := filepath.Join(t.TempDir(), "extra-images.yaml")
+	extraImagesYAML := "images:\n- image: quay.io/calico/node:v3.1\n- image: rancher/freebsd-tools:v1.0.0\n  os: freebsd\n"
+	if err := os.WriteFile(extraImages, []byte(extraImagesYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		description string
+		args        []string
+		images      string
+		sources     string
+		expected    []string
+		unexpected  []string
+	}{
+		{
+			description: "mirrored images",
+			args:        []string{"--os", "linux"},
+			images:      "rancher-images.txt",
+			sources:     "rancher-images-sources.txt",
+			expected:    []string{"rancher/rancher:v2.7.99", "rancher/calico-node:v3.1"},
+			unexpected:  []string{"quay.io/calico/node:v3.1"},
+		},
+		{
+			description: "prime images",
+			args:        []string{"--os", "linux", "--prime"},
+			images:      "rancher-images.txt",
+			sources:     "rancher-images-sources.txt",
+			expected:    []string{"registry.rancher.com/rancher/rancher:v2.7.99", "registry.rancher.com/rancher/calico-node:v3.1"},
+			unexpected:  []string{"rancher/rancher:v2.7.99"},
+		},
+		{
+			description: "upstream images",
+			args:        []string{"--os", "linux", "--mirror-mode", "upstream"},
+			images:      "rancher-images.txt",
+			sources:     "rancher-images-sources.txt",
+			expected:    []string{"rancher/rancher:v2.7.99", "quay.io/calico/node:v3.1"},
+			unexpected:  []string{"rancher/calico-node:v3.1"},
+		},
+		{
+			description: "upstream and mirrored images",
+			args:        []string{"--os", "linux", "--mirror-mode", "both"},
+			images:      "rancher-images.txt",
+			sources:     "rancher-images-sources.txt",
+			expected:    []string{"quay.io/calico/node:v3.1", "rancher/calico-node:v3.1"},
+		},
+		{
+			description: "windows images",
+			args:        []string{"--os", "windows"},
+			images:      "rancher-windows-images.txt",
+			sources:     "rancher-windows-images-sources.txt",
+			expected:    []string{"rancher/wins:v0.4.11"},
+			unexpected:  []string{"rancher/rancher:v2.7.99"},
+		},
+		{
+			description: "registered OS type images",
+			args:        []string{"--os", "freebsd"},
+			images:      "rancher-freebsd-images.txt",
+			sources:     "rancher-freebsd-images-sources.txt",
+			expected:    []string{"rancher/freebsd-tools:v1.0.0"},
+			unexpected:  []string{"rancher/rancher:v2.7.99"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert := assertlib.New(t)
+			outputDir := exportTestImages(t, append(tc.args, "--format", "txt", "--format", "sources", "--extra-images", extraImages)...)
+
+			images := imagetest.ReadLines(t, filepath.Join(outputDir, tc.images))
+			var sourcedImages []string
+			for _, line := range imagetest.ReadLines(t, filepath.Join(outputDir, tc.sources)) {
+				sourcedImages = append(sourcedImages, strings.Fields(line)[0])
+			}
+			for _, image := range tc.expected {
+				assert.Contains(images, image)
+				assert.Contains(sourcedImages, image)
+			}
+			for _, image := range tc.unexpected {
+				assert.NotContains(images, image)
+				assert.NotContains(sourcedImages, image)
+			}
+		})
+	}
+}
+
+func TestExportImagesDefaultFormats(t *testing.T) {
+	assert := assertlib.New(t)
+
+	// The other outputs are only written when requested
+	outputDir := exportTestImages(t, "--os", "linux")
+	entries, err := os.ReadDir(outputDir)
+	assert.NoError(err)
+	var written []string
+	for _, entry := range entries {
+		written = append(written, entry.Name())
+	}
+	assert.ElementsMatch([]string{
+		"rancher-images-origins.txt",
+		"rancher-images.txt",
+		"rancher-images-sources.txt",
+		"rancher-load-images.sh",
+		"rancher-save-images.sh",
+		"rancher-mirror-to-rancher-org.sh",
+	}, written)
+}
+
+func TestExportImagesTextPinnedDigests(t *testing.T) {
+	assert := assertlib.New(t)
+
+	// The Rancher images are mapped to the registry, which serves every image of the unpinned lists
+	registry := imagetest.New(t)
+	args := []string{
+		"--os", "linux",
+		"--format", "txt",
+		"--format", "sources",
+		"--insecure-host", registry.Host(),
+		"--registry-mapping", "rancher/=" + registry.Host() + "/rancher/",
+	}
+	var expected []string
+	for _, image := range imagetest.ReadLines(t, filepath.Join(exportTestImages(t, args...), "rancher-images.txt")) {
+		repo, tag, _ := strings.Cut(strings.TrimPrefix(image, registry.Host()+"/"), ":")
+		expected = append(expected, image+"@"+registry.AddImage(repo, tag, []byte(image)))
+	}
+	assert.NotEmpty(expected)
+	outputDir := exportTestImages(t, append(args, "--pin-digests")...)
+
+	assert.Equal(expected, imagetest.ReadLines(t, filepath.Join(outputDir, "rancher-images.txt")))
+	assert.Len(imagetest.ReadLines(t, filepath.Join(outputDir, "rancher-images-sources.txt")), len(expected))
+}
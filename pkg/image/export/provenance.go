@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	img "github.com/rancher/rancher/pkg/image"
+)
+
+// provenanceFilename is the file the SLSA provenance of the outputs of the export is written to.
+const provenanceFilename = "rancher-images.intoto.json"
+
+// writeProvenance writes the SLSA provenance of the outputs of the export written since writtenSince, i.e. of the
+// files listed by outputFiles, to provenanceFilename. It must be written after all the other outputs, and before their
+// checksums so that it has a checksum too.
+func writeProvenance(writtenSince time.Time, inputs img.ProvenanceInputs) error {
+	files, err := outputFiles(writtenSince)
+	if err != nil {
+		return err
+	}
+	artifacts := make(map[string]string, len(files))
+	for _, file := range files {
+		if file == provenanceFilename {
+			continue
+		}
+		if artifacts[filepath.ToSlash(file)], err = sha256File(file); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Creating %s\n", provenanceFilename)
+	out, err := os.Create(provenanceFilename)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return img.WriteExportProvenance(out, inputs, artifacts)
+}
+
+// provenanceInputs returns the inputs of the export recorded in its provenance, with the revisions of its charts
+// repositories keyed by name.
+func provenanceInputs(options exportOptions, output exportOutput, startedOn time.Time) img.ProvenanceInputs {
+	repositories := make(map[string]img.ProvenanceRepository, len(options.ChartRepos))
+	for name, repo := range options.ChartRepos {
+		if repo.Path == "" && repo.URL == "" {
+			continue
+		}
+		repositories[name] = img.ProvenanceRepository{ChartRepo: repo, Revision: output.Metadata.Revisions[name]}
+	}
+	parameters := map[string]interface{}{
+		"os":       options.OSTypes,
+		"formats":  options.Formats,
+		"coreOnly": options.CoreOnly,
+	}
+	if len(options.Arches) > 0 {
+		parameters["arch"] = options.Arches
+	}
+	if len(options.ExcludePatterns) > 0 {
+		parameters["exclude"] = options.ExcludePatterns
+	}
+	if len(options.RegistryMapping) > 0 {
+		parameters["registryMapping"] = options.RegistryMapping
+	}
+	if options.MirrorMode != "" {
+		parameters["mirrorMode"] = options.MirrorMode
+	}
+	return img.ProvenanceInputs{
+		RancherVersions: output.Metadata.RancherVersions,
+		Repositories:    repositories,
+		KDMSource:       output.KDM.Source,
+		KDMDigest:       output.KDM.Digest,
+		Parameters:      parameters,
+		ToolVersion:     output.Metadata.ToolVersion,
+		BuilderID:       options.ProvenanceBuilderID,
+		InvocationID:    options.ProvenanceInvocationID,
+		StartedOn:       startedOn,
+		FinishedOn:      time.Now(),
+	}
+}
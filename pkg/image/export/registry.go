@@ -0,0 +1,1372 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	img "github.com/rancher/rancher/pkg/image"
+	"github.com/rancher/rancher/pkg/version"
+	"github.com/urfave/cli"
+	"sigs.k8s.io/yaml"
+)
+
+// imageListsDescription describes the image lists given to the commands reading them.
+const imageListsDescription = "Image lists are files in the rancher-images.txt, rancher-images-sources.txt, rancher-images.json or " +
+	"rancher-images.yaml format."
+
+// registryAuthFlags are the flags configuring the credentials of registries.
+var registryAuthFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "authfile",
+		Usage: "docker config.json file to read the registry credentials from, instead of the docker and containers auth files of the user",
+	},
+	cli.StringSliceFlag{
+		Name:  "registry-auth",
+		Usage: "REGISTRY=USERNAME:PASSWORD credentials of a registry, can be repeated",
+	},
+	cli.StringSliceFlag{
+		Name: "credential-helper",
+		Usage: "REGISTRY=HELPER docker credential helper getting the credentials of a registry, e.g. ecr-login, gcr or acr-env, " +
+			"can be repeated",
+	},
+}
+
+// dockerHubFlags are the flags keeping the requests to registries, Docker Hub in particular, under their rate limits.
+var dockerHubFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "docker-hub-mirror",
+		Usage: "registry mirroring Docker Hub to look the docker.io images up in instead, e.g. a pull through cache",
+	},
+	cli.IntFlag{
+		Name:  "docker-hub-rate",
+		Usage: "maximum number of requests per minute sent to Docker Hub, 0 to not pace them",
+	},
+	cli.IntFlag{
+		Name:  "rate-limit-retries",
+		Usage: "number of times the requests for an image are retried while its registry rate limits them",
+		Value: 3,
+	},
+}
+
+// retryFlags are the flags configuring the retries of the registry requests failing with a transient error, i.e. a
+// network failure or a response with a retryable status.
+var retryFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "transient-retries",
+		Usage: "number of times the requests for an image are retried after a network failure or a retryable response, -1 to not retry them",
+		Value: 2,
+	},
+	cli.DurationFlag{
+		Name:  "retry-delay",
+		Usage: "delay before the first retry of the requests failing with a transient error",
+		Value: time.Second,
+	},
+	cli.DurationFlag{
+		Name:  "retry-max-delay",
+		Usage: "maximum delay between two retries of the requests failing with a transient error",
+		Value: 30 * time.Second,
+	},
+	cli.Float64Flag{
+		Name:  "retry-multiplier",
+		Usage: "factor the delay between two retries is multiplied by after each retry, 1 to retry at a constant delay",
+		Value: 2,
+	},
+	cli.StringFlag{
+		Name:  "retry-status",
+		Usage: "comma separated HTTP status codes of the registry responses retried",
+		Value: "408,500,502,503,504",
+	},
+}
+
+// tlsFlags are the flags configuring the TLS connections to registries and other servers. Proxies are configured by
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+var tlsFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "ca-file",
+		Usage: "PEM file of CA certificates trusted in addition to the system CAs, e.g. of a TLS intercepting proxy, can be repeated",
+	},
+	cli.StringFlag{
+		Name:  "certs-dir",
+		Usage: "directory of per-host certificate directories like /etc/docker/certs.d, read instead of the default ones",
+	},
+	cli.StringSliceFlag{
+		Name:  "insecure-host",
+		Usage: "HOST[:PORT] of a registry or server whose TLS certificate is not verified, can be repeated",
+	},
+}
+
+// registryFlags are the flags of the commands accessing registries.
+var registryFlags = append(append(append([]cli.Flag{
+	cli.IntFlag{
+		Name:  "workers",
+		Usage: "number of images handled concurrently",
+		Value: 8,
+	},
+	cli.IntFlag{
+		Name:  "blob-workers",
+		Usage: "number of layers of an image copied concurrently",
+		Value: 1,
+	},
+	cli.StringFlag{
+		Name:  "max-bandwidth",
+		Usage: "maximum bandwidth of the copied layers per second, e.g. 10MiB, not limited if not set",
+	},
+	cli.StringFlag{
+		Name:  "arch",
+		Usage: "architecture whose images are read in manifest lists (amd64, arm64, s390x, ppc64le)",
+		Value: "amd64",
+	},
+}, registryAuthFlags...), append(dockerHubFlags, retryFlags...)...), tlsFlags...)
+
+// imageListFlags are the flags of the commands reading image lists.
+var imageListFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "os",
+		Usage: "OS of the images in text lists, linux or windows; the OS of the images of JSON and YAML lists is read from the lists",
+		Value: "linux",
+	},
+}
+
+// localStoreFlags are the flags of the commands reading images from a local image store instead of their registries.
+var localStoreFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "local-store",
+		Usage: "local image store to read the images from instead of their registries, docker or containerd; only the platform of --os and --arch is read, and the digests differ from the upstream digests",
+	},
+	cli.StringFlag{
+		Name:  "local-store-address",
+		Usage: "address of the docker daemon, e.g. unix:///var/run/docker.sock, or path of the containerd socket, e.g. /run/k3s/containerd/containerd.sock",
+	},
+	cli.StringFlag{
+		Name:  "containerd-namespace",
+		Usage: "containerd namespace holding the images, k8s.io on Kubernetes nodes",
+		Value: "default",
+	},
+}
+
+func validateCommand() cli.Command {
+	return cli.Command{
+		Name:        "validate",
+		Usage:       "check that every image of the image lists exists in its registry",
+		ArgsUsage:   "IMAGE_LIST...",
+		Description: imageListsDescription + " The manifest of each image is requested without being downloaded.",
+		Flags:       append(append([]cli.Flag{}, imageListFlags...), registryFlags...),
+		Action:      validate,
+	}
+}
+
+func validate(c *cli.Context) error {
+	list, err := readImageListArgs(c, "validate")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	log.Printf("Validating %d images\n", len(list))
+	err = client.Validate(context.Background(), list)
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		for _, imageErr := range imageErrs {
+			if class := imageErr.Class(); class == img.ErrorMissing {
+				log.Printf("Missing %v\n", imageErr)
+			} else {
+				log.Printf("Could not check %v (%s)\n", imageErr, class)
+			}
+		}
+		return fmt.Errorf("%d of %d images could not be validated (%s)", len(imageErrs), len(list), imageErrs.ClassSummary())
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("All %d images exist\n", len(list))
+	return nil
+}
+
+func copyCommand() cli.Command {
+	return cli.Command{
+		Name:      "copy",
+		Usage:     "copy the images of the image lists from their registries to a private registry",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " Images are pushed to the private registry like the load scripts do, and every " +
+			"platform of multi-arch images is copied.",
+		Flags: append(append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "registry",
+				Usage: "private registry to copy the images to, e.g. registry.example.com:5000",
+			},
+			cli.StringFlag{
+				Name:  "source-registry",
+				Usage: "registry to pull the images from instead of their own registries, e.g. a staging mirror",
+			},
+			cli.IntFlag{
+				Name:  "retries",
+				Usage: "number of times the copy of an image is retried after failing",
+				Value: 3,
+			},
+			cli.StringFlag{
+				Name:  "state-file",
+				Usage: "file recording the copied images, which a copy run again with the same file skips",
+			},
+			cli.DurationFlag{
+				Name:  "progress-interval",
+				Usage: "interval between the logs of the bytes transferred and the estimated time left, 0 to disable them",
+				Value: 30 * time.Second,
+			},
+		}, imageListFlags...), append(localStoreFlags, registryFlags...)...),
+		Action: copyImages,
+	}
+}
+
+func copyImages(c *cli.Context) error {
+	if c.String("registry") == "" {
+		return fmt.Errorf("--registry is required")
+	}
+	list, err := readImageListArgs(c, "copy")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	if client.LocalStore, err = localStore(c); err != nil {
+		return err
+	}
+	copier := img.ImageCopier{
+		Client:         client,
+		Registry:       c.String("registry"),
+		SourceRegistry: c.String("source-registry"),
+		Retries:        c.Int("retries"),
+		StateFile:      c.String("state-file"),
+		Progress:       logCopyResult,
+	}
+	if interval := c.Duration("progress-interval"); interval > 0 {
+		copier.TransferProgress = logCopyProgress
+		copier.ProgressInterval = interval
+	}
+	log.Printf("Copying %d images to %s\n", len(list), copier.Registry)
+	results, err := copier.Copy(context.Background(), list)
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		return fmt.Errorf("%d of %d images could not be copied (%s)", len(imageErrs), len(results), imageErrs.ClassSummary())
+	}
+	if err != nil {
+		return err
+	}
+	skipped := 0
+	for _, result := range results {
+		if result.Skipped {
+			skipped++
+		}
+	}
+	log.Printf("Copied %d images, %d were already copied\n", len(results)-skipped, skipped)
+	return nil
+}
+
+// logCopyResult logs the outcome of the copy of an image.
+func logCopyResult(result img.CopyResult) {
+	switch {
+	case result.Err != nil:
+		log.Printf("Failed to copy %s (%s): %v\n", result.Image, img.ClassifyError(result.Err), result.Err)
+	case result.Skipped:
+		log.Printf("Skipped %s, already copied to %s (%s)\n", result.Image, result.Target, result.Digest)
+	default:
+		log.Printf("Copied %s to %s (%s)\n", result.Image, result.Target, result.Digest)
+	}
+}
+
+// logCopyProgress logs the progress of the transfer of the copied images.
+func logCopyProgress(progress img.CopyProgress) {
+	eta := "unknown"
+	if progress.ETA > 0 {
+		eta = progress.ETA.Round(time.Second).String()
+	}
+	log.Printf("Progress: %d of %d images done, %s transferred, about %s left, ETA %s\n", progress.Images, progress.TotalImages,
+		units.BytesSize(float64(progress.Bytes)), units.BytesSize(float64(progress.RemainingBytes)), eta)
+}
+
+func bundleCommand() cli.Command {
+	return cli.Command{
+		Name:      "bundle",
+		Usage:     "pull the images of the image lists into an air-gap bundle",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " The bundle is a tarball of an OCI image layout holding every platform of the " +
+			"images, which docker load, ctr images import and skopeo can read, optionally split into parts, along with an " +
+			"index manifest listing its images and the checksums of its parts.",
+		Flags: append(append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "dir",
+				Usage: "directory to write the bundle to, which needs room for twice its size while it is written",
+				Value: ".",
+			},
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "name of the bundle files",
+				Value: "rancher-images-bundle",
+			},
+			cli.StringFlag{
+				Name:  "source-registry",
+				Usage: "registry to pull the images from instead of their own registries, e.g. a staging mirror",
+			},
+			cli.StringFlag{
+				Name:  "part-size",
+				Usage: "maximum size of the parts the tarball is split into, e.g. 4GiB, not split if not set",
+			},
+			cli.IntFlag{
+				Name:  "retries",
+				Usage: "number of times the pull of an image is retried after failing",
+				Value: 3,
+			},
+		}, imageListFlags...), append(localStoreFlags, registryFlags...)...),
+		Action: buildBundle,
+	}
+}
+
+func buildBundle(c *cli.Context) error {
+	var partSize int64
+	if value := c.String("part-size"); value != "" {
+		var err error
+		if partSize, err = units.RAMInBytes(value); err != nil || partSize <= 0 {
+			return fmt.Errorf("invalid part size %q", value)
+		}
+	}
+	list, err := readImageListArgs(c, "bundle")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	if client.LocalStore, err = localStore(c); err != nil {
+		return err
+	}
+	builder := img.BundleBuilder{
+		Client:         client,
+		Dir:            c.String("dir"),
+		Name:           c.String("name"),
+		SourceRegistry: c.String("source-registry"),
+		PartSize:       partSize,
+		Retries:        c.Int("retries"),
+		Progress:       logBundleResult,
+	}
+	log.Printf("Pulling %d images to %s\n", len(list), builder.Dir)
+	index, err := builder.Build(context.Background(), list)
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		return fmt.Errorf("%d images could not be pulled, the bundle only holds %d images (%s)", len(imageErrs), len(index.Images), imageErrs.ClassSummary())
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote %d images in %d parts, %s, indexed in %s\n", len(index.Images), len(index.Parts),
+		units.BytesSize(float64(index.Size)), builder.IndexFile())
+	return nil
+}
+
+// logBundleResult logs the outcome of the pull of an image into a bundle.
+func logBundleResult(result img.CopyResult) {
+	if result.Err != nil {
+		log.Printf("Failed to pull %s (%s): %v\n", result.Image, img.ClassifyError(result.Err), result.Err)
+		return
+	}
+	log.Printf("Pulled %s (%s)\n", result.Image, result.Digest)
+}
+
+func loadCommand() cli.Command {
+	return cli.Command{
+		Name:  "load",
+		Usage: "push the images of an air-gap bundle to a private registry",
+		Description: "The bundle written by the bundle command is verified against its index manifest and extracted, then " +
+			"its images are pushed to the private registry like the load scripts do. Images the registry already holds " +
+			"are skipped and the digests of the pushed images are verified. A failed load resumes where it stopped.",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "dir",
+				Usage: "directory of the bundle, which needs room for twice its size while it is extracted",
+				Value: ".",
+			},
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "name of the bundle files",
+				Value: "rancher-images-bundle",
+			},
+			cli.StringFlag{
+				Name:  "registry",
+				Usage: "private registry to push the images to, e.g. registry.example.com:5000",
+			},
+			cli.IntFlag{
+				Name:  "retries",
+				Usage: "number of times the push of an image is retried after failing",
+				Value: 3,
+			},
+		}, registryFlags...),
+		Action: loadBundle,
+	}
+}
+
+func loadBundle(c *cli.Context) error {
+	if c.String("registry") == "" {
+		return fmt.Errorf("--registry is required")
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	loader := img.BundleLoader{
+		Client:   client,
+		Dir:      c.String("dir"),
+		Name:     c.String("name"),
+		Registry: c.String("registry"),
+		Retries:  c.Int("retries"),
+		Progress: logLoadResult,
+	}
+	log.Printf("Pushing the bundle in %s to %s\n", loader.Dir, loader.Registry)
+	results, err := loader.Load(context.Background())
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		return fmt.Errorf("%d of %d images could not be pushed (%s), run load again to resume", len(imageErrs), len(results), imageErrs.ClassSummary())
+	}
+	if err != nil {
+		return err
+	}
+	skipped := 0
+	for _, result := range results {
+		if result.Skipped {
+			skipped++
+		}
+	}
+	log.Printf("Pushed %d images, %d were already in the registry\n", len(results)-skipped, skipped)
+	return nil
+}
+
+// logLoadResult logs the outcome of the push of an image of a bundle.
+func logLoadResult(result img.CopyResult) {
+	switch {
+	case result.Err != nil:
+		log.Printf("Failed to push %s (%s): %v\n", result.Image, img.ClassifyError(result.Err), result.Err)
+	case result.Skipped:
+		log.Printf("Skipped %s, %s already has digest %s\n", result.Image, result.Target, result.Digest)
+	default:
+		log.Printf("Pushed %s to %s (%s)\n", result.Image, result.Target, result.Digest)
+	}
+}
+
+func sizeCommand() cli.Command {
+	return cli.Command{
+		Name:        "size",
+		Usage:       "estimate the download size of the images of the image lists",
+		ArgsUsage:   "IMAGE_LIST...",
+		Description: imageListsDescription + " The compressed layers of the images are summed, counting the layers shared by several images once.",
+		Flags:       append(append([]cli.Flag{}, imageListFlags...), registryFlags...),
+		Action:      estimateSize,
+	}
+}
+
+func estimateSize(c *cli.Context) error {
+	list, err := readImageListArgs(c, "size")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	log.Printf("Estimating the size of %d images\n", len(list))
+	for _, estimate := range client.EstimateSize(context.Background(), list) {
+		fmt.Printf("%s/%s: %d images, %s\n", estimate.OS, estimate.Architecture, estimate.Images, units.BytesSize(float64(estimate.CompressedSize)))
+		for _, image := range estimate.Failed {
+			fmt.Printf("  could not look up %s\n", image)
+		}
+	}
+	return nil
+}
+
+func platformsCommand() cli.Command {
+	return cli.Command{
+		Name:      "platforms",
+		Usage:     "report the images of the image lists that are not built for some of the requested platforms",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " Each image is only checked for the platforms of the OS it is exported for, e.g. " +
+			"the Linux images for linux/arm64.",
+		Flags: append(append([]cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "platform",
+				Usage: "OS/ARCHITECTURE platform the images must be built for, can be repeated (default: linux/amd64, linux/arm64, windows/amd64)",
+			},
+		}, imageListFlags...), registryFlags...),
+		Action: reportPlatforms,
+	}
+}
+
+func reportPlatforms(c *cli.Context) error {
+	platforms := img.DefaultPlatforms
+	if c.IsSet("platform") {
+		platforms = nil
+		for _, value := range c.StringSlice("platform") {
+			platform, err := img.ParsePlatform(value)
+			if err != nil {
+				return err
+			}
+			platforms = append(platforms, platform)
+		}
+	}
+	list, err := readImageListArgs(c, "platforms")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	log.Printf("Inspecting the platforms of %d images\n", len(list))
+	gaps, err := client.MissingPlatforms(context.Background(), list, platforms)
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		for _, imageErr := range imageErrs {
+			log.Printf("Could not inspect %v\n", imageErr)
+		}
+	} else if err != nil {
+		return err
+	}
+	for _, gap := range gaps {
+		missing := make([]string, 0, len(gap.Missing))
+		for _, platform := range gap.Missing {
+			missing = append(missing, platform.String())
+		}
+		fmt.Printf("%s (%s): missing %s\n", gap.Image, gap.OS, strings.Join(missing, ", "))
+	}
+	if len(gaps) > 0 || len(imageErrs) > 0 {
+		return fmt.Errorf("%d images are missing platforms, %d images could not be inspected", len(gaps), len(imageErrs))
+	}
+	log.Printf("All %d images are built for their platforms\n", len(list))
+	return nil
+}
+
+func windowsBuildsCommand() cli.Command {
+	return cli.Command{
+		Name:      "windows-builds",
+		Usage:     "report the Windows Server builds the Windows images of the image lists support",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " The os.version of the Windows manifests of each Windows image is read from its " +
+			"registry, and the images that do not support every requested build fail the check, since Windows containers " +
+			"only run on hosts of the build they are built for.",
+		Flags: append([]cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "build",
+				Usage: "Windows Server build the images must support, e.g. ltsc2022, 1809 or 10.0.20348, can be repeated (default: the builds supported by --rancher-version, or 1809, ltsc2022)",
+			},
+			cli.StringFlag{
+				Name:  "rancher-version",
+				Usage: "Rancher version whose supported Windows Server builds the images must support when --build is not set",
+			},
+			cli.StringFlag{
+				Name:  "os",
+				Usage: "OS of the images in text lists, linux or windows; the OS of the images of JSON and YAML lists is read from the lists",
+				Value: "windows",
+			},
+		}, registryFlags...),
+		Action: reportWindowsBuilds,
+	}
+}
+
+func reportWindowsBuilds(c *cli.Context) error {
+	builds := img.DefaultWindowsBuilds
+	if rancherVersion := c.String("rancher-version"); rancherVersion != "" {
+		builds = img.WindowsBuildsForRancherVersion(rancherVersion)
+	}
+	if c.IsSet("build") {
+		builds = nil
+		for _, value := range c.StringSlice("build") {
+			build, err := img.ParseWindowsBuild(value)
+			if err != nil {
+				return err
+			}
+			builds = append(builds, build)
+		}
+	}
+	list, err := readImageListArgs(c, "windows-builds")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	log.Printf("Inspecting the Windows builds of %d images\n", len(list))
+	images, err := client.WindowsBuilds(context.Background(), list, builds)
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		for _, imageErr := range imageErrs {
+			log.Printf("Could not inspect %v\n", imageErr)
+		}
+	} else if err != nil {
+		return err
+	}
+	incomplete := 0
+	for _, image := range images {
+		supported := make([]string, 0, len(image.Supported))
+		for _, build := range image.Supported {
+			supported = append(supported, build.String())
+		}
+		line := fmt.Sprintf("%s: supports %s", image.Image, strings.Join(supported, ", "))
+		if len(supported) == 0 {
+			line = fmt.Sprintf("%s: supports none of the builds", image.Image)
+		}
+		if len(image.Missing) > 0 {
+			incomplete++
+			missing := make([]string, 0, len(image.Missing))
+			for _, build := range image.Missing {
+				missing = append(missing, build.String())
+			}
+			line += ", missing " + strings.Join(missing, ", ")
+		}
+		fmt.Println(line)
+	}
+	if incomplete > 0 || len(imageErrs) > 0 {
+		return fmt.Errorf("%d images are missing Windows builds, %d images could not be inspected", incomplete, len(imageErrs))
+	}
+	log.Printf("All %d Windows images support every build\n", len(images))
+	return nil
+}
+
+func inventoryCommand() cli.Command {
+	return cli.Command{
+		Name:      "inventory",
+		Usage:     "list the images held by a private registry as an image list",
+		ArgsUsage: "REGISTRY",
+		Description: "Every tag of every repository of the registry catalog is listed without the registry, so the list compares " +
+			"to the image lists of Rancher, e.g. with the diff command or as the --inventory of export-images. The registry " +
+			"must allow listing its catalog. Text lists are written as the repositories are listed, so the images of large " +
+			"registries are not held in memory, and are not sorted.",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "file to write the image list to, in the rancher-images.txt, rancher-images.json or rancher-images.yaml format according to its extension",
+				Value: "registry-inventory.txt",
+			},
+			cli.IntFlag{
+				Name:  "max-tags",
+				Usage: "maximum number of tags listed per repository, in the order of the registry, 0 to list every tag",
+			},
+		}, registryFlags...),
+		Action: listInventory,
+	}
+}
+
+func listInventory(c *cli.Context) error {
+	if c.NArg() != 1 {
+		cli.ShowCommandHelp(c, "inventory")
+		return fmt.Errorf("inventory requires 1 argument")
+	}
+	registry := c.Args().First()
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	path := c.String("output")
+	log.Printf("Creating %s\n", path)
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// A registry holds the images of every OS, they are listed as Linux images like the images of text lists
+	log.Printf("Listing the images of %s\n", registry)
+	var list img.ImageList
+	listed := 0
+	ext := filepath.Ext(path)
+	streamed := ext != ".json" && ext != ".yaml" && ext != ".yml"
+	out := bufio.NewWriter(file)
+	err = client.WalkInventory(context.Background(), registry, c.Int("max-tags"), func(images []string) error {
+		listed += len(images)
+		repositoryList := make(img.ImageList, 0, len(images))
+		for _, image := range images {
+			repositoryList = append(repositoryList, img.ImageEntry{Image: image, OS: img.Linux})
+		}
+		if streamed {
+			return repositoryList.WriteImages(out, img.FormatText)
+		}
+		list = append(list, repositoryList...)
+		return nil
+	})
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		for _, imageErr := range imageErrs {
+			log.Printf("Could not list %v\n", imageErr)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	metadata := img.ExportMetadata{GeneratedAt: time.Now().UTC(), ToolVersion: version.FriendlyVersion()}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Image < list[j].Image
+	})
+	switch ext {
+	case ".json":
+		err = img.WriteImageListJSON(out, list, metadata)
+	case ".yaml", ".yml":
+		err = img.WriteImageListYAML(out, list, metadata)
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if len(imageErrs) > 0 {
+		return fmt.Errorf("%d repositories of %s could not be listed", len(imageErrs), registry)
+	}
+	log.Printf("Listed %d images of %s\n", listed, registry)
+	return nil
+}
+
+func staleCommand() cli.Command {
+	return cli.Command{
+		Name:      "stale",
+		Usage:     "report the images of a private registry that the image lists no longer reference",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " The images of the registry, listed from its catalog or read from an inventory " +
+			"file, that none of the image lists reference are written, e.g. the images of older Rancher versions. The deletion " +
+			"manifest lists the digests to delete to reclaim their space, without the images sharing a manifest with a " +
+			"referenced image.",
+		Flags: append(append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "registry",
+				Usage: "private registry holding the images, e.g. registry.example.com:5000",
+			},
+			cli.StringFlag{
+				Name:  "inventory",
+				Usage: "image list of the images the registry holds, e.g. written by the inventory command, instead of listing its catalog",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "file to write the stale images to",
+				Value: "registry-stale-images.txt",
+			},
+			cli.StringFlag{
+				Name:  "deletion-manifest",
+				Usage: "JSON or YAML file to write the digests of the stale images to delete to, according to its extension",
+			},
+		}, imageListFlags...), registryFlags...),
+		Action: reportStaleImages,
+	}
+}
+
+func reportStaleImages(c *cli.Context) error {
+	registry := c.String("registry")
+	if registry == "" && (c.String("inventory") == "" || c.String("deletion-manifest") != "") {
+		return fmt.Errorf("--registry is required to list the registry catalog and to write a deletion manifest")
+	}
+	list, err := readImageListArgs(c, "stale")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	var inventory []string
+	if path := c.String("inventory"); path != "" {
+		inventoryList, err := readImageListFile(path, img.Linux)
+		if err != nil {
+			return err
+		}
+		inventory = inventoryList.Images()
+	} else {
+		log.Printf("Listing the images of %s\n", registry)
+		if inventory, err = client.Inventory(context.Background(), registry); err != nil {
+			return err
+		}
+	}
+
+	stale := img.StaleImages(list, inventory, registry)
+	log.Printf("%d of %d images of the registry are no longer referenced\n", len(stale), len(inventory))
+	staleList := make(img.ImageList, 0, len(stale))
+	for _, image := range stale {
+		staleList = append(staleList, img.ImageEntry{Image: image, OS: img.Linux})
+	}
+	if err := writeImageListFile(c.String("output"), staleList, img.FormatText); err != nil {
+		return err
+	}
+	path := c.String("deletion-manifest")
+	if path == "" {
+		return nil
+	}
+
+	manifest, err := client.DeletionManifest(context.Background(), registry, list, inventory)
+	var imageErrs img.ImageErrors
+	if errors.As(err, &imageErrs) {
+		for _, imageErr := range imageErrs {
+			log.Printf("Could not look up %v\n", imageErr)
+		}
+	} else if err != nil {
+		return err
+	}
+	for _, image := range manifest.Kept {
+		log.Printf("Keeping %s, its manifest may be referenced by another tag\n", image.Image)
+	}
+	if err := writeReportFile(path, manifest); err != nil {
+		return err
+	}
+	log.Printf("%d stale images can be deleted\n", len(manifest.Images))
+	return nil
+}
+
+func verifyCommand() cli.Command {
+	return cli.Command{
+		Name:      "verify",
+		Usage:     "compare the digests of the images of the image lists upstream and in a private registry mirroring them",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " Every image is looked up upstream and in the mirror, where it is pushed like " +
+			"the load scripts do, without downloading the manifests. The images whose digests differ, because the upstream " +
+			"image was retagged or the mirrored image is corrupted, and the images missing from the mirror fail the " +
+			"verification.",
+		Flags: append(append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "registry",
+				Usage: "private registry mirroring the images, e.g. registry.example.com:5000",
+			},
+			cli.StringFlag{
+				Name:  "source-registry",
+				Usage: "registry to look the upstream images up in instead of their own registries, e.g. a staging mirror",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "JSON or YAML file to write the verification report to, according to its extension",
+				Value: "mirror-verification.json",
+			},
+		}, imageListFlags...), registryFlags...),
+		Action: verifyMirror,
+	}
+}
+
+func verifyMirror(c *cli.Context) error {
+	if c.String("registry") == "" {
+		return fmt.Errorf("--registry is required")
+	}
+	list, err := readImageListArgs(c, "verify")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	log.Printf("Verifying %d images in %s\n", len(list), c.String("registry"))
+	report, err := client.VerifyMirror(context.Background(), c.String("registry"), c.String("source-registry"), list)
+	if err != nil {
+		return err
+	}
+	for _, image := range report.Images {
+		switch image.Status {
+		case img.MirrorDrifted:
+			log.Printf("Drifted %s: %s upstream, %s in the mirror\n", image.Image, image.UpstreamDigest, image.MirrorDigest)
+		case img.MirrorMissing:
+			log.Printf("Missing %s from the mirror\n", image.Image)
+		case img.MirrorUnknown:
+			log.Printf("Could not verify %s (%s): %s\n", image.Image, image.ErrorClass, image.Error)
+		}
+	}
+	if err := writeReportFile(c.String("output"), report); err != nil {
+		return err
+	}
+	failed := len(report.Images) - report.Count(img.MirrorVerified)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed the verification: %d drifted, %d missing, %d unknown", failed,
+			len(report.Images), report.Count(img.MirrorDrifted), report.Count(img.MirrorMissing), report.Count(img.MirrorUnknown))
+	}
+	log.Printf("All %d images are verified\n", len(report.Images))
+	return nil
+}
+
+func verifySignaturesCommand() cli.Command {
+	return cli.Command{
+		Name:      "verify-signatures",
+		Usage:     "verify the cosign signatures of the images of the image lists",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " The signatures of every image are looked up in its registry, where cosign " +
+			"stores them, and verified offline: keyed signatures against the public keys, keyless signatures against the " +
+			"certificate identity, the Fulcio roots and the Rekor public key. The unsigned images and the images none of " +
+			"whose signatures verify fail the verification.",
+		Flags: append(append([]cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "key",
+				Usage: "PEM file of a public key the images may be signed with, as written by cosign generate-key-pair, can be repeated",
+			},
+			cli.StringFlag{
+				Name:  "certificate-identity",
+				Usage: "email or URI the certificates of keyless signatures must be issued for",
+			},
+			cli.StringFlag{
+				Name:  "certificate-identity-regexp",
+				Usage: "regular expression matching the email or URI the certificates of keyless signatures must be issued for",
+			},
+			cli.StringFlag{
+				Name:  "certificate-oidc-issuer",
+				Usage: "OIDC issuer of the identity of keyless signatures, e.g. https://token.actions.githubusercontent.com",
+			},
+			cli.StringFlag{
+				Name:  "fulcio-root",
+				Usage: "PEM file of the Fulcio root and intermediate certificates keyless signatures are verified against",
+			},
+			cli.StringFlag{
+				Name:  "rekor-public-key",
+				Usage: "PEM file of the public key of the Rekor transparency log keyless signatures are logged in",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "JSON or YAML file to write the verification report to, according to its extension",
+				Value: "signature-verification.json",
+			},
+		}, imageListFlags...), registryFlags...),
+		Action: verifySignatures,
+	}
+}
+
+func verifySignatures(c *cli.Context) error {
+	policy, err := signaturePolicy(c)
+	if err != nil {
+		return err
+	}
+	list, err := readImageListArgs(c, "verify-signatures")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	log.Printf("Verifying the signatures of %d images\n", len(list))
+	report, err := client.VerifySignatures(context.Background(), list, policy)
+	if err != nil {
+		return err
+	}
+	for _, image := range report.Images {
+		switch image.Status {
+		case img.SignatureUnsigned:
+			log.Printf("Unsigned %s (%s)\n", image.Image, image.Digest)
+		case img.SignatureInvalid:
+			log.Printf("Invalid signatures of %s (%s): %s\n", image.Image, image.Digest, image.Error)
+		case img.SignatureUnknown:
+			log.Printf("Could not verify %s (%s): %s\n", image.Image, image.ErrorClass, image.Error)
+		}
+	}
+	if err := writeReportFile(c.String("output"), report); err != nil {
+		return err
+	}
+	failed := len(report.Images) - report.Count(img.SignatureVerified)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed the verification: %d unsigned, %d invalid, %d unknown", failed,
+			len(report.Images), report.Count(img.SignatureUnsigned), report.Count(img.SignatureInvalid), report.Count(img.SignatureUnknown))
+	}
+	log.Printf("All %d images are signed\n", len(report.Images))
+	return nil
+}
+
+// signaturePolicy returns the signature policy configured by the flags of the verify-signatures command.
+func signaturePolicy(c *cli.Context) (img.SignaturePolicy, error) {
+	var policy img.SignaturePolicy
+	for _, path := range c.StringSlice("key") {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return policy, err
+		}
+		key, err := img.ParsePublicKey(content)
+		if err != nil {
+			return policy, fmt.Errorf("%s: %w", path, err)
+		}
+		policy.PublicKeys = append(policy.PublicKeys, key)
+	}
+
+	identity, identityRegexp := c.String("certificate-identity"), c.String("certificate-identity-regexp")
+	if identity == "" && identityRegexp == "" {
+		if len(policy.PublicKeys) == 0 {
+			return policy, fmt.Errorf("--key or --certificate-identity is required")
+		}
+		return policy, nil
+	}
+	if identity != "" && identityRegexp != "" {
+		return policy, fmt.Errorf("--certificate-identity and --certificate-identity-regexp are mutually exclusive")
+	}
+	if identity != "" {
+		identityRegexp = "^" + regexp.QuoteMeta(identity) + "$"
+	}
+	subject, err := regexp.Compile(identityRegexp)
+	if err != nil {
+		return policy, fmt.Errorf("invalid certificate identity: %w", err)
+	}
+	if c.String("fulcio-root") == "" || c.String("rekor-public-key") == "" {
+		return policy, fmt.Errorf("--fulcio-root and --rekor-public-key are required to verify keyless signatures")
+	}
+	content, err := os.ReadFile(c.String("rekor-public-key"))
+	if err != nil {
+		return policy, err
+	}
+	rekorKey, err := img.ParsePublicKey(content)
+	if err != nil {
+		return policy, fmt.Errorf("%s: %w", c.String("rekor-public-key"), err)
+	}
+	roots, intermediates, err := readFulcioCertificates(c.String("fulcio-root"))
+	if err != nil {
+		return policy, err
+	}
+	policy.Keyless = &img.KeylessIdentity{
+		Roots:          roots,
+		Intermediates:  intermediates,
+		Issuer:         c.String("certificate-oidc-issuer"),
+		Subject:        subject,
+		RekorPublicKey: rekorKey,
+	}
+	return policy, nil
+}
+
+// readFulcioCertificates reads the PEM file of Fulcio certificates at path, and returns its self-signed root
+// certificates and its intermediate certificates.
+func readFulcioCertificates(path string) (*x509.CertPool, *x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	for {
+		var block *pem.Block
+		if block, content = pem.Decode(content); block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	return roots, intermediates, nil
+}
+
+func sbomCommand() cli.Command {
+	return cli.Command{
+		Name:      "sbom",
+		Usage:     "aggregate the SBOM attestations of the images of the image lists into a bill of materials of the release",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " The SPDX and CycloneDX SBOM attestations of every image are read from its " +
+			"registry, both those BuildKit attaches to manifest lists and those cosign attests, and aggregated into a " +
+			"CycloneDX bill of materials with a component per image. The images without SBOM are listed in it too.",
+		Flags: append(append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "rancher-version",
+				Usage: "Rancher version the bill of materials is for",
+				Value: os.Getenv("TAG"),
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "file to write the CycloneDX JSON bill of materials to",
+				Value: "rancher-sbom.cdx.json",
+			},
+			cli.BoolFlag{
+				Name:  "strict",
+				Usage: "fail if some images have no SBOM attestation",
+			},
+		}, imageListFlags...), registryFlags...),
+		Action: aggregateSBOMs,
+	}
+}
+
+func aggregateSBOMs(c *cli.Context) error {
+	list, err := readImageListArgs(c, "sbom")
+	if err != nil {
+		return err
+	}
+	client, err := registryClient(c)
+	if err != nil {
+		return err
+	}
+	defer client.TLS.Close()
+	log.Printf("Fetching the SBOMs of %d images\n", len(list))
+	sboms := client.FetchSBOMs(context.Background(), list)
+	counts := make(map[img.SBOMStatus]int)
+	for _, sbom := range sboms {
+		counts[sbom.Status]++
+		switch sbom.Status {
+		case img.SBOMMissing:
+			log.Printf("No SBOM for %s\n", sbom.Image)
+		case img.SBOMUnknown:
+			log.Printf("Could not fetch the SBOMs of %s (%s): %s\n", sbom.Image, sbom.ErrorClass, sbom.Error)
+		}
+	}
+
+	log.Printf("Creating %s\n", c.String("output"))
+	file, err := os.Create(c.String("output"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := img.WriteReleaseBOM(file, c.String("rancher-version"), sboms); err != nil {
+		return err
+	}
+	if counts[img.SBOMUnknown] > 0 || (c.Bool("strict") && counts[img.SBOMMissing] > 0) {
+		return fmt.Errorf("%d of %d images have no SBOM in the bill of materials: %d missing, %d unknown",
+			counts[img.SBOMMissing]+counts[img.SBOMUnknown], len(sboms), counts[img.SBOMMissing], counts[img.SBOMUnknown])
+	}
+	log.Printf("Aggregated the SBOMs of %d of %d images\n", counts[img.SBOMFound], len(sboms))
+	return nil
+}
+
+func scanCommand() cli.Command {
+	return cli.Command{
+		Name:      "scan",
+		Usage:     "scan the images of the image lists for vulnerabilities with Trivy",
+		ArgsUsage: "IMAGE_LIST...",
+		Description: imageListsDescription + " Every image is scanned by the trivy CLI, which must be installed and " +
+			"pulls the images with the credentials of the docker config, and the vulnerabilities found are written to " +
+			"a report with a summary per source of the images, e.g. per chart. The command fails if some images exceed " +
+			"the --threshold maximum numbers of vulnerabilities or could not be scanned.",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:  "trivy-server",
+				Usage: "URL of a Trivy server to scan the images with, instead of downloading the vulnerability database locally",
+			},
+			cli.BoolFlag{
+				Name:  "ignore-unfixed",
+				Usage: "ignore the vulnerabilities without a fixed version",
+			},
+			cli.StringSliceFlag{
+				Name:  "threshold",
+				Usage: "SEVERITY=MAX maximum number of vulnerabilities of a severity (UNKNOWN, LOW, MEDIUM, HIGH, CRITICAL) an image may have, e.g. CRITICAL=0, can be repeated",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "file to write the vulnerability report to, in YAML if it ends with .yaml or .yml and in JSON otherwise",
+				Value: "vulnerability-report.json",
+			},
+			cli.IntFlag{
+				Name:  "workers",
+				Usage: "number of images scanned concurrently",
+				Value: 4,
+			},
+			cli.StringFlag{
+				Name:  "arch",
+				Usage: "architecture whose images are scanned in manifest lists (amd64, arm64, s390x, ppc64le)",
+				Value: "amd64",
+			},
+		}, imageListFlags...),
+		Action: scanImages,
+	}
+}
+
+func scanImages(c *cli.Context) error {
+	thresholds, err := img.ParseVulnerabilityThresholds(c.StringSlice("threshold"))
+	if err != nil {
+		return err
+	}
+	arch, err := img.ParseArch(c.String("arch"))
+	if err != nil {
+		return err
+	}
+	list, err := readImageListArgs(c, "scan")
+	if err != nil {
+		return err
+	}
+	scanner := img.VulnerabilityScanner{
+		Server:        c.String("trivy-server"),
+		IgnoreUnfixed: c.Bool("ignore-unfixed"),
+		Arch:          arch,
+		Workers:       c.Int("workers"),
+	}
+	log.Printf("Scanning %d images for vulnerabilities\n", len(list))
+	report := scanner.Scan(context.Background(), list)
+	for _, source := range report.Sources {
+		log.Printf("%s: %d images, vulnerabilities %s\n", source.Source, source.Images, source.Counts)
+	}
+	if err := writeReportFile(c.String("output"), report); err != nil {
+		return err
+	}
+
+	violations := report.Violations(thresholds)
+	for _, image := range violations {
+		if image.Error != "" {
+			log.Printf("Could not scan %s: %s\n", image.Image, image.Error)
+		} else {
+			log.Printf("%s exceeds the thresholds, vulnerabilities %s\n", image.Image, image.Counts)
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d of %d images exceed the vulnerability thresholds or could not be scanned", len(violations), len(report.Images))
+	}
+	log.Printf("Scanned %d images\n", len(report.Images))
+	return nil
+}
+
+// writeReportFile writes report to path, in YAML for .yaml and .yml files and in JSON otherwise.
+func writeReportFile(path string, report interface{}) error {
+	var out []byte
+	var err error
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		out, err = yaml.Marshal(report)
+	default:
+		out, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Creating %s\n", path)
+	return os.WriteFile(path, out, 0644)
+}
+
+// readImageListArgs reads the image lists given as arguments to command.
+func readImageListArgs(c *cli.Context, command string) (img.ImageList, error) {
+	if c.NArg() == 0 {
+		cli.ShowCommandHelp(c, command)
+		return nil, fmt.Errorf("%s requires at least 1 argument", command)
+	}
+	osTypes, err := parseOSTypes([]string{c.String("os")})
+	if err != nil {
+		return nil, err
+	}
+	var list img.ImageList
+	for _, path := range c.Args() {
+		fileList, err := readImageListFile(path, osTypes[0])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, fileList...)
+	}
+	return list, nil
+}
+
+// registryClient returns the registry client configured by the registry flags. The TLS configuration of the client
+// must be closed.
+func registryClient(c *cli.Context) (img.RegistryClient, error) {
+	credentials, err := registryCredentials(c)
+	if err != nil {
+		return img.RegistryClient{}, err
+	}
+	bandwidth, err := bandwidthLimiter(c)
+	if err != nil {
+		return img.RegistryClient{}, err
+	}
+	tls, err := tlsConfig(c)
+	if err != nil {
+		return img.RegistryClient{}, err
+	}
+	retry, err := retryPolicy(c)
+	if err != nil {
+		return img.RegistryClient{}, err
+	}
+	arch, err := img.ParseArch(c.String("arch"))
+	if err != nil {
+		return img.RegistryClient{}, err
+	}
+	dockerHub := dockerHubLimiter(c)
+	dockerHub.Client = tls.Client()
+	return img.RegistryClient{
+		Arch:             arch,
+		Credentials:      credentials,
+		TLS:              tls,
+		Workers:          c.Int("workers"),
+		BlobWorkers:      c.Int("blob-workers"),
+		Bandwidth:        bandwidth,
+		DockerHub:        dockerHub,
+		RateLimitRetries: c.Int("rate-limit-retries"),
+		Retry:            retry,
+	}, nil
+}
+
+// retryPolicy returns the retry policy configured by the retry flags.
+func retryPolicy(c *cli.Context) (img.RetryPolicy, error) {
+	statuses, err := img.ParseRetryableStatuses(c.String("retry-status"))
+	if err != nil {
+		return img.RetryPolicy{}, err
+	}
+	return img.RetryPolicy{
+		Retries:           c.Int("transient-retries"),
+		Delay:             c.Duration("retry-delay"),
+		MaxDelay:          c.Duration("retry-max-delay"),
+		Multiplier:        c.Float64("retry-multiplier"),
+		RetryableStatuses: statuses,
+	}, nil
+}
+
+// tlsConfig returns the TLS configuration configured by the TLS flags.
+func tlsConfig(c *cli.Context) (*img.TLSConfig, error) {
+	return img.NewTLSConfig(c.StringSlice("ca-file"), c.String("certs-dir"), c.StringSlice("insecure-host"))
+}
+
+// bandwidthLimiter returns the bandwidth limiter configured by the --max-bandwidth flag, or nil if it is not set.
+func bandwidthLimiter(c *cli.Context) (*img.BandwidthLimiter, error) {
+	value := c.String("max-bandwidth")
+	if value == "" {
+		return nil, nil
+	}
+	bytesPerSecond, err := units.RAMInBytes(value)
+	if err != nil || bytesPerSecond <= 0 {
+		return nil, fmt.Errorf("invalid bandwidth %q", value)
+	}
+	return img.NewBandwidthLimiter(bytesPerSecond), nil
+}
+
+// localStore returns the local image store configured by the local store flags, or nil if --local-store is not set.
+func localStore(c *cli.Context) (*img.LocalStore, error) {
+	if c.String("local-store") == "" {
+		return nil, nil
+	}
+	if c.String("source-registry") != "" {
+		return nil, fmt.Errorf("--local-store and --source-registry cannot be used together")
+	}
+	storeType, err := img.ParseLocalStoreType(c.String("local-store"))
+	if err != nil {
+		return nil, err
+	}
+	return &img.LocalStore{Type: storeType, Address: c.String("local-store-address"), Namespace: c.String("containerd-namespace")}, nil
+}
+
+// dockerHubLimiter returns the Docker Hub limiter configured by the Docker Hub flags.
+func dockerHubLimiter(c *cli.Context) *img.DockerHubLimiter {
+	return img.NewDockerHubLimiter(c.Int("docker-hub-rate"), c.String("docker-hub-mirror"))
+}
+
+// registryCredentials returns the registry credentials configured by the registry auth flags.
+func registryCredentials(c *cli.Context) (img.RegistryCredentials, error) {
+	credentials, err := img.ParseRegistryCredentials(c.StringSlice("registry-auth"))
+	if err != nil {
+		return img.RegistryCredentials{}, err
+	}
+	helpers, err := img.ParseCredentialHelpers(c.StringSlice("credential-helper"))
+	if err != nil {
+		return img.RegistryCredentials{}, err
+	}
+	return img.RegistryCredentials{AuthFile: c.String("authfile"), Credentials: credentials, Helpers: helpers}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	img "github.com/rancher/rancher/pkg/image"
+)
+
+// fetchRepo returns the local path of the charts repository repo. Repositories with a git URL and no path are shallow
+// cloned to a temporary directory removed by the returned cleanup function, with the certificates of tls.
+func fetchRepo(repo img.ChartRepo, tls *img.TLSConfig) (string, func(), error) {
+	noop := func() {}
+	if repo.Path != "" || repo.URL == "" {
+		return repo.Path, noop, nil
+	}
+	dir, err := os.MkdirTemp("", "rancher-charts-")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+
+	args, err := tls.GitConfig(repo.URL)
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	if password := repo.Password(); repo.Username != "" || password != "" {
+		// Pass the credentials as a header rather than in the URL, so they are not printed by git
+		credentials := base64.StdEncoding.EncodeToString([]byte(repo.Username + ":" + password))
+		args = append(args, "-c", "http.extraHeader=Authorization: Basic "+credentials)
+	}
+	args = append(args, "clone", "--depth=1", "--no-tags")
+	if repo.Branch != "" {
+		args = append(args, "--branch", repo.Branch)
+	}
+	args = append(args, repo.URL, dir)
+	log.Printf("Cloning %s\n", repo.URL)
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("could not clone %s: %w", repo.URL, err)
+	}
+	return dir, cleanup, nil
+}
+
+func isGitURL(pathOrURL string) bool {
+	for _, prefix := range []string{"https://", "http://", "ssh://", "git@"} {
+		if strings.HasPrefix(pathOrURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// repoRevisions returns the git revision of each of the named repositories at the given paths. Repositories that are
+// not git repositories are left out.
+func repoRevisions(paths map[string]string) map[string]string {
+	revisions := make(map[string]string, len(paths))
+	for name, path := range paths {
+		if path == "" {
+			continue
+		}
+		out, err := exec.Command("git", "-C", path, "rev-parse", "HEAD").Output()
+		if err != nil {
+			continue
+		}
+		revisions[name] = strings.TrimSpace(string(out))
+	}
+	return revisions
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -25,7 +26,15 @@ type GithubEndpoint struct {
 	{URL: "https://api.github.com/repos/rancher/ui-plugin-charts/releases"},
 }
 
-func (e ExtensionsConfig) FetchExtensionImages(imagesSet map[string]map[string]struct{}) error {
+func (e ExtensionsConfig) Name() string {
+	return "extensions"
+}
+
+func (e ExtensionsConfig) FetchExtensionImages(imagesSet *ImageSet) error {
+	return e.FetchImages(context.Background(), imagesSet)
+}
+
+func (e ExtensionsConfig) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
 	for _, endpoint := range e.GithubEndpoints {
 		// Parse the repository name from the URL
 		repoName, err := parseRepoName(endpoint.URL)
@@ -34,7 +43,7 @@ func (e ExtensionsConfig) FetchExtensionImages(imagesSet map[string]map[string]s
 		}
 
 		// Fetch latest releases from GitHub API for the current endpoint
-		latestReleases, err := getLatestReleases(endpoint)
+		latestReleases, err := getLatestReleases(ctx, endpoint)
 		if err != nil {
 			return err
 		}
@@ -53,7 +62,9 @@ func (e ExtensionsConfig) FetchExtensionImages(imagesSet map[string]map[string]s
 		} else {
 			image = repoName + ":" + latestReleaseTag
 		}
-		addSourceToImage(imagesSet, image, "ui-extension")
+		for _, osType := range imagesSet.OSTypes() {
+			imagesSet.Add(osType, image, "ui-extension")
+		}
 	}
 
 	return nil
@@ -70,16 +81,20 @@ func (e ExtensionsConfig) FetchExtensionImages(imagesSet map[string]map[string]s
 	return parts[4] + "/" + parts[5], nil
 }
 
-func getLatestReleases(githubURL GithubEndpoint) ([]Release, error) {
+func getLatestReleases(ctx context.Context, githubURL GithubEndpoint) ([]Release, error) {
 	// Get the releases from GitHub API
-	resp, err := http.Get(githubURL.URL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubURL.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClientFromContext(ctx).Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var releases []Release
-	decoder := json.NewDecoder(resp.Body)
+	decoder := json.NewDecoder(progressFromContext(ctx).reader(resp.Body))
 	if err := decoder.Decode(&releases); err != nil {
 		return nil, err
 	}
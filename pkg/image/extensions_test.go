@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -48,7 +49,7 @@ func TestGetLatestReleases(t *testing.T) {
 	assert := assertlib.New(t)
 
 	endpoint := GithubEndpoint{URL: server.URL}
-	releases, err := getLatestReleases(endpoint)
+	releases, err := getLatestReleases(context.Background(), endpoint)
 	assert.NoError(err)
 	assert.Len(releases, 6)
 	assert.Equal("1.0.0", releases[0].TagName)
@@ -80,7 +81,7 @@ func TestFetchExtensionImages(t *testing.T) {
 	endpoints := []GithubEndpoint{{URL: server.URL}}
 	extensions := ExtensionsConfig{GithubEndpoints: endpoints}
 
-	imagesSet := map[string]map[string]struct{}{}
+	imagesSet := NewImageSet(Linux)
 
 	// Mock the parseRepoName function to return the expected repoName
 	originalParseRepoName := parseRepoName
@@ -97,8 +98,8 @@ func TestFetchExtensionImages(t *testing.T) {
 	assert.NoError(err)
 
 	imageKey := "some-org/some-repo:2.0.0"
-	assert.Contains(imagesSet, imageKey)
-	assert.Contains(imagesSet[imageKey], "ui-extension")
+	assert.True(imagesSet.Has(Linux, imageKey))
+	assert.Contains(imagesSet.Sources(Linux, imageKey), "ui-extension")
 }
 
 func TestFetchExtensionImages_NoSuitableRelease(t *testing.T) {
@@ -114,7 +115,7 @@ func TestFetchExtensionImages_NoSuitableRelease(t *testing.T) {
 	endpoints := []GithubEndpoint{{URL: server.URL}}
 	extensions := ExtensionsConfig{GithubEndpoints: endpoints}
 
-	imagesSet := map[string]map[string]struct{}{}
+	imagesSet := NewImageSet(Linux)
 
 	originalParseRepoName := parseRepoName
 	parseRepoName = func(url string) (string, error) {
@@ -0,0 +1,125 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultExtraImagesSource is the source label of extra images that do not define one.
+const defaultExtraImagesSource = "extra"
+
+// ExtraImages provides site-specific images listed in files, so they are tracked alongside the images required by
+// Rancher. Files ending in .yaml or .yml are read as ExtraImagesFile documents. Any other file is read as text with
+// one image per line, optionally followed by a comma separated list of source labels, the same format as
+// rancher-images-sources.txt. Empty lines and lines starting with "#" are ignored. Images without an OS are added
+// to the Linux list, and images without a source label are added with the "extra" label.
+type ExtraImages struct {
+	Paths []string
+}
+
+// ExtraImagesFile is the YAML format of an extra images file.
+type ExtraImagesFile struct {
+	Images []ExtraImage `yaml:"images"`
+}
+
+// ExtraImage is a single entry of an extra images file.
+type ExtraImage struct {
+	Image string `yaml:"image"`
+	// Source is the label the image is added with.
+	Source string `yaml:"source"`
+	// OS is a comma separated list of the OS types the image is used on, e.g. "linux,windows".
+	OS string `yaml:"os"`
+}
+
+func init() {
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		if len(config.ExtraImagesFiles) == 0 {
+			return nil
+		}
+		return ExtraImages{Paths: config.ExtraImagesFiles}
+	})
+}
+
+func (e ExtraImages) Name() string {
+	return "extra images"
+}
+
+func (e ExtraImages) FetchImages(_ context.Context, imagesSet *ImageSet) error {
+	for _, path := range e.Paths {
+		extraImages, err := readExtraImagesFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read extra images file %s", path)
+		}
+		for _, extraImage := range extraImages {
+			source := extraImage.Source
+			if source == "" {
+				source = defaultExtraImagesSource
+			}
+			osTypes, err := parseOSList(extraImage.OS)
+			if err != nil {
+				return errors.Wrapf(err, "invalid os for extra image %s in %s", extraImage.Image, path)
+			}
+			for _, osType := range osTypes {
+				for _, label := range strings.Split(source, ",") {
+					imagesSet.Add(osType, extraImage.Image, strings.TrimSpace(label))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readExtraImagesFile reads the extra images listed in the file at path.
+func readExtraImagesFile(path string) ([]ExtraImage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		var extraImagesFile ExtraImagesFile
+		if err := decodeYAMLFile(file, &extraImagesFile); err != nil {
+			return nil, err
+		}
+		return extraImagesFile.Images, nil
+	}
+
+	var extraImages []ExtraImage
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		extraImage := ExtraImage{Image: fields[0]}
+		if len(fields) > 1 {
+			extraImage.Source = fields[1]
+		}
+		extraImages = append(extraImages, extraImage)
+	}
+	return extraImages, scanner.Err()
+}
+
+// parseOSList parses a comma separated list of OS names. An empty list defaults to Linux.
+func parseOSList(osList string) ([]OSType, error) {
+	if strings.TrimSpace(osList) == "" {
+		return []OSType{Linux}, nil
+	}
+	var osTypes []OSType
+	for _, os := range strings.Split(osList, ",") {
+		osType, err := ParseOSType(os)
+		if err != nil {
+			return nil, err
+		}
+		osTypes = append(osTypes, osType)
+	}
+	return osTypes, nil
+}
@@ -0,0 +1,55 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestExtraImagesFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	dir := t.TempDir()
+	textPath := filepath.Join(dir, "extra-images.txt")
+	err := os.WriteFile(textPath, []byte(`# site add-ons
+registry.example.com/addon:v1
+registry.example.com/monitor:v2 monitoring,alerting
+
+`), 0644)
+	assert.NoError(err)
+	yamlPath := filepath.Join(dir, "extra-images.yaml")
+	err = os.WriteFile(yamlPath, []byte(`images:
+- image: registry.example.com/agent:v3
+  source: site-agent
+  os: linux,windows
+- image: registry.example.com/windows-only:v4
+  os: windows
+`), 0644)
+	assert.NoError(err)
+
+	imagesSet := NewImageSet(Linux, Windows)
+	extraImages := ExtraImages{Paths: []string{textPath, yamlPath}}
+	assert.NoError(extraImages.FetchImages(context.Background(), imagesSet))
+
+	assert.Equal(map[string]map[string]struct{}{
+		"registry.example.com/addon:v1":   {"extra": {}},
+		"registry.example.com/monitor:v2": {"monitoring": {}, "alerting": {}},
+		"registry.example.com/agent:v3":   {"site-agent": {}},
+	}, imageSetToMap(imagesSet, Linux))
+	assert.Equal(map[string]map[string]struct{}{
+		"registry.example.com/agent:v3":        {"site-agent": {}},
+		"registry.example.com/windows-only:v4": {"extra": {}},
+	}, imageSetToMap(imagesSet, Windows))
+}
+
+func TestExtraImagesInvalidOS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extra-images.yml")
+	err := os.WriteFile(path, []byte("images:\n- image: registry.example.com/addon:v1\n  os: plan9\n"), 0644)
+	assertlib.NoError(t, err)
+
+	extraImages := ExtraImages{Paths: []string{path}}
+	assertlib.Error(t, extraImages.FetchImages(context.Background(), NewImageSet(Linux)))
+}
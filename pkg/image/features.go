@@ -0,0 +1,70 @@
+package image
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// featureCharts maps Rancher feature flags to the charts that are only installed when the feature is enabled.
+var featureCharts = map[string][]string{
+	"fleet":                {"fleet", "fleet-crd", "fleet-agent"},
+	"harvester":            {"harvester-cloud-provider", "harvester-csi-driver"},
+	"embedded-cluster-api": {"rancher-provisioning-capi"},
+}
+
+// featureSystemCharts maps Rancher feature flags to the system charts that are only installed when the feature is
+// enabled. System charts are all legacy apps, so none of them are installed when the legacy feature is disabled.
+var featureSystemCharts = map[string][]string{
+	"monitoringv1": {"rancher-monitoring"},
+}
+
+// legacyFeature is the feature flag enabling the legacy apps of the system charts.
+const legacyFeature = "legacy"
+
+// featureDisabled returns true if feature is explicitly disabled in the export configuration. Features that are not
+// set are considered enabled, so that their images are exported.
+func (c ExportConfig) featureDisabled(feature string) bool {
+	enabled, ok := c.Features[feature]
+	return ok && !enabled
+}
+
+// chartDisabled returns true if the chart called chartName is only installed when a disabled feature is enabled.
+func (c ExportConfig) chartDisabled(chartName string, systemChart bool) bool {
+	charts := featureCharts
+	if systemChart {
+		if c.featureDisabled(legacyFeature) {
+			return true
+		}
+		charts = featureSystemCharts
+	}
+	for feature, featureChartNames := range charts {
+		if !c.featureDisabled(feature) {
+			continue
+		}
+		for _, name := range featureChartNames {
+			if name == chartName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseFeatures parses feature flags given as NAME=BOOL, e.g. legacy=false.
+func ParseFeatures(features []string) (map[string]bool, error) {
+	parsed := make(map[string]bool, len(features))
+	for _, feature := range features {
+		name, value, ok := strings.Cut(feature, "=")
+		if !ok || name == "" {
+			return nil, errors.Errorf("invalid feature %q, must be NAME=BOOL", feature)
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value for feature %s", name)
+		}
+		parsed[name] = enabled
+	}
+	return parsed, nil
+}
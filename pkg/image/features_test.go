@@ -0,0 +1,34 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestExportConfigChartDisabled(t *testing.T) {
+	assert := assertlib.New(t)
+
+	config := ExportConfig{Features: map[string]bool{"fleet": false, "harvester": true, "monitoringv1": false}}
+	assert.True(config.chartDisabled("fleet-agent", false))
+	assert.False(config.chartDisabled("harvester-csi-driver", false))
+	assert.False(config.chartDisabled("rancher-monitoring", false))
+	assert.True(config.chartDisabled("rancher-monitoring", true))
+	assert.False(config.chartDisabled("rancher-logging", true))
+
+	config.Features["legacy"] = false
+	assert.True(config.chartDisabled("rancher-logging", true))
+
+	assert.False(ExportConfig{}.chartDisabled("fleet", false))
+}
+
+func TestParseFeatures(t *testing.T) {
+	features, err := ParseFeatures([]string{"legacy=false", "harvester=true"})
+	assertlib.NoError(t, err)
+	assertlib.Equal(t, map[string]bool{"legacy": false, "harvester": true}, features)
+
+	_, err = ParseFeatures([]string{"legacy"})
+	assertlib.Error(t, err)
+	_, err = ParseFeatures([]string{"legacy=maybe"})
+	assertlib.Error(t, err)
+}
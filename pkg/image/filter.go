@@ -0,0 +1,81 @@
+package image
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// regexPatternPrefix marks an image pattern as a regular expression instead of a glob.
+const regexPatternPrefix = "regex:"
+
+// ImageFilter matches images against a set of patterns. Patterns are globs where "*" matches any sequence of
+// characters, including "/" and ":", and "?" matches a single character, e.g. "*-windows-*" or
+// "rancher/mirrored-istio-*". Patterns prefixed with "regex:" are regular expressions instead, e.g.
+// "regex:^rancher/hardened-.*:v1\.2[0-3]". Both kinds of patterns must match the whole image reference.
+type ImageFilter struct {
+	patterns []*regexp.Regexp
+}
+
+// NewImageFilter compiles patterns into an ImageFilter.
+func NewImageFilter(patterns []string) (*ImageFilter, error) {
+	filter := &ImageFilter{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		expr := globToRegexp(pattern)
+		if strings.HasPrefix(pattern, regexPatternPrefix) {
+			expr = "^(?:" + strings.TrimPrefix(pattern, regexPatternPrefix) + ")$"
+		}
+		compiled, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid image pattern %q", pattern)
+		}
+		filter.patterns = append(filter.patterns, compiled)
+	}
+	return filter, nil
+}
+
+// Empty returns true if the filter has no patterns.
+func (f *ImageFilter) Empty() bool {
+	return f == nil || len(f.patterns) == 0
+}
+
+// Match returns true if image matches any of the patterns of the filter.
+func (f *ImageFilter) Match(image string) bool {
+	if f == nil {
+		return false
+	}
+	for _, pattern := range f.patterns {
+		if pattern.MatchString(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// Exclude splits the list into the entries that do not match filter and the ones that do.
+func (l ImageList) Exclude(filter *ImageFilter) (ImageList, ImageList) {
+	if filter.Empty() {
+		return l, nil
+	}
+	var kept, excluded ImageList
+	for _, entry := range l {
+		if filter.Match(entry.Image) {
+			excluded = append(excluded, entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, excluded
+}
+
+func globToRegexp(glob string) string {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return "^" + expr + "$"
+}
@@ -0,0 +1,57 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestImageFilterMatch(t *testing.T) {
+	testCases := []struct {
+		description string
+		patterns    []string
+		image       string
+		expected    bool
+	}{
+		{"glob spanning repository separators", []string{"*-windows-*"}, "rancher/fleet-windows-agent:v0.7.0", true},
+		{"glob prefix", []string{"rancher/mirrored-istio-*"}, "rancher/mirrored-istio-proxyv2:1.17.2", true},
+		{"glob single character", []string{"rancher/shell:v0.1.?"}, "rancher/shell:v0.1.9", true},
+		{"glob must match whole image", []string{"rancher/shell"}, "rancher/shell:v0.1.9", false},
+		{"regex", []string{`regex:^rancher/hardened-.*:v1\.2[0-3].*`}, "rancher/hardened-kubernetes:v1.23.4-rke2r1", true},
+		{"regex must match whole image", []string{"regex:hardened"}, "rancher/hardened-kubernetes:v1.23.4-rke2r1", false},
+		{"any pattern matches", []string{"foo", "rancher/*"}, "rancher/shell:v0.1.9", true},
+		{"no patterns", nil, "rancher/shell:v0.1.9", false},
+		{"blank patterns are ignored", []string{" "}, "rancher/shell:v0.1.9", false},
+	}
+
+	assert := assertlib.New(t)
+	for _, tc := range testCases {
+		filter, err := NewImageFilter(tc.patterns)
+		assert.NoError(err, tc.description)
+		assert.Equal(tc.expected, filter.Match(tc.image), tc.description)
+	}
+}
+
+func TestNewImageFilterInvalidRegex(t *testing.T) {
+	_, err := NewImageFilter([]string{"regex:rancher/(shell"})
+	assertlib.Error(t, err)
+}
+
+func TestImageListExclude(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet-windows-agent:v0.7.0", OS: Windows},
+		{Image: "rancher/shell:v0.1.20", OS: Linux},
+	}
+	filter, err := NewImageFilter([]string{"*-windows-*"})
+	assert.NoError(err)
+
+	kept, excluded := list.Exclude(filter)
+	assert.Equal(ImageList{{Image: "rancher/shell:v0.1.20", OS: Linux}}, kept)
+	assert.Equal(ImageList{{Image: "rancher/fleet-windows-agent:v0.7.0", OS: Windows}}, excluded)
+
+	kept, excluded = list.Exclude(nil)
+	assert.Equal(list, kept)
+	assert.Empty(excluded)
+}
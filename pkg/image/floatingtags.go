@@ -0,0 +1,68 @@
+package image
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FloatingTagsPolicy is the policy name of the violations of FloatingTags.
+const FloatingTagsPolicy = "floating-tags"
+
+// DefaultFloatingTags are the tags flagged by the exports not configuring their floating tags.
+var DefaultFloatingTags = []string{"latest", "stable"}
+
+// FloatingTags is a policy flagging the images with floating tags, e.g. latest, which make the mirrors of the images
+// non-reproducible since the images they tag change over time. Images pinned to a digest are not flagged, and images
+// without tag are flagged as latest images.
+type FloatingTags struct {
+	// Tags are the floating tags, or glob or regex: patterns of floating tags, e.g. *-nightly, see ImageFilter.
+	Tags []string
+	// Action is whether the images with floating tags fail the export or are only reported.
+	Action PolicyAction
+
+	filter *ImageFilter
+}
+
+// NewFloatingTags returns the policy flagging the images with tags, DefaultFloatingTags if empty.
+func NewFloatingTags(tags []string, action PolicyAction) (*FloatingTags, error) {
+	if len(tags) == 0 {
+		tags = DefaultFloatingTags
+	}
+	filter, err := NewImageFilter(tags)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid floating tag")
+	}
+	return &FloatingTags{Tags: tags, Action: action, filter: filter}, nil
+}
+
+// Check returns the entries of list whose tag is floating.
+func (f *FloatingTags) Check(list ImageList) PolicyViolations {
+	if f == nil {
+		return nil
+	}
+	var violations PolicyViolations
+	for _, entry := range list {
+		if strings.Contains(entry.Image, "@") {
+			continue
+		}
+		_, tag := splitImageTag(entry.Image)
+		if tag == "" {
+			tag = "latest"
+		}
+		if !f.filter.Match(tag) {
+			continue
+		}
+		violations = append(violations, PolicyViolation{
+			Policy:  FloatingTagsPolicy,
+			Image:   entry.Image,
+			OS:      entry.OS,
+			Sources: entry.Sources,
+			Rule:    tag,
+			Reason:  "floating tag, the image it tags may change",
+			Action:  f.Action,
+		})
+	}
+	sortPolicyViolations(violations)
+	return violations
+}
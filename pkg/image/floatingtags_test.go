@@ -0,0 +1,64 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestFloatingTagsCheck(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "busybox", OS: Linux, Sources: []string{"rancher-monitoring:102.0.0"}},
+		{Image: "rancher/shell:latest", OS: Windows},
+		{Image: "rancher/shell:latest@sha256:1111111111111111111111111111111111111111111111111111111111111111", OS: Linux},
+		{Image: "localhost:5000/rancher/fleet:stable", OS: Linux, Sources: []string{"fleet:102.2.0"}},
+		{Image: "rancher/kubectl:v1.28.0-nightly", OS: Linux},
+		{Image: "rancher/kubectl:v1.28.0", OS: Linux},
+	}
+	policy, err := NewFloatingTags(nil, PolicyActionWarn)
+	assert.NoError(err)
+	assert.Equal(DefaultFloatingTags, policy.Tags)
+	assert.Equal(PolicyViolations{
+		{
+			Policy:  FloatingTagsPolicy,
+			Image:   "busybox",
+			OS:      Linux,
+			Sources: []string{"rancher-monitoring:102.0.0"},
+			Rule:    "latest",
+			Reason:  "floating tag, the image it tags may change",
+			Action:  PolicyActionWarn,
+		},
+		{
+			Policy:  FloatingTagsPolicy,
+			Image:   "localhost:5000/rancher/fleet:stable",
+			OS:      Linux,
+			Sources: []string{"fleet:102.2.0"},
+			Rule:    "stable",
+			Reason:  "floating tag, the image it tags may change",
+			Action:  PolicyActionWarn,
+		},
+		{
+			Policy: FloatingTagsPolicy,
+			Image:  "rancher/shell:latest",
+			OS:     Windows,
+			Rule:   "latest",
+			Reason: "floating tag, the image it tags may change",
+			Action: PolicyActionWarn,
+		},
+	}, policy.Check(list))
+
+	policy, err = NewFloatingTags([]string{"*-nightly"}, PolicyActionFail)
+	assert.NoError(err)
+	violations := policy.Check(list)
+	if assert.Len(violations, 1) {
+		assert.Equal("rancher/kubectl:v1.28.0-nightly", violations[0].Image)
+		assert.Equal(PolicyActionFail, violations[0].Action)
+	}
+
+	_, err = NewFloatingTags([]string{"regex:("}, PolicyActionFail)
+	assert.Error(err)
+	var nilPolicy *FloatingTags
+	assert.Empty(nilPolicy.Check(list))
+}
@@ -0,0 +1,124 @@
+package image
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Format is a format an ImageList can be written in with ImageList.WriteImages.
+type Format string
+
+const (
+	// FormatText is the rancher-images.txt format, one image per line.
+	FormatText Format = "txt"
+	// FormatSources is the rancher-images-sources.txt format, one image per line followed by its comma separated
+	// sources.
+	FormatSources Format = "sources"
+	// FormatJSON is a JSON array of the entries of the list.
+	FormatJSON Format = "json"
+	// FormatCSV is CSV with a header row, so the image inventory can be imported into spreadsheets. Sources, charts
+	// and the upstream URLs of the charts are separated by semicolons. The digest and compressed size columns are empty
+	// unless the registries were looked up, and the arches column is empty for images exported for every architecture.
+	FormatCSV Format = "csv"
+)
+
+// Formats are the formats supported by ImageList.WriteImages.
+var Formats = []Format{FormatText, FormatSources, FormatJSON, FormatCSV}
+
+// ParseFormat returns the format called name.
+func ParseFormat(name string) (Format, error) {
+	for _, format := range Formats {
+		if string(format) == name {
+			return format, nil
+		}
+	}
+	return "", errors.Errorf("unknown image list format %q", name)
+}
+
+// csvHeader are the columns of FormatCSV.
+var csvHeader = []string{"image", "os", "sources", "charts", "chart_urls", "digest", "compressed_size", "arches"}
+
+// WriteImages writes the entries of the list to w in format. Entries are written one at a time, so very large lists
+// can be streamed to files or HTTP responses.
+func (l ImageList) WriteImages(w io.Writer, format Format) error {
+	switch format {
+	case FormatText, FormatSources:
+		bw := bufio.NewWriter(w)
+		for _, entry := range l {
+			bw.WriteString(entry.Image)
+			if format == FormatSources {
+				bw.WriteByte(' ')
+				bw.WriteString(strings.Join(entry.Sources, ","))
+			}
+			if err := bw.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	case FormatJSON:
+		bw := bufio.NewWriter(w)
+		bw.WriteString("[")
+		for i, entry := range l {
+			if i > 0 {
+				bw.WriteString(",")
+			}
+			bw.WriteString("\n  ")
+			out, err := json.MarshalIndent(entry, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			if _, err := bw.Write(out); err != nil {
+				return err
+			}
+		}
+		if len(l) > 0 {
+			bw.WriteString("\n")
+		}
+		bw.WriteString("]\n")
+		return bw.Flush()
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, entry := range l {
+			var size string
+			if entry.CompressedSize > 0 {
+				size = strconv.FormatInt(entry.CompressedSize, 10)
+			}
+			record := []string{
+				entry.Image,
+				entry.OS.String(),
+				strings.Join(entry.Sources, ";"),
+				strings.Join(entry.Charts, ";"),
+				strings.Join(entryChartURLs(entry), ";"),
+				entry.Digest,
+				size,
+				strings.Join(archNames(entry.Arches), ";"),
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return errors.Errorf("unknown image list format %q", format)
+}
+
+// entryChartURLs returns the sorted upstream URLs of all the charts of entry.
+func entryChartURLs(entry ImageEntry) []string {
+	urls := make(map[string]struct{})
+	for _, chartURLs := range entry.ChartURLs {
+		for _, url := range chartURLs {
+			urls[url] = struct{}{}
+		}
+	}
+	return sortedKeys(urls)
+}
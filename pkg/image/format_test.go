@@ -0,0 +1,56 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestWriteImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet:v0.7.0", Sources: []string{"fleet:102.1.0", "system"}, OS: Linux, Charts: []string{"fleet:102.1.0"}, ChartURLs: map[string][]string{"fleet:102.1.0": {"https://fleet.rancher.io/", "https://github.com/rancher/fleet"}}, Digest: "sha256:abc", CompressedSize: 1024},
+		{Image: "rancher/wins:v0.4.12", Sources: []string{"system"}, OS: Windows, Arches: []Arch{AMD64}},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(list.WriteImages(&buf, FormatText))
+	assert.Equal("rancher/fleet:v0.7.0\nrancher/wins:v0.4.12\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(list.WriteImages(&buf, FormatSources))
+	assert.Equal("rancher/fleet:v0.7.0 fleet:102.1.0,system\nrancher/wins:v0.4.12 system\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(list.WriteImages(&buf, FormatCSV))
+	assert.Equal(`image,os,sources,charts,chart_urls,digest,compressed_size,arches
+rancher/fleet:v0.7.0,linux,fleet:102.1.0;system,fleet:102.1.0,https://fleet.rancher.io/;https://github.com/rancher/fleet,sha256:abc,1024,
+rancher/wins:v0.4.12,windows,system,,,,,amd64
+`, buf.String())
+
+	buf.Reset()
+	assert.NoError(list.WriteImages(&buf, FormatJSON))
+	var decoded ImageList
+	assert.NoError(json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(list, decoded)
+
+	buf.Reset()
+	assert.NoError(ImageList{}.WriteImages(&buf, FormatJSON))
+	assert.Equal("[]\n", buf.String())
+
+	assert.Error(list.WriteImages(&buf, "xml"))
+}
+
+func TestParseFormat(t *testing.T) {
+	assert := assertlib.New(t)
+
+	format, err := ParseFormat("sources")
+	assert.NoError(err)
+	assert.Equal(FormatSources, format)
+
+	_, err = ParseFormat("xml")
+	assert.EqualError(err, `unknown image list format "xml"`)
+}
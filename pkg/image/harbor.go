@@ -0,0 +1,147 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+// HarborReplicationPolicy is the payload of the Harbor API creating a pull-based replication policy, i.e. of
+// POST /api/v2.0/replication/policies.
+type HarborReplicationPolicy struct {
+	Name          string                  `json:"name"`
+	Description   string                  `json:"description,omitempty"`
+	SrcRegistry   HarborRegistryRef       `json:"src_registry"`
+	DestNamespace string                  `json:"dest_namespace,omitempty"`
+	Filters       []HarborReplicationRule `json:"filters"`
+	Trigger       HarborTrigger           `json:"trigger"`
+	Override      bool                    `json:"override"`
+	Enabled       bool                    `json:"enabled"`
+}
+
+// HarborRegistryRef references a registry endpoint configured in Harbor.
+type HarborRegistryRef struct {
+	ID int64 `json:"id"`
+}
+
+// HarborReplicationRule is a filter of the resources a Harbor replication policy replicates.
+type HarborReplicationRule struct {
+	// Type is the type of the filter, name or tag.
+	Type string `json:"type"`
+	// Value is the pattern matching the repository names or tags to replicate.
+	Value string `json:"value"`
+}
+
+// HarborTrigger is the trigger of a Harbor replication policy.
+type HarborTrigger struct {
+	Type string `json:"type"`
+}
+
+// HarborReplicationPolicies returns a manual replication policy per repository of the images of list, filtering the
+// tags of the repository found in list. registryIDs are the IDs of the Harbor registry endpoints of the registries of
+// the images, keyed by registry, e.g. docker.io. destNamespace is the Harbor project to replicate the images to, the
+// namespace of each repository is kept if it is empty.
+func HarborReplicationPolicies(list ImageList, registryIDs map[string]int64, destNamespace string) ([]HarborReplicationPolicy, error) {
+	type repository struct {
+		domain string
+		path   string
+	}
+	tagsByRepository := make(map[repository]map[string]bool)
+	for _, entry := range list {
+		named, err := reference.ParseNormalizedNamed(entry.Image)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse image %s", entry.Image)
+		}
+		tag := "latest"
+		switch ref := named.(type) {
+		case reference.Digested:
+			// Harbor filters tags only, so images pinned by digest are replicated with all their tags
+			tag = "**"
+		case reference.Tagged:
+			tag = ref.Tag()
+		}
+		repo := repository{domain: reference.Domain(named), path: reference.Path(named)}
+		if tagsByRepository[repo] == nil {
+			tagsByRepository[repo] = make(map[string]bool)
+		}
+		tagsByRepository[repo][tag] = true
+	}
+
+	policies := make([]HarborReplicationPolicy, 0, len(tagsByRepository))
+	for repo, tagSet := range tagsByRepository {
+		registryID, ok := registryIDs[repo.domain]
+		if !ok {
+			return nil, errors.Errorf("no Harbor registry ID for the registry %s of %s", repo.domain, repo.path)
+		}
+		tags := make([]string, 0, len(tagSet))
+		for tag := range tagSet {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		tagFilter := tags[0]
+		if len(tags) > 1 {
+			tagFilter = "{" + strings.Join(tags, ",") + "}"
+		}
+
+		policies = append(policies, HarborReplicationPolicy{
+			Name:          harborPolicyName(repo.domain, repo.path),
+			Description:   "Replicates the Rancher images of " + repo.domain + "/" + repo.path,
+			SrcRegistry:   HarborRegistryRef{ID: registryID},
+			DestNamespace: destNamespace,
+			Filters: []HarborReplicationRule{
+				{Type: "name", Value: repo.path},
+				{Type: "tag", Value: tagFilter},
+			},
+			Trigger:  HarborTrigger{Type: "manual"},
+			Override: true,
+			Enabled:  true,
+		})
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Name < policies[j].Name
+	})
+	return policies, nil
+}
+
+// harborPolicyName returns the name of the replication policy of a repository, which must be unique in Harbor.
+func harborPolicyName(domain, path string) string {
+	name := path
+	if domain != dockerHubDomain {
+		name = domain + "/" + path
+	}
+	return "rancher-" + strings.NewReplacer("/", "-", ".", "-", ":", "-").Replace(name)
+}
+
+// WriteHarborReplicationPolicies writes the replication policies of the images of list to w as an indented JSON
+// array, see HarborReplicationPolicies.
+func WriteHarborReplicationPolicies(w io.Writer, list ImageList, registryIDs map[string]int64, destNamespace string) error {
+	policies, err := HarborReplicationPolicies(list, registryIDs, destNamespace)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(policies)
+}
+
+// ParseHarborRegistryIDs parses the IDs of Harbor registry endpoints given as REGISTRY=ID, e.g. docker.io=1.
+func ParseHarborRegistryIDs(values []string) (map[string]int64, error) {
+	registryIDs := make(map[string]int64, len(values))
+	for _, value := range values {
+		registry, idValue, ok := strings.Cut(value, "=")
+		if !ok || registry == "" {
+			return nil, errors.Errorf("invalid Harbor registry %q, must be REGISTRY=ID", value)
+		}
+		id, err := strconv.ParseInt(idValue, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid Harbor registry ID %q", idValue)
+		}
+		registryIDs[registry] = id
+	}
+	return registryIDs, nil
+}
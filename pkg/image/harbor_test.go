@@ -0,0 +1,46 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestHarborReplicationPolicies(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/shell:v0.1.21", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Windows},
+		{Image: "quay.io/skopeo/stable:v1", OS: Linux},
+	}
+
+	policies, err := HarborReplicationPolicies(list, map[string]int64{"docker.io": 1, "quay.io": 2}, "mirror")
+	assert.NoError(err)
+	if assert.Len(policies, 2) {
+		assert.Equal("rancher-quay-io-skopeo-stable", policies[0].Name)
+		assert.Equal(HarborRegistryRef{ID: 2}, policies[0].SrcRegistry)
+		assert.Equal([]HarborReplicationRule{{Type: "name", Value: "skopeo/stable"}, {Type: "tag", Value: "v1"}}, policies[0].Filters)
+
+		assert.Equal("rancher-rancher-shell", policies[1].Name)
+		assert.Equal("mirror", policies[1].DestNamespace)
+		assert.Equal([]HarborReplicationRule{{Type: "name", Value: "rancher/shell"}, {Type: "tag", Value: "{v0.1.21,v0.1.22}"}}, policies[1].Filters)
+	}
+
+	_, err = HarborReplicationPolicies(list, map[string]int64{"docker.io": 1}, "")
+	assert.EqualError(err, "no Harbor registry ID for the registry quay.io of skopeo/stable")
+}
+
+func TestParseHarborRegistryIDs(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registryIDs, err := ParseHarborRegistryIDs([]string{"docker.io=1", "quay.io=2"})
+	assert.NoError(err)
+	assert.Equal(map[string]int64{"docker.io": 1, "quay.io": 2}, registryIDs)
+
+	_, err = ParseHarborRegistryIDs([]string{"docker.io"})
+	assert.Error(err)
+	_, err = ParseHarborRegistryIDs([]string{"docker.io=one"})
+	assert.Error(err)
+}
@@ -0,0 +1,84 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// hostedProviderCharts are the charts of the operators Rancher installs to manage EKS, AKS and GKE clusters, along
+// with their CRD charts.
+var hostedProviderCharts = map[string]struct{}{
+	"rancher-aks-operator":     {},
+	"rancher-aks-operator-crd": {},
+	"rancher-eks-operator":     {},
+	"rancher-eks-operator-crd": {},
+	"rancher-gke-operator":     {},
+	"rancher-gke-operator-crd": {},
+}
+
+// HostedProviderCharts provides the images of the charts of the EKS, AKS and GKE operators, which Rancher installs to
+// manage hosted clusters. Rancher installs the version of the operator charts matching its own version, so unlike the
+// other charts, of which only the latest version is scanned, the versions of the operator charts that are scanned are
+// those whose Rancher version constraint annotation the Rancher version satisfies, or the latest version if none does.
+type HostedProviderCharts struct {
+	Config ExportConfig
+}
+
+func (h HostedProviderCharts) Name() string {
+	return "hosted providers"
+}
+
+func (h HostedProviderCharts) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
+	return fetchVersionMatchedCharts(ctx, h.Config, hostedProviderCharts, imagesSet)
+}
+
+// fetchVersionMatchedCharts adds the images of the versions of the charts called chartNames, in the charts repository
+// of config, whose Rancher version constraint annotation the Rancher version satisfies, or of their latest version if
+// none does, to imagesSet. It is used for the charts Rancher installs in the version matching its own version.
+func fetchVersionMatchedCharts(ctx context.Context, config ExportConfig, chartNames map[string]struct{}, imagesSet *ImageSet) error {
+	if config.ChartsPath == "" || config.RancherVersion == "" {
+		return nil
+	}
+	index, err := repo.LoadIndexFile(filepath.Join(config.ChartsPath, "index.yaml"))
+	if err != nil {
+		return err
+	}
+	charts := Charts{config}
+	chartErrs := chartErrorCollector{strict: config.Strict}
+	var filteredVersions repo.ChartVersions
+	for _, versions := range index.Entries {
+		if len(versions) == 0 {
+			continue
+		}
+		chartName := versions[0].Metadata.Name
+		if _, ok := chartNames[chartName]; !ok || config.chartDisabled(chartName, false) {
+			continue
+		}
+		var matching repo.ChartVersions
+		for _, version := range versions {
+			isConstraintSatisfied, err := charts.checkChartVersionConstraint(ctx, *version)
+			if err != nil {
+				chartNameAndVersion := fmt.Sprintf("%s:%s", version.Name, version.Version)
+				if err := chartErrs.add(chartNameAndVersion, "index.yaml", errors.Wrapf(err, "failed to check constraint of chart")); err != nil {
+					return err
+				}
+				continue
+			}
+			if isConstraintSatisfied {
+				matching = append(matching, version)
+			}
+		}
+		if len(matching) == 0 {
+			matching = versions[:1]
+		}
+		filteredVersions = append(filteredVersions, matching...)
+	}
+	if err := scanChartVersions(ctx, config, filteredVersions, imagesSet, &chartErrs); err != nil {
+		return err
+	}
+	return chartErrs.err()
+}
@@ -0,0 +1,67 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+const hostedProviderChartsIndex = `apiVersion: v1
+entries:
+  rancher-eks-operator:
+  - name: rancher-eks-operator
+    version: 104.0.0
+    annotations:
+      catalog.cattle.io/rancher-version: '>= 2.9.0-0 < 2.10.0-0'
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+  - name: rancher-eks-operator
+    version: 103.1.0
+    annotations:
+      catalog.cattle.io/rancher-version: '>= 2.8.0-0 < 2.9.0-0'
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+  - name: rancher-eks-operator
+    version: 103.0.0
+    annotations:
+      catalog.cattle.io/rancher-version: '>= 2.8.0-0 < 2.9.0-0'
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+  rancher-monitoring:
+  - name: rancher-monitoring
+    version: 103.0.0
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+`
+
+func TestHostedProviderChartsFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	// The charts point to a broken tarball, so the charts that are scanned are reported as chart errors
+	chartsPath := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "index.yaml"), []byte(hostedProviderChartsIndex), 0644))
+	assert.NoError(os.MkdirAll(filepath.Join(chartsPath, "assets", "broken"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "assets", "broken", "broken-1.0.0.tgz"), []byte("not a tarball"), 0644))
+
+	scannedCharts := func(source ImageSource) []string {
+		var chartErrs ChartErrors
+		assert.ErrorAs(source.FetchImages(context.Background(), NewImageSet(Linux)), &chartErrs)
+		var charts []string
+		for _, chartErr := range chartErrs {
+			charts = append(charts, chartErr.Chart)
+		}
+		sort.Strings(charts)
+		return charts
+	}
+	config := ExportConfig{ChartsPath: chartsPath, RancherVersion: "2.8.2"}
+	assert.Equal([]string{"rancher-eks-operator:103.0.0", "rancher-eks-operator:103.1.0"}, scannedCharts(HostedProviderCharts{config}))
+	// The operator charts are only scanned by HostedProviderCharts
+	assert.Equal([]string{"rancher-monitoring:103.0.0"}, scannedCharts(Charts{config}))
+	// The latest version is scanned if the Rancher version matches none of them
+	config.RancherVersion = "2.7.0"
+	assert.Equal([]string{"rancher-eks-operator:104.0.0"}, scannedCharts(HostedProviderCharts{config}))
+}
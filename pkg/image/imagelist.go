@@ -0,0 +1,302 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ImageEntry describes a single image required by Rancher.
+type ImageEntry struct {
+	// Image is the full image reference, e.g. rancher/rancher-agent:v2.7.5.
+	Image string `json:"image"`
+	// Sources are the labels of everything that references the image, such as "system" or a chart name and version.
+	Sources []string `json:"sources,omitempty"`
+	// OS is the operating system the image is exported for.
+	OS OSType `json:"os"`
+	// Arches are the architectures the chart values referencing the image restrict it to, e.g. amd64 for images not
+	// published for arm64. The image is exported for every architecture if empty.
+	Arches []Arch `json:"arches,omitempty"`
+	// Charts are the charts, in name:version format, whose values reference the image.
+	Charts []string `json:"charts,omitempty"`
+	// ValuesPaths are the key paths of the chart values that produced the image, e.g. fluentd.image, keyed by chart
+	// name and version.
+	ValuesPaths map[string][]string `json:"valuesPaths,omitempty"`
+	// ChartURLs are the upstream project URLs of the charts of the image, read from the home and sources of their
+	// Chart.yaml, keyed by chart name and version.
+	ChartURLs map[string][]string `json:"chartUrls,omitempty"`
+	// RancherVersions are the Rancher versions requiring the image. It is only set on lists built with
+	// SupersetImageList.
+	RancherVersions []string `json:"rancherVersions,omitempty"`
+	// Optional is true if the image is only needed when optional charts, such as monitoring or istio, or UI extensions
+	// are installed. Other images are required to run Rancher and provision clusters.
+	Optional bool `json:"optional"`
+	// Digest is the digest of the image manifest in its registry. It is only set when the registry was looked up, see
+	// RegistryClient.LookupImages.
+	Digest string `json:"digest,omitempty"`
+	// CompressedSize is the total size of the compressed layers of the image, in bytes. It is only set when the
+	// registry was looked up.
+	CompressedSize int64 `json:"compressedSize,omitempty"`
+	// PlatformDigests are the digests of the manifests of the image for the requested platforms. They are only set
+	// when they were looked up, see RegistryClient.LookupPlatformDigests.
+	PlatformDigests []PlatformDigest `json:"platformDigests,omitempty"`
+}
+
+// ImageList is the result of an image export, sorted by image.
+type ImageList []ImageEntry
+
+// Images returns the image references of the list in the legacy rancher-images.txt format. Use WriteImages to write
+// large lists without building the slice.
+func (l ImageList) Images() []string {
+	images := make([]string, 0, len(l))
+	for _, entry := range l {
+		images = append(images, entry.Image)
+	}
+	return images
+}
+
+// ImagesAndSources returns the entries of the list in the legacy rancher-images-sources.txt
+// format, where each line is an image followed by its comma separated sources.
+func (l ImageList) ImagesAndSources() []string {
+	imagesAndSources := make([]string, 0, len(l))
+	for _, entry := range l {
+		imagesAndSources = append(imagesAndSources, fmt.Sprintf("%s %s", entry.Image, strings.Join(entry.Sources, ",")))
+	}
+	return imagesAndSources
+}
+
+// ForOS returns the entries of the list exported for osType.
+func (l ImageList) ForOS(osType OSType) ImageList {
+	var list ImageList
+	for _, entry := range l {
+		if entry.OS == osType {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// ForArch returns the entries of the list exported for arch, i.e. the entries restricted to arch along with the
+// entries that are not restricted to any architecture, for the OS types published for arch, see OSType.Arches.
+func (l ImageList) ForArch(arch Arch) ImageList {
+	return l.ForArches([]Arch{arch})
+}
+
+// ForArches returns the entries of the list exported for at least one of arches, see ForArch. The list is returned
+// as is if arches is empty.
+func (l ImageList) ForArches(arches []Arch) ImageList {
+	if len(arches) == 0 {
+		return l
+	}
+	var list ImageList
+	for _, entry := range l {
+		for _, arch := range arches {
+			if hasArch(entry.OS.Arches(), arch) && hasArch(entry.Arches, arch) {
+				list = append(list, entry)
+				break
+			}
+		}
+	}
+	return list
+}
+
+// ByRegistry splits the list by the registry of its images, e.g. docker.io or quay.io, see ImageRegistry.
+func (l ImageList) ByRegistry() map[string]ImageList {
+	lists := make(map[string]ImageList)
+	for _, entry := range l {
+		registry := ImageRegistry(entry.Image)
+		lists[registry] = append(lists[registry], entry)
+	}
+	return lists
+}
+
+// ImageRegistry returns the registry of image. Like docker, the first component of the image is its registry if it
+// contains a "." or a ":" or is localhost, and the image is from docker.io otherwise.
+func ImageRegistry(image string) string {
+	first, _, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return dockerHubDomain
+}
+
+// SourceCategory is a category of image sources, for pipelines that sync each category with its own cadence.
+type SourceCategory string
+
+const (
+	// SourceCategorySystem are the Kubernetes system images of RKE and RKE2 clusters.
+	SourceCategorySystem SourceCategory = "system"
+	// SourceCategoryCharts are the images of charts.
+	SourceCategoryCharts SourceCategory = "charts"
+	// SourceCategoryK3sUpgrade are the images of k3s clusters, including the images upgrading them.
+	SourceCategoryK3sUpgrade SourceCategory = "k3sUpgrade"
+	// SourceCategoryRequirements are the images Rancher needs to run, including the Rancher images themselves.
+	SourceCategoryRequirements SourceCategory = "requirements"
+	// SourceCategoryOther are the images of the other sources, e.g. UI extensions or extra images.
+	SourceCategoryOther SourceCategory = "other"
+)
+
+// sourceCategories are the categories of the sources that are not charts.
+var sourceCategories = map[string]SourceCategory{
+	"system":       SourceCategorySystem,
+	"rke2All":      SourceCategorySystem,
+	"rke2":         SourceCategorySystem,
+	"k3sUpgrade":   SourceCategoryK3sUpgrade,
+	"k3s":          SourceCategoryK3sUpgrade,
+	"core":         SourceCategoryRequirements,
+	"rancher":      SourceCategoryRequirements,
+	"provisioning": SourceCategoryRequirements,
+}
+
+// BySourceCategory splits the list by the categories of the sources of its images. An image with sources of several
+// categories is part of each of them.
+func (l ImageList) BySourceCategory() map[SourceCategory]ImageList {
+	lists := make(map[SourceCategory]ImageList)
+	for _, entry := range l {
+		charts := make(map[string]bool, len(entry.Charts))
+		for _, chart := range entry.Charts {
+			charts[chart] = true
+		}
+		categories := make(map[SourceCategory]bool)
+		if len(entry.Charts) > 0 {
+			categories[SourceCategoryCharts] = true
+		}
+		for _, source := range entry.Sources {
+			if charts[source] {
+				continue
+			}
+			categories[sourceCategory(source)] = true
+		}
+		if len(categories) == 0 {
+			categories[SourceCategoryOther] = true
+		}
+		for category := range categories {
+			lists[category] = append(lists[category], entry)
+		}
+	}
+	return lists
+}
+
+// sourceCategory returns the category of source, which is not a chart. The sources of the images of a release, e.g.
+// rke2:v1.27.10+rke2r1, are categorized by the name before their version.
+func sourceCategory(source string) SourceCategory {
+	if category, ok := sourceCategories[source]; ok {
+		return category
+	}
+	if name, _, ok := strings.Cut(source, ":"); ok {
+		if category, ok := sourceCategories[name]; ok {
+			return category
+		}
+	}
+	return SourceCategoryOther
+}
+
+// SupersetImageList merges the image lists of several Rancher versions into a single list containing the union of
+// their images. Each entry records which of the Rancher versions require it, along with the combined sources,
+// charts, values paths and chart URLs of all versions. An entry is only optional if it is optional in every version,
+// and only restricted to some architectures if it is restricted in every version.
+// The result is sorted by OS and then by image.
+func SupersetImageList(listsByVersion map[string]ImageList) ImageList {
+	type entryKey struct {
+		os    OSType
+		image string
+	}
+	type mergedEntry struct {
+		sources     map[string]struct{}
+		charts      map[string]struct{}
+		valuesPaths valuesPathSet
+		chartURLs   map[string][]string
+		versions    map[string]struct{}
+		optional    bool
+		anyArch     bool
+		arches      archSet
+	}
+	merged := make(map[entryKey]*mergedEntry)
+	for version, list := range listsByVersion {
+		for _, entry := range list {
+			key := entryKey{os: entry.OS, image: entry.Image}
+			m, ok := merged[key]
+			if !ok {
+				m = &mergedEntry{
+					sources:     make(map[string]struct{}),
+					charts:      make(map[string]struct{}),
+					valuesPaths: make(valuesPathSet),
+					chartURLs:   make(map[string][]string),
+					versions:    make(map[string]struct{}),
+					optional:    true,
+				}
+				merged[key] = m
+			}
+			for _, source := range entry.Sources {
+				m.sources[source] = struct{}{}
+			}
+			for _, chart := range entry.Charts {
+				m.charts[chart] = struct{}{}
+			}
+			for chart, paths := range entry.ValuesPaths {
+				for _, valuesPath := range paths {
+					m.valuesPaths.add(chart, valuesPath)
+				}
+			}
+			for chart, urls := range entry.ChartURLs {
+				m.chartURLs[chart] = urls
+			}
+			m.versions[version] = struct{}{}
+			m.optional = m.optional && entry.Optional
+			m.anyArch = m.anyArch || len(entry.Arches) == 0
+			for _, arch := range entry.Arches {
+				m.arches = m.arches.add(arch)
+			}
+		}
+	}
+
+	list := make(ImageList, 0, len(merged))
+	for key, m := range merged {
+		list = append(list, ImageEntry{
+			Image:           key.image,
+			Sources:         sortedKeys(m.sources),
+			OS:              key.os,
+			Arches:          m.arches.restricted(m.anyArch),
+			Charts:          sortedKeys(m.charts),
+			ValuesPaths:     m.valuesPaths.sorted(),
+			ChartURLs:       chartURLsOrNil(m.chartURLs),
+			RancherVersions: sortedKeys(m.versions),
+			Optional:        m.optional,
+		})
+	}
+	sortImageList(list)
+	return list
+}
+
+func chartURLsOrNil(chartURLs map[string][]string) map[string][]string {
+	if len(chartURLs) == 0 {
+		return nil
+	}
+	return chartURLs
+}
+
+// ReadImageList reads an image list for osType in the rancher-images.txt or rancher-images-sources.txt format, where
+// each line is an image optionally followed by its comma separated sources. Empty lines and lines starting with "#"
+// are ignored. The returned list is sorted by image.
+func ReadImageList(r io.Reader, osType OSType) (ImageList, error) {
+	var list ImageList
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		entry := ImageEntry{Image: fields[0], OS: osType}
+		if len(fields) > 1 {
+			entry.Sources = strings.Split(fields[1], ",")
+		}
+		list = append(list, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sortImageList(list)
+	return list, nil
+}
@@ -0,0 +1,259 @@
+package image
+
+import (
+	"fmt"
+	"testing"
+	"unsafe"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestImageSetList(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Linux)
+	imagesSet.Add(Linux, "rancher/shell:v0.1.20", "core")
+	imagesSet.AddChartImage(Linux, "rancher/fleet:v0.7.0", "fleet:102.1.0", "")
+	imagesSet.AddChartImage(Linux, "rancher/fleet:v0.7.0", "fleet:102.0.0", "")
+	imagesSet.Add(Linux, "rancher/fleet:v0.7.0", "rancher")
+	imagesSet.Add(Linux, "", "core")
+
+	expected := ImageList{
+		{
+			Image:   "rancher/fleet:v0.7.0",
+			Sources: []string{"fleet:102.0.0", "fleet:102.1.0", "rancher"},
+			OS:      Linux,
+			Charts:  []string{"fleet:102.0.0", "fleet:102.1.0"},
+		},
+		{
+			Image:   "rancher/shell:v0.1.20",
+			Sources: []string{"core"},
+			OS:      Linux,
+		},
+	}
+	list := imagesSet.List(Linux)
+	assert.Equal(expected, list)
+	assert.Equal([]string{"rancher/fleet:v0.7.0", "rancher/shell:v0.1.20"}, list.Images())
+	assert.Equal([]string{
+		"rancher/fleet:v0.7.0 fleet:102.0.0,fleet:102.1.0,rancher",
+		"rancher/shell:v0.1.20 core",
+	}, list.ImagesAndSources())
+}
+
+func TestImageSetRename(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Windows)
+	imagesSet.AddChartImage(Windows, "quay.io/coreos/flannel:v1.2.3", "flannel:0.1.0", "")
+	imagesSet.Add(Windows, "rancher/coreos-flannel:v1.2.3", "system")
+	imagesSet.Rename("quay.io/coreos/flannel:v1.2.3", "rancher/coreos-flannel:v1.2.3")
+
+	assert.False(imagesSet.Has(Windows, "quay.io/coreos/flannel:v1.2.3"))
+	assert.Equal(ImageList{
+		{
+			Image:   "rancher/coreos-flannel:v1.2.3",
+			Sources: []string{"flannel:0.1.0", "system"},
+			OS:      Windows,
+			Charts:  []string{"flannel:0.1.0"},
+		},
+	}, imagesSet.List(Windows))
+}
+
+func TestImageSetSortsAddedImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Linux)
+	imagesSet.Add(Linux, "rancher/b:v1", "test")
+	imagesSet.Add(Linux, "rancher/d:v1", "test")
+	assert.Equal([]string{"rancher/b:v1", "rancher/d:v1"}, imagesSet.Images(Linux))
+
+	// Images added after the set was listed are merged into the sorted images
+	imagesSet.Add(Linux, "rancher/c:v1", "test")
+	imagesSet.Add(Linux, "rancher/a:v1", "test")
+	imagesSet.Add(Linux, "rancher/e:v1", "test")
+	assert.Equal([]string{"rancher/a:v1", "rancher/b:v1", "rancher/c:v1", "rancher/d:v1", "rancher/e:v1"}, imagesSet.Images(Linux))
+
+	// Renamed images are dropped, and listed once if they are added again
+	imagesSet.Rename("rancher/b:v1", "rancher/f:v1")
+	imagesSet.Add(Linux, "rancher/c:v1", "other")
+	imagesSet.Rename("rancher/c:v1", "rancher/b:v1")
+	assert.Equal([]string{"rancher/a:v1", "rancher/b:v1", "rancher/d:v1", "rancher/e:v1", "rancher/f:v1"}, imagesSet.Images(Linux))
+	assert.Equal(5, imagesSet.Len(Linux))
+	assert.Equal([]string{"other", "test"}, imagesSet.Sources(Linux, "rancher/b:v1"))
+
+	imagesSet.Rename("rancher/a:v1", "rancher/g:v1")
+	imagesSet.Add(Linux, "rancher/a:v1", "test")
+	assert.Equal([]string{"rancher/a:v1", "rancher/b:v1", "rancher/d:v1", "rancher/e:v1", "rancher/f:v1", "rancher/g:v1"}, imagesSet.Images(Linux))
+	assert.False(imagesSet.Has(Windows, "rancher/a:v1"))
+	assert.Empty(imagesSet.Images(Windows))
+	assert.Empty(imagesSet.List(Windows))
+}
+
+func TestImageSetListSources(t *testing.T) {
+	imagesSet := NewImageSet(Windows)
+	imagesSet.AddForArches(Windows, "rancher/wins:v1", "system", AMD64)
+
+	// Appending to the listed sources leaves the set untouched
+	list := imagesSet.List(Windows)
+	_ = append(list[0].Sources, "other")
+	assertlib.Equal(t, []string{"system"}, imagesSet.Sources(Windows, "rancher/wins:v1"))
+}
+
+func TestImageSetInternsStrings(t *testing.T) {
+	imagesSet := NewImageSet(Linux, Windows)
+	for i := 0; i < 2; i++ {
+		// Each call builds the strings anew, like the chart scans do
+		imagesSet.AddChartImage(Linux, fmt.Sprintf("rancher/image%d:v1", i), fmt.Sprintf("chart:%d.0.0", 1), "image")
+		imagesSet.AddChartImage(Windows, fmt.Sprintf("rancher/image%d:v1", 0), fmt.Sprintf("chart:%d.0.0", 1), "image")
+	}
+	list := imagesSet.ListAll()
+	assertlib.Len(t, list, 3)
+	for _, entry := range list[1:] {
+		assertlib.Equal(t, unsafe.StringData(list[0].Sources[0]), unsafe.StringData(entry.Sources[0]))
+	}
+	assertlib.Equal(t, unsafe.StringData(list[0].Image), unsafe.StringData(list[2].Image))
+}
+
+func TestImageSetMultipleOSTypes(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Windows, Linux)
+	err := pickImagesFromValuesMap(imagesSet, map[interface{}]interface{}{
+		"linuxOnly": map[interface{}]interface{}{
+			"repository": "rancher/linux",
+			"tag":        "v1",
+		},
+		"both": map[interface{}]interface{}{
+			"repository": "rancher/both",
+			"tag":        "v1",
+			"os":         "windows,linux",
+		},
+		"windowsOnly": map[interface{}]interface{}{
+			"repository": "rancher/windows",
+			"tag":        "v1",
+			"os":         "windows",
+		},
+	}, "chart:0.1.0", "", nil, nil)
+	assert.NoError(err)
+
+	list := imagesSet.ListAll()
+	assert.Equal([]string{"rancher/both:v1", "rancher/linux:v1", "rancher/both:v1", "rancher/windows:v1"}, list.Images())
+	assert.Equal([]string{"rancher/both:v1", "rancher/linux:v1"}, list.ForOS(Linux).Images())
+	assert.Equal([]string{"rancher/both:v1", "rancher/windows:v1"}, list.ForOS(Windows).Images())
+}
+
+func TestImageSetIgnoresUntrackedOSTypes(t *testing.T) {
+	imagesSet := NewImageSet(Linux)
+	imagesSet.Add(Windows, "rancher/windows:v1", "test")
+	assertlib.Empty(t, imagesSet.ListAll())
+}
+
+func TestImageSetArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Linux)
+	imagesSet.AddChartImageForArches(Linux, "rancher/amd64-only:v1", "chart:1.0.0", "image", AMD64)
+	imagesSet.AddChartImageForArches(Linux, "rancher/both:v1", "chart:1.0.0", "amd64.image", AMD64)
+	imagesSet.AddChartImageForArches(Linux, "rancher/both:v1", "chart:1.0.0", "arm64.image", ARM64)
+	imagesSet.AddChartImageForArches(Linux, "rancher/shared:v1", "chart:1.0.0", "image", AMD64)
+	imagesSet.Add(Linux, "rancher/shared:v1", "system")
+	imagesSet.Copy("rancher/amd64-only:v1", "registry.example.com/rancher/amd64-only:v1")
+
+	assert.Equal([]Arch{AMD64}, imagesSet.Arches(Linux, "rancher/amd64-only:v1"))
+	assert.Equal([]Arch{AMD64}, imagesSet.Arches(Linux, "registry.example.com/rancher/amd64-only:v1"))
+	assert.Equal([]Arch{AMD64, ARM64}, imagesSet.Arches(Linux, "rancher/both:v1"))
+	assert.Nil(imagesSet.Arches(Linux, "rancher/shared:v1"))
+	assert.Equal([]string{"rancher/both:v1", "rancher/shared:v1"}, imagesSet.ImagesForArch(Linux, ARM64))
+
+	list := imagesSet.List(Linux)
+	assert.Equal([]string{"rancher/both:v1", "rancher/shared:v1"}, list.ForArch(ARM64).Images())
+	assert.Len(list.ForArch(AMD64), 4)
+}
+
+func TestSupersetImageList(t *testing.T) {
+	assert := assertlib.New(t)
+
+	superset := SupersetImageList(map[string]ImageList{
+		"2.6.9": {
+			{Image: "rancher/fleet:v0.5.0", Sources: []string{"rancher"}, OS: Linux},
+			{Image: "rancher/shell:v0.1.18", Sources: []string{"core"}, OS: Linux},
+			{Image: "rancher/shell:v0.1.18", Sources: []string{"core"}, OS: Windows},
+			{Image: "rancher/webhook:v0.3.0", Sources: []string{"rancher-webhook:2.0.0"}, OS: Linux, Arches: []Arch{AMD64}},
+		},
+		"2.7.1": {
+			{Image: "rancher/fleet:v0.6.0", Sources: []string{"rancher"}, OS: Linux},
+			{Image: "rancher/shell:v0.1.18", Sources: []string{"core", "rancher-monitoring:101.0.0"}, OS: Linux, Charts: []string{"rancher-monitoring:101.0.0"}},
+			{Image: "rancher/webhook:v0.3.0", Sources: []string{"rancher-webhook:2.1.0"}, OS: Linux, Arches: []Arch{ARM64}},
+		},
+	})
+
+	assert.Equal(ImageList{
+		{Image: "rancher/fleet:v0.5.0", Sources: []string{"rancher"}, OS: Linux, RancherVersions: []string{"2.6.9"}},
+		{Image: "rancher/fleet:v0.6.0", Sources: []string{"rancher"}, OS: Linux, RancherVersions: []string{"2.7.1"}},
+		{
+			Image:           "rancher/shell:v0.1.18",
+			Sources:         []string{"core", "rancher-monitoring:101.0.0"},
+			OS:              Linux,
+			Charts:          []string{"rancher-monitoring:101.0.0"},
+			RancherVersions: []string{"2.6.9", "2.7.1"},
+		},
+		{Image: "rancher/webhook:v0.3.0", Sources: []string{"rancher-webhook:2.0.0", "rancher-webhook:2.1.0"}, OS: Linux, Arches: []Arch{AMD64, ARM64}, RancherVersions: []string{"2.6.9", "2.7.1"}},
+		{Image: "rancher/shell:v0.1.18", Sources: []string{"core"}, OS: Windows, RancherVersions: []string{"2.6.9"}},
+	}, superset)
+}
+
+func TestImageListByRegistry(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "busybox:1.36", OS: Linux},
+		{Image: "quay.io/skopeo/stable:v1", OS: Linux},
+		{Image: "localhost/test:v1", OS: Linux},
+		{Image: "registry.example.com:5000/rancher/shell:v0.1.22", OS: Windows},
+	}
+
+	assert.Equal(map[string]ImageList{
+		"docker.io":                 {list[0], list[1]},
+		"quay.io":                   {list[2]},
+		"localhost":                 {list[3]},
+		"registry.example.com:5000": {list[4]},
+	}, list.ByRegistry())
+}
+
+func TestImageListBySourceCategory(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet:v0.7.0", OS: Linux, Sources: []string{"fleet:102.1.0", "system"}, Charts: []string{"fleet:102.1.0"}},
+		{Image: "rancher/k3s-upgrade:v1.26.4-k3s1", OS: Linux, Sources: []string{"k3s:v1.26.4+k3s1"}},
+		{Image: "rancher/rancher:v2.8.0", OS: Linux, Sources: []string{"rancher"}},
+		{Image: "rancher/rke2-upgrade:v1.27.10-rke2r1", OS: Linux, Sources: []string{"rke2:v1.27.10+rke2r1"}},
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Sources: []string{"core"}},
+		{Image: "rancher/ui-plugin-catalog:1.0.0", OS: Linux, Sources: []string{"ui-extension"}},
+	}
+
+	assert.Equal(map[SourceCategory]ImageList{
+		SourceCategoryCharts:       {list[0]},
+		SourceCategorySystem:       {list[0], list[3]},
+		SourceCategoryK3sUpgrade:   {list[1]},
+		SourceCategoryRequirements: {list[2], list[4]},
+		SourceCategoryOther:        {list[5]},
+	}, list.BySourceCategory())
+}
+
+func TestImageSetChartURLs(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Linux)
+	imagesSet.SetChartURLs("fleet:102.1.0", "https://github.com/rancher/fleet", "", "https://fleet.rancher.io/", "https://github.com/rancher/fleet")
+	imagesSet.AddChartImage(Linux, "rancher/fleet:v0.7.0", "fleet:102.1.0", "image")
+	imagesSet.Add(Linux, "rancher/shell:v0.1.22", "system")
+
+	list := imagesSet.List(Linux)
+	assert.Equal(map[string][]string{"fleet:102.1.0": {"https://fleet.rancher.io/", "https://github.com/rancher/fleet"}}, list[0].ChartURLs)
+	assert.Nil(list[1].ChartURLs)
+
+	superset := SupersetImageList(map[string]ImageList{"2.8.0": list})
+	assert.Equal(list[0].ChartURLs, superset[0].ChartURLs)
+}
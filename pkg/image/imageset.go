@@ -0,0 +1,522 @@
+package image
+
+import (
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ImageSet collects the images found while exporting along with the sources that reference them. Images are
+// bucketed per OS, and only the OS types the set was created for are tracked; images added for any other OS
+// are ignored, which lets fetchers add everything they find without checking what is being exported. Within each OS,
+// images are exported for every architecture unless all their references restrict them to some architectures, see
+// ImagesForArch.
+//
+// The images, sources, charts and values paths are interned, so that each distinct string is stored once however many
+// images reference it, and the sources, charts and values paths of each image are kept in sorted slices, so that
+// listing the set does not sort them again.
+type ImageSet struct {
+	osTypes   []OSType
+	images    map[OSType]*osImages
+	strings   stringInterner
+	chartURLs map[string][]string
+	warnings  []ChartWarning
+}
+
+// osImages holds the images of an ImageSet for an OS. The images are kept in a slice whose first sorted images are
+// sorted, the images added since being appended to it unsorted until the images are listed, see sortedImages.
+type osImages struct {
+	records map[string]*imageRecord
+	images  []string
+	sorted  int
+	// removed is true if images holds images that were removed from records since it was last compacted.
+	removed bool
+}
+
+// sortedImages returns the images sorted alphabetically, merging the images added since the last call into the sorted
+// ones. The returned slice must not be modified.
+func (o *osImages) sortedImages() []string {
+	removed := o.removed
+	if removed {
+		o.compact()
+	}
+	if o.sorted < len(o.images) {
+		added := o.images[o.sorted:]
+		sort.Strings(added)
+		if o.sorted > 0 && o.images[o.sorted-1] > added[0] {
+			merged := make([]string, 0, len(o.images))
+			sorted := o.images[:o.sorted]
+			for len(sorted) > 0 && len(added) > 0 {
+				if sorted[0] < added[0] {
+					merged, sorted = append(merged, sorted[0]), sorted[1:]
+				} else {
+					merged, added = append(merged, added[0]), added[1:]
+				}
+			}
+			o.images = append(append(merged, sorted...), added...)
+		}
+	}
+	if removed {
+		unique := o.images[:0]
+		for i, image := range o.images {
+			if i == 0 || image != o.images[i-1] {
+				unique = append(unique, image)
+			}
+		}
+		o.images = unique
+	}
+	o.sorted = len(o.images)
+	return o.images
+}
+
+// compact drops the images removed from records from images. Images removed and then added again are listed twice
+// until the images are sorted, see sortedImages.
+func (o *osImages) compact() {
+	kept := o.images[:0]
+	sorted := 0
+	for i, image := range o.images {
+		if _, ok := o.records[image]; !ok {
+			continue
+		}
+		if i < o.sorted {
+			sorted++
+		}
+		kept = append(kept, image)
+	}
+	o.images, o.sorted, o.removed = kept, sorted, false
+}
+
+// stringInterner returns a single copy of the equal strings it is given.
+type stringInterner map[string]string
+
+func (i stringInterner) intern(value string) string {
+	if interned, ok := i[value]; ok {
+		return interned
+	}
+	i[value] = value
+	return value
+}
+
+// imageRecord holds everything known about a single image of an ImageSet.
+type imageRecord struct {
+	// sources and charts are sorted.
+	sources     []string
+	charts      []string
+	valuesPaths chartValuesPaths
+	// anyArch is true if a reference of the image does not restrict its architectures, in which case arches is
+	// ignored.
+	anyArch bool
+	// arches are the architectures the references of the image restrict it to.
+	arches archSet
+}
+
+// hasArch returns whether the image of the record is exported for arch.
+func (r *imageRecord) hasArch(arch Arch) bool {
+	return r.anyArch || r.arches.has(arch)
+}
+
+// addArches records a reference of the image of the record restricted to arches, or not restricted if arches is
+// empty.
+func (r *imageRecord) addArches(arches []Arch) {
+	if len(arches) == 0 {
+		r.anyArch = true
+	}
+	for _, arch := range arches {
+		r.arches = r.arches.add(arch)
+	}
+}
+
+// archSet is a set of architectures, holding the bit 1<<arch of each of its architectures.
+type archSet uint8
+
+func (a archSet) add(arch Arch) archSet {
+	return a | 1<<arch
+}
+
+func (a archSet) has(arch Arch) bool {
+	return a&(1<<arch) != 0
+}
+
+// restricted returns the sorted architectures of the set, or nil if anyArch is true or the set is empty, i.e. if the
+// image is exported for every architecture.
+func (a archSet) restricted(anyArch bool) []Arch {
+	if anyArch {
+		return nil
+	}
+	var sorted []Arch
+	for _, arch := range Arches {
+		if a.has(arch) {
+			sorted = append(sorted, arch)
+		}
+	}
+	return sorted
+}
+
+// insertSorted inserts value into the sorted slice values, unless it is already part of it.
+func insertSorted(values []string, value string) []string {
+	i := sort.SearchStrings(values, value)
+	if i < len(values) && values[i] == value {
+		return values
+	}
+	values = append(values, "")
+	copy(values[i+1:], values[i:])
+	values[i] = value
+	return values
+}
+
+// sortedOrNil returns values, capped so that appending to it does not modify the slice it is part of, or nil if it is
+// empty.
+func sortedOrNil(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[:len(values):len(values)]
+}
+
+// chartValuesPath is the key path of the values of a chart referencing an image.
+type chartValuesPath struct {
+	chart string
+	path  string
+}
+
+// chartValuesPaths holds the key paths of the chart values referencing an image, sorted by chart and then by path.
+type chartValuesPaths []chartValuesPath
+
+func (v chartValuesPaths) add(chart, path string) chartValuesPaths {
+	i := sort.Search(len(v), func(i int) bool {
+		return v[i].chart > chart || v[i].chart == chart && v[i].path >= path
+	})
+	if i < len(v) && v[i].chart == chart && v[i].path == path {
+		return v
+	}
+	v = append(v, chartValuesPath{})
+	copy(v[i+1:], v[i:])
+	v[i] = chartValuesPath{chart: chart, path: path}
+	return v
+}
+
+func (v chartValuesPaths) sorted() map[string][]string {
+	if len(v) == 0 {
+		return nil
+	}
+	valuesPaths := make(map[string][]string)
+	for _, valuesPath := range v {
+		valuesPaths[valuesPath.chart] = append(valuesPaths[valuesPath.chart], valuesPath.path)
+	}
+	return valuesPaths
+}
+
+// valuesPathSet holds the key paths of the chart values referencing an image, per chart.
+type valuesPathSet map[string]map[string]struct{}
+
+func (v valuesPathSet) add(chartNameAndVersion, valuesPath string) {
+	if v[chartNameAndVersion] == nil {
+		v[chartNameAndVersion] = make(map[string]struct{})
+	}
+	v[chartNameAndVersion][valuesPath] = struct{}{}
+}
+
+func (v valuesPathSet) sorted() map[string][]string {
+	if len(v) == 0 {
+		return nil
+	}
+	valuesPaths := make(map[string][]string, len(v))
+	for chart, paths := range v {
+		valuesPaths[chart] = sortedKeys(paths)
+	}
+	return valuesPaths
+}
+
+// NewImageSet returns an empty ImageSet tracking images for the given OS types.
+func NewImageSet(osTypes ...OSType) *ImageSet {
+	s := &ImageSet{
+		images:    make(map[OSType]*osImages, len(osTypes)),
+		strings:   make(stringInterner),
+		chartURLs: make(map[string][]string),
+	}
+	for _, osType := range osTypes {
+		if _, ok := s.images[osType]; ok {
+			continue
+		}
+		s.osTypes = append(s.osTypes, osType)
+		s.images[osType] = &osImages{records: make(map[string]*imageRecord)}
+	}
+	sortOSTypes(s.osTypes)
+	return s
+}
+
+// OSTypes returns the OS types tracked by the set.
+func (s *ImageSet) OSTypes() []OSType {
+	return s.osTypes
+}
+
+// Add records image as being referenced by each of the given sources for osType. Empty images are ignored.
+func (s *ImageSet) Add(osType OSType, image string, sources ...string) {
+	record := s.record(osType, image)
+	if record == nil {
+		return
+	}
+	record.anyArch = true
+	for _, source := range sources {
+		record.sources = insertSorted(record.sources, s.strings.intern(source))
+	}
+}
+
+// AddForArches is Add for an image restricted to arches, e.g. the arm64 variant of an image. The image is exported for
+// every architecture if arches is empty, or if another reference does not restrict it.
+func (s *ImageSet) AddForArches(osType OSType, image, source string, arches ...Arch) {
+	record := s.record(osType, image)
+	if record == nil {
+		return
+	}
+	record.addArches(arches)
+	record.sources = insertSorted(record.sources, s.strings.intern(source))
+}
+
+// AddChartImage records image as being referenced by chartNameAndVersion for osType, using the chart as its source.
+// valuesPath is the key path of the chart values that produced the image, e.g. fluentd.image; it is not recorded
+// if empty.
+func (s *ImageSet) AddChartImage(osType OSType, image, chartNameAndVersion, valuesPath string) {
+	s.AddChartImageForArches(osType, image, chartNameAndVersion, valuesPath)
+}
+
+// AddChartImageForArches is AddChartImage for an image the chart values restrict to arches. The image is exported for
+// every architecture if arches is empty, or if another reference does not restrict it.
+func (s *ImageSet) AddChartImageForArches(osType OSType, image, chartNameAndVersion, valuesPath string, arches ...Arch) {
+	record := s.record(osType, image)
+	if record == nil {
+		return
+	}
+	record.addArches(arches)
+	chartNameAndVersion = s.strings.intern(chartNameAndVersion)
+	record.sources = insertSorted(record.sources, chartNameAndVersion)
+	record.charts = insertSorted(record.charts, chartNameAndVersion)
+	if valuesPath != "" {
+		record.valuesPaths = record.valuesPaths.add(chartNameAndVersion, s.strings.intern(valuesPath))
+	}
+}
+
+// SetChartURLs records the upstream project URLs of chartNameAndVersion, e.g. the home and sources of its
+// Chart.yaml, so the images of the chart can be mapped back to their projects. Empty and duplicate URLs are ignored.
+func (s *ImageSet) SetChartURLs(chartNameAndVersion string, urls ...string) {
+	seen := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		if url != "" {
+			seen[url] = struct{}{}
+		}
+	}
+	if len(seen) == 0 {
+		delete(s.chartURLs, chartNameAndVersion)
+		return
+	}
+	s.chartURLs[chartNameAndVersion] = sortedKeys(seen)
+}
+
+// AddChartWarning records a problem of the values of a chart found while scanning it, and logs it.
+func (s *ImageSet) AddChartWarning(warning ChartWarning) {
+	logrus.Warnf("%v", warning)
+	s.warnings = append(s.warnings, warning)
+}
+
+// ChartWarnings returns the problems of the values of the charts found while scanning them, in the order they were
+// found.
+func (s *ImageSet) ChartWarnings() []ChartWarning {
+	return s.warnings
+}
+
+// Has returns true if image is part of the set for osType.
+func (s *ImageSet) Has(osType OSType, image string) bool {
+	images, ok := s.images[osType]
+	if !ok {
+		return false
+	}
+	_, ok = images.records[image]
+	return ok
+}
+
+// Len returns the number of unique images in the set for osType.
+func (s *ImageSet) Len(osType OSType) int {
+	images, ok := s.images[osType]
+	if !ok {
+		return 0
+	}
+	return len(images.records)
+}
+
+// Images returns the images of the set for osType sorted alphabetically.
+func (s *ImageSet) Images(osType OSType) []string {
+	images, ok := s.images[osType]
+	if !ok {
+		return []string{}
+	}
+	return append([]string{}, images.sortedImages()...)
+}
+
+// ImagesForArch returns the images of the set for osType exported for arch, sorted alphabetically.
+func (s *ImageSet) ImagesForArch(osType OSType, arch Arch) []string {
+	images, ok := s.images[osType]
+	if !ok {
+		return nil
+	}
+	var imagesForArch []string
+	for _, image := range images.sortedImages() {
+		if images.records[image].hasArch(arch) {
+			imagesForArch = append(imagesForArch, image)
+		}
+	}
+	return imagesForArch
+}
+
+// Arches returns the sorted architectures image is restricted to for osType, or nil if it is exported for every
+// architecture or is not part of the set.
+func (s *ImageSet) Arches(osType OSType, image string) []Arch {
+	record := s.existingRecord(osType, image)
+	if record == nil {
+		return nil
+	}
+	return record.arches.restricted(record.anyArch)
+}
+
+// Sources returns the sorted sources of image for osType.
+func (s *ImageSet) Sources(osType OSType, image string) []string {
+	record := s.existingRecord(osType, image)
+	if record == nil {
+		return nil
+	}
+	return sortedOrNil(record.sources)
+}
+
+// Rename moves everything recorded for image over to newImage for every OS, merging it with what newImage
+// already has.
+func (s *ImageSet) Rename(image, newImage string) {
+	if image == newImage {
+		return
+	}
+	s.Copy(image, newImage)
+	for _, osType := range s.osTypes {
+		images := s.images[osType]
+		if _, ok := images.records[image]; ok && s.Has(osType, newImage) {
+			delete(images.records, image)
+			images.removed = true
+		}
+	}
+}
+
+// Copy records everything recorded for image for newImage as well, for every OS, merging it with what newImage
+// already has.
+func (s *ImageSet) Copy(image, newImage string) {
+	if image == newImage {
+		return
+	}
+	for _, osType := range s.osTypes {
+		record := s.existingRecord(osType, image)
+		if record == nil {
+			continue
+		}
+		target := s.record(osType, newImage)
+		if target == nil {
+			continue
+		}
+		for _, source := range record.sources {
+			target.sources = insertSorted(target.sources, source)
+		}
+		for _, chart := range record.charts {
+			target.charts = insertSorted(target.charts, chart)
+		}
+		for _, valuesPath := range record.valuesPaths {
+			target.valuesPaths = target.valuesPaths.add(valuesPath.chart, valuesPath.path)
+		}
+		target.anyArch = target.anyArch || record.anyArch
+		target.arches |= record.arches
+	}
+}
+
+// List converts the set into an ImageList for osType, sorted by image. The sources and charts of the entries share
+// the memory of the set.
+func (s *ImageSet) List(osType OSType) ImageList {
+	images, ok := s.images[osType]
+	if !ok {
+		return ImageList{}
+	}
+	list := make(ImageList, 0, len(images.records))
+	for _, image := range images.sortedImages() {
+		record := images.records[image]
+		list = append(list, ImageEntry{
+			Image:       image,
+			Sources:     sortedOrNil(record.sources),
+			OS:          osType,
+			Arches:      record.arches.restricted(record.anyArch),
+			Charts:      sortedOrNil(record.charts),
+			ValuesPaths: record.valuesPaths.sorted(),
+			ChartURLs:   s.recordChartURLs(record),
+			Optional:    isOptionalImage(record.sources, record.charts),
+		})
+	}
+	return list
+}
+
+// recordChartURLs returns the upstream project URLs of the charts of record, keyed by chart name and version.
+func (s *ImageSet) recordChartURLs(record *imageRecord) map[string][]string {
+	var chartURLs map[string][]string
+	for _, chart := range record.charts {
+		if urls, ok := s.chartURLs[chart]; ok {
+			if chartURLs == nil {
+				chartURLs = make(map[string][]string)
+			}
+			chartURLs[chart] = urls
+		}
+	}
+	return chartURLs
+}
+
+// ListAll converts the set into an ImageList containing the images of every OS tracked by the set, sorted by
+// OS and then by image.
+func (s *ImageSet) ListAll() ImageList {
+	length := 0
+	for _, osType := range s.osTypes {
+		length += s.Len(osType)
+	}
+	list := make(ImageList, 0, length)
+	for _, osType := range s.osTypes {
+		list = append(list, s.List(osType)...)
+	}
+	return list
+}
+
+// record returns the record of image for osType, adding it to the set if needed, or nil if the set does not track
+// osType or image is empty.
+func (s *ImageSet) record(osType OSType, image string) *imageRecord {
+	images, ok := s.images[osType]
+	if !ok || image == "" {
+		return nil
+	}
+	record, ok := images.records[image]
+	if !ok {
+		image = s.strings.intern(image)
+		record = &imageRecord{}
+		images.records[image] = record
+		images.images = append(images.images, image)
+	}
+	return record
+}
+
+// existingRecord returns the record of image for osType, or nil if image is not part of the set for osType.
+func (s *ImageSet) existingRecord(osType OSType, image string) *imageRecord {
+	images, ok := s.images[osType]
+	if !ok {
+		return nil
+	}
+	return images.records[image]
+}
+
+func sortedKeys[V any](set map[string]V) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,23 @@
+package imagetest
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// ReadLines returns the non-empty lines of the file at path, failing the test if it cannot be read.
+func ReadLines(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,270 @@
+// Package imagetest holds the helpers shared by the tests of the image packages: a fake registry and the reading of
+// the files written by the exports.
+package imagetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Schema2MediaType is the media type of docker schema2 manifests.
+const Schema2MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+// Registry is a registry serving the manifests and blobs added or pushed to it, for the tests looking up, copying or
+// pushing images.
+type Registry struct {
+	*httptest.Server
+	mu        sync.Mutex
+	manifests map[string]manifest
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+	// RateLimited is the number of manifest requests answered with 429 responses before serving them again.
+	RateLimited int
+	// Unavailable is the number of manifest requests answered with 503 responses before serving them again.
+	Unavailable int
+	// CatalogPageSize, if set, caps the number of repositories per catalog page, like registries cap the n parameter.
+	CatalogPageSize int
+	// Credentials, if set, are the USERNAME:PASSWORD credentials the requests must be authenticated with.
+	Credentials string
+}
+
+type manifest struct {
+	mediaType string
+	body      []byte
+}
+
+// New starts a TLS registry, stopped when the test ends.
+func New(t *testing.T) *Registry {
+	registry := &Registry{
+		manifests: make(map[string]manifest),
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string][]byte),
+	}
+	registry.Server = httptest.NewTLSServer(http.HandlerFunc(registry.serveHTTP))
+	t.Cleanup(registry.Close)
+	return registry
+}
+
+// Host returns the host of the registry, to prefix the images it serves.
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "https://")
+}
+
+// AddManifest serves body as the manifest of repo:tag and of its digest, which it returns.
+func (r *Registry) AddManifest(repo, tag, mediaType string, body []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manifestDigest := digest.FromBytes(body).String()
+	r.manifests[repo+":"+tag] = manifest{mediaType: mediaType, body: body}
+	r.manifests[repo+"@"+manifestDigest] = manifest{mediaType: mediaType, body: body}
+	return manifestDigest
+}
+
+// AddBlob serves content as a blob of the registry, and returns its digest.
+func (r *Registry) AddBlob(content []byte) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[digest.FromBytes(content).String()] = content
+	return digest.FromBytes(content)
+}
+
+// AddImage serves a single layer image as repo:tag, with its blobs, and returns the digest of its manifest.
+func (r *Registry) AddImage(repo, tag string, layer []byte) string {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	r.AddBlob(config)
+	r.AddBlob(layer)
+	body := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s",`+
+		`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":%d,"digest":"%s"},`+
+		`"layers":[{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","size":%d,"digest":"%s"}]}`,
+		Schema2MediaType, len(config), digest.FromBytes(config), len(layer), digest.FromBytes(layer)))
+	return r.AddManifest(repo, tag, Schema2MediaType, body)
+}
+
+func (r *Registry) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if username, password, _ := req.BasicAuth(); r.Credentials != "" && username+":"+password != r.Credentials {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case req.URL.Path == "/v2/":
+	case path == "_catalog":
+		r.serveCatalog(rw, req)
+	case strings.HasSuffix(path, "/tags/list"):
+		r.serveTags(rw, req, strings.TrimSuffix(path, "/tags/list"))
+	case strings.Contains(path, "/manifests/"):
+		repo, ref, _ := strings.Cut(path, "/manifests/")
+		r.serveManifest(rw, req, repo, ref)
+	case strings.Contains(path, "/blobs/uploads/"):
+		r.serveUpload(rw, req, path)
+	case strings.Contains(path, "/blobs/"):
+		_, blobDigest, _ := strings.Cut(path, "/blobs/")
+		r.mu.Lock()
+		blob, ok := r.blobs[blobDigest]
+		r.mu.Unlock()
+		if !ok {
+			http.NotFound(rw, req)
+			return
+		}
+		rw.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+		rw.Header().Set("Docker-Content-Digest", blobDigest)
+		if req.Method != http.MethodHead {
+			_, _ = rw.Write(blob)
+		}
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+func (r *Registry) serveManifest(rw http.ResponseWriter, req *http.Request, repo, ref string) {
+	r.mu.Lock()
+	limited := r.RateLimited > 0
+	if limited {
+		r.RateLimited--
+	}
+	unavailable := !limited && r.Unavailable > 0
+	if unavailable {
+		r.Unavailable--
+	}
+	r.mu.Unlock()
+	if unavailable {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if limited {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Retry-After", "0")
+		rw.WriteHeader(http.StatusTooManyRequests)
+		_, _ = rw.Write([]byte(`{"errors":[{"code":"TOOMANYREQUESTS","message":"rate limit exceeded"}]}`))
+		return
+	}
+	if req.Method == http.MethodPut {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		manifestDigest := digest.FromBytes(body).String()
+		r.mu.Lock()
+		r.manifests[repo+"@"+manifestDigest] = manifest{mediaType: req.Header.Get("Content-Type"), body: body}
+		if !strings.HasPrefix(ref, "sha256:") {
+			r.manifests[repo+":"+ref] = manifest{mediaType: req.Header.Get("Content-Type"), body: body}
+		}
+		r.mu.Unlock()
+		rw.Header().Set("Docker-Content-Digest", manifestDigest)
+		rw.WriteHeader(http.StatusCreated)
+		return
+	}
+	separator := ":"
+	if strings.HasPrefix(ref, "sha256:") {
+		separator = "@"
+	}
+	r.mu.Lock()
+	m, ok := r.manifests[repo+separator+ref]
+	r.mu.Unlock()
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+	rw.Header().Set("Content-Type", m.mediaType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+	rw.Header().Set("Docker-Content-Digest", digest.FromBytes(m.body).String())
+	if req.Method != http.MethodHead {
+		_, _ = rw.Write(m.body)
+	}
+}
+
+// serveCatalog serves the repositories of the registry, paginated with the n and last parameters.
+func (r *Registry) serveCatalog(rw http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	seen := make(map[string]bool)
+	var repositories []string
+	for key := range r.manifests {
+		repo, _, _ := strings.Cut(strings.Replace(key, "@", ":", 1), ":")
+		if !seen[repo] && repo > req.URL.Query().Get("last") {
+			seen[repo] = true
+			repositories = append(repositories, repo)
+		}
+	}
+	pageSize := r.CatalogPageSize
+	r.mu.Unlock()
+	sort.Strings(repositories)
+	n, err := strconv.Atoi(req.URL.Query().Get("n"))
+	if err != nil || (pageSize > 0 && n > pageSize) {
+		n = pageSize
+	}
+	if n > 0 && n < len(repositories) {
+		repositories = repositories[:n]
+		rw.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=%d>; rel="next"`, repositories[n-1], n))
+	}
+	_ = json.NewEncoder(rw).Encode(map[string][]string{"repositories": repositories})
+}
+
+// serveTags serves the tags of repo, in pages of at most CatalogPageSize tags if set.
+func (r *Registry) serveTags(rw http.ResponseWriter, req *http.Request, repo string) {
+	r.mu.Lock()
+	tags := []string{}
+	for key := range r.manifests {
+		if name, tag, ok := strings.Cut(key, ":"); ok && name == repo && !strings.Contains(key, "@") && tag > req.URL.Query().Get("last") {
+			tags = append(tags, tag)
+		}
+	}
+	pageSize := r.CatalogPageSize
+	r.mu.Unlock()
+	sort.Strings(tags)
+	n, err := strconv.Atoi(req.URL.Query().Get("n"))
+	if err != nil || (pageSize > 0 && n > pageSize) {
+		n = pageSize
+	}
+	if n > 0 && n < len(tags) {
+		tags = tags[:n]
+		rw.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s&n=%d>; rel="next"`, repo, tags[n-1], n))
+	}
+	_ = json.NewEncoder(rw).Encode(map[string]interface{}{"name": repo, "tags": tags})
+}
+
+// serveUpload serves the blob uploads of the registry API: POST starts an upload, PATCH appends to it and PUT
+// completes it.
+func (r *Registry) serveUpload(rw http.ResponseWriter, req *http.Request, path string) {
+	repo, id, _ := strings.Cut(path, "/blobs/uploads/")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch req.Method {
+	case http.MethodPost:
+		id = strconv.Itoa(len(r.uploads) + 1)
+		r.uploads[id] = nil
+	case http.MethodPatch, http.MethodPut:
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.uploads[id] = append(r.uploads[id], body...)
+		if req.Method == http.MethodPut {
+			blobDigest := req.URL.Query().Get("digest")
+			if digest.FromBytes(r.uploads[id]).String() != blobDigest {
+				http.Error(rw, "digest mismatch", http.StatusBadRequest)
+				return
+			}
+			r.blobs[blobDigest] = r.uploads[id]
+			rw.Header().Set("Docker-Content-Digest", blobDigest)
+			rw.WriteHeader(http.StatusCreated)
+			return
+		}
+	}
+	rw.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+	rw.Header().Set("Range", fmt.Sprintf("0-%d", len(r.uploads[id])))
+	rw.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,46 @@
+package image
+
+import (
+	"sort"
+	"strings"
+)
+
+// MirrorDelta is the difference between the images required by Rancher and the images a private registry mirror
+// already holds, so only the missing images need to be copied when updating an air-gapped installation.
+type MirrorDelta struct {
+	// Missing are the required images that the mirror does not hold.
+	Missing ImageList
+	// Obsolete are the images held by the mirror that are no longer required, as listed in its inventory.
+	Obsolete []string
+}
+
+// ComputeMirrorDelta compares list to the inventory of a mirror, i.e. the images it holds. When registry is set, it is
+// stripped from the inventory images it prefixes, so an inventory of registry.example.com/rancher/shell:v0.1.20 holds
+// rancher/shell:v0.1.20. Images are compared regardless of their OS since a registry holds the images of every OS.
+// Inventory files can be read with ReadImageList.
+func ComputeMirrorDelta(list ImageList, inventory []string, registry string) MirrorDelta {
+	prefix := strings.TrimSuffix(registry, "/") + "/"
+	held := make(map[string]struct{}, len(inventory))
+	for _, image := range inventory {
+		if registry != "" {
+			image = strings.TrimPrefix(image, prefix)
+		}
+		held[image] = struct{}{}
+	}
+
+	var delta MirrorDelta
+	required := make(map[string]struct{}, len(list))
+	for _, entry := range list {
+		required[entry.Image] = struct{}{}
+		if _, ok := held[entry.Image]; !ok {
+			delta.Missing = append(delta.Missing, entry)
+		}
+	}
+	for image := range held {
+		if _, ok := required[image]; !ok {
+			delta.Obsolete = append(delta.Obsolete, image)
+		}
+	}
+	sort.Strings(delta.Obsolete)
+	return delta
+}
@@ -0,0 +1,30 @@
+package image
+
+import (
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestComputeMirrorDelta(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet:v0.8.0", OS: Linux},
+		{Image: "rancher/shell:v0.1.20", OS: Linux},
+		{Image: "rancher/fleet-agent:v0.8.0", OS: Windows},
+	}
+	inventory := []string{
+		"registry.example.com/rancher/shell:v0.1.20",
+		"registry.example.com/rancher/fleet:v0.7.0",
+		"rancher/fleet-agent:v0.8.0",
+	}
+
+	delta := ComputeMirrorDelta(list, inventory, "registry.example.com")
+	assert.Equal(ImageList{{Image: "rancher/fleet:v0.8.0", OS: Linux}}, delta.Missing)
+	assert.Equal([]string{"rancher/fleet:v0.7.0"}, delta.Obsolete)
+
+	delta = ComputeMirrorDelta(list, inventory, "")
+	assert.Equal(ImageList{list[0], list[1]}, delta.Missing)
+	assert.Equal([]string{"registry.example.com/rancher/fleet:v0.7.0", "registry.example.com/rancher/shell:v0.1.20"}, delta.Obsolete)
+}
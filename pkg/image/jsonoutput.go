@@ -0,0 +1,48 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// ExportMetadata describes what an image list was generated from, so automation can verify which release it belongs to.
+type ExportMetadata struct {
+	// RancherVersions are the Rancher versions the images were exported for.
+	RancherVersions []string `json:"rancherVersions"`
+	// GeneratedAt is when the image list was generated.
+	GeneratedAt time.Time `json:"generatedAt"`
+	// Revisions are the revisions of the repositories the images were read from, keyed by repository, e.g. charts.
+	Revisions map[string]string `json:"revisions,omitempty"`
+	// ToolVersion is the version of the tool that generated the image list.
+	ToolVersion string `json:"toolVersion"`
+	// ChartWarnings are the problems of the values of the charts found while scanning them, see
+	// ExportResult.ChartWarnings.
+	ChartWarnings []ChartWarning `json:"chartWarnings,omitempty"`
+	// PolicyViolations are the exported images violating the image policies of the export, e.g. its DenyList, both the
+	// failing and the reported ones.
+	PolicyViolations PolicyViolations `json:"policyViolations,omitempty"`
+}
+
+// ImageListDocument is the JSON format of an image list, see WriteImageListJSON.
+type ImageListDocument struct {
+	Metadata ExportMetadata `json:"metadata"`
+	Images   ImageList      `json:"images"`
+}
+
+// WriteImageListJSON writes list to w as an indented ImageListDocument along with metadata.
+func WriteImageListJSON(w io.Writer, list ImageList, metadata ExportMetadata) error {
+	if list == nil {
+		list = ImageList{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(ImageListDocument{Metadata: metadata, Images: list})
+}
+
+// ReadImageListJSON reads an ImageListDocument written by WriteImageListJSON.
+func ReadImageListJSON(r io.Reader) (ImageListDocument, error) {
+	var document ImageListDocument
+	err := json.NewDecoder(r).Decode(&document)
+	return document, err
+}
@@ -0,0 +1,33 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestWriteImageListJSON(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet:v0.7.0", Sources: []string{"fleet:102.1.0"}, OS: Linux, Charts: []string{"fleet:102.1.0"}},
+		{Image: "rancher/fleet-agent:v0.7.0", Sources: []string{"fleet:102.1.0"}, OS: Windows},
+	}
+	metadata := ExportMetadata{
+		RancherVersions: []string{"v2.8.0"},
+		GeneratedAt:     time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+		Revisions:       map[string]string{"charts": "0123abc"},
+		ToolVersion:     "v2.8.0 (abcdef0)",
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(WriteImageListJSON(&buf, list, metadata))
+	assert.Contains(buf.String(), `"os": "windows"`)
+	assert.Contains(buf.String(), `"generatedAt": "2023-09-01T12:00:00Z"`)
+
+	document, err := ReadImageListJSON(&buf)
+	assert.NoError(err)
+	assert.Equal(ImageListDocument{Metadata: metadata, Images: list}, document)
+}
@@ -0,0 +1,77 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// K3sReleasesURL is the URL the K3s releases publish their image lists under.
+const K3sReleasesURL = "https://github.com/k3s-io/k3s/releases/download"
+
+// k3sArches are the architectures the K3s images are published for. K3s publishes a single image list of multi-arch
+// images, and does not support Windows.
+var k3sArches = []Arch{AMD64, ARM64, S390X}
+
+// minK3sVersion is the oldest K3s release whose images are exported: Rancher only provisions K3s clusters of
+// Kubernetes v1.21+.
+var minK3sVersion = semver.MustParse("v1.21.0")
+
+// K3sImages provides the images of the K3s releases supported by a Rancher version: the images listed in the
+// k3s-images.txt file published by each release, along with the K3s upgrade and system agent installer images. Images
+// are labeled with a "k3s:<version>" source, e.g. k3s:v1.27.10+k3s1.
+type K3sImages struct {
+	// RancherVersion is the Rancher version whose K3s releases are exported.
+	RancherVersion string
+	// Releases is the K3s release data of KDM, the "k3s" entry of data.json.
+	Releases map[string]interface{}
+	// Arches limits the images to the given architectures, every architecture if empty.
+	Arches []Arch
+	// URL is the URL the releases publish their image lists under, K3sReleasesURL if empty.
+	URL string
+}
+
+func (k K3sImages) Name() string {
+	return "k3s"
+}
+
+func (k K3sImages) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
+	if !containsOSType(imagesSet.OSTypes(), Linux) {
+		return nil
+	}
+	var arches []Arch
+	for _, arch := range k3sArches {
+		if hasArch(k.Arches, arch) {
+			arches = append(arches, arch)
+		}
+	}
+	if len(arches) == 0 {
+		return nil
+	}
+	url := k.URL
+	if url == "" {
+		url = K3sReleasesURL
+	}
+	for _, version := range kdmReleases(ctx, k.RancherVersion, k.Releases, minK3sVersion) {
+		source := "k3s:" + version
+		tag := releaseTag(version)
+		imagesSet.AddForArches(Linux, "rancher/k3s-upgrade:"+tag, source, arches...)
+		addSystemAgentInstallerImage(imagesSet, "k3s", tag, source, arches...)
+
+		images, err := downloadImageList(ctx, fmt.Sprintf("%s/%s/k3s-images.txt", url, version))
+		if errors.Is(err, errImageListNotPublished) {
+			logrus.Debugf("[k3s] no image list published for release %s", version)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, image := range images {
+			imagesSet.AddForArches(Linux, image, source, arches...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/rancher/pkg/settings"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestK3sImagesFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1.27.10+k3s1/k3s-images.txt" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte("docker.io/rancher/klipper-lb:v0.4.5\ndocker.io/rancher/mirrored-pause:3.6\n"))
+	}))
+	defer server.Close()
+
+	releases := map[string]interface{}{
+		"releases": []interface{}{
+			map[string]interface{}{"version": "v1.27.10+k3s1", "minChannelServerVersion": "v2.8.0-alpha1", "maxChannelServerVersion": "v2.8.99"},
+			map[string]interface{}{"version": "v1.27.11+k3s1", "minChannelServerVersion": "v2.8.0-alpha1", "maxChannelServerVersion": "v2.8.99"},
+			map[string]interface{}{"version": "v1.28.6+k3s1", "minChannelServerVersion": "v2.9.0-alpha1", "maxChannelServerVersion": "v2.9.99"},
+		},
+	}
+	imagesSet := NewImageSet(Linux, Windows)
+	k3s := K3sImages{RancherVersion: "2.8.2", Releases: releases, URL: server.URL}
+	assert.NoError(k3s.FetchImages(context.Background(), imagesSet))
+
+	// The release without an image list only provides its upgrade and installer images
+	assert.ElementsMatch([]string{
+		settings.SystemAgentInstallerImage.Default + "k3s:v1.27.10-k3s1",
+		settings.SystemAgentInstallerImage.Default + "k3s:v1.27.11-k3s1",
+		"rancher/k3s-upgrade:v1.27.10-k3s1",
+		"rancher/k3s-upgrade:v1.27.11-k3s1",
+		"rancher/klipper-lb:v0.4.5",
+		"rancher/mirrored-pause:3.6",
+	}, imagesSet.Images(Linux))
+	assert.Empty(imagesSet.Images(Windows))
+	assert.Equal([]string{"k3s:v1.27.10+k3s1"}, imagesSet.Sources(Linux, "rancher/klipper-lb:v0.4.5"))
+	assert.Equal([]Arch{AMD64, ARM64, S390X}, imagesSet.Arches(Linux, "rancher/klipper-lb:v0.4.5"))
+
+	imagesSet = NewImageSet(Linux)
+	k3s.Arches = []Arch{ARM64}
+	assert.NoError(k3s.FetchImages(context.Background(), imagesSet))
+	assert.Equal([]Arch{ARM64}, imagesSet.Arches(Linux, "rancher/klipper-lb:v0.4.5"))
+
+	// K3s has no ppc64le images
+	imagesSet = NewImageSet(Linux)
+	k3s.Arches = []Arch{PPC64LE}
+	assert.NoError(k3s.FetchImages(context.Background(), imagesSet))
+	assert.Empty(imagesSet.Images(Linux))
+}
@@ -0,0 +1,220 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/image/v5/docker/daemon"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// LocalStoreType is the kind of local image store images are read from.
+type LocalStoreType string
+
+const (
+	// DockerStore is the image store of a docker daemon.
+	DockerStore LocalStoreType = "docker"
+	// ContainerdStore is the content store of containerd, read with ctr.
+	ContainerdStore LocalStoreType = "containerd"
+)
+
+// defaultContainerdNamespace is the containerd namespace images are read from when none is configured, the namespace
+// of ctr. Kubernetes nodes keep their images in the k8s.io namespace.
+const defaultContainerdNamespace = "default"
+
+// ctrCommand is the containerd CLI exporting the images of the content store.
+var ctrCommand = "ctr"
+
+// LocalStore is a local docker daemon or containerd content store images are read from instead of their registries,
+// e.g. on a connected staging host that already pulled them. Local stores hold a single platform of each image, the
+// platform of the host, so only the platform of the OS type and architecture of the client is read, and the manifests
+// are rewritten by the stores: the digests of images read from a local store differ from their upstream digests.
+type LocalStore struct {
+	// Type is the kind of the store.
+	Type LocalStoreType
+	// Address is the address of the docker daemon, e.g. unix:///var/run/docker.sock, or the path of the containerd
+	// socket, e.g. /run/k3s/containerd/containerd.sock. The default address of the store is used if not set.
+	Address string
+	// Namespace is the containerd namespace holding the images, defaultContainerdNamespace if not set.
+	Namespace string
+}
+
+// ParseLocalStoreType parses the type of a local image store, docker or containerd.
+func ParseLocalStoreType(s string) (LocalStoreType, error) {
+	switch storeType := LocalStoreType(strings.ToLower(s)); storeType {
+	case DockerStore, ContainerdStore:
+		return storeType, nil
+	}
+	return "", errors.Errorf("invalid local image store %q, expected docker or containerd", s)
+}
+
+// newImageSource returns the source of image in the store. sys chooses the platform of the images of manifest lists.
+func (s LocalStore) newImageSource(ctx context.Context, image string, sys *types.SystemContext) (types.ImageSource, error) {
+	switch s.Type {
+	case DockerStore:
+		return s.newDockerSource(ctx, image, sys)
+	case ContainerdStore:
+		return s.newContainerdSource(ctx, image, sys)
+	}
+	return nil, errors.Errorf("invalid local image store %q", s.Type)
+}
+
+// newDockerSource returns the source of image in the docker daemon. The daemon saves the image to a temporary tarball
+// the source reads, removed when the source is closed.
+func (s LocalStore) newDockerSource(ctx context.Context, image string, sys *types.SystemContext) (types.ImageSource, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid image %s", image)
+	}
+	// The daemon transport does not support references with both a tag and a digest
+	if canonical, ok := named.(reference.Canonical); ok {
+		if named, err = reference.WithDigest(reference.TrimNamed(named), canonical.Digest()); err != nil {
+			return nil, err
+		}
+	}
+	ref, err := daemon.NewReference("", named)
+	if err != nil {
+		return nil, err
+	}
+	daemonSys := *sys
+	daemonSys.DockerDaemonHost = s.Address
+	src, err := ref.NewImageSource(ctx, &daemonSys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read image %s from the docker daemon", image)
+	}
+	return src, nil
+}
+
+// newContainerdSource exports image from the containerd content store to a temporary OCI layout, removed when the
+// returned source is closed, and returns its source.
+func (s LocalStore) newContainerdSource(ctx context.Context, image string, sys *types.SystemContext) (types.ImageSource, error) {
+	names, err := containerdImageNames(image)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "rancher-images-containerd-")
+	if err != nil {
+		return nil, err
+	}
+	src, err := s.exportContainerdImage(ctx, image, names, dir, sys)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return src, nil
+}
+
+// exportContainerdImage exports the first of names held by the content store to the OCI layout at dir, and returns
+// the source of its manifest for the platform of sys.
+func (s LocalStore) exportContainerdImage(ctx context.Context, image string, names []string, dir string, sys *types.SystemContext) (types.ImageSource, error) {
+	args := []string{"--namespace", s.Namespace}
+	if s.Namespace == "" {
+		args[1] = defaultContainerdNamespace
+	}
+	if s.Address != "" {
+		args = append(args, "--address", s.Address)
+	}
+	platform := sys.OSChoice + "/" + sys.ArchitectureChoice
+	tarball := filepath.Join(dir, "image.tar")
+	var exportErr error
+	for _, name := range names {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, ctrCommand, append(args, "images", "export", "--platform", platform, tarball, name)...)
+		cmd.Stderr = &stderr
+		if exportErr = cmd.Run(); exportErr == nil {
+			break
+		}
+		exportErr = errors.Errorf("%v: %s", exportErr, strings.TrimSpace(stderr.String()))
+	}
+	if exportErr != nil {
+		return nil, errors.Wrapf(exportErr, "failed to export image %s from containerd", image)
+	}
+
+	file, err := os.Open(tarball)
+	if err != nil {
+		return nil, err
+	}
+	layoutDir := filepath.Join(dir, "layout")
+	err = extractTar(file, layoutDir)
+	file.Close()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to extract image %s exported from containerd", image)
+	}
+	os.Remove(tarball)
+
+	src, err := newLayoutSource(layoutDir, image)
+	if err != nil {
+		return nil, err
+	}
+	if err := src.choosePlatform(ctx, sys); err != nil {
+		return nil, errors.Wrapf(err, "failed to read image %s exported from containerd", image)
+	}
+	return &tempLayoutSource{layoutSource: src, tempDir: dir}, nil
+}
+
+// containerdImageNames returns the names containerd may hold image under: its normalized name, along with its name
+// without the tag and without the digest for images pinned to a digest.
+func containerdImageNames(image string) ([]string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid image %s", image)
+	}
+	names := []string{named.String()}
+	canonical, isCanonical := named.(reference.Canonical)
+	tagged, isTagged := named.(reference.NamedTagged)
+	if isCanonical && isTagged {
+		byDigest, err := reference.WithDigest(reference.TrimNamed(named), canonical.Digest())
+		if err != nil {
+			return nil, err
+		}
+		byTag, err := reference.WithTag(reference.TrimNamed(named), tagged.Tag())
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, byDigest.String(), byTag.String())
+	}
+	return names, nil
+}
+
+// choosePlatform makes the source read the manifest of the platform of sys when its manifest is a manifest list,
+// since the layouts exported for a platform only hold the manifest and blobs of that platform.
+func (s *layoutSource) choosePlatform(ctx context.Context, sys *types.SystemContext) error {
+	raw, mimeType, err := s.GetManifest(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		return nil
+	}
+	list, err := manifest.ListFromBlob(raw, mimeType)
+	if err != nil {
+		return err
+	}
+	instance, err := list.ChooseInstance(sys)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.blobPath(instance)); err != nil {
+		return errors.Errorf("manifest %s of platform %s/%s is missing", instance, sys.OSChoice, sys.ArchitectureChoice)
+	}
+	s.descriptor = imgspecv1.Descriptor{Digest: instance}
+	return nil
+}
+
+// tempLayoutSource is a layoutSource reading a temporary layout, removed when the source is closed.
+type tempLayoutSource struct {
+	*layoutSource
+	tempDir string
+}
+
+func (s *tempLayoutSource) Close() error {
+	return os.RemoveAll(s.tempDir)
+}
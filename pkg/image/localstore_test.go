@@ -0,0 +1,84 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestParseLocalStoreType(t *testing.T) {
+	assert := assertlib.New(t)
+
+	storeType, err := ParseLocalStoreType("Docker")
+	assert.NoError(err)
+	assert.Equal(DockerStore, storeType)
+	storeType, err = ParseLocalStoreType("containerd")
+	assert.NoError(err)
+	assert.Equal(ContainerdStore, storeType)
+	_, err = ParseLocalStoreType("podman")
+	assert.Error(err)
+}
+
+func TestContainerdImageNames(t *testing.T) {
+	assert := assertlib.New(t)
+
+	names, err := containerdImageNames("rancher/shell:v0.1.22")
+	assert.NoError(err)
+	assert.Equal([]string{"docker.io/rancher/shell:v0.1.22"}, names)
+
+	pinned := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	names, err = containerdImageNames("quay.io/skopeo/stable:v1@" + pinned)
+	assert.NoError(err)
+	assert.Equal([]string{"quay.io/skopeo/stable:v1@" + pinned, "quay.io/skopeo/stable@" + pinned, "quay.io/skopeo/stable:v1"}, names)
+}
+
+func TestImageCopierCopyContainerdStore(t *testing.T) {
+	assert := assertlib.New(t)
+
+	// The images of the content store are exported from a bundle by a fake ctr recording its arguments
+	source := newFakeRegistry(t)
+	manifestDigest := source.AddImage("rancher/shell", "v0.1.22", []byte("layer"))
+	builder := BundleBuilder{Client: source.client(), Dir: t.TempDir(), SourceRegistry: source.Host()}
+	_, err := builder.Build(context.Background(), ImageList{{Image: "rancher/shell:v0.1.22", OS: Linux}})
+	if !assert.NoError(err) {
+		return
+	}
+	dir := t.TempDir()
+	ctr := filepath.Join(dir, "ctr")
+	args := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\nfor arg; do out=$prev; prev=$arg; done\n" +
+		"case $prev in docker.io/rancher/shell:v0.1.22) cp " + filepath.Join(builder.Dir, "rancher-images-bundle.tar") + " $out;; *) echo not found >&2; exit 1;; esac\n"
+	assert.NoError(os.WriteFile(ctr, []byte(script), 0755))
+	defer func(command string) { ctrCommand = command }(ctrCommand)
+	ctrCommand = ctr
+
+	dest := newFakeRegistry(t)
+	client := dest.client()
+	client.LocalStore = &LocalStore{Type: ContainerdStore, Namespace: "k8s.io"}
+	copier := ImageCopier{Client: client, Registry: dest.Host()}
+	// The image is found by the image name annotation of containerd since the bundle names it rancher/shell:v0.1.22
+	results, err := copier.Copy(context.Background(), ImageList{
+		{Image: "docker.io/rancher/shell:v0.1.22", OS: Linux},
+		{Image: "docker.io/rancher/shell:v0.1.21", OS: Linux},
+	})
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal("docker.io/rancher/shell:v0.1.21", imageErrs[0].Image)
+		assert.Contains(imageErrs[0].Error(), "not found")
+	}
+	if assert.Len(results, 2) {
+		assert.NoError(results[1].Err)
+		assert.Equal(manifestDigest, results[1].Digest)
+	}
+	digest, err := dest.client().Digest(context.Background(), dest.Host()+"/docker.io/rancher/shell:v0.1.22", Linux)
+	assert.NoError(err)
+	assert.Equal(manifestDigest, digest)
+
+	called, err := os.ReadFile(args)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(string(called), "--namespace k8s.io images export --platform linux/amd64 "))
+}
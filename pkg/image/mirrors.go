@@ -0,0 +1,118 @@
+package image
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	img "github.com/rancher/rke/types/image"
+)
+
+// MirrorMode is how upstream images are converted to their mirrored names in an export.
+type MirrorMode string
+
+const (
+	// MirrorModeMirrored exports the mirrored names of the images only, e.g. rancher/mirrored-coredns-coredns. It is the
+	// default mode.
+	MirrorModeMirrored MirrorMode = "mirrored"
+	// MirrorModeUpstream skips the conversion and exports the upstream names of the images only, for users copying the
+	// images directly from their upstream registries.
+	MirrorModeUpstream MirrorMode = "upstream"
+	// MirrorModeBoth exports both the upstream and the mirrored names of the images.
+	MirrorModeBoth MirrorMode = "both"
+)
+
+// MirrorModes are the supported mirror modes.
+var MirrorModes = []MirrorMode{MirrorModeMirrored, MirrorModeUpstream, MirrorModeBoth}
+
+// ParseMirrorMode parses a mirror mode, an empty value being MirrorModeMirrored.
+func ParseMirrorMode(value string) (MirrorMode, error) {
+	if value == "" {
+		return MirrorModeMirrored, nil
+	}
+	for _, mode := range MirrorModes {
+		if string(mode) == value {
+			return mode, nil
+		}
+	}
+	return "", errors.Errorf("invalid mirror mode %q, must be one of %v", value, MirrorModes)
+}
+
+// KeepsUpstreamNames returns whether images are exported under their upstream names in the mode, in which case they
+// are not all in the rancher namespace.
+func (m MirrorMode) KeepsUpstreamNames() bool {
+	return m == MirrorModeUpstream || m == MirrorModeBoth
+}
+
+// LoadMirrorMapping reads a mirror mapping file, a YAML map of upstream image prefixes to the prefixes of their
+// mirrored images, e.g.
+//
+//	quay.io/jetstack/: rancher/mirrored-jetstack-
+//
+// It allows adding mirrors to an export before they are added to the mirrors of the rke types, see
+// ExportConfig.MirrorMapping.
+func LoadMirrorMapping(path string) (RegistryMapping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var mapping RegistryMapping
+	if err := decodeYAMLFile(file, &mapping); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode mirror mapping file %s", path)
+	}
+	for prefix := range mapping {
+		if prefix == "" {
+			return nil, errors.Errorf("invalid mirror mapping file %s: empty upstream prefix", path)
+		}
+	}
+	return mapping, nil
+}
+
+// mirrorImage returns the mirrored name of image. The longest prefix of mapping matching image takes precedence over
+// the mirrors of the rke types.
+func mirrorImage(image string, mapping RegistryMapping) string {
+	mirrored := mapping.Map(image)
+	if mirrored == image {
+		return img.Mirror(image)
+	}
+	// Record the mirror like img.Mirror does, so the image is part of the saved image lists
+	img.Mirrors[mirrored] = image
+	return mirrored
+}
+
+// keepUpstreamImage records image as its own mirror, so that it is part of the saved image lists when it is exported
+// under its upstream name.
+func keepUpstreamImage(image string) {
+	if _, ok := img.Mirrors[image]; !ok {
+		img.Mirrors[image] = image
+	}
+}
+
+// rancherImagePrefixes are the prefixes of the images published by Rancher, on Docker Hub and on the Rancher Prime
+// registry.
+var rancherImagePrefixes = []string{"rancher/", "docker.io/rancher/", "registry.rancher.com/rancher/"}
+
+// IsRancherImage returns true if image is published by Rancher, either as one of its own images or as the mirror of
+// an upstream image, e.g. rancher/mirrored-coredns-coredns.
+func IsRancherImage(image string) bool {
+	for _, prefix := range rancherImagePrefixes {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmirrored returns the entries of the list whose images are not published by Rancher, i.e. which have no mirror and
+// still require access to third-party registries or namespaces in air-gapped installations.
+func (l ImageList) Unmirrored() ImageList {
+	var list ImageList
+	for _, entry := range l {
+		if !IsRancherImage(entry.Image) {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
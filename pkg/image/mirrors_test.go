@@ -0,0 +1,38 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestLoadMirrorMapping(t *testing.T) {
+	assert := assertlib.New(t)
+
+	path := filepath.Join(t.TempDir(), "mirrors.yaml")
+	assert.NoError(os.WriteFile(path, []byte("quay.io/jetstack/: rancher/mirrored-jetstack-\n"), 0644))
+	mapping, err := LoadMirrorMapping(path)
+	assert.NoError(err)
+	assert.Equal(RegistryMapping{"quay.io/jetstack/": "rancher/mirrored-jetstack-"}, mapping)
+	assert.Equal("rancher/mirrored-jetstack-cert-manager-webhook:v1.11.0", mirrorImage("quay.io/jetstack/cert-manager-webhook:v1.11.0", mapping))
+	assert.Equal("rancher/coreos-flannel:v1.2.3", mirrorImage("quay.io/coreos/flannel:v1.2.3", mapping))
+
+	assert.NoError(os.WriteFile(path, []byte("\"\": rancher/\n"), 0644))
+	_, err = LoadMirrorMapping(path)
+	assert.Error(err)
+}
+
+func TestUnmirrored(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "busybox:1.36", OS: Linux},
+		{Image: "quay.io/coreos/etcd:v3.5.9", OS: Linux},
+		{Image: "rancher/mirrored-coredns-coredns:1.10.1", OS: Linux},
+		{Image: "registry.rancher.com/rancher/rancher:v2.8.0", OS: Linux},
+		{Image: "docker.io/rancher/shell:v0.1.22", OS: Windows},
+	}
+	assert.Equal([]string{"busybox:1.36", "quay.io/coreos/etcd:v3.5.9"}, list.Unmirrored().Images())
+}
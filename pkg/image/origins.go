@@ -316,8 +316,11 @@ func GatherUnknownImages(allImages []string) string {
 }
 
 // repoFromImage strips away the repository and version
-// of a given image.
+// of a given image, along with the digest it is pinned to.
 func repoFromImage(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		image = image[:i]
+	}
 	split := strings.Split(image, "/")
 	if len(split) != 2 {
 		return ""
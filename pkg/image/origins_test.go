@@ -50,6 +50,11 @@ func TestRepoFromImage(t *testing.T) {
 		t.Fail()
 	}
 
+	returnedRepo = repoFromImage(image + "@sha256:0123456789abcdef")
+	if repo != returnedRepo {
+		t.Errorf("expected: %s, got :%s", repo, returnedRepo)
+	}
+
 	badImage1 := "hardened-sriov-network-operator:v1.0.0-build20210429"
 	badImage2 := "rancher/hardened-sriov-network-operator"
 
@@ -0,0 +1,225 @@
+package image
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// OSType is the operating system images are exported for, named like the OS of the image platforms, e.g. linux or
+// windows. OS types other than Linux and Windows are registered with RegisterOSType.
+type OSType string
+
+const (
+	Linux   OSType = "linux"
+	Windows OSType = "windows"
+)
+
+// ImagesOSAnnotationKey is the chart annotation forcing the OS types all the images of the chart are exported for,
+// e.g. "linux", regardless of the "os:" keys of its values, to correct charts with wrong OS hints.
+const ImagesOSAnnotationKey = "catalog.cattle.io/images-os"
+
+// OSTypeConfig describes a registered OS type.
+type OSTypeConfig struct {
+	// ImageListName is the key of the images of the OS type in the image list ConfigMap of the catalog, e.g.
+	// windows-rancher-images. The name of the OS type followed by -rancher-images is used if not set.
+	ImageListName string
+	// Arches are the architectures the images of the OS type are published for, every architecture if empty.
+	Arches []Arch
+}
+
+var (
+	osTypesLock sync.RWMutex
+	// osTypes are the registered OS types, in the order they were registered, which is the order image lists are
+	// sorted in.
+	osTypes       []OSType
+	osTypeConfigs = make(map[OSType]OSTypeConfig)
+)
+
+func init() {
+	RegisterOSType(Linux, OSTypeConfig{ImageListName: "rancher-images"})
+	// Rancher only ships Windows images for amd64
+	RegisterOSType(Windows, OSTypeConfig{ImageListName: "windows-rancher-images", Arches: []Arch{AMD64}})
+}
+
+// RegisterOSType registers osType, so it can be parsed with ParseOSType and exported, replacing its configuration if
+// it is already registered. OS type names are lower case.
+func RegisterOSType(osType OSType, config OSTypeConfig) {
+	osTypesLock.Lock()
+	defer osTypesLock.Unlock()
+	if _, ok := osTypeConfigs[osType]; !ok {
+		osTypes = append(osTypes, osType)
+	}
+	osTypeConfigs[osType] = config
+}
+
+// RegisteredOSTypes returns the registered OS types, in the order they were registered.
+func RegisteredOSTypes() []OSType {
+	osTypesLock.RLock()
+	defer osTypesLock.RUnlock()
+	return append([]OSType(nil), osTypes...)
+}
+
+func (o OSType) String() string {
+	return string(o)
+}
+
+// ParseOSType returns the registered OSType called name, case insensitively, e.g. linux or Windows.
+func ParseOSType(name string) (OSType, error) {
+	osType := OSType(strings.ToLower(strings.TrimSpace(name)))
+	osTypesLock.RLock()
+	defer osTypesLock.RUnlock()
+	if _, ok := osTypeConfigs[osType]; !ok {
+		return "", errors.Errorf("unknown os %q", name)
+	}
+	return osType, nil
+}
+
+// ParseOSTypes parses a comma separated list of registered OS types, e.g. linux,windows.
+func ParseOSTypes(s string) ([]OSType, error) {
+	var types []OSType
+	for _, field := range strings.Split(s, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		osType, err := ParseOSType(field)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, osType)
+	}
+	return types, nil
+}
+
+// ParseChartOSTypes parses the OS types all the images of charts are exported for given as NAME=OS[,OS], e.g.
+// rancher-monitoring=linux.
+func ParseChartOSTypes(values []string) (map[string][]OSType, error) {
+	chartOSTypes := make(map[string][]OSType, len(values))
+	for _, value := range values {
+		name, osList, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return nil, errors.Errorf("invalid chart os %q, expected NAME=OS[,OS]", value)
+		}
+		types, err := ParseOSTypes(osList)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid chart os %q", value)
+		}
+		if len(types) == 0 {
+			return nil, errors.Errorf("invalid chart os %q, expected NAME=OS[,OS]", value)
+		}
+		chartOSTypes[name] = types
+	}
+	return chartOSTypes, nil
+}
+
+// MarshalText encodes the OS type as its name, so it is readable in JSON and YAML outputs.
+func (o OSType) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+func (o *OSType) UnmarshalText(text []byte) error {
+	osType, err := ParseOSType(string(text))
+	if err != nil {
+		return err
+	}
+	*o = osType
+	return nil
+}
+
+// Arches returns the architectures the images of the OS type are published for, or nil if they are published for
+// every architecture.
+func (o OSType) Arches() []Arch {
+	osTypesLock.RLock()
+	defer osTypesLock.RUnlock()
+	return osTypeConfigs[o].Arches
+}
+
+// imageListName returns the key of the images of the OS type in the image list ConfigMap of the catalog.
+func (o OSType) imageListName() string {
+	osTypesLock.RLock()
+	defer osTypesLock.RUnlock()
+	if name := osTypeConfigs[o].ImageListName; name != "" {
+		return name
+	}
+	return string(o) + "-rancher-images"
+}
+
+// less returns whether o is sorted before other: registered OS types are sorted in the order they were registered,
+// before the other OS types sorted by name.
+func (o OSType) less(other OSType) bool {
+	i, j := o.index(), other.index()
+	if i != j {
+		return i < j
+	}
+	return o < other
+}
+
+// index returns the position of the OS type in the registered OS types, or their number if it is not registered.
+func (o OSType) index() int {
+	osTypesLock.RLock()
+	defer osTypesLock.RUnlock()
+	for i, osType := range osTypes {
+		if osType == o {
+			return i
+		}
+	}
+	return len(osTypes)
+}
+
+// sortOSTypes sorts types with OSType.less.
+func sortOSTypes(types []OSType) {
+	sort.Slice(types, func(i, j int) bool {
+		return types[i].less(types[j])
+	})
+}
+
+// chartOSTypes returns the OS types all the images of the chart called chartName are exported for, overriding the
+// "os:" keys of its values: the ones of ChartOSTypes if the chart is listed there, or else the ones of its
+// ImagesOSAnnotationKey annotation. Unknown OS types are ignored. The "os:" keys of the values are followed if none
+// is returned.
+func (c ExportConfig) chartOSTypes(chartName string, annotations map[string]string) []OSType {
+	if types, ok := c.ChartOSTypes[chartName]; ok {
+		return types
+	}
+	var types []OSType
+	for _, name := range strings.Split(annotations[ImagesOSAnnotationKey], ",") {
+		if osType, err := ParseOSType(name); err == nil {
+			types = append(types, osType)
+		}
+	}
+	return types
+}
+
+// OSTypesForArches returns the OS types of osTypes whose images are published for one of arches, e.g. only Linux for
+// arm64 since Rancher only ships Windows images for amd64. Every OS type of osTypes is returned if arches is empty.
+func OSTypesForArches(osTypes []OSType, arches []Arch) []OSType {
+	config := ExportConfig{Arches: arches}
+	var supported []OSType
+	for _, osType := range osTypes {
+		if !config.archUnsupported(osType.Arches()) {
+			supported = append(supported, osType)
+		}
+	}
+	return supported
+}
+
+// exportsOSType returns whether the images of osType are exported: it is one of OSTypes, if any, and its images are
+// published for one of Arches, if any.
+func (c ExportConfig) exportsOSType(osType OSType) bool {
+	if len(c.OSTypes) > 0 && !containsOSType(c.OSTypes, osType) {
+		return false
+	}
+	return !c.archUnsupported(osType.Arches())
+}
+
+// containsOSType returns whether osTypes contains osType.
+func containsOSType(osTypes []OSType, osType OSType) bool {
+	for _, o := range osTypes {
+		if o == osType {
+			return true
+		}
+	}
+	return false
+}
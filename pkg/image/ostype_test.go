@@ -0,0 +1,76 @@
+package image
+
+import (
+	"encoding/json"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestParseOSType(t *testing.T) {
+	assert := assertlib.New(t)
+
+	osType, err := ParseOSType(" Windows")
+	assert.NoError(err)
+	assert.Equal(Windows, osType)
+	_, err = ParseOSType("freebsd")
+	assert.EqualError(err, `unknown os "freebsd"`)
+
+	var entry ImageEntry
+	assert.NoError(json.Unmarshal([]byte(`{"image":"rancher/wins:v0.4.12","os":"windows"}`), &entry))
+	assert.Equal(Windows, entry.OS)
+	assert.Error(json.Unmarshal([]byte(`{"image":"rancher/wins:v0.4.12","os":"freebsd"}`), &entry))
+}
+
+func TestParseChartOSTypes(t *testing.T) {
+	assert := assertlib.New(t)
+
+	chartOSTypes, err := ParseChartOSTypes([]string{"rancher-monitoring=linux", "rancher-wins=Windows,linux"})
+	assert.NoError(err)
+	assert.Equal(map[string][]OSType{"rancher-monitoring": {Linux}, "rancher-wins": {Windows, Linux}}, chartOSTypes)
+
+	_, err = ParseChartOSTypes([]string{"rancher-monitoring"})
+	assert.Error(err)
+	_, err = ParseChartOSTypes([]string{"rancher-monitoring="})
+	assert.Error(err)
+	_, err = ParseChartOSTypes([]string{"rancher-monitoring=freebsd"})
+	assert.Error(err)
+}
+
+func TestOSTypesForArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	assert.Equal([]OSType{Linux, Windows}, OSTypesForArches([]OSType{Linux, Windows}, nil))
+	assert.Equal([]OSType{Linux, Windows}, OSTypesForArches([]OSType{Linux, Windows}, []Arch{AMD64, ARM64}))
+	assert.Equal([]OSType{Linux}, OSTypesForArches([]OSType{Linux, Windows}, []Arch{ARM64}))
+	assert.Empty(OSTypesForArches([]OSType{Windows}, []Arch{S390X}))
+}
+
+func TestRegisterOSType(t *testing.T) {
+	assert := assertlib.New(t)
+
+	defer func(registered []OSType) {
+		osTypesLock.Lock()
+		defer osTypesLock.Unlock()
+		osTypes = registered
+		delete(osTypeConfigs, "freebsd")
+	}(RegisteredOSTypes())
+	RegisterOSType("freebsd", OSTypeConfig{Arches: []Arch{AMD64}})
+
+	osType, err := ParseOSType("FreeBSD")
+	assert.NoError(err)
+	assert.Equal(OSType("freebsd"), osType)
+	assert.Equal([]OSType{Linux, Windows, osType}, RegisteredOSTypes())
+	assert.Equal([]Arch{AMD64}, osType.Arches())
+	assert.Equal("freebsd-rancher-images", osType.imageListName())
+	assert.Equal("windows-rancher-images", Windows.imageListName())
+
+	// Registered OS types are sorted in the order they were registered, before the other OS types
+	imagesSet := NewImageSet("solaris", osType, Windows, Linux)
+	assert.Equal([]OSType{Linux, Windows, osType, "solaris"}, imagesSet.OSTypes())
+	imagesSet.Add(osType, "rancher/shell:v0.1.22", "core")
+	imagesSet.Add(Linux, "rancher/shell:v0.1.22", "core")
+	list := imagesSet.ListAll()
+	assert.Equal([]OSType{Linux, osType}, []OSType{list[0].OS, list[1].OS})
+	assert.Empty(list.ForArch(ARM64).ForOS(osType))
+}
@@ -0,0 +1,101 @@
+package image
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PlatformDigest is the digest of the manifest of an image for a single platform, e.g. the linux/arm64 manifest of a
+// manifest list.
+type PlatformDigest struct {
+	// Platform is the platform of the manifest, in the OS/ARCHITECTURE format, e.g. linux/arm64.
+	Platform string `json:"platform"`
+	// Digest is the digest of the manifest.
+	Digest string `json:"digest"`
+}
+
+// PlatformDigests returns the digests of the manifests of image for the requested platforms of osType, in the order of
+// platforms, so mirrors can replicate the platforms they need rather than whole manifest lists. The digest of single
+// platform images is returned if their platform is requested. The requested platforms the image is not built for are
+// skipped.
+func (c RegistryClient) PlatformDigests(ctx context.Context, image string, osType OSType, platforms []Platform) ([]PlatformDigest, error) {
+	var imagePlatforms []imagePlatform
+	err := c.withImage(ctx, image, osType, func(ref types.ImageReference, sys *types.SystemContext) error {
+		var err error
+		imagePlatforms, err = imagePlatformsOf(ctx, image, ref, sys)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var digests []PlatformDigest
+	for _, platform := range platforms {
+		if platform.OS != osType.String() {
+			continue
+		}
+		// Manifest lists may hold several manifests of a platform, e.g. one per Windows build, which are all needed
+		for _, imagePlatform := range imagePlatforms {
+			if imagePlatform.Platform == platform && imagePlatform.Digest != "" {
+				digests = append(digests, PlatformDigest{Platform: platform.String(), Digest: imagePlatform.Digest})
+			}
+		}
+	}
+	return digests, nil
+}
+
+// LookupPlatformDigests sets the platform-specific manifest digests of the entries of list for the requested
+// platforms from their registries, see PlatformDigests. The images that cannot be looked up are logged and left
+// without platform digests, like with LookupImages.
+func (c RegistryClient) LookupPlatformDigests(ctx context.Context, list ImageList, platforms []Platform) {
+	var mu sync.Mutex
+	var errs ImageErrors
+	c.forEach(list, func(entry *ImageEntry) {
+		digests, err := c.PlatformDigests(ctx, entry.Image, entry.OS, platforms)
+		if err != nil {
+			imageErr := &ImageError{Image: entry.Image, OS: entry.OS, Err: err}
+			logrus.Warnf("skipping platform digest lookup (%s): %v", imageErr.Class(), err)
+			mu.Lock()
+			errs = append(errs, imageErr)
+			mu.Unlock()
+			return
+		}
+		entry.PlatformDigests = digests
+	})
+	if len(errs) > 0 {
+		logrus.Warnf("%d of %d images could not be looked up: %s", len(errs), len(list), errs.ClassSummary())
+	}
+}
+
+// WritePlatformDigests writes a line per platform-specific manifest digest of the entries of the list, with the
+// platform-specific image reference followed by its platform and by the image, e.g.
+// "rancher/shell@sha256:... linux/arm64 rancher/shell:v0.1.22". The digests must have been looked up with
+// LookupPlatformDigests, the entries without any are skipped.
+func (l ImageList) WritePlatformDigests(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	for _, entry := range l {
+		for _, platformDigest := range entry.PlatformDigests {
+			if _, err := fmt.Fprintf(writer, "%s@%s %s %s\n", imageRepository(entry.Image), platformDigest.Digest, platformDigest.Platform, entry.Image); err != nil {
+				return errors.Wrap(err, "failed to write platform digests")
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+// imageRepository returns the repository of image, without its tag and digest, e.g. rancher/shell for
+// rancher/shell:v0.1.22@sha256:....
+func imageRepository(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	repository, _ := splitImageTag(image)
+	return repository
+}
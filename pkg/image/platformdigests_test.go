@@ -0,0 +1,61 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestRegistryClientLookupPlatformDigests(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	amd64Digest := registry.AddImage("rancher/shell", "v0.1.22-amd64", []byte("amd64 layer"))
+	arm64Digest := registry.AddImage("rancher/shell", "v0.1.22-arm64", []byte("arm64 layer"))
+	registry.AddManifest("rancher/shell", "v0.1.22", "application/vnd.docker.distribution.manifest.list.v2+json", []byte(fmt.Sprintf(
+		`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[`+
+			`{"mediaType":"%s","size":1,"digest":"%s","platform":{"architecture":"amd64","os":"linux"}},`+
+			`{"mediaType":"%s","size":1,"digest":"%s","platform":{"architecture":"arm64","os":"linux"}}]}`,
+		schema2MediaType, amd64Digest, schema2MediaType, arm64Digest)))
+	singleDigest := registry.AddImage("rancher/rke-tools", "v0.1.88", []byte("layer"))
+
+	list := ImageList{
+		{Image: registry.Host() + "/rancher/rke-tools:v0.1.88", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.21", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux},
+	}
+	registry.client().LookupPlatformDigests(context.Background(), list, []Platform{
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "windows", Architecture: "amd64"},
+	})
+
+	// Single platform images only have the digest of their own platform, and the missing images have none
+	assert.Equal([]PlatformDigest{{Platform: "linux/amd64", Digest: singleDigest}}, list[0].PlatformDigests)
+	assert.Nil(list[1].PlatformDigests)
+	assert.Equal([]PlatformDigest{
+		{Platform: "linux/arm64", Digest: arm64Digest},
+		{Platform: "linux/amd64", Digest: amd64Digest},
+	}, list[2].PlatformDigests)
+
+	var out bytes.Buffer
+	assert.NoError(list.WritePlatformDigests(&out))
+	assert.Equal(
+		registry.Host()+"/rancher/rke-tools@"+singleDigest+" linux/amd64 "+registry.Host()+"/rancher/rke-tools:v0.1.88\n"+
+			registry.Host()+"/rancher/shell@"+arm64Digest+" linux/arm64 "+registry.Host()+"/rancher/shell:v0.1.22\n"+
+			registry.Host()+"/rancher/shell@"+amd64Digest+" linux/amd64 "+registry.Host()+"/rancher/shell:v0.1.22\n",
+		out.String())
+}
+
+func TestImageRepository(t *testing.T) {
+	assert := assertlib.New(t)
+
+	pinned := "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	assert.Equal("rancher/shell", imageRepository("rancher/shell:v0.1.22"))
+	assert.Equal("rancher/shell", imageRepository("rancher/shell:v0.1.22@"+pinned))
+	assert.Equal("registry.example.com:5000/rancher/shell", imageRepository("registry.example.com:5000/rancher/shell@"+pinned))
+	assert.Equal("registry.example.com:5000/rancher/shell", imageRepository("registry.example.com:5000/rancher/shell"))
+}
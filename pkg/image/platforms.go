@@ -0,0 +1,196 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	ctrimage "github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// Platform is a platform images are built for, e.g. linux/arm64.
+type Platform struct {
+	OS           string
+	Architecture string
+}
+
+func (p Platform) String() string {
+	return p.OS + "/" + p.Architecture
+}
+
+// DefaultPlatforms are the platforms Rancher supports.
+var DefaultPlatforms = []Platform{
+	{OS: "linux", Architecture: "amd64"},
+	{OS: "linux", Architecture: "arm64"},
+	{OS: "windows", Architecture: "amd64"},
+}
+
+// ParsePlatform parses a platform in the OS/ARCHITECTURE format, e.g. linux/arm64.
+func ParsePlatform(value string) (Platform, error) {
+	osName, architecture, ok := strings.Cut(value, "/")
+	if !ok || osName == "" || architecture == "" || strings.Contains(architecture, "/") {
+		return Platform{}, errors.Errorf("invalid platform %q, must be OS/ARCHITECTURE", value)
+	}
+	return Platform{OS: osName, Architecture: architecture}, nil
+}
+
+// PlatformGap is an image that is not built for some of the requested platforms.
+type PlatformGap struct {
+	// Image is the image reference.
+	Image string
+	// OS is the OS the image is exported for.
+	OS OSType
+	// Missing are the requested platforms the image is not built for.
+	Missing []Platform
+}
+
+// manifestListPlatforms is the part of docker manifest lists and OCI indexes describing the platforms of their
+// manifests.
+type manifestListPlatforms struct {
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			OSVersion    string `json:"os.version"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// imagePlatform is a platform an image is built for, along with the version of its OS, e.g. 10.0.20348.2031 for
+// Windows images, and the digest of its manifest.
+type imagePlatform struct {
+	Platform
+	OSVersion string
+	Digest    string
+}
+
+// Platforms returns the platforms image is built for: the platforms of the manifests of its manifest list, or the
+// platform of its config for single platform images.
+func (c RegistryClient) Platforms(ctx context.Context, image string) ([]Platform, error) {
+	var platforms []Platform
+	err := c.withImage(ctx, image, Linux, func(ref types.ImageReference, sys *types.SystemContext) error {
+		var err error
+		platforms, err = platformsOf(ctx, image, ref, sys)
+		return err
+	})
+	return platforms, err
+}
+
+// platformsOf returns the platforms ref is built for.
+func platformsOf(ctx context.Context, image string, ref types.ImageReference, sys *types.SystemContext) ([]Platform, error) {
+	imagePlatforms, err := imagePlatformsOf(ctx, image, ref, sys)
+	if err != nil {
+		return nil, err
+	}
+	platforms := make([]Platform, 0, len(imagePlatforms))
+	for _, platform := range imagePlatforms {
+		platforms = append(platforms, platform.Platform)
+	}
+	return platforms, nil
+}
+
+// imagePlatformsOf returns the platforms ref is built for, with the versions of their OS.
+func imagePlatformsOf(ctx context.Context, image string, ref types.ImageReference, sys *types.SystemContext) ([]imagePlatform, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to access image %s", image)
+	}
+	defer src.Close()
+
+	raw, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get manifest of image %s", image)
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		var list manifestListPlatforms
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse manifest list of image %s", image)
+		}
+		platforms := make([]imagePlatform, 0, len(list.Manifests))
+		for _, m := range list.Manifests {
+			platforms = append(platforms, imagePlatform{
+				Platform:  Platform{OS: m.Platform.OS, Architecture: m.Platform.Architecture},
+				OSVersion: m.Platform.OSVersion,
+				Digest:    m.Digest,
+			})
+		}
+		return platforms, nil
+	}
+
+	single, err := ctrimage.FromUnparsedImage(ctx, sys, ctrimage.UnparsedInstance(src, nil))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read image %s", image)
+	}
+	info, err := single.Inspect(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config of image %s", image)
+	}
+	manifestDigest, err := manifest.Digest(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compute manifest digest of image %s", image)
+	}
+	platform := imagePlatform{Platform: Platform{OS: info.Os, Architecture: info.Architecture}, Digest: manifestDigest.String()}
+	// The inspected details lack the OS version, which is only in the config of OCI and docker schema2 images
+	if rawConfig, err := single.ConfigBlob(ctx); err == nil && len(rawConfig) > 0 {
+		var config struct {
+			OSVersion string `json:"os.version"`
+		}
+		if err := json.Unmarshal(rawConfig, &config); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse config of image %s", image)
+		}
+		platform.OSVersion = config.OSVersion
+	}
+	return []imagePlatform{platform}, nil
+}
+
+// MissingPlatforms returns the images of list that are not built for some of the requested platforms of the OS they
+// are exported for, e.g. Linux images without a linux/arm64 manifest, sorted by OS and image. The platforms of the
+// architectures an image is not exported for are not requested for it, e.g. linux/s390x for an image restricted to
+// amd64 and arm64 by its chart values. The images whose platforms cannot be read are returned in ImageErrors along
+// with the gaps found in the other images.
+func (c RegistryClient) MissingPlatforms(ctx context.Context, list ImageList, platforms []Platform) ([]PlatformGap, error) {
+	var mu sync.Mutex
+	var gaps []PlatformGap
+	var errs ImageErrors
+	c.forEach(list, func(entry *ImageEntry) {
+		imagePlatforms, err := c.Platforms(ctx, entry.Image)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, &ImageError{Image: entry.Image, OS: entry.OS, Err: err})
+			return
+		}
+		built := make(map[Platform]bool, len(imagePlatforms))
+		for _, platform := range imagePlatforms {
+			built[platform] = true
+		}
+		gap := PlatformGap{Image: entry.Image, OS: entry.OS}
+		for _, platform := range platforms {
+			if platform.OS != entry.OS.String() || built[platform] {
+				continue
+			}
+			// Images restricted to some architectures are not expected to be built for the others
+			if arch, err := ParseArch(platform.Architecture); err == nil && !hasArch(entry.Arches, arch) {
+				continue
+			}
+			gap.Missing = append(gap.Missing, platform)
+		}
+		if len(gap.Missing) > 0 {
+			gaps = append(gaps, gap)
+		}
+	})
+
+	sort.Slice(gaps, func(i, j int) bool {
+		return lessImageEntry(ImageEntry{Image: gaps[i].Image, OS: gaps[i].OS}, ImageEntry{Image: gaps[j].Image, OS: gaps[j].OS})
+	})
+	if len(errs) > 0 {
+		return gaps, errs
+	}
+	return gaps, nil
+}
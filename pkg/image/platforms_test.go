@@ -0,0 +1,70 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestParsePlatform(t *testing.T) {
+	assert := assertlib.New(t)
+
+	platform, err := ParsePlatform("linux/arm64")
+	assert.NoError(err)
+	assert.Equal(Platform{OS: "linux", Architecture: "arm64"}, platform)
+	assert.Equal("linux/arm64", platform.String())
+
+	for _, value := range []string{"linux", "/arm64", "linux/", "linux/arm/v7"} {
+		_, err := ParsePlatform(value)
+		assert.Error(err, value)
+	}
+}
+
+func TestRegistryClientMissingPlatforms(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	instanceDigest := registry.AddImage("rancher/shell", "v0.1.22-amd64", []byte("layer"))
+	registry.AddManifest("rancher/shell", "v0.1.22", "application/vnd.docker.distribution.manifest.list.v2+json", []byte(fmt.Sprintf(
+		`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.list.v2+json","manifests":[`+
+			`{"mediaType":"%s","size":1,"digest":"%s","platform":{"architecture":"amd64","os":"linux"}},`+
+			`{"mediaType":"%s","size":1,"digest":"%s","platform":{"architecture":"arm64","os":"linux"}}]}`,
+		schema2MediaType, instanceDigest, schema2MediaType, instanceDigest)))
+	registry.AddImage("rancher/rke-tools", "v0.1.88", []byte("layer"))
+
+	gaps, err := registry.client().MissingPlatforms(context.Background(), ImageList{
+		{Image: registry.Host() + "/rancher/rke-tools:v0.1.88", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Windows},
+		{Image: registry.Host() + "/rancher/shell:v0.1.21", OS: Linux},
+	}, DefaultPlatforms)
+
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal(registry.Host()+"/rancher/shell:v0.1.21", imageErrs[0].Image)
+	}
+	assert.Equal([]PlatformGap{
+		{Image: registry.Host() + "/rancher/rke-tools:v0.1.88", OS: Linux, Missing: []Platform{{OS: "linux", Architecture: "arm64"}}},
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Windows, Missing: []Platform{{OS: "windows", Architecture: "amd64"}}},
+	}, gaps)
+}
+
+func TestRegistryClientMissingPlatformsRestrictedArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	registry.AddImage("rancher/rke-tools", "v0.1.88", []byte("layer"))
+	registry.AddImage("rancher/shell", "v0.1.22", []byte("layer"))
+
+	// Only the images exported for s390x are expected to be built for it
+	gaps, err := registry.client().MissingPlatforms(context.Background(), ImageList{
+		{Image: registry.Host() + "/rancher/rke-tools:v0.1.88", OS: Linux, Arches: []Arch{AMD64}},
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux},
+	}, []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "s390x"}})
+	assert.NoError(err)
+	assert.Equal([]PlatformGap{
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux, Missing: []Platform{{OS: "linux", Architecture: "s390x"}}},
+	}, gaps)
+}
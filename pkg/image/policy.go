@@ -0,0 +1,101 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PolicyAction is what the violations of an image policy rule do to an export.
+type PolicyAction string
+
+const (
+	// PolicyActionFail fails the export.
+	PolicyActionFail PolicyAction = "fail"
+	// PolicyActionWarn reports the violation without failing the export.
+	PolicyActionWarn PolicyAction = "warn"
+)
+
+// ParsePolicyAction parses a policy action, PolicyActionFail if empty.
+func ParsePolicyAction(value string) (PolicyAction, error) {
+	switch PolicyAction(value) {
+	case "", PolicyActionFail:
+		return PolicyActionFail, nil
+	case PolicyActionWarn:
+		return PolicyActionWarn, nil
+	}
+	return "", errors.Errorf("invalid policy action %q, must be %s or %s", value, PolicyActionFail, PolicyActionWarn)
+}
+
+// PolicyViolation is an exported image violating a rule of an image policy, e.g. a denied image.
+type PolicyViolation struct {
+	// Policy is the name of the violated policy, e.g. DenyListPolicy.
+	Policy string `json:"policy"`
+	Image  string `json:"image"`
+	OS     OSType `json:"os"`
+	// Sources are the sources of the image, e.g. the charts pulling it in, see ImageEntry.Sources.
+	Sources []string `json:"sources,omitempty"`
+	// Rule is the violated rule, e.g. the pattern of a denied image, or the repository of an image from a registry
+	// that is not allowed.
+	Rule string `json:"rule"`
+	// Reason is why the rule exists, e.g. an embargoed CVE.
+	Reason string       `json:"reason,omitempty"`
+	Action PolicyAction `json:"action"`
+}
+
+func (v PolicyViolation) String() string {
+	message := fmt.Sprintf("%s image %s", v.OS, v.Image)
+	if len(v.Sources) > 0 {
+		message += " from " + strings.Join(v.Sources, ", ")
+	}
+	message += fmt.Sprintf(" violates %s rule %s", v.Policy, v.Rule)
+	if v.Reason != "" {
+		message += ": " + v.Reason
+	}
+	return message
+}
+
+// PolicyViolations are the violations of the image policies by an export.
+type PolicyViolations []PolicyViolation
+
+// Failures returns the violations failing the export.
+func (v PolicyViolations) Failures() PolicyViolations {
+	var failures PolicyViolations
+	for _, violation := range v {
+		if violation.Action == PolicyActionFail {
+			failures = append(failures, violation)
+		}
+	}
+	return failures
+}
+
+// BySource groups the violations by the sources of their images, e.g. by the charts pulling them in.
+func (v PolicyViolations) BySource() map[string]PolicyViolations {
+	bySource := make(map[string]PolicyViolations)
+	for _, violation := range v {
+		for _, source := range violation.Sources {
+			bySource[source] = append(bySource[source], violation)
+		}
+	}
+	return bySource
+}
+
+func (v PolicyViolations) Error() string {
+	messages := make([]string, 0, len(v))
+	for _, violation := range v {
+		messages = append(messages, violation.String())
+	}
+	return fmt.Sprintf("%d images violate the image policies:\n%s", len(v), strings.Join(messages, "\n"))
+}
+
+// sortPolicyViolations sorts violations by policy, then by image and OS.
+func sortPolicyViolations(violations PolicyViolations) {
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Policy != violations[j].Policy {
+			return violations[i].Policy < violations[j].Policy
+		}
+		return lessImageEntry(ImageEntry{Image: violations[i].Image, OS: violations[i].OS}, ImageEntry{Image: violations[j].Image, OS: violations[j].OS})
+	})
+}
@@ -0,0 +1,131 @@
+package image
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Progress describes how far along an export is.
+type Progress struct {
+	// Source is the name of the image source being fetched.
+	Source string
+	// Chart is the chart, in name:version format, that was just scanned, if any.
+	Chart string
+	// ChartsScanned and ChartsTotal are the number of charts of Source scanned so far and in total.
+	ChartsScanned int
+	ChartsTotal   int
+	// ImagesFound is the number of images found so far, across all sources and OS types.
+	ImagesFound int
+	// BytesDownloaded is the number of bytes downloaded so far, across all sources.
+	BytesDownloaded int64
+	// Done is true once Source has been fully fetched.
+	Done bool
+}
+
+// ProgressFunc is called with the current progress of an export whenever it changes. It is called synchronously,
+// so it should return quickly.
+type ProgressFunc func(Progress)
+
+type progressContextKey struct{}
+
+// progressTracker keeps track of the progress of an export and reports it to a ProgressFunc. All methods are safe to
+// call on a nil tracker, in which case they do nothing.
+type progressTracker struct {
+	lock      sync.Mutex
+	report    ProgressFunc
+	imagesSet *ImageSet
+	progress  Progress
+}
+
+// withProgress returns a context carrying a tracker reporting the progress of filling imagesSet to report. The
+// context is returned unchanged if report is nil.
+func withProgress(ctx context.Context, report ProgressFunc, imagesSet *ImageSet) context.Context {
+	if report == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressContextKey{}, &progressTracker{report: report, imagesSet: imagesSet})
+}
+
+// progressFromContext returns the tracker of ctx, or nil if it has none.
+func progressFromContext(ctx context.Context) *progressTracker {
+	tracker, _ := ctx.Value(progressContextKey{}).(*progressTracker)
+	return tracker
+}
+
+// startSource resets the per-source progress for the source called name.
+func (t *progressTracker) startSource(name string) {
+	t.update(func(p *Progress) {
+		p.Source = name
+		p.Chart = ""
+		p.ChartsScanned = 0
+		p.ChartsTotal = 0
+		p.Done = false
+	})
+}
+
+// finishSource reports that the current source has been fully fetched.
+func (t *progressTracker) finishSource() {
+	t.update(func(p *Progress) {
+		p.Chart = ""
+		p.Done = true
+	})
+}
+
+// setChartsTotal sets the number of charts the current source is going to scan.
+func (t *progressTracker) setChartsTotal(total int) {
+	t.update(func(p *Progress) {
+		p.ChartsTotal = total
+	})
+}
+
+// chartScanned reports that chartNameAndVersion has been scanned.
+func (t *progressTracker) chartScanned(chartNameAndVersion string) {
+	t.update(func(p *Progress) {
+		p.Chart = chartNameAndVersion
+		p.ChartsScanned++
+	})
+}
+
+// addBytesDownloaded adds n to the number of bytes downloaded.
+func (t *progressTracker) addBytesDownloaded(n int64) {
+	t.update(func(p *Progress) {
+		p.BytesDownloaded += n
+	})
+}
+
+// reader wraps r so the bytes read from it are counted as downloaded. r is returned as is on a nil tracker.
+func (t *progressTracker) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &progressReader{reader: r, tracker: t}
+}
+
+func (t *progressTracker) update(change func(*Progress)) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	change(&t.progress)
+	t.progress.ImagesFound = 0
+	for _, osType := range t.imagesSet.OSTypes() {
+		t.progress.ImagesFound += t.imagesSet.Len(osType)
+	}
+	progress := t.progress
+	t.lock.Unlock()
+	t.report(progress)
+}
+
+type progressReader struct {
+	reader  io.Reader
+	tracker *progressTracker
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.tracker.addBytesDownloaded(int64(n))
+	}
+	return n, err
+}
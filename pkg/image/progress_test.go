@@ -0,0 +1,60 @@
+package image
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestProgressTracker(t *testing.T) {
+	assert := assertlib.New(t)
+
+	var reports []Progress
+	imagesSet := NewImageSet(Linux, Windows)
+	ctx := withProgress(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+	}, imagesSet)
+	progress := progressFromContext(ctx)
+
+	progress.startSource("charts")
+	progress.setChartsTotal(2)
+	imagesSet.Add(Linux, "rancher/fleet:v0.7.0", "fleet:102.1.0")
+	imagesSet.Add(Windows, "rancher/fleet:v0.7.0", "fleet:102.1.0")
+	progress.chartScanned("fleet:102.1.0")
+	_, err := io.ReadAll(progress.reader(strings.NewReader("12345")))
+	assert.NoError(err)
+	progress.finishSource()
+
+	assert.Equal(Progress{
+		Source:          "charts",
+		Chart:           "fleet:102.1.0",
+		ChartsScanned:   1,
+		ChartsTotal:     2,
+		ImagesFound:     2,
+		BytesDownloaded: 0,
+	}, reports[2])
+	assert.Equal(Progress{
+		Source:          "charts",
+		ChartsScanned:   1,
+		ChartsTotal:     2,
+		ImagesFound:     2,
+		BytesDownloaded: 5,
+		Done:            true,
+	}, reports[len(reports)-1])
+}
+
+func TestProgressTrackerDisabled(t *testing.T) {
+	ctx := withProgress(context.Background(), nil, NewImageSet(Linux))
+	progress := progressFromContext(ctx)
+	assertlib.Nil(t, progress)
+
+	// all methods are no-ops on a nil tracker
+	progress.startSource("charts")
+	progress.chartScanned("fleet:102.1.0")
+	progress.finishSource()
+	r := strings.NewReader("12345")
+	assertlib.Equal(t, r, progress.reader(r))
+}
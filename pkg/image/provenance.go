@@ -0,0 +1,197 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// InTotoStatementType is the type of the in-toto statements of the provenance of image lists.
+	InTotoStatementType = "https://in-toto.io/Statement/v1"
+	// SLSAProvenancePredicateType is the predicate type of the SLSA provenance of image lists.
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v1"
+	// ExportBuildType is the SLSA build type of the exports of image lists, whose external parameters are described by
+	// ProvenanceInputs.
+	ExportBuildType = "https://github.com/rancher/rancher/pkg/image/export@v1"
+	// DefaultBuilderID is the SLSA builder ID of the exports of image lists not run by a known builder.
+	DefaultBuilderID = "https://github.com/rancher/rancher/pkg/image/export"
+)
+
+// ProvenanceStatement is an in-toto statement of the SLSA provenance of the artifacts of an export, so that consumers
+// can verify how an image list was built.
+type ProvenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []ProvenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     SLSAProvenance      `json:"predicate"`
+}
+
+// ProvenanceSubject is an artifact of an export, e.g. rancher-images.txt.
+type ProvenanceSubject struct {
+	Name string `json:"name"`
+	// Digest is the digest of the artifact keyed by algorithm, e.g. sha256.
+	Digest map[string]string `json:"digest"`
+}
+
+// SLSAProvenance is the SLSA provenance predicate, see https://slsa.dev/spec/v1.0/provenance.
+type SLSAProvenance struct {
+	BuildDefinition SLSABuildDefinition `json:"buildDefinition"`
+	RunDetails      SLSARunDetails      `json:"runDetails"`
+}
+
+// SLSABuildDefinition describes the inputs of a build.
+type SLSABuildDefinition struct {
+	BuildType            string                   `json:"buildType"`
+	ExternalParameters   map[string]interface{}   `json:"externalParameters"`
+	InternalParameters   map[string]interface{}   `json:"internalParameters,omitempty"`
+	ResolvedDependencies []SLSAResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+// SLSAResourceDescriptor describes an artifact the build depends on, e.g. the revision of a charts repository.
+type SLSAResourceDescriptor struct {
+	URI    string            `json:"uri,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// SLSARunDetails describes the run of a build.
+type SLSARunDetails struct {
+	Builder  SLSABuilder       `json:"builder"`
+	Metadata SLSABuildMetadata `json:"metadata"`
+}
+
+// SLSABuilder identifies the platform running a build.
+type SLSABuilder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// SLSABuildMetadata describes when and how a build was run.
+type SLSABuildMetadata struct {
+	InvocationID string     `json:"invocationId,omitempty"`
+	StartedOn    *time.Time `json:"startedOn,omitempty"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// ProvenanceRepository is a charts repository an export read images from.
+type ProvenanceRepository struct {
+	ChartRepo
+	// Revision is the git commit of the repository the images were read from, empty if it is not a git repository.
+	Revision string
+}
+
+// ProvenanceInputs are the inputs of an export recorded in its provenance.
+type ProvenanceInputs struct {
+	// RancherVersions are the Rancher versions the images were exported for.
+	RancherVersions []string
+	// Repositories are the charts repositories the images were read from, keyed by name, e.g. charts.
+	Repositories map[string]ProvenanceRepository
+	// KDMSource and KDMDigest are where the KDM data was loaded from, and the sha256 digest of its data.json file.
+	KDMSource string
+	KDMDigest string
+	// Parameters are the other parameters of the export, e.g. its OS types, keyed by name.
+	Parameters map[string]interface{}
+	// ToolVersion is the version of the tool that exported the images.
+	ToolVersion string
+	// BuilderID identifies the platform running the export, DefaultBuilderID if not set, and InvocationID the run,
+	// e.g. the URL of a CI job.
+	BuilderID    string
+	InvocationID string
+	// StartedOn and FinishedOn are when the export started and finished.
+	StartedOn  time.Time
+	FinishedOn time.Time
+}
+
+// NewExportProvenance returns the provenance of the artifacts of an export, whose sha256 digests are keyed by name,
+// e.g. rancher-images.txt. The subjects are sorted by name.
+func NewExportProvenance(inputs ProvenanceInputs, artifacts map[string]string) ProvenanceStatement {
+	external := map[string]interface{}{"rancherVersions": inputs.RancherVersions}
+	for name, value := range inputs.Parameters {
+		external[name] = value
+	}
+	var dependencies []SLSAResourceDescriptor
+	repositories := make(map[string]interface{}, len(inputs.Repositories))
+	for _, name := range sortedKeys(inputs.Repositories) {
+		repo := inputs.Repositories[name]
+		parameters := map[string]string{}
+		dependency := SLSAResourceDescriptor{Name: name}
+		if repo.URL != "" && repo.Path == "" {
+			parameters["url"] = repo.URL
+			dependency.URI = "git+" + strings.TrimPrefix(repo.URL, "git+")
+			if repo.Branch != "" {
+				parameters["branch"] = repo.Branch
+				dependency.URI += "@refs/heads/" + repo.Branch
+			}
+		} else {
+			parameters["path"] = repo.Path
+		}
+		repositories[name] = parameters
+		if repo.Revision != "" {
+			dependency.Digest = map[string]string{"gitCommit": repo.Revision}
+		}
+		dependencies = append(dependencies, dependency)
+	}
+	if len(repositories) > 0 {
+		external["repositories"] = repositories
+	}
+	if inputs.KDMSource != "" {
+		external["kdm"] = inputs.KDMSource
+		kdm := SLSAResourceDescriptor{Name: "kdm"}
+		if strings.HasPrefix(inputs.KDMSource, "http://") || strings.HasPrefix(inputs.KDMSource, "https://") {
+			kdm.URI = inputs.KDMSource
+		}
+		if algorithm, digest, ok := strings.Cut(inputs.KDMDigest, ":"); ok {
+			kdm.Digest = map[string]string{algorithm: digest}
+		}
+		dependencies = append(dependencies, kdm)
+	}
+
+	builder := SLSABuilder{ID: inputs.BuilderID}
+	if builder.ID == "" {
+		builder.ID = DefaultBuilderID
+	}
+	if inputs.ToolVersion != "" {
+		builder.Version = map[string]string{"export": inputs.ToolVersion}
+	}
+	metadata := SLSABuildMetadata{InvocationID: inputs.InvocationID}
+	if !inputs.StartedOn.IsZero() {
+		startedOn := inputs.StartedOn.UTC()
+		metadata.StartedOn = &startedOn
+	}
+	if !inputs.FinishedOn.IsZero() {
+		finishedOn := inputs.FinishedOn.UTC()
+		metadata.FinishedOn = &finishedOn
+	}
+
+	subjects := make([]ProvenanceSubject, 0, len(artifacts))
+	for name, digest := range artifacts {
+		subjects = append(subjects, ProvenanceSubject{Name: name, Digest: map[string]string{"sha256": digest}})
+	}
+	sort.Slice(subjects, func(i, j int) bool {
+		return subjects[i].Name < subjects[j].Name
+	})
+	return ProvenanceStatement{
+		Type:          InTotoStatementType,
+		Subject:       subjects,
+		PredicateType: SLSAProvenancePredicateType,
+		Predicate: SLSAProvenance{
+			BuildDefinition: SLSABuildDefinition{
+				BuildType:            ExportBuildType,
+				ExternalParameters:   external,
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: SLSARunDetails{Builder: builder, Metadata: metadata},
+		},
+	}
+}
+
+// WriteExportProvenance writes the provenance of the artifacts of an export to w as indented JSON, see
+// NewExportProvenance.
+func WriteExportProvenance(w io.Writer, inputs ProvenanceInputs, artifacts map[string]string) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(NewExportProvenance(inputs, artifacts))
+}
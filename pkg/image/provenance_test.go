@@ -0,0 +1,67 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestNewExportProvenance(t *testing.T) {
+	assert := assertlib.New(t)
+
+	startedOn := time.Date(2023, 11, 7, 10, 0, 0, 0, time.UTC)
+	finishedOn := startedOn.Add(5 * time.Minute)
+	statement := NewExportProvenance(ProvenanceInputs{
+		RancherVersions: []string{"2.8.0"},
+		Repositories: map[string]ProvenanceRepository{
+			"charts":       {ChartRepo: ChartRepo{URL: "https://github.com/rancher/charts", Branch: "release-v2.8"}, Revision: "1111111"},
+			"systemCharts": {ChartRepo: ChartRepo{Path: "/src/system-charts"}},
+		},
+		KDMSource:   "https://releases.rancher.com/kontainer-driver-metadata/release-v2.8/data.json",
+		KDMDigest:   "sha256:2222222",
+		Parameters:  map[string]interface{}{"os": []string{"linux"}},
+		ToolVersion: "v2.8.0",
+		StartedOn:   startedOn,
+		FinishedOn:  finishedOn,
+	}, map[string]string{"rancher-windows-images.txt": "4444444", "rancher-images.txt": "3333333"})
+
+	assert.Equal(InTotoStatementType, statement.Type)
+	assert.Equal(SLSAProvenancePredicateType, statement.PredicateType)
+	assert.Equal([]ProvenanceSubject{
+		{Name: "rancher-images.txt", Digest: map[string]string{"sha256": "3333333"}},
+		{Name: "rancher-windows-images.txt", Digest: map[string]string{"sha256": "4444444"}},
+	}, statement.Subject)
+	assert.Equal(SLSABuildDefinition{
+		BuildType: ExportBuildType,
+		ExternalParameters: map[string]interface{}{
+			"rancherVersions": []string{"2.8.0"},
+			"os":              []string{"linux"},
+			"kdm":             "https://releases.rancher.com/kontainer-driver-metadata/release-v2.8/data.json",
+			"repositories": map[string]interface{}{
+				"charts":       map[string]string{"url": "https://github.com/rancher/charts", "branch": "release-v2.8"},
+				"systemCharts": map[string]string{"path": "/src/system-charts"},
+			},
+		},
+		ResolvedDependencies: []SLSAResourceDescriptor{
+			{Name: "charts", URI: "git+https://github.com/rancher/charts@refs/heads/release-v2.8", Digest: map[string]string{"gitCommit": "1111111"}},
+			{Name: "systemCharts"},
+			{Name: "kdm", URI: "https://releases.rancher.com/kontainer-driver-metadata/release-v2.8/data.json", Digest: map[string]string{"sha256": "2222222"}},
+		},
+	}, statement.Predicate.BuildDefinition)
+	assert.Equal(SLSARunDetails{
+		Builder:  SLSABuilder{ID: DefaultBuilderID, Version: map[string]string{"export": "v2.8.0"}},
+		Metadata: SLSABuildMetadata{StartedOn: &startedOn, FinishedOn: &finishedOn},
+	}, statement.Predicate.RunDetails)
+
+	var buf bytes.Buffer
+	assert.NoError(WriteExportProvenance(&buf, ProvenanceInputs{BuilderID: "https://github.com/rancher/rancher/actions", KDMSource: EmbeddedKDMSource}, nil))
+	var written map[string]interface{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &written))
+	assert.Equal(InTotoStatementType, written["_type"])
+	assert.Equal([]interface{}{}, written["subject"])
+	predicate := written["predicate"].(map[string]interface{})
+	assert.Equal(map[string]interface{}{"id": "https://github.com/rancher/rancher/actions"}, predicate["runDetails"].(map[string]interface{})["builder"])
+}
@@ -0,0 +1,71 @@
+package image
+
+import (
+	"context"
+	"os"
+
+	"github.com/rancher/rancher/pkg/settings"
+)
+
+// provisioningCharts are the charts Rancher installs for provisioning v2, whose versions match the Rancher version.
+var provisioningCharts = map[string]struct{}{
+	"rancher-provisioning-capi": {},
+}
+
+// Provisioning provides the images provisioning v2 needs to provision RKE2 and K3s clusters: the images of the
+// rancher-provisioning-capi chart version matching the Rancher version, i.e. the cluster-api controllers, along with
+// the machine provisioning image node drivers run in and the system agent upgrade image Rancher defaults to. The system
+// agent installer images of each RKE2 and K3s release are added by RKE2Images and K3sImages, also labeled with the
+// "provisioning" source, see addSystemAgentInstallerImage.
+type Provisioning struct {
+	Config ExportConfig
+}
+
+func (p Provisioning) Name() string {
+	return "provisioning"
+}
+
+func (p Provisioning) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
+	if containsOSType(imagesSet.OSTypes(), Linux) {
+		for _, image := range []string{settingValue(settings.MachineProvisionImage), systemAgentUpgradeImage()} {
+			imagesSet.Add(Linux, image, "provisioning")
+		}
+	}
+	return fetchVersionMatchedCharts(ctx, p.Config, provisioningCharts, imagesSet)
+}
+
+// systemAgentUpgradeImage returns the image upgrading the system agent of provisioned nodes, the
+// system-agent-upgrade-image setting, or the image of the system-agent-version setting if it is not set, like the
+// Rancher images do. It returns an empty image if neither is set.
+func systemAgentUpgradeImage() string {
+	if image := settingValue(settings.SystemAgentUpgradeImage); image != "" {
+		return image
+	}
+	if version := settingValue(settings.SystemAgentVersion); version != "" {
+		return "rancher/system-agent:" + version + "-suc"
+	}
+	return ""
+}
+
+// addSystemAgentInstallerImage adds the system agent installer image of the release of distro, rke2 or k3s, tagged tag
+// for arches, the image installing the system agent along with the release on the nodes of custom and node driver
+// clusters. It is labeled with both the source of the release and the "provisioning" source.
+func addSystemAgentInstallerImage(imagesSet *ImageSet, distro, tag, source string, arches ...Arch) {
+	image := settingValue(settings.SystemAgentInstallerImage) + distro + ":" + tag
+	imagesSet.AddForArches(Linux, image, source, arches...)
+	imagesSet.AddForArches(Linux, image, "provisioning", arches...)
+}
+
+// settingValue returns the value of setting Rancher defaults to: its CATTLE_ environment variable, which the Rancher
+// images set for the settings defined at build time, or its default value.
+func settingValue(setting settings.Setting) string {
+	if value, ok := lookupSettingEnv(setting); ok {
+		return value
+	}
+	return setting.Get()
+}
+
+// lookupSettingEnv returns the value of the CATTLE_ environment variable of setting, and whether it is set.
+func lookupSettingEnv(setting settings.Setting) (string, bool) {
+	return os.LookupEnv(settings.GetEnvKey(setting.Name))
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/rancher/pkg/settings"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+const provisioningChartsIndex = `apiVersion: v1
+entries:
+  rancher-provisioning-capi:
+  - name: rancher-provisioning-capi
+    version: 104.0.0+up0.2.0
+    annotations:
+      catalog.cattle.io/rancher-version: '>= 2.9.0-0 < 2.10.0-0'
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+  - name: rancher-provisioning-capi
+    version: 103.0.0+up0.0.1
+    annotations:
+      catalog.cattle.io/rancher-version: '>= 2.8.0-0 < 2.9.0-0'
+    urls:
+    - assets/broken/broken-1.0.0.tgz
+`
+
+func TestProvisioningFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentUpgradeImage.Name), "")
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentVersion.Name), "v0.3.4")
+	t.Setenv(settings.GetEnvKey(settings.MachineProvisionImage.Name), "rancher/machine:v0.15.0-rancher106")
+
+	// The chart points to a broken tarball, so the chart that is scanned is reported as a chart error
+	chartsPath := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "index.yaml"), []byte(provisioningChartsIndex), 0644))
+	assert.NoError(os.MkdirAll(filepath.Join(chartsPath, "assets", "broken"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "assets", "broken", "broken-1.0.0.tgz"), []byte("not a tarball"), 0644))
+
+	imagesSet := NewImageSet(Linux, Windows)
+	err := Provisioning{ExportConfig{ChartsPath: chartsPath, RancherVersion: "2.8.2"}}.FetchImages(context.Background(), imagesSet)
+	var chartErrs ChartErrors
+	if assert.ErrorAs(err, &chartErrs) && assert.Len(chartErrs, 1) {
+		assert.Equal("rancher-provisioning-capi:103.0.0+up0.0.1", chartErrs[0].Chart)
+	}
+	assert.Equal([]string{"rancher/machine:v0.15.0-rancher106", "rancher/system-agent:v0.3.4-suc"}, imagesSet.Images(Linux))
+	assert.Equal([]string{"provisioning"}, imagesSet.Sources(Linux, "rancher/system-agent:v0.3.4-suc"))
+	assert.Empty(imagesSet.Images(Windows))
+}
+
+func TestSystemAgentUpgradeImage(t *testing.T) {
+	assert := assertlib.New(t)
+
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentUpgradeImage.Name), "rancher/system-agent:v0.3.5-suc")
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentVersion.Name), "v0.3.4")
+	assert.Equal("rancher/system-agent:v0.3.5-suc", systemAgentUpgradeImage())
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentUpgradeImage.Name), "")
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentVersion.Name), "")
+	assert.Empty(systemAgentUpgradeImage())
+}
+
+func TestAddSystemAgentInstallerImage(t *testing.T) {
+	assert := assertlib.New(t)
+
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentInstallerImage.Name), "example.com/system-agent-installer-")
+	imagesSet := NewImageSet(Linux)
+	addSystemAgentInstallerImage(imagesSet, "k3s", "v1.27.10-k3s1", "k3s:v1.27.10+k3s1", AMD64, ARM64)
+	image := "example.com/system-agent-installer-k3s:v1.27.10-k3s1"
+	assert.Equal([]string{image}, imagesSet.Images(Linux))
+	assert.Equal([]string{"k3s:v1.27.10+k3s1", "provisioning"}, imagesSet.Sources(Linux, image))
+	assert.Equal([]Arch{AMD64, ARM64}, imagesSet.Arches(Linux, image))
+}
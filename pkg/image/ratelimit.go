@@ -0,0 +1,132 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/pkg/docker/config"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultRateLimitRetries is the number of times RegistryClient retries the requests for an image a registry rate
+	// limits.
+	defaultRateLimitRetries = 3
+	// defaultRateLimitDelay is the delay before the first retry of rate limited requests, doubled for each following
+	// retry. containers/image already retries 429 responses for a few seconds, while registries rate limit for minutes.
+	defaultRateLimitDelay = 30 * time.Second
+	// dockerHubAuthURL is the endpoint issuing the bearer tokens of Docker Hub.
+	dockerHubAuthURL = "https://auth.docker.io/token"
+	// dockerHubTokenMargin is subtracted from the lifetime of Docker Hub tokens, so they are not used as they expire.
+	dockerHubTokenMargin = 10 * time.Second
+)
+
+// DockerHubLimiter keeps the requests of RegistryClient to Docker Hub under its rate limits: the requests are paced,
+// the bearer token of each repository is reused until it expires instead of requesting a token per image, and the
+// images can be looked up in a mirror of Docker Hub instead. A DockerHubLimiter is safe for concurrent use and can be
+// shared by several clients.
+type DockerHubLimiter struct {
+	// Mirror, if set, is a registry mirroring Docker Hub the docker.io images are looked up in instead, e.g. a pull
+	// through cache.
+	Mirror string
+	// Client sends the token requests to Docker Hub.
+	Client *http.Client
+
+	limiter *rate.Limiter
+	authURL string
+
+	mu     sync.Mutex
+	tokens map[string]dockerHubToken
+}
+
+type dockerHubToken struct {
+	token   string
+	expires time.Time
+}
+
+// NewDockerHubLimiter returns a DockerHubLimiter sending at most requestsPerMinute requests per minute to Docker Hub,
+// or not pacing them if requestsPerMinute is 0, and looking the images up in mirror if it is not empty.
+func NewDockerHubLimiter(requestsPerMinute int, mirror string) *DockerHubLimiter {
+	limit := rate.Inf
+	if requestsPerMinute > 0 {
+		limit = rate.Limit(float64(requestsPerMinute) / 60)
+	}
+	return &DockerHubLimiter{
+		Mirror:  mirror,
+		limiter: rate.NewLimiter(limit, 1),
+		Client:  http.DefaultClient,
+		authURL: dockerHubAuthURL,
+		tokens:  make(map[string]dockerHubToken),
+	}
+}
+
+// mirrored returns the image of the mirror for Docker Hub images, e.g. mirror.example.com/library/busybox:1.36 for
+// busybox:1.36, and image itself otherwise.
+func (l *DockerHubLimiter) mirrored(image string) string {
+	if l.Mirror == "" || ImageRegistry(image) != dockerHubDomain {
+		return image
+	}
+	name := strings.TrimPrefix(image, dockerHubDomain+"/")
+	if repo, _ := splitImageTag(name); !strings.Contains(repo, "/") {
+		name = "library/" + name
+	}
+	return strings.TrimSuffix(l.Mirror, "/") + "/" + name
+}
+
+// wait blocks until a request can be sent to Docker Hub.
+func (l *DockerHubLimiter) wait(ctx context.Context) error {
+	return l.limiter.Wait(ctx)
+}
+
+// token returns a bearer token pulling repo, e.g. library/busybox, with the credentials of Docker Hub in sys. Tokens
+// are reused until they expire. An empty token is returned for identity tokens, which containers/image exchanges
+// itself.
+func (l *DockerHubLimiter) token(ctx context.Context, sys *types.SystemContext, repo string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cached, ok := l.tokens[repo]; ok && time.Now().Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	auth, err := config.GetCredentials(sys, dockerHubDomain)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get credentials of docker.io")
+	}
+	if auth.IdentityToken != "" {
+		return "", nil
+	}
+	token, lifetime, err := requestBearerToken(ctx, l.Client, l.authURL, "registry.docker.io", "repository:"+repo+":pull", auth)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get docker.io token")
+	}
+	l.tokens[repo] = dockerHubToken{token: token, expires: time.Now().Add(lifetime - dockerHubTokenMargin)}
+	return token, nil
+}
+
+// isRateLimited returns whether err comes from a registry rate limiting the requests, i.e. from a 429 response.
+func isRateLimited(err error) bool {
+	if errors.Is(err, docker.ErrTooManyRequests) {
+		return true
+	}
+	var codeErrs errcode.Errors
+	if errors.As(err, &codeErrs) {
+		for _, codeErr := range codeErrs {
+			if isRateLimited(codeErr) {
+				return true
+			}
+		}
+	}
+	var codeErr errcode.Error
+	if errors.As(err, &codeErr) && codeErr.Code == errcode.ErrorCodeTooManyRequests {
+		return true
+	}
+	// HEAD responses have no body to read the error code from, containers/image only reports their status code
+	return strings.Contains(err.Error(), "StatusCode: 429")
+}
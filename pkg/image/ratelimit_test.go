@@ -0,0 +1,72 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestDockerHubLimiterMirrored(t *testing.T) {
+	assert := assertlib.New(t)
+
+	limiter := NewDockerHubLimiter(0, "mirror.example.com/")
+	assert.Equal("mirror.example.com/library/busybox:1.36", limiter.mirrored("busybox:1.36"))
+	assert.Equal("mirror.example.com/rancher/shell:v0.1.22", limiter.mirrored("rancher/shell:v0.1.22"))
+	assert.Equal("mirror.example.com/rancher/shell:v0.1.22", limiter.mirrored("docker.io/rancher/shell:v0.1.22"))
+	assert.Equal("quay.io/example/shell:v1", limiter.mirrored("quay.io/example/shell:v1"))
+	assert.Equal("busybox:1.36", NewDockerHubLimiter(0, "").mirrored("busybox:1.36"))
+}
+
+func TestDockerHubLimiterToken(t *testing.T) {
+	assert := assertlib.New(t)
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		username, password, _ := req.BasicAuth()
+		requests = append(requests, fmt.Sprintf("%s %s:%s", req.URL.Query().Get("scope"), username, password))
+		fmt.Fprintf(rw, `{"token":"token-%d","expires_in":300}`, len(requests))
+	}))
+	defer server.Close()
+
+	limiter := NewDockerHubLimiter(0, "")
+	limiter.authURL = server.URL
+	sys := &types.SystemContext{DockerAuthConfig: &types.DockerAuthConfig{Username: "user", Password: "password"}}
+	for _, repo := range []string{"rancher/shell", "rancher/shell", "library/busybox"} {
+		_, err := limiter.token(context.Background(), sys, repo)
+		assert.NoError(err)
+	}
+	token, err := limiter.token(context.Background(), sys, "rancher/shell")
+	assert.NoError(err)
+	assert.Equal("token-1", token)
+	assert.Equal([]string{"repository:rancher/shell:pull user:password", "repository:library/busybox:pull user:password"}, requests)
+}
+
+func TestRegistryClientRateLimited(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	manifestDigest := registry.AddManifest("rancher/shell", "v0.1.22", schema2MediaType, schema2Manifest(100))
+	image := registry.Host() + "/rancher/shell:v0.1.22"
+
+	client := registry.client()
+	client.rateLimitDelay = 1
+	registry.RateLimited = 2
+	d, err := client.Digest(context.Background(), image, Linux)
+	assert.NoError(err)
+	assert.Equal(manifestDigest, d)
+
+	client.RateLimitRetries = 1
+	registry.RateLimited = 100
+	_, err = client.Digest(context.Background(), image, Linux)
+	var rateLimitErr *RateLimitError
+	if assert.True(errors.As(err, &rateLimitErr)) {
+		assert.Equal(registry.Host(), rateLimitErr.Registry)
+	}
+	assert.True(isRateLimited(err))
+}
@@ -0,0 +1,350 @@
+package image
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLookupWorkers is the number of images handled concurrently by RegistryClient.
+const defaultLookupWorkers = 8
+
+// RegistryClient looks up the images of an image list in their registries.
+type RegistryClient struct {
+	// SystemContext configures the registry connections, e.g. credentials or certificates. It may be nil.
+	SystemContext *types.SystemContext
+	// Arch is the architecture whose images are read in manifest lists, e.g. to size or copy the s390x images, amd64 by
+	// default. The architecture chosen by SystemContext takes precedence.
+	Arch Arch
+	// Credentials configure how the client authenticates to registries.
+	Credentials RegistryCredentials
+	// TLS, if set, configures the TLS connections to the registries.
+	TLS *TLSConfig
+	// Workers is the number of images handled concurrently, defaultLookupWorkers if not set.
+	Workers int
+	// BlobWorkers is the number of blobs of an image copied concurrently, defaultBlobWorkers if not set.
+	BlobWorkers int
+	// Bandwidth, if set, caps the bandwidth of the copied blobs.
+	Bandwidth *BandwidthLimiter
+	// LocalStore, if set, is the local image store the copied images are read from instead of their registries.
+	LocalStore *LocalStore
+	// DockerHub, if set, paces and authenticates the requests to Docker Hub, or sends them to its mirror.
+	DockerHub *DockerHubLimiter
+	// RateLimitRetries is the number of times the requests for an image are retried while its registry rate limits
+	// them, defaultRateLimitRetries if not set.
+	RateLimitRetries int
+	// Retry configures the retries of the requests failing with a transient error.
+	Retry RetryPolicy
+
+	// transfer, if set, records the transfer of the blobs of the copied image.
+	transfer *imageTransfer
+	// rateLimitDelay is the delay before the first retry of rate limited requests, defaultRateLimitDelay if not set.
+	rateLimitDelay time.Duration
+}
+
+// ImageDetails are the details of an image read from its registry.
+type ImageDetails struct {
+	// Digest is the digest of the image manifest, i.e. of the manifest list for multi-arch images.
+	Digest string
+	// CompressedSize is the total size of the compressed layers of the image for its OS and architecture.
+	CompressedSize int64
+	// Layers are the compressed sizes of the layers of the image for its OS and architecture, keyed by digest.
+	Layers map[string]int64
+}
+
+// dockerReference returns the reference of image in the docker transport. The tag of images pinned to a digest is
+// dropped, since the transport does not support references with both.
+func dockerReference(image string) (types.ImageReference, error) {
+	name := image
+	if repo, tag := splitImageTag(image); strings.Contains(image, "@") {
+		if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+			name = repo[:i] + "@" + tag
+		}
+	}
+	ref, err := docker.ParseReference("//" + name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse image %s", image)
+	}
+	return ref, nil
+}
+
+// Digest returns the digest of the manifest of image in its registry, i.e. of the manifest list for multi-arch images.
+// The manifest is not downloaded, its digest is read with a HEAD request.
+func (c RegistryClient) Digest(ctx context.Context, image string, osType OSType) (string, error) {
+	var manifestDigest string
+	err := c.withImage(ctx, image, osType, func(ref types.ImageReference, sys *types.SystemContext) error {
+		d, err := docker.GetDigest(ctx, sys, ref)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get digest of image %s", image)
+		}
+		manifestDigest = d.String()
+		return nil
+	})
+	return manifestDigest, err
+}
+
+// Inspect reads the details of image for osType from its registry.
+func (c RegistryClient) Inspect(ctx context.Context, image string, osType OSType) (ImageDetails, error) {
+	var details ImageDetails
+	err := c.withImage(ctx, image, osType, func(ref types.ImageReference, sys *types.SystemContext) error {
+		var err error
+		details, err = inspectImage(ctx, image, osType, ref, sys)
+		return err
+	})
+	return details, err
+}
+
+// inspectImage reads the details of image for osType from ref.
+func inspectImage(ctx context.Context, image string, osType OSType, ref types.ImageReference, sys *types.SystemContext) (ImageDetails, error) {
+	src, err := ref.NewImageSource(ctx, sys)
+	if err != nil {
+		return ImageDetails{}, errors.Wrapf(err, "failed to access image %s", image)
+	}
+	defer src.Close()
+
+	raw, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return ImageDetails{}, errors.Wrapf(err, "failed to get manifest of image %s", image)
+	}
+	manifestDigest, err := manifest.Digest(raw)
+	if err != nil {
+		return ImageDetails{}, errors.Wrapf(err, "failed to compute digest of image %s", image)
+	}
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(raw, mimeType)
+		if err != nil {
+			return ImageDetails{}, errors.Wrapf(err, "failed to parse manifest list of image %s", image)
+		}
+		instance, err := list.ChooseInstance(sys)
+		if err != nil {
+			return ImageDetails{}, errors.Wrapf(err, "failed to find %s manifest of image %s", osType, image)
+		}
+		if raw, mimeType, err = src.GetManifest(ctx, &instance); err != nil {
+			return ImageDetails{}, errors.Wrapf(err, "failed to get %s manifest of image %s", osType, image)
+		}
+	}
+	m, err := manifest.FromBlob(raw, mimeType)
+	if err != nil {
+		return ImageDetails{}, errors.Wrapf(err, "failed to parse manifest of image %s", image)
+	}
+
+	details := ImageDetails{Digest: manifestDigest.String(), Layers: make(map[string]int64)}
+	for _, layer := range m.LayerInfos() {
+		details.CompressedSize += layer.Size
+		details.Layers[layer.Digest.String()] = layer.Size
+	}
+	return details, nil
+}
+
+// withImage calls f with the reference and system context of image. The requests for Docker Hub images are paced
+// and authenticated by DockerHub, or sent to its mirror. f is retried with an exponential backoff while the registry
+// rate limits it, and RateLimitError is returned if it still does after RateLimitRetries retries. f is also retried
+// after transient errors, as configured by Retry.
+func (c RegistryClient) withImage(ctx context.Context, image string, osType OSType, f func(ref types.ImageReference, sys *types.SystemContext) error) error {
+	source := image
+	if c.DockerHub != nil {
+		source = c.DockerHub.mirrored(image)
+	}
+	ref, err := dockerReference(source)
+	if err != nil {
+		return err
+	}
+	sys, err := c.systemContext(source, osType)
+	if err != nil {
+		return err
+	}
+	registry := ImageRegistry(source)
+	retries := c.RateLimitRetries
+	if retries <= 0 {
+		retries = defaultRateLimitRetries
+	}
+	delay := c.rateLimitDelay
+	if delay <= 0 {
+		delay = defaultRateLimitDelay
+	}
+
+	for attempt, transientAttempt := 0, 0; ; {
+		err = nil
+		if c.DockerHub != nil && registry == dockerHubDomain {
+			err = c.DockerHub.wait(ctx)
+			if err == nil {
+				sys.DockerBearerRegistryToken, err = c.DockerHub.token(ctx, sys, reference.Path(ref.DockerReference()))
+			}
+		}
+		if err == nil {
+			err = f(ref, sys)
+		}
+		if err == nil {
+			return nil
+		}
+		if !isRateLimited(err) {
+			if transientAttempt >= c.Retry.retries() || !c.Retry.retryable(err) {
+				return err
+			}
+			transientDelay := c.Retry.delay(transientAttempt)
+			transientAttempt++
+			logrus.Warnf("requests for image %s failed, retrying in %v (%d/%d): %v", image, transientDelay, transientAttempt, c.Retry.retries(), err)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(transientDelay):
+			}
+			continue
+		}
+		if attempt >= retries {
+			return &RateLimitError{Registry: registry, Err: err}
+		}
+		attempt++
+		logrus.Warnf("registry %s rate limited the requests for image %s, retrying in %v (%d/%d)", registry, image, delay, attempt, retries)
+		select {
+		case <-ctx.Done():
+			return &RateLimitError{Registry: registry, Err: err}
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// systemContext returns the system context accessing image with the credentials of its registry, and selecting the
+// image of osType for the architecture of the client in manifest lists.
+func (c RegistryClient) systemContext(image string, osType OSType) (*types.SystemContext, error) {
+	var sys types.SystemContext
+	if c.SystemContext != nil {
+		sys = *c.SystemContext
+	}
+	sys.OSChoice = osType.String()
+	sys.ArchitectureChoice = c.architecture()
+	if c.Credentials.AuthFile != "" {
+		sys.AuthFilePath = c.Credentials.AuthFile
+	}
+	auth, err := c.Credentials.authConfig(ImageRegistry(image))
+	if err != nil {
+		return nil, err
+	}
+	if auth != nil {
+		sys.DockerAuthConfig = auth
+	}
+	if c.TLS != nil {
+		if err := c.TLS.apply(&sys, ImageRegistry(image)); err != nil {
+			return nil, err
+		}
+	}
+	return &sys, nil
+}
+
+// architecture returns the architecture whose images are selected in manifest lists, the architecture of the client
+// unless its system context chooses another one.
+func (c RegistryClient) architecture() string {
+	if c.SystemContext != nil && c.SystemContext.ArchitectureChoice != "" {
+		return c.SystemContext.ArchitectureChoice
+	}
+	return c.Arch.String()
+}
+
+// LookupImages sets the digest and compressed size of the entries of list from their registries. The images that
+// cannot be looked up are logged and left without details, so a registry being unavailable does not fail an export.
+func (c RegistryClient) LookupImages(ctx context.Context, list ImageList) {
+	var mu sync.Mutex
+	var errs ImageErrors
+	c.forEach(list, func(entry *ImageEntry) {
+		details, err := c.Inspect(ctx, entry.Image, entry.OS)
+		if err != nil {
+			imageErr := &ImageError{Image: entry.Image, OS: entry.OS, Err: err}
+			logrus.Warnf("skipping registry lookup (%s): %v", imageErr.Class(), err)
+			mu.Lock()
+			errs = append(errs, imageErr)
+			mu.Unlock()
+			return
+		}
+		entry.Digest = details.Digest
+		entry.CompressedSize = details.CompressedSize
+	})
+	if len(errs) > 0 {
+		logrus.Warnf("%d of %d images could not be looked up: %s", len(errs), len(list), errs.ClassSummary())
+	}
+}
+
+// PinnedImage returns image pinned to manifestDigest, e.g. rancher/shell:v0.1.22@sha256:..., keeping its tag for
+// readability. The digest image may already have is replaced.
+func PinnedImage(image, manifestDigest string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	return image + "@" + manifestDigest
+}
+
+// PinDigests returns a copy of the list with every image pinned to its digest, see PinnedImage, so the list is immune
+// to tags being moved between the export and the mirroring of the images. The digests must have been looked up with
+// LookupImages, ImageErrors are returned for the images without digest.
+func (l ImageList) PinDigests() (ImageList, error) {
+	pinned := make(ImageList, 0, len(l))
+	var errs ImageErrors
+	for _, entry := range l {
+		if entry.Digest == "" {
+			errs = append(errs, &ImageError{Image: entry.Image, OS: entry.OS, Err: errors.New("digest was not found")})
+			continue
+		}
+		entry.Image = PinnedImage(entry.Image, entry.Digest)
+		pinned = append(pinned, entry)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	sortImageList(pinned)
+	return pinned, nil
+}
+
+// Validate checks that the image of every entry of list exists in its registry, without downloading the manifests.
+// It returns ImageErrors with the images that could not be found, so that missing tags are caught before a release.
+func (c RegistryClient) Validate(ctx context.Context, list ImageList) error {
+	var mu sync.Mutex
+	var errs ImageErrors
+	c.forEach(list, func(entry *ImageEntry) {
+		if _, err := c.Digest(ctx, entry.Image, entry.OS); err != nil {
+			mu.Lock()
+			errs = append(errs, &ImageError{Image: entry.Image, OS: entry.OS, Err: err})
+			mu.Unlock()
+		}
+	})
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return lessImageEntry(ImageEntry{Image: errs[i].Image, OS: errs[i].OS}, ImageEntry{Image: errs[j].Image, OS: errs[j].OS})
+	})
+	return errs
+}
+
+// forEach calls f for every entry of list, with Workers entries handled concurrently.
+func (c RegistryClient) forEach(list ImageList, f func(entry *ImageEntry)) {
+	workers := c.Workers
+	if workers <= 0 {
+		workers = defaultLookupWorkers
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				f(&list[index])
+			}
+		}()
+	}
+	for i := range list {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,113 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	"github.com/rancher/rancher/pkg/image/internal/imagetest"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry is the registry of the tests of RegistryClient and ImageCopier, see imagetest.Registry.
+type fakeRegistry struct {
+	*imagetest.Registry
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	return &fakeRegistry{imagetest.New(t)}
+}
+
+// client returns a RegistryClient trusting the certificate of the registry.
+func (r *fakeRegistry) client() RegistryClient {
+	return RegistryClient{SystemContext: &types.SystemContext{DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}}
+}
+
+// schema2Manifest returns a docker schema2 manifest with layers of the given sizes.
+func schema2Manifest(layerSizes ...int64) []byte {
+	layers := make([]string, 0, len(layerSizes))
+	for _, size := range layerSizes {
+		layers = append(layers, fmt.Sprintf(`{"mediaType":"application/vnd.docker.image.rootfs.diff.tar.gzip","size":%d,"digest":"sha256:%064x"}`, size, size))
+	}
+	return []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",`+
+		`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":2,"digest":"sha256:%064x"},"layers":[%s]}`,
+		0, strings.Join(layers, ",")))
+}
+
+const schema2MediaType = imagetest.Schema2MediaType
+
+func TestRegistryClientLookupImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	manifestDigest := registry.AddManifest("rancher/shell", "v0.1.22", schema2MediaType, schema2Manifest(100, 200))
+
+	list := ImageList{
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.21", OS: Linux},
+	}
+	registry.client().LookupImages(context.Background(), list)
+	assert.Equal(manifestDigest, list[0].Digest)
+	assert.Equal(int64(300), list[0].CompressedSize)
+	assert.Empty(list[1].Digest)
+}
+
+func TestRegistryClientValidate(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	registry.AddManifest("rancher/shell", "v0.1.22", schema2MediaType, schema2Manifest(100))
+
+	client := registry.client()
+	assert.NoError(client.Validate(context.Background(), ImageList{{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux}}))
+
+	err := client.Validate(context.Background(), ImageList{
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.21", OS: Linux},
+	})
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal(registry.Host()+"/rancher/shell:v0.1.21", imageErrs[0].Image)
+	}
+}
+
+func TestImageListPinDigests(t *testing.T) {
+	assert := assertlib.New(t)
+
+	manifestDigest := "sha256:" + strings.Repeat("0", 64)
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Digest: manifestDigest},
+		{Image: "rancher/shell:v0.1.21@sha256:" + strings.Repeat("1", 64), OS: Linux, Digest: manifestDigest},
+	}
+	pinned, err := list.PinDigests()
+	assert.NoError(err)
+	assert.Equal([]string{"rancher/shell:v0.1.21@" + manifestDigest, "rancher/shell:v0.1.22@" + manifestDigest}, pinned.Images())
+	assert.Equal("rancher/shell:v0.1.22", list[0].Image)
+
+	_, err = append(list, ImageEntry{Image: "rancher/shell:v0.1.20", OS: Linux}).PinDigests()
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal("rancher/shell:v0.1.20", imageErrs[0].Image)
+	}
+}
+
+func TestRegistryClientEstimateSize(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	registry.AddManifest("rancher/shell", "v0.1.22", schema2MediaType, schema2Manifest(100, 200))
+	registry.AddManifest("rancher/shell", "v0.1.21", schema2MediaType, schema2Manifest(100, 300))
+
+	estimates := registry.client().EstimateSize(context.Background(), ImageList{
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.21", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.20", OS: Linux},
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Windows},
+	})
+	assert.Equal([]SizeEstimate{
+		{OS: Linux, Architecture: "amd64", Images: 2, CompressedSize: 600, Failed: []string{registry.Host() + "/rancher/shell:v0.1.20"}},
+		{OS: Windows, Architecture: "amd64", Images: 1, CompressedSize: 300},
+	}, estimates)
+}
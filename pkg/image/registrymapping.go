@@ -0,0 +1,79 @@
+package image
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	img "github.com/rancher/rke/types/image"
+)
+
+// RegistryMapping rewrites the images of an export whose repository starts with one of its prefixes, replacing the
+// prefix with the value it maps to. The longest matching prefix is used. This allows exporting the images of an
+// alternate registry namespace, e.g. for Rancher Prime, without post-processing the image lists.
+type RegistryMapping map[string]string
+
+// PrimeRegistryMapping maps the Rancher owned images published under rancher/ on Docker Hub to the Rancher Prime
+// registry.
+var PrimeRegistryMapping = RegistryMapping{
+	"rancher/": "registry.rancher.com/rancher/",
+}
+
+// Map returns image with its longest matching prefix replaced, or image itself if no prefix matches.
+func (m RegistryMapping) Map(image string) string {
+	var longest string
+	for prefix := range m {
+		if strings.HasPrefix(image, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return image
+	}
+	return m[longest] + strings.TrimPrefix(image, longest)
+}
+
+// ParseRegistryMapping parses mappings given as PREFIX=REPLACEMENT, e.g. rancher/=registry.example.com/rancher/.
+func ParseRegistryMapping(mappings []string) (RegistryMapping, error) {
+	registryMapping := make(RegistryMapping, len(mappings))
+	for _, mapping := range mappings {
+		prefix, replacement, ok := strings.Cut(mapping, "=")
+		if !ok || prefix == "" {
+			return nil, errors.Errorf("invalid registry mapping %q, must be PREFIX=REPLACEMENT", mapping)
+		}
+		registryMapping[prefix] = replacement
+	}
+	return registryMapping, nil
+}
+
+// String returns the mapping in the PREFIX=REPLACEMENT format, sorted by prefix.
+func (m RegistryMapping) String() string {
+	mappings := make([]string, 0, len(m))
+	for prefix, replacement := range m {
+		mappings = append(mappings, prefix+"="+replacement)
+	}
+	sort.Strings(mappings)
+	return strings.Join(mappings, ",")
+}
+
+// mapRegistries renames the images of imagesSet according to mapping. The mapped images are recorded in the mirrors of
+// the rke types with the source of the image they replace, so they are part of the saved image lists.
+func mapRegistries(imagesSet *ImageSet, mapping RegistryMapping) {
+	if len(mapping) == 0 {
+		return
+	}
+	for _, osType := range imagesSet.OSTypes() {
+		for _, image := range imagesSet.Images(osType) {
+			mapped := mapping.Map(image)
+			if mapped == image {
+				continue
+			}
+			source, ok := img.Mirrors[image]
+			if !ok {
+				source = image
+			}
+			img.Mirrors[mapped] = source
+			imagesSet.Rename(image, mapped)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package image
+
+import (
+	"testing"
+
+	img "github.com/rancher/rke/types/image"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestRegistryMappingMap(t *testing.T) {
+	mapping := RegistryMapping{
+		"rancher/":               "registry.rancher.com/rancher/",
+		"rancher/mirrored-":      "registry.suse.com/rancher/mirrored-",
+		"docker.io/library/bci/": "registry.suse.com/bci/",
+	}
+	assertlib.Equal(t, "registry.rancher.com/rancher/shell:v0.1.20", mapping.Map("rancher/shell:v0.1.20"))
+	assertlib.Equal(t, "registry.suse.com/rancher/mirrored-pause:3.6", mapping.Map("rancher/mirrored-pause:3.6"))
+	assertlib.Equal(t, "quay.io/coreos/etcd:v3.4.3", mapping.Map("quay.io/coreos/etcd:v3.4.3"))
+}
+
+func TestParseRegistryMapping(t *testing.T) {
+	mapping, err := ParseRegistryMapping([]string{"rancher/=registry.example.com/rancher/"})
+	assertlib.NoError(t, err)
+	assertlib.Equal(t, RegistryMapping{"rancher/": "registry.example.com/rancher/"}, mapping)
+	assertlib.Equal(t, "rancher/=registry.example.com/rancher/", mapping.String())
+
+	_, err = ParseRegistryMapping([]string{"rancher/"})
+	assertlib.Error(t, err)
+}
+
+func TestMapRegistries(t *testing.T) {
+	imagesSet := NewImageSet(Linux)
+	imagesSet.Add(Linux, "rancher/shell:v0.1.20", "core")
+	imagesSet.Add(Linux, "quay.io/coreos/etcd:v3.4.3", "system")
+	img.Mirrors["rancher/shell:v0.1.20"] = "rancher/shell:v0.1.20"
+	mapRegistries(imagesSet, PrimeRegistryMapping)
+	assertlib.Equal(t, []string{"quay.io/coreos/etcd:v3.4.3", "registry.rancher.com/rancher/shell:v0.1.20"}, imagesSet.Images(Linux))
+	assertlib.Equal(t, "rancher/shell:v0.1.20", img.Mirrors["registry.rancher.com/rancher/shell:v0.1.20"])
+}
@@ -0,0 +1,78 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+)
+
+// errImageListNotPublished is returned by downloadImageList for the image lists a release does not publish, e.g. the
+// arm64 image lists of the releases that predate arm64 support.
+var errImageListNotPublished = errors.New("image list not published")
+
+// kdmReleases returns the versions of the releases of the KDM release data of a distribution, e.g. the "rke2" entry of
+// data.json, that rancherVersion supports according to their minChannelServerVersion and maxChannelServerVersion,
+// leaving out the releases older than minVersion. Releases without both channel server versions are skipped.
+func kdmReleases(ctx context.Context, rancherVersion string, data map[string]interface{}, minVersion *semver.Version) []string {
+	releases, _ := data["releases"].([]interface{})
+	var versions []string
+	for _, release := range releases {
+		releaseMap, _ := release.(map[string]interface{})
+		version, _ := releaseMap["version"].(string)
+		if version == "" {
+			continue
+		}
+		if releaseVersion, err := semver.NewVersion(version); err != nil || releaseVersion.LessThan(minVersion) {
+			continue
+		}
+		minChannelServerVersion, _ := releaseMap["minChannelServerVersion"].(string)
+		maxChannelServerVersion, _ := releaseMap["maxChannelServerVersion"].(string)
+		if minChannelServerVersion == "" || maxChannelServerVersion == "" {
+			continue
+		}
+		constraintStr := minMaxToConstraintStr(strings.TrimPrefix(minChannelServerVersion, "v"), strings.TrimPrefix(maxChannelServerVersion, "v"))
+		if ok, err := compareRancherVersionToConstraint(ctx, rancherVersion, constraintStr); err != nil || !ok {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+// releaseTag returns the image tag of a release version: registries do not allow "+", so it is replaced by "-", e.g.
+// v1.27.10+rke2r1 is tagged v1.27.10-rke2r1.
+func releaseTag(version string) string {
+	return strings.ReplaceAll(version, "+", "-")
+}
+
+// downloadImageList downloads the image list published at url, one image per line, dropping the docker.io/ prefix of
+// the images so that they match the images of the other sources. It returns errImageListNotPublished if there is no
+// image list at url. The image list is downloaded through the download cache of ctx, if any, see WithDownloadCache.
+func downloadImageList(ctx context.Context, url string) ([]string, error) {
+	content, err := downloadCacheFromContext(ctx).Download(ctx, url)
+	var statusErr *DownloadStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, errors.Wrap(errImageListNotPublished, url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var images []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		image := strings.TrimSpace(scanner.Text())
+		if image == "" || strings.HasPrefix(image, "#") {
+			continue
+		}
+		images = append(images, strings.TrimPrefix(image, "docker.io/"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", url)
+	}
+	return images, nil
+}
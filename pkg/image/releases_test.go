@@ -0,0 +1,61 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestKDMReleases(t *testing.T) {
+	assert := assertlib.New(t)
+
+	data := map[string]interface{}{
+		"releases": []interface{}{
+			map[string]interface{}{"version": "v1.20.15+rke2r2", "minChannelServerVersion": "v2.6.0-alpha1", "maxChannelServerVersion": "v2.6.99"},
+			map[string]interface{}{"version": "v1.26.11+rke2r1", "minChannelServerVersion": "v2.7.0-alpha1", "maxChannelServerVersion": "v2.8.99"},
+			map[string]interface{}{"version": "v1.27.10+rke2r1", "minChannelServerVersion": "v2.8.0-alpha1", "maxChannelServerVersion": "v2.8.99"},
+			map[string]interface{}{"version": "v1.28.6+rke2r1", "minChannelServerVersion": "v2.9.0-alpha1", "maxChannelServerVersion": "v2.9.99"},
+			map[string]interface{}{"version": "v1.27.11+rke2r1", "minChannelServerVersion": "v2.8.0-alpha1"},
+			map[string]interface{}{"minChannelServerVersion": "v2.8.0-alpha1", "maxChannelServerVersion": "v2.8.99"},
+		},
+	}
+	minVersion := semver.MustParse("v1.21.0")
+	assert.Equal([]string{"v1.26.11+rke2r1", "v1.27.10+rke2r1"}, kdmReleases(context.Background(), "2.8.2", data, minVersion))
+	// Development versions support the releases of their minor version
+	assert.Equal([]string{"v1.26.11+rke2r1", "v1.27.10+rke2r1"}, kdmReleases(context.Background(), "2.8.99", data, minVersion))
+	assert.Equal([]string{"v1.28.6+rke2r1"}, kdmReleases(context.Background(), "2.9.0", data, minVersion))
+	assert.Nil(kdmReleases(context.Background(), "2.8.2", nil, minVersion))
+}
+
+func TestDownloadImageList(t *testing.T) {
+	assert := assertlib.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/images.txt":
+			_, _ = rw.Write([]byte("docker.io/rancher/rke2-runtime:v1.27.10-rke2r1\n\n# comment\nquay.io/tigera/operator:v1.32.3\n"))
+		case "/error.txt":
+			rw.WriteHeader(http.StatusInternalServerError)
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	images, err := downloadImageList(context.Background(), server.URL+"/images.txt")
+	assert.NoError(err)
+	assert.Equal([]string{"rancher/rke2-runtime:v1.27.10-rke2r1", "quay.io/tigera/operator:v1.32.3"}, images)
+	_, err = downloadImageList(context.Background(), server.URL+"/missing.txt")
+	assert.ErrorIs(err, errImageListNotPublished)
+	_, err = downloadImageList(context.Background(), server.URL+"/error.txt")
+	assert.Error(err)
+	assert.NotErrorIs(err, errImageListNotPublished)
+}
+
+func TestReleaseTag(t *testing.T) {
+	assertlib.Equal(t, "v1.27.10-rke2r1", releaseTag("v1.27.10+rke2r1"))
+}
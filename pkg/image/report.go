@@ -0,0 +1,176 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteMarkdownReport writes a markdown report of list to w for release notes: the totals per OS, the images of each
+// chart version, the images of the other sources, the warnings of the chart scan and the policy violations if any,
+// and, if previous is not nil, the changes since the image list of the previous release.
+func WriteMarkdownReport(w io.Writer, list ImageList, previous ImageList, metadata ExportMetadata) error {
+	mw := &markdownWriter{w: w}
+
+	title := "Rancher images"
+	if len(metadata.RancherVersions) > 0 {
+		title += " " + strings.Join(metadata.RancherVersions, ", ")
+	}
+	mw.printf("# %s\n\n", title)
+
+	mw.printf("| OS | Images | Required | Optional |\n")
+	mw.printf("|----|--------|----------|----------|\n")
+	for _, osType := range []OSType{Linux, Windows} {
+		osList := list.ForOS(osType)
+		if len(osList) == 0 {
+			continue
+		}
+		mw.printf("| %s | %d | %d | %d |\n", osType, len(osList), len(osList.Required()), len(osList.Optional()))
+	}
+
+	charts, others := reportGroups(list)
+	if len(charts) > 0 {
+		mw.printf("\n## Charts\n")
+		for _, name := range sortedKeys(charts) {
+			mw.printf("\n### %s\n", name)
+			for _, version := range sortedKeys(charts[name]) {
+				mw.printf("\n#### %s\n\n", version)
+				mw.images(sortedKeys(charts[name][version]))
+			}
+		}
+	}
+	if len(others) > 0 {
+		mw.printf("\n## Other sources\n")
+		for _, source := range sortedKeys(others) {
+			mw.printf("\n### %s\n\n", source)
+			mw.images(sortedKeys(others[source]))
+		}
+	}
+
+	if len(metadata.ChartWarnings) > 0 {
+		mw.printf("\n## Warnings\n\n")
+		for _, warning := range metadata.ChartWarnings {
+			mw.printf("- %s\n", reportWarning(warning))
+		}
+	}
+
+	if len(metadata.PolicyViolations) > 0 {
+		mw.printf("\n## Policy violations\n\n")
+		for _, violation := range metadata.PolicyViolations {
+			mw.printf("- %s\n", reportPolicyViolation(violation))
+		}
+	}
+
+	if previous != nil {
+		mw.printf("\n## Changes since the previous release\n")
+		diff := DiffImageLists(previous, list)
+		if diff.Empty() {
+			mw.printf("\nNo changes.\n")
+		}
+		if len(diff.Added) > 0 {
+			mw.printf("\n### Added\n\n")
+			mw.images(reportImages(diff.Added))
+		}
+		if len(diff.Removed) > 0 {
+			mw.printf("\n### Removed\n\n")
+			mw.images(reportImages(diff.Removed))
+		}
+		if len(diff.Retagged) > 0 {
+			mw.printf("\n### Updated\n\n")
+			for _, retagged := range diff.Retagged {
+				mw.printf("- %s → %s\n", reportImage(retagged.Old), reportImage(retagged.New))
+			}
+		}
+	}
+	return mw.err
+}
+
+// reportGroups groups the images of list by chart name and version, and the images that are not from a chart by
+// source.
+func reportGroups(list ImageList) (map[string]map[string]map[string]struct{}, map[string]map[string]struct{}) {
+	charts := make(map[string]map[string]map[string]struct{})
+	others := make(map[string]map[string]struct{})
+	for _, entry := range list {
+		image := reportImage(entry)
+		for _, chart := range entry.Charts {
+			name := chartName(chart)
+			version := strings.TrimPrefix(chart, name+":")
+			if charts[name] == nil {
+				charts[name] = make(map[string]map[string]struct{})
+			}
+			if charts[name][version] == nil {
+				charts[name][version] = make(map[string]struct{})
+			}
+			charts[name][version][image] = struct{}{}
+		}
+		if len(entry.Charts) > 0 {
+			continue
+		}
+		for _, source := range diffSources(entry) {
+			if others[source] == nil {
+				others[source] = make(map[string]struct{})
+			}
+			others[source][image] = struct{}{}
+		}
+	}
+	return charts, others
+}
+
+// markdownWriter writes markdown to w, keeping the first error so it only has to be checked once.
+type markdownWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (mw *markdownWriter) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, args...)
+}
+
+// images writes a bullet list of images, in the format of reportImage.
+func (mw *markdownWriter) images(images []string) {
+	sort.Strings(images)
+	for _, image := range images {
+		mw.printf("- %s\n", image)
+	}
+}
+
+// reportImage returns how the image of entry is listed in the report, with its OS if it is not Linux.
+func reportImage(entry ImageEntry) string {
+	if entry.OS != Linux {
+		return fmt.Sprintf("`%s` (%s)", entry.Image, entry.OS)
+	}
+	return fmt.Sprintf("`%s`", entry.Image)
+}
+
+func reportImages(list ImageList) []string {
+	images := make([]string, 0, len(list))
+	for _, entry := range list {
+		images = append(images, reportImage(entry))
+	}
+	return images
+}
+
+// reportWarning returns how a chart warning is listed in the report.
+func reportPolicyViolation(violation PolicyViolation) string {
+	message := fmt.Sprintf("%s: %s image `%s`", violation.Action, violation.OS, violation.Image)
+	if len(violation.Sources) > 0 {
+		message += " from " + strings.Join(violation.Sources, ", ")
+	}
+	message += fmt.Sprintf(", %s rule `%s`", violation.Policy, violation.Rule)
+	if violation.Reason != "" {
+		message += ": " + violation.Reason
+	}
+	return message
+}
+
+func reportWarning(warning ChartWarning) string {
+	image := fmt.Sprintf("`%s`", warning.Image)
+	if warning.ValuesPath != "" {
+		image += fmt.Sprintf(" (%s)", warning.ValuesPath)
+	}
+	return fmt.Sprintf("chart %s, image %s: %s", warning.Chart, image, warning.Message)
+}
@@ -0,0 +1,100 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestWriteMarkdownReport(t *testing.T) {
+	assert := assertlib.New(t)
+
+	previous := ImageList{
+		{Image: "rancher/fleet:v0.7.0", OS: Linux},
+		{Image: "rancher/shell:v0.1.21", OS: Linux},
+	}
+	list := ImageList{
+		{Image: "rancher/fleet:v0.8.0", Sources: []string{"fleet:102.2.0"}, OS: Linux, Charts: []string{"fleet:102.2.0"}},
+		{Image: "rancher/mirrored-prometheus:v2.45.0", Sources: []string{"rancher-monitoring:102.0.0"}, OS: Linux, Charts: []string{"rancher-monitoring:102.0.0"}, Optional: true},
+		{Image: "rancher/shell:v0.1.21", Sources: []string{"system"}, OS: Linux},
+		{Image: "rancher/wins:v0.4.12", Sources: []string{"system"}, OS: Windows},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(WriteMarkdownReport(&buf, list, previous, ExportMetadata{RancherVersions: []string{"v2.8.0"}}))
+	assert.Equal("# Rancher images v2.8.0\n"+
+		"\n"+
+		"| OS | Images | Required | Optional |\n"+
+		"|----|--------|----------|----------|\n"+
+		"| linux | 3 | 2 | 1 |\n"+
+		"| windows | 1 | 1 | 0 |\n"+
+		"\n"+
+		"## Charts\n"+
+		"\n"+
+		"### fleet\n"+
+		"\n"+
+		"#### 102.2.0\n"+
+		"\n"+
+		"- `rancher/fleet:v0.8.0`\n"+
+		"\n"+
+		"### rancher-monitoring\n"+
+		"\n"+
+		"#### 102.0.0\n"+
+		"\n"+
+		"- `rancher/mirrored-prometheus:v2.45.0`\n"+
+		"\n"+
+		"## Other sources\n"+
+		"\n"+
+		"### system\n"+
+		"\n"+
+		"- `rancher/shell:v0.1.21`\n"+
+		"- `rancher/wins:v0.4.12` (windows)\n"+
+		"\n"+
+		"## Changes since the previous release\n"+
+		"\n"+
+		"### Added\n"+
+		"\n"+
+		"- `rancher/mirrored-prometheus:v2.45.0`\n"+
+		"- `rancher/wins:v0.4.12` (windows)\n"+
+		"\n"+
+		"### Updated\n"+
+		"\n"+
+		"- `rancher/fleet:v0.7.0` → `rancher/fleet:v0.8.0`\n", buf.String())
+}
+
+func TestWriteMarkdownReportChartWarnings(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{{Image: "rancher/fleet:v0.8.0", Sources: []string{"fleet:102.2.0"}, OS: Linux, Charts: []string{"fleet:102.2.0"}}}
+	metadata := ExportMetadata{ChartWarnings: []ChartWarning{
+		{Chart: "fleet:102.2.0", Image: "rancher/fleet:v0.8.0", ValuesPath: "image", Message: `ignoring unknown os "linx" of field 'os:'`},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(WriteMarkdownReport(&buf, list, nil, metadata))
+	assert.Contains(buf.String(), "\n## Warnings\n"+
+		"\n"+
+		"- chart fleet:102.2.0, image `rancher/fleet:v0.8.0` (image): ignoring unknown os \"linx\" of field 'os:'\n")
+}
+
+func TestWriteMarkdownReportPolicyViolations(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{{Image: "rancher/mirrored-library-nginx:1.21.1", Sources: []string{"rancher-monitoring:102.0.0"}, OS: Linux}}
+	metadata := ExportMetadata{PolicyViolations: PolicyViolations{{
+		Policy:  DenyListPolicy,
+		Image:   "rancher/mirrored-library-nginx:1.21.1",
+		OS:      Linux,
+		Sources: []string{"rancher-monitoring:102.0.0"},
+		Rule:    "rancher/mirrored-library-nginx:1.21.*",
+		Reason:  "CVE-2023-44487",
+		Action:  PolicyActionWarn,
+	}}}
+
+	var buf bytes.Buffer
+	assert.NoError(WriteMarkdownReport(&buf, list, nil, metadata))
+	assert.Contains(buf.String(), "\n## Policy violations\n"+
+		"\n"+
+		"- warn: linux image `rancher/mirrored-library-nginx:1.21.1` from rancher-monitoring:102.0.0, deny-list rule `rancher/mirrored-library-nginx:1.21.*`: CVE-2023-44487\n")
+}
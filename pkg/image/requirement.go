@@ -0,0 +1,192 @@
+package image
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/rancher/rancher/pkg/settings"
+)
+
+// RequirementImage is a core image Rancher needs to run on an OS type, regardless of charts and Kubernetes versions.
+type RequirementImage struct {
+	// Image is the image reference, e.g. rancher/shell:v0.1.22.
+	Image string `yaml:"image"`
+	// Arches restricts the image to some architectures, e.g. arm64 for an arm64 only variant of an image. The image is
+	// required on every architecture if empty.
+	Arches []Arch `yaml:"arch"`
+}
+
+// imageSettingOSTypes are the OS types the images of the image settings are used on, keyed by setting name. The images
+// of the other image settings are Linux images.
+var imageSettingOSTypes = map[string][]OSType{
+	settings.AgentImage.Name:            {Linux, Windows},
+	settings.WinsAgentUpgradeImage.Name: {Windows},
+}
+
+// DefaultRequirementImages returns the core images Rancher needs to run on osType for rancherVersion: the busybox
+// images on Linux, along with the images of the image settings used on osType, see SettingImages.
+func DefaultRequirementImages(rancherVersion string, osType OSType) []RequirementImage {
+	var images []RequirementImage
+	if osType == Linux {
+		images = append(images,
+			RequirementImage{Image: "rancher/mirrored-bci-busybox:15.4.11.2"},
+			RequirementImage{Image: "rancher/mirrored-bci-micro:15.4.14.3"},
+		)
+	}
+	for _, image := range SettingImages(rancherVersion, osType) {
+		images = append(images, RequirementImage{Image: image})
+	}
+	return images
+}
+
+// SettingImages returns the images of the Rancher settings whose value is an image, see settings.ImageSettings, that
+// are used on osType, e.g. the shell image on Linux, so that the images of new settings are exported without changes
+// here. The settings default to their CATTLE_ environment variables like in the Rancher images, and the images of unset
+// settings are left out.
+func SettingImages(rancherVersion string, osType OSType) []string {
+	var images []string
+	for _, setting := range settings.ImageSettings() {
+		osTypes, ok := imageSettingOSTypes[setting.Name]
+		if !ok {
+			osTypes = []OSType{Linux}
+		}
+		if !containsOSType(osTypes, osType) {
+			continue
+		}
+		if image := settingImage(setting, rancherVersion); image != "" {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// settingImage returns the image of the image setting for rancherVersion. The settings whose default is not the image
+// of a release, e.g. the head agent image, are resolved for rancherVersion.
+func settingImage(setting settings.Setting, rancherVersion string) string {
+	switch setting.Name {
+	case settings.AgentImage.Name:
+		return agentImage(rancherVersion)
+	case settings.SystemAgentUpgradeImage.Name:
+		return systemAgentUpgradeImage()
+	}
+	return settingValue(setting)
+}
+
+// agentImage returns the rancher-agent image of rancherVersion: the agent-image setting if its environment variable is
+// set, the rancher-agent image tagged with rancherVersion for released versions, and the default head image of the
+// setting for development versions, e.g. 2.8.99.
+func agentImage(rancherVersion string) string {
+	if image, ok := lookupSettingEnv(settings.AgentImage); ok {
+		return image
+	}
+	if version, err := semver.NewVersion(rancherVersion); err == nil && version.Patch() != 99 {
+		return "rancher/rancher-agent:v" + strings.TrimPrefix(rancherVersion, "v")
+	}
+	return settings.AgentImage.Get()
+}
+
+// ParseRequirementImages parses requirement images given as OS[/ARCH]=IMAGE, e.g.
+// linux/arm64=rancher/shell:v0.1.22-arm64 or windows=rancher/wins:v0.4.12, keyed by OS type.
+func ParseRequirementImages(values []string) (map[OSType][]RequirementImage, error) {
+	images := make(map[OSType][]RequirementImage, len(values))
+	for _, value := range values {
+		platform, image, ok := strings.Cut(value, "=")
+		if !ok || strings.TrimSpace(image) == "" {
+			return nil, errors.Errorf("invalid requirement image %q, expected OS[/ARCH]=IMAGE", value)
+		}
+		osName, archName, hasArch := strings.Cut(platform, "/")
+		osType, err := ParseOSType(osName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid requirement image %q", value)
+		}
+		requirement := RequirementImage{Image: strings.TrimSpace(image)}
+		if hasArch {
+			arch, err := ParseArch(archName)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid requirement image %q", value)
+			}
+			requirement.Arches = []Arch{arch}
+		}
+		images[osType] = append(images[osType], requirement)
+	}
+	return images, nil
+}
+
+// requiredCharts are the charts Rancher installs by itself to run and to provision clusters, whose images are always
+// required. The images of any other chart, e.g. rancher-monitoring, rancher-istio, rancher-logging or
+// rancher-cis-benchmark, are only needed when the chart is installed.
+var requiredCharts = map[string]struct{}{
+	"fleet":                     {},
+	"fleet-crd":                 {},
+	"fleet-agent":               {},
+	"rancher-webhook":           {},
+	"rancher-provisioning-capi": {},
+	"system-upgrade-controller": {},
+	"rancher-aks-operator":      {},
+	"rancher-aks-operator-crd":  {},
+	"rancher-eks-operator":      {},
+	"rancher-eks-operator-crd":  {},
+	"rancher-gke-operator":      {},
+	"rancher-gke-operator-crd":  {},
+	"rancher-vsphere-cpi":       {},
+	"rancher-vsphere-csi":       {},
+}
+
+// optionalSources are the source labels, other than charts, of images that are only needed when something optional
+// is installed.
+var optionalSources = map[string]struct{}{
+	"ui-extension": {},
+}
+
+// isOptionalImage returns true if every source of an image is an optional chart or an optional source, i.e. the image
+// is not needed unless one of them is installed. sources and charts are sorted, charts being the sources of the image
+// that are charts.
+func isOptionalImage(sources, charts []string) bool {
+	if len(sources) == 0 {
+		return false
+	}
+	for _, source := range sources {
+		if i := sort.SearchStrings(charts, source); i < len(charts) && charts[i] == source {
+			if _, ok := requiredCharts[chartName(source)]; ok {
+				return false
+			}
+			continue
+		}
+		if _, ok := optionalSources[source]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// chartName returns the name of a chart given in name:version format.
+func chartName(chartNameAndVersion string) string {
+	if i := strings.LastIndex(chartNameAndVersion, ":"); i >= 0 {
+		return chartNameAndVersion[:i]
+	}
+	return chartNameAndVersion
+}
+
+// Required returns the entries of the list that are required to run Rancher and provision clusters.
+func (l ImageList) Required() ImageList {
+	var list ImageList
+	for _, entry := range l {
+		if !entry.Optional {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// Optional returns the entries of the list that are only needed when optional charts or UI extensions are installed.
+func (l ImageList) Optional() ImageList {
+	var list ImageList
+	for _, entry := range l {
+		if entry.Optional {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
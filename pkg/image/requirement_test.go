@@ -0,0 +1,76 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/rancher/rancher/pkg/settings"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestImageSetOptionalImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Linux)
+	imagesSet.AddChartImage(Linux, "rancher/mirrored-prometheus:v2.42.0", "rancher-monitoring:102.0.0", "")
+	imagesSet.AddChartImage(Linux, "rancher/fleet:v0.7.0", "fleet:102.1.0", "")
+	imagesSet.AddChartImage(Linux, "rancher/shell:v0.1.20", "rancher-monitoring:102.0.0", "")
+	imagesSet.Add(Linux, "rancher/shell:v0.1.20", "core")
+	imagesSet.Add(Linux, "rancher/kubewarden-ui:v1.0.0", "ui-extension")
+
+	list := imagesSet.List(Linux)
+	assert.Equal([]string{"rancher/fleet:v0.7.0", "rancher/shell:v0.1.20"}, list.Required().Images())
+	assert.Equal([]string{"rancher/kubewarden-ui:v1.0.0", "rancher/mirrored-prometheus:v2.42.0"}, list.Optional().Images())
+}
+
+func TestSupersetImageListOptional(t *testing.T) {
+	superset := SupersetImageList(map[string]ImageList{
+		"2.7.1": {{Image: "rancher/shell:v0.1.18", OS: Linux, Optional: true}},
+		"2.7.2": {{Image: "rancher/shell:v0.1.18", OS: Linux}},
+		"2.7.3": {{Image: "rancher/istio:v1.17.2", OS: Linux, Optional: true}},
+	})
+	assertlib.Equal(t, []string{"rancher/istio:v1.17.2"}, superset.Optional().Images())
+}
+
+func TestParseRequirementImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	images, err := ParseRequirementImages([]string{"linux/arm64=rancher/shell:v0.1.22-arm64", "Windows=rancher/wins:v0.4.12"})
+	assert.NoError(err)
+	assert.Equal(map[OSType][]RequirementImage{
+		Linux:   {{Image: "rancher/shell:v0.1.22-arm64", Arches: []Arch{ARM64}}},
+		Windows: {{Image: "rancher/wins:v0.4.12"}},
+	}, images)
+
+	for _, value := range []string{"rancher/wins:v0.4.12", "windows=", "freebsd=rancher/shell:v0.1.22", "linux/mips64le=rancher/shell:v0.1.22"} {
+		_, err := ParseRequirementImages([]string{value})
+		assert.Error(err, value)
+	}
+}
+
+func TestSettingImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	t.Setenv(settings.GetEnvKey(settings.ShellImage.Name), "rancher/shell:v0.1.23")
+	t.Setenv(settings.GetEnvKey(settings.MachineProvisionImage.Name), "rancher/machine:v0.15.0-rancher106")
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentUpgradeImage.Name), "")
+	t.Setenv(settings.GetEnvKey(settings.SystemAgentVersion.Name), "v0.3.4")
+	t.Setenv(settings.GetEnvKey(settings.WinsAgentUpgradeImage.Name), "rancher/wins:v0.4.12")
+	assert.Equal([]string{
+		"rancher/rancher-agent:v2.8.2",
+		settings.AuthImage.Get(),
+		"rancher/machine:v0.15.0-rancher106",
+		"rancher/shell:v0.1.23",
+		"rancher/system-agent:v0.3.4-suc",
+	}, SettingImages("2.8.2", Linux))
+	assert.Equal([]string{"rancher/rancher-agent:v2.8.2", "rancher/wins:v0.4.12"}, SettingImages("2.8.2", Windows))
+}
+
+func TestAgentImage(t *testing.T) {
+	assert := assertlib.New(t)
+
+	assert.Equal("rancher/rancher-agent:v2.8.2", agentImage("2.8.2"))
+	assert.Equal("rancher/rancher-agent:v2.8.2", agentImage("v2.8.2"))
+	assert.Equal(settings.AgentImage.Get(), agentImage("2.8.99"))
+	t.Setenv(settings.GetEnvKey(settings.AgentImage.Name), "example.com/rancher-agent:v2.8.2")
+	assert.Equal("example.com/rancher-agent:v2.8.2", agentImage("2.8.2"))
+}
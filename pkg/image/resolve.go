@@ -1,9 +1,8 @@
 package image
 
 import (
-	"fmt"
+	"context"
 	"path"
-	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
@@ -11,9 +10,7 @@
 	util "github.com/rancher/rancher/pkg/cluster"
 	v1 "github.com/rancher/rancher/pkg/generated/norman/core/v1"
 	v3 "github.com/rancher/rancher/pkg/generated/norman/management.cattle.io/v3"
-	"github.com/rancher/rancher/pkg/settings"
 	rketypes "github.com/rancher/rke/types"
-	img "github.com/rancher/rke/types/image"
 )
 
 // ExportConfig provides parameters you can define to configure image exporting for Rancher components
@@ -22,22 +19,93 @@ type ExportConfig struct {
 	OsType           OSType
 	ChartsPath       string
 	SystemChartsPath string
+	// ExcludePatterns are glob or regular expression patterns of images to drop from the final image list.
+	// See ImageFilter for the pattern syntax.
+	ExcludePatterns []string
+	// ExtraImagesFiles are paths to files listing additional images to include, see ExtraImages.
+	ExtraImagesFiles []string
+	// Progress, if set, is called as image sources are fetched and charts are scanned.
+	Progress ProgressFunc
+	// Strict makes the export fail on the first chart that cannot be scanned. By default, such charts are skipped
+	// and reported in ExportResult.ChartErrors.
+	Strict bool
+	// CoreOnly limits the export to the images strictly required to run Rancher and provision clusters: system images,
+	// Rancher images and the images of the charts Rancher installs by itself. Optional app charts, system charts and
+	// UI extensions are skipped, for installations that mirror apps separately.
+	CoreOnly bool
+	// MirrorMapping maps the prefixes of upstream images to the prefixes of their mirrored images, in addition to the
+	// mirrors of the rke types, see LoadMirrorMapping. Its prefixes take precedence over the rke mirrors.
+	MirrorMapping RegistryMapping
+	// MirrorMode is how upstream images are converted to their mirrored names, MirrorModeMirrored if not set.
+	MirrorMode MirrorMode
+	// RegistryMapping, if set, rewrites the exported images, e.g. PrimeRegistryMapping to export the images of
+	// Rancher Prime. It is applied after images are converted to their mirrored names and before ExcludePatterns.
+	RegistryMapping RegistryMapping
+	// Features are Rancher feature flags by name. Charts that are only installed when a feature is enabled are skipped
+	// if the feature is set to false here, e.g. legacy=false skips the system charts. Unset features are considered
+	// enabled.
+	Features map[string]bool
+	// InstallableK8sVersionsOnly limits the RKE system images to the Kubernetes versions the Rancher version can
+	// install, see InstallableRKESystemImages, for air-gapped installations not managing clusters upgraded from
+	// previous Rancher versions.
+	InstallableK8sVersionsOnly bool
+	// K8sPatches is which patch versions of each Kubernetes minor version the RKE system images are exported for, e.g.
+	// K8sPatchesAll for every patch version. K8sPatchesLatest if not set.
+	K8sPatches K8sPatchMode
+	// OSTypes limits the export to the images of the given OS types, e.g. linux for a Linux only air-gap bundle, so the
+	// images of the other OS types are not gathered. Images of every OS type are exported if empty. OS types whose
+	// images are published for none of Arches are not exported either, see OSTypesForArches.
+	OSTypes []OSType
+	// Arches limits the export to the images of the given architectures, e.g. arm64 for an arm64 air-gap bundle:
+	// charts supporting none of them are skipped, along with the images restricted to other architectures. Images of
+	// every architecture are exported if empty.
+	Arches []Arch
+	// ChartArches are the architectures supported by charts, keyed by chart name, taking precedence over the
+	// PermitsArchAnnotationKey annotation of the charts, e.g. for charts that do not declare it.
+	ChartArches map[string][]Arch
+	// RequirementImages are core images required on each OS type in addition to DefaultRequirementImages, e.g. an
+	// arm64 variant of the shell image, keyed by OS type.
+	RequirementImages map[OSType][]RequirementImage
+	// ChartOSTypes are the OS types all the images of charts are exported for, keyed by chart name, overriding the
+	// "os:" keys of their values and taking precedence over their ImagesOSAnnotationKey annotation, e.g. linux for a
+	// chart wrongly hinting some of its images as Windows images.
+	ChartOSTypes map[string][]OSType
+	// RKE2Releases is the RKE2 release data of KDM, the "rke2" entry of data.json, to export the images of the RKE2
+	// releases supported by RancherVersion, see RKE2Images. RKE2 images are not exported if nil.
+	RKE2Releases map[string]interface{}
+	// K3sReleases is the K3s release data of KDM, the "k3s" entry of data.json, to export the images of the K3s
+	// releases supported by RancherVersion, see K3sImages. K3s images are not exported if nil.
+	K3sReleases map[string]interface{}
+	// RKE2Components are the optional RKE2 components whose images are exported along with the core RKE2 images, e.g.
+	// canal, DefaultRKE2Components if nil.
+	RKE2Components []string
+	// RKEAddonTemplates are the RKE addon templates of the KDM data, its K8sVersionedTemplates, whose embedded images
+	// are exported along with the RKE system images of each Kubernetes version, see RKEAddonImages.
+	RKEAddonTemplates map[string]map[string]string
+	// Distributions are the Kubernetes distributions of the downstream clusters whose images are exported, e.g. rke2
+	// for air-gapped installations only provisioning RKE2 clusters. The images of every distribution are exported if
+	// empty.
+	Distributions []Distribution
+	// ValuesLimits limits the size and depth of the values files of the scanned charts, see ValuesLimits.
+	ValuesLimits ValuesLimits
 }
 
-type OSType int
-
-const (
-	Linux OSType = iota
-	Windows
-)
+// ExportResult is the outcome of exporting the images required by Rancher.
+type ExportResult struct {
+	// Images are the images required by Rancher, sorted by OS and then by image.
+	Images ImageList
+	// Excluded are the images that were dropped from Images because they matched ExportConfig.ExcludePatterns.
+	Excluded ImageList
+	// ChartErrors are the charts that were skipped because they could not be scanned, in which case Images is
+	// incomplete. It is always empty for strict exports.
+	ChartErrors ChartErrors
+	// ChartWarnings are the problems of the values of the scanned charts, e.g. unknown os values, which may have
+	// exported some of their images for the wrong OS types or architectures.
+	ChartWarnings []ChartWarning
+}
 
 const imageListDelimiter = "\n"
 
-var osTypeImageListName = map[OSType]string{
-	Windows: "windows-rancher-images",
-	Linux:   "rancher-images",
-}
-
 // Resolve calls ResolveWithCluster passing nil into the cluster argument.
 // returns the image concatenated with the URL of the system default registry.
 // if there is no system default registry it will return the image
@@ -61,75 +129,110 @@ func ResolveWithCluster(image string, cluster *v3.Cluster) string {
 	return image
 }
 
-func GetImages(exportConfig ExportConfig, externalImages map[string][]string, imagesFromArgs []string, rkeSystemImages map[string]rketypes.RKESystemImages) ([]string, []string, error) {
-	imagesSet := make(map[string]map[string]struct{})
+// OSImageInputs holds the images handed to an export for a single OS.
+type OSImageInputs struct {
+	// ExternalImages maps a source label to the images it provides, e.g. "rancher".
+	ExternalImages map[string][]string
+	// ImagesFromArgs are Rancher images passed in directly and labeled with the "rancher" source.
+	ImagesFromArgs []string
+	// RKESystemImages are the RKE system images for each Kubernetes version.
+	RKESystemImages map[string]rketypes.RKESystemImages
+}
 
-	// fetch images from charts
-	charts := Charts{exportConfig}
-	if err := charts.FetchImages(imagesSet); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to fetch images from charts")
+// GetImages collects all the images required by Rancher for the OS defined in exportConfig, Linux if not set, and
+// returns them as an ImageList sorted by image.
+func GetImages(exportConfig ExportConfig, externalImages map[string][]string, imagesFromArgs []string, rkeSystemImages map[string]rketypes.RKESystemImages) (ImageList, error) {
+	osType := exportConfig.OsType
+	if osType == "" {
+		osType = Linux
 	}
-
-	// fetch images from system charts
-	systemCharts := SystemCharts{exportConfig}
-	if err := systemCharts.FetchImages(imagesSet); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to fetch images from system charts")
+	result, err := GetImagesForOSTypes(context.Background(), exportConfig, map[OSType]OSImageInputs{
+		osType: {
+			ExternalImages:  externalImages,
+			ImagesFromArgs:  imagesFromArgs,
+			RKESystemImages: rkeSystemImages,
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
+	return result.Images, nil
+}
 
-	// fetch images from system images
-	system := System{exportConfig}
-	if err := system.FetchImages(rkeSystemImages, imagesSet); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to fetch images from system")
+// GetImagesForOSTypes collects all the images required by Rancher for every OS in inputs from the registered image
+// sources (see RegisterImageSource). Charts are only read and parsed once, with their images bucketed per OS, which
+// avoids calling GetImages once per OS. The OsType of exportConfig is ignored, and only the OS types of inputs
+// exported by exportConfig are gathered, see ExportConfig.OSTypes. The resulting image lists are sorted by
+// OS and then by image; see ImageList.ForOS. Unless exportConfig is strict, charts that cannot be scanned do not fail
+// the export, and are reported in the ChartErrors of the result instead. The chart files are decoded once per export,
+// or once for all the exports run with a context returned by WithDecodeCache.
+func GetImagesForOSTypes(ctx context.Context, exportConfig ExportConfig, inputs map[OSType]OSImageInputs) (ExportResult, error) {
+	filter, err := NewImageFilter(exportConfig.ExcludePatterns)
+	if err != nil {
+		return ExportResult{}, err
 	}
 
-	// fetch images from extension catalog images
-	extensions := ExtensionsConfig{
-		GithubEndpoints: ExtensionEndpoints,
+	var osTypes []OSType
+	exportedInputs := make(map[OSType]OSImageInputs, len(inputs))
+	for osType, osInputs := range inputs {
+		if exportConfig.exportsOSType(osType) {
+			osTypes = append(osTypes, osType)
+			exportedInputs[osType] = osInputs
+		}
 	}
-	if err := extensions.FetchExtensionImages(imagesSet); err != nil {
-		return nil, nil, errors.Wrap(err, "failed to fetch images from extensions")
+	inputs = exportedInputs
+	imagesSet := NewImageSet(osTypes...)
+	if decodeCacheFromContext(ctx) == nil {
+		ctx = WithDecodeCache(ctx)
 	}
-
-	setRequirementImages(exportConfig.OsType, imagesSet)
-
-	// set rancher images from args
-	setImages("rancher", imagesFromArgs, imagesSet)
-
-	for source, sourceImages := range externalImages {
-		setImages(source, sourceImages, imagesSet)
+	ctx = withConstraintCache(ctx)
+	ctx = withValuesLimits(ctx, exportConfig.ValuesLimits)
+	ctx = withProgress(ctx, exportConfig.Progress, imagesSet)
+	progress := progressFromContext(ctx)
+
+	var result ExportResult
+	for _, source := range imageSources(exportConfig, inputs) {
+		progress.startSource(source.Name())
+		if err := source.FetchImages(ctx, imagesSet); err != nil {
+			var chartErrs ChartErrors
+			if exportConfig.Strict || !errors.As(err, &chartErrs) {
+				return ExportResult{}, errors.Wrapf(err, "failed to fetch images from %s", source.Name())
+			}
+			result.ChartErrors = append(result.ChartErrors, chartErrs...)
+		}
+		progress.finishSource()
 	}
 
-	convertMirroredImages(imagesSet)
-
-	imagesList, imagesAndSourcesList := generateImageAndSourceLists(imagesSet)
+	convertMirroredImages(imagesSet, exportConfig.MirrorMapping, exportConfig.MirrorMode)
+	mapRegistries(imagesSet, exportConfig.RegistryMapping)
 
-	return imagesList, imagesAndSourcesList, nil
+	result.ChartWarnings = imagesSet.ChartWarnings()
+	result.Images, result.Excluded = imagesSet.ListAll().ForArches(exportConfig.Arches).Exclude(filter)
+	return result, nil
 }
 
 func AddImagesToImageListConfigMap(cm *v1.ConfigMap, rancherVersion, systemChartsPath string) error {
 	exportConfig := ExportConfig{
 		SystemChartsPath: systemChartsPath,
-		OsType:           Windows,
 		RancherVersion:   rancherVersion,
 	}
-	windowsImages, _, err := GetImages(exportConfig, nil, []string{}, nil)
-	if err != nil {
-		return err
-	}
-	exportConfig.OsType = Linux
-	linuxImages, _, err := GetImages(exportConfig, nil, []string{}, nil)
+	result, err := GetImagesForOSTypes(context.Background(), exportConfig, map[OSType]OSImageInputs{
+		Linux:   {ImagesFromArgs: []string{}},
+		Windows: {ImagesFromArgs: []string{}},
+	})
 	if err != nil {
 		return err
 	}
+	images := result.Images
 	cm.Data = make(map[string]string, 2)
-	cm.Data[osTypeImageListName[Windows]] = strings.Join(windowsImages, imageListDelimiter)
-	cm.Data[osTypeImageListName[Linux]] = strings.Join(linuxImages, imageListDelimiter)
+	cm.Data[Windows.imageListName()] = strings.Join(images.ForOS(Windows).Images(), imageListDelimiter)
+	cm.Data[Linux.imageListName()] = strings.Join(images.ForOS(Linux).Images(), imageListDelimiter)
 	return nil
 }
 
 func ParseCatalogImageListConfigMap(cm *v1.ConfigMap) ([]string, []string) {
-	windowsImages := strings.Split(cm.Data[osTypeImageListName[Windows]], imageListDelimiter)
-	linuxImages := strings.Split(cm.Data[osTypeImageListName[Linux]], imageListDelimiter)
+	windowsImages := strings.Split(cm.Data[Windows.imageListName()], imageListDelimiter)
+	linuxImages := strings.Split(cm.Data[Linux.imageListName()], imageListDelimiter)
 	return windowsImages, linuxImages
 }
 
@@ -138,73 +241,18 @@ func IsValidSemver(version string) bool {
 	return err == nil
 }
 
-func setRequirementImages(osType OSType, imagesSet map[string]map[string]struct{}) {
-	coreLabel := "core"
-	switch osType {
-	case Linux:
-		addSourceToImage(imagesSet, settings.ShellImage.Get(), coreLabel)
-		addSourceToImage(imagesSet, settings.MachineProvisionImage.Get(), coreLabel)
-		addSourceToImage(imagesSet, "rancher/mirrored-bci-busybox:15.4.11.2", coreLabel)
-		addSourceToImage(imagesSet, "rancher/mirrored-bci-micro:15.4.14.3", coreLabel)
-	}
-}
-
-func setImages(source string, imagesFromArgs []string, imagesSet map[string]map[string]struct{}) {
-	for _, image := range imagesFromArgs {
-		addSourceToImage(imagesSet, image, source)
-	}
-}
-
-func addSourceToImage(imagesSet map[string]map[string]struct{}, image string, sources ...string) {
-	if image == "" {
-		return
-	}
-	if imagesSet[image] == nil {
-		imagesSet[image] = make(map[string]struct{})
-	}
-	for _, source := range sources {
-		imagesSet[image][source] = struct{}{}
-	}
-}
-
-func convertMirroredImages(imagesSet map[string]map[string]struct{}) {
-	for image := range imagesSet {
-		convertedImage := img.Mirror(image)
-		if image == convertedImage {
-			continue
+func convertMirroredImages(imagesSet *ImageSet, mirrorMapping RegistryMapping, mode MirrorMode) {
+	for _, osType := range imagesSet.OSTypes() {
+		for _, image := range imagesSet.Images(osType) {
+			switch mode {
+			case MirrorModeUpstream:
+				keepUpstreamImage(image)
+			case MirrorModeBoth:
+				keepUpstreamImage(image)
+				imagesSet.Copy(image, mirrorImage(image, mirrorMapping))
+			default:
+				imagesSet.Rename(image, mirrorImage(image, mirrorMapping))
+			}
 		}
-		for source := range imagesSet[image] {
-			addSourceToImage(imagesSet, convertedImage, source)
-		}
-		delete(imagesSet, image)
-	}
-}
-
-func generateImageAndSourceLists(imagesSet map[string]map[string]struct{}) ([]string, []string) {
-	var images, imagesAndSources []string
-	// unique
-	for image := range imagesSet {
-		images = append(images, image)
-	}
-
-	// sort
-	sort.Slice(images, func(i, j int) bool {
-		return images[i] < images[j]
-	})
-
-	for _, image := range images {
-		imagesAndSources = append(imagesAndSources, fmt.Sprintf("%s %s", image, getSourcesList(imagesSet[image])))
-	}
-
-	return images, imagesAndSources
-}
-
-func getSourcesList(imageSources map[string]struct{}) string {
-	var sources []string
-
-	for source := range imageSources {
-		sources = append(sources, source)
 	}
-	sort.Strings(sources)
-	return strings.Join(sources, ",")
 }
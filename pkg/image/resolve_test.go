@@ -17,6 +17,8 @@ func TestConvertMirroredImages(t *testing.T) {
 	testCases := []struct {
 		caseName                string
 		inputRawImages          map[string]map[string]struct{}
+		mirrorMapping           RegistryMapping
+		mirrorMode              MirrorMode
 		outputImagesShouldEqual map[string]map[string]struct{}
 	}{
 		{
@@ -39,13 +41,60 @@ func TestConvertMirroredImages(t *testing.T) {
 				"test.io/test:v0.0.1":             {"test": struct{}{}},
 			},
 		},
+		{
+			caseName: "mirror mapping",
+			inputRawImages: map[string]map[string]struct{}{
+				"quay.io/jetstack/cert-manager-controller:v1.11.0": {"test": struct{}{}},
+				"quay.io/coreos/flannel:v1.2.3":                    {"system": struct{}{}},
+				"prom/prometheus:v2.0.1":                           {"system": struct{}{}},
+			},
+			mirrorMapping: RegistryMapping{
+				"quay.io/jetstack/": "rancher/mirrored-jetstack-",
+				"prom/":             "rancher/mirrored-prom-",
+			},
+			outputImagesShouldEqual: map[string]map[string]struct{}{
+				"rancher/mirrored-jetstack-cert-manager-controller:v1.11.0": {"test": struct{}{}},
+				"rancher/coreos-flannel:v1.2.3":                             {"system": struct{}{}},
+				"rancher/mirrored-prom-prometheus:v2.0.1":                   {"system": struct{}{}},
+			},
+		},
+		{
+			caseName: "upstream mode",
+			inputRawImages: map[string]map[string]struct{}{
+				"rancher/rke-tools:v0.1.48":     {"system": struct{}{}},
+				"quay.io/coreos/flannel:v1.2.3": {"system": struct{}{}},
+			},
+			mirrorMode: MirrorModeUpstream,
+			outputImagesShouldEqual: map[string]map[string]struct{}{
+				"rancher/rke-tools:v0.1.48":     {"system": struct{}{}},
+				"quay.io/coreos/flannel:v1.2.3": {"system": struct{}{}},
+			},
+		},
+		{
+			caseName: "both mode",
+			inputRawImages: map[string]map[string]struct{}{
+				"rancher/rke-tools:v0.1.48":     {"system": struct{}{}},
+				"quay.io/coreos/flannel:v1.2.3": {"system": struct{}{}},
+			},
+			mirrorMode: MirrorModeBoth,
+			outputImagesShouldEqual: map[string]map[string]struct{}{
+				"rancher/rke-tools:v0.1.48":     {"system": struct{}{}},
+				"quay.io/coreos/flannel:v1.2.3": {"system": struct{}{}},
+				"rancher/coreos-flannel:v1.2.3": {"system": struct{}{}},
+			},
+		},
 	}
 
 	assert := assertlib.New(t)
 	for _, cs := range testCases {
-		imagesSet := cs.inputRawImages
-		convertMirroredImages(imagesSet)
-		assert.Equal(cs.outputImagesShouldEqual, imagesSet)
+		imagesSet := NewImageSet(Linux)
+		for image, sources := range cs.inputRawImages {
+			for source := range sources {
+				imagesSet.Add(Linux, image, source)
+			}
+		}
+		convertMirroredImages(imagesSet, cs.mirrorMapping, cs.mirrorMode)
+		assert.Equal(cs.outputImagesShouldEqual, imageSetToMap(imagesSet, Linux))
 	}
 }
 
@@ -0,0 +1,202 @@
+package image
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultTransientRetries is the number of times RegistryClient retries the requests for an image failing with a
+	// transient error.
+	defaultTransientRetries = 2
+	// defaultRetryDelay is the delay before the first retry of the requests failing with a transient error.
+	defaultRetryDelay = time.Second
+	// defaultMaxRetryDelay caps the delay between two retries of the requests failing with a transient error.
+	defaultMaxRetryDelay = 30 * time.Second
+	// defaultRetryMultiplier multiplies the delay between two retries after each retry.
+	defaultRetryMultiplier = 2
+)
+
+// defaultRetryableStatuses are the HTTP status codes of the registry responses retried by default: timeouts, server
+// errors and unavailable gateways.
+var defaultRetryableStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// statusCodePattern matches the HTTP status code containers/image reports in the errors of unexpected responses.
+var statusCodePattern = regexp.MustCompile(`(?:StatusCode: |invalid status code from registry |received unexpected HTTP status: )(\d{3})`)
+
+// ErrorClass is the class of the error of a registry operation, telling whether retrying it may help.
+type ErrorClass string
+
+const (
+	// ErrorAuth errors come from missing or rejected credentials, or from denied access.
+	ErrorAuth ErrorClass = "auth"
+	// ErrorMissing errors come from images or repositories the registry does not hold.
+	ErrorMissing ErrorClass = "missing"
+	// ErrorRateLimited errors come from registries still rate limiting the requests after their retries.
+	ErrorRateLimited ErrorClass = "rate-limited"
+	// ErrorTransient errors come from network failures, timeouts or server errors, which retrying may fix.
+	ErrorTransient ErrorClass = "transient"
+	// ErrorOther errors are the other errors, e.g. invalid manifests or digest mismatches.
+	ErrorOther ErrorClass = "other"
+)
+
+// RetryPolicy configures how RegistryClient retries the requests for an image failing with a transient error, i.e. a
+// network failure or a response with a retryable status, with an exponential backoff. Rate limited requests are
+// retried separately, see RegistryClient.RateLimitRetries. The zero value retries with the defaults.
+type RetryPolicy struct {
+	// Retries is the number of times the requests are retried, defaultTransientRetries if 0, or never retried if
+	// negative.
+	Retries int
+	// Delay is the delay before the first retry, defaultRetryDelay if not set.
+	Delay time.Duration
+	// MaxDelay caps the delay between two retries, defaultMaxRetryDelay if not set.
+	MaxDelay time.Duration
+	// Multiplier multiplies the delay after each retry, defaultRetryMultiplier if not set. A multiplier of 1 retries
+	// at a constant delay.
+	Multiplier float64
+	// RetryableStatuses are the HTTP status codes of the responses retried, defaultRetryableStatuses if not set.
+	RetryableStatuses []int
+}
+
+// retries returns the number of times the requests are retried.
+func (p RetryPolicy) retries() int {
+	switch {
+	case p.Retries < 0:
+		return 0
+	case p.Retries == 0:
+		return defaultTransientRetries
+	}
+	return p.Retries
+}
+
+// delay returns the delay before the retry following attempt, counted from 0.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay, maxDelay, multiplier := p.Delay, p.MaxDelay, p.Multiplier
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxRetryDelay
+	}
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+	for i := 0; i < attempt && delay < maxDelay; i++ {
+		delay = time.Duration(float64(delay) * multiplier)
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// retryable returns whether err is retried: network failures, and responses with a retryable status.
+func (p RetryPolicy) retryable(err error) bool {
+	statuses := p.RetryableStatuses
+	if len(statuses) == 0 {
+		statuses = defaultRetryableStatuses
+	}
+	if status := errorStatusCode(err); status != 0 {
+		for _, retryable := range statuses {
+			if status == retryable {
+				return true
+			}
+		}
+		return false
+	}
+	return isNetworkError(err)
+}
+
+// ParseRetryableStatuses parses a comma separated list of HTTP status codes, e.g. 500,502,503.
+func ParseRetryableStatuses(s string) ([]int, error) {
+	var statuses []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		status, err := strconv.Atoi(field)
+		if err != nil || status < 100 || status > 599 {
+			return nil, errors.Errorf("invalid HTTP status code %q", field)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ClassifyError returns the class of err, the error of a registry operation.
+func ClassifyError(err error) ErrorClass {
+	var rateLimitErr *RateLimitError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &rateLimitErr) || isRateLimited(err):
+		return ErrorRateLimited
+	case isAuthError(err):
+		return ErrorAuth
+	case isManifestUnknown(err):
+		return ErrorMissing
+	case isNetworkError(err):
+		return ErrorTransient
+	}
+	if status := errorStatusCode(err); status == http.StatusRequestTimeout || status >= 500 {
+		return ErrorTransient
+	}
+	return ErrorOther
+}
+
+// isAuthError returns whether err comes from missing or rejected credentials, or from denied access.
+func isAuthError(err error) bool {
+	var unauthorized docker.ErrUnauthorizedForCredentials
+	if errors.As(err, &unauthorized) {
+		return true
+	}
+	status := errorStatusCode(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// isNetworkError returns whether err comes from a network failure: a timeout, a failed dial, a reset connection, or a
+// connection closed in the middle of a response. Certificate errors are not network failures.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	var opErr *net.OpError
+	return (errors.As(err, &netErr) && netErr.Timeout()) ||
+		errors.As(err, &opErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		strings.Contains(err.Error(), "connection reset by peer")
+}
+
+// errorStatusCode returns the HTTP status code of the registry response err comes from, or 0 if it is unknown.
+func errorStatusCode(err error) int {
+	var codeErr errcode.Error
+	if errors.As(err, &codeErr) {
+		return codeErr.Code.Descriptor().HTTPStatusCode
+	}
+	var codeErrs errcode.Errors
+	if errors.As(err, &codeErrs) && len(codeErrs) > 0 {
+		return errorStatusCode(codeErrs[0])
+	}
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		return status
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	assert := assertlib.New(t)
+
+	policy := RetryPolicy{}
+	assert.Equal(2, policy.retries())
+	assert.Equal(time.Second, policy.delay(0))
+	assert.Equal(4*time.Second, policy.delay(2))
+	assert.Equal(30*time.Second, policy.delay(10))
+
+	policy = RetryPolicy{Retries: -1, Delay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 3}
+	assert.Equal(0, policy.retries())
+	assert.Equal(300*time.Millisecond, policy.delay(1))
+	assert.Equal(time.Second, policy.delay(3))
+
+	policy = RetryPolicy{Delay: time.Second, Multiplier: 1}
+	assert.Equal(time.Second, policy.delay(5))
+}
+
+func TestParseRetryableStatuses(t *testing.T) {
+	assert := assertlib.New(t)
+
+	statuses, err := ParseRetryableStatuses("500, 503,")
+	assert.NoError(err)
+	assert.Equal([]int{500, 503}, statuses)
+	_, err = ParseRetryableStatuses("5xx")
+	assert.Error(err)
+	_, err = ParseRetryableStatuses("700")
+	assert.Error(err)
+}
+
+func TestClassifyError(t *testing.T) {
+	assert := assertlib.New(t)
+
+	assert.Equal(ErrorClass(""), ClassifyError(nil))
+	assert.Equal(ErrorRateLimited, ClassifyError(&RateLimitError{Registry: "docker.io", Err: docker.ErrTooManyRequests}))
+	assert.Equal(ErrorAuth, ClassifyError(docker.ErrUnauthorizedForCredentials{Err: fmt.Errorf("denied")}))
+	assert.Equal(ErrorAuth, ClassifyError(fmt.Errorf("reading manifest: %w", errcode.ErrorCodeDenied.WithMessage("denied"))))
+	assert.Equal(ErrorMissing, ClassifyError(fmt.Errorf("reading manifest: %w", v2.ErrorCodeManifestUnknown.WithMessage("unknown"))))
+	assert.Equal(ErrorMissing, ClassifyError(fmt.Errorf("StatusCode: 404, ")))
+	assert.Equal(ErrorTransient, ClassifyError(fmt.Errorf("StatusCode: 503, unavailable")))
+	assert.Equal(ErrorTransient, ClassifyError(fmt.Errorf("reading manifest: invalid status code from registry 502 (Bad Gateway)")))
+	assert.Equal(ErrorTransient, ClassifyError(&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}))
+	assert.Equal(ErrorTransient, ClassifyError(fmt.Errorf("reading blob: %w", io.ErrUnexpectedEOF)))
+	assert.Equal(ErrorOther, ClassifyError(fmt.Errorf("image has digest sha256:1 instead of sha256:2")))
+	assert.Equal(ErrorOther, ClassifyError(fmt.Errorf("x509: certificate signed by unknown authority")))
+
+	errs := ImageErrors{
+		{Image: "rancher/shell:v0.1.21", Err: fmt.Errorf("StatusCode: 404, ")},
+		{Image: "rancher/shell:v0.1.22", Err: fmt.Errorf("StatusCode: 503, ")},
+		{Image: "rancher/shell:v0.1.23", Err: fmt.Errorf("StatusCode: 404, ")},
+	}
+	assert.Equal("2 missing, 1 transient", errs.ClassSummary())
+}
+
+func TestRegistryClientTransientRetries(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	manifestDigest := registry.AddManifest("rancher/shell", "v0.1.22", schema2MediaType, schema2Manifest(100))
+	image := registry.Host() + "/rancher/shell:v0.1.22"
+
+	client := registry.client()
+	client.Retry = RetryPolicy{Retries: 2, Delay: time.Millisecond}
+	registry.Unavailable = 2
+	d, err := client.Digest(context.Background(), image, Linux)
+	assert.NoError(err)
+	assert.Equal(manifestDigest, d)
+
+	registry.Unavailable = 3
+	_, err = client.Digest(context.Background(), image, Linux)
+	assert.Equal(ErrorTransient, ClassifyError(err))
+
+	// Statuses that are not retryable fail right away
+	registry.Unavailable = 1
+	client.Retry.RetryableStatuses = []int{502}
+	_, err = client.Digest(context.Background(), image, Linux)
+	assert.Error(err)
+	registry.Unavailable = 0
+
+	// Missing images are not retried
+	_, err = client.Digest(context.Background(), registry.Host()+"/rancher/shell:v0.1.21", Linux)
+	assert.Equal(ErrorMissing, ClassifyError(err))
+}
@@ -0,0 +1,153 @@
+package image
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RKE2ReleasesURL is the URL the RKE2 releases publish their image lists under.
+const RKE2ReleasesURL = "https://github.com/rancher/rke2/releases/download"
+
+// DefaultRKE2Components are the optional RKE2 components whose images are exported along with the core RKE2 images:
+// the CNIs and cloud providers of the RKE2 clusters Rancher provisions.
+var DefaultRKE2Components = []string{"calico", "canal", "cilium", "flannel", "harvester", "multus", "vsphere"}
+
+// rke2Arches are the architectures RKE2 publishes Linux image lists for. Windows image lists are only published for
+// amd64.
+var rke2Arches = []Arch{AMD64, ARM64, S390X}
+
+// minRKE2Version is the oldest RKE2 release whose images are exported: Rancher only provisions RKE2 clusters of
+// Kubernetes v1.21+, and older releases do not publish all their image lists.
+var minRKE2Version = semver.MustParse("v1.21.0")
+
+// RKE2Images provides the images of the RKE2 releases supported by a Rancher version: the images listed in the
+// rke2-images files published by each release, the core images and those of Components, along with the RKE2 upgrade
+// and system agent installer images. The Windows images are those of the Windows image lists of the releases, including
+// the per-build lists of WindowsBuilds, e.g. the calico and containerd shim images of Windows workers. Images are
+// labeled with an "rke2:<version>" source, e.g. rke2:v1.27.10+rke2r1.
+type RKE2Images struct {
+	// RancherVersion is the Rancher version whose RKE2 releases are exported.
+	RancherVersion string
+	// Releases is the RKE2 release data of KDM, the "rke2" entry of data.json.
+	Releases map[string]interface{}
+	// Components are the optional RKE2 components whose images are exported, DefaultRKE2Components if nil.
+	Components []string
+	// WindowsBuilds are the Windows Server builds whose Windows image lists are downloaded, DefaultWindowsBuilds if
+	// nil.
+	WindowsBuilds []WindowsBuild
+	// Arches limits the image lists that are downloaded to the given architectures, every architecture if empty.
+	Arches []Arch
+	// URL is the URL the releases publish their image lists under, RKE2ReleasesURL if empty.
+	URL string
+}
+
+func (r RKE2Images) Name() string {
+	return "rke2"
+}
+
+func (r RKE2Images) FetchImages(ctx context.Context, imagesSet *ImageSet) error {
+	for _, version := range kdmReleases(ctx, r.RancherVersion, r.Releases, minRKE2Version) {
+		source := "rke2:" + version
+		for _, osType := range imagesSet.OSTypes() {
+			var err error
+			switch osType {
+			case Linux:
+				err = r.fetchLinuxImages(ctx, version, source, imagesSet)
+			case Windows:
+				err = r.fetchWindowsImages(ctx, version, source, imagesSet)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchLinuxImages adds the Linux images of the RKE2 release version for each of the architectures RKE2 publishes image
+// lists for. Releases publishing no core image list for an architecture, but an image list of all their images, e.g.
+// v1.21 releases, are exported from the latter. The image lists of components a release does not ship are skipped.
+func (r RKE2Images) fetchLinuxImages(ctx context.Context, version, source string, imagesSet *ImageSet) error {
+	var arches []Arch
+	for _, arch := range rke2Arches {
+		if hasArch(r.Arches, arch) {
+			arches = append(arches, arch)
+		}
+	}
+	if len(arches) == 0 {
+		return nil
+	}
+	tag := releaseTag(version)
+	imagesSet.AddForArches(Linux, "rancher/rke2-upgrade:"+tag, source, arches...)
+	addSystemAgentInstallerImage(imagesSet, "rke2", tag, source, arches...)
+
+	components := r.Components
+	if components == nil {
+		components = DefaultRKE2Components
+	}
+	for _, arch := range arches {
+		err := r.fetchImageList(ctx, version, fmt.Sprintf("rke2-images-core.linux-%s.txt", arch), Linux, source, arch, imagesSet)
+		if errors.Is(err, errImageListNotPublished) {
+			err = r.fetchImageList(ctx, version, fmt.Sprintf("rke2-images-all.linux-%s.txt", arch), Linux, source, arch, imagesSet)
+		}
+		if errors.Is(err, errImageListNotPublished) {
+			logrus.Debugf("[rke2] no %s image list published for release %s", arch, version)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, component := range components {
+			err := r.fetchImageList(ctx, version, fmt.Sprintf("rke2-images-%s.linux-%s.txt", component, arch), Linux, source, arch, imagesSet)
+			if err != nil && !errors.Is(err, errImageListNotPublished) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fetchWindowsImages adds the Windows images of the RKE2 release version, from its Windows image list and from the
+// image lists of each of the Windows builds. The image lists a release does not publish are skipped, e.g. the
+// ltsc2022 image list of releases predating ltsc2022 support.
+func (r RKE2Images) fetchWindowsImages(ctx context.Context, version, source string, imagesSet *ImageSet) error {
+	if !hasArch(r.Arches, AMD64) {
+		return nil
+	}
+	names := []string{"rke2-images.windows-amd64.txt"}
+	builds := r.WindowsBuilds
+	if builds == nil {
+		builds = DefaultWindowsBuilds
+	}
+	for _, build := range builds {
+		names = append(names, fmt.Sprintf("rke2-windows-%s-amd64-images.txt", build.Name))
+	}
+	for _, name := range names {
+		err := r.fetchImageList(ctx, version, name, Windows, source, AMD64, imagesSet)
+		if err != nil && !errors.Is(err, errImageListNotPublished) {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchImageList adds the images of the image list called name of the RKE2 release version to imagesSet, for osType
+// and arch.
+func (r RKE2Images) fetchImageList(ctx context.Context, version, name string, osType OSType, source string, arch Arch, imagesSet *ImageSet) error {
+	url := r.URL
+	if url == "" {
+		url = RKE2ReleasesURL
+	}
+	images, err := downloadImageList(ctx, fmt.Sprintf("%s/%s/%s", url, version, name))
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		imagesSet.AddForArches(osType, image, source, arch)
+	}
+	return nil
+}
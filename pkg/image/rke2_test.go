@@ -0,0 +1,107 @@
+package image
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/rancher/pkg/settings"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+var rke2TestReleases = map[string]interface{}{
+	"releases": []interface{}{
+		map[string]interface{}{"version": "v1.27.10+rke2r1", "minChannelServerVersion": "v2.8.0-alpha1", "maxChannelServerVersion": "v2.8.99"},
+	},
+}
+
+func rke2TestServer() *httptest.Server {
+	lists := map[string]string{
+		"/v1.27.10+rke2r1/rke2-images-core.linux-amd64.txt":  "docker.io/rancher/rke2-runtime:v1.27.10-rke2r1\ndocker.io/rancher/mirrored-pause:3.6\n",
+		"/v1.27.10+rke2r1/rke2-images-canal.linux-amd64.txt": "docker.io/rancher/hardened-flannel:v0.24.0-build20231129\n",
+		"/v1.27.10+rke2r1/rke2-images-core.linux-arm64.txt":  "docker.io/rancher/rke2-runtime:v1.27.10-rke2r1\n",
+		"/v1.27.10+rke2r1/rke2-images-all.linux-s390x.txt":   "docker.io/rancher/rke2-runtime:v1.27.10-rke2r1\n",
+		"/v1.27.10+rke2r1/rke2-images.windows-amd64.txt":     "docker.io/rancher/rke2-runtime:v1.27.10-rke2r1-windows-amd64\n",
+		"/v1.27.10+rke2r1/rke2-windows-ltsc2022-amd64-images.txt": "docker.io/rancher/mirrored-calico-node:v3.26.3-ltsc2022\n" +
+			"docker.io/rancher/rke2-runtime:v1.27.10-rke2r1-windows-amd64\n",
+	}
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		list, ok := lists[req.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(list))
+	}))
+}
+
+func TestRKE2ImagesFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	server := rke2TestServer()
+	defer server.Close()
+
+	imagesSet := NewImageSet(Linux, Windows)
+	rke2 := RKE2Images{RancherVersion: "2.8.2", Releases: rke2TestReleases, URL: server.URL}
+	assert.NoError(rke2.FetchImages(context.Background(), imagesSet))
+
+	installer := settings.SystemAgentInstallerImage.Default + "rke2:v1.27.10-rke2r1"
+	assert.ElementsMatch([]string{
+		installer,
+		"rancher/hardened-flannel:v0.24.0-build20231129",
+		"rancher/mirrored-pause:3.6",
+		"rancher/rke2-runtime:v1.27.10-rke2r1",
+		"rancher/rke2-upgrade:v1.27.10-rke2r1",
+	}, imagesSet.Images(Linux))
+	assert.Equal([]string{"rancher/mirrored-calico-node:v3.26.3-ltsc2022", "rancher/rke2-runtime:v1.27.10-rke2r1-windows-amd64"}, imagesSet.Images(Windows))
+	assert.Equal([]Arch{AMD64, ARM64, S390X}, imagesSet.Arches(Linux, "rancher/rke2-runtime:v1.27.10-rke2r1"))
+	assert.Equal([]string{"provisioning", "rke2:v1.27.10+rke2r1"}, imagesSet.Sources(Linux, installer))
+	assert.Equal([]Arch{AMD64}, imagesSet.Arches(Linux, "rancher/mirrored-pause:3.6"))
+	assert.Equal([]Arch{AMD64}, imagesSet.Arches(Windows, "rancher/rke2-runtime:v1.27.10-rke2r1-windows-amd64"))
+	assert.Equal([]string{"rke2:v1.27.10+rke2r1"}, imagesSet.Sources(Linux, "rancher/rke2-upgrade:v1.27.10-rke2r1"))
+}
+
+func TestRKE2ImagesFetchImagesComponentsAndArches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	server := rke2TestServer()
+	defer server.Close()
+
+	imagesSet := NewImageSet(Linux, Windows)
+	rke2 := RKE2Images{RancherVersion: "2.8.2", Releases: rke2TestReleases, Components: []string{}, Arches: []Arch{ARM64}, URL: server.URL}
+	assert.NoError(rke2.FetchImages(context.Background(), imagesSet))
+
+	installer := settings.SystemAgentInstallerImage.Default + "rke2:v1.27.10-rke2r1"
+	assert.ElementsMatch([]string{installer, "rancher/rke2-runtime:v1.27.10-rke2r1", "rancher/rke2-upgrade:v1.27.10-rke2r1"}, imagesSet.Images(Linux))
+	assert.Equal([]Arch{ARM64}, imagesSet.Arches(Linux, "rancher/rke2-upgrade:v1.27.10-rke2r1"))
+	assert.Empty(imagesSet.Images(Windows))
+
+	// Rancher versions without RKE2 releases export no RKE2 images
+	imagesSet = NewImageSet(Linux)
+	rke2 = RKE2Images{RancherVersion: "2.7.0", Releases: rke2TestReleases, URL: server.URL}
+	assert.NoError(rke2.FetchImages(context.Background(), imagesSet))
+	assert.Empty(imagesSet.Images(Linux))
+}
+
+func TestRKE2ImagesFetchImagesError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	rke2 := RKE2Images{RancherVersion: "2.8.2", Releases: rke2TestReleases, URL: server.URL}
+	assertlib.Error(t, rke2.FetchImages(context.Background(), NewImageSet(Linux)))
+}
+
+func TestRKE2ImagesFetchImagesWindowsBuilds(t *testing.T) {
+	assert := assertlib.New(t)
+
+	server := rke2TestServer()
+	defer server.Close()
+
+	imagesSet := NewImageSet(Windows)
+	rke2 := RKE2Images{RancherVersion: "2.8.2", Releases: rke2TestReleases, WindowsBuilds: []WindowsBuild{windowsBuilds["1809"]}, URL: server.URL}
+	assert.NoError(rke2.FetchImages(context.Background(), imagesSet))
+	assert.Equal([]string{"rancher/rke2-runtime:v1.27.10-rke2r1-windows-amd64"}, imagesSet.Images(Windows))
+}
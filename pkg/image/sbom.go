@@ -0,0 +1,484 @@
+package image
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/types"
+	"github.com/docker/distribution/reference"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// maxSBOMSize is the maximum size of the attestations read from registries. SBOMs of large images are a few MiB.
+const maxSBOMSize = 64 << 20
+
+// The annotations BuildKit describes its attestations with: the type of the attestation manifests in manifest lists,
+// and the predicate type of the in-toto statements of their layers.
+const (
+	buildKitReferenceTypeAnnotation = "vnd.docker.reference.type"
+	buildKitAttestationManifest     = "attestation-manifest"
+	inTotoPredicateTypeAnnotation   = "in-toto.io/predicate-type"
+)
+
+// SBOMFormat is the format of an SBOM attestation.
+type SBOMFormat string
+
+const (
+	// SBOMFormatSPDX is the SPDX JSON format, the format of the SBOMs generated by BuildKit.
+	SBOMFormatSPDX SBOMFormat = "spdx"
+	// SBOMFormatCycloneDX is the CycloneDX JSON format.
+	SBOMFormatCycloneDX SBOMFormat = "cyclonedx"
+)
+
+// sbomPredicateTypes are the prefixes of the in-toto predicate types of the SBOM formats, which may be followed by the
+// version of the format, e.g. https://spdx.dev/Document/v2.3.
+var sbomPredicateTypes = map[string]SBOMFormat{
+	"https://spdx.dev/Document": SBOMFormatSPDX,
+	"https://cyclonedx.org/bom": SBOMFormatCycloneDX,
+}
+
+// sbomFormat returns the SBOM format of the in-toto predicate type, if it is one.
+func sbomFormat(predicateType string) (SBOMFormat, bool) {
+	for prefix, format := range sbomPredicateTypes {
+		if predicateType == prefix || strings.HasPrefix(predicateType, prefix+"/") {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// SBOMStatus is the outcome of the lookup of the SBOM attestations of an image.
+type SBOMStatus string
+
+const (
+	// SBOMFound images have SBOM attestations.
+	SBOMFound SBOMStatus = "found"
+	// SBOMMissing images have no SBOM attestation.
+	SBOMMissing SBOMStatus = "missing"
+	// SBOMUnknown images or attestations could not be looked up.
+	SBOMUnknown SBOMStatus = "unknown"
+)
+
+// SBOMPackage is a package listed by an SBOM.
+type SBOMPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// PURL is the package URL of the package, e.g. pkg:golang/golang.org/x/net@v0.17.0.
+	PURL string `json:"purl,omitempty"`
+	// Licenses are the SPDX license identifiers or expressions of the package.
+	Licenses []string `json:"licenses,omitempty"`
+}
+
+// ImageSBOM is what the SBOM attestations of an image list.
+type ImageSBOM struct {
+	// Image is the image.
+	Image string `json:"image"`
+	// Digest is the digest of the image, empty if it could not be looked up.
+	Digest string `json:"digest,omitempty"`
+	// Status is the outcome of the lookup of the attestations.
+	Status SBOMStatus `json:"status"`
+	// Formats are the formats of the SBOM attestations of the image.
+	Formats []SBOMFormat `json:"formats,omitempty"`
+	// Packages are the packages listed by the SBOMs of every platform of the image, sorted by name and version.
+	Packages []SBOMPackage `json:"packages,omitempty"`
+	// Error is why the attestations of unknown images could not be looked up.
+	Error string `json:"error,omitempty"`
+	// ErrorClass is the class of the error of unknown images, see ClassifyError.
+	ErrorClass ErrorClass `json:"errorClass,omitempty"`
+}
+
+// FetchSBOMs fetches the SBOM attestations of each image of list from its registry, and returns what they list,
+// sorted by image. Both the attestations BuildKit attaches to the manifest lists it builds and the attestations cosign
+// stores under the sha256-DIGEST.att tag are read; their signatures are not verified, see VerifySignatures. Images
+// exported for several OS types are fetched once.
+func (c RegistryClient) FetchSBOMs(ctx context.Context, list ImageList) []ImageSBOM {
+	var unique ImageList
+	seen := make(map[string]bool, len(list))
+	for _, entry := range list {
+		if !seen[entry.Image] {
+			seen[entry.Image] = true
+			unique = append(unique, entry)
+		}
+	}
+
+	var mu sync.Mutex
+	var sboms []ImageSBOM
+	c.forEach(unique, func(entry *ImageEntry) {
+		sbom := c.fetchSBOM(ctx, *entry)
+		mu.Lock()
+		defer mu.Unlock()
+		sboms = append(sboms, sbom)
+	})
+	sort.Slice(sboms, func(i, j int) bool {
+		return sboms[i].Image < sboms[j].Image
+	})
+	return sboms
+}
+
+// fetchSBOM fetches the SBOM attestations of the image of entry.
+func (c RegistryClient) fetchSBOM(ctx context.Context, entry ImageEntry) ImageSBOM {
+	sbom := ImageSBOM{Image: entry.Image}
+	statements, imageDigest, err := c.buildKitAttestations(ctx, entry)
+	sbom.Digest = imageDigest
+	if err == nil {
+		var cosignStatements []inTotoStatement
+		cosignStatements, err = c.cosignAttestations(ctx, entry, imageDigest)
+		statements = append(statements, cosignStatements...)
+	}
+	if err != nil {
+		sbom.Status = SBOMUnknown
+		sbom.Error = err.Error()
+		sbom.ErrorClass = ClassifyError(err)
+		return sbom
+	}
+
+	type packageKey struct{ name, version, purl string }
+	packages := make(map[packageKey]bool)
+	for _, statement := range statements {
+		format, ok := sbomFormat(statement.PredicateType)
+		if !ok {
+			continue
+		}
+		var statementPackages []SBOMPackage
+		switch format {
+		case SBOMFormatSPDX:
+			statementPackages, err = spdxPackages(statement.Predicate)
+		case SBOMFormatCycloneDX:
+			statementPackages, err = cycloneDXPackages(statement.Predicate)
+		}
+		if err != nil {
+			sbom.Status = SBOMUnknown
+			sbom.Error = errors.Wrapf(err, "failed to parse %s SBOM of image %s", format, entry.Image).Error()
+			return sbom
+		}
+		if !containsSBOMFormat(sbom.Formats, format) {
+			sbom.Formats = append(sbom.Formats, format)
+		}
+		for _, p := range statementPackages {
+			// The packages of the SBOMs of the platforms of multi-arch images mostly overlap
+			key := packageKey{name: p.Name, version: p.Version, purl: p.PURL}
+			if !packages[key] {
+				packages[key] = true
+				sbom.Packages = append(sbom.Packages, p)
+			}
+		}
+	}
+	if len(sbom.Formats) == 0 {
+		sbom.Status = SBOMMissing
+		return sbom
+	}
+	sbom.Status = SBOMFound
+	sort.Slice(sbom.Packages, func(i, j int) bool {
+		if sbom.Packages[i].Name != sbom.Packages[j].Name {
+			return sbom.Packages[i].Name < sbom.Packages[j].Name
+		}
+		return sbom.Packages[i].Version < sbom.Packages[j].Version
+	})
+	return sbom
+}
+
+// inTotoStatement is an in-toto attestation statement.
+type inTotoStatement struct {
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// buildKitAttestations returns the in-toto statements of the attestation manifests of the manifest list of the image of
+// entry, along with the digest of the image.
+func (c RegistryClient) buildKitAttestations(ctx context.Context, entry ImageEntry) ([]inTotoStatement, string, error) {
+	var statements []inTotoStatement
+	var imageDigest string
+	err := c.withImage(ctx, entry.Image, entry.OS, func(ref types.ImageReference, sys *types.SystemContext) error {
+		statements = nil
+		src, err := ref.NewImageSource(ctx, sys)
+		if err != nil {
+			return errors.Wrapf(err, "failed to access image %s", entry.Image)
+		}
+		defer src.Close()
+
+		raw, mimeType, err := src.GetManifest(ctx, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get manifest of image %s", entry.Image)
+		}
+		manifestDigest, err := manifest.Digest(raw)
+		if err != nil {
+			return errors.Wrapf(err, "failed to compute digest of image %s", entry.Image)
+		}
+		imageDigest = manifestDigest.String()
+		if !manifest.MIMETypeIsMultiImage(mimeType) {
+			return nil
+		}
+		var index imgspecv1.Index
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return errors.Wrapf(err, "failed to parse manifest list of image %s", entry.Image)
+		}
+		for _, descriptor := range index.Manifests {
+			if descriptor.Annotations[buildKitReferenceTypeAnnotation] != buildKitAttestationManifest {
+				continue
+			}
+			instance := descriptor.Digest
+			raw, _, err := src.GetManifest(ctx, &instance)
+			if err != nil {
+				return errors.Wrapf(err, "failed to get attestation manifest %s of image %s", instance, entry.Image)
+			}
+			var m imgspecv1.Manifest
+			if err := json.Unmarshal(raw, &m); err != nil {
+				return errors.Wrapf(err, "failed to parse attestation manifest %s of image %s", instance, entry.Image)
+			}
+			for _, layer := range m.Layers {
+				if _, ok := sbomFormat(layer.Annotations[inTotoPredicateTypeAnnotation]); !ok {
+					continue
+				}
+				content, err := readBlob(ctx, src, layer, maxSBOMSize)
+				if err != nil {
+					return errors.Wrapf(err, "failed to read attestation of image %s", entry.Image)
+				}
+				var statement inTotoStatement
+				if err := json.Unmarshal(content, &statement); err != nil {
+					return errors.Wrapf(err, "failed to parse attestation of image %s", entry.Image)
+				}
+				statements = append(statements, statement)
+			}
+		}
+		return nil
+	})
+	return statements, imageDigest, err
+}
+
+// cosignAttestations returns the in-toto statements of the DSSE envelopes cosign stores the attestations of the image
+// of entry, whose manifest has imageDigest, in. Images without attestations have none.
+func (c RegistryClient) cosignAttestations(ctx context.Context, entry ImageEntry, imageDigest string) ([]inTotoStatement, error) {
+	var statements []inTotoStatement
+	err := c.withImage(ctx, cosignImage(entry.Image, imageDigest, "att"), entry.OS, func(ref types.ImageReference, sys *types.SystemContext) error {
+		statements = nil
+		src, err := ref.NewImageSource(ctx, sys)
+		if err != nil {
+			return errors.Wrapf(err, "failed to access attestations of image %s", entry.Image)
+		}
+		defer src.Close()
+
+		raw, _, err := src.GetManifest(ctx, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get attestations of image %s", entry.Image)
+		}
+		var m imgspecv1.Manifest
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return errors.Wrapf(err, "failed to parse attestations of image %s", entry.Image)
+		}
+		for _, layer := range m.Layers {
+			content, err := readBlob(ctx, src, layer, maxSBOMSize)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read attestation of image %s", entry.Image)
+			}
+			var envelope struct {
+				Payload string `json:"payload"`
+			}
+			if err := json.Unmarshal(content, &envelope); err != nil {
+				return errors.Wrapf(err, "failed to parse attestation of image %s", entry.Image)
+			}
+			payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+			if err != nil {
+				return errors.Wrapf(err, "failed to decode attestation of image %s", entry.Image)
+			}
+			var statement inTotoStatement
+			if err := json.Unmarshal(payload, &statement); err != nil {
+				return errors.Wrapf(err, "failed to parse attestation of image %s", entry.Image)
+			}
+			statements = append(statements, statement)
+		}
+		return nil
+	})
+	if isManifestUnknown(err) {
+		return nil, nil
+	}
+	return statements, err
+}
+
+// spdxPackages returns the packages of an SPDX JSON document.
+func spdxPackages(document json.RawMessage) ([]SBOMPackage, error) {
+	var spdx struct {
+		Packages []struct {
+			Name             string `json:"name"`
+			VersionInfo      string `json:"versionInfo"`
+			LicenseConcluded string `json:"licenseConcluded"`
+			LicenseDeclared  string `json:"licenseDeclared"`
+			ExternalRefs     []struct {
+				ReferenceType    string `json:"referenceType"`
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(document, &spdx); err != nil {
+		return nil, err
+	}
+	packages := make([]SBOMPackage, 0, len(spdx.Packages))
+	for _, p := range spdx.Packages {
+		sbomPackage := SBOMPackage{Name: p.Name, Version: p.VersionInfo}
+		for _, ref := range p.ExternalRefs {
+			if ref.ReferenceType == "purl" {
+				sbomPackage.PURL = ref.ReferenceLocator
+				break
+			}
+		}
+		// NOASSERTION and NONE are not licenses, the declared license is used when none was concluded
+		for _, license := range []string{p.LicenseConcluded, p.LicenseDeclared} {
+			if license != "" && license != "NOASSERTION" && license != "NONE" {
+				sbomPackage.Licenses = []string{license}
+				break
+			}
+		}
+		packages = append(packages, sbomPackage)
+	}
+	return packages, nil
+}
+
+// cycloneDXPackages returns the packages of a CycloneDX JSON document, including the nested components.
+func cycloneDXPackages(document json.RawMessage) ([]SBOMPackage, error) {
+	var bom struct {
+		Components []CycloneDXComponent `json:"components"`
+	}
+	if err := json.Unmarshal(document, &bom); err != nil {
+		return nil, err
+	}
+	var packages []SBOMPackage
+	var add func(components []CycloneDXComponent)
+	add = func(components []CycloneDXComponent) {
+		for _, component := range components {
+			sbomPackage := SBOMPackage{Name: component.Name, Version: component.Version, PURL: component.PURL}
+			for _, license := range component.Licenses {
+				switch {
+				case license.Expression != "":
+					sbomPackage.Licenses = append(sbomPackage.Licenses, license.Expression)
+				case license.License != nil && license.License.ID != "":
+					sbomPackage.Licenses = append(sbomPackage.Licenses, license.License.ID)
+				case license.License != nil && license.License.Name != "":
+					sbomPackage.Licenses = append(sbomPackage.Licenses, license.License.Name)
+				}
+			}
+			packages = append(packages, sbomPackage)
+			add(component.Components)
+		}
+	}
+	add(bom.Components)
+	return packages, nil
+}
+
+// containsSBOMFormat returns whether formats contains format.
+func containsSBOMFormat(formats []SBOMFormat, format SBOMFormat) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// CycloneDXBOM is a CycloneDX JSON bill of materials.
+type CycloneDXBOM struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    CycloneDXMetadata    `json:"metadata"`
+	Components  []CycloneDXComponent `json:"components"`
+}
+
+// CycloneDXMetadata describes what a CycloneDX bill of materials is for.
+type CycloneDXMetadata struct {
+	Timestamp string              `json:"timestamp,omitempty"`
+	Component *CycloneDXComponent `json:"component,omitempty"`
+}
+
+// CycloneDXComponent is a component of a CycloneDX bill of materials.
+type CycloneDXComponent struct {
+	Type       string               `json:"type"`
+	BOMRef     string               `json:"bom-ref,omitempty"`
+	Name       string               `json:"name"`
+	Version    string               `json:"version,omitempty"`
+	PURL       string               `json:"purl,omitempty"`
+	Licenses   []CycloneDXLicense   `json:"licenses,omitempty"`
+	Properties []CycloneDXProperty  `json:"properties,omitempty"`
+	Components []CycloneDXComponent `json:"components,omitempty"`
+}
+
+// CycloneDXLicense is either a license, identified by its SPDX identifier or its name, or an SPDX license expression.
+type CycloneDXLicense struct {
+	License *struct {
+		ID   string `json:"id,omitempty"`
+		Name string `json:"name,omitempty"`
+	} `json:"license,omitempty"`
+	Expression string `json:"expression,omitempty"`
+}
+
+// CycloneDXProperty is a name-value property of a CycloneDX component.
+type CycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// sbomStatusProperty is the property recording the SBOM status of the images of release bills of materials.
+const sbomStatusProperty = "rancher:sbom:status"
+
+// NewReleaseBOM aggregates the SBOMs of the images of a Rancher release into a CycloneDX bill of materials of the
+// release, with a container component per image holding the packages of its SBOMs. The images without SBOM are listed
+// as well, with their SBOM status, so the bill of materials accounts for every image of the release.
+func NewReleaseBOM(rancherVersion string, sboms []ImageSBOM) (CycloneDXBOM, error) {
+	bom := CycloneDXBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: CycloneDXMetadata{
+			Component: &CycloneDXComponent{Type: "application", Name: "rancher", Version: rancherVersion},
+		},
+		Components: []CycloneDXComponent{},
+	}
+	for _, sbom := range sboms {
+		named, err := reference.ParseNormalizedNamed(sbom.Image)
+		if err != nil {
+			return CycloneDXBOM{}, errors.Wrapf(err, "failed to parse image %s", sbom.Image)
+		}
+		component := CycloneDXComponent{
+			Type:       "container",
+			BOMRef:     sbom.Image,
+			Name:       imageRepository(sbom.Image),
+			Properties: []CycloneDXProperty{{Name: sbomStatusProperty, Value: string(sbom.Status)}},
+		}
+		if tagged, ok := named.(reference.Tagged); ok {
+			component.Version = tagged.Tag()
+		}
+		if sbom.Digest != "" {
+			path := reference.Path(named)
+			component.PURL = "pkg:oci/" + path[strings.LastIndex(path, "/")+1:] + "@" + strings.Replace(sbom.Digest, ":", "%3A", 1) +
+				"?repository_url=" + named.Name()
+		}
+		for _, p := range sbom.Packages {
+			library := CycloneDXComponent{Type: "library", Name: p.Name, Version: p.Version, PURL: p.PURL}
+			for _, license := range p.Licenses {
+				library.Licenses = append(library.Licenses, CycloneDXLicense{Expression: license})
+			}
+			component.Components = append(component.Components, library)
+		}
+		bom.Components = append(bom.Components, component)
+	}
+	return bom, nil
+}
+
+// WriteReleaseBOM writes the CycloneDX bill of materials of a Rancher release aggregating sboms to w as indented JSON,
+// see NewReleaseBOM.
+func WriteReleaseBOM(w io.Writer, rancherVersion string, sboms []ImageSBOM) error {
+	bom, err := NewReleaseBOM(rancherVersion, sboms)
+	if err != nil {
+		return err
+	}
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(bom)
+}
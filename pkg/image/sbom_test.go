@@ -0,0 +1,145 @@
+package image
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+const spdxStatement = `{"_type":"https://in-toto.io/Statement/v0.1","predicateType":"https://spdx.dev/Document","predicate":{
+"spdxVersion":"SPDX-2.3","packages":[
+{"name":"busybox","versionInfo":"1.36.1","licenseConcluded":"NOASSERTION","licenseDeclared":"GPL-2.0-only",
+ "externalRefs":[{"referenceCategory":"PACKAGE-MANAGER","referenceType":"purl","referenceLocator":"pkg:apk/alpine/busybox@1.36.1"}]},
+{"name":"musl","versionInfo":"1.2.4","licenseConcluded":"MIT"}]}}`
+
+const cycloneDXStatement = `{"_type":"https://in-toto.io/Statement/v0.1","predicateType":"https://cyclonedx.org/bom","predicate":{
+"bomFormat":"CycloneDX","specVersion":"1.5","components":[
+{"type":"library","name":"golang.org/x/net","version":"v0.17.0","purl":"pkg:golang/golang.org/x/net@v0.17.0",
+ "licenses":[{"license":{"id":"BSD-3-Clause"}}],
+ "components":[{"type":"library","name":"golang.org/x/net/http2","version":"v0.17.0"}]}]}}`
+
+// addBlob serves content as a blob of the registry, and returns its descriptor.
+func (r *fakeRegistry) addBlob(mediaType string, content []byte, annotations map[string]string) imgspecv1.Descriptor {
+	return imgspecv1.Descriptor{MediaType: mediaType, Size: int64(len(content)), Digest: r.AddBlob(content), Annotations: annotations}
+}
+
+// addOCIManifest serves an OCI manifest with layers as repo:tag, and returns its descriptor.
+func (r *fakeRegistry) addOCIManifest(repo, tag string, layers ...imgspecv1.Descriptor) imgspecv1.Descriptor {
+	m := imgspecv1.Manifest{
+		MediaType: imgspecv1.MediaTypeImageManifest,
+		Config:    r.addBlob(imgspecv1.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux"}`), nil),
+		Layers:    layers,
+	}
+	m.SchemaVersion = 2
+	body, _ := json.Marshal(m)
+	manifestDigest := r.AddManifest(repo, tag, imgspecv1.MediaTypeImageManifest, body)
+	return imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageManifest, Size: int64(len(body)), Digest: digest.Digest(manifestDigest)}
+}
+
+func TestRegistryClientFetchSBOMs(t *testing.T) {
+	assert := assertlib.New(t)
+	registry := newFakeRegistry(t)
+
+	// BuildKit attaches the SBOMs to the manifest lists it builds, in attestation manifests
+	platform := registry.addOCIManifest("rancher/shell", "linux-amd64", registry.addBlob(imgspecv1.MediaTypeImageLayerGzip, []byte("shell"), nil))
+	platform.Platform = &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}
+	attestation := registry.addOCIManifest("rancher/shell", "attestation", registry.addBlob("application/vnd.in-toto+json",
+		[]byte(spdxStatement), map[string]string{inTotoPredicateTypeAnnotation: "https://spdx.dev/Document"}))
+	attestation.Platform = &imgspecv1.Platform{OS: "unknown", Architecture: "unknown"}
+	attestation.Annotations = map[string]string{buildKitReferenceTypeAnnotation: buildKitAttestationManifest}
+	index := imgspecv1.Index{MediaType: imgspecv1.MediaTypeImageIndex, Manifests: []imgspecv1.Descriptor{platform, attestation}}
+	index.SchemaVersion = 2
+	body, _ := json.Marshal(index)
+	shellDigest := registry.AddManifest("rancher/shell", "v0.1.22", imgspecv1.MediaTypeImageIndex, body)
+
+	// cosign stores the attestations in DSSE envelopes, under the sha256-DIGEST.att tag
+	fleet := registry.addOCIManifest("rancher/fleet", "v0.9.0", registry.addBlob(imgspecv1.MediaTypeImageLayerGzip, []byte("fleet"), nil))
+	envelope := fmt.Sprintf(`{"payloadType":"application/vnd.in-toto+json","payload":"%s","signatures":[]}`,
+		base64.StdEncoding.EncodeToString([]byte(cycloneDXStatement)))
+	registry.addOCIManifest("rancher/fleet", strings.Replace(fleet.Digest.String(), ":", "-", 1)+".att",
+		registry.addBlob("application/vnd.dsse.envelope.v1+json", []byte(envelope), nil))
+
+	registry.AddImage("rancher/kubectl", "v1.28.0", []byte("kubectl"))
+
+	image := func(name string) string {
+		return registry.Host() + "/" + name
+	}
+	sboms := registry.client().FetchSBOMs(context.Background(), ImageList{
+		{Image: image("rancher/shell:v0.1.22"), OS: Linux},
+		{Image: image("rancher/shell:v0.1.22"), OS: Windows},
+		{Image: image("rancher/fleet:v0.9.0"), OS: Linux},
+		{Image: image("rancher/kubectl:v1.28.0"), OS: Linux},
+		{Image: image("rancher/missing:v1.0.0"), OS: Linux},
+	})
+	if !assert.Len(sboms, 4) {
+		return
+	}
+	assert.Equal(ImageSBOM{
+		Image:   image("rancher/fleet:v0.9.0"),
+		Digest:  fleet.Digest.String(),
+		Status:  SBOMFound,
+		Formats: []SBOMFormat{SBOMFormatCycloneDX},
+		Packages: []SBOMPackage{
+			{Name: "golang.org/x/net", Version: "v0.17.0", PURL: "pkg:golang/golang.org/x/net@v0.17.0", Licenses: []string{"BSD-3-Clause"}},
+			{Name: "golang.org/x/net/http2", Version: "v0.17.0"},
+		},
+	}, sboms[0])
+	assert.Equal(SBOMMissing, sboms[1].Status)
+	assert.Equal(SBOMUnknown, sboms[2].Status)
+	assert.NotEmpty(sboms[2].Error)
+	assert.Equal(ImageSBOM{
+		Image:   image("rancher/shell:v0.1.22"),
+		Digest:  shellDigest,
+		Status:  SBOMFound,
+		Formats: []SBOMFormat{SBOMFormatSPDX},
+		Packages: []SBOMPackage{
+			{Name: "busybox", Version: "1.36.1", PURL: "pkg:apk/alpine/busybox@1.36.1", Licenses: []string{"GPL-2.0-only"}},
+			{Name: "musl", Version: "1.2.4", Licenses: []string{"MIT"}},
+		},
+	}, sboms[3])
+}
+
+func TestNewReleaseBOM(t *testing.T) {
+	assert := assertlib.New(t)
+
+	const shellDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	bom, err := NewReleaseBOM("v2.8.0", []ImageSBOM{
+		{Image: "rancher/kubectl:v1.28.0", Status: SBOMMissing},
+		{Image: "rancher/shell:v0.1.22", Digest: shellDigest, Status: SBOMFound, Formats: []SBOMFormat{SBOMFormatSPDX},
+			Packages: []SBOMPackage{{Name: "musl", Version: "1.2.4", PURL: "pkg:apk/alpine/musl@1.2.4", Licenses: []string{"MIT"}}}},
+	})
+	assert.NoError(err)
+	assert.Equal("CycloneDX", bom.BOMFormat)
+	assert.Equal(&CycloneDXComponent{Type: "application", Name: "rancher", Version: "v2.8.0"}, bom.Metadata.Component)
+	assert.Equal([]CycloneDXComponent{
+		{
+			Type:       "container",
+			BOMRef:     "rancher/kubectl:v1.28.0",
+			Name:       "rancher/kubectl",
+			Version:    "v1.28.0",
+			Properties: []CycloneDXProperty{{Name: sbomStatusProperty, Value: "missing"}},
+		},
+		{
+			Type:       "container",
+			BOMRef:     "rancher/shell:v0.1.22",
+			Name:       "rancher/shell",
+			Version:    "v0.1.22",
+			PURL:       "pkg:oci/shell@sha256%3A1111111111111111111111111111111111111111111111111111111111111111?repository_url=docker.io/rancher/shell",
+			Properties: []CycloneDXProperty{{Name: sbomStatusProperty, Value: "found"}},
+			Components: []CycloneDXComponent{{
+				Type:     "library",
+				Name:     "musl",
+				Version:  "1.2.4",
+				PURL:     "pkg:apk/alpine/musl@1.2.4",
+				Licenses: []CycloneDXLicense{{Expression: "MIT"}},
+			}},
+		},
+	}, bom.Components)
+}
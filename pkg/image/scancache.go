@@ -0,0 +1,175 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scanCacheVersion is the version of the format of the charts stored in a ScanCache, and of the way charts are
+// scanned: bumping it makes exports scan again the charts scanned by previous versions.
+const scanCacheVersion = 1
+
+// ScanCache persists the images found in the chart tgz files scanned by exports on disk, so that repeated exports,
+// e.g. the nightly generation of the image lists, only scan the charts that changed since. Scanned charts are keyed by
+// the digest of their tgz file, along with the settings of the export that affect which of their images are exported.
+// All methods are safe to call on a nil cache, in which case charts are scanned every time.
+type ScanCache struct {
+	// Dir is the directory the scanned charts are stored in.
+	Dir string `yaml:"dir"`
+}
+
+// chartScan describes a scan of a chart tgz file, and keys the scanned chart in a ScanCache.
+type chartScan struct {
+	Version      int          `json:"version"`
+	Digest       string       `json:"digest"`
+	Chart        string       `json:"chart"`
+	TagToIgnore  string       `json:"tagToIgnore,omitempty"`
+	Arches       []Arch       `json:"arches,omitempty"`
+	OSTypes      []OSType     `json:"osTypes,omitempty"`
+	ValuesLimits ValuesLimits `json:"valuesLimits"`
+}
+
+// scannedChart is what was found scanning the values files of a chart, as stored in a ScanCache.
+type scannedChart struct {
+	Images   []scannedImage `json:"images"`
+	Warnings []ChartWarning `json:"warnings,omitempty"`
+}
+
+// scannedImage is an image found in the values files of a chart, with the architectures they restrict it to and the
+// key paths of the values defining it.
+type scannedImage struct {
+	OS          OSType   `json:"os"`
+	Image       string   `json:"image"`
+	Arches      []Arch   `json:"arches,omitempty"`
+	ValuesPaths []string `json:"valuesPaths,omitempty"`
+}
+
+type scanCacheContextKey struct{}
+
+// WithScanCache returns a context carrying cache, which the exports run with it read the images of the charts they
+// already scanned from.
+func WithScanCache(ctx context.Context, cache *ScanCache) context.Context {
+	if cache == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, scanCacheContextKey{}, cache)
+}
+
+// scanCacheFromContext returns the scan cache of ctx, or nil if it has none.
+func scanCacheFromContext(ctx context.Context) *ScanCache {
+	cache, _ := ctx.Value(scanCacheContextKey{}).(*ScanCache)
+	return cache
+}
+
+// scan adds the images of the chart tgz file at tgzPath to imagesSet. The images are read from the cache if the chart
+// was scanned with the same settings before, in which case the warnings of the chart are logged again, and found by
+// scanChart otherwise. scan describes the scan of the chart but for its digest, which is that of the tgz file. A cache
+// that cannot be read or written is logged and bypassed.
+func (c *ScanCache) scan(imagesSet *ImageSet, tgzPath string, scan chartScan, scanChart func(*ImageSet) error) error {
+	if c == nil || c.Dir == "" {
+		return scanChart(imagesSet)
+	}
+	content, err := os.ReadFile(tgzPath)
+	if err != nil {
+		return err
+	}
+	scan.Version = scanCacheVersion
+	scan.Digest = contentDigest(content)
+	path, err := c.path(scan)
+	if err != nil {
+		return err
+	}
+	scanned, err := c.read(path)
+	if err != nil {
+		logrus.Debugf("[scan-cache] ignoring cached scan of chart %s: %v", scan.Chart, err)
+	}
+	if scanned != nil {
+		for _, warning := range scanned.Warnings {
+			logrus.Warnf("%v", warning)
+		}
+		scanned.addTo(imagesSet, scan.Chart)
+		return nil
+	}
+
+	// The chart is scanned into a set of its own, holding the images of every OS type for later exports
+	chartImagesSet := NewImageSet(RegisteredOSTypes()...)
+	if err := scanChart(chartImagesSet); err != nil {
+		return err
+	}
+	scanned = newScannedChart(chartImagesSet, scan.Chart)
+	if err := c.write(path, scanned); err != nil {
+		logrus.Warnf("[scan-cache] failed to cache the scan of chart %s: %v", scan.Chart, err)
+	}
+	scanned.addTo(imagesSet, scan.Chart)
+	return nil
+}
+
+// path returns the path scan is stored at in the cache.
+func (c *ScanCache) path(scan chartScan) (string, error) {
+	key, err := json.Marshal(scan)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(key)
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// read returns the scanned chart stored at path, or nil if there is none.
+func (c *ScanCache) read(path string) (*scannedChart, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var scanned scannedChart
+	if err := json.Unmarshal(content, &scanned); err != nil {
+		return nil, err
+	}
+	return &scanned, nil
+}
+
+func (c *ScanCache) write(path string, scanned *scannedChart) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(scanned)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, content)
+}
+
+// newScannedChart returns the images and warnings of chartImagesSet, the images found scanning chartNameAndVersion.
+func newScannedChart(chartImagesSet *ImageSet, chartNameAndVersion string) *scannedChart {
+	scanned := &scannedChart{Warnings: chartImagesSet.ChartWarnings()}
+	for _, entry := range chartImagesSet.ListAll() {
+		scanned.Images = append(scanned.Images, scannedImage{
+			OS:          entry.OS,
+			Image:       entry.Image,
+			Arches:      entry.Arches,
+			ValuesPaths: entry.ValuesPaths[chartNameAndVersion],
+		})
+	}
+	return scanned
+}
+
+// addTo adds the images and warnings of the scanned chart to imagesSet, without logging the warnings.
+func (s *scannedChart) addTo(imagesSet *ImageSet, chartNameAndVersion string) {
+	for _, image := range s.Images {
+		if len(image.ValuesPaths) == 0 {
+			imagesSet.AddChartImageForArches(image.OS, image.Image, chartNameAndVersion, "", image.Arches...)
+		}
+		for _, valuesPath := range image.ValuesPaths {
+			imagesSet.AddChartImageForArches(image.OS, image.Image, chartNameAndVersion, valuesPath, image.Arches...)
+		}
+	}
+	imagesSet.warnings = append(imagesSet.warnings, s.Warnings...)
+}
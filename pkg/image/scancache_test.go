@@ -0,0 +1,88 @@
+package image
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+const scanCacheValues = `agent:
+  image:
+    repository: rancher/agent
+    tag: v1.0.0
+    os: linux,windows
+    arch: amd64,mips64le
+controller:
+  repository: rancher/controller
+  tag: v1.0.0
+`
+
+func TestScanCache(t *testing.T) {
+	assert := assertlib.New(t)
+
+	chartsPath := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(chartsPath, "index.yaml"), []byte(`apiVersion: v1
+entries:
+  chart:
+  - name: chart
+    version: 1.0.0
+    urls:
+    - assets/chart/chart-1.0.0.tgz
+`), 0644))
+	tgzPath := filepath.Join(chartsPath, "assets", "chart", "chart-1.0.0.tgz")
+	assert.NoError(os.MkdirAll(filepath.Dir(tgzPath), 0755))
+	assert.NoError(writeChartTgz(tgzPath, "chart", "1.0.0", map[string][]byte{"values.yaml": []byte(scanCacheValues)}))
+
+	config := ExportConfig{ChartsPath: chartsPath, RancherVersion: "2.8.0"}
+	fetch := func(ctx context.Context, osTypes ...OSType) (ImageList, []ChartWarning) {
+		imagesSet := NewImageSet(osTypes...)
+		assert.NoError(Charts{config}.FetchImages(ctx, imagesSet))
+		return imagesSet.ListAll(), imagesSet.ChartWarnings()
+	}
+	expectedImages, expectedWarnings := fetch(context.Background(), Linux)
+	assert.Len(expectedImages, 2)
+	assert.Len(expectedWarnings, 1)
+
+	cache := &ScanCache{Dir: filepath.Join(t.TempDir(), "scans")}
+	ctx := WithScanCache(context.Background(), cache)
+	images, warnings := fetch(ctx, Linux)
+	assert.Equal(expectedImages, images)
+	assert.Equal(expectedWarnings, warnings)
+	scans, err := filepath.Glob(filepath.Join(cache.Dir, "*.json"))
+	assert.NoError(err)
+	assert.Len(scans, 1)
+
+	// The cached scan holds the images of every OS type, whatever the OS types of the export scanning the chart
+	windowsImages, _ := fetch(ctx, Windows)
+	assert.Equal([]string{"rancher/agent:v1.0.0"}, windowsImages.Images())
+	assert.Equal([]Arch{AMD64}, windowsImages[0].Arches)
+	assert.Equal(map[string][]string{"chart:1.0.0": {"agent.image"}}, windowsImages[0].ValuesPaths)
+
+	// Cached scans are read instead of scanning the chart again
+	var scanned scannedChart
+	content, err := os.ReadFile(scans[0])
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(content, &scanned))
+	scanned.Images = append(scanned.Images, scannedImage{OS: Linux, Image: "rancher/cached:v1.0.0"})
+	content, err = json.Marshal(scanned)
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(scans[0], content, 0644))
+	images, warnings = fetch(ctx, Linux)
+	assert.Contains(images.Images(), "rancher/cached:v1.0.0")
+	assert.Equal(expectedWarnings, warnings)
+
+	// Charts are scanned again when their content changes or the export settings affecting them do
+	assert.NoError(writeChartTgz(tgzPath, "chart", "1.0.0", map[string][]byte{"values.yaml": []byte(scanCacheValues + "# changed\n")}))
+	images, _ = fetch(ctx, Linux)
+	assert.Equal(expectedImages, images)
+	config.ChartArches = map[string][]Arch{"chart": {ARM64}}
+	images, _ = fetch(ctx, Linux)
+	assert.Equal([]string{"rancher/controller:v1.0.0"}, images.Images())
+	scans, err = filepath.Glob(filepath.Join(cache.Dir, "*.json"))
+	assert.NoError(err)
+	assert.Len(scans, 3)
+}
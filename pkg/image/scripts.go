@@ -0,0 +1,513 @@
+package image
+
+import (
+	"io"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// ScriptRuntime is the container runtime used by the scripts generated by WriteSaveScript and WriteLoadScript.
+type ScriptRuntime string
+
+const (
+	// DockerRuntime generates scripts using the docker CLI.
+	DockerRuntime ScriptRuntime = "docker"
+	// ContainerdRuntime generates scripts using the containerd ctr CLI.
+	ContainerdRuntime ScriptRuntime = "containerd"
+)
+
+// scriptData is what the script templates are rendered with.
+type scriptData struct {
+	LinuxImages   []string
+	WindowsImages []string
+}
+
+// WriteSaveScript writes a bash script to w that pulls the Linux images of list and saves them to
+// rancher-images.tar.gz with runtime. The images are embedded in the script, so it always matches list, but another
+// image list file can still be given to the script with --image-list.
+func WriteSaveScript(w io.Writer, list ImageList, runtime ScriptRuntime) error {
+	return writeScript(w, list, runtime, map[ScriptRuntime]*template.Template{
+		DockerRuntime:     dockerSaveScriptTemplate,
+		ContainerdRuntime: containerdSaveScriptTemplate,
+	})
+}
+
+// WriteLoadScript writes a bash script to w that loads the images saved by the script of WriteSaveScript with runtime,
+// and pushes them to a private registry. With docker, the Windows images of list are pushed along with the Linux
+// images as multi-arch manifests when the script is given --windows-versions.
+func WriteLoadScript(w io.Writer, list ImageList, runtime ScriptRuntime) error {
+	return writeScript(w, list, runtime, map[ScriptRuntime]*template.Template{
+		DockerRuntime:     dockerLoadScriptTemplate,
+		ContainerdRuntime: containerdLoadScriptTemplate,
+	})
+}
+
+func writeScript(w io.Writer, list ImageList, runtime ScriptRuntime, templates map[ScriptRuntime]*template.Template) error {
+	tmpl, ok := templates[runtime]
+	if !ok {
+		return errors.Errorf("unknown script runtime %q", runtime)
+	}
+	return tmpl.Execute(w, scriptData{
+		LinuxImages:   list.ForOS(Linux).Images(),
+		WindowsImages: list.ForOS(Windows).Images(),
+	})
+}
+
+// scriptImageFunctions are the bash functions shared by the generated scripts to read the images to process.
+const scriptImageFunctions = `
+# read_images sets the images array from the file given as first argument, or from the images embedded in this
+# script if no file is given.
+read_images () {
+    if [[ -z "$1" ]]; then
+        images_to_process=("${embedded_images[@]}")
+        return
+    fi
+    images_to_process=()
+    while IFS= read -r i; do
+        [ -z "${i}" ] && continue
+        images_to_process+=("${i}")
+    done < "$1"
+}
+`
+
+// scriptTargetFunction is the bash function shared by the generated load scripts to name the images to push.
+const scriptTargetFunction = `
+# target_image prints the name of image $1 in the target registry. Images without a repository are pushed to the
+# rancher repository. The digest of pinned images is dropped, since a tag cannot be created with a digest.
+target_image () {
+    local image="${1%@*}"
+    case ${image} in
+    */*)
+        echo "${target_registry}${image}"
+        ;;
+    *)
+        echo "${target_registry}rancher/${image}"
+        ;;
+    esac
+}
+`
+
+// scriptQualifyFunction is the bash function used by the containerd scripts, since ctr requires fully qualified
+// image references.
+const scriptQualifyFunction = `
+# qualify prints the fully qualified reference of image $1 in the source registry.
+qualify () {
+    if [[ -n "${source_registry}" ]]; then
+        echo "${source_registry}$1"
+        return
+    fi
+    if [[ "$1" != */* ]]; then
+        echo "docker.io/library/$1"
+        return
+    fi
+    case "${1%%/*}" in
+    *.*|*:*|localhost)
+        echo "$1"
+        ;;
+    *)
+        echo "docker.io/$1"
+        ;;
+    esac
+}
+`
+
+const scriptEmbeddedImages = `
+embedded_images=(
+{{- range .LinuxImages}}
+    "{{.}}"
+{{- end}}
+)
+`
+
+var dockerSaveScriptTemplate = template.Must(template.New("docker-save").Parse(`#!/bin/bash
+# Generated from the Rancher image list by pkg/image, do not edit.
+list=""
+images="rancher-images.tar.gz"
+source_registry=""
+` + scriptEmbeddedImages + scriptImageFunctions + `
+usage () {
+    echo "USAGE: $0 [--image-list rancher-images.txt] [--images rancher-images.tar.gz]"
+    echo "  [-s|--source-registry] source registry to pull images from in registry:port format."
+    echo "  [-l|--image-list path] text file with list of images; one image per line. Defaults to the images embedded in this script."
+    echo "  [-i|--images path] tar.gz generated by docker save."
+    echo "  [-h|--help] Usage message"
+}
+
+while [[ $# -gt 0 ]]; do
+    key="$1"
+    case $key in
+        -i|--images)
+        images="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -l|--image-list)
+        list="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -s|--source-registry)
+        source_registry="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -h|--help)
+        help="true"
+        shift
+        ;;
+        *)
+        usage
+        exit 1
+        ;;
+    esac
+done
+
+if [[ $help ]]; then
+    usage
+    exit 0
+fi
+
+source_registry="${source_registry%/}"
+if [ ! -z "${source_registry}" ]; then
+    source_registry="${source_registry}/"
+fi
+
+read_images "${list}"
+pulled=()
+for i in "${images_to_process[@]}"; do
+    i="${source_registry}${i}"
+    if docker pull "${i}" > /dev/null 2>&1; then
+        echo "Image pull success: ${i}"
+        pulled+=("${i}")
+    else
+        if docker inspect "${i}" > /dev/null 2>&1; then
+            pulled+=("${i}")
+        else
+            echo "Image pull failed: ${i}"
+        fi
+    fi
+done
+
+echo "Creating ${images} with ${#pulled[@]} images"
+docker save "${pulled[@]}" | gzip --stdout > "${images}"
+`))
+
+var dockerLoadScriptTemplate = template.Must(template.New("docker-load").Parse(`#!/bin/bash
+# Generated from the Rancher image list by pkg/image, do not edit.
+list=""
+images="rancher-images.tar.gz"
+windows_versions=""
+source_registry=""
+` + scriptEmbeddedImages + `
+windows_images=(
+{{- range .WindowsImages}}
+    "{{.}}"
+{{- end}}
+)
+` + scriptImageFunctions + scriptTargetFunction + `
+usage () {
+    echo "USAGE: $0 [--images rancher-images.tar.gz] [--source-registry index.docker.io] --registry my.registry.com:5000"
+    echo "  [-l|--image-list path] text file with list of images; one image per line. Defaults to the images embedded in this script."
+    echo "  [-i|--images path] tar.gz generated by docker save."
+    echo "  [-r|--registry registry:port] target private registry in the registry:port format."
+    echo "  [-s|--source-registry registry:port] source registry in the registry:port format."
+    echo "  [--windows-versions version] Comma separated Windows versions, e.g. \"1809,ltsc2022\". Windows image mirroring is skipped when this is empty."
+    echo "  [-h|--help] Usage message"
+}
+
+push_manifest () {
+    export DOCKER_CLI_EXPERIMENTAL=enabled
+    manifest_list=()
+    for i in "${arch_list[@]}"
+    do
+        manifest_list+=("$1-${i}")
+    done
+
+    echo "Preparing manifest $1, list[${arch_list[@]}]"
+    docker manifest create "$1" "${manifest_list[@]}" --amend
+    docker manifest push "$1" --purge
+}
+
+while [[ $# -gt 0 ]]; do
+    key="$1"
+    case $key in
+        -r|--registry)
+        target_registry="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -s|--source-registry)
+        source_registry="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -l|--image-list)
+        list="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -i|--images)
+        images="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        --windows-versions)
+        windows_versions="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -h|--help)
+        help="true"
+        shift
+        ;;
+        *)
+        usage
+        exit 1
+        ;;
+    esac
+done
+if [[ $help ]]; then
+    usage
+    exit 0
+fi
+if [[ -z "${target_registry}" ]]; then
+    usage
+    exit 1
+fi
+
+target_registry="${target_registry%/}/"
+source_registry="${source_registry%/}"
+if [ ! -z "${source_registry}" ]; then
+    source_registry="${source_registry}/"
+fi
+
+docker load --input "${images}"
+
+read_images "${list}"
+linux_images=("${images_to_process[@]}")
+
+arch_list=()
+if [[ -n "${windows_versions}" ]]; then
+    IFS=',' read -r -a versions <<< "$windows_versions"
+    for version in "${versions[@]}"
+    do
+        arch_list+=("windows-${version}")
+    done
+
+    # use manifest to publish images only used in Windows
+    for i in "${windows_images[@]}"; do
+        if [[ ! " ${linux_images[@]} " =~ " ${i} " ]]; then
+            push_manifest "$(target_image "${i}")"
+        fi
+    done
+fi
+
+arch_list+=("linux-amd64")
+for i in "${linux_images[@]}"; do
+    arch_suffix=""
+    use_manifest=false
+    if [[ (-n "${windows_versions}") && " ${windows_images[@]} " =~ " ${i} " ]]; then
+        # use manifest to publish images when it is used both in Linux and Windows
+        use_manifest=true
+        arch_suffix="-linux-amd64"
+    fi
+    image_name="$(target_image "${i}")"
+
+    docker tag "${source_registry}${i}" "${image_name}${arch_suffix}"
+    docker push "${image_name}${arch_suffix}"
+
+    if $use_manifest; then
+        push_manifest "${image_name}"
+    fi
+done
+`))
+
+var containerdSaveScriptTemplate = template.Must(template.New("containerd-save").Parse(`#!/bin/bash
+# Generated from the Rancher image list by pkg/image, do not edit.
+set -e
+list=""
+images="rancher-images.tar.gz"
+source_registry=""
+namespace="default"
+platform="linux/amd64"
+` + scriptEmbeddedImages + scriptImageFunctions + scriptQualifyFunction + `
+usage () {
+    echo "USAGE: $0 [--image-list rancher-images.txt] [--images rancher-images.tar.gz]"
+    echo "  [-s|--source-registry] source registry to pull images from in registry:port format."
+    echo "  [-l|--image-list path] text file with list of images; one image per line. Defaults to the images embedded in this script."
+    echo "  [-i|--images path] tar.gz generated by ctr images export."
+    echo "  [-n|--namespace namespace] containerd namespace to pull the images to. (Default \"default\")"
+    echo "  [-p|--platform platform] platform of the images to save. (Default \"linux/amd64\")"
+    echo "  [-h|--help] Usage message"
+}
+
+while [[ $# -gt 0 ]]; do
+    key="$1"
+    case $key in
+        -i|--images)
+        images="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -l|--image-list)
+        list="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -s|--source-registry)
+        source_registry="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -n|--namespace)
+        namespace="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -p|--platform)
+        platform="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -h|--help)
+        help="true"
+        shift
+        ;;
+        *)
+        usage
+        exit 1
+        ;;
+    esac
+done
+
+if [[ $help ]]; then
+    usage
+    exit 0
+fi
+
+source_registry="${source_registry%/}"
+if [ ! -z "${source_registry}" ]; then
+    source_registry="${source_registry}/"
+fi
+
+read_images "${list}"
+pulled=()
+for i in "${images_to_process[@]}"; do
+    ref="$(qualify "${i}")"
+    if ctr -n "${namespace}" images pull --platform "${platform}" "${ref}" > /dev/null 2>&1; then
+        echo "Image pull success: ${ref}"
+        pulled+=("${ref}")
+    else
+        echo "Image pull failed: ${ref}"
+    fi
+done
+
+echo "Creating ${images} with ${#pulled[@]} images"
+archive="$(mktemp)"
+trap 'rm -f "${archive}"' EXIT
+ctr -n "${namespace}" images export --platform "${platform}" "${archive}" "${pulled[@]}"
+gzip --stdout "${archive}" > "${images}"
+`))
+
+var containerdLoadScriptTemplate = template.Must(template.New("containerd-load").Parse(`#!/bin/bash
+# Generated from the Rancher image list by pkg/image, do not edit.
+set -e
+list=""
+images="rancher-images.tar.gz"
+source_registry=""
+namespace="default"
+user=""
+plain_http=""
+` + scriptEmbeddedImages + scriptImageFunctions + scriptQualifyFunction + scriptTargetFunction + `
+usage () {
+    echo "USAGE: $0 [--images rancher-images.tar.gz] [--source-registry index.docker.io] --registry my.registry.com:5000"
+    echo "  [-l|--image-list path] text file with list of images; one image per line. Defaults to the images embedded in this script."
+    echo "  [-i|--images path] tar.gz generated by the save script."
+    echo "  [-r|--registry registry:port] target private registry in the registry:port format."
+    echo "  [-s|--source-registry registry:port] source registry the images were saved from, in the registry:port format."
+    echo "  [-n|--namespace namespace] containerd namespace to import the images to. (Default \"default\")"
+    echo "  [-u|--user user:password] credentials of the target registry."
+    echo "  [--plain-http] push to the target registry over HTTP."
+    echo "  [-h|--help] Usage message"
+}
+
+while [[ $# -gt 0 ]]; do
+    key="$1"
+    case $key in
+        -r|--registry)
+        target_registry="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -s|--source-registry)
+        source_registry="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -l|--image-list)
+        list="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -i|--images)
+        images="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -n|--namespace)
+        namespace="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        -u|--user)
+        user="$2"
+        shift # past argument
+        shift # past value
+        ;;
+        --plain-http)
+        plain_http="true"
+        shift
+        ;;
+        -h|--help)
+        help="true"
+        shift
+        ;;
+        *)
+        usage
+        exit 1
+        ;;
+    esac
+done
+if [[ $help ]]; then
+    usage
+    exit 0
+fi
+if [[ -z "${target_registry}" ]]; then
+    usage
+    exit 1
+fi
+
+target_registry="${target_registry%/}/"
+source_registry="${source_registry%/}"
+if [ ! -z "${source_registry}" ]; then
+    source_registry="${source_registry}/"
+fi
+
+push_args=()
+if [[ -n "${user}" ]]; then
+    push_args+=(--user "${user}")
+fi
+if [[ -n "${plain_http}" ]]; then
+    push_args+=(--plain-http)
+fi
+
+gzip --decompress --stdout "${images}" | ctr -n "${namespace}" images import -
+
+read_images "${list}"
+for i in "${images_to_process[@]}"; do
+    image_name="$(target_image "${i}")"
+    ctr -n "${namespace}" images tag --force "$(qualify "${i}")" "${image_name}"
+    ctr -n "${namespace}" images push "${push_args[@]}" "${image_name}"
+done
+`))
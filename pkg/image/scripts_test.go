@@ -0,0 +1,39 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestWriteScripts(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/rancher:v2.8.0", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/wins:v0.4.12", OS: Windows},
+	}
+
+	for _, runtime := range []ScriptRuntime{DockerRuntime, ContainerdRuntime} {
+		var save bytes.Buffer
+		assert.NoError(WriteSaveScript(&save, list, runtime))
+		assert.Contains(save.String(), "embedded_images=(\n    \"rancher/rancher:v2.8.0\"\n    \"rancher/shell:v0.1.22\"\n)")
+		assert.NotContains(save.String(), "rancher/wins")
+
+		var load bytes.Buffer
+		assert.NoError(WriteLoadScript(&load, list, runtime))
+		assert.Contains(load.String(), "\"rancher/shell:v0.1.22\"")
+	}
+
+	var dockerLoad bytes.Buffer
+	assert.NoError(WriteLoadScript(&dockerLoad, list, DockerRuntime))
+	assert.Contains(dockerLoad.String(), "windows_images=(\n    \"rancher/wins:v0.4.12\"\n)")
+
+	var containerdSave bytes.Buffer
+	assert.NoError(WriteSaveScript(&containerdSave, list, ContainerdRuntime))
+	assert.Contains(containerdSave.String(), "ctr -n \"${namespace}\" images export")
+
+	assert.EqualError(WriteSaveScript(&bytes.Buffer{}, list, "podman"), `unknown script runtime "podman"`)
+}
@@ -0,0 +1,525 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// The annotations of the layers of cosign signature manifests, holding the signature of the payload of the layer and,
+// for keyless signatures, the Fulcio certificate of the signer, its chain and the bundle of the Rekor transparency log
+// entry of the signature.
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// cosignPayloadType is the type of the simple signing payloads signed by cosign.
+const cosignPayloadType = "cosign container image signature"
+
+// maxSignaturePayloadSize is the maximum size of the signature payloads read from registries, which are small JSON
+// documents.
+const maxSignaturePayloadSize = 1 << 20
+
+// The OIDs of the Fulcio certificate extensions holding the OIDC issuer of the identity of the signer: the first one
+// holds the raw issuer and is deprecated by the second one, which holds it as a DER encoded UTF8String.
+var (
+	fulcioIssuerOID   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// SignatureStatus is the outcome of the verification of the cosign signatures of an image.
+type SignatureStatus string
+
+const (
+	// SignatureVerified images have a signature verified by the signature policy.
+	SignatureVerified SignatureStatus = "verified"
+	// SignatureUnsigned images have no cosign signature.
+	SignatureUnsigned SignatureStatus = "unsigned"
+	// SignatureInvalid images have cosign signatures, none of which the signature policy verifies.
+	SignatureInvalid SignatureStatus = "invalid"
+	// SignatureUnknown images or signatures could not be looked up.
+	SignatureUnknown SignatureStatus = "unknown"
+)
+
+// SignaturePolicy is what the cosign signatures of images are verified against: signatures verify if they are made
+// by one of the public keys, or by the keyless identity. At least one of them is required.
+type SignaturePolicy struct {
+	// PublicKeys are the ECDSA, RSA or Ed25519 public keys of keyed signatures.
+	PublicKeys []crypto.PublicKey
+	// Keyless, if set, is the identity of keyless signatures.
+	Keyless *KeylessIdentity
+}
+
+// KeylessIdentity is the identity keyless signatures are made with: their Fulcio certificate must chain to Roots and
+// be issued for Subject by Issuer, and the signature must be in the Rekor transparency log, which is verified offline
+// with the signed entry timestamp of the bundle of the signature.
+type KeylessIdentity struct {
+	// Roots are the Fulcio root certificates.
+	Roots *x509.CertPool
+	// Intermediates are the Fulcio intermediate certificates, in addition to the chain of the signatures.
+	Intermediates *x509.CertPool
+	// Issuer is the OIDC issuer of the identity of the signer, e.g. https://token.actions.githubusercontent.com. Any
+	// issuer is accepted if empty.
+	Issuer string
+	// Subject matches the email or URI subject alternative name of the certificate of the signer, e.g. the URI of the
+	// workflow signing the images.
+	Subject *regexp.Regexp
+	// RekorPublicKey is the public key of the Rekor transparency log.
+	RekorPublicKey crypto.PublicKey
+}
+
+// SignatureVerification is the verification of the cosign signatures of an image.
+type SignatureVerification struct {
+	// Image is the verified image.
+	Image string `json:"image"`
+	// Digest is the digest of the image the signatures are for, empty if it could not be looked up.
+	Digest string `json:"digest,omitempty"`
+	// Status is the outcome of the verification.
+	Status SignatureStatus `json:"status"`
+	// Signer is the signer of the verified signature: the SHA-256 fingerprint of the public key of keyed signatures,
+	// the subject of the certificate of keyless signatures.
+	Signer string `json:"signer,omitempty"`
+	// Error is why the signatures of invalid images did not verify, or why the signatures of unknown images could not
+	// be looked up.
+	Error string `json:"error,omitempty"`
+	// ErrorClass is the class of the error of unknown images, see ClassifyError.
+	ErrorClass ErrorClass `json:"errorClass,omitempty"`
+}
+
+// SignatureReport is the verification of the cosign signatures of the images of an image list.
+type SignatureReport struct {
+	// VerifiedAt is when the signatures were verified.
+	VerifiedAt time.Time `json:"verifiedAt"`
+	// Images are the verifications of the images, sorted by image.
+	Images []SignatureVerification `json:"images"`
+}
+
+// Count returns the number of images of the report with status.
+func (r SignatureReport) Count(status SignatureStatus) int {
+	count := 0
+	for _, image := range r.Images {
+		if image.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// ParsePublicKey parses the PEM encoded public key of keyed signatures, as written by cosign generate-key-pair.
+func ParsePublicKey(content []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key")
+	}
+	return key, nil
+}
+
+// VerifySignatures verifies the cosign signatures of each image of list against policy. The signatures are looked up
+// in the registry of the image, under the sha256-DIGEST.sig tag cosign stores them at, and are verified offline.
+// Images exported for several OS types are verified once.
+func (c RegistryClient) VerifySignatures(ctx context.Context, list ImageList, policy SignaturePolicy) (SignatureReport, error) {
+	if len(policy.PublicKeys) == 0 && policy.Keyless == nil {
+		return SignatureReport{}, errors.New("a public key or a keyless identity is required")
+	}
+	if keyless := policy.Keyless; keyless != nil && (keyless.Roots == nil || keyless.Subject == nil || keyless.RekorPublicKey == nil) {
+		return SignatureReport{}, errors.New("keyless identities require Fulcio roots, a subject and a Rekor public key")
+	}
+	var unique ImageList
+	seen := make(map[string]bool, len(list))
+	for _, entry := range list {
+		if !seen[entry.Image] {
+			seen[entry.Image] = true
+			unique = append(unique, entry)
+		}
+	}
+
+	report := SignatureReport{VerifiedAt: time.Now().UTC()}
+	var mu sync.Mutex
+	c.forEach(unique, func(entry *ImageEntry) {
+		verification := c.verifySignatures(ctx, *entry, policy)
+		mu.Lock()
+		defer mu.Unlock()
+		report.Images = append(report.Images, verification)
+	})
+	sort.Slice(report.Images, func(i, j int) bool {
+		return report.Images[i].Image < report.Images[j].Image
+	})
+	return report, nil
+}
+
+// verifySignatures verifies the cosign signatures of the image of entry against policy.
+func (c RegistryClient) verifySignatures(ctx context.Context, entry ImageEntry, policy SignaturePolicy) SignatureVerification {
+	verification := SignatureVerification{Image: entry.Image}
+	imageDigest, err := c.Digest(ctx, entry.Image, entry.OS)
+	if err != nil {
+		verification.Status = SignatureUnknown
+		verification.Error = err.Error()
+		verification.ErrorClass = ClassifyError(err)
+		return verification
+	}
+	verification.Digest = imageDigest
+
+	signatures, err := c.signatures(ctx, entry, imageDigest)
+	switch {
+	case isManifestUnknown(err):
+		verification.Status = SignatureUnsigned
+		return verification
+	case err != nil:
+		verification.Status = SignatureUnknown
+		verification.Error = err.Error()
+		verification.ErrorClass = ClassifyError(err)
+		return verification
+	case len(signatures) == 0:
+		verification.Status = SignatureUnsigned
+		return verification
+	}
+
+	var errs []string
+	for _, signature := range signatures {
+		signer, err := policy.verify(signature, imageDigest)
+		if err == nil {
+			verification.Status = SignatureVerified
+			verification.Signer = signer
+			return verification
+		}
+		errs = append(errs, err.Error())
+	}
+	verification.Status = SignatureInvalid
+	verification.Error = strings.Join(errs, "; ")
+	return verification
+}
+
+// cosignSignature is a layer of a cosign signature manifest: the signed payload, and the annotations holding its
+// signature.
+type cosignSignature struct {
+	payload     []byte
+	annotations map[string]string
+}
+
+// cosignImage returns the image cosign stores the artifacts of image, whose manifest has imageDigest, as: its
+// signatures for the sig suffix, its attestations for the att suffix.
+func cosignImage(image, imageDigest, suffix string) string {
+	return imageRepository(image) + ":" + strings.Replace(imageDigest, ":", "-", 1) + "." + suffix
+}
+
+// signatures returns the cosign signatures of the image of entry, whose manifest has imageDigest.
+func (c RegistryClient) signatures(ctx context.Context, entry ImageEntry, imageDigest string) ([]cosignSignature, error) {
+	var signatures []cosignSignature
+	err := c.withImage(ctx, cosignImage(entry.Image, imageDigest, "sig"), entry.OS, func(ref types.ImageReference, sys *types.SystemContext) error {
+		signatures = nil
+		src, err := ref.NewImageSource(ctx, sys)
+		if err != nil {
+			return errors.Wrapf(err, "failed to access signatures of image %s", entry.Image)
+		}
+		defer src.Close()
+
+		raw, _, err := src.GetManifest(ctx, nil)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get signatures of image %s", entry.Image)
+		}
+		var m imgspecv1.Manifest
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return errors.Wrapf(err, "failed to parse signatures of image %s", entry.Image)
+		}
+		for _, layer := range m.Layers {
+			payload, err := readBlob(ctx, src, layer, maxSignaturePayloadSize)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read signature of image %s", entry.Image)
+			}
+			signatures = append(signatures, cosignSignature{payload: payload, annotations: layer.Annotations})
+		}
+		return nil
+	})
+	return signatures, err
+}
+
+// readBlob reads the blob of src described by descriptor, of at most maxSize bytes, and verifies its digest.
+func readBlob(ctx context.Context, src types.ImageSource, descriptor imgspecv1.Descriptor, maxSize int64) ([]byte, error) {
+	if descriptor.Size > maxSize {
+		return nil, errors.Errorf("blob %s is larger than %d bytes", descriptor.Digest, maxSize)
+	}
+	stream, _, err := src.GetBlob(ctx, types.BlobInfo{Digest: descriptor.Digest, Size: descriptor.Size}, none.NoCache)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	content, err := io.ReadAll(io.LimitReader(stream, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, errors.Errorf("blob %s is larger than %d bytes", descriptor.Digest, maxSize)
+	}
+	if digest.FromBytes(content) != descriptor.Digest {
+		return nil, errors.Errorf("blob does not match its digest %s", descriptor.Digest)
+	}
+	return content, nil
+}
+
+// verify verifies signature, a signature of the image whose manifest has imageDigest, and returns its signer.
+// Signatures with a certificate are verified against the keyless identity of the policy, the others against its public
+// keys.
+func (p SignaturePolicy) verify(signature cosignSignature, imageDigest string) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(signature.annotations[cosignSignatureAnnotation])
+	if err != nil || len(sig) == 0 {
+		return "", errors.New("signature has no valid signature annotation")
+	}
+	var signer string
+	switch {
+	case signature.annotations[cosignCertificateAnnotation] != "" && p.Keyless != nil:
+		signer, err = p.Keyless.verify(signature, sig)
+	case len(p.PublicKeys) > 0:
+		signer, err = verifyWithKeys(p.PublicKeys, signature.payload, sig)
+	default:
+		err = errors.New("signature is not a keyless signature")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var payload struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(signature.payload, &payload); err != nil {
+		return "", errors.Wrap(err, "failed to parse signature payload")
+	}
+	if payload.Critical.Type != cosignPayloadType {
+		return "", errors.Errorf("signature payload has unexpected type %q", payload.Critical.Type)
+	}
+	if payload.Critical.Image.DockerManifestDigest != imageDigest {
+		return "", errors.Errorf("signature is for digest %s", payload.Critical.Image.DockerManifestDigest)
+	}
+	return signer, nil
+}
+
+// verifyWithKeys verifies sig, the signature of payload, with the first of keys it was made with, and returns the
+// fingerprint of that key.
+func verifyWithKeys(keys []crypto.PublicKey, payload, sig []byte) (string, error) {
+	for _, key := range keys {
+		if verifySignature(key, payload, sig) == nil {
+			return keyFingerprint(key)
+		}
+	}
+	return "", errors.New("signature is not made by any of the public keys")
+}
+
+// verifySignature verifies sig, the signature of payload made by key, like cosign does: ECDSA and RSA PKCS #1 v1.5
+// signatures are made over the SHA-256 digest of the payload, Ed25519 signatures over the payload itself.
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	sum := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, sum[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return errors.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// keyFingerprint returns the SHA-256 digest of the DER encoding of key.
+func keyFingerprint(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// rekorBundle is the bundle of the Rekor transparency log entry of a keyless signature.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte             `json:"SignedEntryTimestamp"`
+	Payload              rekorBundlePayload `json:"Payload"`
+}
+
+// rekorBundlePayload is the transparency log entry signed by the signed entry timestamp of a bundle. Its fields are in
+// the order of its canonical JSON encoding, which is what the timestamp signs.
+type rekorBundlePayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// hashedRekord is the body of the hashedrekord transparency log entries of signatures.
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verify verifies sig, the keyless signature of the payload of signature, and returns the subject of its certificate.
+func (k *KeylessIdentity) verify(signature cosignSignature, sig []byte) (string, error) {
+	certBlock, _ := pem.Decode([]byte(signature.annotations[cosignCertificateAnnotation]))
+	if certBlock == nil {
+		return "", errors.New("signature has no PEM encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse signature certificate")
+	}
+	integratedTime, err := k.verifyBundle(signature, sig, cert)
+	if err != nil {
+		return "", err
+	}
+
+	intermediates := x509.NewCertPool()
+	if k.Intermediates != nil {
+		intermediates = k.Intermediates.Clone()
+	}
+	intermediates.AppendCertsFromPEM([]byte(signature.annotations[cosignChainAnnotation]))
+	// Fulcio certificates are only valid for a few minutes, they are verified at the time the signature was logged
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         k.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   integratedTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return "", errors.Wrap(err, "failed to verify signature certificate")
+	}
+	if issuer := certificateIssuer(cert); k.Issuer != "" && issuer != k.Issuer {
+		return "", errors.Errorf("signature certificate is issued by %q", issuer)
+	}
+	subject, ok := k.matchSubject(cert)
+	if !ok {
+		return "", errors.Errorf("signature certificate is issued for %s", strings.Join(certificateSubjects(cert), ", "))
+	}
+	if err := verifySignature(cert.PublicKey, signature.payload, sig); err != nil {
+		return "", err
+	}
+	return subject, nil
+}
+
+// verifyBundle verifies that sig, the signature of the payload of signature made with cert, is logged in the Rekor
+// transparency log, and returns the time it was logged at.
+func (k *KeylessIdentity) verifyBundle(signature cosignSignature, sig []byte, cert *x509.Certificate) (time.Time, error) {
+	content := signature.annotations[cosignBundleAnnotation]
+	if content == "" {
+		return time.Time{}, errors.New("keyless signature has no transparency log bundle")
+	}
+	var bundle rekorBundle
+	if err := json.Unmarshal([]byte(content), &bundle); err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to parse transparency log bundle")
+	}
+	signed, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := verifySignature(k.RekorPublicKey, signed, bundle.SignedEntryTimestamp); err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to verify signed entry timestamp of transparency log bundle")
+	}
+
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to decode transparency log entry")
+	}
+	var entry hashedRekord
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, errors.Wrap(err, "failed to parse transparency log entry")
+	}
+	sum := sha256.Sum256(signature.payload)
+	logged, _ := pem.Decode(entry.Spec.Signature.PublicKey.Content)
+	switch {
+	case entry.Kind != "hashedrekord":
+		return time.Time{}, errors.Errorf("transparency log entry has unexpected kind %q", entry.Kind)
+	case entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(sum[:]):
+		return time.Time{}, errors.New("transparency log entry is not for the signature payload")
+	case !bytes.Equal(entry.Spec.Signature.Content, sig):
+		return time.Time{}, errors.New("transparency log entry is not for the signature")
+	case logged == nil || !bytes.Equal(logged.Bytes, cert.Raw):
+		return time.Time{}, errors.New("transparency log entry is not for the signature certificate")
+	}
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
+
+// certificateIssuer returns the OIDC issuer of the identity a Fulcio certificate is issued for.
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(fulcioIssuerV2OID) {
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(fulcioIssuerOID) {
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// certificateSubjects returns the email and URI subject alternative names of cert.
+func certificateSubjects(cert *x509.Certificate) []string {
+	subjects := append([]string{}, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		subjects = append(subjects, uri.String())
+	}
+	return subjects
+}
+
+// matchSubject returns the first subject alternative name of cert matching the subject of the identity.
+func (k *KeylessIdentity) matchSubject(cert *x509.Certificate) (string, bool) {
+	for _, subject := range certificateSubjects(cert) {
+		if k.Subject.MatchString(subject) {
+			return subject, true
+		}
+	}
+	return "", false
+}
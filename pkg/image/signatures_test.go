@@ -0,0 +1,256 @@
+package image
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+// signaturePayload returns the payload cosign signs for image, whose manifest has imageDigest.
+func signaturePayload(image, imageDigest string) []byte {
+	return []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s"},"image":{"docker-manifest-digest":"%s"},"type":"%s"},"optional":null}`,
+		image, imageDigest, cosignPayloadType))
+}
+
+// signPayload signs payload with key like cosign does.
+func signPayload(t *testing.T, key *ecdsa.PrivateKey, payload []byte) []byte {
+	sum := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	assertlib.NoError(t, err)
+	return sig
+}
+
+// addSignatures serves the signature manifest of repo, whose image has imageDigest, with a layer per signature.
+func (r *fakeRegistry) addSignatures(repo, imageDigest string, signatures ...cosignSignature) {
+	m := imgspecv1.Manifest{MediaType: imgspecv1.MediaTypeImageManifest}
+	m.SchemaVersion = 2
+	config := []byte("{}")
+	m.Config = imgspecv1.Descriptor{MediaType: imgspecv1.MediaTypeImageConfig, Size: int64(len(config)), Digest: digest.FromBytes(config)}
+	r.AddBlob(config)
+	for _, signature := range signatures {
+		r.AddBlob(signature.payload)
+		m.Layers = append(m.Layers, imgspecv1.Descriptor{
+			MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+			Size:        int64(len(signature.payload)),
+			Digest:      digest.FromBytes(signature.payload),
+			Annotations: signature.annotations,
+		})
+	}
+	body, _ := json.Marshal(m)
+	r.AddManifest(repo, strings.Replace(imageDigest, ":", "-", 1)+".sig", imgspecv1.MediaTypeImageManifest, body)
+}
+
+func TestRegistryClientVerifySignatures(t *testing.T) {
+	assert := assertlib.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	registry := newFakeRegistry(t)
+	sign := func(signingKey *ecdsa.PrivateKey, payload []byte) cosignSignature {
+		return cosignSignature{payload: payload, annotations: map[string]string{
+			cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signPayload(t, signingKey, payload)),
+		}}
+	}
+
+	shellDigest := registry.AddImage("rancher/shell", "v0.1.22", []byte("shell"))
+	registry.addSignatures("rancher/shell", shellDigest, sign(otherKey, signaturePayload("rancher/shell", shellDigest)),
+		sign(key, signaturePayload("rancher/shell", shellDigest)))
+	agentDigest := registry.AddImage("rancher/rancher-agent", "v2.8.0", []byte("agent"))
+	registry.addSignatures("rancher/rancher-agent", agentDigest, sign(otherKey, signaturePayload("rancher/rancher-agent", agentDigest)))
+	fleetDigest := registry.AddImage("rancher/fleet", "v0.9.0", []byte("fleet"))
+	registry.addSignatures("rancher/fleet", fleetDigest, sign(key, signaturePayload("rancher/fleet", shellDigest)))
+	registry.AddImage("rancher/kubectl", "v1.28.0", []byte("kubectl"))
+
+	image := func(name string) string {
+		return registry.Host() + "/" + name
+	}
+	report, err := registry.client().VerifySignatures(context.Background(), ImageList{
+		{Image: image("rancher/shell:v0.1.22"), OS: Linux},
+		{Image: image("rancher/shell:v0.1.22"), OS: Windows},
+		{Image: image("rancher/rancher-agent:v2.8.0"), OS: Linux},
+		{Image: image("rancher/fleet:v0.9.0"), OS: Linux},
+		{Image: image("rancher/kubectl:v1.28.0"), OS: Linux},
+		{Image: image("rancher/missing:v1.0.0"), OS: Linux},
+	}, SignaturePolicy{PublicKeys: []crypto.PublicKey{&key.PublicKey}})
+	assert.NoError(err)
+	fingerprint, err := keyFingerprint(&key.PublicKey)
+	assert.NoError(err)
+	if assert.Len(report.Images, 5) {
+		assert.Equal(SignatureVerification{Image: image("rancher/fleet:v0.9.0"), Digest: fleetDigest, Status: SignatureInvalid,
+			Error: "signature is for digest " + shellDigest}, report.Images[0])
+		assert.Equal(SignatureVerification{Image: image("rancher/kubectl:v1.28.0"), Digest: report.Images[1].Digest, Status: SignatureUnsigned}, report.Images[1])
+		assert.Equal(SignatureUnknown, report.Images[2].Status)
+		assert.Equal(SignatureVerification{Image: image("rancher/rancher-agent:v2.8.0"), Digest: agentDigest, Status: SignatureInvalid,
+			Error: "signature is not made by any of the public keys"}, report.Images[3])
+		assert.Equal(SignatureVerification{Image: image("rancher/shell:v0.1.22"), Digest: shellDigest, Status: SignatureVerified,
+			Signer: fingerprint}, report.Images[4])
+	}
+	assert.Equal(2, report.Count(SignatureInvalid))
+
+	_, err = registry.client().VerifySignatures(context.Background(), nil, SignaturePolicy{})
+	assert.Error(err)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	assert := assertlib.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(err)
+	parsed, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(err)
+	assert.True(key.PublicKey.Equal(parsed))
+
+	_, err = ParsePublicKey([]byte("not a key"))
+	assert.Error(err)
+}
+
+// keylessSigner signs payloads like cosign keyless signing does: with an ephemeral key certified by a Fulcio CA, and
+// logged in a Rekor transparency log.
+type keylessSigner struct {
+	t        *testing.T
+	caCert   *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	rekorKey *ecdsa.PrivateKey
+	signedAt time.Time
+}
+
+func newKeylessSigner(t *testing.T) *keylessSigner {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assertlib.NoError(t, err)
+	signedAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fulcio"},
+		NotBefore:             signedAt.Add(-time.Hour),
+		NotAfter:              signedAt.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	assertlib.NoError(t, err)
+	caCert, err := x509.ParseCertificate(der)
+	assertlib.NoError(t, err)
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assertlib.NoError(t, err)
+	return &keylessSigner{t: t, caCert: caCert, caKey: caKey, rekorKey: rekorKey, signedAt: signedAt}
+}
+
+// identity returns the keyless identity of the signatures of the signer for subject.
+func (s *keylessSigner) identity(subject string) *KeylessIdentity {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.caCert)
+	return &KeylessIdentity{
+		Roots:          roots,
+		Issuer:         "https://token.actions.githubusercontent.com",
+		Subject:        regexp.MustCompile("^" + regexp.QuoteMeta(subject) + "$"),
+		RekorPublicKey: &s.rekorKey.PublicKey,
+	}
+}
+
+// sign returns the keyless signature of payload by the workflow at subject.
+func (s *keylessSigner) sign(payload []byte, subject string) cosignSignature {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assertlib.NoError(s.t, err)
+	subjectURI, err := url.Parse(subject)
+	assertlib.NoError(s.t, err)
+	issuer, err := asn1.Marshal("https://token.actions.githubusercontent.com")
+	assertlib.NoError(s.t, err)
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       s.signedAt.Add(-time.Minute),
+		NotAfter:        s.signedAt.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{subjectURI},
+		ExtraExtensions: []pkix.Extension{{Id: fulcioIssuerV2OID, Value: issuer}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, &key.PublicKey, s.caKey)
+	assertlib.NoError(s.t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	sig := signPayload(s.t, key, payload)
+
+	sum := sha256.Sum256(payload)
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data":      map[string]interface{}{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(sum[:])}},
+			"signature": map[string]interface{}{"content": sig, "publicKey": map[string]interface{}{"content": certPEM}},
+		},
+	})
+	assertlib.NoError(s.t, err)
+	bundlePayload := rekorBundlePayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: s.signedAt.Unix(),
+		LogID:          strings.Repeat("ab", 32),
+		LogIndex:       42,
+	}
+	signed, err := json.Marshal(bundlePayload)
+	assertlib.NoError(s.t, err)
+	bundle, err := json.Marshal(rekorBundle{SignedEntryTimestamp: signPayload(s.t, s.rekorKey, signed), Payload: bundlePayload})
+	assertlib.NoError(s.t, err)
+	return cosignSignature{payload: payload, annotations: map[string]string{
+		cosignSignatureAnnotation:   base64.StdEncoding.EncodeToString(sig),
+		cosignCertificateAnnotation: string(certPEM),
+		cosignBundleAnnotation:      string(bundle),
+	}}
+}
+
+func TestSignaturePolicyVerifyKeyless(t *testing.T) {
+	assert := assertlib.New(t)
+
+	const workflow = "https://github.com/rancher/rancher/.github/workflows/release.yml@refs/tags/v2.8.0"
+	const imageDigest = "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	signer := newKeylessSigner(t)
+	policy := SignaturePolicy{Keyless: signer.identity(workflow)}
+	signature := signer.sign(signaturePayload("rancher/shell", imageDigest), workflow)
+
+	subject, err := policy.verify(signature, imageDigest)
+	assert.NoError(err)
+	assert.Equal(workflow, subject)
+
+	// Signatures by other identities, issuers or CAs are invalid
+	_, err = SignaturePolicy{Keyless: signer.identity("https://github.com/example/fork/.github/workflows/release.yml@refs/heads/main")}.verify(signature, imageDigest)
+	assert.ErrorContains(err, "signature certificate is issued for "+workflow)
+	otherIssuer := signer.identity(workflow)
+	otherIssuer.Issuer = "https://accounts.google.com"
+	_, err = SignaturePolicy{Keyless: otherIssuer}.verify(signature, imageDigest)
+	assert.ErrorContains(err, "signature certificate is issued by")
+	_, err = SignaturePolicy{Keyless: newKeylessSigner(t).identity(workflow)}.verify(signature, imageDigest)
+	assert.ErrorContains(err, "failed to verify signed entry timestamp")
+
+	// Signatures must be logged in the transparency log as they are
+	tampered := signer.sign(signaturePayload("rancher/shell", imageDigest), workflow)
+	tampered.annotations[cosignBundleAnnotation] = signature.annotations[cosignBundleAnnotation]
+	_, err = policy.verify(tampered, imageDigest)
+	assert.ErrorContains(err, "transparency log entry is not for the signature")
+	delete(tampered.annotations, cosignBundleAnnotation)
+	_, err = policy.verify(tampered, imageDigest)
+	assert.ErrorContains(err, "no transparency log bundle")
+}
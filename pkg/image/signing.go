@@ -0,0 +1,140 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	img "github.com/rancher/rke/types/image"
+)
+
+// SigningManifest lists the exact references the release pipeline signs for an image list. It is generated from the
+// image list itself, so that the signing job signs every image the list references and nothing else.
+type SigningManifest struct {
+	// RancherVersions are the Rancher versions of the image list, if known.
+	RancherVersions []string `json:"rancherVersions,omitempty"`
+	// Images are the images to sign, sorted by image.
+	Images []SigningImage `json:"images"`
+}
+
+// SigningImage is an image published by Rancher along with the references of it to sign.
+type SigningImage struct {
+	// Image is the image as exported, i.e. its mirrored name for the mirrors of upstream images.
+	Image string `json:"image"`
+	// Upstream is the upstream image mirrored as Image, if Image is a mirror.
+	Upstream string `json:"upstream,omitempty"`
+	// OS are the OS types the image is exported for.
+	OS []OSType `json:"os"`
+	// Digest is the digest of the manifest of the image, i.e. of the manifest list for multi-arch images.
+	Digest string `json:"digest"`
+	// Platforms are the digests of the platform-specific manifests of the image, if they were looked up.
+	Platforms []PlatformDigest `json:"platforms,omitempty"`
+	// References are the digest references to sign: the manifest and then its platform-specific manifests, in every
+	// registry the image is published to.
+	References []string `json:"references"`
+}
+
+// NewSigningManifest returns the signing manifest of the images of list published by Rancher, see IsRancherImage:
+// third-party images are signed by their own publishers. The references to sign are in each of registries, e.g.
+// docker.io and registry.rancher.com, or in the registry of each image if none are given. The digests of the images
+// must have been looked up, see RegistryClient.LookupImages, and the platform-specific digests are included if they
+// were, see RegistryClient.LookupPlatformDigests. An image without a digest is an error rather than being left out,
+// since the manifest would then miss images of the list.
+func NewSigningManifest(list ImageList, registries []string) (SigningManifest, error) {
+	images := make(map[string]*SigningImage)
+	var missing []string
+	for _, entry := range list {
+		if !IsRancherImage(entry.Image) {
+			continue
+		}
+		if entry.Digest == "" {
+			missing = append(missing, entry.Image)
+			continue
+		}
+		image, ok := images[entry.Image]
+		if !ok {
+			image = &SigningImage{Image: entry.Image, Digest: entry.Digest}
+			tagged, _, _ := strings.Cut(entry.Image, "@")
+			if upstream, ok := img.Mirrors[tagged]; ok && upstream != tagged {
+				image.Upstream = upstream
+			}
+			images[entry.Image] = image
+		}
+		if image.Digest != entry.Digest {
+			return SigningManifest{}, errors.Errorf("image %s has different digests %s and %s", entry.Image, image.Digest, entry.Digest)
+		}
+		image.OS = append(image.OS, entry.OS)
+		for _, platformDigest := range entry.PlatformDigests {
+			if !containsPlatformDigest(image.Platforms, platformDigest) {
+				image.Platforms = append(image.Platforms, platformDigest)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return SigningManifest{}, errors.Errorf("%d images have no digest, they must be looked up in their registries: %s",
+			len(missing), strings.Join(missing, ", "))
+	}
+
+	var manifest SigningManifest
+	for _, image := range images {
+		repositories, err := signingRepositories(image.Image, registries)
+		if err != nil {
+			return SigningManifest{}, err
+		}
+		for _, repository := range repositories {
+			image.References = append(image.References, repository+"@"+image.Digest)
+			for _, platformDigest := range image.Platforms {
+				image.References = append(image.References, repository+"@"+platformDigest.Digest)
+			}
+		}
+		manifest.Images = append(manifest.Images, *image)
+	}
+	sort.Slice(manifest.Images, func(i, j int) bool {
+		return manifest.Images[i].Image < manifest.Images[j].Image
+	})
+	return manifest, nil
+}
+
+// signingRepositories returns the repositories of image in each of registries, or its own repository if none are
+// given.
+func signingRepositories(image string, registries []string) ([]string, error) {
+	if len(registries) == 0 {
+		return []string{imageRepository(image)}, nil
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse image %s", image)
+	}
+	repositories := make([]string, 0, len(registries))
+	for _, registry := range registries {
+		repositories = append(repositories, strings.TrimSuffix(registry, "/")+"/"+reference.Path(named))
+	}
+	return repositories, nil
+}
+
+// containsPlatformDigest returns whether digests contains digest.
+func containsPlatformDigest(digests []PlatformDigest, digest PlatformDigest) bool {
+	for _, d := range digests {
+		if d == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSigningManifest writes the signing manifest of the images of list to w as indented JSON, see
+// NewSigningManifest.
+func WriteSigningManifest(w io.Writer, list ImageList, rancherVersions, registries []string) error {
+	manifest, err := NewSigningManifest(list, registries)
+	if err != nil {
+		return err
+	}
+	manifest.RancherVersions = rancherVersions
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
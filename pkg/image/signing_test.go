@@ -0,0 +1,72 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestNewSigningManifest(t *testing.T) {
+	assert := assertlib.New(t)
+
+	const (
+		shellDigest     = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		shellAMD64      = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		shellWindows    = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+		certManagerHash = "sha256:4444444444444444444444444444444444444444444444444444444444444444"
+	)
+	certManager := mirrorImage("quay.io/jetstack/cert-manager-controller:v1.11.0", RegistryMapping{"quay.io/jetstack/": "rancher/mirrored-jetstack-"})
+	list := ImageList{
+		{Image: certManager, OS: Linux, Digest: certManagerHash},
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Digest: shellDigest,
+			PlatformDigests: []PlatformDigest{{Platform: "linux/amd64", Digest: shellAMD64}}},
+		{Image: "busybox:1.36", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Windows, Digest: shellDigest,
+			PlatformDigests: []PlatformDigest{{Platform: "windows/amd64", Digest: shellWindows}}},
+	}
+
+	manifest, err := NewSigningManifest(list, nil)
+	assert.NoError(err)
+	assert.Equal(SigningManifest{Images: []SigningImage{
+		{
+			Image:      "rancher/mirrored-jetstack-cert-manager-controller:v1.11.0",
+			Upstream:   "quay.io/jetstack/cert-manager-controller:v1.11.0",
+			OS:         []OSType{Linux},
+			Digest:     certManagerHash,
+			References: []string{"rancher/mirrored-jetstack-cert-manager-controller@" + certManagerHash},
+		},
+		{
+			Image:  "rancher/shell:v0.1.22",
+			OS:     []OSType{Linux, Windows},
+			Digest: shellDigest,
+			Platforms: []PlatformDigest{
+				{Platform: "linux/amd64", Digest: shellAMD64},
+				{Platform: "windows/amd64", Digest: shellWindows},
+			},
+			References: []string{"rancher/shell@" + shellDigest, "rancher/shell@" + shellAMD64, "rancher/shell@" + shellWindows},
+		},
+	}}, manifest)
+
+	// The references are signed in every registry the images are published to
+	manifest, err = NewSigningManifest(list[:1], []string{"docker.io", "registry.rancher.com/"})
+	assert.NoError(err)
+	if assert.Len(manifest.Images, 1) {
+		assert.Equal([]string{
+			"docker.io/rancher/mirrored-jetstack-cert-manager-controller@" + certManagerHash,
+			"registry.rancher.com/rancher/mirrored-jetstack-cert-manager-controller@" + certManagerHash,
+		}, manifest.Images[0].References)
+	}
+
+	// Images that were not looked up would be left unsigned
+	_, err = NewSigningManifest(ImageList{{Image: "rancher/fleet:v0.9.0", OS: Linux}}, nil)
+	assert.EqualError(err, "1 images have no digest, they must be looked up in their registries: rancher/fleet:v0.9.0")
+
+	var buf bytes.Buffer
+	assert.NoError(WriteSigningManifest(&buf, list, []string{"v2.8.0"}, nil))
+	var written SigningManifest
+	assert.NoError(json.Unmarshal(buf.Bytes(), &written))
+	assert.Equal([]string{"v2.8.0"}, written.RancherVersions)
+	assert.Len(written.Images, 2)
+}
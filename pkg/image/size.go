@@ -0,0 +1,64 @@
+package image
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// SizeEstimate is the size of the download of the images of an OS and architecture, to plan the storage and bandwidth
+// of a mirror.
+type SizeEstimate struct {
+	// OS and Architecture are the platform of the images.
+	OS           OSType
+	Architecture string
+	// Images is the number of images whose size is included.
+	Images int
+	// CompressedSize is the total size of the compressed layers of the images, in bytes. Layers shared by several
+	// images are only counted once.
+	CompressedSize int64
+	// Failed are the images that could not be looked up, whose size is not included.
+	Failed []string
+}
+
+// EstimateSize returns the download size of the images of list for each of their OS types, for the architecture of
+// the client, reading the manifests of the images from their registries.
+func (c RegistryClient) EstimateSize(ctx context.Context, list ImageList) []SizeEstimate {
+	var mu sync.Mutex
+	estimates := make(map[OSType]*SizeEstimate)
+	layers := make(map[OSType]map[string]int64)
+	for _, entry := range list {
+		if estimates[entry.OS] == nil {
+			estimates[entry.OS] = &SizeEstimate{OS: entry.OS, Architecture: c.architecture()}
+			layers[entry.OS] = make(map[string]int64)
+		}
+	}
+
+	c.forEach(list, func(entry *ImageEntry) {
+		details, err := c.Inspect(ctx, entry.Image, entry.OS)
+		mu.Lock()
+		defer mu.Unlock()
+		estimate := estimates[entry.OS]
+		if err != nil {
+			estimate.Failed = append(estimate.Failed, entry.Image)
+			return
+		}
+		estimate.Images++
+		for layerDigest, size := range details.Layers {
+			layers[entry.OS][layerDigest] = size
+		}
+	})
+
+	result := make([]SizeEstimate, 0, len(estimates))
+	for osType, estimate := range estimates {
+		for _, size := range layers[osType] {
+			estimate.CompressedSize += size
+		}
+		sort.Strings(estimate.Failed)
+		result = append(result, *estimate)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].OS.less(result[j].OS)
+	})
+	return result
+}
@@ -0,0 +1,74 @@
+package image
+
+import (
+	"io"
+	"sort"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// SkopeoSyncRegistry is the configuration of a source registry in a skopeo sync YAML file.
+type SkopeoSyncRegistry struct {
+	// Images are the tags and digests to sync, keyed by repository.
+	Images map[string][]string `yaml:"images"`
+}
+
+// SkopeoSyncConfig returns the skopeo sync configuration of the images of list, keyed by source registry, so the
+// images can be mirrored with:
+//
+//	skopeo sync --all --src yaml --dest docker rancher-images-skopeo.yaml registry.example.com
+func SkopeoSyncConfig(list ImageList) (map[string]SkopeoSyncRegistry, error) {
+	config := make(map[string]SkopeoSyncRegistry)
+	seen := make(map[string]bool)
+	for _, entry := range list {
+		if seen[entry.Image] {
+			continue
+		}
+		seen[entry.Image] = true
+
+		named, err := reference.ParseNormalizedNamed(entry.Image)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse image %s", entry.Image)
+		}
+		var tagOrDigest string
+		switch ref := named.(type) {
+		case reference.Digested:
+			tagOrDigest = ref.Digest().String()
+		case reference.Tagged:
+			tagOrDigest = ref.Tag()
+		default:
+			tagOrDigest = "latest"
+		}
+
+		domain := reference.Domain(named)
+		registry, ok := config[domain]
+		if !ok {
+			registry = SkopeoSyncRegistry{Images: make(map[string][]string)}
+			config[domain] = registry
+		}
+		path := reference.Path(named)
+		registry.Images[path] = append(registry.Images[path], tagOrDigest)
+	}
+	for _, registry := range config {
+		for _, tags := range registry.Images {
+			sort.Strings(tags)
+		}
+	}
+	return config, nil
+}
+
+// WriteSkopeoSyncYAML writes the skopeo sync configuration of the images of list to w, see SkopeoSyncConfig.
+func WriteSkopeoSyncYAML(w io.Writer, list ImageList) error {
+	config, err := SkopeoSyncConfig(list)
+	if err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal skopeo sync configuration")
+	}
+	_, err = w.Write(out)
+	return err
+}
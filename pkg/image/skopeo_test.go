@@ -0,0 +1,37 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestWriteSkopeoSyncYAML(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/shell:v0.1.21", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Windows},
+		{Image: "busybox", OS: Linux},
+		{Image: "quay.io/skopeo/stable@sha256:0000000000000000000000000000000000000000000000000000000000000000", OS: Linux},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(WriteSkopeoSyncYAML(&buf, list))
+	assert.Equal(`docker.io:
+  images:
+    library/busybox:
+    - latest
+    rancher/shell:
+    - v0.1.21
+    - v0.1.22
+quay.io:
+  images:
+    skopeo/stable:
+    - sha256:0000000000000000000000000000000000000000000000000000000000000000
+`, buf.String())
+
+	assert.Error(WriteSkopeoSyncYAML(&bytes.Buffer{}, ImageList{{Image: "Invalid Image"}}))
+}
@@ -0,0 +1,176 @@
+package image
+
+import (
+	"context"
+	"sync"
+
+	rketypes "github.com/rancher/rke/types"
+)
+
+// ImageSource provides images to an export. Sources add what they find to the given ImageSet, which takes care of
+// only keeping the images of the OS types being exported.
+type ImageSource interface {
+	// Name identifies the source in errors and logs.
+	Name() string
+	// FetchImages adds the images provided by the source to into.
+	FetchImages(ctx context.Context, into *ImageSet) error
+}
+
+// ImageSourceFactory returns the ImageSource to use for an export, or nil if the source does not apply to it.
+type ImageSourceFactory func(config ExportConfig, inputs map[OSType]OSImageInputs) ImageSource
+
+var (
+	imageSourceFactoriesLock sync.Mutex
+	imageSourceFactories     []ImageSourceFactory
+)
+
+// RegisterImageSource registers a factory for an ImageSource that is used by every subsequent export. This allows
+// new kinds of images to be included without changes to GetImages.
+func RegisterImageSource(factory ImageSourceFactory) {
+	imageSourceFactoriesLock.Lock()
+	defer imageSourceFactoriesLock.Unlock()
+	imageSourceFactories = append(imageSourceFactories, factory)
+}
+
+// imageSources returns the sources registered for an export with the given configuration and inputs.
+func imageSources(config ExportConfig, inputs map[OSType]OSImageInputs) []ImageSource {
+	imageSourceFactoriesLock.Lock()
+	defer imageSourceFactoriesLock.Unlock()
+	var sources []ImageSource
+	for _, factory := range imageSourceFactories {
+		if source := factory(config, inputs); source != nil {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+func init() {
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return Charts{config}
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return HostedProviderCharts{config}
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return Provisioning{config}
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return Components{config}
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		if config.CoreOnly || config.featureDisabled(legacyFeature) {
+			return nil
+		}
+		return SystemCharts{config}
+	})
+	RegisterImageSource(func(config ExportConfig, inputs map[OSType]OSImageInputs) ImageSource {
+		if !config.distributionExported(DistributionRKE) {
+			return nil
+		}
+		system := System{
+			RKESystemImages: make(map[OSType]map[string]rketypes.RKESystemImages, len(inputs)),
+			AddonTemplates:  config.RKEAddonTemplates,
+		}
+		for osType, osInputs := range inputs {
+			system.RKESystemImages[osType] = osInputs.RKESystemImages
+		}
+		return system
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		if config.CoreOnly {
+			return nil
+		}
+		return ExtensionsConfig{GithubEndpoints: ExtensionEndpoints}
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return Requirements{RancherVersion: config.RancherVersion, Images: config.RequirementImages}
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		if config.K3sReleases == nil || !config.distributionExported(DistributionK3s) {
+			return nil
+		}
+		return K3sImages{
+			RancherVersion: config.RancherVersion,
+			Releases:       config.K3sReleases,
+			Arches:         config.Arches,
+		}
+	})
+	RegisterImageSource(func(config ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		if config.RKE2Releases == nil || !config.distributionExported(DistributionRKE2) {
+			return nil
+		}
+		return RKE2Images{
+			RancherVersion: config.RancherVersion,
+			Releases:       config.RKE2Releases,
+			Components:     config.RKE2Components,
+			Arches:         config.Arches,
+		}
+	})
+	RegisterImageSource(func(_ ExportConfig, inputs map[OSType]OSImageInputs) ImageSource {
+		external := External{Images: make(map[OSType]map[string][]string, len(inputs))}
+		for osType, osInputs := range inputs {
+			images := make(map[string][]string, len(osInputs.ExternalImages)+1)
+			for source, sourceImages := range osInputs.ExternalImages {
+				images[source] = sourceImages
+			}
+			// Copy the rancher images of the inputs, appending to them could modify the caller's slice
+			images["rancher"] = append(append([]string(nil), images["rancher"]...), osInputs.ImagesFromArgs...)
+			external.Images[osType] = images
+		}
+		return external
+	})
+}
+
+// Requirements provides the core images that Rancher needs to run on each OS type, regardless of charts and
+// Kubernetes versions: the default requirement images of the OS type, see DefaultRequirementImages, along with Images.
+type Requirements struct {
+	// RancherVersion is the Rancher version the images of the image settings are resolved for, see SettingImages.
+	RancherVersion string
+	// Images are additional requirement images, keyed by OS type.
+	Images map[OSType][]RequirementImage
+}
+
+func (r Requirements) Name() string {
+	return "requirements"
+}
+
+func (r Requirements) FetchImages(_ context.Context, imagesSet *ImageSet) error {
+	for _, osType := range imagesSet.OSTypes() {
+		setRequirementImages(osType, append(DefaultRequirementImages(r.RancherVersion, osType), r.Images[osType]...), imagesSet)
+	}
+	return nil
+}
+
+func setRequirementImages(osType OSType, images []RequirementImage, imagesSet *ImageSet) {
+	coreLabel := "core"
+	for _, image := range images {
+		if image.Image != "" {
+			imagesSet.AddForArches(osType, image.Image, coreLabel, image.Arches...)
+		}
+	}
+}
+
+// External provides images handed to an export directly, such as the Rancher images passed as arguments. Images are grouped by OS and then by the source label they are added with.
+type External struct {
+	Images map[OSType]map[string][]string
+}
+
+func (e External) Name() string {
+	return "external"
+}
+
+func (e External) FetchImages(_ context.Context, imagesSet *ImageSet) error {
+	for osType, sources := range e.Images {
+		for source, sourceImages := range sources {
+			setImages(osType, source, sourceImages, imagesSet)
+		}
+	}
+	return nil
+}
+
+func setImages(osType OSType, source string, imagesFromArgs []string, imagesSet *ImageSet) {
+	for _, image := range imagesFromArgs {
+		imagesSet.Add(osType, image, source)
+	}
+}
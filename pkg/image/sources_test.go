@@ -0,0 +1,141 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rancher/rancher/pkg/settings"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+type testSource struct {
+	images map[OSType][]string
+}
+
+func (t testSource) Name() string {
+	return "test"
+}
+
+func (t testSource) FetchImages(_ context.Context, imagesSet *ImageSet) error {
+	for osType, images := range t.images {
+		setImages(osType, "test", images, imagesSet)
+	}
+	return nil
+}
+
+func TestRegisterImageSource(t *testing.T) {
+	assert := assertlib.New(t)
+
+	originalFactories := imageSourceFactories
+	originalEndpoints := ExtensionEndpoints
+	defer func() {
+		imageSourceFactories = originalFactories
+		ExtensionEndpoints = originalEndpoints
+	}()
+	ExtensionEndpoints = nil
+
+	RegisterImageSource(func(_ ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return nil
+	})
+	RegisterImageSource(func(_ ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return testSource{images: map[OSType][]string{
+			Linux:   {"rancher/test-linux:v1", "rancher/rancher:v2.7.0"},
+			Windows: {"rancher/test-windows:v1"},
+		}}
+	})
+
+	result, err := GetImagesForOSTypes(context.Background(), ExportConfig{}, map[OSType]OSImageInputs{
+		Linux: {ImagesFromArgs: []string{"rancher/rancher:v2.7.0"}},
+	})
+	assert.NoError(err)
+	images := result.Images
+	assert.Empty(images.ForOS(Windows))
+
+	var found bool
+	for _, entry := range images {
+		if entry.Image == "rancher/rancher:v2.7.0" {
+			found = true
+			assert.Equal([]string{"rancher", "test"}, entry.Sources)
+		}
+	}
+	assert.True(found, "expected image from registered source")
+	assert.Contains(images.Images(), "rancher/test-linux:v1")
+}
+
+func TestImageSourcesCoreOnly(t *testing.T) {
+	sourceNames := func(config ExportConfig) []string {
+		var names []string
+		for _, source := range imageSources(config, map[OSType]OSImageInputs{Linux: {}}) {
+			names = append(names, source.Name())
+		}
+		return names
+	}
+
+	assertlib.Subset(t, sourceNames(ExportConfig{}), []string{"charts", "system charts", "extensions"})
+	coreOnlySources := sourceNames(ExportConfig{CoreOnly: true})
+	assertlib.Contains(t, coreOnlySources, "charts")
+	assertlib.NotContains(t, coreOnlySources, "system charts")
+	assertlib.NotContains(t, coreOnlySources, "extensions")
+}
+
+func TestGetImagesForOSTypesLimitedPlatforms(t *testing.T) {
+	assert := assertlib.New(t)
+
+	originalFactories := imageSourceFactories
+	originalEndpoints := ExtensionEndpoints
+	defer func() {
+		imageSourceFactories = originalFactories
+		ExtensionEndpoints = originalEndpoints
+	}()
+	ExtensionEndpoints = nil
+	RegisterImageSource(func(_ ExportConfig, _ map[OSType]OSImageInputs) ImageSource {
+		return testSource{images: map[OSType][]string{
+			Linux:   {"rancher/test-linux:v1"},
+			Windows: {"rancher/test-windows:v1"},
+		}}
+	})
+	inputs := map[OSType]OSImageInputs{Linux: {}, Windows: {}}
+
+	result, err := GetImagesForOSTypes(context.Background(), ExportConfig{OSTypes: []OSType{Windows}}, inputs)
+	assert.NoError(err)
+	assert.Empty(result.Images.ForOS(Linux))
+	assert.Contains(result.Images.ForOS(Windows).Images(), "rancher/test-windows:v1")
+
+	// Windows images are only published for amd64
+	result, err = GetImagesForOSTypes(context.Background(), ExportConfig{Arches: []Arch{ARM64}}, inputs)
+	assert.NoError(err)
+	assert.Contains(result.Images.ForOS(Linux).Images(), "rancher/test-linux:v1")
+	assert.Empty(result.Images.ForOS(Windows))
+}
+
+func TestImageSourcesExternalImagesNotModified(t *testing.T) {
+	rancherImages := make([]string, 1, 2)
+	rancherImages[0] = "rancher/rancher:v2.7.0"
+	inputs := map[OSType]OSImageInputs{Linux: {
+		ImagesFromArgs: []string{"rancher/rancher-agent:v2.7.0"},
+		ExternalImages: map[string][]string{"rancher": rancherImages},
+	}}
+
+	imageSources(ExportConfig{}, inputs)
+	assertlib.Equal(t, []string{"rancher/rancher:v2.7.0", ""}, rancherImages[:2])
+}
+
+func TestRequirementsFetchImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Linux, Windows)
+	requirements := Requirements{Images: map[OSType][]RequirementImage{
+		Linux:   {{Image: "rancher/shell:v0.1.22-arm64", Arches: []Arch{ARM64}}},
+		Windows: {{Image: "rancher/wins:v0.4.12"}},
+	}}
+	assert.NoError(requirements.FetchImages(context.Background(), imagesSet))
+
+	assert.Contains(imagesSet.Images(Linux), "rancher/mirrored-bci-busybox:15.4.11.2")
+	assert.Equal([]Arch{ARM64}, imagesSet.Arches(Linux, "rancher/shell:v0.1.22-arm64"))
+	assert.NotContains(imagesSet.ImagesForArch(Linux, AMD64), "rancher/shell:v0.1.22-arm64")
+	assert.Equal([]string{"core"}, imagesSet.Sources(Linux, "rancher/shell:v0.1.22-arm64"))
+	// Windows lists get their own requirement images rather than none
+	assert.Contains(imagesSet.Images(Windows), "rancher/wins:v0.4.12")
+	assert.Contains(imagesSet.Images(Windows), settings.AgentImage.Get())
+	assert.NotContains(imagesSet.Images(Windows), "rancher/mirrored-bci-busybox:15.4.11.2")
+}
@@ -0,0 +1,114 @@
+package image
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StaleImage is an image held by a private registry that the image lists no longer reference, e.g. an image of an
+// older Rancher version.
+type StaleImage struct {
+	// Image is the image without the registry, e.g. rancher/shell:v0.1.20.
+	Image string `json:"image"`
+	// Repository is the repository of the image in the registry, e.g. rancher/shell.
+	Repository string `json:"repository"`
+	// Digest is the digest of the manifest of the image in the registry.
+	Digest string `json:"digest"`
+}
+
+// DeletionManifest lists the manifests to delete from a private registry to reclaim the space of its stale images.
+// Registries delete manifests by digest, with a DELETE /v2/REPOSITORY/manifests/DIGEST request, which deletes every tag
+// of the manifest.
+type DeletionManifest struct {
+	// Registry is the registry holding the images.
+	Registry string `json:"registry"`
+	// Images are the stale images to delete.
+	Images []StaleImage `json:"images"`
+	// Kept are the stale images whose manifest is also the manifest of a referenced image, which would be deleted along
+	// with them, or may be since the digest of an image of their repository could not be looked up.
+	Kept []StaleImage `json:"kept,omitempty"`
+}
+
+// StaleImages returns the images of inventory, the images held by registry as listed by Inventory, that list no longer
+// references, sorted. The images of list are compared as they are pushed to the registry, see TargetImage: pinned
+// images by tag and images without repository in the rancher repository. Images are compared regardless of their OS
+// since a registry holds the images of every OS. The registry is stripped from the inventory images it prefixes.
+func StaleImages(list ImageList, inventory []string, registry string) []string {
+	prefix := strings.TrimSuffix(registry, "/") + "/"
+	referenced := make(map[string]bool, len(list))
+	for _, entry := range list {
+		referenced[strings.TrimPrefix(TargetImage(registry, entry.Image), prefix)] = true
+	}
+
+	var stale []string
+	for _, image := range inventory {
+		image = strings.TrimPrefix(image, prefix)
+		if !referenced[image] {
+			stale = append(stale, image)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// DeletionManifest returns the manifest deleting the stale images of registry, i.e. the images of its inventory that
+// list no longer references, see StaleImages. The digests of the images of the inventory are looked up in the
+// registry, and the stale images sharing their manifest with a referenced image are kept, so the deletion does not
+// remove a referenced tag. The images whose digest cannot be looked up are returned in ImageErrors, and the stale
+// images of their repository are kept since they may share its manifest.
+func (c RegistryClient) DeletionManifest(ctx context.Context, registry string, list ImageList, inventory []string) (DeletionManifest, error) {
+	prefix := strings.TrimSuffix(registry, "/") + "/"
+	stale := make(map[string]bool)
+	for _, image := range StaleImages(list, inventory, registry) {
+		stale[image] = true
+	}
+	held := make(ImageList, 0, len(inventory))
+	for _, image := range inventory {
+		held = append(held, ImageEntry{Image: strings.TrimPrefix(image, prefix), OS: Linux})
+	}
+
+	var mu sync.Mutex
+	var staleImages []StaleImage
+	referencedDigests := make(map[string]bool)
+	unknownRepositories := make(map[string]bool)
+	var errs ImageErrors
+	c.forEach(held, func(entry *ImageEntry) {
+		manifestDigest, err := c.Digest(ctx, prefix+entry.Image, entry.OS)
+		repository, _ := splitImageTag(entry.Image)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			errs = append(errs, &ImageError{Image: entry.Image, OS: entry.OS, Err: err})
+			unknownRepositories[repository] = true
+		case stale[entry.Image]:
+			staleImages = append(staleImages, StaleImage{Image: entry.Image, Repository: repository, Digest: manifestDigest})
+		default:
+			// Manifests are deleted per repository, a manifest shared with another repository is not deleted there
+			referencedDigests[repository+"@"+manifestDigest] = true
+		}
+	})
+
+	manifest := DeletionManifest{Registry: registry}
+	for _, image := range staleImages {
+		if referencedDigests[image.Repository+"@"+image.Digest] || unknownRepositories[image.Repository] {
+			manifest.Kept = append(manifest.Kept, image)
+		} else {
+			manifest.Images = append(manifest.Images, image)
+		}
+	}
+	for _, images := range [][]StaleImage{manifest.Images, manifest.Kept} {
+		sort.Slice(images, func(i, j int) bool {
+			return images[i].Image < images[j].Image
+		})
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Image < errs[j].Image
+		})
+		return manifest, errs
+	}
+	return manifest, nil
+}
@@ -0,0 +1,52 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestStaleImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/rancher-agent:v2.8.0@sha256:0000000000000000000000000000000000000000000000000000000000000000", OS: Linux},
+		{Image: "busybox:1.36", OS: Linux},
+		{Image: "rancher/wins:v0.4.11", OS: Windows},
+	}
+	inventory := []string{
+		"registry.example.com/rancher/shell:v0.1.22",
+		"registry.example.com/rancher/shell:v0.1.20",
+		"rancher/rancher-agent:v2.8.0",
+		"rancher/busybox:1.36",
+		"rancher/wins:v0.4.11",
+		"rancher/wins:v0.4.10",
+	}
+	assert.Equal([]string{"rancher/shell:v0.1.20", "rancher/wins:v0.4.10"}, StaleImages(list, inventory, "registry.example.com"))
+}
+
+func TestRegistryClientDeletionManifest(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	current := registry.AddManifest("rancher/shell", "v0.1.22", schema2MediaType, schema2Manifest(100))
+	registry.AddManifest("rancher/shell", "latest", schema2MediaType, schema2Manifest(100))
+	old := registry.AddManifest("rancher/shell", "v0.1.20", schema2MediaType, schema2Manifest(200))
+	inventory := []string{"rancher/shell:latest", "rancher/shell:v0.1.20", "rancher/shell:v0.1.22"}
+
+	manifest, err := registry.client().DeletionManifest(context.Background(), registry.Host(), ImageList{{Image: "rancher/shell:v0.1.22", OS: Linux}}, inventory)
+	assert.Equal(registry.Host(), manifest.Registry)
+	assert.NoError(err)
+	assert.Equal([]StaleImage{{Image: "rancher/shell:v0.1.20", Repository: "rancher/shell", Digest: old}}, manifest.Images)
+	assert.Equal([]StaleImage{{Image: "rancher/shell:latest", Repository: "rancher/shell", Digest: current}}, manifest.Kept)
+
+	manifest, err = registry.client().DeletionManifest(context.Background(), registry.Host(), ImageList{{Image: "rancher/shell:v0.1.22", OS: Linux}}, append(inventory, "rancher/shell:v0.1.19"))
+	assert.Empty(manifest.Images)
+	assert.Len(manifest.Kept, 2)
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal("rancher/shell:v0.1.19", imageErrs[0].Image)
+	}
+}
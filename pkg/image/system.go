@@ -1,31 +1,131 @@
 package image
 
 import (
+	"context"
+
+	mVersion "github.com/mcuadros/go-version"
+	"github.com/pkg/errors"
 	"github.com/rancher/norman/types/convert"
 	v32 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
 	rketypes "github.com/rancher/rke/types"
+	"github.com/rancher/rke/util"
 )
 
+// System provides the RKE system images of each Kubernetes version. The images are labeled with a "system:<version>"
+// source for each Kubernetes version using them, e.g. system:v1.27.10-rancher1-1, so that lists trimmed to some
+// Kubernetes versions can be reviewed, along with the images embedded in the RKE addon templates of each Linux
+// Kubernetes version, see RKEAddonImages. The images of the Rancher tools, e.g. kube-api-auth, are labeled with the
+// "system" source. KDM declares no CNI images for Windows nodes: the flannel binaries of RKE Windows nodes ship in the
+// Kubernetes services sidecar image, which is part of the Windows system images, while the calico and flannel images
+// of RKE2 Windows nodes are provided by RKE2Images.
 type System struct {
-	Config ExportConfig
+	// RKESystemImages are the RKE system images of each Kubernetes version, per OS.
+	RKESystemImages map[OSType]map[string]rketypes.RKESystemImages
+	// AddonTemplates are the RKE addon templates of the KDM data, its K8sVersionedTemplates.
+	AddonTemplates map[string]map[string]string
+}
+
+func (s System) Name() string {
+	return "system"
+}
+
+func (s System) FetchImages(_ context.Context, imagesSet *ImageSet) error {
+	for _, osType := range imagesSet.OSTypes() {
+		rkeSystemImages := s.RKESystemImages[osType]
+		if len(rkeSystemImages) <= 0 {
+			continue
+		}
+		for k8sVersion, systemImages := range rkeSystemImages {
+			images, err := flatImagesFromCollections(systemImages)
+			if err != nil {
+				return err
+			}
+			if osType == Linux {
+				images = append(images, RKEAddonImages(s.AddonTemplates, k8sVersion)...)
+			}
+			for _, image := range images {
+				imagesSet.Add(osType, image, systemSource(k8sVersion))
+			}
+		}
+		if osType == Linux {
+			images, err := flatImagesFromCollections(v32.ToolsSystemImages)
+			if err != nil {
+				return err
+			}
+			for _, image := range images {
+				imagesSet.Add(osType, image, "system")
+			}
+		}
+	}
+	return nil
+}
+
+// systemSource returns the source label of the RKE system images of k8sVersion.
+func systemSource(k8sVersion string) string {
+	return "system:" + k8sVersion
 }
 
-func (s System) FetchImages(rkeSystemImages map[string]rketypes.RKESystemImages, imagesSet map[string]map[string]struct{}) error {
-	if len(rkeSystemImages) <= 0 {
-		return nil
+// InstallableRKESystemImages returns the RKE system images of the Kubernetes versions of rkeSystemImages that
+// rancherVersion can install according to the KDM metadata of versionInfo, keyed by Kubernetes version and by minor
+// version, e.g. v1.27: the versions that are deprecated, or whose minimum or maximum Rancher version excludes
+// rancherVersion, are left out. GetK8sVersionInfo keeps the Kubernetes versions whose own maximum Rancher version
+// excludes rancherVersion, since Rancher still manages the clusters upgraded from previous Rancher versions, so only
+// the exports for new installations should be limited to the installable versions.
+func InstallableRKESystemImages(rancherVersion string, rkeSystemImages map[string]rketypes.RKESystemImages, versionInfo map[string]rketypes.K8sVersionInfo) map[string]rketypes.RKESystemImages {
+	installable := make(map[string]rketypes.RKESystemImages, len(rkeSystemImages))
+	for k8sVersion, images := range rkeSystemImages {
+		if info, ok := versionInfo[k8sVersion]; ok && !rancherVersionInRange(rancherVersion, info) {
+			continue
+		}
+		if info, ok := versionInfo[util.GetTagMajorVersion(k8sVersion)]; ok && !rancherVersionInRange(rancherVersion, info) {
+			continue
+		}
+		installable[k8sVersion] = images
 	}
-	collectionImagesList := []interface{}{rkeSystemImages}
-	if s.Config.OsType == Linux {
-		collectionImagesList = append(collectionImagesList, v32.ToolsSystemImages)
+	return installable
+}
+
+// K8sPatchMode is which patch versions of each Kubernetes minor version the RKE system images are exported for.
+type K8sPatchMode string
+
+const (
+	// K8sPatchesLatest only exports the RKE system images of the latest patch version of each Kubernetes minor version,
+	// e.g. v1.27.11-rancher1-1 but not v1.27.10-rancher1-1, since new clusters are rarely provisioned with older patch
+	// versions and their images account for gigabytes of the mirrored images. It is the default mode.
+	K8sPatchesLatest K8sPatchMode = "latest"
+	// K8sPatchesAll exports the RKE system images of every patch version the KDM data lists for the Rancher version,
+	// e.g. for the air-gapped clusters still running older patch versions.
+	K8sPatchesAll K8sPatchMode = "all"
+)
+
+// K8sPatchModes are the supported Kubernetes patch modes.
+var K8sPatchModes = []K8sPatchMode{K8sPatchesLatest, K8sPatchesAll}
+
+// ParseK8sPatchMode parses a Kubernetes patch mode, an empty value being K8sPatchesLatest.
+func ParseK8sPatchMode(value string) (K8sPatchMode, error) {
+	if value == "" {
+		return K8sPatchesLatest, nil
 	}
-	images, err := flatImagesFromCollections(collectionImagesList...)
-	if err != nil {
-		return err
+	for _, mode := range K8sPatchModes {
+		if string(mode) == value {
+			return mode, nil
+		}
 	}
-	for _, image := range images {
-		addSourceToImage(imagesSet, image, "system")
+	return "", errors.Errorf("invalid Kubernetes patch mode %q, must be one of %v", value, K8sPatchModes)
+}
+
+// rancherVersionInRange returns whether rancherVersion is within the minimum and maximum Rancher versions of info, and
+// is not deprecated by it.
+func rancherVersionInRange(rancherVersion string, info rketypes.K8sVersionInfo) bool {
+	switch {
+	case info.DeprecateRancherVersion != "" && mVersion.Compare(rancherVersion, info.DeprecateRancherVersion, ">="):
+		return false
+	case info.MinRancherVersion != "" && mVersion.Compare(rancherVersion, info.MinRancherVersion, "<"):
+		return false
+	case info.MaxRancherVersion != "" && mVersion.Compare(rancherVersion, info.MaxRancherVersion, ">"):
+		return false
 	}
-	return nil
+	return true
 }
 
 func flatImagesFromCollections(cols ...interface{}) (images []string, err error) {
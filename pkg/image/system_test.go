@@ -1,8 +1,10 @@
 package image
 
 import (
+	"context"
 	"testing"
 
+	v32 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
 	rketypes "github.com/rancher/rke/types"
 	assertlib "github.com/stretchr/testify/assert"
 )
@@ -63,35 +65,66 @@ func TestFetchImagesFromSystem(t *testing.T) {
 	assert := assertlib.New(t)
 
 	for _, cs := range testCases {
-		imagesSet := make(map[string]map[string]struct{})
-		exportConfig := ExportConfig{
-			OsType: cs.inputOsType,
+		imagesSet := NewImageSet(cs.inputOsType)
+		systemExport := System{
+			RKESystemImages: map[OSType]map[string]rketypes.RKESystemImages{
+				cs.inputOsType: cs.inputRkeSystemImages,
+			},
 		}
-		systemExport := System{exportConfig}
-		err := systemExport.FetchImages(cs.inputRkeSystemImages, imagesSet)
-		images, imageSources := getImagesAndSourcesLists(imagesSet)
+		err := systemExport.FetchImages(context.Background(), imagesSet)
+		images, imageSources := getImagesAndSourcesLists(imagesSet, cs.inputOsType)
 		assert.Nilf(err, "%s, failed to fetch images from system images", cs.caseName)
 		assert.Subset(images, cs.outputShouldContainImages, cs.caseName)
 		for _, nc := range cs.outputShouldNotContain {
 			assert.NotContains(images, nc, cs.caseName)
 		}
 		for _, source := range imageSources {
-			assert.Equal("system", source)
+			assert.Contains([]string{"system", "system:" + k8sVersion}, source)
 		}
 	}
 }
 
-func getImagesAndSourcesLists(imagesSet map[string]map[string]struct{}) ([]string, []string) {
+func TestFetchImagesFromSystemSources(t *testing.T) {
+	assert := assertlib.New(t)
+
+	imagesSet := NewImageSet(Linux)
+	systemExport := System{
+		RKESystemImages: map[OSType]map[string]rketypes.RKESystemImages{
+			Linux: {
+				"v1.26.14-rancher1-1": {CoreDNS: "rancher/mirrored-coredns-coredns:1.10.1", NginxProxy: "rancher/rke-tools:v0.1.96"},
+				"v1.27.11-rancher1-1": {CoreDNS: "rancher/mirrored-coredns-coredns:1.10.1", NginxProxy: "rancher/rke-tools:v0.1.97"},
+			},
+		},
+	}
+	assert.NoError(systemExport.FetchImages(context.Background(), imagesSet))
+
+	assert.Equal([]string{"system:v1.26.14-rancher1-1", "system:v1.27.11-rancher1-1"}, imagesSet.Sources(Linux, "rancher/mirrored-coredns-coredns:1.10.1"))
+	assert.Equal([]string{"system:v1.27.11-rancher1-1"}, imagesSet.Sources(Linux, "rancher/rke-tools:v0.1.97"))
+	assert.Equal([]string{"system"}, imagesSet.Sources(Linux, v32.ToolsSystemImages.AuthSystemImages.KubeAPIAuth))
+}
+
+func getImagesAndSourcesLists(imagesSet *ImageSet, osType OSType) ([]string, []string) {
 	var images, imageSources []string
-	for image, sources := range imagesSet {
+	for _, image := range imagesSet.Images(osType) {
 		images = append(images, image)
-		for source := range sources {
-			imageSources = append(imageSources, source)
-		}
+		imageSources = append(imageSources, imagesSet.Sources(osType, image)...)
 	}
 	return images, imageSources
 }
 
+// imageSetToMap converts the osType images of imagesSet into a map of images to their sources so it can be
+// compared against literals.
+func imageSetToMap(imagesSet *ImageSet, osType OSType) map[string]map[string]struct{} {
+	images := make(map[string]map[string]struct{}, imagesSet.Len(osType))
+	for _, image := range imagesSet.Images(osType) {
+		images[image] = make(map[string]struct{})
+		for _, source := range imagesSet.Sources(osType, image) {
+			images[image][source] = struct{}{}
+		}
+	}
+	return images
+}
+
 func flatStringSlice(slices ...[]string) []string {
 	var ret []string
 	for _, s := range slices {
@@ -99,3 +132,44 @@ func flatStringSlice(slices ...[]string) []string {
 	}
 	return ret
 }
+
+func TestInstallableRKESystemImages(t *testing.T) {
+	assert := assertlib.New(t)
+
+	rkeSystemImages := map[string]rketypes.RKESystemImages{
+		"v1.24.17-rancher1-1": {Kubernetes: "rancher/hyperkube:v1.24.17-rancher1"},
+		"v1.25.16-rancher2-1": {Kubernetes: "rancher/hyperkube:v1.25.16-rancher2"},
+		"v1.26.11-rancher2-1": {Kubernetes: "rancher/hyperkube:v1.26.11-rancher2"},
+		"v1.27.8-rancher2-2":  {Kubernetes: "rancher/hyperkube:v1.27.8-rancher2"},
+		"v1.28.4-rancher1-1":  {Kubernetes: "rancher/hyperkube:v1.28.4-rancher1"},
+	}
+	versionInfo := map[string]rketypes.K8sVersionInfo{
+		"v1.24":               {MaxRancherVersion: "2.7.99"},
+		"v1.25":               {DeprecateRancherVersion: "2.8.0"},
+		"v1.26.11-rancher2-1": {MinRancherVersion: "2.7.10"},
+		"v1.28.4-rancher1-1":  {MinRancherVersion: "2.8.1"},
+	}
+
+	installable := InstallableRKESystemImages("2.8.0", rkeSystemImages, versionInfo)
+	assert.Len(installable, 2)
+	assert.Contains(installable, "v1.26.11-rancher2-1")
+	assert.Contains(installable, "v1.27.8-rancher2-2")
+
+	installable = InstallableRKESystemImages("2.7.5", rkeSystemImages, versionInfo)
+	assert.Len(installable, 3)
+	assert.Contains(installable, "v1.24.17-rancher1-1")
+	assert.Contains(installable, "v1.25.16-rancher2-1")
+	assert.Contains(installable, "v1.27.8-rancher2-2")
+}
+
+func TestParseK8sPatchMode(t *testing.T) {
+	assert := assertlib.New(t)
+
+	for value, expected := range map[string]K8sPatchMode{"": K8sPatchesLatest, "all": K8sPatchesAll, "latest": K8sPatchesLatest} {
+		mode, err := ParseK8sPatchMode(value)
+		assert.NoError(err)
+		assert.Equal(expected, mode)
+	}
+	_, err := ParseK8sPatchMode("oldest")
+	assert.Error(err)
+}
@@ -0,0 +1,59 @@
+package image
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultBlobWorkers is the number of blobs of an image RegistryClient copies concurrently.
+	defaultBlobWorkers = 1
+	// maxBandwidthBurst is the maximum number of bytes read at once from a blob stream limited by BandwidthLimiter.
+	maxBandwidthBurst = 64 * 1024
+)
+
+// BandwidthLimiter caps the bandwidth of the blobs RegistryClient copies, so copies from constrained hosts do not
+// saturate their links. The cap applies to all the blobs copied concurrently. A BandwidthLimiter is safe for
+// concurrent use and can be shared by several clients.
+type BandwidthLimiter struct {
+	limiter *rate.Limiter
+}
+
+// NewBandwidthLimiter returns a BandwidthLimiter copying at most bytesPerSecond bytes per second, or not limiting the
+// bandwidth if bytesPerSecond is 0.
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return &BandwidthLimiter{limiter: rate.NewLimiter(rate.Inf, maxBandwidthBurst)}
+	}
+	burst := int64(maxBandwidthBurst)
+	if bytesPerSecond < burst {
+		burst = bytesPerSecond
+	}
+	return &BandwidthLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))}
+}
+
+// reader returns stream read within the bandwidth of the limiter.
+func (l *BandwidthLimiter) reader(ctx context.Context, stream io.ReadCloser) io.ReadCloser {
+	return &limitedReader{ctx: ctx, ReadCloser: stream, limiter: l.limiter}
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
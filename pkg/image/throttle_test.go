@@ -0,0 +1,51 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestBandwidthLimiterReader(t *testing.T) {
+	assert := assertlib.New(t)
+
+	data := bytes.Repeat([]byte("a"), 3000)
+	limiter := NewBandwidthLimiter(10000)
+	start := time.Now()
+	read, err := io.ReadAll(limiter.reader(context.Background(), io.NopCloser(bytes.NewReader(data))))
+	assert.NoError(err)
+	assert.Equal(data, read)
+	// The first 10000 bytes are the burst of the limiter
+	assert.Less(time.Since(start), time.Second)
+
+	read, err = io.ReadAll(limiter.reader(context.Background(), io.NopCloser(bytes.NewReader(bytes.Repeat(data, 4)))))
+	assert.NoError(err)
+	assert.Len(read, 12000)
+	assert.GreaterOrEqual(time.Since(start), 400*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = io.ReadAll(limiter.reader(ctx, io.NopCloser(bytes.NewReader(data))))
+	assert.ErrorIs(err, context.Canceled)
+}
+
+func TestImageCopierCopyThrottled(t *testing.T) {
+	assert := assertlib.New(t)
+
+	source := newFakeRegistry(t)
+	manifestDigest := source.AddImage("rancher/shell", "v0.1.22", []byte("layer"))
+	dest := newFakeRegistry(t)
+	client := source.client()
+	client.BlobWorkers = 4
+	client.Bandwidth = NewBandwidthLimiter(1024)
+	copier := ImageCopier{Client: client, Registry: dest.Host(), SourceRegistry: source.Host()}
+	results, err := copier.Copy(context.Background(), ImageList{{Image: "rancher/shell:v0.1.22", OS: Linux}})
+	assert.NoError(err)
+	if assert.Len(results, 1) {
+		assert.Equal(manifestDigest, results[0].Digest)
+	}
+}
@@ -0,0 +1,278 @@
+package image
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/pkg/tlsclientconfig"
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// caBundleFile is the name of the file holding the additional CA certificates in the certificate directories prepared
+// by TLSConfig.
+const caBundleFile = "ca-bundle.crt"
+
+// defaultCertsDirs are the directories of per-host certificate directories read by containers/image when no
+// directory is configured, in order of precedence.
+var defaultCertsDirs = []string{
+	filepath.Join(os.Getenv("HOME"), ".config", "containers", "certs.d"),
+	"/etc/containers/certs.d",
+	"/etc/docker/certs.d",
+}
+
+// systemCABundles are the usual locations of the CA bundle of the system, the first one existing is trusted by the git
+// clones along with the additional CA certificates.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// TLSConfig configures the TLS connections to registries and to the other servers the images are gathered from, for
+// environments behind TLS intercepting proxies or with registries using private CAs: additional CA certificates are
+// trusted by every server, the certificates of each host are read from a per-host directory like /etc/docker/certs.d,
+// and the certificates of insecure hosts are not verified. Proxies are configured by the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables. A TLSConfig is safe for concurrent use and can be shared by several clients. Close
+// removes the certificate directories it prepares.
+type TLSConfig struct {
+	caBundle      []byte
+	certsDir      string
+	insecureHosts map[string]bool
+
+	mu         sync.Mutex
+	dir        string
+	transports map[string]http.RoundTripper
+}
+
+// NewTLSConfig returns a TLSConfig trusting the CA certificates of the PEM files caFiles in addition to the system
+// CAs, reading the certificates of each host from certsDir/HOST instead of the default directories if certsDir is not
+// empty, and not verifying the certificates of insecureHosts, e.g. registry.example.com:5000.
+func NewTLSConfig(caFiles []string, certsDir string, insecureHosts []string) (*TLSConfig, error) {
+	var bundle bytes.Buffer
+	for _, path := range caFiles {
+		in, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read CA file %s", path)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(in) {
+			return nil, errors.Errorf("no PEM certificate found in CA file %s", path)
+		}
+		bundle.Write(in)
+		bundle.WriteString("\n")
+	}
+	t := &TLSConfig{
+		caBundle:      bundle.Bytes(),
+		certsDir:      certsDir,
+		insecureHosts: make(map[string]bool, len(insecureHosts)),
+		transports:    make(map[string]http.RoundTripper),
+	}
+	for _, host := range insecureHosts {
+		t.insecureHosts[host] = true
+	}
+	return t, nil
+}
+
+// Insecure returns whether the certificates of host, e.g. registry.example.com:5000, are not verified.
+func (t *TLSConfig) Insecure(host string) bool {
+	return t.insecureHosts[host]
+}
+
+// Client returns an HTTP client sending requests through the proxies of the environment and verifying the servers
+// as configured.
+func (t *TLSConfig) Client() *http.Client {
+	return &http.Client{Transport: t.Transport()}
+}
+
+// Transport returns an HTTP transport sending requests through the proxies of the environment and verifying the
+// servers as configured.
+func (t *TLSConfig) Transport() http.RoundTripper {
+	return tlsTransport{config: t}
+}
+
+// Close removes the certificate directories prepared for containers/image and git.
+func (t *TLSConfig) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(t.dir)
+	t.dir = ""
+	return err
+}
+
+// GitConfig returns the git configuration options, as -c arguments, cloning repoURL with the configured certificates.
+func (t *TLSConfig) GitConfig(repoURL string) ([]string, error) {
+	var args []string
+	if u, err := url.Parse(repoURL); err == nil && t.Insecure(u.Host) {
+		args = append(args, "-c", "http.sslVerify=false")
+	}
+	if len(t.caBundle) == 0 {
+		return args, nil
+	}
+	// git replaces the system CAs by the CA file it is given, so the file holds both
+	bundle := append([]byte(nil), t.caBundle...)
+	for _, path := range systemCABundles {
+		if system, err := os.ReadFile(path); err == nil {
+			bundle = append(bundle, system...)
+			break
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dir, err := t.tempDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "git-"+caBundleFile)
+	if err := os.WriteFile(path, bundle, 0644); err != nil {
+		return nil, err
+	}
+	return append(args, "-c", "http.sslCAInfo="+path), nil
+}
+
+// apply configures the connections of sys to host.
+func (t *TLSConfig) apply(sys *types.SystemContext, host string) error {
+	if t.Insecure(host) {
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+	if len(t.caBundle) == 0 {
+		if t.certsDir != "" {
+			sys.DockerPerHostCertDirPath = t.certsDir
+		}
+		return nil
+	}
+	dir, err := t.preparedCertDir(host)
+	if err != nil {
+		return errors.Wrapf(err, "failed to prepare certificates of %s", host)
+	}
+	sys.DockerCertPath = dir
+	return nil
+}
+
+// hostCertDir returns the directory of the certificates of host, or an empty string if it has none.
+func (t *TLSConfig) hostCertDir(host string) string {
+	if t.certsDir != "" {
+		return filepath.Join(t.certsDir, host)
+	}
+	for _, dir := range defaultCertsDirs {
+		if _, err := os.Stat(filepath.Join(dir, host)); err == nil {
+			return filepath.Join(dir, host)
+		}
+	}
+	return ""
+}
+
+// preparedCertDir returns a directory holding the additional CA certificates along with links to the certificates of
+// host, since containers/image reads the certificates of a host from a single directory.
+func (t *TLSConfig) preparedCertDir(host string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	root, err := t.tempDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, strings.ReplaceAll(host, string(filepath.Separator), "_"))
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	if err := t.prepareCertDir(dir, host); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+func (t *TLSConfig) prepareCertDir(dir, host string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if hostDir := t.hostCertDir(host); hostDir != "" {
+		files, err := os.ReadDir(hostDir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, file := range files {
+			// Private keys are linked rather than copied, so they stay only where they were
+			if err := os.Symlink(filepath.Join(hostDir, file.Name()), filepath.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, caBundleFile), t.caBundle, 0644)
+}
+
+// tempDir returns the directory of the prepared certificate directories, creating it if needed. t.mu must be held.
+func (t *TLSConfig) tempDir() (string, error) {
+	if t.dir != "" {
+		return t.dir, nil
+	}
+	dir, err := os.MkdirTemp("", "rancher-images-certs-")
+	if err != nil {
+		return "", err
+	}
+	t.dir = dir
+	return dir, nil
+}
+
+// transport returns the HTTP transport of host, trusting its certificates and the additional CA certificates, or not
+// verifying its certificates if it is insecure.
+func (t *TLSConfig) transport(host string) (http.RoundTripper, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if transport, ok := t.transports[host]; ok {
+		return transport, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.Insecure(host) {
+		config.InsecureSkipVerify = true //nolint:gosec // requested for this host
+	} else {
+		if dir := t.hostCertDir(host); dir != "" {
+			if err := tlsclientconfig.SetupCertificates(dir, config); err != nil {
+				return nil, errors.Wrapf(err, "failed to read certificates of %s", host)
+			}
+		}
+		if len(t.caBundle) > 0 {
+			if config.RootCAs == nil {
+				pool, err := x509.SystemCertPool()
+				if err != nil {
+					pool = x509.NewCertPool()
+				}
+				config.RootCAs = pool
+			}
+			config.RootCAs.AppendCertsFromPEM(t.caBundle)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	t.transports[host] = transport
+	return transport, nil
+}
+
+// tlsTransport sends each request with the transport of its host.
+type tlsTransport struct {
+	config *TLSConfig
+}
+
+func (t tlsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.config.transport(req.URL.Host)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
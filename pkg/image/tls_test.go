@@ -0,0 +1,84 @@
+package image
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfigClient(t *testing.T) {
+	assert := assertlib.New(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	untrusted, err := NewTLSConfig(nil, "", nil)
+	assert.NoError(err)
+	_, err = untrusted.Client().Get(server.URL)
+	assert.Error(err)
+
+	trusted, err := NewTLSConfig([]string{caFile}, "", nil)
+	assert.NoError(err)
+	resp, err := trusted.Client().Get(server.URL)
+	if assert.NoError(err) {
+		resp.Body.Close()
+	}
+
+	insecure, err := NewTLSConfig(nil, "", []string{host})
+	assert.NoError(err)
+	resp, err = insecure.Client().Get(server.URL)
+	if assert.NoError(err) {
+		resp.Body.Close()
+	}
+
+	_, err = NewTLSConfig([]string{filepath.Join(t.TempDir(), "missing.pem")}, "", nil)
+	assert.Error(err)
+}
+
+func TestTLSConfigApply(t *testing.T) {
+	assert := assertlib.New(t)
+
+	certsDir := t.TempDir()
+	assert.NoError(os.MkdirAll(filepath.Join(certsDir, "registry.example.com"), 0755))
+	assert.NoError(os.WriteFile(filepath.Join(certsDir, "registry.example.com", "client.key"), []byte("key"), 0600))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	config, err := NewTLSConfig(nil, certsDir, []string{"insecure.example.com:5000"})
+	assert.NoError(err)
+	var sys types.SystemContext
+	assert.NoError(config.apply(&sys, "insecure.example.com:5000"))
+	assert.Equal(types.OptionalBoolTrue, sys.DockerInsecureSkipTLSVerify)
+	assert.Equal(certsDir, sys.DockerPerHostCertDirPath)
+
+	config, err = NewTLSConfig([]string{caFile}, certsDir, nil)
+	assert.NoError(err)
+	sys = types.SystemContext{}
+	assert.NoError(config.apply(&sys, "registry.example.com"))
+	assert.Equal(types.OptionalBoolUndefined, sys.DockerInsecureSkipTLSVerify)
+	assert.FileExists(filepath.Join(sys.DockerCertPath, caBundleFile))
+	key, err := os.ReadFile(filepath.Join(sys.DockerCertPath, "client.key"))
+	assert.NoError(err)
+	assert.Equal("key", string(key))
+
+	args, err := config.GitConfig("https://git.example.com/rancher/charts")
+	assert.NoError(err)
+	if assert.Len(args, 2) {
+		assert.FileExists(strings.TrimPrefix(args[1], "http.sslCAInfo="))
+	}
+
+	assert.NoError(config.Close())
+	assert.NoDirExists(sys.DockerCertPath)
+}
@@ -0,0 +1,209 @@
+package image
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultProgressInterval is the minimum interval between two transfer progress reports of ImageCopier.
+const defaultProgressInterval = time.Second
+
+// CopyProgress is the progress of the transfer of the images of an ImageCopier, reported while their blobs are copied.
+// The bytes of an image are known once its manifests are read, and the blobs the private registry already has are not
+// counted, so the totals grow as the copy goes and the remaining bytes of the images not started yet are estimated
+// from the images already copied.
+type CopyProgress struct {
+	// Image is the image whose transfer progressed.
+	Image string `json:"image"`
+	// ImageBytes is the number of bytes of the image transferred.
+	ImageBytes int64 `json:"imageBytes"`
+	// ImageTotalBytes is the number of bytes of the image to transfer.
+	ImageTotalBytes int64 `json:"imageTotalBytes"`
+	// Bytes is the number of bytes of every image transferred.
+	Bytes int64 `json:"bytes"`
+	// TotalBytes is the number of bytes to transfer of the images started.
+	TotalBytes int64 `json:"totalBytes"`
+	// RemainingBytes is the estimated number of bytes left to transfer, including the images not started yet.
+	RemainingBytes int64 `json:"remainingBytes"`
+	// Images is the number of images done, i.e. copied, skipped or failed.
+	Images int `json:"images"`
+	// TotalImages is the number of images to copy.
+	TotalImages int `json:"totalImages"`
+	// Elapsed is the time since the copy started.
+	Elapsed time.Duration `json:"elapsed"`
+	// ETA is the estimated time left until every image is copied, at the average rate of the transfer so far, or 0 if
+	// nothing was transferred yet.
+	ETA time.Duration `json:"eta"`
+}
+
+// transferTracker aggregates the transfer progress of the images of a copy and reports it at most once per interval,
+// and whenever an image is done.
+type transferTracker struct {
+	report   func(progress CopyProgress)
+	interval time.Duration
+
+	mu            sync.Mutex
+	start         time.Time
+	lastReport    time.Time
+	totalImages   int
+	startedImages int
+	doneImages    int
+	copiedImages  int
+	copiedBytes   int64
+	bytes         int64
+	totalBytes    int64
+	images        map[string]*imageTransfer
+}
+
+// newTransferTracker returns a tracker of the transfer of totalImages images reporting to report at most once per
+// interval, defaultProgressInterval if not set.
+func newTransferTracker(totalImages int, interval time.Duration, report func(progress CopyProgress)) *transferTracker {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	return &transferTracker{
+		report:      report,
+		interval:    interval,
+		start:       time.Now(),
+		totalImages: totalImages,
+		images:      make(map[string]*imageTransfer),
+	}
+}
+
+// image returns the progress of the transfer of image, reset if the image is transferred again by a retry.
+func (t *transferTracker) image(image string) *imageTransfer {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p, ok := t.images[image]; ok {
+		t.bytes -= p.bytes
+		t.totalBytes -= p.total
+		p.bytes, p.total = 0, 0
+		return p
+	}
+	p := &imageTransfer{tracker: t, image: image}
+	t.images[image] = p
+	t.startedImages++
+	return p
+}
+
+// done records that the transfer of image is over, copied if it succeeded, and reports the progress.
+func (t *transferTracker) done(image string, copied bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.doneImages++
+	p, started := t.images[image]
+	if !started {
+		t.startedImages++
+	}
+	if copied && started {
+		t.copiedImages++
+		t.copiedBytes += p.total
+	}
+	t.reportLocked(image, p, true)
+}
+
+// reportLocked reports the progress after the transfer of image progressed, unless the last report is more recent than
+// the interval and force is false. t.mu must be held.
+func (t *transferTracker) reportLocked(image string, p *imageTransfer, force bool) {
+	now := time.Now()
+	if !force && now.Sub(t.lastReport) < t.interval {
+		return
+	}
+	t.lastReport = now
+	progress := CopyProgress{
+		Image:       image,
+		Bytes:       t.bytes,
+		TotalBytes:  t.totalBytes,
+		Images:      t.doneImages,
+		TotalImages: t.totalImages,
+		Elapsed:     now.Sub(t.start),
+	}
+	if p != nil {
+		progress.ImageBytes, progress.ImageTotalBytes = p.bytes, p.total
+	}
+	// The images not started yet are estimated to be as large as the images copied so far
+	var averageBytes int64
+	switch {
+	case t.copiedImages > 0:
+		averageBytes = t.copiedBytes / int64(t.copiedImages)
+	case t.startedImages > 0:
+		averageBytes = t.totalBytes / int64(t.startedImages)
+	}
+	progress.RemainingBytes = t.totalBytes - t.bytes + averageBytes*int64(t.totalImages-t.startedImages)
+	if progress.RemainingBytes < 0 {
+		progress.RemainingBytes = 0
+	}
+	if t.bytes > 0 {
+		progress.ETA = time.Duration(float64(progress.Elapsed) * float64(progress.RemainingBytes) / float64(t.bytes))
+	}
+	t.report(progress)
+}
+
+// imageTransfer is the progress of the transfer of an image. Its methods do nothing on a nil imageTransfer, so the
+// copies without a tracker need no checks.
+type imageTransfer struct {
+	tracker *transferTracker
+	image   string
+	bytes   int64
+	total   int64
+}
+
+// expect adds size bytes to transfer to the image.
+func (p *imageTransfer) expect(size int64) {
+	if p == nil || size <= 0 {
+		return
+	}
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+	p.total += size
+	p.tracker.totalBytes += size
+}
+
+// reused removes size bytes the destination already has from the bytes to transfer of the image.
+func (p *imageTransfer) reused(size int64) {
+	if p == nil || size <= 0 {
+		return
+	}
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+	p.total -= size
+	p.tracker.totalBytes -= size
+}
+
+// transferred adds n transferred bytes to the image and reports the progress.
+func (p *imageTransfer) transferred(n int64) {
+	if p == nil || n <= 0 {
+		return
+	}
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+	p.bytes += n
+	p.tracker.bytes += n
+	p.tracker.reportLocked(p.image, p, false)
+}
+
+// reader returns stream counting the bytes read from it as transferred.
+func (p *imageTransfer) reader(stream io.ReadCloser) io.ReadCloser {
+	if p == nil {
+		return stream
+	}
+	return &transferReader{ReadCloser: stream, progress: p}
+}
+
+type transferReader struct {
+	io.ReadCloser
+	progress *imageTransfer
+}
+
+func (r *transferReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.progress.transferred(int64(n))
+	return n, err
+}
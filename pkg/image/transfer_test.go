@@ -0,0 +1,97 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestTransferTracker(t *testing.T) {
+	assert := assertlib.New(t)
+
+	var reports []CopyProgress
+	tracker := newTransferTracker(3, time.Hour, func(progress CopyProgress) {
+		reports = append(reports, progress)
+	})
+	shell := tracker.image("rancher/shell:v0.1.22")
+	shell.expect(100)
+	shell.expect(50)
+	shell.reused(50)
+	_, err := io.Copy(io.Discard, shell.reader(io.NopCloser(bytes.NewReader(make([]byte, 100)))))
+	assert.NoError(err)
+	// The first transfer is reported, the next ones wait for the interval
+	if assert.NotEmpty(reports) {
+		assert.Equal("rancher/shell:v0.1.22", reports[0].Image)
+		assert.Equal(int64(100), reports[0].ImageTotalBytes)
+	}
+	tracker.done("rancher/shell:v0.1.22", true)
+
+	last := reports[len(reports)-1]
+	assert.Equal(int64(100), last.ImageBytes)
+	assert.Equal(int64(100), last.Bytes)
+	assert.Equal(int64(100), last.TotalBytes)
+	assert.Equal(1, last.Images)
+	assert.Equal(3, last.TotalImages)
+	// The 2 images not started are estimated to be as large as the copied one
+	assert.Equal(int64(200), last.RemainingBytes)
+	assert.Greater(last.ETA, time.Duration(0))
+
+	// A retry resets the transfer of the image
+	agent := tracker.image("rancher/rancher-agent:v2.8.0")
+	agent.expect(300)
+	agent.transferred(10)
+	agent = tracker.image("rancher/rancher-agent:v2.8.0")
+	agent.expect(300)
+	tracker.done("rancher/rancher-agent:v2.8.0", false)
+	last = reports[len(reports)-1]
+	assert.Equal(int64(0), last.ImageBytes)
+	assert.Equal(int64(100), last.Bytes)
+	assert.Equal(int64(400), last.TotalBytes)
+	assert.Equal(2, last.Images)
+
+	// Images without tracker are not tracked
+	var untracked *transferTracker
+	untracked.image("rancher/shell:v0.1.22").expect(100)
+	untracked.done("rancher/shell:v0.1.22", true)
+}
+
+func TestImageCopierTransferProgress(t *testing.T) {
+	assert := assertlib.New(t)
+
+	source := newFakeRegistry(t)
+	source.AddImage("rancher/shell", "v0.1.22", []byte("layer"))
+	dest := newFakeRegistry(t)
+	var reports []CopyProgress
+	copier := ImageCopier{
+		Client:         source.client(),
+		Registry:       dest.Host(),
+		SourceRegistry: source.Host(),
+		TransferProgress: func(progress CopyProgress) {
+			reports = append(reports, progress)
+		},
+	}
+	_, err := copier.Copy(context.Background(), ImageList{{Image: "rancher/shell:v0.1.22", OS: Linux}})
+	assert.NoError(err)
+	if assert.NotEmpty(reports) {
+		last := reports[len(reports)-1]
+		assert.Equal(1, last.Images)
+		assert.Equal(1, last.TotalImages)
+		assert.Equal(last.TotalBytes, last.Bytes)
+		assert.Greater(last.Bytes, int64(len("layer")))
+		assert.Equal(int64(0), last.RemainingBytes)
+	}
+
+	// The blobs the private registry already has are not transferred again
+	reports = nil
+	copier.Client = dest.client()
+	copier.SourceRegistry = dest.Host()
+	_, err = copier.Copy(context.Background(), ImageList{{Image: "rancher/shell:v0.1.22", OS: Linux}})
+	assert.NoError(err)
+	if assert.NotEmpty(reports) {
+		assert.Equal(int64(0), reports[len(reports)-1].TotalBytes)
+	}
+}
@@ -1,38 +1,41 @@
 package utilities
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
-	"github.com/coreos/go-semver/semver"
 	kd "github.com/rancher/rancher/pkg/controllers/management/kontainerdrivermetadata"
 	img "github.com/rancher/rancher/pkg/image"
-	ext "github.com/rancher/rancher/pkg/image/external"
 	"github.com/rancher/rancher/pkg/settings"
+	rkedata "github.com/rancher/rke/data"
 	"github.com/rancher/rke/types/image"
 	"github.com/rancher/rke/types/kdm"
 )
 
 var (
 	scriptMap = map[string]string{
-		"linux-save":     linuxSaveScript,
-		"linux-load":     linuxLoadScript,
 		"linux-mirror":   linuxMirrorScript,
 		"windows-save":   windowsSaveScript,
 		"windows-load":   windowsLoadScript,
 		"windows-mirror": windowsMirrorScript,
 	}
 	scriptNameMap = map[string]string{
-		"linux-save":     "rancher-save-images.sh",
-		"linux-load":     "rancher-load-images.sh",
-		"linux-mirror":   "rancher-mirror-to-rancher-org.sh",
-		"windows-save":   "rancher-save-images.ps1",
-		"windows-load":   "rancher-load-images.ps1",
-		"windows-mirror": "rancher-mirror-to-rancher-org.ps1",
+		"linux-save":            "rancher-save-images.sh",
+		"linux-load":            "rancher-load-images.sh",
+		"linux-containerd-save": "rancher-save-images-containerd.sh",
+		"linux-containerd-load": "rancher-load-images-containerd.sh",
+		"linux-mirror":          "rancher-mirror-to-rancher-org.sh",
+		"windows-save":          "rancher-save-images.ps1",
+		"windows-load":          "rancher-load-images.ps1",
+		"windows-mirror":        "rancher-mirror-to-rancher-org.ps1",
 	}
 	filenameMap = map[string]string{
 		"linux":   "rancher-images.txt",
@@ -42,163 +45,454 @@
 		"linux":   "rancher-images-sources.txt",
 		"windows": "rancher-windows-images-sources.txt",
 	}
+	excludedFilenameMap = map[string]string{
+		"linux":   "rancher-images-excluded.txt",
+		"windows": "rancher-windows-images-excluded.txt",
+	}
+	requiredFilenameMap = map[string]string{
+		"linux":   "rancher-images-required.txt",
+		"windows": "rancher-windows-images-required.txt",
+	}
+	missingFilenameMap = map[string]string{
+		"linux":   "rancher-images-missing.txt",
+		"windows": "rancher-windows-images-missing.txt",
+	}
+	nonCompliantFilenameMap = map[string]string{
+		"linux":   "rancher-images-noncompliant.txt",
+		"windows": "rancher-windows-images-noncompliant.txt",
+	}
 )
 
+const obsoleteFilename = "rancher-images-obsolete.txt"
+
+// osFilename returns the filename of os in filenames, or the filename of the images of os followed by suffix, e.g.
+// rancher-freebsd-images-sources.txt, for the OS types registered with img.RegisterOSType.
+func osFilename(filenames map[string]string, os, suffix string) string {
+	if filename, ok := filenames[os]; ok {
+		return filename
+	}
+	return "rancher-" + os + "-images" + suffix + ".txt"
+}
+
 // ImageTargetsAndSources is an aggregate type containing
-// the list of images used by Rancher for Linux and Windows,
+// the list of images used by Rancher for each OS type,
 // as well as the source of these images.
 type ImageTargetsAndSources struct {
-	LinuxImagesFromArgs           []string
+	LinuxImagesFromArgs []string
+	// ImageLists are the image lists of every registered OS type, see ImageList. The lists of Linux and Windows are
+	// also held by LinuxImageList and WindowsImageList, along with their target images and sources.
+	ImageLists                    map[img.OSType]img.ImageList
+	LinuxImageList                img.ImageList
+	WindowsImageList              img.ImageList
+	ExcludedImageList             img.ImageList
 	TargetLinuxImages             []string
 	TargetLinuxImagesAndSources   []string
 	TargetWindowsImages           []string
 	TargetWindowsImagesAndSources []string
+	// ChartErrors are the charts that could not be scanned, in which case the image lists are incomplete.
+	ChartErrors img.ChartErrors
+	// ChartWarnings are the problems of the values of the scanned charts, see img.ExportResult.
+	ChartWarnings []img.ChartWarning
+	// RancherVersions are the Rancher versions the images were gathered for.
+	RancherVersions []string
+	// KDM is the KDM data the images were gathered from.
+	KDM KDMSnapshot
+	// MirrorMode is how upstream images were converted to their mirrored names, see img.ExportConfig.
+	MirrorMode img.MirrorMode
+}
+
+// ImageList returns the image list of osType.
+func (t ImageTargetsAndSources) ImageList(osType img.OSType) img.ImageList {
+	return t.ImageLists[osType]
+}
+
+// SetImageList replaces the image list of osType with list, along with the target images and sources of Linux and
+// Windows.
+func (t *ImageTargetsAndSources) SetImageList(osType img.OSType, list img.ImageList) {
+	if t.ImageLists == nil {
+		t.ImageLists = make(map[img.OSType]img.ImageList)
+	}
+	t.ImageLists[osType] = list
+	switch osType {
+	case img.Linux:
+		t.LinuxImageList = list
+		t.TargetLinuxImages = list.Images()
+		t.TargetLinuxImagesAndSources = list.ImagesAndSources()
+	case img.Windows:
+		t.WindowsImageList = list
+		t.TargetWindowsImages = list.Images()
+		t.TargetWindowsImagesAndSources = list.ImagesAndSources()
+	}
 }
 
 // GatherTargetImagesAndSources queries KDM, charts and system-charts to gather all the images used by Rancher and their source.
 // It returns an aggregate type, ImageTargetsAndSources, which contains the images required to run Rancher on Linux and Windows, as well
 // as the source of each image.
 func GatherTargetImagesAndSources(systemChartsPath, chartsPath string, imagesFromArgs []string) (ImageTargetsAndSources, error) {
-	rancherVersion, ok := os.LookupEnv("TAG")
-	if !ok {
-		return ImageTargetsAndSources{}, fmt.Errorf("no tag defining current Rancher version, cannot gather target images and sources")
+	return GatherTargetImages(GatherOptions{
+		SystemChartsPath: systemChartsPath,
+		ChartsPath:       chartsPath,
+		ImagesFromArgs:   imagesFromArgs,
+	})
+}
+
+// GatherOptions configures how GatherTargetImages gathers the images used by Rancher.
+type GatherOptions struct {
+	SystemChartsPath string
+	ChartsPath       string
+	// RancherVersions are the Rancher versions to gather images for. When more than one version is given, the
+	// union of their images is returned and each image records which of the versions require it. Defaults to the
+	// version in the TAG environment variable.
+	RancherVersions []string
+	// ImagesFromArgs are additional Rancher images, which must include the rancher/wins upgrade image.
+	ImagesFromArgs []string
+	// ExcludePatterns are patterns of images to drop from the gathered lists, see img.ImageFilter.
+	ExcludePatterns []string
+	// ExtraImagesFiles are files listing additional images to merge into the gathered lists, see img.ExtraImages.
+	ExtraImagesFiles []string
+	// Progress, if set, is called as images are gathered.
+	Progress img.ProgressFunc
+	// Strict makes gathering fail on the first chart that cannot be scanned instead of reporting it in ChartErrors.
+	Strict bool
+	// CoreOnly limits the gathered images to those strictly required to run Rancher and provision clusters, see
+	// img.ExportConfig.
+	CoreOnly bool
+	// MirrorMapping adds mirrors to the ones of the rke types, see img.ExportConfig.
+	MirrorMapping img.RegistryMapping
+	// MirrorMode is how upstream images are converted to their mirrored names, see img.ExportConfig.
+	MirrorMode img.MirrorMode
+	// RegistryMapping, if set, rewrites the gathered images, see img.ExportConfig.
+	RegistryMapping img.RegistryMapping
+	// Features are Rancher feature flags, charts of disabled features are skipped, see img.ExportConfig.
+	Features map[string]bool
+	// OSTypes limits the gathered images to the given OS types, see img.ExportConfig.
+	OSTypes []img.OSType
+	// Arches limits the gathered images to the given architectures, see img.ExportConfig.
+	Arches []img.Arch
+	// ChartArches are the architectures supported by charts, keyed by chart name, see img.ExportConfig.
+	ChartArches map[string][]img.Arch
+	// ChartOSTypes are the OS types all the images of charts are exported for, keyed by chart name, see
+	// img.ExportConfig.
+	ChartOSTypes map[string][]img.OSType
+	// InstallableK8sVersionsOnly limits the RKE system images to the Kubernetes versions the Rancher versions can
+	// install, see img.ExportConfig.
+	InstallableK8sVersionsOnly bool
+	// K8sPatches is which patch versions of each Kubernetes minor version the RKE system images are gathered for, see
+	// img.ExportConfig.
+	K8sPatches img.K8sPatchMode
+	// RequirementImages are core images required on each OS type in addition to the default ones, see
+	// img.ExportConfig.
+	RequirementImages map[img.OSType][]img.RequirementImage
+	// RKE2Components are the optional RKE2 components whose images are gathered along with the core RKE2 images, see
+	// img.ExportConfig.
+	RKE2Components []string
+	// Distributions are the Kubernetes distributions whose images are gathered, see img.ExportConfig.
+	Distributions []img.Distribution
+	// ValuesLimits limits the size and depth of the values files of the scanned charts, see img.ExportConfig.
+	ValuesLimits img.ValuesLimits
+	// KDMDataSource is where the KDM data is loaded from: the path or http(s) URL of a data.json file, or
+	// img.EmbeddedKDMSource, see LoadKDMData. Defaults to ./data.json, or $HOME/bin/data.json if it does not exist.
+	KDMDataSource string
+	// DownloadCache caches the KDM data and the image lists downloaded while gathering, see img.DownloadCache. Files
+	// are downloaded every time if nil.
+	DownloadCache *img.DownloadCache
+	// ScanCache holds the images found in the charts scanned by previous gatherings, see img.ScanCache. Charts are
+	// scanned every time if nil.
+	ScanCache *img.ScanCache
+	// HTTPClient downloads the KDM data and the image lists, and queries GitHub, the default HTTP client if nil.
+	HTTPClient *http.Client
+}
+
+// GatherTargetImages works like GatherTargetImagesAndSources, but is configured through options.
+func GatherTargetImages(options GatherOptions) (ImageTargetsAndSources, error) {
+	rancherVersions := options.RancherVersions
+	imagesFromArgs := options.ImagesFromArgs
+	if len(rancherVersions) == 0 {
+		rancherVersion, ok := os.LookupEnv("TAG")
+		if !ok {
+			return ImageTargetsAndSources{}, fmt.Errorf("no tag defining current Rancher version, cannot gather target images and sources")
+		}
+		rancherVersions = []string{rancherVersion}
 	}
 
-	if !img.IsValidSemver(rancherVersion) || strings.HasPrefix(rancherVersion, "dev") || strings.HasPrefix(rancherVersion, "master") || strings.HasSuffix(rancherVersion, "-head") {
-		rancherVersion = settings.RancherVersionDev
+	ctx := img.WithDownloadCache(img.WithDecodeCache(context.Background()), options.DownloadCache)
+	ctx = img.WithScanCache(ctx, options.ScanCache)
+	ctx = img.WithHTTPClient(ctx, options.HTTPClient)
+	data, kdmSnapshot, err := loadKDMData(ctx, options.KDMDataSource, options.DownloadCache)
+	if err != nil {
+		return ImageTargetsAndSources{}, err
 	}
-	rancherVersion = strings.TrimPrefix(rancherVersion, "v")
 
-	// already downloaded in dapper
-	b, err := os.ReadFile(filepath.Join("data.json"))
-	if os.IsNotExist(err) {
-		b, err = os.ReadFile(filepath.Join(os.Getenv("HOME"), "bin", "data.json"))
+	sort.Strings(imagesFromArgs)
+	winsIndex := sort.SearchStrings(imagesFromArgs, "rancher/wins")
+	if winsIndex > len(imagesFromArgs)-1 {
+		return ImageTargetsAndSources{}, fmt.Errorf("rancher/wins upgrade image not found")
 	}
-	if err != nil {
-		return ImageTargetsAndSources{}, fmt.Errorf("could not read data.json: %w", err)
+
+	winsAgentUpdateImage := imagesFromArgs[winsIndex]
+	linuxImagesFromArgs := append(imagesFromArgs[:winsIndex], imagesFromArgs[winsIndex+1:]...)
+
+	k8sVersionsSet := make(map[string]struct{})
+	var normalizedVersions []string
+	listsByVersion := make(map[string]img.ImageList, len(rancherVersions))
+	excludedByVersion := make(map[string]img.ImageList, len(rancherVersions))
+	var chartErrs img.ChartErrors
+	chartErrsSet := make(map[string]struct{})
+	var chartWarnings []img.ChartWarning
+	chartWarningsSet := make(map[img.ChartWarning]struct{})
+	for _, rancherVersion := range rancherVersions {
+		rancherVersion = normalizeRancherVersion(rancherVersion)
+		normalizedVersions = append(normalizedVersions, rancherVersion)
+		exportConfig := img.ExportConfig{
+			SystemChartsPath:           options.SystemChartsPath,
+			ChartsPath:                 options.ChartsPath,
+			RancherVersion:             rancherVersion,
+			ExcludePatterns:            options.ExcludePatterns,
+			ExtraImagesFiles:           options.ExtraImagesFiles,
+			Progress:                   options.Progress,
+			Strict:                     options.Strict,
+			CoreOnly:                   options.CoreOnly,
+			MirrorMapping:              options.MirrorMapping,
+			MirrorMode:                 options.MirrorMode,
+			RegistryMapping:            options.RegistryMapping,
+			Features:                   options.Features,
+			OSTypes:                    options.OSTypes,
+			Arches:                     options.Arches,
+			ChartArches:                options.ChartArches,
+			ChartOSTypes:               options.ChartOSTypes,
+			RequirementImages:          options.RequirementImages,
+			InstallableK8sVersionsOnly: options.InstallableK8sVersionsOnly,
+			K8sPatches:                 options.K8sPatches,
+			RKE2Components:             options.RKE2Components,
+			Distributions:              options.Distributions,
+			ValuesLimits:               options.ValuesLimits,
+		}
+		result, k8sVersions, err := gatherImageList(ctx, exportConfig, data, linuxImagesFromArgs, winsAgentUpdateImage)
+		if err != nil {
+			return ImageTargetsAndSources{}, fmt.Errorf("could not gather images for Rancher version %s: %w", rancherVersion, err)
+		}
+		listsByVersion[rancherVersion] = result.Images
+		excludedByVersion[rancherVersion] = result.Excluded
+		// The same chart is usually scanned for every version, only report its errors once
+		for _, chartErr := range result.ChartErrors {
+			if _, ok := chartErrsSet[chartErr.Error()]; !ok {
+				chartErrsSet[chartErr.Error()] = struct{}{}
+				chartErrs = append(chartErrs, chartErr)
+			}
+		}
+		for _, warning := range result.ChartWarnings {
+			if _, ok := chartWarningsSet[warning]; !ok {
+				chartWarningsSet[warning] = struct{}{}
+				chartWarnings = append(chartWarnings, warning)
+			}
+		}
+		for _, k8sVersion := range k8sVersions {
+			k8sVersionsSet[k8sVersion] = struct{}{}
+		}
 	}
-	data, err := kdm.FromData(b)
-	if err != nil {
-		return ImageTargetsAndSources{}, fmt.Errorf("could not load KDM data: %w", err)
+
+	var k8sVersions []string
+	for k := range k8sVersionsSet {
+		k8sVersions = append(k8sVersions, k)
 	}
+	sort.Strings(k8sVersions)
+	if err := writeSliceToFile(filepath.Join(os.Getenv("HOME"), "bin", "rancher-rke-k8s-versions.txt"), k8sVersions); err != nil {
+		return ImageTargetsAndSources{}, fmt.Errorf("%s: %w", "could not write rancher-rke-k8s-versions.txt file", err)
+	}
+
+	imageList := mergeImageLists(listsByVersion)
+	targetsAndSources := ImageTargetsAndSources{
+		LinuxImagesFromArgs: linuxImagesFromArgs,
+		ExcludedImageList:   mergeImageLists(excludedByVersion),
+		ChartErrors:         chartErrs,
+		ChartWarnings:       chartWarnings,
+		RancherVersions:     normalizedVersions,
+		KDM:                 kdmSnapshot,
+		MirrorMode:          options.MirrorMode,
+	}
+	for _, osType := range img.RegisteredOSTypes() {
+		targetsAndSources.SetImageList(osType, imageList.ForOS(osType))
+	}
+	return targetsAndSources, nil
+}
 
-	linuxInfo, windowsInfo := kd.GetK8sVersionInfo(
+// mergeImageLists returns the union of the image lists of several Rancher versions. A single list is returned as is.
+func mergeImageLists(listsByVersion map[string]img.ImageList) img.ImageList {
+	if len(listsByVersion) == 1 {
+		for _, list := range listsByVersion {
+			return list
+		}
+	}
+	return img.SupersetImageList(listsByVersion)
+}
+
+// gatherImageList gathers the images used by the Rancher version of exportConfig for every registered OS type. It also
+// returns the RKE Kubernetes versions supported by that Rancher version.
+func gatherImageList(ctx context.Context, exportConfig img.ExportConfig, data kdm.Data, linuxImagesFromArgs []string, winsAgentUpdateImage string) (img.ExportResult, []string, error) {
+	rancherVersion := exportConfig.RancherVersion
+	getK8sVersionInfo := kd.GetK8sVersionInfo
+	if exportConfig.K8sPatches == img.K8sPatchesAll {
+		getK8sVersionInfo = kd.GetAllK8sVersionInfo
+	}
+	linuxInfo, windowsInfo := getK8sVersionInfo(
 		rancherVersion,
 		data.K8sVersionRKESystemImages,
 		data.K8sVersionServiceOptions,
 		data.K8sVersionWindowsServiceOptions,
 		data.K8sVersionInfo,
 	)
+	if exportConfig.InstallableK8sVersionsOnly {
+		linuxInfo.RKESystemImages = img.InstallableRKESystemImages(rancherVersion, linuxInfo.RKESystemImages, data.K8sVersionInfo)
+		windowsInfo.RKESystemImages = img.InstallableRKESystemImages(rancherVersion, windowsInfo.RKESystemImages, data.K8sVersionInfo)
+	}
 
 	var k8sVersions []string
 	for k := range linuxInfo.RKESystemImages {
 		k8sVersions = append(k8sVersions, k)
 	}
-	sort.Strings(k8sVersions)
-	if err := writeSliceToFile(filepath.Join(os.Getenv("HOME"), "bin", "rancher-rke-k8s-versions.txt"), k8sVersions); err != nil {
-		return ImageTargetsAndSources{}, fmt.Errorf("%s: %w", "could not write rancher-rke-k8s-versions.txt file", err)
-	}
 
-	k8sVersion1_21_0 := &semver.Version{
-		Major: 1,
-		Minor: 21,
-		Patch: 0,
+	exportConfig.K3sReleases = data.K3S
+	exportConfig.RKE2Releases = data.RKE2
+	exportConfig.RKEAddonTemplates = data.K8sVersionedTemplates
+
+	inputs := map[img.OSType]img.OSImageInputs{
+		img.Linux: {
+			ImagesFromArgs:  linuxImagesFromArgs,
+			RKESystemImages: linuxInfo.RKESystemImages,
+		},
+		img.Windows: {
+			ImagesFromArgs:  []string{getWindowsAgentImage(), winsAgentUpdateImage},
+			RKESystemImages: windowsInfo.RKESystemImages,
+		},
+	}
+	// The other OS types have no KDM or Rancher images, only the ones of the charts, extra images and requirements
+	for _, osType := range img.RegisteredOSTypes() {
+		if _, ok := inputs[osType]; !ok {
+			inputs[osType] = img.OSImageInputs{}
+		}
 	}
-
-	externalLinuxImages := make(map[string][]string)
-
-	k3sUpgradeImages, err := ext.GetExternalImages(rancherVersion, data.K3S, ext.K3S, k8sVersion1_21_0, img.Linux)
+	result, err := img.GetImagesForOSTypes(ctx, exportConfig, inputs)
 	if err != nil {
-		return ImageTargetsAndSources{}, fmt.Errorf("%s: %w", "could not get external images for K3s", err)
-	}
-	if k3sUpgradeImages != nil {
-		externalLinuxImages["k3sUpgrade"] = k3sUpgradeImages
+		return img.ExportResult{}, nil, err
+	}
+	return result, k8sVersions, nil
+}
+
+// LoadKDMData loads the KDM data of source: the path of a data.json file, its http(s) URL, e.g.
+// https://releases.rancher.com/kontainer-driver-metadata/release-v2.8/data.json, or img.EmbeddedKDMSource. The
+// data.json file already downloaded in dapper is read from ./data.json, or $HOME/bin/data.json if it does not exist,
+// if source is empty. The data.json file at a URL is downloaded through cache, if any.
+func LoadKDMData(source string, cache *img.DownloadCache) (kdm.Data, error) {
+	data, _, err := loadKDMData(context.Background(), source, cache)
+	return data, err
+}
+
+// KDMSnapshot identifies the KDM data images were gathered from.
+type KDMSnapshot struct {
+	// Source is where the KDM data was loaded from: the path or URL of its data.json file, or img.EmbeddedKDMSource.
+	Source string
+	// Digest is the sha256 digest of the data.json file, e.g. sha256:0123...
+	Digest string
+}
+
+// loadKDMData works like LoadKDMData, and also returns the snapshot of the loaded KDM data.
+func loadKDMData(ctx context.Context, source string, cache *img.DownloadCache) (kdm.Data, KDMSnapshot, error) {
+	var b []byte
+	var err error
+	switch {
+	case source == img.EmbeddedKDMSource:
+		b, err = rkedata.Asset("data/data.json")
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		b, err = cache.Download(ctx, source)
+	case source != "":
+		b, err = os.ReadFile(source)
+	default:
+		source = "data.json"
+		b, err = os.ReadFile(source)
+		if os.IsNotExist(err) {
+			source = filepath.Join(os.Getenv("HOME"), "bin", "data.json")
+			b, err = os.ReadFile(source)
+		}
 	}
-
-	// RKE2 Provisioning will only be supported on Kubernetes v1.21+. In addition, only RKE2
-	// releases corresponding to Kubernetes v1.21+ include the "rke2-images-all.linux-amd64.txt" file that we need.
-	rke2LinuxImages, err := ext.GetExternalImages(rancherVersion, data.RKE2, ext.RKE2, k8sVersion1_21_0, img.Linux)
 	if err != nil {
-		return ImageTargetsAndSources{}, fmt.Errorf("%s: %w", "could not get external images for RKE2", err)
-
+		return kdm.Data{}, KDMSnapshot{}, fmt.Errorf("could not read data.json: %w", err)
 	}
-	if rke2LinuxImages != nil {
-		externalLinuxImages["rke2All"] = rke2LinuxImages
+	data, err := kdm.FromData(b)
+	if err != nil {
+		return kdm.Data{}, KDMSnapshot{}, fmt.Errorf("could not load KDM data: %w", err)
 	}
+	return data, KDMSnapshot{Source: source, Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(b))}, nil
+}
 
-	sort.Strings(imagesFromArgs)
-	winsIndex := sort.SearchStrings(imagesFromArgs, "rancher/wins")
-	if winsIndex > len(imagesFromArgs)-1 {
-		return ImageTargetsAndSources{}, fmt.Errorf("rancher/wins upgrade image not found")
+// normalizeRancherVersion replaces development versions with the Rancher dev version and removes the "v" prefix.
+func normalizeRancherVersion(rancherVersion string) string {
+	if !img.IsValidSemver(rancherVersion) || strings.HasPrefix(rancherVersion, "dev") || strings.HasPrefix(rancherVersion, "master") || strings.HasSuffix(rancherVersion, "-head") {
+		rancherVersion = settings.RancherVersionDev
 	}
+	return strings.TrimPrefix(rancherVersion, "v")
+}
 
-	winsAgentUpdateImage := imagesFromArgs[winsIndex]
-	linuxImagesFromArgs := append(imagesFromArgs[:winsIndex], imagesFromArgs[winsIndex+1:]...)
-
-	exportConfig := img.ExportConfig{
-		SystemChartsPath: systemChartsPath,
-		ChartsPath:       chartsPath,
-		OsType:           img.Linux,
-		RancherVersion:   rancherVersion,
-	}
-	targetImages, targetImagesAndSources, err := img.GetImages(exportConfig, externalLinuxImages, linuxImagesFromArgs, linuxInfo.RKESystemImages)
-	if err != nil {
-		return ImageTargetsAndSources{}, err
+// LoadScript produces executable files for Linux and Windows
+// which will load all images used by Rancher into a given image repository.
+// The Linux script is generated from imageList, the Windows images of
+// imageList are pushed along with the Linux ones as multi-arch manifests.
+func LoadScript(arch string, imageList img.ImageList) error {
+	if arch == img.Linux.String() {
+		return generateScript(getScriptFilename(arch, "load"), imageList, img.WriteLoadScript, img.DockerRuntime)
 	}
+	return writeScript(getScriptFilename(arch, "load"), getScript(arch, "load"))
+}
 
-	exportConfig.OsType = img.Windows
-	targetWindowsImages, targetWindowsImagesAndSources, err := img.GetImages(exportConfig, nil, []string{getWindowsAgentImage(), winsAgentUpdateImage}, windowsInfo.RKESystemImages)
-	if err != nil {
-		return ImageTargetsAndSources{}, err
+// SaveScript produces executable files for Linux and Windows
+// which will save all the images used by Rancher using the command
+// `docker save`. The Linux script is generated from imageList.
+func SaveScript(arch string, imageList img.ImageList) error {
+	if arch == img.Linux.String() {
+		return generateScript(getScriptFilename(arch, "save"), imageList, img.WriteSaveScript, img.DockerRuntime)
 	}
+	return writeScript(getScriptFilename(arch, "save"), getScript(arch, "save"))
+}
 
-	return ImageTargetsAndSources{
-		LinuxImagesFromArgs:           linuxImagesFromArgs,
-		TargetLinuxImages:             targetImages,
-		TargetLinuxImagesAndSources:   targetImagesAndSources,
-		TargetWindowsImages:           targetWindowsImages,
-		TargetWindowsImagesAndSources: targetWindowsImagesAndSources,
-	}, nil
+// ContainerdScripts produces the executable files which save the Linux
+// images of imageList and load them into a given image repository with
+// containerd instead of docker.
+func ContainerdScripts(imageList img.ImageList) error {
+	if err := generateScript(getScriptFilename("linux", "containerd-save"), imageList, img.WriteSaveScript, img.ContainerdRuntime); err != nil {
+		return err
+	}
+	return generateScript(getScriptFilename("linux", "containerd-load"), imageList, img.WriteLoadScript, img.ContainerdRuntime)
 }
 
-// LoadScript produces executable files for Linux and Windows
-// which will load all images used by Rancher into a given image repository.
-func LoadScript(arch string, targetImages []string) error {
-	loadScriptName := getScriptFilename(arch, "load")
-	log.Printf("Creating %s\n", loadScriptName)
-	load, err := os.Create(loadScriptName)
+func generateScript(filename string, imageList img.ImageList, generate func(io.Writer, img.ImageList, img.ScriptRuntime) error, runtime img.ScriptRuntime) error {
+	log.Printf("Creating %s\n", filename)
+	script, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-	defer load.Close()
-	load.Chmod(0755)
+	defer script.Close()
+	script.Chmod(0755)
 
-	fmt.Fprintf(load, getScript(arch, "load"))
-	return nil
+	return generate(script, imageList, runtime)
 }
 
-// SaveScript produces executable files for Linux and Windows
-// which will save all the images used by Rancher using the command
-// `docker save`
-func SaveScript(arch string, targetImages []string) error {
-	filename := getScriptFilename(arch, "save")
+func writeScript(filename, content string) error {
 	log.Printf("Creating %s\n", filename)
-	save, err := os.Create(filename)
+	script, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-	defer save.Close()
-	save.Chmod(0755)
-
-	fmt.Fprintf(save, getScript(arch, "save"))
+	defer script.Close()
+	script.Chmod(0755)
 
+	fmt.Fprintf(script, content)
 	return nil
 }
 
 // ImagesText will produce a file containing all the images
-// used by Rancher for a particular arch.
-func ImagesText(arch string, targetImages []string) error {
-	filename := filenameMap[arch]
+// used by Rancher for a particular arch. The images are exported
+// with the names of mirrorMode, see img.MirrorMode.
+func ImagesText(arch string, targetImages []string, mirrorMode img.MirrorMode) error {
+	filename := osFilename(filenameMap, arch, "")
 	log.Printf("Creating %s\n", filename)
 	save, err := os.Create(filename)
 	if err != nil {
@@ -208,7 +502,7 @@ func ImagesText(arch string, targetImages []string) error {
 	save.Chmod(0755)
 
 	for _, image := range saveImages(targetImages) {
-		err := checkImage(image)
+		err := checkImage(image, mirrorMode.KeepsUpstreamNames())
 		if err != nil {
 			return err
 		}
@@ -219,9 +513,9 @@ func ImagesText(arch string, targetImages []string) error {
 }
 
 // ImagesAndSourcesText writes data of the format "image source1,..." to the filename
-// designated for the given arch
-func ImagesAndSourcesText(arch string, targetImagesAndSources []string) error {
-	filename := sourcesFilenameMap[arch]
+// designated for the given arch, see ImagesText for mirrorMode
+func ImagesAndSourcesText(arch string, targetImagesAndSources []string, mirrorMode img.MirrorMode) error {
+	filename := osFilename(sourcesFilenameMap, arch, "-sources")
 	log.Printf("Creating %s\n", filename)
 	save, err := os.Create(filename)
 	if err != nil {
@@ -231,7 +525,7 @@ func ImagesAndSourcesText(arch string, targetImagesAndSources []string) error {
 	save.Chmod(0755)
 
 	for _, imageAndSources := range saveImagesAndSources(targetImagesAndSources) {
-		if err := checkImage(strings.Split(imageAndSources, " ")[0]); err != nil {
+		if err := checkImage(strings.Split(imageAndSources, " ")[0], mirrorMode.KeepsUpstreamNames()); err != nil {
 			return err
 		}
 		fmt.Fprintln(save, imageAndSources)
@@ -240,6 +534,95 @@ func ImagesAndSourcesText(arch string, targetImagesAndSources []string) error {
 	return nil
 }
 
+// ExcludedImagesText writes the images that were excluded from the image list of the given arch, in the
+// "image source1,..." format, to the filename designated for excluded images of that arch.
+func ExcludedImagesText(arch string, excludedImagesAndSources []string) error {
+	filename := osFilename(excludedFilenameMap, arch, "-excluded")
+	log.Printf("Creating %s\n", filename)
+	save, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer save.Close()
+
+	for _, imageAndSources := range excludedImagesAndSources {
+		fmt.Fprintln(save, imageAndSources)
+	}
+
+	return nil
+}
+
+// RequiredImagesText writes the images of the given arch that are required to run Rancher and provision clusters,
+// one per line, to the filename designated for required images of that arch.
+func RequiredImagesText(arch string, requiredImages []string) error {
+	filename := osFilename(requiredFilenameMap, arch, "-required")
+	log.Printf("Creating %s\n", filename)
+	save, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer save.Close()
+
+	for _, image := range requiredImages {
+		fmt.Fprintln(save, image)
+	}
+
+	return nil
+}
+
+// NonCompliantImagesText writes the images of the given arch that have no compliant variant, in the
+// "image source1,..." format, to the filename designated for non-compliant images of that arch.
+func NonCompliantImagesText(arch string, nonCompliantImagesAndSources []string) error {
+	filename := osFilename(nonCompliantFilenameMap, arch, "-noncompliant")
+	log.Printf("Creating %s\n", filename)
+	save, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer save.Close()
+
+	for _, imageAndSources := range nonCompliantImagesAndSources {
+		fmt.Fprintln(save, imageAndSources)
+	}
+
+	return nil
+}
+
+// MissingImagesText writes the images of the given arch that are missing from a mirror, one per line, to the
+// filename designated for missing images of that arch.
+func MissingImagesText(arch string, missingImages []string) error {
+	filename := osFilename(missingFilenameMap, arch, "-missing")
+	log.Printf("Creating %s\n", filename)
+	save, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer save.Close()
+
+	for _, image := range missingImages {
+		fmt.Fprintln(save, image)
+	}
+
+	return nil
+}
+
+// ObsoleteImagesText writes the images held by a mirror that are no longer required, one per line, to
+// rancher-images-obsolete.txt.
+func ObsoleteImagesText(obsoleteImages []string) error {
+	log.Printf("Creating %s\n", obsoleteFilename)
+	save, err := os.Create(obsoleteFilename)
+	if err != nil {
+		return err
+	}
+	defer save.Close()
+
+	for _, image := range obsoleteImages {
+		fmt.Fprintln(save, image)
+	}
+
+	return nil
+}
+
 // MirrorScript creates executable files for Linux and Windows
 // which will perform `docker pull`'s for each image used by Rancher
 func MirrorScript(arch string, targetImages []string) error {
@@ -257,6 +640,8 @@ func MirrorScript(arch string, targetImages []string) error {
 
 	var saveImages []string
 	for _, targetImage := range targetImages {
+		// A tag cannot be created with the digest of pinned images
+		targetImage = unpinnedImage(targetImage)
 		srcImage, ok := image.Mirrors[targetImage]
 		if !ok {
 			continue
@@ -276,7 +661,7 @@ func MirrorScript(arch string, targetImages []string) error {
 func saveImages(targetImages []string) []string {
 	var saveImages []string
 	for _, targetImage := range targetImages {
-		_, ok := image.Mirrors[targetImage]
+		_, ok := image.Mirrors[unpinnedImage(targetImage)]
 		if !ok {
 			continue
 		}
@@ -290,7 +675,7 @@ func saveImagesAndSources(imagesAndSources []string) []string {
 	var saveImagesAndSources []string
 	for _, imageAndSources := range imagesAndSources {
 		targetImage := strings.Split(imageAndSources, " ")[0]
-		_, ok := image.Mirrors[targetImage]
+		_, ok := image.Mirrors[unpinnedImage(targetImage)]
 		if !ok {
 			continue
 		}
@@ -300,19 +685,27 @@ func saveImagesAndSources(imagesAndSources []string) []string {
 	return saveImagesAndSources
 }
 
-func checkImage(image string) error {
+// checkImage returns an error if image is not a tagged Rancher image, possibly pinned to a digest. Images exported under their upstream names,
+// when upstreamNames is set, are not required to be in the rancher namespace.
+func checkImage(image string, upstreamNames bool) error {
 	// ignore non prefixed images, also in types (image/mirror.go)
 	if strings.HasPrefix(image, "weaveworks") || strings.HasPrefix(image, "noiro") {
 		return nil
 	}
-	imageNameTag := strings.Split(image, ":")
+	// The registry of the images mapped with a registry mapping, e.g. registry.rancher.com/rancher/shell, is not part
+	// of their name
+	name := unpinnedImage(image)
+	if registry, path, ok := strings.Cut(name, "/"); ok && isRegistryDomain(registry) {
+		name = path
+	}
+	imageNameTag := strings.Split(name, ":")
 	if len(imageNameTag) != 2 {
 		return fmt.Errorf("Can't extract tag from image [%s]", image)
 	}
 	if imageNameTag[1] == "" {
 		return fmt.Errorf("Extracted tag from image [%s] is empty", image)
 	}
-	if !strings.HasPrefix(imageNameTag[0], "rancher/") {
+	if !upstreamNames && !strings.HasPrefix(imageNameTag[0], "rancher/") {
 		return fmt.Errorf("Image [%s] does not start with rancher/", image)
 	}
 	if strings.HasSuffix(imageNameTag[0], "-") {
@@ -321,6 +714,21 @@ func checkImage(image string) error {
 	return nil
 }
 
+// unpinnedImage returns image without the digest it is pinned to, if any, e.g. rancher/shell:v0.1.22 for
+// rancher/shell:v0.1.22@sha256:...
+func unpinnedImage(image string) string {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[:i]
+	}
+	return image
+}
+
+// isRegistryDomain returns true if the first component of an image is a registry rather than a namespace, like
+// reference.ParseNormalizedNamed does.
+func isRegistryDomain(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
 func writeSliceToFile(filename string, versions []string) error {
 	log.Printf("Creating %s\n", filename)
 	save, err := os.Create(filename)
@@ -358,231 +766,6 @@ func getScriptFilename(arch, fileType string) string {
 }
 
 const (
-	linuxLoadScript = `#!/bin/bash
-images="rancher-images.tar.gz"
-list="rancher-images.txt"
-windows_image_list=""
-windows_versions="1809"
-source_registry=""
-usage () {
-    echo "USAGE: $0 [--images rancher-images.tar.gz] [--source-registry index.docker.io] --registry my.registry.com:5000"
-    echo "  [-l|--image-list path] text file with list of images; one image per line."
-    echo "  [-i|--images path] tar.gz generated by docker save."
-    echo "  [-r|--registry registry:port] target private registry in the registry:port format."
-    echo "  [-s|--source-registry registry:port] source registry in the registry:port format."
-    echo "  [--windows-image-list path] text file with list of images used in Windows. Windows image mirroring is skipped when this is empty."
-    echo "  [--windows-versions version] Comma separated Windows versions. e.g., \"1809,ltsc2022\". (Default \"1809\")"
-    echo "  [-h|--help] Usage message"
-}
-
-push_manifest () {
-    export DOCKER_CLI_EXPERIMENTAL=enabled
-    manifest_list=()
-    for i in "${arch_list[@]}"
-    do
-        manifest_list+=("$1-${i}")
-    done
-
-    echo "Preparing manifest $1, list[${arch_list[@]}]"
-    docker manifest create "$1" "${manifest_list[@]}" --amend
-    docker manifest push "$1" --purge
-}
-
-while [[ $# -gt 0 ]]; do
-    key="$1"
-    case $key in
-        -r|--registry)
-        target_registry="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        -s|--source-registry)
-        source_registry="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        -l|--image-list)
-        list="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        -i|--images)
-        images="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        --windows-image-list)
-        windows_image_list="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        --windows-versions)
-        windows_versions="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        -h|--help)
-        help="true"
-        shift
-        ;;
-        *)
-        usage
-        exit 1
-        ;;
-    esac
-done
-if [[ -z "${target_registry}" ]]; then
-    usage
-    exit 1
-fi
-if [[ $help ]]; then
-    usage
-    exit 0
-fi
-
-target_registry="${target_registry%/}/"
-source_registry="${source_registry%/}"
-if [ ! -z "${source_registry}" ]; then
-    source_registry="${source_registry}/"
-fi
-
-docker load --input ${images}
-
-linux_images=()
-while IFS= read -r i; do
-    [ -z "${i}" ] && continue
-    linux_images+=("${i}");
-done < "${list}"
-
-arch_list=()
-if [[ -n "${windows_image_list}" ]]; then
-    IFS=',' read -r -a versions <<< "$windows_versions"
-    for version in "${versions[@]}"
-    do
-        arch_list+=("windows-${version}")
-    done
-
-    windows_images=()
-    while IFS= read -r i; do
-        [ -z "${i}" ] && continue
-        windows_images+=("${i}")
-    done < "${windows_image_list}"
-
-    # use manifest to publish images only used in Windows
-    for i in "${windows_images[@]}"; do
-        if [[ ! " ${linux_images[@]}" =~ " ${i}" ]]; then
-            case $i in
-            */*)
-                image_name="${target_registry}${i}"
-                ;;
-            *)
-                image_name="${target_registry}rancher/${i}"
-                ;;
-            esac
-            push_manifest "${image_name}"
-        fi
-    done
-fi
-
-arch_list+=("linux-amd64")
-for i in "${linux_images[@]}"; do
-    [ -z "${i}" ] && continue
-    arch_suffix=""
-    use_manifest=false
-    if [[ (-n "${windows_image_list}") && " ${windows_images[@]}" =~ " ${i}" ]]; then
-        # use manifest to publish images when it is used both in Linux and Windows
-        use_manifest=true
-        arch_suffix="-linux-amd64"
-    fi
-    case $i in
-    */*)
-        image_name="${target_registry}${i}"
-        ;;
-    *)
-        image_name="${target_registry}rancher/${i}"
-        ;;
-    esac
-
-    docker tag "${source_registry}${i}" "${image_name}${arch_suffix}"
-    docker push "${image_name}${arch_suffix}"
-
-    if $use_manifest; then
-        push_manifest "${image_name}"
-    fi
-done
-`
-	linuxSaveScript = `#!/bin/bash
-list="rancher-images.txt"
-images="rancher-images.tar.gz"
-source_registry=""
-
-usage () {
-    echo "USAGE: $0 [--image-list rancher-images.txt] [--images rancher-images.tar.gz]"
-    echo "  [-s|--source-registry] source registry to pull images from in registry:port format."
-    echo "  [-l|--image-list path] text file with list of images; one image per line."
-    echo "  [-i|--images path] tar.gz generated by docker save."
-    echo "  [-h|--help] Usage message"
-}
-
-POSITIONAL=()
-while [[ $# -gt 0 ]]; do
-    key="$1"
-    case $key in
-        -i|--images)
-        images="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        -l|--image-list)
-        list="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        -s|--source-registry)
-        source_registry="$2"
-        shift # past argument
-        shift # past value
-        ;;
-        -h|--help)
-        help="true"
-        shift
-        ;;
-        *)
-        usage
-        exit 1
-        ;;
-    esac
-done
-
-if [[ $help ]]; then
-    usage
-    exit 0
-fi
-
-source_registry="${source_registry%/}"
-if [ ! -z "${source_registry}" ]; then
-    source_registry="${source_registry}/"
-fi
-
-pulled=""
-while IFS= read -r i; do
-    [ -z "${i}" ] && continue
-    i="${source_registry}${i}"
-    if docker pull "${i}" > /dev/null 2>&1; then
-        echo "Image pull success: ${i}"
-        pulled="${pulled} ${i}"
-    else
-        if docker inspect "${i}" > /dev/null 2>&1; then
-            pulled="${pulled} ${i}"
-        else
-            echo "Image pull failed: ${i}"
-        fi
-    fi
-done < "${list}"
-
-echo "Creating ${images} with $(echo ${pulled} | wc -w | tr -d '[:space:]') images"
-docker save $(echo ${pulled}) | gzip --stdout > ${images}
-`
 	linuxMirrorScript = "#!/bin/sh\nset -e -x\n\n"
 	windowsLoadScript = `$ErrorActionPreference = 'Stop'
 
@@ -1,23 +1,41 @@
 package utilities
 
 import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	kd "github.com/rancher/rancher/pkg/controllers/management/kontainerdrivermetadata"
+	img "github.com/rancher/rancher/pkg/image"
+	"github.com/rancher/rancher/pkg/image/internal/imagetest"
+	"github.com/rancher/rancher/pkg/settings"
 )
 
 func TestCheckImage(t *testing.T) {
 	imageListAndErrorExpectations := map[string]bool{
-		"weaveworks/npc:latest":         false,
-		"noiro/test:latest":             false,
-		"registry.suse.com/test:latest": true,
-		"rancher/aks-operator:latest":   false,
-		"google/gke-operator:latest":    true, // not from 'rancher/' or whitelisted
-		"rancher/gke-operator-:latest":  true, // trailing '-' in image name
-		"rancher/test":                  true, // missing tag
-		"rancher/test:":                 true, // empty tag
+		"weaveworks/npc:latest":                        false,
+		"noiro/test:latest":                            false,
+		"registry.suse.com/test:latest":                true,
+		"rancher/aks-operator:latest":                  false,
+		"google/gke-operator:latest":                   true,  // not from 'rancher/' or whitelisted
+		"rancher/gke-operator-:latest":                 true,  // trailing '-' in image name
+		"rancher/test":                                 true,  // missing tag
+		"rancher/test:":                                true,  // empty tag
+		"registry.rancher.com/rancher/shell:v0.1.22":   false, // mapped with a registry mapping
+		"registry.example.com:5000/rancher/shell:v0.1": false,
+		"registry.example.com/google/gke-operator:v1":  true,
+		"rancher/shell:v0.1@sha256:0123456789abcdef":   false, // pinned to its digest
+		"rancher/shell@sha256:0123456789abcdef":        true,
 	}
 
 	for k, v := range imageListAndErrorExpectations {
-		err := checkImage(k)
+		err := checkImage(k, false)
 		if err != nil && !v {
 			t.Logf("did not expect error when checking image %s", k)
 			t.Fail()
@@ -28,3 +46,315 @@ func TestCheckImage(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeRancherVersion(t *testing.T) {
+	versions := map[string]string{
+		"v2.7.1":        "2.7.1",
+		"2.6.9":         "2.6.9",
+		"dev":           settings.RancherVersionDev,
+		"master-head":   settings.RancherVersionDev,
+		"v2.7.5-head":   settings.RancherVersionDev,
+		"not-a-version": settings.RancherVersionDev,
+	}
+
+	for version, expected := range versions {
+		if actual := normalizeRancherVersion(version); actual != expected {
+			t.Errorf("expected version %s to be normalized to %s, got %s", version, expected, actual)
+		}
+	}
+}
+
+func TestLoadKDMData(t *testing.T) {
+	dataJSON := []byte(`{"K8sVersionRKESystemImages":{"v1.27.8-rancher2-2":{"etcd":"rancher/mirrored-coreos-etcd:v3.5.9"}}}`)
+	path := filepath.Join(t.TempDir(), "data.json")
+	if err := os.WriteFile(path, dataJSON, 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/data.json" {
+			http.NotFound(rw, req)
+			return
+		}
+		rw.Write(dataJSON)
+	}))
+	defer server.Close()
+
+	for _, source := range []string{path, server.URL + "/data.json"} {
+		data, err := LoadKDMData(source, nil)
+		if err != nil {
+			t.Fatalf("could not load KDM data from %s: %v", source, err)
+		}
+		if etcd := data.K8sVersionRKESystemImages["v1.27.8-rancher2-2"].Etcd; etcd != "rancher/mirrored-coreos-etcd:v3.5.9" {
+			t.Errorf("expected the etcd image of the KDM data of %s, got %q", source, etcd)
+		}
+	}
+	if _, err := LoadKDMData(server.URL+"/missing.json", nil); err == nil {
+		t.Error("expected an error loading missing KDM data")
+	}
+	data, err := LoadKDMData(img.EmbeddedKDMSource, nil)
+	if err != nil {
+		t.Fatalf("could not load the embedded KDM data: %v", err)
+	}
+	if len(data.K8sVersionRKESystemImages) == 0 {
+		t.Error("expected the embedded KDM data to have RKE system images")
+	}
+
+	_, snapshot, err := loadKDMData(context.Background(), path, nil)
+	if err != nil {
+		t.Fatalf("could not load KDM data from %s: %v", path, err)
+	}
+	if expected := (KDMSnapshot{Source: path, Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(dataJSON))}); snapshot != expected {
+		t.Errorf("expected the KDM snapshot %+v, got %+v", expected, snapshot)
+	}
+}
+
+// gatherTestImages gathers the core images of a KDM data.json file with RKE system images only, with options, for Linux
+// unless options has OS types, and switches to a temporary directory the image lists can be written to.
+func gatherTestImages(t *testing.T, options GatherOptions) ImageTargetsAndSources {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	if err := os.Mkdir(filepath.Join(dir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dataJSON := []byte(`{"K8sVersionRKESystemImages":{"v1.27.8-rancher2-2":{"etcd":"rancher/mirrored-coreos-etcd:v3.5.9","coredns":"coredns/coredns:1.10.1"}}}`)
+	options.KDMDataSource = filepath.Join(dir, "data.json")
+	if err := os.WriteFile(options.KDMDataSource, dataJSON, 0644); err != nil {
+		t.Fatal(err)
+	}
+	options.SystemChartsPath = filepath.Join(dir, "system-charts")
+	options.ChartsPath = filepath.Join(dir, "charts")
+	for _, path := range []string{options.SystemChartsPath, options.ChartsPath} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(options.ChartsPath, "index.yaml"), []byte("apiVersion: v1\nentries: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options.RancherVersions = []string{"v2.7.99"}
+	options.ImagesFromArgs = []string{"rancher/rancher:v2.7.99", "rancher/wins:v0.4.11"}
+	if len(options.OSTypes) == 0 {
+		options.OSTypes = []img.OSType{img.Linux}
+	}
+	// The UI extensions are fetched from GitHub
+	options.CoreOnly = true
+
+	targetsAndSources, err := GatherTargetImages(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return targetsAndSources
+}
+
+func TestImagesTextRegistryMapping(t *testing.T) {
+	targetsAndSources := gatherTestImages(t, GatherOptions{RegistryMapping: img.PrimeRegistryMapping})
+
+	if err := ImagesText("linux", targetsAndSources.TargetLinuxImages, targetsAndSources.MirrorMode); err != nil {
+		t.Fatal(err)
+	}
+	images := imagetest.ReadLines(t, "rancher-images.txt")
+	if len(images) == 0 {
+		t.Fatalf("expected the mapped images to be written to rancher-images.txt, got %v", targetsAndSources.TargetLinuxImages)
+	}
+	for _, image := range images {
+		if !strings.HasPrefix(image, "registry.rancher.com/rancher/") {
+			t.Errorf("expected %s to be mapped to registry.rancher.com", image)
+		}
+	}
+
+	if err := ImagesAndSourcesText("linux", targetsAndSources.TargetLinuxImagesAndSources, targetsAndSources.MirrorMode); err != nil {
+		t.Fatal(err)
+	}
+	if lines := imagetest.ReadLines(t, "rancher-images-sources.txt"); len(lines) == 0 {
+		t.Error("expected the mapped images to be written to rancher-images-sources.txt")
+	}
+}
+
+func TestImagesTextMirrorModes(t *testing.T) {
+	for _, test := range []struct {
+		mode     img.MirrorMode
+		expected []string
+	}{
+		{mode: img.MirrorModeMirrored, expected: []string{"rancher/calico-node:v3.1"}},
+		{mode: img.MirrorModeUpstream, expected: []string{"quay.io/calico/node:v3.1"}},
+		{mode: img.MirrorModeBoth, expected: []string{"quay.io/calico/node:v3.1", "rancher/calico-node:v3.1"}},
+	} {
+		t.Run(string(test.mode), func(t *testing.T) {
+			extraImages := filepath.Join(t.TempDir(), "extra-images.txt")
+			if err := os.WriteFile(extraImages, []byte("quay.io/calico/node:v3.1\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			targetsAndSources := gatherTestImages(t, GatherOptions{MirrorMode: test.mode, ExtraImagesFiles: []string{extraImages}})
+
+			if err := ImagesText("linux", targetsAndSources.TargetLinuxImages, targetsAndSources.MirrorMode); err != nil {
+				t.Fatal(err)
+			}
+			if err := ImagesAndSourcesText("linux", targetsAndSources.TargetLinuxImagesAndSources, targetsAndSources.MirrorMode); err != nil {
+				t.Fatal(err)
+			}
+			images := imagetest.ReadLines(t, "rancher-images.txt")
+			sources := strings.Join(imagetest.ReadLines(t, "rancher-images-sources.txt"), "\n")
+			for _, image := range test.expected {
+				if !containsString(images, image) {
+					t.Errorf("expected %s in rancher-images.txt, got %v", image, images)
+				}
+				if !strings.Contains(sources, image) {
+					t.Errorf("expected %s in rancher-images-sources.txt, got %v", image, sources)
+				}
+			}
+		})
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestImagesTextPinnedDigests(t *testing.T) {
+	targetsAndSources := gatherTestImages(t, GatherOptions{})
+	list := append(img.ImageList{}, targetsAndSources.LinuxImageList...)
+	for i := range list {
+		list[i].Digest = "sha256:0123456789abcdef"
+	}
+	pinned, err := list.PinDigests()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ImagesText("linux", pinned.Images(), targetsAndSources.MirrorMode); err != nil {
+		t.Fatal(err)
+	}
+	if images := imagetest.ReadLines(t, "rancher-images.txt"); len(images) != len(pinned) {
+		t.Errorf("expected the %d pinned images in rancher-images.txt, got %v", len(pinned), images)
+	}
+	if err := ImagesAndSourcesText("linux", pinned.ImagesAndSources(), targetsAndSources.MirrorMode); err != nil {
+		t.Fatal(err)
+	}
+	if lines := imagetest.ReadLines(t, "rancher-images-sources.txt"); len(lines) == 0 {
+		t.Error("expected the pinned images in rancher-images-sources.txt")
+	}
+}
+
+func TestGatherTargetImagesRegisteredOSType(t *testing.T) {
+	// OS types cannot be unregistered, the other tests gather the images of Linux only
+	freeBSD := img.OSType("freebsd")
+	img.RegisterOSType(freeBSD, img.OSTypeConfig{})
+	extraImages := filepath.Join(t.TempDir(), "extra-images.yaml")
+	if err := os.WriteFile(extraImages, []byte("images:\n- image: rancher/freebsd-tools:v1.0.0\n  os: freebsd\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targetsAndSources := gatherTestImages(t, GatherOptions{
+		OSTypes:          []img.OSType{img.Linux, freeBSD},
+		ExtraImagesFiles: []string{extraImages},
+	})
+
+	if images := targetsAndSources.ImageList(freeBSD).Images(); len(images) != 1 || images[0] != "rancher/freebsd-tools:v1.0.0" {
+		t.Errorf("expected the freebsd images to be rancher/freebsd-tools:v1.0.0, got %v", images)
+	}
+	if containsString(targetsAndSources.TargetLinuxImages, "rancher/freebsd-tools:v1.0.0") {
+		t.Error("expected the freebsd images not to be in the Linux images")
+	}
+	if len(targetsAndSources.ImageList(img.Linux)) == 0 || len(targetsAndSources.ImageList(img.Linux)) != len(targetsAndSources.TargetLinuxImages) {
+		t.Errorf("expected the Linux image list to match the Linux target images, got %v", targetsAndSources.ImageList(img.Linux).Images())
+	}
+
+	if err := ImagesText(freeBSD.String(), targetsAndSources.ImageList(freeBSD).Images(), targetsAndSources.MirrorMode); err != nil {
+		t.Fatal(err)
+	}
+	if images := imagetest.ReadLines(t, "rancher-freebsd-images.txt"); len(images) != 1 {
+		t.Errorf("expected the freebsd images in rancher-freebsd-images.txt, got %v", images)
+	}
+}
+
+func TestGatherImageListWindowsCNI(t *testing.T) {
+	data, err := LoadKDMData(img.EmbeddedKDMSource, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rancherVersion := normalizeRancherVersion("v2.7.99")
+	_, windowsInfo := kd.GetK8sVersionInfo(rancherVersion, data.K8sVersionRKESystemImages, data.K8sVersionServiceOptions, data.K8sVersionWindowsServiceOptions, data.K8sVersionInfo)
+	if len(windowsInfo.RKESystemImages) == 0 {
+		t.Fatal("expected the embedded KDM data to have Windows RKE system images")
+	}
+
+	// The flannel and canal daemon sets of KDM are not scheduled on Windows nodes, whose flannel binaries ship in the
+	// Kubernetes services sidecar image instead
+	result, _, err := gatherImageList(context.Background(), img.ExportConfig{RancherVersion: rancherVersion, CoreOnly: true, OSTypes: []img.OSType{img.Windows}, Distributions: []img.Distribution{img.DistributionRKE}}, data, nil, "rancher/wins:v0.4.11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	windowsImages := result.Images.ForOS(img.Windows).Images()
+	for k8sVersion, systemImages := range windowsInfo.RKESystemImages {
+		if !containsString(windowsImages, systemImages.KubernetesServicesSidecar) {
+			t.Errorf("expected the Kubernetes services sidecar image %s of %s in the Windows images", systemImages.KubernetesServicesSidecar, k8sVersion)
+		}
+	}
+	for _, template := range []string{"flannel", "canal"} {
+		for _, templateKey := range data.K8sVersionedTemplates[template] {
+			if addon := data.K8sVersionedTemplates["templateKeys"][templateKey]; strings.Contains(addon, "beta.kubernetes.io/os") && !strings.Contains(addon, "- windows") {
+				t.Errorf("expected the %s template %s not to be scheduled on Windows nodes", template, templateKey)
+			}
+		}
+	}
+}
+
+func TestGatherImageListK8sPatches(t *testing.T) {
+	data, err := LoadKDMData(img.EmbeddedKDMSource, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gather := func(k8sPatches img.K8sPatchMode) ([]string, []string) {
+		exportConfig := img.ExportConfig{
+			RancherVersion: normalizeRancherVersion("v2.7.99"),
+			CoreOnly:       true,
+			OSTypes:        []img.OSType{img.Linux},
+			Distributions:  []img.Distribution{img.DistributionRKE},
+			K8sPatches:     k8sPatches,
+		}
+		result, k8sVersions, err := gatherImageList(context.Background(), exportConfig, data, nil, "rancher/wins:v0.4.11")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k8sVersions, result.Images.ForOS(img.Linux).Images()
+	}
+	latestVersions, latestImages := gather(img.K8sPatchesLatest)
+	allVersions, allImages := gather(img.K8sPatchesAll)
+
+	// The latest patch versions are one per minor version, and are exported in both modes
+	minors := make(map[string]bool)
+	for _, k8sVersion := range latestVersions {
+		minor := strings.Join(strings.SplitN(k8sVersion, ".", 3)[:2], ".")
+		if minors[minor] {
+			t.Errorf("expected a single latest patch version of %s, got %v", minor, latestVersions)
+		}
+		minors[minor] = true
+		if !containsString(allVersions, k8sVersion) {
+			t.Errorf("expected %s in the patch versions of every patch mode, got %v", k8sVersion, allVersions)
+		}
+	}
+	if len(allVersions) <= len(latestVersions) {
+		t.Errorf("expected more patch versions than %v, got %v", latestVersions, allVersions)
+	}
+	for _, image := range latestImages {
+		if !containsString(allImages, image) {
+			t.Errorf("expected %s in the images of every patch version", image)
+		}
+	}
+	if len(allImages) <= len(latestImages) {
+		t.Errorf("expected the older patch versions to add images to the %d images of the latest ones, got %d", len(latestImages), len(allImages))
+	}
+}
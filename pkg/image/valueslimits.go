@@ -0,0 +1,97 @@
+package image
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultMaxValuesSize is the default maximum size of a values file, in bytes. The largest values files of the
+	// Rancher charts are a few hundred kilobytes.
+	DefaultMaxValuesSize int64 = 16 << 20
+	// DefaultMaxValuesDepth is the default maximum nesting depth of a values file.
+	DefaultMaxValuesDepth = 100
+)
+
+// ValuesLimits limits the values files decoded while scanning charts, so that enormous or pathological values files
+// fail the chart instead of slowing down the export. The charts whose values files exceed the limits are reported as
+// chart errors. MaxSize is enforced while the file is read, whereas MaxDepth is a sanity check of the decoded values,
+// which bounds the walks of the values looking for images: the YAML decoder itself rejects the alias bombs and the
+// files nested deeper than 10000 levels while decoding them.
+type ValuesLimits struct {
+	// MaxSize is the maximum size of a values file, in bytes, DefaultMaxValuesSize if zero.
+	MaxSize int64 `yaml:"maxSize"`
+	// MaxDepth is the maximum nesting depth of the maps and lists of a values file, checked once it is decoded,
+	// DefaultMaxValuesDepth if zero.
+	MaxDepth int `yaml:"maxDepth"`
+}
+
+// withDefaults returns the limits with their unset fields set to their default.
+func (l ValuesLimits) withDefaults() ValuesLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultMaxValuesSize
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultMaxValuesDepth
+	}
+	return l
+}
+
+type valuesLimitsContextKey struct{}
+
+// withValuesLimits returns a context carrying the limits of the values files decoded with it.
+func withValuesLimits(ctx context.Context, limits ValuesLimits) context.Context {
+	return context.WithValue(ctx, valuesLimitsContextKey{}, limits.withDefaults())
+}
+
+// valuesLimitsFromContext returns the values limits of ctx, or the default limits if it has none.
+func valuesLimitsFromContext(ctx context.Context) ValuesLimits {
+	limits, _ := ctx.Value(valuesLimitsContextKey{}).(ValuesLimits)
+	return limits.withDefaults()
+}
+
+// decodeValues decodes the values file read from r, streaming it through the YAML decoder. It fails if the file is
+// larger than limits.MaxSize, which stops the decoding, or if the decoded values are nested deeper than
+// limits.MaxDepth. An empty file decodes to nil values.
+func decodeValues(r io.Reader, limits ValuesLimits) (map[interface{}]interface{}, error) {
+	limits = limits.withDefaults()
+	// Read one more byte than allowed to tell files of exactly MaxSize bytes from larger ones
+	limited := &io.LimitedReader{R: r, N: limits.MaxSize + 1}
+	var values map[interface{}]interface{}
+	err := yaml.NewDecoder(limited).Decode(&values)
+	if limited.N <= 0 {
+		return nil, errors.Errorf("values file is larger than %d bytes", limits.MaxSize)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if valuesDepthExceeds(values, limits.MaxDepth) {
+		return nil, errors.Errorf("values file is nested deeper than %d levels", limits.MaxDepth)
+	}
+	return values, nil
+}
+
+// valuesDepthExceeds returns whether the maps and lists of value are nested deeper than maxDepth levels.
+func valuesDepthExceeds(value interface{}, maxDepth int) bool {
+	if maxDepth < 0 {
+		return true
+	}
+	switch data := value.(type) {
+	case map[interface{}]interface{}:
+		for _, elem := range data {
+			if valuesDepthExceeds(elem, maxDepth-1) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, elem := range data {
+			if valuesDepthExceeds(elem, maxDepth-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
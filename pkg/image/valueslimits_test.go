@@ -0,0 +1,31 @@
+package image
+
+import (
+	"strings"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestDecodeValues(t *testing.T) {
+	assert := assertlib.New(t)
+
+	valuesFile := "image:\n  repository: rancher/shell\n  tag: v0.1.22\n"
+	values, err := decodeValues(strings.NewReader(valuesFile), ValuesLimits{})
+	assert.NoError(err)
+	assert.Equal(map[interface{}]interface{}{"image": map[interface{}]interface{}{"repository": "rancher/shell", "tag": "v0.1.22"}}, values)
+
+	values, err = decodeValues(strings.NewReader(""), ValuesLimits{})
+	assert.NoError(err)
+	assert.Nil(values)
+
+	_, err = decodeValues(strings.NewReader(valuesFile), ValuesLimits{MaxSize: int64(len(valuesFile))})
+	assert.NoError(err)
+	_, err = decodeValues(strings.NewReader(valuesFile), ValuesLimits{MaxSize: int64(len(valuesFile)) - 1})
+	assert.ErrorContains(err, "larger than")
+
+	_, err = decodeValues(strings.NewReader(valuesFile), ValuesLimits{MaxDepth: 2})
+	assert.NoError(err)
+	_, err = decodeValues(strings.NewReader("a:\n  b:\n    - c: d\n"), ValuesLimits{MaxDepth: 2})
+	assert.ErrorContains(err, "deeper than")
+}
@@ -0,0 +1,87 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// imageVariablesNames are the names of the variables of WriteImageVariables holding the images of each OS.
+var imageVariablesNames = map[OSType]string{
+	Linux:   "rancher_images",
+	Windows: "rancher_windows_images",
+}
+
+// ImageComponents returns the images of list keyed by the logical component they provide, e.g. rancher_agent for
+// rancher/rancher-agent:v2.8.0, so that infrastructure as code can reference images by name. A component is named
+// after the last part of the repository of its image, without the mirrored- prefix. The whole repository is used
+// instead when several repositories share that name, and the tag is added when a repository has several tags.
+func ImageComponents(list ImageList) map[string]string {
+	type repository struct {
+		repo string
+		tags map[string]string
+	}
+	repositories := make(map[string]*repository)
+	reposByName := make(map[string]map[string]bool)
+	for _, entry := range list {
+		repo, tag := splitImageTag(entry.Image)
+		r, ok := repositories[repo]
+		if !ok {
+			r = &repository{repo: repo, tags: make(map[string]string)}
+			repositories[repo] = r
+		}
+		r.tags[tag] = entry.Image
+
+		name := componentName(repo[strings.LastIndex(repo, "/")+1:])
+		if reposByName[name] == nil {
+			reposByName[name] = make(map[string]bool)
+		}
+		reposByName[name][repo] = true
+	}
+
+	components := make(map[string]string, len(list))
+	for name, repos := range reposByName {
+		for repo := range repos {
+			component := name
+			if len(repos) > 1 {
+				component = componentName(repo)
+			}
+			tags := repositories[repo].tags
+			for tag, image := range tags {
+				if len(tags) > 1 {
+					components[component+"_"+componentName(tag)] = image
+				} else {
+					components[component] = image
+				}
+			}
+		}
+	}
+	return components
+}
+
+// componentName turns value into a variable name, replacing everything but lowercase letters and digits with
+// underscores.
+func componentName(value string) string {
+	value = strings.TrimPrefix(strings.ToLower(value), "mirrored-")
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+// WriteImageVariables writes the images of each OS of list to w as a JSON variables file, usable as a Terraform
+// .tfvars.json file or an Ansible vars file. The rancher_images and rancher_windows_images variables are maps of
+// logical components to images, see ImageComponents.
+func WriteImageVariables(w io.Writer, list ImageList) error {
+	variables := make(map[string]map[string]string, len(imageVariablesNames))
+	for osType, name := range imageVariablesNames {
+		if osList := list.ForOS(osType); len(osList) > 0 {
+			variables[name] = ImageComponents(osList)
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(variables)
+}
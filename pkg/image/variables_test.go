@@ -0,0 +1,49 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestImageComponents(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/rancher-agent:v2.8.0", OS: Linux},
+		{Image: "rancher/mirrored-coredns-coredns:1.10.1", OS: Linux},
+		{Image: "rancher/rke-tools:v0.1.88", OS: Linux},
+		{Image: "rancher/rke-tools:v0.1.89", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "quay.io/example/shell:v1", OS: Linux},
+	}
+	assert.Equal(map[string]string{
+		"rancher_agent":         "rancher/rancher-agent:v2.8.0",
+		"coredns_coredns":       "rancher/mirrored-coredns-coredns:1.10.1",
+		"rke_tools_v0_1_88":     "rancher/rke-tools:v0.1.88",
+		"rke_tools_v0_1_89":     "rancher/rke-tools:v0.1.89",
+		"rancher_shell":         "rancher/shell:v0.1.22",
+		"quay_io_example_shell": "quay.io/example/shell:v1",
+	}, ImageComponents(list))
+}
+
+func TestWriteImageVariables(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/rancher-agent:v2.8.0", OS: Linux},
+		{Image: "rancher/wins:v0.4.11", OS: Windows},
+	}
+	var buf bytes.Buffer
+	assert.NoError(WriteImageVariables(&buf, list))
+	assert.Equal(`{
+  "rancher_images": {
+    "rancher_agent": "rancher/rancher-agent:v2.8.0"
+  },
+  "rancher_windows_images": {
+    "wins": "rancher/wins:v0.4.11"
+  }
+}
+`, buf.String())
+}
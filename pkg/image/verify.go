@@ -0,0 +1,152 @@
+package image
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"github.com/pkg/errors"
+)
+
+// MirrorStatus is the outcome of the verification of a mirrored image.
+type MirrorStatus string
+
+const (
+	// MirrorVerified images have the same digest upstream and in the mirror.
+	MirrorVerified MirrorStatus = "verified"
+	// MirrorDrifted images have a different digest upstream and in the mirror, because the upstream image was retagged
+	// or the mirrored image is corrupted.
+	MirrorDrifted MirrorStatus = "drifted"
+	// MirrorMissing images are not in the mirror.
+	MirrorMissing MirrorStatus = "missing"
+	// MirrorUnknown images could not be looked up upstream or in the mirror.
+	MirrorUnknown MirrorStatus = "unknown"
+)
+
+// MirrorVerification is the verification of an image of a mirror.
+type MirrorVerification struct {
+	// Image is the upstream image.
+	Image string `json:"image"`
+	// Mirror is the image in the mirror, see TargetImage.
+	Mirror string `json:"mirror"`
+	// UpstreamDigest is the digest of the upstream image, empty if it could not be looked up.
+	UpstreamDigest string `json:"upstreamDigest,omitempty"`
+	// MirrorDigest is the digest of the image in the mirror, empty if it is missing or could not be looked up.
+	MirrorDigest string `json:"mirrorDigest,omitempty"`
+	// Status is the outcome of the verification.
+	Status MirrorStatus `json:"status"`
+	// Error is the error of the lookups of unknown images.
+	Error string `json:"error,omitempty"`
+	// ErrorClass is the class of the error of the first failed lookup of unknown images, see ClassifyError.
+	ErrorClass ErrorClass `json:"errorClass,omitempty"`
+}
+
+// MirrorReport is the verification of the images of an image list mirrored to a private registry, for periodic
+// compliance checks of the mirror.
+type MirrorReport struct {
+	// Registry is the private registry mirroring the images.
+	Registry string `json:"registry"`
+	// VerifiedAt is when the images were verified.
+	VerifiedAt time.Time `json:"verifiedAt"`
+	// Images are the verifications of the images, sorted by image.
+	Images []MirrorVerification `json:"images"`
+}
+
+// Count returns the number of images of the report with status.
+func (r MirrorReport) Count(status MirrorStatus) int {
+	count := 0
+	for _, image := range r.Images {
+		if image.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// VerifyMirror compares the digest of each image of list upstream, i.e. in sourceRegistry if set or in its own
+// registry otherwise, with its digest in the mirror registry, where the images are pushed like the load scripts do.
+// Images exported for several OS types are verified once, and the manifests are not downloaded.
+func (c RegistryClient) VerifyMirror(ctx context.Context, registry, sourceRegistry string, list ImageList) (MirrorReport, error) {
+	if registry == "" {
+		return MirrorReport{}, errors.New("mirror registry is required")
+	}
+	var unique ImageList
+	seen := make(map[string]bool, len(list))
+	for _, entry := range list {
+		if !seen[entry.Image] {
+			seen[entry.Image] = true
+			unique = append(unique, entry)
+		}
+	}
+
+	report := MirrorReport{Registry: registry, VerifiedAt: time.Now().UTC()}
+	var mu sync.Mutex
+	c.forEach(unique, func(entry *ImageEntry) {
+		verification := c.verifyImage(ctx, registry, sourceRegistry, *entry)
+		mu.Lock()
+		defer mu.Unlock()
+		report.Images = append(report.Images, verification)
+	})
+	sort.Slice(report.Images, func(i, j int) bool {
+		return report.Images[i].Image < report.Images[j].Image
+	})
+	return report, nil
+}
+
+// verifyImage compares the digest of the image of entry upstream and in registry.
+func (c RegistryClient) verifyImage(ctx context.Context, registry, sourceRegistry string, entry ImageEntry) MirrorVerification {
+	verification := MirrorVerification{Image: entry.Image, Mirror: TargetImage(registry, entry.Image)}
+	upstream := entry.Image
+	if sourceRegistry != "" {
+		upstream = strings.TrimSuffix(sourceRegistry, "/") + "/" + entry.Image
+	}
+
+	var errs []string
+	upstreamDigest, err := c.Digest(ctx, upstream, entry.OS)
+	if err != nil {
+		errs = append(errs, err.Error())
+		verification.ErrorClass = ClassifyError(err)
+	}
+	verification.UpstreamDigest = upstreamDigest
+	mirrorDigest, err := c.Digest(ctx, verification.Mirror, entry.OS)
+	switch {
+	case isManifestUnknown(err):
+		verification.Status = MirrorMissing
+	case err != nil:
+		errs = append(errs, err.Error())
+		if verification.ErrorClass == "" {
+			verification.ErrorClass = ClassifyError(err)
+		}
+	}
+	verification.MirrorDigest = mirrorDigest
+
+	switch {
+	case len(errs) > 0:
+		verification.Status = MirrorUnknown
+		verification.Error = strings.Join(errs, "; ")
+	case verification.Status == MirrorMissing:
+	case upstreamDigest == mirrorDigest:
+		verification.Status = MirrorVerified
+	default:
+		verification.Status = MirrorDrifted
+	}
+	return verification
+}
+
+// isManifestUnknown returns whether err comes from a registry not holding the requested manifest, i.e. from a 404
+// response.
+func isManifestUnknown(err error) bool {
+	if err == nil {
+		return false
+	}
+	var codeErr errcode.Error
+	if errors.As(err, &codeErr) && (codeErr.Code == v2.ErrorCodeManifestUnknown || codeErr.Code == v2.ErrorCodeNameUnknown) {
+		return true
+	}
+	// HEAD responses have no body to read the error code from, containers/image only reports their status code
+	return strings.Contains(err.Error(), "StatusCode: 404")
+}
@@ -0,0 +1,61 @@
+package image
+
+import (
+	"context"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestRegistryClientVerifyMirror(t *testing.T) {
+	assert := assertlib.New(t)
+
+	upstream := newFakeRegistry(t)
+	shellDigest := upstream.AddImage("rancher/shell", "v0.1.22", []byte("shell"))
+	agentDigest := upstream.AddImage("rancher/rancher-agent", "v2.8.0", []byte("agent"))
+	upstream.AddImage("rancher/fleet", "v0.9.0", []byte("fleet"))
+	mirror := newFakeRegistry(t)
+	mirror.AddImage("rancher/shell", "v0.1.22", []byte("shell"))
+	mirroredAgentDigest := mirror.AddImage("rancher/rancher-agent", "v2.8.0", []byte("corrupted"))
+	mirror.AddImage("rancher/kubectl", "v1.28.0", []byte("kubectl"))
+
+	report, err := upstream.client().VerifyMirror(context.Background(), mirror.Host(), upstream.Host(), ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux},
+		{Image: "rancher/shell:v0.1.22", OS: Windows},
+		{Image: "rancher/rancher-agent:v2.8.0", OS: Linux},
+		{Image: "rancher/fleet:v0.9.0", OS: Linux},
+		{Image: "rancher/kubectl:v1.28.0", OS: Linux},
+	})
+	assert.NoError(err)
+	assert.Equal(mirror.Host(), report.Registry)
+	if assert.Len(report.Images, 4) {
+		assert.Equal("rancher/fleet:v0.9.0", report.Images[0].Image)
+		assert.Equal(MirrorMissing, report.Images[0].Status)
+		assert.Empty(report.Images[0].Error)
+
+		assert.Equal("rancher/kubectl:v1.28.0", report.Images[1].Image)
+		assert.Equal(MirrorUnknown, report.Images[1].Status)
+		assert.NotEmpty(report.Images[1].Error)
+
+		assert.Equal(MirrorVerification{
+			Image:          "rancher/rancher-agent:v2.8.0",
+			Mirror:         mirror.Host() + "/rancher/rancher-agent:v2.8.0",
+			UpstreamDigest: agentDigest,
+			MirrorDigest:   mirroredAgentDigest,
+			Status:         MirrorDrifted,
+		}, report.Images[2])
+
+		assert.Equal(MirrorVerification{
+			Image:          "rancher/shell:v0.1.22",
+			Mirror:         mirror.Host() + "/rancher/shell:v0.1.22",
+			UpstreamDigest: shellDigest,
+			MirrorDigest:   shellDigest,
+			Status:         MirrorVerified,
+		}, report.Images[3])
+	}
+	assert.Equal(1, report.Count(MirrorDrifted))
+	assert.Equal(1, report.Count(MirrorVerified))
+
+	_, err = upstream.client().VerifyMirror(context.Background(), "", "", nil)
+	assert.Error(err)
+}
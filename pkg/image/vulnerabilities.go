@@ -0,0 +1,311 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// trivyCommand is the Trivy CLI scanning the images.
+var trivyCommand = "trivy"
+
+// Severity is the severity of a vulnerability, as rated by Trivy.
+type Severity string
+
+const (
+	SeverityUnknown  Severity = "UNKNOWN"
+	SeverityLow      Severity = "LOW"
+	SeverityMedium   Severity = "MEDIUM"
+	SeverityHigh     Severity = "HIGH"
+	SeverityCritical Severity = "CRITICAL"
+)
+
+// Severities are the severities of vulnerabilities, from the least to the most severe.
+var Severities = []Severity{SeverityUnknown, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// ParseSeverity parses a severity, case insensitively.
+func ParseSeverity(value string) (Severity, error) {
+	for _, severity := range Severities {
+		if strings.EqualFold(string(severity), value) {
+			return severity, nil
+		}
+	}
+	return "", errors.Errorf("invalid severity %q, must be one of %v", value, Severities)
+}
+
+// Vulnerability is a vulnerability of a package of an image.
+type Vulnerability struct {
+	// ID is the identifier of the vulnerability, e.g. CVE-2023-44487.
+	ID               string   `json:"id"`
+	Package          string   `json:"package"`
+	InstalledVersion string   `json:"installedVersion,omitempty"`
+	FixedVersion     string   `json:"fixedVersion,omitempty"`
+	Severity         Severity `json:"severity"`
+	Title            string   `json:"title,omitempty"`
+}
+
+// SeverityCounts are numbers of vulnerabilities, keyed by severity.
+type SeverityCounts map[Severity]int
+
+// String returns the counts in the order of Severities, from the most severe, e.g. CRITICAL=1, HIGH=3.
+func (c SeverityCounts) String() string {
+	var counts []string
+	for i := len(Severities) - 1; i >= 0; i-- {
+		if count := c[Severities[i]]; count > 0 {
+			counts = append(counts, string(Severities[i])+"="+strconv.Itoa(count))
+		}
+	}
+	if len(counts) == 0 {
+		return "none"
+	}
+	return strings.Join(counts, ", ")
+}
+
+// ImageVulnerabilities are the vulnerabilities found scanning an image.
+type ImageVulnerabilities struct {
+	Image string `json:"image"`
+	OS    OSType `json:"os"`
+	// Sources are the sources of the image in the image list, see ImageEntry.Sources.
+	Sources []string `json:"sources,omitempty"`
+	// Vulnerabilities are the vulnerabilities of the image, sorted by severity, from the most severe, and by ID.
+	Vulnerabilities []Vulnerability `json:"vulnerabilities,omitempty"`
+	// Counts are the numbers of vulnerabilities of the image by severity.
+	Counts SeverityCounts `json:"counts,omitempty"`
+	// Error is why the image could not be scanned.
+	Error string `json:"error,omitempty"`
+}
+
+// SourceVulnerabilities summarize the vulnerabilities of the images of a source of the image list, e.g. of a chart.
+type SourceVulnerabilities struct {
+	Source string `json:"source"`
+	// Images is the number of scanned images of the source.
+	Images int `json:"images"`
+	// Counts are the numbers of distinct vulnerabilities of the images of the source by severity.
+	Counts SeverityCounts `json:"counts"`
+}
+
+// VulnerabilityReport is the vulnerabilities of the images of an image list.
+type VulnerabilityReport struct {
+	// ScannedAt is when the images were scanned.
+	ScannedAt time.Time `json:"scannedAt"`
+	// Images are the vulnerabilities of each image, sorted by image and OS.
+	Images []ImageVulnerabilities `json:"images"`
+	// Sources are the summaries of the vulnerabilities of each source of the images, sorted by source.
+	Sources []SourceVulnerabilities `json:"sources"`
+}
+
+// VulnerabilityThresholds are the maximum numbers of vulnerabilities of each severity an image may have, e.g. no
+// critical vulnerability. The severities without threshold are not limited.
+type VulnerabilityThresholds map[Severity]int
+
+// ParseVulnerabilityThresholds parses thresholds in the SEVERITY=MAX format, e.g. CRITICAL=0, or SEVERITY alone for
+// no vulnerability of the severity.
+func ParseVulnerabilityThresholds(values []string) (VulnerabilityThresholds, error) {
+	thresholds := make(VulnerabilityThresholds, len(values))
+	for _, value := range values {
+		name, max, hasMax := strings.Cut(value, "=")
+		severity, err := ParseSeverity(name)
+		if err != nil {
+			return nil, err
+		}
+		thresholds[severity] = 0
+		if hasMax {
+			if thresholds[severity], err = strconv.Atoi(max); err != nil || thresholds[severity] < 0 {
+				return nil, errors.Errorf("invalid vulnerability threshold %q, must be SEVERITY=MAX", value)
+			}
+		}
+	}
+	return thresholds, nil
+}
+
+// Violations returns the images of the report whose vulnerabilities exceed the thresholds, and the images that could
+// not be scanned since they cannot be shown to be under them.
+func (r VulnerabilityReport) Violations(thresholds VulnerabilityThresholds) []ImageVulnerabilities {
+	var violations []ImageVulnerabilities
+	for _, image := range r.Images {
+		exceeded := image.Error != ""
+		for severity, max := range thresholds {
+			if image.Counts[severity] > max {
+				exceeded = true
+			}
+		}
+		if exceeded {
+			violations = append(violations, image)
+		}
+	}
+	return violations
+}
+
+// VulnerabilityScanner scans images for vulnerabilities with Trivy, which pulls them from their registries with the
+// credentials of the docker config of the user.
+type VulnerabilityScanner struct {
+	// Server is the URL of a Trivy server to scan the images with in client mode, sparing the download of the
+	// vulnerability database by every scan. The images are scanned locally if not set.
+	Server string
+	// IgnoreUnfixed skips the vulnerabilities without a fixed version.
+	IgnoreUnfixed bool
+	// Arch is the architecture whose images are scanned in manifest lists.
+	Arch Arch
+	// Workers is the number of images scanned concurrently, defaultLookupWorkers if not set.
+	Workers int
+}
+
+// Scan scans each image of list, and summarizes the vulnerabilities of the images by source. Images exported for
+// several OS types are scanned for each of them, their platforms being distinct images.
+func (s VulnerabilityScanner) Scan(ctx context.Context, list ImageList) VulnerabilityReport {
+	report := VulnerabilityReport{ScannedAt: time.Now().UTC()}
+	var mu sync.Mutex
+	RegistryClient{Workers: s.Workers}.forEach(list, func(entry *ImageEntry) {
+		image := ImageVulnerabilities{Image: entry.Image, OS: entry.OS, Sources: entry.Sources}
+		vulnerabilities, err := s.scanImage(ctx, *entry)
+		if err != nil {
+			image.Error = err.Error()
+		}
+		image.Vulnerabilities = vulnerabilities
+		image.Counts = countSeverities(vulnerabilities)
+		mu.Lock()
+		defer mu.Unlock()
+		report.Images = append(report.Images, image)
+	})
+	sort.Slice(report.Images, func(i, j int) bool {
+		return lessImageEntry(ImageEntry{Image: report.Images[i].Image, OS: report.Images[i].OS}, ImageEntry{Image: report.Images[j].Image, OS: report.Images[j].OS})
+	})
+	report.Sources = summarizeSources(report.Images)
+	return report
+}
+
+// trivyReport is the part of the JSON report of Trivy listing the vulnerabilities.
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// scanImage returns the vulnerabilities of the image of entry, for its OS and the architecture of the scanner.
+func (s VulnerabilityScanner) scanImage(ctx context.Context, entry ImageEntry) ([]Vulnerability, error) {
+	args := []string{"image", "--quiet", "--format", "json", "--scanners", "vuln", "--platform", entry.OS.String() + "/" + s.Arch.String()}
+	if s.Server != "" {
+		args = append(args, "--server", s.Server)
+	}
+	if s.IgnoreUnfixed {
+		args = append(args, "--ignore-unfixed")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, trivyCommand, append(args, entry.Image)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(errors.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())), "failed to scan image %s", entry.Image)
+	}
+	var trivy trivyReport
+	if err := json.Unmarshal(stdout.Bytes(), &trivy); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse scan report of image %s", entry.Image)
+	}
+
+	// The same vulnerability may be reported for several targets of the image, e.g. several binaries
+	seen := make(map[[2]string]bool)
+	var vulnerabilities []Vulnerability
+	for _, result := range trivy.Results {
+		for _, v := range result.Vulnerabilities {
+			key := [2]string{v.VulnerabilityID, v.PkgName}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			severity, err := ParseSeverity(v.Severity)
+			if err != nil {
+				severity = SeverityUnknown
+			}
+			vulnerabilities = append(vulnerabilities, Vulnerability{
+				ID:               v.VulnerabilityID,
+				Package:          v.PkgName,
+				InstalledVersion: v.InstalledVersion,
+				FixedVersion:     v.FixedVersion,
+				Severity:         severity,
+				Title:            v.Title,
+			})
+		}
+	}
+	sort.Slice(vulnerabilities, func(i, j int) bool {
+		if ri, rj := severityRank(vulnerabilities[i].Severity), severityRank(vulnerabilities[j].Severity); ri != rj {
+			return ri > rj
+		}
+		if vulnerabilities[i].ID != vulnerabilities[j].ID {
+			return vulnerabilities[i].ID < vulnerabilities[j].ID
+		}
+		return vulnerabilities[i].Package < vulnerabilities[j].Package
+	})
+	return vulnerabilities, nil
+}
+
+// severityRank returns the rank of severity in Severities.
+func severityRank(severity Severity) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return 0
+}
+
+// countSeverities returns the numbers of vulnerabilities by severity.
+func countSeverities(vulnerabilities []Vulnerability) SeverityCounts {
+	if len(vulnerabilities) == 0 {
+		return nil
+	}
+	counts := make(SeverityCounts)
+	for _, v := range vulnerabilities {
+		counts[v.Severity]++
+	}
+	return counts
+}
+
+// summarizeSources returns the summaries of the vulnerabilities of the scanned images of each source, counting the
+// vulnerabilities shared by several images of a source once.
+func summarizeSources(images []ImageVulnerabilities) []SourceVulnerabilities {
+	type sourceVulnerabilities struct {
+		images          int
+		vulnerabilities map[[2]string]Severity
+	}
+	sources := make(map[string]*sourceVulnerabilities)
+	for _, image := range images {
+		if image.Error != "" {
+			continue
+		}
+		for _, source := range image.Sources {
+			summary, ok := sources[source]
+			if !ok {
+				summary = &sourceVulnerabilities{vulnerabilities: make(map[[2]string]Severity)}
+				sources[source] = summary
+			}
+			summary.images++
+			for _, v := range image.Vulnerabilities {
+				summary.vulnerabilities[[2]string{v.ID, v.Package}] = v.Severity
+			}
+		}
+	}
+	summaries := make([]SourceVulnerabilities, 0, len(sources))
+	for _, source := range sortedKeys(sources) {
+		summary := SourceVulnerabilities{Source: source, Images: sources[source].images, Counts: make(SeverityCounts)}
+		for _, severity := range sources[source].vulnerabilities {
+			summary.Counts[severity]++
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
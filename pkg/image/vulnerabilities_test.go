@@ -0,0 +1,95 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+const trivyShellReport = `{"Results":[
+{"Target":"rancher/shell:v0.1.22 (alpine 3.18.4)","Vulnerabilities":[
+ {"VulnerabilityID":"CVE-2023-5363","PkgName":"libcrypto3","InstalledVersion":"3.1.3-r0","FixedVersion":"3.1.4-r0","Severity":"HIGH"},
+ {"VulnerabilityID":"CVE-2023-42363","PkgName":"busybox","InstalledVersion":"1.36.1-r2","Severity":"MEDIUM"}]},
+{"Target":"usr/bin/kubectl","Vulnerabilities":[
+ {"VulnerabilityID":"CVE-2023-44487","PkgName":"golang.org/x/net","InstalledVersion":"v0.13.0","FixedVersion":"0.17.0","Severity":"CRITICAL","Title":"HTTP/2 rapid reset"},
+ {"VulnerabilityID":"CVE-2023-44487","PkgName":"golang.org/x/net","InstalledVersion":"v0.13.0","FixedVersion":"0.17.0","Severity":"CRITICAL","Title":"HTTP/2 rapid reset"}]}]}`
+
+const trivyKubectlReport = `{"Results":[{"Target":"usr/bin/kubectl","Vulnerabilities":[
+ {"VulnerabilityID":"CVE-2023-44487","PkgName":"golang.org/x/net","InstalledVersion":"v0.13.0","FixedVersion":"0.17.0","Severity":"CRITICAL","Title":"HTTP/2 rapid reset"}]}]}`
+
+func TestVulnerabilityScannerScan(t *testing.T) {
+	assert := assertlib.New(t)
+
+	dir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(dir, "shell.json"), []byte(trivyShellReport), 0644))
+	assert.NoError(os.WriteFile(filepath.Join(dir, "kubectl.json"), []byte(trivyKubectlReport), 0644))
+	args := filepath.Join(dir, "args")
+	trivy := filepath.Join(dir, "trivy")
+	script := "#!/bin/sh\necho \"$@\" >> " + args + "\nfor arg; do image=$arg; done\n" +
+		"case $image in rancher/shell:*) cat " + filepath.Join(dir, "shell.json") + ";; rancher/kubectl:*) cat " +
+		filepath.Join(dir, "kubectl.json") + ";; *) echo MANIFEST_UNKNOWN >&2; exit 1;; esac\n"
+	assert.NoError(os.WriteFile(trivy, []byte(script), 0755))
+	defer func(command string) { trivyCommand = command }(trivyCommand)
+	trivyCommand = trivy
+
+	scanner := VulnerabilityScanner{Server: "http://trivy:4954", IgnoreUnfixed: true, Arch: ARM64, Workers: 1}
+	report := scanner.Scan(context.Background(), ImageList{
+		{Image: "rancher/shell:v0.1.22", OS: Linux, Sources: []string{"rancher-monitoring:102.0.0", "system"}},
+		{Image: "rancher/kubectl:v1.28.0", OS: Linux, Sources: []string{"system"}},
+		{Image: "rancher/missing:v1.0.0", OS: Linux, Sources: []string{"system"}},
+	})
+	written, err := os.ReadFile(args)
+	assert.NoError(err)
+	assert.Contains(string(written), "image --quiet --format json --scanners vuln --platform linux/arm64 --server http://trivy:4954 --ignore-unfixed rancher/shell:v0.1.22\n")
+
+	if !assert.Len(report.Images, 3) {
+		return
+	}
+	assert.Equal(ImageVulnerabilities{
+		Image:   "rancher/kubectl:v1.28.0",
+		OS:      Linux,
+		Sources: []string{"system"},
+		Vulnerabilities: []Vulnerability{
+			{ID: "CVE-2023-44487", Package: "golang.org/x/net", InstalledVersion: "v0.13.0", FixedVersion: "0.17.0", Severity: SeverityCritical, Title: "HTTP/2 rapid reset"},
+		},
+		Counts: SeverityCounts{SeverityCritical: 1},
+	}, report.Images[0])
+	assert.Equal("rancher/missing:v1.0.0", report.Images[1].Image)
+	assert.Contains(report.Images[1].Error, "MANIFEST_UNKNOWN")
+	// The vulnerability reported for several targets of the image is counted once
+	assert.Equal(SeverityCounts{SeverityCritical: 1, SeverityHigh: 1, SeverityMedium: 1}, report.Images[2].Counts)
+	assert.Equal("CRITICAL=1, HIGH=1, MEDIUM=1", report.Images[2].Counts.String())
+	assert.Equal([]string{"CVE-2023-44487", "CVE-2023-5363", "CVE-2023-42363"}, []string{
+		report.Images[2].Vulnerabilities[0].ID, report.Images[2].Vulnerabilities[1].ID, report.Images[2].Vulnerabilities[2].ID,
+	})
+
+	// The vulnerabilities shared by several images of a source are counted once
+	assert.Equal([]SourceVulnerabilities{
+		{Source: "rancher-monitoring:102.0.0", Images: 1, Counts: SeverityCounts{SeverityCritical: 1, SeverityHigh: 1, SeverityMedium: 1}},
+		{Source: "system", Images: 2, Counts: SeverityCounts{SeverityCritical: 1, SeverityHigh: 1, SeverityMedium: 1}},
+	}, report.Sources)
+
+	thresholds, err := ParseVulnerabilityThresholds([]string{"critical=1", "HIGH"})
+	assert.NoError(err)
+	assert.Equal(VulnerabilityThresholds{SeverityCritical: 1, SeverityHigh: 0}, thresholds)
+	violations := report.Violations(thresholds)
+	if assert.Len(violations, 2) {
+		assert.Equal("rancher/missing:v1.0.0", violations[0].Image)
+		assert.Equal("rancher/shell:v0.1.22", violations[1].Image)
+	}
+}
+
+func TestParseVulnerabilityThresholds(t *testing.T) {
+	assert := assertlib.New(t)
+
+	_, err := ParseVulnerabilityThresholds([]string{"SEVERE=0"})
+	assert.EqualError(err, `invalid severity "SEVERE", must be one of [UNKNOWN LOW MEDIUM HIGH CRITICAL]`)
+	_, err = ParseVulnerabilityThresholds([]string{"HIGH=-1"})
+	assert.EqualError(err, `invalid vulnerability threshold "HIGH=-1", must be SEVERITY=MAX`)
+	thresholds, err := ParseVulnerabilityThresholds(nil)
+	assert.NoError(err)
+	assert.Empty(thresholds)
+}
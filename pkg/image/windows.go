@@ -0,0 +1,146 @@
+package image
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containers/image/v5/types"
+	"github.com/pkg/errors"
+)
+
+// WindowsBuild is a Windows Server build Windows containers run on. Windows containers only run on hosts of the build
+// they are built for, which manifests record in the os.version of their platform.
+type WindowsBuild struct {
+	// Name is the name of the build, e.g. ltsc2022.
+	Name string `json:"name"`
+	// Version is the version prefixing the os.version of the images built for the build, e.g. 10.0.20348.
+	Version string `json:"version"`
+}
+
+func (b WindowsBuild) String() string {
+	return b.Name
+}
+
+// matches returns whether osVersion, e.g. 10.0.20348.2031, is a version of the build.
+func (b WindowsBuild) matches(osVersion string) bool {
+	return osVersion == b.Version || strings.HasPrefix(osVersion, b.Version+".")
+}
+
+// windowsBuilds are the known Windows Server builds, by name.
+var windowsBuilds = map[string]WindowsBuild{
+	"1809":     {Name: "1809", Version: "10.0.17763"},
+	"ltsc2019": {Name: "1809", Version: "10.0.17763"},
+	"1903":     {Name: "1903", Version: "10.0.18362"},
+	"1909":     {Name: "1909", Version: "10.0.18363"},
+	"2004":     {Name: "2004", Version: "10.0.19041"},
+	"20H2":     {Name: "20H2", Version: "10.0.19042"},
+	"ltsc2022": {Name: "ltsc2022", Version: "10.0.20348"},
+	"ltsc2025": {Name: "ltsc2025", Version: "10.0.26100"},
+}
+
+// DefaultWindowsBuilds are the Windows Server builds Rancher supports Windows nodes on.
+var DefaultWindowsBuilds = []WindowsBuild{windowsBuilds["1809"], windowsBuilds["ltsc2022"]}
+
+// ParseWindowsBuild parses the name of a Windows Server build, e.g. ltsc2022 or 1809, or its version, e.g. 10.0.20348.
+func ParseWindowsBuild(value string) (WindowsBuild, error) {
+	if build, ok := windowsBuilds[value]; ok {
+		return build, nil
+	}
+	for _, build := range windowsBuilds {
+		if build.Version == value {
+			return build, nil
+		}
+	}
+	if parts := strings.Split(value, "."); len(parts) == 3 && parts[0] == "10" {
+		return WindowsBuild{Name: value, Version: value}, nil
+	}
+	return WindowsBuild{}, errors.Errorf("unknown Windows build %q, must be a build name like ltsc2022 or a version like 10.0.20348", value)
+}
+
+// WindowsImageBuilds are the Windows Server builds a Windows image supports.
+type WindowsImageBuilds struct {
+	// Image is the image reference.
+	Image string `json:"image"`
+	// OSVersions are the os.version of the Windows manifests of the image, sorted.
+	OSVersions []string `json:"osVersions"`
+	// Supported are the requested builds the image supports.
+	Supported []WindowsBuild `json:"supported,omitempty"`
+	// Missing are the requested builds the image does not support.
+	Missing []WindowsBuild `json:"missing,omitempty"`
+}
+
+// WindowsOSVersions returns the os.version of the Windows manifests of image, i.e. the versions of the Windows Server
+// builds it runs on, sorted.
+func (c RegistryClient) WindowsOSVersions(ctx context.Context, image string) ([]string, error) {
+	var versions []string
+	err := c.withImage(ctx, image, Windows, func(ref types.ImageReference, sys *types.SystemContext) error {
+		platforms, err := imagePlatformsOf(ctx, image, ref, sys)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, platform := range platforms {
+			if platform.OS == Windows.String() && platform.OSVersion != "" && !seen[platform.OSVersion] {
+				seen[platform.OSVersion] = true
+				versions = append(versions, platform.OSVersion)
+			}
+		}
+		return nil
+	})
+	sort.Strings(versions)
+	return versions, err
+}
+
+// WindowsBuilds returns the requested builds each Windows image of list supports, sorted by image. The images
+// exported for Linux are ignored. The images whose manifests cannot be read are returned in ImageErrors along with
+// the builds of the other images.
+func (c RegistryClient) WindowsBuilds(ctx context.Context, list ImageList, builds []WindowsBuild) ([]WindowsImageBuilds, error) {
+	var windowsList ImageList
+	for _, entry := range list {
+		if entry.OS == Windows {
+			windowsList = append(windowsList, entry)
+		}
+	}
+
+	var mu sync.Mutex
+	var images []WindowsImageBuilds
+	var errs ImageErrors
+	c.forEach(windowsList, func(entry *ImageEntry) {
+		versions, err := c.WindowsOSVersions(ctx, entry.Image)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, &ImageError{Image: entry.Image, OS: entry.OS, Err: err})
+			return
+		}
+		image := WindowsImageBuilds{Image: entry.Image, OSVersions: versions}
+		for _, build := range builds {
+			supported := false
+			for _, version := range versions {
+				if build.matches(version) {
+					supported = true
+					break
+				}
+			}
+			if supported {
+				image.Supported = append(image.Supported, build)
+			} else {
+				image.Missing = append(image.Missing, build)
+			}
+		}
+		images = append(images, image)
+	})
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Image < images[j].Image
+	})
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool {
+			return errs[i].Image < errs[j].Image
+		})
+		return images, errs
+	}
+	return images, nil
+}
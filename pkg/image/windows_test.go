@@ -0,0 +1,157 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestParseWindowsBuild(t *testing.T) {
+	assert := assertlib.New(t)
+
+	build, err := ParseWindowsBuild("ltsc2022")
+	assert.NoError(err)
+	assert.Equal(WindowsBuild{Name: "ltsc2022", Version: "10.0.20348"}, build)
+	build, err = ParseWindowsBuild("ltsc2019")
+	assert.NoError(err)
+	assert.Equal("1809", build.String())
+	build, err = ParseWindowsBuild("10.0.17763")
+	assert.NoError(err)
+	assert.Equal("1809", build.Name)
+	build, err = ParseWindowsBuild("10.0.22621")
+	assert.NoError(err)
+	assert.Equal(WindowsBuild{Name: "10.0.22621", Version: "10.0.22621"}, build)
+
+	for _, value := range []string{"", "2016", "10.0", "6.3.9600"} {
+		_, err := ParseWindowsBuild(value)
+		assert.Error(err, value)
+	}
+}
+
+func TestWindowsBuildMatches(t *testing.T) {
+	assert := assertlib.New(t)
+
+	build := WindowsBuild{Name: "1809", Version: "10.0.17763"}
+	assert.True(build.matches("10.0.17763"))
+	assert.True(build.matches("10.0.17763.5122"))
+	assert.False(build.matches("10.0.177630"))
+	assert.False(build.matches("10.0.20348.2031"))
+}
+
+// addWindowsImage serves a Windows image as repo:tag with a manifest for each of osVersions, and returns the digest
+// of its manifest list.
+func (r *fakeRegistry) addWindowsImage(repo, tag string, osVersions ...string) string {
+	var manifests []string
+	for _, osVersion := range osVersions {
+		config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"windows","os.version":"%s"}`, osVersion))
+		r.AddBlob(config)
+		body := []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s",`+
+			`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":%d,"digest":"%s"},"layers":[]}`,
+			schema2MediaType, len(config), digest.FromBytes(config)))
+		instanceDigest := r.AddManifest(repo, tag+"-"+osVersion, schema2MediaType, body)
+		manifests = append(manifests, fmt.Sprintf(
+			`{"mediaType":"%s","size":%d,"digest":"%s","platform":{"architecture":"amd64","os":"windows","os.version":"%s"}}`,
+			schema2MediaType, len(body), instanceDigest, osVersion))
+	}
+	listMediaType := "application/vnd.docker.distribution.manifest.list.v2+json"
+	return r.AddManifest(repo, tag, listMediaType, []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","manifests":[%s]}`,
+		listMediaType, strings.Join(manifests, ","))))
+}
+
+func TestRegistryClientWindowsBuilds(t *testing.T) {
+	assert := assertlib.New(t)
+
+	registry := newFakeRegistry(t)
+	registry.addWindowsImage("rancher/wins", "v0.4.11", "10.0.17763.5122", "10.0.20348.2113")
+	registry.addWindowsImage("rancher/windows-agent", "v2.8.0", "10.0.17763.5122")
+	registry.AddImage("rancher/shell", "v0.1.22", []byte("layer"))
+
+	images, err := registry.client().WindowsBuilds(context.Background(), ImageList{
+		{Image: registry.Host() + "/rancher/wins:v0.4.11", OS: Windows},
+		{Image: registry.Host() + "/rancher/windows-agent:v2.8.0", OS: Windows},
+		{Image: registry.Host() + "/rancher/shell:v0.1.22", OS: Linux},
+		{Image: registry.Host() + "/rancher/missing:v1", OS: Windows},
+	}, DefaultWindowsBuilds)
+
+	var imageErrs ImageErrors
+	if assert.ErrorAs(err, &imageErrs) && assert.Len(imageErrs, 1) {
+		assert.Equal(registry.Host()+"/rancher/missing:v1", imageErrs[0].Image)
+	}
+	ltsc2022 := WindowsBuild{Name: "ltsc2022", Version: "10.0.20348"}
+	build1809 := WindowsBuild{Name: "1809", Version: "10.0.17763"}
+	assert.Equal([]WindowsImageBuilds{
+		{
+			Image:      registry.Host() + "/rancher/windows-agent:v2.8.0",
+			OSVersions: []string{"10.0.17763.5122"},
+			Supported:  []WindowsBuild{build1809},
+			Missing:    []WindowsBuild{ltsc2022},
+		},
+		{
+			Image:      registry.Host() + "/rancher/wins:v0.4.11",
+			OSVersions: []string{"10.0.17763.5122", "10.0.20348.2113"},
+			Supported:  []WindowsBuild{build1809, ltsc2022},
+		},
+	}, images)
+}
+
+func TestWindowsBuildsForRancherVersion(t *testing.T) {
+	assert := assertlib.New(t)
+
+	buildNames := func(builds []WindowsBuild) []string {
+		var names []string
+		for _, build := range builds {
+			names = append(names, build.Name)
+		}
+		return names
+	}
+	assert.Equal([]string{"1809", "1909", "2004", "20H2"}, buildNames(WindowsBuildsForRancherVersion("2.5.16")))
+	assert.Equal([]string{"1809", "2004", "20H2", "ltsc2022"}, buildNames(WindowsBuildsForRancherVersion("v2.6.9")))
+	assert.Equal([]string{"1809", "ltsc2022"}, buildNames(WindowsBuildsForRancherVersion("2.8.0-rc1")))
+	assert.Equal(DefaultWindowsBuilds, WindowsBuildsForRancherVersion("master-head"))
+}
+
+func TestParseWindowsTagVariants(t *testing.T) {
+	assert := assertlib.New(t)
+
+	variants, err := ParseWindowsTagVariants([]string{"rancher/mirrored-pause={tag}-windows-{build}-amd64"})
+	assert.NoError(err)
+	assert.Equal(WindowsTagVariants{"rancher/mirrored-pause": "{tag}-windows-{build}-amd64"}, variants)
+
+	for _, value := range []string{"rancher/mirrored-pause", "={tag}-{build}", "rancher/mirrored-pause={tag}"} {
+		_, err := ParseWindowsTagVariants([]string{value})
+		assert.Error(err, value)
+	}
+}
+
+func TestImageListWindowsTagVariants(t *testing.T) {
+	assert := assertlib.New(t)
+
+	variants := WindowsTagVariants{"rancher/mirrored-pause": "{tag}-windows-{build}-amd64", "rancher/kubelet-pause": "{tag}-{version}"}
+	list := ImageList{
+		{Image: "rancher/mirrored-pause:3.6", OS: Linux},
+		{Image: "rancher/kubelet-pause:v0.1.6", OS: Windows},
+		{Image: "rancher/mirrored-pause:3.6", OS: Windows, Sources: []string{"system"}},
+		{Image: "rancher/wins:v0.4.12", OS: Windows},
+	}
+	builds := []WindowsBuild{windowsBuilds["1809"], windowsBuilds["ltsc2022"]}
+
+	ltsc2022 := list.ForWindowsBuild(builds[1], variants)
+	assert.Equal([]string{"rancher/kubelet-pause:v0.1.6-10.0.20348", "rancher/mirrored-pause:3.6-windows-ltsc2022-amd64", "rancher/wins:v0.4.12"}, ltsc2022.Images())
+	assert.Equal([]string{"system"}, ltsc2022[1].Sources)
+
+	expanded := list.ExpandWindowsTagVariants(builds, variants)
+	assert.Equal([]string{
+		"rancher/mirrored-pause:3.6",
+		"rancher/kubelet-pause:v0.1.6-10.0.17763",
+		"rancher/kubelet-pause:v0.1.6-10.0.20348",
+		"rancher/mirrored-pause:3.6-windows-1809-amd64",
+		"rancher/mirrored-pause:3.6-windows-ltsc2022-amd64",
+		"rancher/wins:v0.4.12",
+	}, expanded.Images())
+	pinned := ImageList{{Image: "rancher/mirrored-pause:3.6@sha256:" + strings.Repeat("0", 64), OS: Windows}}
+	assert.Equal(pinned, pinned.ExpandWindowsTagVariants(builds, variants))
+}
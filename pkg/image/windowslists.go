@@ -0,0 +1,122 @@
+package image
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// rancherWindowsBuilds are the Windows Server builds Rancher supports Windows nodes on, by constraint on the Rancher
+// version.
+var rancherWindowsBuilds = []struct {
+	constraint string
+	builds     []string
+}{
+	{constraint: "< 2.6.0", builds: []string{"1809", "1909", "2004", "20H2"}},
+	{constraint: ">= 2.6.0, < 2.7.0", builds: []string{"1809", "2004", "20H2", "ltsc2022"}},
+	{constraint: ">= 2.7.0", builds: []string{"1809", "ltsc2022"}},
+}
+
+// WindowsBuildsForRancherVersion returns the Windows Server builds rancherVersion supports Windows nodes on, or
+// DefaultWindowsBuilds if rancherVersion is not a semantic version.
+func WindowsBuildsForRancherVersion(rancherVersion string) []WindowsBuild {
+	for _, supported := range rancherWindowsBuilds {
+		if ok, err := compareRancherVersionToConstraint(context.Background(), rancherVersion, supported.constraint); err != nil || !ok {
+			continue
+		}
+		builds := make([]WindowsBuild, 0, len(supported.builds))
+		for _, name := range supported.builds {
+			builds = append(builds, windowsBuilds[name])
+		}
+		return builds
+	}
+	return DefaultWindowsBuilds
+}
+
+// WindowsTagVariants are the templates of the tags of the per-build variants of Windows images, keyed by repository,
+// for the images that are published with a tag per Windows Server build instead of a manifest list covering every
+// build, e.g. rancher/mirrored-pause={tag}-windows-{build}-amd64. The {tag} placeholder of the templates is replaced
+// by the tag of the image, {build} by the name of the build, e.g. ltsc2022, and {version} by its version, e.g.
+// 10.0.20348.
+type WindowsTagVariants map[string]string
+
+// ParseWindowsTagVariants parses the tag templates of per-build variants of Windows images given as REPOSITORY=TEMPLATE,
+// e.g. rancher/mirrored-pause={tag}-windows-{build}-amd64.
+func ParseWindowsTagVariants(values []string) (WindowsTagVariants, error) {
+	variants := make(WindowsTagVariants, len(values))
+	for _, value := range values {
+		repository, template, ok := strings.Cut(value, "=")
+		if !ok || repository == "" || template == "" {
+			return nil, errors.Errorf("invalid Windows tag variant %q, expected REPOSITORY=TEMPLATE", value)
+		}
+		if !strings.Contains(template, "{build}") && !strings.Contains(template, "{version}") {
+			return nil, errors.Errorf("invalid Windows tag variant %q, the template must contain {build} or {version}", value)
+		}
+		variants[repository] = template
+	}
+	return variants, nil
+}
+
+// variant returns the variant of image for build, or false if image has no per-build variants. Images pinned to a
+// digest have none.
+func (v WindowsTagVariants) variant(image string, build WindowsBuild) (string, bool) {
+	if strings.Contains(image, "@") {
+		return "", false
+	}
+	repository, tag := splitImageTag(image)
+	template, ok := v[repository]
+	if !ok || tag == "" {
+		return "", false
+	}
+	tag = strings.NewReplacer("{tag}", tag, "{build}", build.Name, "{version}", build.Version).Replace(template)
+	return repository + ":" + tag, true
+}
+
+// ForWindowsBuild returns the Windows entries of the list running on build, with the images that have per-build
+// variants replaced by their variant for build. The result is sorted by image.
+func (l ImageList) ForWindowsBuild(build WindowsBuild, variants WindowsTagVariants) ImageList {
+	var list ImageList
+	for _, entry := range l.ForOS(Windows) {
+		if image, ok := variants.variant(entry.Image, build); ok {
+			entry.Image = image
+		}
+		list = append(list, entry)
+	}
+	sortImageList(list)
+	return list
+}
+
+// ExpandWindowsTagVariants returns the list with the Windows images that have per-build variants replaced by their
+// variants for each of builds, so that a single list mirrors the images of every build. The result is sorted by OS
+// and then by image.
+func (l ImageList) ExpandWindowsTagVariants(builds []WindowsBuild, variants WindowsTagVariants) ImageList {
+	list := make(ImageList, 0, len(l))
+	seen := make(map[string]bool)
+	for _, entry := range l {
+		if entry.OS != Windows {
+			list = append(list, entry)
+			continue
+		}
+		expanded := false
+		for _, build := range builds {
+			image, ok := variants.variant(entry.Image, build)
+			if !ok {
+				break
+			}
+			expanded = true
+			if !seen[image] {
+				seen[image] = true
+				variant := entry
+				variant.Image = image
+				list = append(list, variant)
+			}
+		}
+		if !expanded && !seen[entry.Image] {
+			seen[entry.Image] = true
+			list = append(list, entry)
+		}
+	}
+	sortImageList(list)
+	return list
+}
@@ -0,0 +1,33 @@
+package image
+
+import (
+	"io"
+
+	"sigs.k8s.io/yaml"
+)
+
+// WriteImageListYAML writes list to w as an ImageListDocument in YAML along with metadata. Its fields are the same as
+// the ones of WriteImageListJSON, so that each image has explicit image, sources and os fields instead of the
+// space delimited format of rancher-images-sources.txt.
+func WriteImageListYAML(w io.Writer, list ImageList, metadata ExportMetadata) error {
+	if list == nil {
+		list = ImageList{}
+	}
+	out, err := yaml.Marshal(ImageListDocument{Metadata: metadata, Images: list})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// ReadImageListYAML reads an ImageListDocument written by WriteImageListYAML.
+func ReadImageListYAML(r io.Reader) (ImageListDocument, error) {
+	var document ImageListDocument
+	in, err := io.ReadAll(r)
+	if err != nil {
+		return document, err
+	}
+	err = yaml.Unmarshal(in, &document)
+	return document, err
+}
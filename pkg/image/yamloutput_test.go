@@ -0,0 +1,38 @@
+package image
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	assertlib "github.com/stretchr/testify/assert"
+)
+
+func TestWriteImageListYAML(t *testing.T) {
+	assert := assertlib.New(t)
+
+	list := ImageList{
+		{Image: "rancher/fleet:v0.7.0", Sources: []string{"fleet:102.1.0", "system"}, OS: Linux, Charts: []string{"fleet:102.1.0"}},
+		{Image: "rancher/wins:v0.4.12", Sources: []string{"system"}, OS: Windows},
+	}
+	metadata := ExportMetadata{
+		RancherVersions: []string{"v2.8.0"},
+		GeneratedAt:     time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(WriteImageListYAML(&buf, list, metadata))
+	assert.Contains(buf.String(), `- charts:
+  - fleet:102.1.0
+  image: rancher/fleet:v0.7.0
+  optional: false
+  os: linux
+  sources:
+  - fleet:102.1.0
+  - system
+`)
+
+	document, err := ReadImageListYAML(&buf)
+	assert.NoError(err)
+	assert.Equal(ImageListDocument{Metadata: metadata, Images: list}, document)
+}
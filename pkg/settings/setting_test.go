@@ -186,3 +186,18 @@ func TestGetRancherVersion(t *testing.T) {
 		assert.Equal(t, value, result)
 	}
 }
+
+func TestImageSettings(t *testing.T) {
+	var names []string
+	for _, setting := range ImageSettings() {
+		names = append(names, setting.Name)
+	}
+	assert.Equal(t, []string{
+		AgentImage.Name,
+		AuthImage.Name,
+		MachineProvisionImage.Name,
+		ShellImage.Name,
+		SystemAgentUpgradeImage.Name,
+		WinsAgentUpgradeImage.Name,
+	}, names)
+}